@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// AccessLog writes a line per request, apart from the application log, in
+// the Common or Combined Log Format web log tooling reads, or as JSON
+type AccessLog struct {
+	// Path is the file written to; empty writes to stdout
+	Path   string
+	Format string
+	// MaxSize rotates the file to Path.1 once it grows past that many
+	// bytes; 0 leaves rotation to logrotate and SIGUSR1
+	MaxSize int64
+
+	mu   sync.Mutex
+	out  io.Writer
+	file *os.File
+	size int64
+}
+
+// accessLogFromEnv reads QUAYCHECK_ACCESS_LOG, "stdout" or a file path,
+// QUAYCHECK_ACCESS_LOG_FORMAT (combined, common or json) and
+// QUAYCHECK_ACCESS_LOG_MAX_MB
+func accessLogFromEnv() *AccessLog {
+	dest := os.Getenv("QUAYCHECK_ACCESS_LOG")
+	if dest == "" {
+		return nil
+	}
+	a := &AccessLog{Format: os.Getenv("QUAYCHECK_ACCESS_LOG_FORMAT")}
+	switch a.Format {
+	case "":
+		a.Format = "combined"
+	case "combined", "common", "json":
+	default:
+		log.Fatalf("Invalid QUAYCHECK_ACCESS_LOG_FORMAT %q: expected combined, common or json", a.Format)
+	}
+	if dest != "stdout" {
+		a.Path = dest
+	}
+	if mb, err := strconv.Atoi(os.Getenv("QUAYCHECK_ACCESS_LOG_MAX_MB")); err == nil && mb > 0 {
+		a.MaxSize = int64(mb) << 20
+	}
+	if err := a.Reopen(); err != nil {
+		log.Fatalf("Cannot open the access log: %v", err)
+	}
+	return a
+}
+
+// Reopen closes the file and opens Path again, for after logrotate moved
+// it away
+func (a *AccessLog) Reopen() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.reopen()
+}
+
+func (a *AccessLog) reopen() error {
+	if a.Path == "" {
+		a.out = os.Stdout
+		return nil
+	}
+	f, err := os.OpenFile(a.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if a.file != nil {
+		a.file.Close()
+	}
+	a.file, a.out, a.size = f, f, info.Size()
+	return nil
+}
+
+func (a *AccessLog) write(line []byte) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.MaxSize > 0 && a.file != nil && a.size+int64(len(line)) > a.MaxSize {
+		// Closed first, as Windows can't rename an open file
+		a.file.Close()
+		a.file = nil
+		if err := os.Rename(a.Path, a.Path+".1"); err != nil {
+			log.Printf("Cannot rotate the access log: %v", err)
+		}
+		if err := a.reopen(); err != nil {
+			log.Printf("Cannot reopen the access log: %v", err)
+		}
+	}
+	n, _ := a.out.Write(line)
+	a.size += int64(n)
+}
+
+// accessEntry is a request as the access log records it
+type accessEntry struct {
+	Time      time.Time `json:"time"`
+	Remote    string    `json:"remote"`
+	Method    string    `json:"method"`
+	URI       string    `json:"uri"`
+	Proto     string    `json:"proto"`
+	Status    int       `json:"status"`
+	Bytes     int64     `json:"bytes"`
+	Referer   string    `json:"referer,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	Duration  float64   `json:"duration_ms"`
+}
+
+// format renders e as one line. quaycheck can't tell the user before the
+// token has been checked further in, so the CLF user field is always "-".
+func (a *AccessLog) format(e accessEntry) []byte {
+	if a.Format == "json" {
+		line, _ := json.Marshal(e)
+		return append(line, '\n')
+	}
+	size := "-"
+	if e.Bytes > 0 {
+		size = strconv.FormatInt(e.Bytes, 10)
+	}
+	line := fmt.Appendf(nil, "%s - - [%s] %q %d %s", e.Remote, e.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		e.Method+" "+e.URI+" "+e.Proto, e.Status, size)
+	if a.Format == "combined" {
+		line = fmt.Appendf(line, " %q %q", orDash(e.Referer), orDash(e.UserAgent))
+	}
+	return append(line, '\n')
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// accessRecorder captures the status and size of a response on its way out
+type accessRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (r *accessRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *accessRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += int64(n)
+	return n, err
+}
+
+// Flush keeps server-sent events streaming through the recorder
+func (r *accessRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (r *accessRecorder) Unwrap() http.ResponseWriter { return r.ResponseWriter }
+
+// loggedURI is r's request URI with the ?token= of an embedded widget
+// masked, so tokens don't end up in the access log
+func loggedURI(r *http.Request) string {
+	q := r.URL.Query()
+	if !q.Has("token") {
+		return r.RequestURI
+	}
+	q.Set("token", "REDACTED")
+	u := *r.URL
+	u.RawQuery = q.Encode()
+	return u.RequestURI()
+}
+
+// withAccessLog logs every request next serves once it has been answered
+func (s *Server) withAccessLog(next http.Handler) http.Handler {
+	if s.accessLog == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &accessRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		s.accessLog.write(s.accessLog.format(accessEntry{
+			Time:      start,
+			Remote:    s.clientIP(r),
+			Method:    r.Method,
+			URI:       loggedURI(r),
+			Proto:     r.Proto,
+			Status:    rec.status,
+			Bytes:     rec.bytes,
+			Referer:   r.Referer(),
+			UserAgent: r.UserAgent(),
+			Duration:  float64(time.Since(start).Microseconds()) / 1000,
+		}))
+	})
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAccessLogFormats(t *testing.T) {
+	e := accessEntry{
+		Time:      time.Date(2024, 3, 1, 14, 5, 9, 0, time.FixedZone("", 3600)),
+		Remote:    "192.0.2.1",
+		Method:    "GET",
+		URI:       "/api/check?port=8080",
+		Proto:     "HTTP/1.1",
+		Status:    200,
+		Bytes:     120,
+		UserAgent: "curl/8.0",
+	}
+	common := `192.0.2.1 - - [01/Mar/2024:14:05:09 +0100] "GET /api/check?port=8080 HTTP/1.1" 200 120` + "\n"
+	if got := string((&AccessLog{Format: "common"}).format(e)); got != common {
+		t.Errorf("Unexpected common line:\n%s", got)
+	}
+	combined := strings.TrimSuffix(common, "\n") + ` "-" "curl/8.0"` + "\n"
+	if got := string((&AccessLog{Format: "combined"}).format(e)); got != combined {
+		t.Errorf("Unexpected combined line:\n%s", got)
+	}
+	var decoded accessEntry
+	if err := json.Unmarshal((&AccessLog{Format: "json"}).format(e), &decoded); err != nil || decoded.Status != 200 || decoded.URI != e.URI {
+		t.Errorf("Unexpected JSON line: %+v %v", decoded, err)
+	}
+}
+
+func TestAccessLogMiddleware(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	a := &AccessLog{Path: path, Format: "common"}
+	if err := a.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	server := &Server{client: &MockDockerClient{}, accessLog: a}
+	router := SetupRouter(server)
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/check?port=8080", nil))
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/check", nil))
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/widget/summary?token=s3cret", nil))
+
+	data, _ := os.ReadFile(path)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("Expected 3 lines, got %q", data)
+	}
+	if !regexp.MustCompile(`^192\.0\.2\.1 - - \[.+\] "GET /api/check\?port=8080 HTTP/1\.1" 200 \d+$`).MatchString(lines[0]) {
+		t.Errorf("Unexpected line %q", lines[0])
+	}
+	if !strings.Contains(lines[1], `" 400 `) {
+		t.Errorf("Expected the 400 logged, got %q", lines[1])
+	}
+	if strings.Contains(lines[2], "s3cret") || !strings.Contains(lines[2], "token=REDACTED") {
+		t.Errorf("Expected a widget token masked, got %q", lines[2])
+	}
+}
+
+func TestAccessLogRotatesBySize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	a := &AccessLog{Path: path, Format: "common", MaxSize: 100}
+	if err := a.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	line := []byte(strings.Repeat("x", 59) + "\n")
+	a.write(line)
+	a.write(line)
+
+	rotated, _ := os.ReadFile(path + ".1")
+	current, _ := os.ReadFile(path)
+	if string(rotated) != string(line) || string(current) != string(line) {
+		t.Errorf("Expected one line in each file, got %q and %q", rotated, current)
+	}
+}
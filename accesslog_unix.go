@@ -0,0 +1,24 @@
+//go:build !windows
+
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// reopenOnSignal reopens the access log on SIGUSR1, which logrotate sends
+// once it moved the file away
+func (a *AccessLog) reopenOnSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1)
+	go func() {
+		for range signals {
+			if err := a.Reopen(); err != nil {
+				log.Printf("Cannot reopen the access log: %v", err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,5 @@
+package main
+
+// reopenOnSignal does nothing: Windows has no SIGUSR1, so rotate by size
+// with QUAYCHECK_ACCESS_LOG_MAX_MB instead
+func (a *AccessLog) reopenOnSignal() {}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+// AllocateRequest picks a free port and reserves it in one step. Port is a
+// preference, as in a batch item.
+type AllocateRequest struct {
+	BatchItem
+	Owner string `json:"owner,omitempty"`
+}
+
+type AllocateResponse struct {
+	Reservation
+	Meta *ResponseMeta `json:"meta,omitempty"`
+}
+
+// handleAllocate is suggest and reserve as one operation: the port is
+// chosen inside the reservation store's lock, and saved with compare-and-swap,
+// so concurrent callers, here or on instances sharing the store, never get
+// the same one
+func (s *Server) handleAllocate(w http.ResponseWriter, r *http.Request) {
+	var req AllocateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Name == "" {
+		writeError(w, http.StatusBadRequest, "invalid_body", "Expected a JSON body with at least a name")
+		return
+	}
+	cfg := s.config()
+	batch := BatchRequest{Items: []BatchItem{req.BatchItem}}
+	if err := batch.validate(cfg.Presets); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_body", err.Error())
+		return
+	}
+
+	snap, err := s.collectScoped(r.Context())
+	if err != nil {
+		status, code, msg := classifyDockerError(err)
+		writeError(w, status, code, msg)
+		return
+	}
+	ix := s.pickable(snap.Index)
+	allocs, err := s.reservations.ReserveBatch(batch.Items, ix, req.Owner, environmentFrom(r.Context()), s.checkFrozen)
+
+	var fe *frozenError
+	switch {
+	case errors.As(err, &fe):
+		writeError(w, http.StatusLocked, "frozen", fe.Error())
+		return
+	case errors.Is(err, errReservationExists):
+		writeError(w, http.StatusConflict, "reservation_exists", "A reservation with this name already exists")
+		return
+	case err != nil:
+		writeReservationError(w, err)
+		return
+	}
+
+	a := allocs[0]
+	res, ok := s.reservations.Get(environmentFrom(r.Context()), a.Name)
+	if !ok {
+		// Deleted again already; still tell the caller what it got
+		res = Reservation{Name: a.Name, Port: a.Port, Protocol: a.Protocol, Owner: req.Owner, Revision: 1, Environment: environmentFrom(r.Context())}
+	}
+	s.audit(r, "reservation.create", res.Name, strconv.Itoa(res.Port))
+	w.Header().Set("ETag", res.etag())
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(AllocateResponse{Reservation: res, Meta: s.snapshotMeta(snap)})
+}
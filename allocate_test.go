@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+)
+
+func TestHandleAllocate(t *testing.T) {
+	store, _ := NewReservationStore(nil)
+	server := &Server{
+		client:       &MockDockerClient{Containers: []types.Container{{State: "running", Ports: []types.Port{{PublicPort: 3000}}}}},
+		reservations: store,
+	}
+	mux := SetupRouter(server)
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("POST", "/api/allocate", strings.NewReader(`{"name":"grafana","port":3000,"start":3000,"end":3010,"owner":"ci"}`)))
+	var resp AllocateResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	if w.Code != http.StatusCreated || resp.Port != 3001 || resp.Owner != "ci" || resp.CreatedAt.IsZero() {
+		t.Fatalf("Expected grafana on 3001, got %d %+v", w.Code, resp)
+	}
+	if r, ok := store.Get("", "grafana"); !ok || r.Port != 3001 {
+		t.Errorf("Expected the allocation to be reserved, got %+v", r)
+	}
+
+	for body, want := range map[string]int{
+		`{"name":"grafana"}`:                         http.StatusConflict,
+		`{"name":"full","start":3000,"end":3001}`:    http.StatusConflict,
+		`{"start":3000}`:                             http.StatusBadRequest,
+		`{"name":"x","preset":"nope"}`:               http.StatusBadRequest,
+		`{"name":"backwards","start":4000,"end":10}`: http.StatusBadRequest,
+	} {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("POST", "/api/allocate", strings.NewReader(body)))
+		if w.Code != want {
+			t.Errorf("%s: expected %d, got %d: %s", body, want, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestAllocateConcurrentCallersGetDistinctPorts(t *testing.T) {
+	store, _ := NewReservationStore(openTestDB(t))
+	mux := SetupRouter(&Server{client: &MockDockerClient{}, reservations: store})
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	seen := map[int]string{}
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest("POST", "/api/allocate", strings.NewReader(fmt.Sprintf(`{"name":"svc%d","start":9000}`, i))))
+			var resp AllocateResponse
+			json.NewDecoder(w.Body).Decode(&resp)
+			mu.Lock()
+			defer mu.Unlock()
+			if w.Code != http.StatusCreated {
+				t.Errorf("Expected 201, got %d", w.Code)
+			} else if other, dup := seen[resp.Port]; dup {
+				t.Errorf("Port %d handed to both %s and %s", resp.Port, other, resp.Name)
+			}
+			seen[resp.Port] = resp.Name
+		}()
+	}
+	wg.Wait()
+	if len(store.List()) != 20 {
+		t.Errorf("Expected 20 reservations, got %d", len(store.List()))
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ansibleAPIVersion is bumped on any breaking change to the /api/ansible contract
+const ansibleAPIVersion = 1
+
+// AnsibleResult follows the shape Ansible modules return, so a module wrapper
+// can pass it through to exit_json/fail_json untouched
+type AnsibleResult struct {
+	Changed     bool         `json:"changed"`
+	Failed      bool         `json:"failed"`
+	Msg         string       `json:"msg"`
+	Code        string       `json:"code,omitempty"`
+	Port        int          `json:"port,omitempty"`
+	Available   *bool        `json:"available,omitempty"`
+	Reservation *Reservation `json:"reservation,omitempty"`
+	Meta        AnsibleMeta  `json:"meta"`
+}
+
+type AnsibleMeta struct {
+	APIVersion int    `json:"api_version"`
+	Operation  string `json:"operation"`
+	CheckMode  bool   `json:"check_mode"`
+}
+
+type AnsibleReservationRequest struct {
+	ReservationRequest
+	State     string `json:"state,omitempty"`
+	CheckMode bool   `json:"check_mode,omitempty"`
+}
+
+type AnsibleCheckRequest struct {
+	Port int `json:"port"`
+}
+
+func writeAnsible(w http.ResponseWriter, status int, result AnsibleResult) {
+	result.Meta.APIVersion = ansibleAPIVersion
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(result)
+}
+
+func ansibleFailure(op, code, msg string) AnsibleResult {
+	return AnsibleResult{Failed: true, Msg: msg, Code: code, Meta: AnsibleMeta{Operation: op}}
+}
+
+// handleAnsibleReservation is an idempotent "ensure" operation: state=present
+// creates the reservation only if missing, state=absent removes it if present
+func (s *Server) handleAnsibleReservation(w http.ResponseWriter, r *http.Request) {
+	const op = "reservation"
+	var req AnsibleReservationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Name == "" {
+		writeAnsible(w, http.StatusBadRequest, ansibleFailure(op, "invalid_body", "Expected a JSON body with at least a name"))
+		return
+	}
+	meta := AnsibleMeta{Operation: op, CheckMode: req.CheckMode}
+
+	switch req.State {
+	case "absent":
+		existing, exists := s.reservations.Get(environmentFrom(r.Context()), req.Name)
+		if !exists {
+			writeAnsible(w, http.StatusOK, AnsibleResult{Msg: "Reservation already absent", Code: "reservation_absent", Meta: meta})
+			return
+		}
+		if !req.CheckMode {
+			if _, err := s.reservations.Delete(existing.Environment, req.Name); err != nil {
+				writeAnsible(w, http.StatusInternalServerError, ansibleFailure(op, "store_error", err.Error()))
+				return
+			}
+		}
+		writeAnsible(w, http.StatusOK, AnsibleResult{Changed: true, Msg: "Reservation removed", Code: "reservation_removed", Port: existing.Port, Reservation: &existing, Meta: meta})
+		return
+	case "", "present":
+	default:
+		writeAnsible(w, http.StatusBadRequest, ansibleFailure(op, "invalid_param", "state must be present or absent"))
+		return
+	}
+	if req.Port < 0 || req.Port > maxPort {
+		writeAnsible(w, http.StatusBadRequest, ansibleFailure(op, "invalid_param", "port must be between 1 and 65535"))
+		return
+	}
+
+	used, err := s.usedWithoutReservations(r.Context())
+	if err != nil {
+		status, code, msg := classifyDockerError(err)
+		writeAnsible(w, status, ansibleFailure(op, code, msg))
+		return
+	}
+
+	if req.Port == 0 {
+		used = s.pickable(used)
+	}
+
+	want := reservationFromRequest(req.ReservationRequest)
+	want.Environment = environmentFrom(r.Context())
+	res, changed, err := s.reservations.Ensure(want, used, suggestStart(req.Start), true)
+	if err == nil && changed {
+		if f, ok := s.frozen(res.Port); ok {
+			writeAnsible(w, http.StatusLocked, ansibleFailure(op, "frozen", f.message()))
+			return
+		}
+	}
+	if err == nil && !req.CheckMode {
+		res, changed, err = s.reservations.Ensure(want, used, suggestStart(req.Start), false)
+	}
+	if err != nil {
+		code := "store_error"
+		switch {
+		case errors.Is(err, errPortTaken):
+			code = "port_in_use"
+		case errors.Is(err, errNoFreePort):
+			code = "no_free_port"
+		}
+		writeAnsible(w, http.StatusConflict, ansibleFailure(op, code, err.Error()))
+		return
+	}
+
+	code, msg := "reservation_present", "Reservation already present"
+	if changed {
+		code, msg = "port_reserved", fmt.Sprintf("Reserved port %d", res.Port)
+		if !req.CheckMode {
+			s.audit(r, "reservation.ensure", res.Name, strconv.Itoa(res.Port))
+		}
+	}
+	writeAnsible(w, http.StatusOK, AnsibleResult{Changed: changed, Msg: msg, Code: code, Port: res.Port, Reservation: &res, Meta: meta})
+}
+
+func (s *Server) handleAnsibleCheck(w http.ResponseWriter, r *http.Request) {
+	const op = "check"
+	var req AnsibleCheckRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Port < 1 || req.Port > 65535 {
+		writeAnsible(w, http.StatusBadRequest, ansibleFailure(op, "invalid_body", "Expected a JSON body with a port between 1 and 65535"))
+		return
+	}
+
+	snap, err := s.loadSnapshot(r.Context())
+	if err != nil {
+		status, code, msg := classifyDockerError(err)
+		writeAnsible(w, status, ansibleFailure(op, code, msg))
+		return
+	}
+
+	available := !snap.Index.Used(req.Port)
+	code, msg := "port_available", "Port is available"
+	if !available {
+		code, msg = "port_in_use", "Port is in use"
+	}
+	writeAnsible(w, http.StatusOK, AnsibleResult{Msg: msg, Code: code, Port: req.Port, Available: &available, Meta: AnsibleMeta{Operation: op}})
+}
+
+// runReserve implements `quaycheck reserve`, printing the same AnsibleResult
+// JSON as the API so shell-based modules can use it as is
+func runReserve(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("reserve", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	server := fs.String("server", os.Getenv("QUAYCHECK_SERVER"), "quaycheck URL")
+	var req AnsibleReservationRequest
+	fs.StringVar(&req.Name, "name", "", "reservation name")
+	fs.IntVar(&req.Port, "port", 0, "exact port to reserve (default: pick a free one)")
+	fs.IntVar(&req.Start, "start", 0, "first port to consider when picking")
+	fs.StringVar(&req.Owner, "owner", "", "owner of the reservation")
+	fs.StringVar(&req.Note, "note", "", "free-form note")
+	fs.StringVar(&req.State, "state", "present", "present or absent")
+	fs.BoolVar(&req.CheckMode, "check", false, "report what would change without changing it")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	ctx, cancel := cliContext()
+	defer cancel()
+	result := reserveRemote(ctx, *server, req)
+	enc := json.NewEncoder(stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(result)
+	if result.Failed {
+		return 1
+	}
+	return 0
+}
+
+func reserveRemote(ctx context.Context, server string, req AnsibleReservationRequest) AnsibleResult {
+	const op = "reservation"
+	if server == "" {
+		return ansibleFailure(op, "missing_param", "-server or QUAYCHECK_SERVER is required")
+	}
+	if req.Name == "" {
+		return ansibleFailure(op, "missing_param", "-name is required")
+	}
+
+	body, _ := json.Marshal(req)
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", strings.TrimRight(server, "/")+"/api/ansible/reservation", bytes.NewReader(body))
+	if err != nil {
+		return ansibleFailure(op, "request_error", err.Error())
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	authorize(httpReq)
+	resp, err := http.DefaultClient.Do(httpReq)
+	if err != nil {
+		return ansibleFailure(op, "request_error", err.Error())
+	}
+	defer resp.Body.Close()
+
+	var result AnsibleResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return ansibleFailure(op, "request_error", fmt.Sprintf("unexpected %s response from server", resp.Status))
+	}
+	return result
+}
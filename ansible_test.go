@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+)
+
+func TestHandleAnsibleReservation(t *testing.T) {
+	store, _ := NewReservationStore(nil)
+	server := &Server{client: &MockDockerClient{}, reservations: store}
+	mux := SetupRouter(server)
+
+	post := func(body string) (int, AnsibleResult) {
+		req := httptest.NewRequest("POST", "/api/ansible/reservation", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		var result AnsibleResult
+		json.NewDecoder(w.Body).Decode(&result)
+		return w.Code, result
+	}
+
+	tests := []struct {
+		body    string
+		status  int
+		changed bool
+		failed  bool
+	}{
+		{`{"name":"grafana","port":3000,"check_mode":true}`, http.StatusOK, true, false},
+		{`{"name":"grafana","port":3000}`, http.StatusOK, true, false},
+		{`{"name":"grafana","port":3000}`, http.StatusOK, false, false},
+		{`{"name":"grafana"}`, http.StatusOK, false, false},
+		{`{"name":"loki","port":3000}`, http.StatusConflict, false, true},
+		{`{"name":"grafana","state":"absent"}`, http.StatusOK, true, false},
+		{`{"name":"grafana","state":"absent"}`, http.StatusOK, false, false},
+		{`{"name":"grafana","state":"latest"}`, http.StatusBadRequest, false, true},
+		{`{}`, http.StatusBadRequest, false, true},
+	}
+
+	for i, tt := range tests {
+		status, result := post(tt.body)
+		if status != tt.status || result.Changed != tt.changed || result.Failed != tt.failed {
+			t.Errorf("Step %d %s: expected %d changed=%v failed=%v, got %d %+v", i, tt.body, tt.status, tt.changed, tt.failed, status, result)
+		}
+		if result.Meta.APIVersion != ansibleAPIVersion || result.Meta.Operation != "reservation" {
+			t.Errorf("Step %d: unexpected meta %+v", i, result.Meta)
+		}
+	}
+}
+
+func TestHandleAnsibleCheck(t *testing.T) {
+	server := &Server{client: &MockDockerClient{Containers: []types.Container{
+		{State: "running", Ports: []types.Port{{PublicPort: 8080}}},
+	}}}
+
+	req := httptest.NewRequest("POST", "/api/ansible/check", strings.NewReader(`{"port":8080}`))
+	w := httptest.NewRecorder()
+	server.handleAnsibleCheck(w, req)
+
+	var result AnsibleResult
+	json.NewDecoder(w.Body).Decode(&result)
+	if result.Changed || result.Failed || result.Available == nil || *result.Available {
+		t.Errorf("Expected unchanged unavailable result, got %+v", result)
+	}
+}
+
+func TestRunReserve(t *testing.T) {
+	store, _ := NewReservationStore(nil)
+	ts := httptest.NewServer(SetupRouter(&Server{client: &MockDockerClient{}, reservations: store}))
+	defer ts.Close()
+
+	var stdout, stderr bytes.Buffer
+	if code := runReserve([]string{"-server", ts.URL, "-name", "grafana", "-start", "3000"}, &stdout, &stderr); code != 0 {
+		t.Fatalf("Expected exit code 0, got %d: %s", code, stdout.String())
+	}
+	var result AnsibleResult
+	json.Unmarshal(stdout.Bytes(), &result)
+	if !result.Changed || result.Port != 3000 {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+
+	stdout.Reset()
+	if code := runReserve([]string{"-name", "grafana"}, &stdout, &stderr); code != 1 {
+		t.Errorf("Expected exit code 1 without a server, got %d", code)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+)
+
+// staticFiles is the web UI, compiled into the binary so a release is a
+// single file that runs from a scratch image or any working directory
+//
+//go:embed static
+var staticFiles embed.FS
+
+// staticHandler serves the embedded UI. QUAYCHECK_STATIC_DIR serves it from
+// disk instead, which saves rebuilding while working on the frontend.
+func staticHandler() http.Handler {
+	if dir := os.Getenv("QUAYCHECK_STATIC_DIR"); dir != "" {
+		return http.FileServer(http.Dir(dir))
+	}
+	sub, err := fs.Sub(staticFiles, "static")
+	if err != nil {
+		panic(err)
+	}
+	return http.FileServer(http.FS(sub))
+}
+
+// frameOriginsFromEnv reads QUAYCHECK_FRAME_ORIGINS, the origins allowed to
+// show the UI in an iframe, such as a dashboard's; "*" allows any. Only
+// quaycheck's own origin may by default.
+func frameOriginsFromEnv() []string {
+	origins := queryList([]string{os.Getenv("QUAYCHECK_FRAME_ORIGINS")})
+	for _, o := range origins {
+		if o == "*" {
+			continue
+		}
+		if u, err := url.Parse(o); err != nil || u.Scheme == "" || u.Host == "" || strings.Trim(u.Path, "/") != "" {
+			log.Fatalf("Invalid QUAYCHECK_FRAME_ORIGINS entry %q: expected an origin such as https://grafana.example.com", o)
+		}
+	}
+	return origins
+}
+
+// contentSecurityPolicy is the UI's: its own scripts and styles only, plus
+// WebAssembly for the compose planner, framed by frameAncestors
+func contentSecurityPolicy(frameAncestors string) string {
+	return "default-src 'self'; script-src 'self' 'wasm-unsafe-eval'; style-src 'self'; img-src 'self'; " +
+		"connect-src 'self'; object-src 'none'; base-uri 'none'; form-action 'self'; frame-ancestors " + frameAncestors
+}
+
+// withUIHeaders adds the security headers of the static UI. X-Frame-Options
+// can't list origins, so it's only sent while framing is limited to
+// quaycheck's own; frame-ancestors says the rest.
+func (s *Server) withUIHeaders(next http.Handler) http.Handler {
+	ancestors := "'self'"
+	switch {
+	case slices.Contains(s.frameOrigins, "*"):
+		ancestors = "*"
+	case len(s.frameOrigins) > 0:
+		ancestors = "'self' " + strings.Join(s.frameOrigins, " ")
+	}
+	csp := contentSecurityPolicy(ancestors)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		h.Set("Content-Security-Policy", csp)
+		if len(s.frameOrigins) == 0 {
+			h.Set("X-Frame-Options", "SAMEORIGIN")
+		}
+		h.Set("Referrer-Policy", "same-origin")
+		h.Set("X-Content-Type-Options", "nosniff")
+		next.ServeHTTP(w, r)
+	})
+}
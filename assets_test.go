@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestUIHeaders(t *testing.T) {
+	router := SetupRouter(&Server{client: &MockDockerClient{}})
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	csp := w.Header().Get("Content-Security-Policy")
+	if !strings.Contains(csp, "script-src 'self' 'wasm-unsafe-eval';") || !strings.HasSuffix(csp, "frame-ancestors 'self'") {
+		t.Errorf("Unexpected CSP %q", csp)
+	}
+	if w.Header().Get("X-Frame-Options") != "SAMEORIGIN" || w.Header().Get("Referrer-Policy") != "same-origin" || w.Header().Get("X-Content-Type-Options") != "nosniff" {
+		t.Errorf("Missing security headers: %v", w.Header())
+	}
+
+	// The API isn't a page and keeps its own headers
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/ports", nil))
+	if w.Header().Get("Content-Security-Policy") != "" {
+		t.Error("Expected no CSP on the API")
+	}
+
+	router = SetupRouter(&Server{client: &MockDockerClient{}, frameOrigins: []string{"https://grafana.lan"}})
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if !strings.HasSuffix(w.Header().Get("Content-Security-Policy"), "frame-ancestors 'self' https://grafana.lan") || w.Header().Get("X-Frame-Options") != "" {
+		t.Errorf("Expected grafana.lan allowed to frame the UI, got %v", w.Header())
+	}
+}
+
+// The CSP blocks inline handlers and scripts, so the UI must not have any
+func TestUIHasNoInlineScript(t *testing.T) {
+	inline := regexp.MustCompile(`(?i)\son[a-z]+\s*=|<script>|<style|\sstyle=`)
+	for _, name := range []string{"static/index.html", "static/app.js"} {
+		data, err := staticFiles.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m := inline.Find(data); m != nil {
+			t.Errorf("%s: inline %q is blocked by the CSP", name, m)
+		}
+	}
+}
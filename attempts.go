@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ConnectionAttempts is who has recently been connecting to a port from
+// public addresses
+type ConnectionAttempts struct {
+	Connections int `json:"connections"`
+	Sources     int `json:"sources"`
+	// AuthFailures counts failed SSH logins, from sshd's journal
+	AuthFailures int             `json:"auth_failures,omitempty"`
+	Countries    map[string]int  `json:"countries,omitempty"`
+	Top          []AttemptSource `json:"top,omitempty"`
+}
+
+type AttemptSource struct {
+	IP          string `json:"ip"`
+	Connections int    `json:"connections"`
+	Country     string `json:"country,omitempty"`
+}
+
+// AttemptTracker reads recent inbound connections from conntrack and
+// failed logins from sshd's journal, credited to SSHPort
+type AttemptTracker struct {
+	Conntrack bool
+	Journal   bool
+	SSHPort   int
+	GeoIP     *GeoIPDB
+}
+
+// attemptsFromEnv reads QUAYCHECK_ATTEMPTS, a list of conntrack and
+// journal[:port], and QUAYCHECK_GEOIP_CSV
+func attemptsFromEnv() *AttemptTracker {
+	raw := os.Getenv("QUAYCHECK_ATTEMPTS")
+	if raw == "" {
+		return nil
+	}
+	t := &AttemptTracker{SSHPort: 22}
+	for _, item := range queryList([]string{raw}) {
+		name, port, hasPort := strings.Cut(item, ":")
+		switch {
+		case name == "conntrack" && !hasPort:
+			t.Conntrack = true
+		case name == "journal":
+			t.Journal = true
+			if hasPort {
+				p, err := strconv.Atoi(port)
+				if err != nil || p < 1 || p > maxPort {
+					log.Fatalf("Invalid SSH port in QUAYCHECK_ATTEMPTS: %s", item)
+				}
+				t.SSHPort = p
+			}
+		default:
+			log.Fatalf("Invalid QUAYCHECK_ATTEMPTS entry %q: expected conntrack or journal[:port]", item)
+		}
+	}
+	if path := os.Getenv("QUAYCHECK_GEOIP_CSV"); path != "" {
+		db, err := loadGeoIP(path)
+		if err != nil {
+			log.Fatalf("Invalid QUAYCHECK_GEOIP_CSV: %v", err)
+		}
+		t.GeoIP = db
+	}
+	return t
+}
+
+// readConntrack is swapped out in tests. The proc file needs the
+// nf_conntrack_proc_compat module; the conntrack tool prints the same.
+var readConntrack = func(ctx context.Context) ([]byte, error) {
+	if data, err := os.ReadFile("/proc/net/nf_conntrack"); err == nil {
+		return data, nil
+	}
+	return exec.CommandContext(ctx, "conntrack", "-L", "-o", "extended").Output()
+}
+
+// readSSHJournal is swapped out in tests
+var readSSHJournal = func(ctx context.Context) ([]byte, error) {
+	return exec.CommandContext(ctx, "journalctl", "-q", "-o", "cat", "--since", "-24h", "_COMM=sshd", "_COMM=sshd-session").Output()
+}
+
+// portKey is a port and protocol, for counts read from the kernel
+type portKey struct {
+	port  int
+	proto string
+}
+
+// Collect gathers attempts per port. A source that can't be read is
+// reported but doesn't hide what the others found.
+func (t *AttemptTracker) Collect(ctx context.Context) (map[portKey]*ConnectionAttempts, error) {
+	hits := map[portKey]map[string]int{}
+	failures := map[string]int{}
+	var errs []error
+	if t.Conntrack {
+		data, err := readConntrack(ctx)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("conntrack: %w", err))
+		}
+		for key, sources := range parseConntrack(data) {
+			hits[key] = sources
+		}
+	}
+	if t.Journal {
+		data, err := readSSHJournal(ctx)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("journal: %w", err))
+		}
+		failures = parseSSHFailures(data)
+	}
+
+	result := map[portKey]*ConnectionAttempts{}
+	ssh := portKey{t.SSHPort, "tcp"}
+	if len(failures) > 0 && hits[ssh] == nil {
+		hits[ssh] = map[string]int{}
+	}
+	for key, sources := range hits {
+		a := &ConnectionAttempts{}
+		if key == ssh {
+			for ip, n := range failures {
+				a.AuthFailures += n
+				// A failed login is a connection even once conntrack has
+				// forgotten it
+				sources[ip] = max(sources[ip], n)
+			}
+		}
+		for ip, n := range sources {
+			a.Connections += n
+			src := AttemptSource{IP: ip, Connections: n}
+			if country := t.GeoIP.Country(ip); country != "" {
+				src.Country = country
+				if a.Countries == nil {
+					a.Countries = map[string]int{}
+				}
+				a.Countries[country]++
+			}
+			a.Top = append(a.Top, src)
+		}
+		a.Sources = len(a.Top)
+		slices.SortFunc(a.Top, func(x, y AttemptSource) int {
+			if x.Connections != y.Connections {
+				return y.Connections - x.Connections
+			}
+			return strings.Compare(x.IP, y.IP)
+		})
+		if len(a.Top) > 5 {
+			a.Top = a.Top[:5]
+		}
+		result[key] = a
+	}
+	if len(errs) > 0 {
+		return result, fmt.Errorf("%v", errs)
+	}
+	return result, nil
+}
+
+// parseConntrack counts connections from public addresses per destination
+// port, by source, from the original direction of each entry
+func parseConntrack(data []byte) map[portKey]map[string]int {
+	result := map[portKey]map[string]int{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		var proto, src, dport string
+		for _, f := range fields {
+			if f == "tcp" || f == "udp" {
+				if proto == "" {
+					proto = f
+				}
+				continue
+			}
+			k, v, ok := strings.Cut(f, "=")
+			if !ok {
+				continue
+			}
+			// The reply direction repeats the keys: keep the first
+			switch {
+			case k == "src" && src == "":
+				src = v
+			case k == "dport" && dport == "":
+				dport = v
+			}
+		}
+		port, err := strconv.Atoi(dport)
+		if proto == "" || err != nil || addressScope(src, false) != scopePublic {
+			continue
+		}
+		key := portKey{port, proto}
+		if result[key] == nil {
+			result[key] = map[string]int{}
+		}
+		result[key][src]++
+	}
+	return result
+}
+
+var sshFailure = regexp.MustCompile(`^(?:Failed \S+ for (?:invalid user )?.* from|Invalid user .* from) (\S+) port (\d+)`)
+
+// parseSSHFailures counts failed logins by source address. sshd logs
+// "Invalid user" and "Failed password" for the same attempt, so lines are
+// told apart by the client's source port.
+func parseSSHFailures(data []byte) map[string]int {
+	seen := map[string]bool{}
+	result := map[string]int{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		m := sshFailure.FindStringSubmatch(scanner.Text())
+		if m == nil || addressScope(m[1], false) != scopePublic || seen[m[1]+" "+m[2]] {
+			continue
+		}
+		seen[m[1]+" "+m[2]] = true
+		result[m[1]]++
+	}
+	return result
+}
+
+// GeoIPDB maps addresses to countries from a "start,end,country" CSV, the
+// layout of DB-IP's free IP-to-country database
+type GeoIPDB struct {
+	ranges []geoRange
+}
+
+type geoRange struct {
+	start, end net.IP
+	country    string
+}
+
+func loadGeoIP(path string) (*GeoIPDB, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	db := &GeoIPDB{}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Split(strings.ReplaceAll(scanner.Text(), `"`, ""), ",")
+		if len(fields) < 3 {
+			continue
+		}
+		start, end := net.ParseIP(fields[0]).To16(), net.ParseIP(fields[1]).To16()
+		if start == nil || end == nil {
+			return nil, fmt.Errorf("%s:%d: expected start,end,country", path, line)
+		}
+		db.ranges = append(db.ranges, geoRange{start, end, fields[2]})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	slices.SortFunc(db.ranges, func(a, b geoRange) int { return bytes.Compare(a.start, b.start) })
+	return db, nil
+}
+
+// Country is the country code for ip, or empty when unknown
+func (db *GeoIPDB) Country(ip string) string {
+	parsed := net.ParseIP(ip).To16()
+	if db == nil || parsed == nil {
+		return ""
+	}
+	// The last range starting at or before ip
+	i, found := slices.BinarySearchFunc(db.ranges, parsed, func(r geoRange, ip net.IP) int { return bytes.Compare(r.start, ip) })
+	if !found {
+		i--
+	}
+	if i < 0 || bytes.Compare(parsed, db.ranges[i].end) > 0 {
+		return ""
+	}
+	return db.ranges[i].country
+}
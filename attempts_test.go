@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+)
+
+const conntrackDump = `ipv4     2 tcp      6 117 SYN_SENT src=203.0.113.5 dst=192.168.1.10 sport=40000 dport=22 [UNREPLIED] src=192.168.1.10 dst=203.0.113.5 sport=22 dport=40000 mark=0 use=1
+ipv4     2 tcp      6 431999 ESTABLISHED src=198.51.100.7 dst=192.168.1.10 sport=51000 dport=8443 src=172.17.0.2 dst=198.51.100.7 sport=443 dport=51000 [ASSURED] mark=0 use=1
+ipv4     2 tcp      6 100 TIME_WAIT src=198.51.100.7 dst=192.168.1.10 sport=51001 dport=8443 src=172.17.0.2 dst=198.51.100.7 sport=443 dport=51001 [ASSURED] mark=0 use=1
+ipv4     2 tcp      6 100 TIME_WAIT src=192.168.1.50 dst=192.168.1.10 sport=51002 dport=8443 src=172.17.0.2 dst=192.168.1.50 sport=443 dport=51002 [ASSURED] mark=0 use=1
+ipv4     2 udp      17 29 src=203.0.113.9 dst=192.168.1.10 sport=5353 dport=51820 src=192.168.1.10 dst=203.0.113.9 sport=51820 dport=5353 mark=0 use=1
+`
+
+const sshJournal = `Invalid user admin from 203.0.113.5 port 40000
+Failed password for invalid user admin from 203.0.113.5 port 40000 ssh2
+Failed password for root from 203.0.113.5 port 40010 ssh2
+Failed publickey for git from 192.0.2.1 port 5000 ssh2
+Accepted publickey for me from 192.168.1.50 port 6000 ssh2
+Failed password for me from 192.168.1.50 port 6001 ssh2
+`
+
+func TestAttemptTracker(t *testing.T) {
+	defer func(orig func(context.Context) ([]byte, error)) { readConntrack = orig }(readConntrack)
+	defer func(orig func(context.Context) ([]byte, error)) { readSSHJournal = orig }(readSSHJournal)
+	readConntrack = func(context.Context) ([]byte, error) { return []byte(conntrackDump), nil }
+	readSSHJournal = func(context.Context) ([]byte, error) { return []byte(sshJournal), nil }
+
+	geoPath := filepath.Join(t.TempDir(), "dbip-country-lite.csv")
+	os.WriteFile(geoPath, []byte("192.0.2.0,192.0.2.255,NL\n\"198.51.100.0\",\"198.51.100.255\",\"US\"\n203.0.113.0,203.0.113.255,CN\n2001:db8::,2001:db8::ffff,DE\n"), 0o644)
+	geo, err := loadGeoIP(geoPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := geo.Country("2001:db8::1"); got != "DE" {
+		t.Errorf("Expected DE, got %q", got)
+	}
+	if got := geo.Country("10.0.0.1"); got != "" {
+		t.Errorf("Expected no country for an address outside every range, got %q", got)
+	}
+
+	tracker := &AttemptTracker{Conntrack: true, Journal: true, SSHPort: 22, GeoIP: geo}
+	attempts, err := tracker.Collect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ssh := attempts[portKey{22, "tcp"}]
+	if ssh == nil || ssh.AuthFailures != 3 || ssh.Sources != 2 || ssh.Connections != 3 || ssh.Top[0].IP != "203.0.113.5" || ssh.Top[0].Country != "CN" || ssh.Countries["NL"] != 1 {
+		t.Errorf("Unexpected SSH attempts %+v", ssh)
+	}
+	https := attempts[portKey{8443, "tcp"}]
+	if https == nil || https.Connections != 2 || https.Sources != 1 || https.AuthFailures != 0 {
+		t.Errorf("Expected two public connections to 8443, got %+v", https)
+	}
+	if wg := attempts[portKey{51820, "udp"}]; wg == nil || wg.Connections != 1 {
+		t.Errorf("Expected the UDP entry to be counted, got %+v", wg)
+	}
+
+	readSSHJournal = func(context.Context) ([]byte, error) { return nil, errors.New("journalctl: not found") }
+	attempts, err = tracker.Collect(context.Background())
+	if err == nil || attempts[portKey{8443, "tcp"}] == nil {
+		t.Errorf("Expected conntrack's findings next to the journal error, got %v %+v", err, attempts)
+	}
+}
+
+func TestReachabilityAttempts(t *testing.T) {
+	defer func(orig func(context.Context) ([]byte, error)) { readConntrack = orig }(readConntrack)
+	readConntrack = func(context.Context) ([]byte, error) { return []byte(conntrackDump), nil }
+	defer func(orig func() ([]InterfaceInfo, error)) { hostInterfaces = orig }(hostInterfaces)
+	hostInterfaces = func() ([]InterfaceInfo, error) {
+		return []InterfaceInfo{{Name: "eth0", Addresses: []AddressStatus{{IP: "192.168.1.10"}}}}, nil
+	}
+
+	server := &Server{
+		client: &MockDockerClient{Containers: []types.Container{
+			{Names: []string{"/gitea"}, State: "running", Ports: []types.Port{{PublicPort: 22, PrivatePort: 22, Type: "tcp", IP: "0.0.0.0"}}},
+			{Names: []string{"/admin"}, State: "running", Ports: []types.Port{{PublicPort: 5000, PrivatePort: 5000, Type: "tcp", IP: "127.0.0.1"}}},
+			{Names: []string{"/web"}, State: "running", Ports: []types.Port{{PublicPort: 8443, PrivatePort: 443, Type: "tcp", IP: "0.0.0.0"}}},
+			{Names: []string{"/db"}, State: "running", Ports: []types.Port{{PublicPort: 5432, PrivatePort: 5432, Type: "tcp", IP: "0.0.0.0"}}},
+		}},
+		attempts: &AttemptTracker{Conntrack: true},
+	}
+	w := httptest.NewRecorder()
+	SetupRouter(server).ServeHTTP(w, httptest.NewRequest("GET", "/api/reachability?sort=attempts", nil))
+	var resp ReachabilityResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	if w.Code != 200 || len(resp.Ports) != 4 {
+		t.Fatalf("Expected four ports without a probe, got %d %+v", w.Code, resp)
+	}
+	var order []int
+	for _, p := range resp.Ports {
+		order = append(order, p.Port)
+	}
+	if order[0] != 8443 || order[1] != 22 || order[2] != 5000 || order[3] != 5432 {
+		t.Errorf("Expected the busiest ports first, got %v", order)
+	}
+	if resp.Ports[0].Attempts.Connections != 2 || resp.Ports[0].Reachable != nil {
+		t.Errorf("Unexpected attempts %+v", resp.Ports[0])
+	}
+	if resp.Ports[2].Attempts != nil || resp.Ports[3].Attempts == nil || resp.Ports[3].Attempts.Connections != 0 {
+		t.Errorf("Expected attempts on exposed ports only, got %+v %+v", resp.Ports[2], resp.Ports[3])
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"quaycheck/internal/storage"
+)
+
+// AuditEntry records a change made through the API
+type AuditEntry struct {
+	ID      int64     `json:"id"`
+	Time    time.Time `json:"time"`
+	Action  string    `json:"action"`
+	Subject string    `json:"subject,omitempty"`
+	Detail  string    `json:"detail,omitempty"`
+	Remote  string    `json:"remote,omitempty"`
+}
+
+// AuditLog appends to the audit table
+type AuditLog struct {
+	db *storage.DB
+}
+
+func NewAuditLog(db *storage.DB) *AuditLog {
+	if db == nil {
+		return nil
+	}
+	return &AuditLog{db: db}
+}
+
+func (a *AuditLog) Record(ctx context.Context, e AuditEntry) error {
+	if a == nil {
+		return nil
+	}
+	_, err := a.db.ExecContext(ctx, `INSERT INTO audit (at, action, subject, detail, remote) VALUES (?, ?, ?, ?, ?)`,
+		e.Time.UnixNano(), e.Action, e.Subject, e.Detail, e.Remote)
+	return err
+}
+
+// List returns the latest entries, newest first
+func (a *AuditLog) List(ctx context.Context, limit int) ([]AuditEntry, error) {
+	result := []AuditEntry{}
+	if a == nil {
+		return result, nil
+	}
+	rows, err := a.db.QueryContext(ctx, `SELECT id, at, action, subject, detail, remote FROM audit ORDER BY id DESC LIMIT ?`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e AuditEntry
+		var at int64
+		if err := rows.Scan(&e.ID, &at, &e.Action, &e.Subject, &e.Detail, &e.Remote); err != nil {
+			return nil, err
+		}
+		e.Time = time.Unix(0, at).UTC()
+		result = append(result, e)
+	}
+	return result, rows.Err()
+}
+
+// audit records a successful change made by request r. Failing to audit
+// doesn't fail the change, which has already happened.
+func (s *Server) audit(r *http.Request, action, subject, detail string) {
+	s.auditAs(r.Context(), actorFromRequest(r), s.clientIP(r), action, subject, detail)
+}
+
+// auditAs records a change made by actor from remote, for changes that
+// background jobs make on their own
+func (s *Server) auditAs(ctx context.Context, actor, remote, action, subject, detail string) {
+	now := time.Now().UTC()
+	err := s.auditLog.Record(ctx, AuditEntry{
+		Time:    now,
+		Action:  action,
+		Subject: subject,
+		Detail:  detail,
+		Remote:  remote,
+	})
+	if err != nil {
+		log.Printf("Cannot record audit entry %s %s: %v", action, subject, err)
+	}
+	err = s.eventLog.Append(ctx, LogEntry{
+		Time:    now,
+		Kind:    LogKindAction,
+		Type:    action,
+		Subject: subject,
+		Detail:  detail,
+		Actor:   actor,
+		Remote:  remote,
+	})
+	if err != nil {
+		log.Printf("Cannot append %s %s to the event log: %v", action, subject, err)
+	}
+}
+
+func (s *Server) handleAudit(w http.ResponseWriter, r *http.Request) {
+	limit, ok := limitParam(r, 100)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "invalid_param", "Invalid limit")
+		return
+	}
+	entries, err := s.auditLog.List(r.Context(), limit)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "store_error", "Cannot read audit log: "+err.Error())
+		return
+	}
+	writeEncoded(w, r, entries)
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Scope is what a token may do. Admin implies every other scope.
+type Scope string
+
+const (
+	ScopePortsRead Scope = "ports:read"
+	ScopeCheck     Scope = "check"
+	ScopeSuggest   Scope = "suggest"
+	ScopeReserve   Scope = "reserve"
+	// ScopeContainers stops and restarts containers, when that's enabled
+	ScopeContainers Scope = "containers"
+	ScopeAdmin      Scope = "admin"
+)
+
+var allScopes = []Scope{ScopePortsRead, ScopeCheck, ScopeSuggest, ScopeReserve, ScopeContainers, ScopeAdmin}
+
+// APIToken is a bearer token, named for logs, and the scopes it grants
+type APIToken struct {
+	Name   string
+	Secret string
+	Scopes []Scope
+}
+
+func (t APIToken) allows(scope Scope) bool {
+	return slices.Contains(t.Scopes, scope) || slices.Contains(t.Scopes, ScopeAdmin)
+}
+
+// parseScopes reads "check,suggest"
+func parseScopes(s string) ([]Scope, error) {
+	var scopes []Scope
+	for _, v := range strings.Split(s, ",") {
+		scope := Scope(strings.TrimSpace(v))
+		if scope == "" {
+			continue
+		}
+		if !slices.Contains(allScopes, scope) {
+			return nil, fmt.Errorf("unknown scope %q", scope)
+		}
+		scopes = append(scopes, scope)
+	}
+	if len(scopes) == 0 {
+		return nil, fmt.Errorf("no scopes given")
+	}
+	return scopes, nil
+}
+
+// parseTokens reads "dashboard:s3cret=ports:read;ci:t0ken=check,suggest,reserve"
+func parseTokens(s string) ([]APIToken, error) {
+	var tokens []APIToken
+	for i, entry := range strings.Split(s, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		id, spec, ok := strings.Cut(entry, "=")
+		name, secret, hasSecret := strings.Cut(id, ":")
+		if !ok || !hasSecret || name == "" || secret == "" {
+			// Never echo the entry, it holds the secret
+			return nil, fmt.Errorf("invalid token #%d, expected name:secret=scope,scope", i+1)
+		}
+		scopes, err := parseScopes(spec)
+		if err != nil {
+			return nil, fmt.Errorf("token %s: %w", name, err)
+		}
+		tokens = append(tokens, APIToken{Name: name, Secret: secret, Scopes: scopes})
+	}
+	return tokens, nil
+}
+
+func tokensFromEnv() []APIToken {
+	tokens, err := parseTokens(os.Getenv("QUAYCHECK_TOKENS"))
+	if err != nil {
+		// Failing open would expose everything the tokens were meant to guard
+		log.Fatalf("Invalid QUAYCHECK_TOKENS: %v", err)
+	}
+	return tokens
+}
+
+// bearerToken returns the token of an "Authorization: Bearer" header
+func bearerToken(r *http.Request) string {
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return token
+}
+
+// lookupToken finds the token with this secret
+func lookupToken(tokens []APIToken, secret string) (APIToken, bool) {
+	found, ok := APIToken{}, false
+	for _, t := range tokens {
+		// Compare against every token so timing doesn't tell which matched
+		if subtle.ConstantTimeCompare([]byte(secret), []byte(t.Secret)) == 1 {
+			found, ok = t, true
+		}
+	}
+	return found, ok
+}
+
+// envTokens are QUAYCHECK_TOKENS plus QUAYCHECK_ADMIN_TOKEN as an admin token
+func (s *Server) envTokens() []APIToken {
+	tokens := s.tokens
+	if admin := os.Getenv("QUAYCHECK_ADMIN_TOKEN"); admin != "" {
+		tokens = append(slices.Clip(tokens), APIToken{Name: "admin", Secret: admin, Scopes: []Scope{ScopeAdmin}})
+	}
+	return tokens
+}
+
+// authenticate finds the token with this secret, configured or managed
+func (s *Server) authenticate(secret string) (APIToken, bool) {
+	if t, ok := lookupToken(s.envTokens(), secret); ok {
+		return t, true
+	}
+	if s.apiTokens != nil {
+		return s.apiTokens.Authenticate(secret)
+	}
+	return APIToken{}, false
+}
+
+// authRequired reports whether endpoints needing scope are guarded. Until a
+// scoped token exists, in QUAYCHECK_TOKENS or created through the API, only
+// admin endpoints and container actions are. Admin endpoints are never
+// open: with no admin token, anyone could mint the first one and lock the
+// owner out, so QUAYCHECK_ADMIN_TOKEN is how a server gets its first.
+func (s *Server) authRequired(scope Scope) bool {
+	if scope == ScopeContainers || scope == ScopeAdmin {
+		return true
+	}
+	return len(s.tokens) > 0 || (s.apiTokens != nil && s.apiTokens.Len() > 0)
+}
+
+// requireScope lets a request through when its bearer token, or the token
+// its session cookie was opened with, grants scope. Session requests that
+// change state must also carry the session's CSRF token.
+func (s *Server) requireScope(scope Scope, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.authRequired(scope) {
+			next(w, r)
+			return
+		}
+		secret := bearerToken(r)
+		if _, sess, ok := s.sessions.fromRequest(r); ok && secret == "" {
+			if !checkCSRF(r, sess) {
+				writeError(w, http.StatusForbidden, "csrf", "Missing or invalid CSRF token")
+				return
+			}
+			secret = sess.secret
+		}
+		t, ok := s.authenticate(secret)
+		switch {
+		case !ok && scope == ScopeAdmin && !s.adminConfigured():
+			writeError(w, http.StatusUnauthorized, "admin_not_configured", "Set QUAYCHECK_ADMIN_TOKEN to use the admin endpoints")
+		case !ok && scope == ScopeAdmin:
+			writeError(w, http.StatusUnauthorized, "unauthorized", "Admin token required")
+		case !ok:
+			writeError(w, http.StatusUnauthorized, "unauthorized", "A valid token is required")
+		case !t.allows(scope):
+			writeError(w, http.StatusForbidden, "forbidden", localize(w, "This token lacks the %s scope", scope))
+		default:
+			next(w, r.WithContext(context.WithValue(r.Context(), actorKey{}, t.Name)))
+		}
+	}
+}
+
+type actorKey struct{}
+
+// actorFromRequest names the token a request was authorized with, empty
+// when the endpoint was open
+func actorFromRequest(r *http.Request) string {
+	name, _ := r.Context().Value(actorKey{}).(string)
+	return name
+}
+
+// authorize adds QUAYCHECK_TOKEN to requests the CLI makes to a server
+func authorize(req *http.Request) {
+	if token := os.Getenv("QUAYCHECK_TOKEN"); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseTokens(t *testing.T) {
+	tokens, err := parseTokens("dashboard:abc=ports:read; ci:def=check,suggest,reserve")
+	if err != nil || len(tokens) != 2 || tokens[1].Name != "ci" || len(tokens[1].Scopes) != 3 {
+		t.Fatalf("Unexpected tokens: %+v %v", tokens, err)
+	}
+	for _, bad := range []string{"abc=check", "ci:abc=", "ci:abc=root"} {
+		if _, err := parseTokens(bad); err == nil {
+			t.Errorf("Expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestRequireScope(t *testing.T) {
+	server := &Server{client: &MockDockerClient{}, tokens: []APIToken{
+		{Name: "dashboard", Secret: "read", Scopes: []Scope{ScopePortsRead}},
+		{Name: "root", Secret: "root", Scopes: []Scope{ScopeAdmin}},
+	}}
+	mux := SetupRouter(server)
+
+	tests := []struct {
+		path, token string
+		want        int
+	}{
+		{"/api/ports", "", 401},
+		{"/api/ports", "nope", 401},
+		{"/api/ports", "read", 200},
+		{"/api/check?port=8080", "read", 403},
+		{"/api/check?port=8080", "root", 200},
+		{"/api/capabilities", "", 200},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", tt.path, nil)
+		if tt.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if w.Code != tt.want {
+			t.Errorf("%s with %q: expected %d, got %d", tt.path, tt.token, tt.want, w.Code)
+		}
+	}
+}
+
+func TestRequireScopeOpenByDefault(t *testing.T) {
+	t.Setenv("QUAYCHECK_ADMIN_TOKEN", "s3cret")
+	mux := SetupRouter(&Server{client: &MockDockerClient{}})
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/api/check?port=8080", nil))
+	if w.Code != 200 {
+		t.Errorf("Expected non-admin endpoints to stay open with only an admin token, got %d", w.Code)
+	}
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"quaycheck/internal/storage"
+)
+
+// A backup archive is a gzipped tar holding the database and a manifest
+// describing it, so a restore can tell a truncated upload or the wrong file
+// from a real backup before touching anything
+const (
+	backupManifestName = "manifest.json"
+	backupDBName       = "quaycheck.db"
+	backupFormat       = 1
+
+	maxRestoreSize = 512 << 20
+	// maxRestoreDBSize caps the database once decompressed, as the upload
+	// limit alone lets a small gzip stream inflate to fill the disk
+	maxRestoreDBSize = 1 << 30
+)
+
+type BackupManifest struct {
+	Format        int       `json:"format"`
+	SchemaVersion int       `json:"schema_version"`
+	CreatedAt     time.Time `json:"created_at"`
+	DBSize        int64     `json:"db_size"`
+	DBSHA256      string    `json:"db_sha256"`
+}
+
+type RestoreResponse struct {
+	Restored     bool           `json:"restored"`
+	Manifest     BackupManifest `json:"manifest"`
+	Reservations int            `json:"reservations"`
+	Freezes      int            `json:"freezes"`
+	Tags         int            `json:"tags"`
+}
+
+// errInvalidBackup marks uploads that aren't a usable archive
+var errInvalidBackup = errors.New("invalid backup archive")
+
+// writeBackup snapshots db into a temp file, then writes the archive to w
+func writeBackup(r *http.Request, db *storage.DB, w io.Writer) error {
+	tmp, err := os.CreateTemp(filepath.Dir(db.Path()), ".backup-*.db")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	h := sha256.New()
+	if err := db.Backup(r.Context(), io.MultiWriter(tmp, h)); err != nil {
+		return err
+	}
+	size, err := tmp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	version, err := db.Version(r.Context())
+	if err != nil {
+		return err
+	}
+	manifest, _ := json.MarshalIndent(BackupManifest{
+		Format:        backupFormat,
+		SchemaVersion: version,
+		CreatedAt:     time.Now().UTC(),
+		DBSize:        size,
+		DBSHA256:      hex.EncodeToString(h.Sum(nil)),
+	}, "", "  ")
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	now := time.Now()
+	if err := tw.WriteHeader(&tar.Header{Name: backupManifestName, Mode: 0o644, Size: int64(len(manifest)), ModTime: now}); err != nil {
+		return err
+	}
+	if _, err := tw.Write(manifest); err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: backupDBName, Mode: 0o644, Size: size, ModTime: now}); err != nil {
+		return err
+	}
+	if _, err := io.Copy(tw, tmp); err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// readBackup extracts an archive into dir and checks the database against
+// its manifest, returning the path of the extracted database
+func readBackup(body io.Reader, dir string) (string, BackupManifest, error) {
+	var manifest BackupManifest
+	gz, err := gzip.NewReader(body)
+	if err != nil {
+		return "", manifest, fmt.Errorf("%w: not gzip: %v", errInvalidBackup, err)
+	}
+	tr := tar.NewReader(gz)
+
+	dbPath := filepath.Join(dir, backupDBName)
+	var haveManifest, haveDB bool
+	var size int64
+	h := sha256.New()
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", manifest, fmt.Errorf("%w: %v", errInvalidBackup, err)
+		}
+		switch hdr.Name {
+		case backupManifestName:
+			if err := json.NewDecoder(io.LimitReader(tr, 1<<20)).Decode(&manifest); err != nil {
+				return "", manifest, fmt.Errorf("%w: bad manifest: %v", errInvalidBackup, err)
+			}
+			haveManifest = true
+		case backupDBName:
+			if hdr.Size > maxRestoreDBSize {
+				return "", manifest, fmt.Errorf("%w: database over %d MiB", errInvalidBackup, maxRestoreDBSize>>20)
+			}
+			f, err := os.Create(dbPath)
+			if err != nil {
+				return "", manifest, err
+			}
+			// The header's size isn't trusted either: stop one byte past it
+			size, err = io.Copy(io.MultiWriter(f, h), io.LimitReader(tr, maxRestoreDBSize+1))
+			f.Close()
+			if err != nil {
+				return "", manifest, fmt.Errorf("%w: %v", errInvalidBackup, err)
+			}
+			if size > maxRestoreDBSize {
+				return "", manifest, fmt.Errorf("%w: database over %d MiB", errInvalidBackup, maxRestoreDBSize>>20)
+			}
+			haveDB = true
+		}
+	}
+
+	switch {
+	case !haveManifest || !haveDB:
+		return "", manifest, fmt.Errorf("%w: expected %s and %s", errInvalidBackup, backupManifestName, backupDBName)
+	case manifest.Format != backupFormat:
+		return "", manifest, fmt.Errorf("%w: unknown format %d", errInvalidBackup, manifest.Format)
+	case size != manifest.DBSize || hex.EncodeToString(h.Sum(nil)) != manifest.DBSHA256:
+		return "", manifest, fmt.Errorf("%w: database doesn't match its checksum", errInvalidBackup)
+	}
+	return dbPath, manifest, nil
+}
+
+// handleBackup downloads the state as an archive
+func (s *Server) handleBackup(w http.ResponseWriter, r *http.Request) {
+	if s.db == nil {
+		writeError(w, http.StatusNotImplemented, "storage_disabled", "No state database on this server")
+		return
+	}
+	// writeBackup snapshots before writing anything, so a failed snapshot
+	// still gets a proper error response
+	name := "quaycheck-" + time.Now().UTC().Format("20060102-150405") + ".tar.gz"
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	if err := writeBackup(r, s.db, w); err != nil {
+		log.Printf("Backup failed: %v", err)
+		writeError(w, http.StatusInternalServerError, "backup_failed", "Cannot back up state: "+err.Error())
+		return
+	}
+	s.audit(r, "backup", name, "")
+}
+
+// handleRestore replaces the state with an uploaded backup archive. The
+// server is read-only meanwhile, so no change lands between the check and
+// the swap and gets silently overwritten. Replacing everything, tokens
+// included, is never open: it needs an admin token to exist.
+func (s *Server) handleRestore(w http.ResponseWriter, r *http.Request) {
+	if s.db == nil {
+		writeError(w, http.StatusNotImplemented, "storage_disabled", "No state database on this server")
+		return
+	}
+	if !s.adminConfigured() {
+		writeError(w, http.StatusForbidden, "admin_not_configured", "Set QUAYCHECK_ADMIN_TOKEN to restore backups")
+		return
+	}
+	if !s.readOnly.CompareAndSwap(false, true) {
+		writeError(w, http.StatusConflict, "restore_in_progress", "Another restore is in progress")
+		return
+	}
+	defer s.readOnly.Store(false)
+
+	dir, err := os.MkdirTemp(filepath.Dir(s.db.Path()), ".restore-")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "restore_failed", "Cannot stage restore: "+err.Error())
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	path, manifest, err := readBackup(http.MaxBytesReader(w, r.Body, maxRestoreSize), dir)
+	if err == nil {
+		err = s.db.Restore(r.Context(), path)
+	}
+	switch {
+	case errors.Is(err, errInvalidBackup), errors.Is(err, storage.ErrCorrupt), errors.Is(err, storage.ErrNewerSchema):
+		writeError(w, http.StatusBadRequest, "invalid_backup", err.Error())
+		return
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, "restore_failed", "Cannot restore: "+err.Error())
+		return
+	}
+
+	if err := s.reloadStores(); err != nil {
+		writeError(w, http.StatusInternalServerError, "restore_failed", "Restored, but cannot reload state: "+err.Error())
+		return
+	}
+	s.audit(r, "restore", manifest.CreatedAt.Format(time.RFC3339), "")
+	log.Printf("Restored state from a backup taken %s", manifest.CreatedAt.Format(time.RFC3339))
+
+	resp := RestoreResponse{Restored: true, Manifest: manifest}
+	if s.reservations != nil {
+		resp.Reservations = len(s.reservations.List())
+	}
+	if s.freezes != nil {
+		resp.Freezes = len(s.freezes.List())
+	}
+	if s.tags != nil {
+		resp.Tags = len(s.tags.List())
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// reloadStores rereads the in-memory stores from the database
+func (s *Server) reloadStores() error {
+	if s.reservations != nil {
+		if err := s.reservations.Reload(); err != nil {
+			return err
+		}
+	}
+	if s.freezes != nil {
+		if err := s.freezes.Reload(); err != nil {
+			return err
+		}
+	}
+	if s.ingest != nil {
+		if err := s.ingest.Reload(); err != nil {
+			return err
+		}
+	}
+	if s.settings != nil {
+		if err := s.settings.Reload(); err != nil {
+			return err
+		}
+	}
+	if s.apiTokens != nil {
+		if err := s.apiTokens.Reload(); err != nil {
+			return err
+		}
+	}
+	if s.reassignments != nil {
+		if err := s.reassignments.Reload(); err != nil {
+			return err
+		}
+	}
+	if s.tags != nil {
+		return s.tags.Reload()
+	}
+	return nil
+}
+
+// writable wraps handlers that change state so they answer 503 while a
+// restore is running
+func (s *Server) writable(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.readOnly.Load() {
+			w.Header().Set("Retry-After", "5")
+			writeError(w, http.StatusServiceUnavailable, "read_only", "State is being restored; try again shortly")
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// testAdminToken is what adminRequest authenticates with
+const testAdminToken = "adm1n"
+
+// adminRequest is a request carrying testAdminToken, for servers started
+// with QUAYCHECK_ADMIN_TOKEN set to it
+func adminRequest(method, target string, body io.Reader) *http.Request {
+	req := httptest.NewRequest(method, target, body)
+	req.Header.Set("Authorization", "Bearer "+testAdminToken)
+	return req
+}
+
+// backupServer returns a server with its own state database and a grafana
+// reservation, and an admin token to back up and restore with
+func backupServer(t *testing.T) *Server {
+	t.Setenv("QUAYCHECK_ADMIN_TOKEN", testAdminToken)
+	db := openTestDB(t)
+	reservations, _ := NewReservationStore(db)
+	freezes, _ := NewFreezeStore(db)
+	tags, _ := NewTagStore(db)
+	reservations.Ensure(Reservation{Name: "grafana", Port: 3000}, nil, 8000, false)
+	return &Server{client: &MockDockerClient{}, reservations: reservations, freezes: freezes, tags: tags, auditLog: NewAuditLog(db), db: db}
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	src := backupServer(t)
+	src.tags.Set(PortTag{Port: 8443, Tags: []string{"legacy"}})
+	rr := httptest.NewRecorder()
+	SetupRouter(src).ServeHTTP(rr, adminRequest("GET", "/api/admin/backup", nil))
+	if rr.Code != 200 {
+		t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	archive := rr.Body.Bytes()
+
+	dst := backupServer(t)
+	dst.reservations.Ensure(Reservation{Name: "prometheus", Port: 9090}, nil, 8000, false)
+	rr = httptest.NewRecorder()
+	SetupRouter(dst).ServeHTTP(rr, adminRequest("POST", "/api/admin/restore", bytes.NewReader(archive)))
+	if rr.Code != 200 {
+		t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var resp RestoreResponse
+	json.NewDecoder(rr.Body).Decode(&resp)
+	if !resp.Restored || resp.Reservations != 1 || resp.Tags != 1 {
+		t.Errorf("Unexpected restore response: %+v", resp)
+	}
+	if _, ok := dst.reservations.Get("", "prometheus"); ok {
+		t.Error("Expected the restore to replace existing reservations")
+	}
+	if r, ok := dst.reservations.Get("", "grafana"); !ok || r.Port != 3000 {
+		t.Errorf("Expected grafana from the backup, got %+v", r)
+	}
+	if dst.readOnly.Load() {
+		t.Error("Expected read-only mode to end with the restore")
+	}
+}
+
+func TestRestoreRejectsBadArchives(t *testing.T) {
+	src := backupServer(t)
+	rr := httptest.NewRecorder()
+	SetupRouter(src).ServeHTTP(rr, adminRequest("GET", "/api/admin/backup", nil))
+
+	// Flip a byte of the database inside an otherwise valid archive
+	tampered := rewriteArchive(t, rr.Body.Bytes(), func(name string, data []byte) []byte {
+		if name == backupDBName {
+			data[len(data)/2] ^= 0xff
+		}
+		return data
+	})
+
+	for name, body := range map[string][]byte{
+		"not gzip":  []byte("hello"),
+		"truncated": rr.Body.Bytes()[:rr.Body.Len()/2],
+		"tampered":  tampered,
+	} {
+		dst := backupServer(t)
+		w := httptest.NewRecorder()
+		SetupRouter(dst).ServeHTTP(w, adminRequest("POST", "/api/admin/restore", bytes.NewReader(body)))
+		if w.Code != 400 {
+			t.Errorf("%s: expected 400, got %d: %s", name, w.Code, w.Body.String())
+		}
+		if _, ok := dst.reservations.Get("", "grafana"); !ok {
+			t.Errorf("%s: expected the existing state to be untouched", name)
+		}
+	}
+}
+
+func TestRestoreNeedsAnAdminToken(t *testing.T) {
+	server := backupServer(t)
+	rr := httptest.NewRecorder()
+	SetupRouter(server).ServeHTTP(rr, adminRequest("GET", "/api/admin/backup", nil))
+	archive := rr.Body.Bytes()
+
+	t.Setenv("QUAYCHECK_ADMIN_TOKEN", "")
+	rr = httptest.NewRecorder()
+	SetupRouter(server).ServeHTTP(rr, httptest.NewRequest("POST", "/api/admin/restore", bytes.NewReader(archive)))
+	if rr.Code != 401 {
+		t.Errorf("Expected restore refused without an admin token, got %d", rr.Code)
+	}
+}
+
+func TestRestoreRejectsOversizedDatabase(t *testing.T) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	tw.WriteHeader(&tar.Header{Name: backupDBName, Mode: 0o644, Size: maxRestoreDBSize + 1})
+	gz.Close()
+	if _, _, err := readBackup(&buf, t.TempDir()); !errors.Is(err, errInvalidBackup) {
+		t.Errorf("Expected a database over the limit refused, got %v", err)
+	}
+}
+
+func TestReadOnlyDuringRestore(t *testing.T) {
+	server := backupServer(t)
+	server.readOnly.Store(true)
+	rr := httptest.NewRecorder()
+	SetupRouter(server).ServeHTTP(rr, httptest.NewRequest("POST", "/api/reservations", bytes.NewBufferString(`{"name":"x","port":9000}`)))
+	if rr.Code != 503 || rr.Header().Get("Retry-After") == "" {
+		t.Errorf("Expected 503 with Retry-After, got %d", rr.Code)
+	}
+	rr = httptest.NewRecorder()
+	SetupRouter(server).ServeHTTP(rr, httptest.NewRequest("GET", "/api/reservations", nil))
+	if rr.Code != 200 {
+		t.Errorf("Expected reads to keep working, got %d", rr.Code)
+	}
+}
+
+// rewriteArchive passes every file of a backup archive through edit
+func rewriteArchive(t *testing.T, archive []byte, edit func(name string, data []byte) []byte) []byte {
+	gz, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gz)
+	var out bytes.Buffer
+	gzw := gzip.NewWriter(&out)
+	tw := tar.NewWriter(gzw)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(tr)
+		data = edit(hdr.Name, data)
+		hdr.Size = int64(len(data))
+		tw.WriteHeader(hdr)
+		tw.Write(data)
+	}
+	tw.Close()
+	gzw.Close()
+	return out.Bytes()
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// BatchItem is one allocation wanted from /api/suggest/batch. Port is a
+// preference: it's used when free, otherwise the first free port in
+// [Start, End] is picked.
+type BatchItem struct {
+	Name     string `json:"name"`
+	Port     int    `json:"port,omitempty"`
+	Start    int    `json:"start,omitempty"`
+	End      int    `json:"end,omitempty"`
+	Protocol string `json:"protocol,omitempty"`
+
+	// Preset fills Start and End from a named range when they're unset
+	Preset string `json:"preset,omitempty"`
+}
+
+type BatchRequest struct {
+	Items   []BatchItem `json:"items"`
+	Reserve bool        `json:"reserve,omitempty"`
+	Owner   string      `json:"owner,omitempty"`
+}
+
+type BatchAllocation struct {
+	Name     string `json:"name"`
+	Port     int    `json:"port"`
+	Protocol string `json:"protocol"`
+}
+
+type BatchResponse struct {
+	Allocations []BatchAllocation `json:"allocations"`
+	Reserved    bool              `json:"reserved"`
+	Meta        *ResponseMeta     `json:"meta,omitempty"`
+}
+
+// batchError names the item that could not be placed
+type batchError struct {
+	Name string
+	Err  error
+}
+
+func (e *batchError) Error() string { return e.Name + ": " + e.Err.Error() }
+func (e *batchError) Unwrap() error { return e.Err }
+
+// validate fills in defaults, including ranges from presets, and checks
+// each item's range
+func (req *BatchRequest) validate(presets map[string]PortRange) error {
+	if len(req.Items) == 0 {
+		return errors.New("Expected at least one item")
+	}
+	seen := make(map[string]bool, len(req.Items))
+	for i := range req.Items {
+		it := &req.Items[i]
+		if it.Name == "" {
+			return fmt.Errorf("Item %d has no name", i)
+		}
+		if seen[it.Name] {
+			return fmt.Errorf("Duplicate item name %q", it.Name)
+		}
+		seen[it.Name] = true
+
+		if it.Preset != "" {
+			pr, ok := presets[it.Preset]
+			if !ok {
+				return fmt.Errorf("Unknown preset %q for %s", it.Preset, it.Name)
+			}
+			if it.Start == 0 {
+				it.Start = pr.Start
+			}
+			if it.End == 0 {
+				it.End = pr.End
+			}
+		}
+		it.Start = suggestStart(it.Start)
+		if it.End == 0 {
+			it.End = maxPort
+		}
+		if it.End < it.Start || it.End > maxPort {
+			return fmt.Errorf("Invalid range %d-%d for %s", it.Start, it.End, it.Name)
+		}
+		if it.Port != 0 && (it.Port < it.Start || it.Port > it.End) {
+			return fmt.Errorf("Preferred port %d for %s is outside %d-%d", it.Port, it.Name, it.Start, it.End)
+		}
+		if it.Protocol == "" {
+			it.Protocol = "tcp"
+		}
+	}
+	return nil
+}
+
+// planBatch assigns every item a distinct free port, occupying them in ix as
+// it goes so later items see earlier ones. It fails as a whole if any item
+// can't be placed.
+func planBatch(ix *PortIndex, items []BatchItem) ([]BatchAllocation, error) {
+	result := make([]BatchAllocation, 0, len(items))
+	for _, it := range items {
+		port := it.Port
+		if port == 0 || ix.Used(port) {
+			port = ix.NextFree(it.Start)
+			if port > it.End {
+				port = -1
+			}
+		}
+		if port == -1 {
+			return nil, &batchError{Name: it.Name, Err: errNoFreePort}
+		}
+		ix.Occupy(port)
+		result = append(result, BatchAllocation{Name: it.Name, Port: port, Protocol: it.Protocol})
+	}
+	return result, nil
+}
+
+// ReserveBatch plans items against ix, the ports used by everything other
+// than reservations, plus the existing reservations sharing environment env,
+// and saves them all in one write. check may veto a planned port. Nothing is stored if any item
+// fails.
+func (s *ReservationStore) ReserveBatch(items []BatchItem, ix *PortIndex, owner, env string, check func(port int) error) ([]BatchAllocation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var allocs []BatchAllocation
+	err := s.updateLocked(func(reserved map[reservationKey]Reservation) (bool, error) {
+		for _, it := range items {
+			if _, exists := reserved[reservationKey{Environment: env, Name: it.Name}]; exists {
+				return false, &batchError{Name: it.Name, Err: errReservationExists}
+			}
+		}
+
+		ix := ix.Clone()
+		for _, r := range reserved {
+			if sharesEnvironment(r.Environment, env) {
+				ix.Occupy(r.Port)
+			}
+		}
+		var err error
+		if allocs, err = planBatch(ix, items); err != nil {
+			return false, err
+		}
+		if err := checkAllocations(allocs, check); err != nil {
+			return false, err
+		}
+
+		now := time.Now().UTC()
+		for _, a := range allocs {
+			reserved[reservationKey{Environment: env, Name: a.Name}] = Reservation{Name: a.Name, Port: a.Port, Protocol: a.Protocol, Owner: owner, CreatedAt: now, Revision: 1, Environment: env}
+		}
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return allocs, nil
+}
+
+func checkAllocations(allocs []BatchAllocation, check func(port int) error) error {
+	if check == nil {
+		return nil
+	}
+	for _, a := range allocs {
+		if err := check(a.Port); err != nil {
+			return &batchError{Name: a.Name, Err: err}
+		}
+	}
+	return nil
+}
+
+// writeBatchError answers for an item that couldn't be placed or reserved
+func writeBatchError(w http.ResponseWriter, err error) {
+	var be *batchError
+	var fe *frozenError
+	switch {
+	case errors.As(err, &fe) && errors.As(err, &be):
+		writeError(w, http.StatusLocked, "frozen", fe.Error()+" (needed for "+be.Name+")")
+	case errors.As(err, &be) && errors.Is(err, errNoFreePort):
+		writeError(w, http.StatusConflict, "no_free_port", "No free port for "+be.Name+" in its range")
+	case errors.As(err, &be):
+		writeError(w, http.StatusConflict, "reservation_exists", "A reservation named "+be.Name+" already exists")
+	default:
+		writeReservationError(w, err)
+	}
+}
+
+func (s *Server) handleBatchSuggest(w http.ResponseWriter, r *http.Request) {
+	var req BatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_body", "Expected a JSON body with items")
+		return
+	}
+	cfg := s.config()
+	if err := req.validate(cfg.Presets); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_body", err.Error())
+		return
+	}
+	if req.Reserve && s.reservations == nil {
+		writeError(w, http.StatusNotImplemented, "reservations_disabled", "Reservations are not enabled on this server")
+		return
+	}
+	if req.Reserve {
+		// Reserving writes reservations, so it's guarded like POST /api/reservations
+		s.writable(s.requireScope(ScopeReserve, func(w http.ResponseWriter, r *http.Request) {
+			s.batchSuggest(w, r, req, cfg)
+		}))(w, r)
+		return
+	}
+	s.batchSuggest(w, r, req, cfg)
+}
+
+// batchSuggest plans a validated batch and, when asked, reserves it
+func (s *Server) batchSuggest(w http.ResponseWriter, r *http.Request, req BatchRequest, cfg Settings) {
+	// Reservations are planned against live usage inside the store lock so
+	// concurrent batches can't hand out the same port
+	load := s.loadSnapshot
+	if req.Reserve {
+		load = s.collectScoped
+	}
+	snap, err := load(r.Context())
+	if err != nil {
+		status, code, msg := classifyDockerError(err)
+		writeError(w, status, code, msg)
+		return
+	}
+
+	var allocs []BatchAllocation
+	ix := s.pickable(snap.Index)
+	if req.Reserve {
+		allocs, err = s.reservations.ReserveBatch(req.Items, ix, req.Owner, environmentFrom(r.Context()), s.checkFrozen)
+	} else if allocs, err = planBatch(ix.Clone(), req.Items); err == nil {
+		err = checkAllocations(allocs, s.checkFrozen)
+	}
+
+	if err != nil {
+		writeBatchError(w, err)
+		return
+	}
+
+	if req.Reserve {
+		for _, a := range allocs {
+			s.audit(r, "reservation.create", a.Name, strconv.Itoa(a.Port))
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(BatchResponse{Allocations: allocs, Reserved: req.Reserve, Meta: s.snapshotMeta(snap)})
+}
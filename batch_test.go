@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+func TestPlanBatch(t *testing.T) {
+	ix := buildPortIndex([]ContainerData{{State: "running", Ports: []PortMapping{{PublicPort: 8080}, {PublicPort: 9000}}}})
+	items := []BatchItem{
+		{Name: "web", Port: 8080, Start: 8080, End: 8089},
+		{Name: "api", Port: 8081, Start: 8080, End: 8089},
+		{Name: "metrics", Start: 9000, End: 9001},
+	}
+
+	allocs, err := planBatch(ix, items)
+	if err != nil {
+		t.Fatalf("Expected a plan, got %v", err)
+	}
+	// web's 8080 is taken so it falls back to 8081, which in turn pushes
+	// api off its preference
+	if allocs[0].Port != 8081 || allocs[1].Port != 8082 || allocs[2].Port != 9001 {
+		t.Errorf("Unexpected allocations: %+v", allocs)
+	}
+
+	_, err = planBatch(buildPortIndex(nil), []BatchItem{{Name: "a", Start: 9000, End: 9000}, {Name: "b", Start: 9000, End: 9000}})
+	if be, ok := err.(*batchError); !ok || be.Name != "b" {
+		t.Errorf("Expected b to fail, got %v", err)
+	}
+}
+
+func TestHandleBatchSuggest(t *testing.T) {
+	store, _ := NewReservationStore(nil)
+	store.Ensure(Reservation{Name: "grafana", Port: 3000}, nil, 0, false)
+	server := &Server{
+		client:       &MockDockerClient{Containers: []types.Container{{State: "running", Ports: []types.Port{{PublicPort: 3001}}}}},
+		reservations: store,
+	}
+
+	body := `{"items":[{"name":"a","port":3000,"start":3000,"end":3010},{"name":"b","start":3000,"end":3010}],"reserve":true,"owner":"ci"}`
+	w := httptest.NewRecorder()
+	server.handleBatchSuggest(w, httptest.NewRequest("POST", "/api/suggest/batch", strings.NewReader(body)))
+	if w.Code != 200 {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp BatchResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	if !resp.Reserved || resp.Allocations[0].Port != 3002 || resp.Allocations[1].Port != 3003 {
+		t.Errorf("Unexpected response: %+v", resp)
+	}
+	if r, ok := store.Get("", "b"); !ok || r.Port != 3003 || r.Owner != "ci" {
+		t.Errorf("Expected b to be reserved, got %+v", r)
+	}
+
+	// All or nothing: c fits but d doesn't, so neither is stored
+	body = `{"items":[{"name":"c","start":3004,"end":3004},{"name":"d","start":3004,"end":3004}],"reserve":true}`
+	w = httptest.NewRecorder()
+	server.handleBatchSuggest(w, httptest.NewRequest("POST", "/api/suggest/batch", strings.NewReader(body)))
+	if w.Code != 409 {
+		t.Errorf("Expected 409, got %d", w.Code)
+	}
+	if _, ok := store.Get("", "c"); ok {
+		t.Error("Expected nothing to be reserved after a failed batch")
+	}
+
+	w = httptest.NewRecorder()
+	server.handleBatchSuggest(w, httptest.NewRequest("POST", "/api/suggest/batch", strings.NewReader(`{"items":[{"name":"x","port":80,"start":8000}]}`)))
+	if w.Code != 400 {
+		t.Errorf("Expected 400 for a preferred port outside the range, got %d", w.Code)
+	}
+}
+
+func TestBatchReserveNeedsReserveScope(t *testing.T) {
+	store, _ := NewReservationStore(nil)
+	server := &Server{client: &MockDockerClient{}, reservations: store, tokens: []APIToken{
+		{Name: "ci", Secret: "suggest", Scopes: []Scope{ScopeSuggest}},
+		{Name: "deploy", Secret: "reserve", Scopes: []Scope{ScopeSuggest, ScopeReserve}},
+	}}
+	mux := SetupRouter(server)
+
+	post := func(token, body string) int {
+		req := httptest.NewRequest("POST", "/api/suggest/batch", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w.Code
+	}
+	if code := post("suggest", `{"items":[{"name":"a","start":3000}]}`); code != 200 {
+		t.Errorf("Expected a suggest token to plan a batch, got %d", code)
+	}
+	if code := post("suggest", `{"items":[{"name":"a","start":3000}],"reserve":true}`); code != 403 {
+		t.Errorf("Expected a suggest token to be refused a reserving batch, got %d", code)
+	}
+	if _, ok := store.Get("", "a"); ok {
+		t.Fatal("Expected nothing reserved by a suggest-only token")
+	}
+	if code := post("reserve", `{"items":[{"name":"a","start":3000}],"reserve":true}`); code != 200 {
+		t.Errorf("Expected a reserve token to reserve, got %d", code)
+	}
+}
+
+func TestBatchSkipsRecentlyFreed(t *testing.T) {
+	server := &Server{client: &MockDockerClient{}, freed: newFreedPorts(), freedCooldown: 10 * time.Minute}
+	server.reservations, _ = NewReservationStore(nil)
+	server.freed.record([]PortEvent{{Type: EventPortFreed, Port: 8000, Time: time.Now()}})
+
+	for _, reserve := range []string{"false", "true"} {
+		w := httptest.NewRecorder()
+		body := `{"items":[{"name":"web` + reserve + `","start":8000,"end":8010}],"reserve":` + reserve + `}`
+		server.handleBatchSuggest(w, httptest.NewRequest("POST", "/api/suggest/batch", strings.NewReader(body)))
+		var resp BatchResponse
+		json.NewDecoder(w.Body).Decode(&resp)
+		if w.Code != 200 || len(resp.Allocations) != 1 || resp.Allocations[0].Port != 8001 {
+			t.Errorf("Expected a batch with reserve=%s to skip 8000, got %d %+v", reserve, w.Code, resp)
+		}
+	}
+}
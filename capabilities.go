@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+)
+
+// CapabilitiesResponse tells clients which optional parts of the API this
+// server has, so they can hide what's missing instead of probing for 404s
+type CapabilitiesResponse struct {
+	// Features maps each optional subsystem to whether it's enabled
+	Features map[string]bool `json:"features"`
+
+	// Sources lists the inventory sources by name, docker first
+	Sources []string `json:"sources"`
+
+	Languages []string `json:"languages"`
+}
+
+func (s *Server) capabilities() CapabilitiesResponse {
+	probing, shared := false, false
+	sources := []string{"docker"}
+	if s.offline != nil {
+		sources = []string{"snapshot"}
+	}
+	for _, src := range s.sources {
+		sources = append(sources, src.Name())
+		if _, ok := src.(*ProbeSource); ok {
+			probing = true
+		}
+	}
+	if s.reservations != nil {
+		sources = append(sources, s.reservations.Name())
+		_, shared = s.reservations.backend.(*redisReservations)
+	}
+
+	return CapabilitiesResponse{
+		Features: map[string]bool{
+			"reservations": s.reservations != nil,
+			// reservations live in Redis, shared with other instances
+			"shared_reservations": shared,
+			"freezes":             s.freezes != nil,
+			"tags":                s.tags != nil,
+			"history":             s.history != nil,
+			"traffic":             s.traffic != nil,
+			"audit":               s.db != nil,
+			"event_log":           s.eventLog != nil,
+			"backup":              s.db != nil,
+			"settings":            s.settings != nil,
+			"tokens":              s.apiTokens != nil,
+			"sessions":            s.sessions != nil,
+			"events":              s.events != nil,
+			"ingest":              s.ingest != nil && s.ingestToken != "",
+			// probed hosts show up next to the local ones
+			"probing":         probing,
+			"multi_host":      probing,
+			"admin_auth":      os.Getenv("QUAYCHECK_ADMIN_TOKEN") != "",
+			"leader_election": s.leader != nil,
+			"environments":    len(s.environments) > 0,
+			// stop and restart the container publishing a port
+			"container_actions": s.containerActions,
+			"reassign":          s.reassignments != nil,
+			// proxy configs to check upstreams of
+			"upstream_validation": len(s.proxyConfigs) > 0,
+			"dns_updates":         s.dnsUpdater != nil,
+			"mdns":                s.mdns != nil,
+			"reachability":        s.reachability != nil,
+			"connection_attempts": s.attempts != nil,
+			"router":              s.routerSource() != nil,
+			// serving an exported snapshot rather than a live inventory
+			"offline": s.offline != nil,
+		},
+		Sources:   sources,
+		Languages: supportedLanguages,
+	}
+}
+
+func (s *Server) handleCapabilities(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.capabilities())
+}
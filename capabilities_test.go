@@ -0,0 +1,30 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestCapabilities(t *testing.T) {
+	reservations, _ := NewReservationStore(nil)
+	server := &Server{
+		client:       &MockDockerClient{},
+		reservations: reservations,
+		sources:      []PortSource{&ProbeSource{}},
+	}
+	rr := httptest.NewRecorder()
+	SetupRouter(server).ServeHTTP(rr, httptest.NewRequest("GET", "/api/capabilities", nil))
+
+	var resp CapabilitiesResponse
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("Expected JSON, got %v", err)
+	}
+	if !resp.Features["reservations"] || !resp.Features["probing"] || resp.Features["history"] || resp.Features["ingest"] {
+		t.Errorf("Unexpected features: %v", resp.Features)
+	}
+	if !slices.Equal(resp.Sources, []string{"docker", "remote", "reservation"}) {
+		t.Errorf("Unexpected sources: %v", resp.Sources)
+	}
+}
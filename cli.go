@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"time"
+)
+
+// cliContext bounds a command's requests to 30 seconds and cancels them on
+// Ctrl-C, so an interrupted command doesn't leave a request hanging
+func cliContext() (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	return ctx, func() {
+		cancel()
+		stop()
+	}
+}
+
+// fetchContainers reads the inventory from a quaycheck server, or straight
+// from the local Docker daemon when no server is given
+func fetchContainers(ctx context.Context, server string) ([]ContainerData, error) {
+	if server == "" {
+		cli, err := NewDockerClient()
+		if err != nil {
+			return nil, err
+		}
+		return (&Server{client: cli, sources: sourcesFromEnv()}).getContainers(ctx)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", strings.TrimRight(server, "/")+"/api/ports", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", mimeMsgpack+", application/json;q=0.9")
+	authorize(req)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var e ErrorResponse
+		json.NewDecoder(resp.Body).Decode(&e)
+		return nil, fmt.Errorf("%s: %s", resp.Status, e.Message)
+	}
+	var containers []ContainerData
+	if err := decodeResponse(resp, &containers); err != nil {
+		return nil, err
+	}
+	return containers, nil
+}
+
+func defaultComposeFile() string {
+	for _, name := range []string{"compose.yaml", "compose.yml", "docker-compose.yaml", "docker-compose.yml"} {
+		if _, err := os.Stat(name); err == nil {
+			return name
+		}
+	}
+	return "compose.yaml"
+}
+
+// ciFormat picks the annotation format for the CI system we are running in
+func ciFormat() string {
+	switch {
+	case os.Getenv("GITHUB_ACTIONS") == "true":
+		return "github"
+	case os.Getenv("GITLAB_CI") == "true":
+		return "gitlab"
+	}
+	return "text"
+}
+
+// runCheck implements `quaycheck check`: it exits 1 when the compose file
+// publishes a port that is already taken, 2 when the check itself failed
+func runCheck(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("check", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	file := fs.String("f", "", "compose file, or a .container, .pod or .service unit (default: compose.yaml or docker-compose.yml)")
+	server := fs.String("server", os.Getenv("QUAYCHECK_SERVER"), "quaycheck URL to query instead of the local Docker daemon")
+	ci := fs.Bool("ci", false, "emit CI annotations, auto-detecting GitHub Actions or GitLab")
+	format := fs.String("format", "text", "output format: text, github or gitlab")
+	ignoreProject := fs.String("ignore-project", "", "compose project whose running containers are about to be recreated")
+	stack := fs.Bool("stack", false, "read a docker stack file: ingress ports are checked on every host, host-mode ones where placement allows")
+	var profiles []string
+	fs.Func("profile", "compose profile to enable, repeatable (default: $COMPOSE_PROFILES)", func(v string) error {
+		profiles = append(profiles, v)
+		return nil
+	})
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *file == "" {
+		*file = fs.Arg(0)
+	}
+	if *file == "" {
+		*file = defaultComposeFile()
+	}
+	if *ci {
+		*format = ciFormat()
+	}
+	if profiles == nil {
+		profiles = queryList([]string{os.Getenv("COMPOSE_PROFILES")})
+	}
+
+	data, err := os.ReadFile(*file)
+	if err != nil {
+		fmt.Fprintf(stderr, "quaycheck: %v\n", err)
+		return 2
+	}
+	parse := func(data []byte) ([]ComposePort, error) { return ParseCompose(data, profiles) }
+	switch {
+	case *stack:
+		parse = ParseStack
+	case isUnitFile(*file):
+		parse = func(data []byte) ([]ComposePort, error) { return ParseUnit(*file, data) }
+	}
+	ports, err := parse(data)
+	if err != nil {
+		fmt.Fprintf(stderr, "quaycheck: %s: %v\n", *file, err)
+		return 2
+	}
+
+	ctx, cancel := cliContext()
+	defer cancel()
+	containers, err := fetchContainers(ctx, *server)
+	if err != nil {
+		fmt.Fprintf(stderr, "quaycheck: cannot list containers: %v\n", err)
+		return 2
+	}
+
+	if *ignoreProject != "" {
+		containers = slices.DeleteFunc(containers, func(c ContainerData) bool { return c.Project == *ignoreProject })
+	}
+
+	var conflicts []ComposeConflict
+	if *stack {
+		conflicts = FindStackConflicts(ports, containers, inventoryHosts(containers))
+	} else {
+		conflicts = FindComposeConflicts(ports, containers)
+	}
+	switch *format {
+	case "github":
+		writeGitHubAnnotations(stdout, *file, conflicts)
+	case "gitlab":
+		writeGitLabReport(stdout, *file, conflicts)
+	default:
+		writeTextReport(stdout, *file, conflicts)
+	}
+
+	if len(conflicts) > 0 {
+		return 1
+	}
+	return 0
+}
+
+func writeTextReport(w io.Writer, file string, conflicts []ComposeConflict) {
+	if len(conflicts) == 0 {
+		fmt.Fprintf(w, "%s: no port conflicts\n", file)
+		return
+	}
+	for _, c := range conflicts {
+		fmt.Fprintf(w, "%s:%d: %s\n", file, c.Port.Line, c.Reason)
+	}
+}
+
+// writeGitHubAnnotations emits workflow commands that GitHub Actions renders
+// inline on the pull request diff
+func writeGitHubAnnotations(w io.Writer, file string, conflicts []ComposeConflict) {
+	for _, c := range conflicts {
+		fmt.Fprintf(w, "::error file=%s,line=%d,title=Port conflict::%s\n", file, c.Port.Line, c.Reason)
+	}
+}
+
+// gitLabIssue is one entry of a GitLab Code Quality report
+type gitLabIssue struct {
+	Description string `json:"description"`
+	CheckName   string `json:"check_name"`
+	Fingerprint string `json:"fingerprint"`
+	Severity    string `json:"severity"`
+	Location    struct {
+		Path  string `json:"path"`
+		Lines struct {
+			Begin int `json:"begin"`
+		} `json:"lines"`
+	} `json:"location"`
+}
+
+func writeGitLabReport(w io.Writer, file string, conflicts []ComposeConflict) {
+	issues := []gitLabIssue{}
+	for _, c := range conflicts {
+		sum := sha1.Sum([]byte(fmt.Sprintf("%s:%s:%d/%s", file, c.Port.Service, c.Port.PublicPort, c.Port.Type)))
+		issue := gitLabIssue{
+			Description: c.Reason,
+			CheckName:   "port-conflict",
+			Fingerprint: hex.EncodeToString(sum[:]),
+			Severity:    "major",
+		}
+		issue.Location.Path = file
+		issue.Location.Lines.Begin = c.Port.Line
+		issues = append(issues, issue)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(issues)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+)
+
+func TestRunCheck(t *testing.T) {
+	agent := &Server{client: &MockDockerClient{Containers: []types.Container{
+		{Names: []string{"/nginx"}, State: "running", Labels: map[string]string{composeProjectLabel: "site"}, Ports: []types.Port{{PublicPort: 8080}}},
+	}}}
+	ts := httptest.NewServer(SetupRouter(agent))
+	defer ts.Close()
+
+	file := filepath.Join(t.TempDir(), "compose.yaml")
+	os.WriteFile(file, []byte(composeFile), 0o644)
+
+	var stdout, stderr bytes.Buffer
+	code := runCheck([]string{"-server", ts.URL, "-format", "github", file}, &stdout, &stderr)
+	if code != 1 {
+		t.Fatalf("Expected exit code 1, got %d (stderr: %s)", code, stderr.String())
+	}
+	if !strings.HasPrefix(stdout.String(), "::error file="+file+",line=5,title=Port conflict::port 8080") {
+		t.Errorf("Unexpected annotations: %s", stdout.String())
+	}
+
+	stdout.Reset()
+	runCheck([]string{"-server", ts.URL, "-format", "gitlab", "-f", file}, &stdout, &stderr)
+	var issues []gitLabIssue
+	if err := json.Unmarshal(stdout.Bytes(), &issues); err != nil {
+		t.Fatalf("Expected valid JSON report, got %v", err)
+	}
+	if len(issues) != 2 || issues[0].Location.Lines.Begin != 5 || issues[0].Fingerprint == "" {
+		t.Errorf("Unexpected GitLab report: %+v", issues)
+	}
+
+	stdout.Reset()
+	runCheck([]string{"-server", ts.URL, "-format", "gitlab", "-ignore-project", "site", file}, &stdout, &stderr)
+	issues = nil
+	json.Unmarshal(stdout.Bytes(), &issues)
+	if len(issues) != 1 {
+		t.Errorf("Expected only the in-file clash with the project ignored, got %+v", issues)
+	}
+
+	if code := runCheck([]string{"-server", ts.URL, "/nonexistent.yaml"}, &stdout, &stderr); code != 2 {
+		t.Errorf("Expected exit code 2 for missing file, got %d", code)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+)
+
+// trustedProxiesFromEnv reads QUAYCHECK_TRUSTED_PROXIES, the reverse
+// proxies whose X-Forwarded-For, X-Real-IP and X-Forwarded-Proto headers
+// are believed: addresses or CIDRs, comma-separated
+func trustedProxiesFromEnv() []netip.Prefix {
+	var prefixes []netip.Prefix
+	for _, item := range queryList([]string{os.Getenv("QUAYCHECK_TRUSTED_PROXIES")}) {
+		prefix, err := netip.ParsePrefix(item)
+		if err != nil {
+			addr, aerr := netip.ParseAddr(item)
+			if aerr != nil {
+				log.Fatalf("Invalid QUAYCHECK_TRUSTED_PROXIES entry %q: expected an address or a CIDR", item)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes
+}
+
+func (s *Server) trustedProxy(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, p := range s.trustedProxies {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteAddr is the address r came from, without its port
+func remoteAddr(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// clientIP is the address of the client behind r. Forwarding headers only
+// count when r comes from a trusted proxy: X-Forwarded-For is read from the
+// right, skipping the proxies it went through, so a client can't slip in
+// an address of its own choosing ahead of them.
+func (s *Server) clientIP(r *http.Request) string {
+	remote := remoteAddr(r)
+	addr, err := netip.ParseAddr(remote)
+	if err != nil || !s.trustedProxy(addr) {
+		return remote
+	}
+
+	var hops []string
+	for _, v := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(v, ",")...)
+	}
+	client := ""
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		client = hop.Unmap().String()
+		if !s.trustedProxy(hop) {
+			return client
+		}
+	}
+	if client != "" {
+		return client
+	}
+	if real, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP"))); err == nil {
+		return real.Unmap().String()
+	}
+	return remote
+}
+
+// isHTTPS reports whether the client reached quaycheck over TLS, directly
+// or through a trusted proxy that says so in X-Forwarded-Proto
+func (s *Server) isHTTPS(r *http.Request) bool {
+	if r.TLS != nil {
+		return true
+	}
+	addr, err := netip.ParseAddr(remoteAddr(r))
+	return err == nil && s.trustedProxy(addr) && strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	server := &Server{trustedProxies: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("::1/128")}}
+	tests := []struct {
+		remote, xff, realIP, want string
+	}{
+		{"192.0.2.1:5000", "", "", "192.0.2.1"},
+		// Headers from untrusted peers are ignored
+		{"192.0.2.1:5000", "203.0.113.9", "203.0.113.8", "192.0.2.1"},
+		{"10.0.0.2:5000", "203.0.113.9", "", "203.0.113.9"},
+		// A forged hop ahead of the real client doesn't win
+		{"10.0.0.2:5000", "1.2.3.4, 203.0.113.9, 10.0.0.3", "", "203.0.113.9"},
+		{"10.0.0.2:5000", "10.0.0.4, 10.0.0.3", "", "10.0.0.4"},
+		{"10.0.0.2:5000", "", "203.0.113.8", "203.0.113.8"},
+		{"10.0.0.2:5000", "garbage", "", "10.0.0.2"},
+		{"[::1]:5000", "2001:db8::7", "", "2001:db8::7"},
+		{"[::ffff:10.0.0.2]:5000", "::ffff:203.0.113.9", "", "203.0.113.9"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = tt.remote
+		if tt.xff != "" {
+			r.Header.Set("X-Forwarded-For", tt.xff)
+		}
+		if tt.realIP != "" {
+			r.Header.Set("X-Real-IP", tt.realIP)
+		}
+		if got := server.clientIP(r); got != tt.want {
+			t.Errorf("%s with %q/%q: expected %s, got %s", tt.remote, tt.xff, tt.realIP, tt.want, got)
+		}
+	}
+}
+
+func TestIsHTTPS(t *testing.T) {
+	server := &Server{trustedProxies: []netip.Prefix{netip.MustParsePrefix("10.0.0.1/32")}}
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "192.0.2.1:5000"
+	r.Header.Set("X-Forwarded-Proto", "https")
+	if server.isHTTPS(r) {
+		t.Error("Expected X-Forwarded-Proto from an untrusted peer to be ignored")
+	}
+	r.RemoteAddr = "10.0.0.1:5000"
+	if !server.isHTTPS(r) {
+		t.Error("Expected X-Forwarded-Proto from a trusted proxy to count")
+	}
+	r = httptest.NewRequest("GET", "/", nil)
+	r.TLS = &tls.ConnectionState{}
+	if !server.isHTTPS(r) {
+		t.Error("Expected a TLS request to be HTTPS")
+	}
+}
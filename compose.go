@@ -0,0 +1,415 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+	"quaycheck/internal/portspec"
+)
+
+// ComposePort is a host port, or a range of them, published by a compose
+// service
+type ComposePort struct {
+	PortMapping
+	// PublicPortEnd closes a range such as 8000-8010:8000-8010, and
+	// PrivatePortEnd its container side unless the whole range goes to one
+	// container port
+	PublicPortEnd  uint16 `json:"public_port_end,omitempty"`
+	PrivatePortEnd uint16 `json:"private_port_end,omitempty"`
+	Service        string `json:"service"`
+	Line           int    `json:"line"`
+	// Replica is which replica of a scaled service publishes the port
+	Replica int `json:"replica,omitempty"`
+	// Pool is set on a range published to a single container port: each
+	// replica binds whichever port of the range is free, so Pool of them
+	// are needed, not all
+	Pool int `json:"pool,omitempty"`
+	// Mode and Constraints only come with stack files: an ingress port is
+	// published on every swarm node, a host one where the service's
+	// placement constraints let its tasks run
+	Mode        string   `json:"mode,omitempty"`
+	Constraints []string `json:"constraints,omitempty"`
+}
+
+// last is the highest host port p covers
+func (p ComposePort) last() int {
+	return max(int(p.PublicPort), int(p.PublicPortEnd))
+}
+
+// describe names the host ports p covers, for conflict reasons
+func (p ComposePort) describe() string {
+	if p.last() > int(p.PublicPort) {
+		return fmt.Sprintf("ports %d-%d", p.PublicPort, p.last())
+	}
+	return fmt.Sprintf("port %d", p.PublicPort)
+}
+
+// ComposeConflict explains why a published compose port cannot be used
+type ComposeConflict struct {
+	Port   ComposePort `json:"port"`
+	Code   string      `json:"code"`
+	Reason string      `json:"reason"`
+	// Ports are the conflicting ports of a range
+	Ports []PortRange `json:"ports,omitempty"`
+	// Hosts are the nodes a stack file's port conflicts on
+	Hosts []string `json:"hosts,omitempty"`
+}
+
+type composeLongPort struct {
+	Target    string `yaml:"target"`
+	Published string `yaml:"published"`
+	HostIP    string `yaml:"host_ip"`
+	Protocol  string `yaml:"protocol"`
+	Mode      string `yaml:"mode"`
+}
+
+// ParseCompose extracts published ports from a compose file, keeping the line
+// each one was declared on so CI annotations can point at it. Services with
+// profiles count only when one of them is in profiles ("*" enables them
+// all), as with docker compose --profile. Scaled services publish their
+// fixed ports once per replica.
+func ParseCompose(data []byte, profiles []string) ([]ComposePort, error) {
+	return parseCompose(data, profiles, false)
+}
+
+// parseCompose reads compose files, or stack files when swarm is set:
+// those ignore profiles, and publish a port once per service rather than
+// once per replica, on the nodes its mode and placement allow
+func parseCompose(data []byte, profiles []string, swarm bool) ([]ComposePort, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 {
+		return nil, nil
+	}
+
+	services := mappingValue(doc.Content[0], "services")
+	if services == nil || services.Kind != yaml.MappingNode {
+		return nil, nil
+	}
+
+	var result []ComposePort
+	for i := 0; i+1 < len(services.Content); i += 2 {
+		name, service := services.Content[i].Value, services.Content[i+1]
+		ports := mappingValue(service, "ports")
+		if ports == nil || ports.Kind != yaml.SequenceNode || (!swarm && !profileEnabled(service, profiles)) {
+			continue
+		}
+		replicas, err := serviceReplicas(service)
+		if err != nil {
+			return nil, fmt.Errorf("service %s: %w", name, err)
+		}
+
+		var constraints []string
+		if swarm {
+			constraints, err = placementConstraints(service)
+			if err != nil {
+				return nil, fmt.Errorf("service %s: %w", name, err)
+			}
+		}
+
+		for _, item := range ports.Content {
+			var p ComposePort
+			var err error
+			mode := "ingress"
+			switch item.Kind {
+			case yaml.ScalarNode:
+				p, err = parsePortSpec(item.Value)
+			case yaml.MappingNode:
+				var long composeLongPort
+				if err = item.Decode(&long); err == nil {
+					p, err = parseLongPort(long)
+				}
+				if long.Mode != "" {
+					mode = long.Mode
+				}
+			default:
+				err = fmt.Errorf("unsupported port entry")
+			}
+			if err != nil {
+				return nil, fmt.Errorf("line %d: service %s: %w", item.Line, name, err)
+			}
+			if p.PublicPort == 0 || replicas == 0 {
+				continue
+			}
+			p.Service, p.Line = name, item.Line
+			if swarm {
+				if mode != "ingress" && mode != "host" {
+					return nil, fmt.Errorf("line %d: service %s: invalid port mode %q", item.Line, name, mode)
+				}
+				p.Mode, p.Constraints = mode, constraints
+				if p.PublicPortEnd != 0 && p.PrivatePortEnd == 0 {
+					p.Pool = 1
+				}
+				result = append(result, p)
+				continue
+			}
+
+			// A range published to one container port is shared by the
+			// replicas, a fixed port would be bound by each of them
+			if p.PublicPortEnd != 0 && p.PrivatePortEnd == 0 {
+				p.Pool = replicas
+				result = append(result, p)
+				continue
+			}
+			for r := 1; r <= replicas; r++ {
+				if replicas > 1 {
+					p.Replica = r
+				}
+				result = append(result, p)
+			}
+		}
+	}
+	return result, nil
+}
+
+// profileEnabled reports whether a service runs with the given profiles
+// active: always when it has none of its own
+func profileEnabled(service *yaml.Node, active []string) bool {
+	node := mappingValue(service, "profiles")
+	if node == nil || node.Kind != yaml.SequenceNode || len(node.Content) == 0 {
+		return true
+	}
+	for _, p := range node.Content {
+		for _, a := range active {
+			if a == "*" || a == p.Value {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// serviceReplicas is how many containers compose starts for a service:
+// deploy.replicas, else the older scale, else one
+func serviceReplicas(service *yaml.Node) (int, error) {
+	node := mappingValue(mappingValue(service, "deploy"), "replicas")
+	if node == nil {
+		node = mappingValue(service, "scale")
+	}
+	if node == nil {
+		return 1, nil
+	}
+	n, err := strconv.Atoi(node.Value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("line %d: invalid replica count %q", node.Line, node.Value)
+	}
+	return n, nil
+}
+
+// placementConstraints reads deploy.placement.constraints
+func placementConstraints(service *yaml.Node) ([]string, error) {
+	node := mappingValue(mappingValue(mappingValue(service, "deploy"), "placement"), "constraints")
+	if node == nil {
+		return nil, nil
+	}
+	var constraints []string
+	if err := node.Decode(&constraints); err != nil {
+		return nil, fmt.Errorf("line %d: invalid placement constraints", node.Line)
+	}
+	return constraints, nil
+}
+
+// ComposeDeclaredPorts returns each service's ports: entries as written,
+// the long syntax in YAML flow style
+func ComposeDeclaredPorts(data []byte) (map[string][]string, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	result := make(map[string][]string)
+	if len(doc.Content) == 0 {
+		return result, nil
+	}
+	services := mappingValue(doc.Content[0], "services")
+	if services == nil || services.Kind != yaml.MappingNode {
+		return result, nil
+	}
+	for i := 0; i+1 < len(services.Content); i += 2 {
+		ports := mappingValue(services.Content[i+1], "ports")
+		if ports == nil || ports.Kind != yaml.SequenceNode {
+			continue
+		}
+		name := services.Content[i].Value
+		for _, item := range ports.Content {
+			if item.Kind == yaml.ScalarNode {
+				result[name] = append(result[name], item.Value)
+				continue
+			}
+			item.Style = yaml.FlowStyle
+			out, err := yaml.Marshal(item)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: service %s: %w", item.Line, name, err)
+			}
+			result[name] = append(result[name], strings.TrimSpace(string(out)))
+		}
+	}
+	return result, nil
+}
+
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// parsePortSpec parses the docker run / compose short syntax:
+// [[host_ip:]published:]target[/protocol]. Ports without a published side
+// are not bound on the host and come back with no PublicPort.
+func parsePortSpec(spec string) (ComposePort, error) {
+	m, err := portspec.Parse(spec)
+	return composePort(m), err
+}
+
+func parseLongPort(p composeLongPort) (ComposePort, error) {
+	proto := strings.ToLower(p.Protocol)
+	if proto == "" {
+		proto = "tcp"
+	}
+	m, err := portspec.Pair(p.HostIP, p.Published, p.Target, proto)
+	return composePort(m), err
+}
+
+// composePort turns a parsed mapping into the entry checked against the
+// inventory, keeping a range as one entry
+func composePort(m portspec.Mapping) ComposePort {
+	return ComposePort{
+		PortMapping: PortMapping{
+			PublicPort:  uint16(m.Published),
+			PrivatePort: uint16(m.Target),
+			Type:        m.Protocol,
+			IP:          m.HostIP,
+		},
+		PublicPortEnd:  uint16(m.PublishedEnd),
+		PrivatePortEnd: uint16(m.TargetEnd),
+	}
+}
+
+// ownerLabel names c, noting its state when it isn't simply running
+func ownerLabel(c ContainerData) string {
+	if c.State == "running" || c.State == "reserved" {
+		return containerName(c)
+	}
+	return containerName(c) + " (" + c.State + ")"
+}
+
+// portOwners maps every used host port to the name of the entry holding it
+func portOwners(containers []ContainerData) map[int]string {
+	owners := make(map[int]string)
+	for _, c := range containers {
+		if !occupiesPorts(c.State) {
+			continue
+		}
+		for _, p := range c.Ports {
+			if p.PublicPort == 0 {
+				continue
+			}
+			if _, taken := owners[int(p.PublicPort)]; !taken {
+				owners[int(p.PublicPort)] = ownerLabel(c)
+			}
+		}
+	}
+	return owners
+}
+
+// FindComposeConflicts reports compose ports that are already in use, or that
+// two services (or two replicas of one) in the same file both try to
+// publish. A range shared by replicas only conflicts when fewer of its ports
+// are free than there are replicas.
+func FindComposeConflicts(ports []ComposePort, containers []ContainerData) []ComposeConflict {
+	owners := portOwners(containers)
+	claimed := make(map[string]ComposePort)
+	key := func(port int, proto string) string { return strconv.Itoa(port) + "/" + proto }
+
+	var conflicts []ComposeConflict
+	for _, p := range ports {
+		if p.Pool > 0 {
+			var free []int
+			for port := int(p.PublicPort); port <= p.last() && len(free) < p.Pool; port++ {
+				_, owned := owners[port]
+				if _, taken := claimed[key(port, p.Type)]; !owned && !taken {
+					free = append(free, port)
+				}
+			}
+			if len(free) < p.Pool {
+				conflicts = append(conflicts, ComposeConflict{
+					Port:   p,
+					Code:   "port_range_exhausted",
+					Reason: fmt.Sprintf("%s (service %s) have %d free for %d replicas", p.describe(), p.Service, len(free), p.Pool),
+				})
+				continue
+			}
+			for _, port := range free {
+				claimed[key(port, p.Type)] = p
+			}
+			continue
+		}
+
+		var inUse, twice []int
+		var holders []string
+		var other ComposePort
+		for port := int(p.PublicPort); port <= p.last(); port++ {
+			if owner, ok := owners[port]; ok {
+				inUse = append(inUse, port)
+				if !slices.Contains(holders, owner) {
+					holders = append(holders, owner)
+				}
+			} else if c, ok := claimed[key(port, p.Type)]; ok && (c.Service != p.Service || c.Replica != p.Replica) {
+				twice, other = append(twice, port), c
+			}
+		}
+		switch {
+		case len(inUse) > 0:
+			conflicts = append(conflicts, rangeConflict(p, "port_in_use", inUse,
+				"is already in use by "+strings.Join(holders, ", "), "already in use by "+strings.Join(holders, ", ")))
+		case len(twice) > 0 && other.Service != p.Service:
+			conflicts = append(conflicts, rangeConflict(p, "port_published_twice", twice,
+				"is also published by service "+other.Service, "also published by service "+other.Service))
+		case len(twice) > 0:
+			conflicts = append(conflicts, rangeConflict(p, "port_published_twice", twice,
+				"is published by each of its replicas", "published by each of its replicas"))
+		default:
+			for port := int(p.PublicPort); port <= p.last(); port++ {
+				claimed[key(port, p.Type)] = p
+			}
+		}
+	}
+	return conflicts
+}
+
+// rangeConflict builds a conflict on p. A single port reads "port 8080
+// (service web) <single>"; a range lists which of its ports conflict.
+func rangeConflict(p ComposePort, code string, ports []int, single, ofRange string) ComposeConflict {
+	c := ComposeConflict{Port: p, Code: code}
+	if p.last() == int(p.PublicPort) {
+		c.Reason = fmt.Sprintf("%s (service %s) %s", p.describe(), p.Service, single)
+		return c
+	}
+	var spans []string
+	for _, port := range ports {
+		if n := len(c.Ports); n > 0 && c.Ports[n-1].End == port-1 {
+			c.Ports[n-1].End = port
+		} else {
+			c.Ports = append(c.Ports, PortRange{port, port})
+		}
+	}
+	for _, r := range c.Ports {
+		if r.Start == r.End {
+			spans = append(spans, strconv.Itoa(r.Start))
+		} else {
+			spans = append(spans, fmt.Sprintf("%d-%d", r.Start, r.End))
+		}
+	}
+	c.Reason = fmt.Sprintf("%s (service %s) include %s, %s", p.describe(), p.Service, strings.Join(spans, ", "), ofRange)
+	return c
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"testing"
+)
+
+const composeFile = `services:
+  web:
+    image: nginx
+    ports:
+      - "8080:80"
+      - "127.0.0.1:8443:443/tcp"
+      - "9000"
+  dns:
+    image: coredns
+    ports:
+      - target: 53
+        published: "5353"
+        protocol: udp
+  api:
+    image: api
+    ports:
+      - "8443:8443"
+      - "7000-7001:7000-7001"
+`
+
+func TestParseCompose(t *testing.T) {
+	ports, err := ParseCompose([]byte(composeFile), nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(ports) != 5 {
+		t.Fatalf("Expected 5 published ports, got %d: %+v", len(ports), ports)
+	}
+
+	web := ports[1]
+	if web.Service != "web" || web.PublicPort != 8443 || web.IP != "127.0.0.1" || web.Line != 6 {
+		t.Errorf("Unexpected web port: %+v", web)
+	}
+	dns := ports[2]
+	if dns.Service != "dns" || dns.PublicPort != 5353 || dns.PrivatePort != 53 || dns.Type != "udp" {
+		t.Errorf("Unexpected dns port: %+v", dns)
+	}
+	if ports[4].PublicPort != 7000 || ports[4].PublicPortEnd != 7001 || ports[4].PrivatePortEnd != 7001 {
+		t.Errorf("Expected the range as one entry, got %+v", ports[4])
+	}
+}
+
+func TestParsePortSpec(t *testing.T) {
+	tests := []struct {
+		spec    string
+		public  uint16
+		end     uint16
+		ip      string
+		wantErr bool
+	}{
+		{"80", 0, 0, "", false},
+		{"8080:80", 8080, 0, "", false},
+		{"[::1]:8080:80/udp", 8080, 0, "::1", false},
+		{"127.0.0.1::80", 0, 0, "", false},
+		{"8000-8002:80-82", 8000, 8002, "", false},
+		{"8000-8002:80-81", 0, 0, "", true},
+		{"abc:80", 0, 0, "", true},
+		{"1:2:3:4", 0, 0, "", true},
+	}
+
+	for _, tt := range tests {
+		port, err := parsePortSpec(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q: expected error=%v, got %v", tt.spec, tt.wantErr, err)
+			continue
+		}
+		if port.PublicPort != tt.public || port.PublicPortEnd != tt.end || port.IP != tt.ip {
+			t.Errorf("%q: unexpected mapping %+v", tt.spec, port)
+		}
+	}
+}
+
+func TestFindComposeConflicts(t *testing.T) {
+	ports, _ := ParseCompose([]byte(composeFile), nil)
+	containers := []ContainerData{
+		{Names: []string{"/nginx"}, State: "running", Ports: []PortMapping{{PublicPort: 8080}}},
+		{Names: []string{"/old"}, State: "exited", Ports: []PortMapping{{PublicPort: 5353}}},
+	}
+
+	conflicts := FindComposeConflicts(ports, containers)
+	if len(conflicts) != 2 {
+		t.Fatalf("Expected 2 conflicts, got %d: %+v", len(conflicts), conflicts)
+	}
+	if conflicts[0].Port.PublicPort != 8080 || conflicts[0].Reason != "port 8080 (service web) is already in use by nginx" {
+		t.Errorf("Unexpected first conflict: %+v", conflicts[0])
+	}
+	if conflicts[1].Port.Service != "api" || conflicts[1].Port.PublicPort != 8443 {
+		t.Errorf("Expected api/web clash on 8443, got %+v", conflicts[1])
+	}
+
+	containers[1].State = "paused"
+	conflicts = FindComposeConflicts(ports, containers)
+	if len(conflicts) != 3 || conflicts[1].Reason != "port 5353 (service dns) is already in use by old (paused)" {
+		t.Errorf("Expected the paused container to conflict, got %+v", conflicts)
+	}
+}
+
+const scaledComposeFile = `services:
+  web:
+    deploy:
+      replicas: 2
+    ports:
+      - "8000-8002:80"
+  worker:
+    scale: 2
+    ports:
+      - "9000:9000"
+  debug:
+    profiles: [debug]
+    ports:
+      - "5005:5005"
+`
+
+func TestParseComposeProfilesAndReplicas(t *testing.T) {
+	ports, err := ParseCompose([]byte(scaledComposeFile), nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(ports) != 3 || ports[0].Pool != 2 || ports[1].Replica != 1 || ports[2].Replica != 2 {
+		t.Fatalf("Expected the shared range and worker's port per replica, got %+v", ports)
+	}
+	if ports, _ := ParseCompose([]byte(scaledComposeFile), []string{"debug"}); len(ports) != 4 || ports[3].Service != "debug" {
+		t.Errorf("Expected the debug profile to add its port, got %+v", ports)
+	}
+	if _, err := ParseCompose([]byte("services:\n  web:\n    scale: lots\n    ports: [\"80:80\"]\n"), nil); err == nil {
+		t.Error("Expected an invalid scale to fail")
+	}
+
+	containers := []ContainerData{{Names: []string{"/old"}, State: "running", Ports: []PortMapping{{PublicPort: 8000}}}}
+	conflicts := FindComposeConflicts(ports, containers)
+	if len(conflicts) != 1 || conflicts[0].Port.Replica != 2 || conflicts[0].Reason != "port 9000 (service worker) is published by each of its replicas" {
+		t.Fatalf("Expected worker's replicas to clash, got %+v", conflicts)
+	}
+	containers[0].Ports = append(containers[0].Ports, PortMapping{PublicPort: 8001})
+	conflicts = FindComposeConflicts(ports, containers)
+	if len(conflicts) != 2 || conflicts[0].Code != "port_range_exhausted" || conflicts[0].Reason != "ports 8000-8002 (service web) have 1 free for 2 replicas" {
+		t.Errorf("Expected the range to run short, got %+v", conflicts)
+	}
+}
+
+func TestFindComposeConflictsInRanges(t *testing.T) {
+	ports, _ := ParseCompose([]byte(`services:
+  game:
+    ports:
+      - "27000-27999:27000-27999/udp"
+  voice:
+    ports:
+      - "27500:9987/udp"
+`), nil)
+	containers := []ContainerData{{Names: []string{"/old"}, State: "running", Ports: []PortMapping{{PublicPort: 27010}, {PublicPort: 27011}, {PublicPort: 27100}}}}
+
+	conflicts := FindComposeConflicts(ports, containers)
+	if len(conflicts) != 1 || conflicts[0].Reason != "ports 27000-27999 (service game) include 27010-27011, 27100, already in use by old" {
+		t.Fatalf("Expected one conflict for the range, got %+v", conflicts)
+	}
+	if len(conflicts[0].Ports) != 2 || conflicts[0].Ports[0] != (PortRange{27010, 27011}) {
+		t.Errorf("Expected the conflicting ports as ranges, got %+v", conflicts[0].Ports)
+	}
+
+	conflicts = FindComposeConflicts(ports, nil)
+	if len(conflicts) != 1 || conflicts[0].Reason != "port 27500 (service voice) is also published by service game" {
+		t.Errorf("Expected voice to clash with the range, got %+v", conflicts)
+	}
+}
+
+func FuzzParseCompose(f *testing.F) {
+	f.Add([]byte(composeFile))
+	f.Add([]byte(scaledComposeFile))
+	f.Add([]byte("services:\n  web:\n    ports:\n      - target: 80\n        published: \"8080-8081\"\n"))
+	f.Add([]byte("services:\n  web:\n    ports: [\"[::1]:80\"]\n"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		ports, err := ParseCompose(data, []string{"debug"})
+		if err != nil {
+			return
+		}
+		FindComposeConflicts(ports, nil)
+		ParseStack(data)
+	})
+}
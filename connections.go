@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// PortConnections is how many connections a published port has open
+type PortConnections struct {
+	Port        int    `json:"port"`
+	Protocol    string `json:"protocol"`
+	Container   string `json:"container"`
+	Established int    `json:"established"`
+}
+
+type ConnectionsResponse struct {
+	Ports []PortConnections `json:"ports"`
+	// Source is where the counts came from: conntrack or proc
+	Source string        `json:"source"`
+	Meta   *ResponseMeta `json:"meta,omitempty"`
+}
+
+// readProcNetTCP is swapped out in tests
+var readProcNetTCP = func() ([]byte, error) {
+	var all []byte
+	var firstErr error
+	for _, path := range []string{"/proc/net/tcp", "/proc/net/tcp6"} {
+		data, err := os.ReadFile(path)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		all = append(all, data...)
+	}
+	if len(all) == 0 {
+		return nil, firstErr
+	}
+	return all, nil
+}
+
+// establishedCounts counts established TCP connections per local port.
+// Conntrack sees connections Docker NATs straight to a container, so it's
+// preferred; the socket table only sees those through docker-proxy.
+func establishedCounts(ctx context.Context) (map[int]int, string, error) {
+	if data, err := readConntrack(ctx); err == nil {
+		return conntrackEstablished(data), "conntrack", nil
+	}
+	data, err := readProcNetTCP()
+	if err != nil {
+		return nil, "", err
+	}
+	return procEstablished(data), "proc", nil
+}
+
+// conntrackEstablished counts established TCP entries by the original
+// destination port
+func conntrackEstablished(data []byte) map[int]int {
+	result := map[int]int{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if !slices.Contains(fields, "tcp") || !slices.Contains(fields, "ESTABLISHED") {
+			continue
+		}
+		for _, f := range fields {
+			if v, ok := strings.CutPrefix(f, "dport="); ok {
+				if port, err := strconv.Atoi(v); err == nil {
+					result[port]++
+				}
+				break
+			}
+		}
+	}
+	return result
+}
+
+// procEstablished counts sockets in state 01 (established) by local port
+// in /proc/net/tcp layout: "sl local_address rem_address st ...", with
+// addresses as hex ip:port
+func procEstablished(data []byte) map[int]int {
+	result := map[int]int{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 || fields[3] != "01" {
+			continue
+		}
+		_, hexPort, ok := strings.Cut(fields[1], ":")
+		if !ok {
+			continue
+		}
+		if port, err := strconv.ParseUint(hexPort, 16, 16); err == nil {
+			result[int(port)]++
+		}
+	}
+	return result
+}
+
+// handleConnections reports how many connections each TCP port this host
+// publishes has open; ?idle=true keeps those with none, the candidates for
+// cleanup
+func (s *Server) handleConnections(w http.ResponseWriter, r *http.Request) {
+	idle := r.URL.Query().Get("idle") == "true"
+	snap, err := s.loadSnapshot(r.Context())
+	if err != nil {
+		status, code, msg := classifyDockerError(err)
+		writeError(w, status, code, msg)
+		return
+	}
+	counts, source, err := establishedCounts(r.Context())
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, "connections_unavailable", localize(w, "Cannot read the connection table: %v", err))
+		return
+	}
+
+	ports := []PortConnections{}
+	for _, c := range localContainers(snap.Containers) {
+		if !occupiesPorts(c.State) || c.Source == "reservation" {
+			continue
+		}
+		for _, p := range c.Ports {
+			if p.PublicPort == 0 || p.Type == "udp" || slices.ContainsFunc(ports, func(e PortConnections) bool { return e.Port == int(p.PublicPort) }) {
+				continue
+			}
+			entry := PortConnections{Port: int(p.PublicPort), Protocol: p.Type, Container: containerName(c), Established: counts[int(p.PublicPort)]}
+			if !idle || entry.Established == 0 {
+				ports = append(ports, entry)
+			}
+		}
+	}
+	slices.SortFunc(ports, func(a, b PortConnections) int { return a.Port - b.Port })
+
+	s.writeSnapshotHeaders(w, snap)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ConnectionsResponse{Ports: ports, Source: source, Meta: s.snapshotMeta(snap)})
+}
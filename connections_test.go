@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+)
+
+const procNetTCP = `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000:1F90 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1 1 0000000000000000 100 0 0 10 0
+   1: 0A01A8C0:1F90 3201A8C0:C350 01 00000000:00000000 00:00000000 00000000     0        0 2 1 0000000000000000 20 4 30 10 -1
+   2: 0100007F:1F90 0100007F:C351 01 00000000:00000000 00:00000000 00000000     0        0 3 1 0000000000000000 20 4 30 10 -1
+   3: 0A01A8C0:0BB8 3201A8C0:C352 06 00000000:00000000 00:00000000 00000000     0        0 0 3 0000000000000000
+`
+
+func TestConnections(t *testing.T) {
+	defer func(orig func(context.Context) ([]byte, error)) { readConntrack = orig }(readConntrack)
+	defer func(orig func() ([]byte, error)) { readProcNetTCP = orig }(readProcNetTCP)
+	readConntrack = func(context.Context) ([]byte, error) { return nil, errors.New("no conntrack") }
+	readProcNetTCP = func() ([]byte, error) { return []byte(procNetTCP), nil }
+
+	server := &Server{client: &MockDockerClient{Containers: []types.Container{
+		{Names: []string{"/web"}, State: "running", Ports: []types.Port{{PublicPort: 8080, PrivatePort: 80, Type: "tcp"}}},
+		{Names: []string{"/grafana"}, State: "running", Ports: []types.Port{{PublicPort: 3000, PrivatePort: 3000, Type: "tcp"}}},
+		{Names: []string{"/dns"}, State: "running", Ports: []types.Port{{PublicPort: 53, PrivatePort: 53, Type: "udp"}}},
+	}}}
+	get := func(url string) ConnectionsResponse {
+		w := httptest.NewRecorder()
+		SetupRouter(server).ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d %s", w.Code, w.Body)
+		}
+		var resp ConnectionsResponse
+		json.NewDecoder(w.Body).Decode(&resp)
+		return resp
+	}
+
+	resp := get("/api/connections")
+	if resp.Source != "proc" || len(resp.Ports) != 2 || resp.Ports[0].Port != 3000 || resp.Ports[0].Established != 0 || resp.Ports[1].Established != 2 {
+		t.Errorf("Expected 8080's two connections from the socket table, got %+v", resp)
+	}
+	if resp := get("/api/connections?idle=true"); len(resp.Ports) != 1 || resp.Ports[0].Container != "grafana" {
+		t.Errorf("Expected only the idle grafana, got %+v", resp.Ports)
+	}
+
+	readConntrack = func(context.Context) ([]byte, error) { return []byte(conntrackDump), nil }
+	resp = get("/api/connections")
+	if resp.Source != "conntrack" || resp.Ports[1].Established != 0 {
+		t.Errorf("Expected counts from conntrack, got %+v", resp)
+	}
+	if counts := conntrackEstablished([]byte(conntrackDump)); counts[8443] != 1 || counts[22] != 0 {
+		t.Errorf("Expected one established connection to 8443, got %v", counts)
+	}
+
+	readProcNetTCP = func() ([]byte, error) { return nil, errors.New("no proc") }
+	readConntrack = func(context.Context) ([]byte, error) { return nil, errors.New("no conntrack") }
+	w := httptest.NewRecorder()
+	SetupRouter(server).ServeHTTP(w, httptest.NewRequest("GET", "/api/connections", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 without a connection table, got %d", w.Code)
+	}
+}
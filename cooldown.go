@@ -0,0 +1,81 @@
+package main
+
+import (
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// freedRetention bounds how long released ports are remembered
+const freedRetention = 24 * time.Hour
+
+// freedPorts remembers when host ports were last released, so suggest can
+// avoid handing out a port that monitoring or lingering clients still
+// associate with the old service
+type freedPorts struct {
+	mu    sync.Mutex
+	freed map[int]time.Time
+}
+
+func newFreedPorts() *freedPorts {
+	return &freedPorts{freed: make(map[int]time.Time)}
+}
+
+// record updates the history from a batch of port events. A port that is
+// taken again is forgotten: it's no longer free to avoid.
+func (f *freedPorts) record(events []PortEvent) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, e := range events {
+		if e.Type == EventPortFreed {
+			f.freed[e.Port] = e.Time
+		}
+	}
+	for _, e := range events {
+		if e.Type == EventPortOccupied {
+			delete(f.freed, e.Port)
+		}
+	}
+	for p, t := range f.freed {
+		if time.Since(t) > freedRetention {
+			delete(f.freed, p)
+		}
+	}
+}
+
+// Recent returns the ports released within window, in order
+func (f *freedPorts) Recent(window time.Duration) []int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var ports []int
+	for p, t := range f.freed {
+		if time.Since(t) <= window {
+			ports = append(ports, p)
+		}
+	}
+	sort.Ints(ports)
+	return ports
+}
+
+func freedCooldownFromEnv() time.Duration {
+	d, _ := time.ParseDuration(os.Getenv("QUAYCHECK_FREED_COOLDOWN"))
+	return d
+}
+
+// withoutRecentlyFreed returns ix with ports released within window marked
+// as used, leaving ix itself untouched
+func (s *Server) withoutRecentlyFreed(ix *PortIndex, window time.Duration) *PortIndex {
+	if s.freed == nil || window <= 0 {
+		return ix
+	}
+	recent := s.freed.Recent(window)
+	if len(recent) == 0 {
+		return ix
+	}
+	ix = ix.Clone()
+	for _, p := range recent {
+		ix.Occupy(p)
+	}
+	return ix
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFreedPorts(t *testing.T) {
+	f := newFreedPorts()
+	now := time.Now()
+	f.record([]PortEvent{
+		{Type: EventPortFreed, Port: 8000, Time: now},
+		{Type: EventPortFreed, Port: 8001, Time: now.Add(-time.Hour)},
+		{Type: EventPortFreed, Port: 8002, Time: now},
+		{Type: EventPortOccupied, Port: 8002, Time: now},
+		{Type: EventPortFreed, Port: 8003, Time: now.Add(-2 * freedRetention)},
+	})
+
+	if got := f.Recent(10 * time.Minute); len(got) != 1 || got[0] != 8000 {
+		t.Errorf("Expected only 8000 within 10m, got %v", got)
+	}
+	if got := f.Recent(2 * time.Hour); len(got) != 2 {
+		t.Errorf("Expected 8000 and 8001 within 2h, got %v", got)
+	}
+	if _, ok := f.freed[8003]; ok {
+		t.Error("Expected entries past retention to be pruned")
+	}
+}
+
+func TestHandleSuggestCooldown(t *testing.T) {
+	server := &Server{client: &MockDockerClient{}, freed: newFreedPorts(), freedCooldown: 10 * time.Minute}
+	server.freed.record([]PortEvent{{Type: EventPortFreed, Port: 8000, Time: time.Now()}})
+
+	tests := []struct {
+		query        string
+		expectedPort int
+	}{
+		{"start=8000", 8001},
+		{"start=8000&cooldown=0s", 8000},
+		{"prefer=8000", 8001},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		server.handleSuggest(w, httptest.NewRequest("GET", "/api/suggest?"+tt.query, nil))
+		var result SuggestResponse
+		json.NewDecoder(w.Body).Decode(&result)
+		if result.Port != tt.expectedPort {
+			t.Errorf("%s: expected %d, got %d", tt.query, tt.expectedPort, result.Port)
+		}
+	}
+
+	// check is about what's bound right now, not about the cooldown
+	w := httptest.NewRecorder()
+	server.handleCheck(w, httptest.NewRequest("GET", "/api/check?port=8000", nil))
+	var check CheckResponse
+	json.NewDecoder(w.Body).Decode(&check)
+	if !check.Available {
+		t.Error("Expected a recently freed port to still check as available")
+	}
+}
+
+func TestPickingSkipsRecentlyFreed(t *testing.T) {
+	server := &Server{client: &MockDockerClient{}, freed: newFreedPorts(), freedCooldown: 10 * time.Minute}
+	server.reservations, _ = NewReservationStore(nil)
+	server.freed.record([]PortEvent{{Type: EventPortFreed, Port: 8000, Time: time.Now()}})
+
+	w := httptest.NewRecorder()
+	server.handleTerraformSuggest(w, httptest.NewRequest("POST", "/api/terraform/suggest", strings.NewReader(`{"start":"8000"}`)))
+	if !strings.Contains(w.Body.String(), `"8001"`) {
+		t.Errorf("Expected Terraform to get 8001, got %s", w.Body)
+	}
+
+	w = httptest.NewRecorder()
+	server.handleCreateReservation(w, httptest.NewRequest("POST", "/api/reservations", strings.NewReader(`{"name":"grafana","start":8000}`)))
+	var res Reservation
+	json.NewDecoder(w.Body).Decode(&res)
+	if res.Port != 8001 {
+		t.Errorf("Expected the reservation to skip 8000, got %d", res.Port)
+	}
+
+	w = httptest.NewRecorder()
+	server.handleAnsibleReservation(w, httptest.NewRequest("POST", "/api/ansible/reservation", strings.NewReader(`{"name":"loki","start":8000}`)))
+	var result AnsibleResult
+	json.NewDecoder(w.Body).Decode(&result)
+	if result.Port != 8002 {
+		t.Errorf("Expected Ansible to skip 8000, got %d", result.Port)
+	}
+
+	// Asking for the port itself is still allowed
+	w = httptest.NewRecorder()
+	server.handleCreateReservation(w, httptest.NewRequest("POST", "/api/reservations", strings.NewReader(`{"name":"old","port":8000}`)))
+	if w.Code != http.StatusCreated {
+		t.Errorf("Expected an explicit port to be reserved, got %d", w.Code)
+	}
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sendMail is swapped out in tests
+var sendMail = smtp.SendMail
+
+// SMTPConfig is where digests are sent from and to. smtp.SendMail upgrades
+// to STARTTLS whenever the server offers it.
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+	To       []string
+}
+
+// smtpFromEnv reads QUAYCHECK_SMTP_* and QUAYCHECK_DIGEST_TO; nil when the
+// host is missing. Recipients may also be set later through the settings.
+func smtpFromEnv() *SMTPConfig {
+	cfg := &SMTPConfig{
+		Host:     os.Getenv("QUAYCHECK_SMTP_HOST"),
+		Port:     587,
+		Username: os.Getenv("QUAYCHECK_SMTP_USERNAME"),
+		Password: os.Getenv("QUAYCHECK_SMTP_PASSWORD"),
+		From:     os.Getenv("QUAYCHECK_SMTP_FROM"),
+		To:       queryList([]string{os.Getenv("QUAYCHECK_DIGEST_TO")}),
+	}
+	if cfg.Host == "" {
+		return nil
+	}
+	if v := os.Getenv("QUAYCHECK_SMTP_PORT"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 && n <= maxPort {
+			cfg.Port = n
+		} else {
+			log.Printf("Ignoring QUAYCHECK_SMTP_PORT=%q", v)
+		}
+	}
+	if cfg.From == "" {
+		cfg.From = "quaycheck@" + cfg.Host
+	}
+	return cfg
+}
+
+// DigestSchedule sends a digest every day, or every Monday when Weekly, at
+// Hour:Minute local time
+type DigestSchedule struct {
+	Weekly       bool
+	Hour, Minute int
+}
+
+func (d DigestSchedule) period() time.Duration {
+	if d.Weekly {
+		return 7 * 24 * time.Hour
+	}
+	return 24 * time.Hour
+}
+
+func (d DigestSchedule) name() string {
+	if d.Weekly {
+		return "weekly"
+	}
+	return "daily"
+}
+
+// next returns the first send time after now
+func (d DigestSchedule) next(now time.Time) time.Time {
+	t := time.Date(now.Year(), now.Month(), now.Day(), d.Hour, d.Minute, 0, 0, now.Location())
+	if d.Weekly {
+		t = t.AddDate(0, 0, (int(time.Monday)-int(t.Weekday())+7)%7)
+	}
+	for !t.After(now) {
+		if d.Weekly {
+			t = t.AddDate(0, 0, 7)
+		} else {
+			t = t.AddDate(0, 0, 1)
+		}
+	}
+	return t
+}
+
+// digestScheduleFromEnv reads QUAYCHECK_DIGEST (daily or weekly) and
+// QUAYCHECK_DIGEST_AT (HH:MM), defaulting to daily at 08:00
+func digestScheduleFromEnv() DigestSchedule {
+	d := DigestSchedule{Hour: 8}
+	switch v := os.Getenv("QUAYCHECK_DIGEST"); v {
+	case "", "daily":
+	case "weekly":
+		d.Weekly = true
+	default:
+		log.Printf("Ignoring QUAYCHECK_DIGEST=%q, expected daily or weekly", v)
+	}
+	if v := os.Getenv("QUAYCHECK_DIGEST_AT"); v != "" {
+		if t, err := time.Parse("15:04", v); err == nil {
+			d.Hour, d.Minute = t.Hour(), t.Minute()
+		} else {
+			log.Printf("Ignoring QUAYCHECK_DIGEST_AT=%q, expected HH:MM", v)
+		}
+	}
+	return d
+}
+
+// Digest summarizes a period for people who won't open the UI
+type Digest struct {
+	From, To  time.Time
+	InUse     int
+	Added     []PortEvent
+	Removed   []PortEvent
+	Conflicts []PortConflict
+	// Taken are reserved ports held by something other than their container
+	Taken []HASensor
+	// Expiring lists freezes and ingested ports that lapse before the next
+	// digest; reservations themselves never expire
+	Expiring []string
+	// Idle are ports with no traffic for IdleDays, when traffic is sampled
+	Idle     []Recommendation
+	IdleDays int
+}
+
+// netChanges keeps, per binding, only a change that outlived the period:
+// a container restarted five times shows up once, or not at all
+func netChanges(events []PortEvent) (added, removed []PortEvent) {
+	type binding struct {
+		port            int
+		proto, ip, name string
+	}
+	first := make(map[binding]PortEvent)
+	last := make(map[binding]PortEvent)
+	var order []binding
+	for _, e := range events {
+		k := binding{e.Port, e.Protocol, e.IP, e.Container}
+		if _, ok := first[k]; !ok {
+			first[k] = e
+			order = append(order, k)
+		}
+		last[k] = e
+	}
+	for _, k := range order {
+		f, l := first[k], last[k]
+		switch {
+		case f.Type == EventPortOccupied && l.Type == EventPortOccupied:
+			added = append(added, l)
+		case f.Type == EventPortFreed && l.Type == EventPortFreed:
+			removed = append(removed, l)
+		}
+	}
+	byPort := func(list []PortEvent) {
+		sort.SliceStable(list, func(i, j int) bool { return list[i].Port < list[j].Port })
+	}
+	byPort(added)
+	byPort(removed)
+	return added, removed
+}
+
+// buildDigest covers the period ending at now, listing what expires within
+// the next one
+func (s *Server) buildDigest(ctx context.Context, now time.Time, period time.Duration) (Digest, error) {
+	d := Digest{From: now.Add(-period), To: now}
+	events, err := s.history.Since(ctx, d.From)
+	if err != nil {
+		return d, err
+	}
+	d.Added, d.Removed = netChanges(events)
+
+	snap, err := s.loadSnapshot(ctx)
+	if err != nil {
+		return d, err
+	}
+	d.InUse = snap.Index.Count()
+	d.Conflicts = portConflicts(snap.Containers, func(c ContainerData) bool { return occupiesPorts(c.State) })
+
+	var reservations []Reservation
+	if s.reservations != nil {
+		reservations = s.reservations.List()
+	}
+	for _, sensor := range haSensors(snap.Containers, nil, reservations) {
+		if sensor.State == "on" {
+			d.Taken = append(d.Taken, sensor)
+		}
+	}
+
+	if s.traffic != nil {
+		d.IdleDays = s.idleDays
+		if d.Idle, _, err = s.idlePorts(ctx, snap, now, d.IdleDays); err != nil {
+			return d, err
+		}
+	}
+
+	horizon := now.Add(period)
+	if s.freezes != nil {
+		for _, f := range s.freezes.List() {
+			if f.Until != nil && f.Until.After(now) && !f.Until.After(horizon) {
+				d.Expiring = append(d.Expiring, fmt.Sprintf("Freeze %s (%s) lifts %s", f.ID, f.Reason, f.Until.Local().Format("Mon Jan 2 15:04")))
+			}
+		}
+	}
+	if s.ingest != nil {
+		for _, p := range s.ingest.List() {
+			if p.ExpiresAt != nil && !p.ExpiresAt.After(horizon) {
+				d.Expiring = append(d.Expiring, fmt.Sprintf("%d/%s reported by %s lapses %s", p.Port, p.Protocol, p.Owner, p.ExpiresAt.Local().Format("Mon Jan 2 15:04")))
+			}
+		}
+	}
+	return d, nil
+}
+
+// render writes the digest as plain text
+func (d Digest) render() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Port usage from %s to %s\n\n", d.From.Local().Format("Mon Jan 2 15:04"), d.To.Local().Format("Mon Jan 2 15:04"))
+	fmt.Fprintf(&b, "%d ports in use, %d new, %d released, %d conflicts, %d reservations taken\n", d.InUse, len(d.Added), len(d.Removed), len(d.Conflicts), len(d.Taken))
+
+	section := func(title string, lines []string) {
+		if len(lines) == 0 {
+			return
+		}
+		fmt.Fprintf(&b, "\n%s\n%s\n", title, strings.Repeat("-", len(title)))
+		for _, l := range lines {
+			fmt.Fprintf(&b, "  %s\n", l)
+		}
+	}
+	eventLines := func(events []PortEvent) []string {
+		var lines []string
+		for _, e := range events {
+			lines = append(lines, fmt.Sprintf("%d/%s  %s", e.Port, e.Protocol, e.Container))
+		}
+		return lines
+	}
+
+	var violations []string
+	for _, c := range d.Conflicts {
+		violations = append(violations, fmt.Sprintf("%d/%s claimed by %s", c.Port, c.Protocol, strings.Join(c.Holders, ", ")))
+	}
+	for _, t := range d.Taken {
+		violations = append(violations, fmt.Sprintf("%d/%s reserved for %s but held by %s", t.Port, t.Protocol, t.ReservedFor, strings.Join(t.Holders, ", ")))
+	}
+	section("Violations", violations)
+	section("New ports", eventLines(d.Added))
+	section("Released ports", eventLines(d.Removed))
+	section("Expiring soon", d.Expiring)
+	var idle []string
+	for _, r := range d.Idle {
+		line := fmt.Sprintf("%d/%s  %s, no traffic for %d days", r.Port, r.Protocol, r.Container, d.IdleDays)
+		if r.LastActive != nil {
+			line += ", last used " + r.LastActive.Local().Format("Mon Jan 2")
+		}
+		idle = append(idle, line)
+	}
+	section("Idle ports", idle)
+	if len(violations)+len(d.Added)+len(d.Removed)+len(d.Expiring)+len(d.Idle) == 0 {
+		b.WriteString("\nNothing changed.\n")
+	}
+	return b.String()
+}
+
+// send mails body to every recipient
+func (cfg *SMTPConfig) send(subject, body string) error {
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\n", cfg.From, strings.Join(cfg.To, ", "), subject, time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+
+	var auth smtp.Auth
+	if cfg.Username != "" {
+		auth = smtp.PlainAuth("", cfg.Username, cfg.Password, cfg.Host)
+	}
+	addr := net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port))
+	return sendMail(addr, auth, cfg.From, cfg.To, []byte(msg.String()))
+}
+
+// digestRecipients are the saved recipients when settings are enabled, so
+// an admin can change or clear them at runtime, else the env's
+func (s *Server) digestRecipients(cfg *SMTPConfig) []string {
+	if s.settings != nil {
+		return s.settings.Get().DigestTo
+	}
+	return cfg.To
+}
+
+func (s *Server) sendDigest(ctx context.Context, cfg *SMTPConfig, schedule DigestSchedule, now time.Time) error {
+	to := s.digestRecipients(cfg)
+	if len(to) == 0 {
+		return nil
+	}
+	c := *cfg
+	c.To = to
+	d, err := s.buildDigest(ctx, now, schedule.period())
+	if err != nil {
+		return err
+	}
+	host, _ := os.Hostname()
+	subject := fmt.Sprintf("quaycheck %s digest for %s: %d in use, %d violations", schedule.name(), host, d.InUse, len(d.Conflicts)+len(d.Taken))
+	return c.send(subject, d.render())
+}
+
+// digestLoop sends a digest at every scheduled time
+func (s *Server) digestLoop(ctx context.Context, cfg *SMTPConfig, schedule DigestSchedule) {
+	if cfg == nil || s.history == nil {
+		return
+	}
+	for {
+		next := schedule.next(time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(next)):
+		}
+		to := s.digestRecipients(cfg)
+		if len(to) == 0 {
+			continue
+		}
+		if err := s.sendDigest(ctx, cfg, schedule, time.Now()); err != nil {
+			log.Printf("Digest not sent: %v", err)
+		} else {
+			log.Printf("Sent %s digest to %s", schedule.name(), strings.Join(to, ", "))
+		}
+	}
+}
+
+// handleDigestPreview renders the digest ?period=daily (default) or weekly
+// would send right now, to check it without waiting for the schedule
+func (s *Server) handleDigestPreview(w http.ResponseWriter, r *http.Request) {
+	var schedule DigestSchedule
+	switch r.URL.Query().Get("period") {
+	case "", "daily":
+	case "weekly":
+		schedule.Weekly = true
+	default:
+		writeError(w, http.StatusBadRequest, "invalid_param", "period must be daily or weekly")
+		return
+	}
+	d, err := s.buildDigest(r.Context(), time.Now(), schedule.period())
+	if err != nil {
+		status, code, msg := classifyDockerError(err)
+		writeError(w, status, code, msg)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprint(w, d.render())
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"net/smtp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+func TestDigestScheduleNext(t *testing.T) {
+	// Wednesday
+	now := time.Date(2026, 3, 4, 9, 0, 0, 0, time.UTC)
+	if got := (DigestSchedule{Hour: 8}).next(now); !got.Equal(time.Date(2026, 3, 5, 8, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected tomorrow 08:00, got %v", got)
+	}
+	if got := (DigestSchedule{Hour: 10}).next(now); !got.Equal(time.Date(2026, 3, 4, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected today 10:00, got %v", got)
+	}
+	if got := (DigestSchedule{Weekly: true, Hour: 8}).next(now); !got.Equal(time.Date(2026, 3, 9, 8, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected next Monday 08:00, got %v", got)
+	}
+}
+
+func TestNetChanges(t *testing.T) {
+	events := []PortEvent{
+		{Type: EventPortOccupied, Port: 8080, Protocol: "tcp", Container: "web"},
+		{Type: EventPortFreed, Port: 9000, Protocol: "tcp", Container: "old"},
+		{Type: EventPortFreed, Port: 5432, Protocol: "tcp", Container: "db"},
+		{Type: EventPortOccupied, Port: 5432, Protocol: "tcp", Container: "db"},
+	}
+	added, removed := netChanges(events)
+	if len(added) != 1 || added[0].Port != 8080 || len(removed) != 1 || removed[0].Port != 9000 {
+		t.Errorf("Expected a restart to cancel out, got added %v removed %v", added, removed)
+	}
+}
+
+func TestSendDigest(t *testing.T) {
+	db := openTestDB(t)
+	reservations, _ := NewReservationStore(db)
+	reservations.Ensure(Reservation{Name: "minecraft", Port: 25565, Protocol: "tcp"}, nil, 0, false)
+	mock := &MockDockerClient{Containers: []types.Container{
+		{ID: "a", Names: []string{"/squatter"}, State: "running", Ports: []types.Port{{PublicPort: 25565, Type: "tcp"}}},
+	}}
+	server := &Server{client: mock, history: NewHistoryStore(db), reservations: reservations}
+	now := time.Now()
+	server.history.Record(context.Background(), []PortEvent{{Type: EventPortOccupied, Port: 25565, Protocol: "tcp", Container: "squatter", Time: now.Add(-time.Hour)}})
+
+	var sent string
+	var rcpt []string
+	sendMail = func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+		sent, rcpt = string(msg), to
+		return nil
+	}
+	defer func() { sendMail = smtp.SendMail }()
+
+	cfg := &SMTPConfig{Host: "mail.lan", Port: 25, From: "quaycheck@lan", To: []string{"ops@lan", "boss@lan"}}
+	if err := server.sendDigest(context.Background(), cfg, DigestSchedule{Hour: 8}, now); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(rcpt) != 2 {
+		t.Errorf("Expected two recipients, got %v", rcpt)
+	}
+	for _, want := range []string{"Subject: quaycheck daily digest", "25565/tcp reserved for minecraft but held by squatter", "New ports", "25565/tcp  squatter"} {
+		if !strings.Contains(sent, want) {
+			t.Errorf("Expected the digest to contain %q:\n%s", want, sent)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"quaycheck/internal/portspec"
+)
+
+// ForwardRule is a host port forwarded somewhere outside Docker's control
+type ForwardRule struct {
+	Name        string `json:"name"`
+	PublicPort  uint16 `json:"public_port"`
+	PrivatePort uint16 `json:"private_port"`
+	Type        string `json:"type"`
+	IP          string `json:"ip,omitempty"`
+	Target      string `json:"target,omitempty"`
+}
+
+func (f ForwardRule) toContainerData(source string) ContainerData {
+	proto := f.Type
+	if proto == "" {
+		proto = "tcp"
+	}
+	name := f.Name
+	if name == "" {
+		name = fmt.Sprintf("%s %d→%s:%d", source, f.PublicPort, f.Target, f.PrivatePort)
+	}
+	return ContainerData{
+		ID:     fmt.Sprintf("%s:%s:%d/%s", source, f.IP, f.PublicPort, proto),
+		Names:  []string{name},
+		Image:  f.Target,
+		State:  "running",
+		Source: source,
+		Ports: []PortMapping{{
+			PrivatePort: f.PrivatePort,
+			PublicPort:  f.PublicPort,
+			Type:        proto,
+			IP:          f.IP,
+		}},
+	}
+}
+
+// runIptablesSave is swapped out in tests
+var runIptablesSave = func(ctx context.Context) ([]byte, error) {
+	return exec.CommandContext(ctx, "iptables-save", "-t", "nat").Output()
+}
+
+// IptablesSource reads DNAT rules from the nat table. Rules in Docker's own
+// DOCKER chain are skipped since the Docker API already reports them.
+type IptablesSource struct{}
+
+func (s *IptablesSource) Name() string { return "dnat" }
+
+func (s *IptablesSource) Containers(ctx context.Context) ([]ContainerData, error) {
+	out, err := runIptablesSave(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("iptables-save: %w", err)
+	}
+	var result []ContainerData
+	for _, rule := range parseDNATRules(string(out)) {
+		result = append(result, rule.toContainerData(s.Name()))
+	}
+	return result, nil
+}
+
+// parseDNATRules extracts port forwards from iptables-save output
+func parseDNATRules(dump string) []ForwardRule {
+	var rules []ForwardRule
+	for _, line := range strings.Split(dump, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "-A" || fields[1] == "DOCKER" {
+			continue
+		}
+
+		var proto, dest, dport, to string
+		isDNAT := false
+		for i := 2; i < len(fields)-1; i++ {
+			switch fields[i] {
+			case "-p":
+				proto = fields[i+1]
+			case "-d":
+				dest = strings.TrimSuffix(fields[i+1], "/32")
+			case "--dport":
+				dport = fields[i+1]
+			case "--to-destination":
+				to = fields[i+1]
+			case "-j":
+				isDNAT = fields[i+1] == "DNAT"
+			}
+		}
+		if !isDNAT || dport == "" {
+			continue
+		}
+
+		target, targetPort := to, ""
+		if idx := strings.LastIndex(to, ":"); idx >= 0 {
+			target, targetPort = to[:idx], to[idx+1:]
+		}
+
+		lo, hi, ok := portspec.Range(dport, ":")
+		if !ok {
+			continue
+		}
+		for p := lo; p <= hi; p++ {
+			private := p
+			if tp, err := strconv.Atoi(targetPort); err == nil {
+				private = tp
+			}
+			rules = append(rules, ForwardRule{
+				PublicPort:  uint16(p),
+				PrivatePort: uint16(private),
+				Type:        proto,
+				IP:          dest,
+				Target:      target,
+			})
+		}
+	}
+	return rules
+}
+
+// ForwardsFileSource reads a JSON list of ForwardRule from disk, for forwards
+// done by the router, socat, firewalld or anything else quaycheck cannot see.
+// Ports pushed through /api/ingest are merged in from Ingested.
+type ForwardsFileSource struct {
+	// Path may be empty when everything comes through ingest
+	Path     string
+	Ingested *IngestStore
+}
+
+func (s *ForwardsFileSource) Name() string { return "manual" }
+
+func (s *ForwardsFileSource) Containers(ctx context.Context) ([]ContainerData, error) {
+	var rules []ForwardRule
+	if s.Path != "" {
+		data, err := os.ReadFile(s.Path)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, &rules); err != nil {
+			return nil, fmt.Errorf("parse %s: %w", s.Path, err)
+		}
+	}
+	if s.Ingested != nil {
+		rules = append(rules, s.Ingested.rules()...)
+	}
+	var result []ContainerData
+	for _, rule := range rules {
+		result = append(result, rule.toContainerData(s.Name()))
+	}
+	return result, nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+)
+
+const iptablesDump = `# Generated by iptables-save
+*nat
+:PREROUTING ACCEPT [0:0]
+:DOCKER - [0:0]
+-A PREROUTING -m addrtype --dst-type LOCAL -j DOCKER
+-A PREROUTING -d 192.168.1.10/32 -p tcp -m tcp --dport 2222 -j DNAT --to-destination 10.0.0.5:22
+-A PREROUTING -p udp -m udp --dport 27015:27016 -j DNAT --to-destination 10.0.0.6
+-A DOCKER ! -i docker0 -p tcp -m tcp --dport 8080 -j DNAT --to-destination 172.17.0.2:80
+COMMIT
+`
+
+func TestParseDNATRules(t *testing.T) {
+	rules := parseDNATRules(iptablesDump)
+	if len(rules) != 3 {
+		t.Fatalf("Expected 3 rules, got %d: %+v", len(rules), rules)
+	}
+
+	ssh := rules[0]
+	if ssh.PublicPort != 2222 || ssh.PrivatePort != 22 || ssh.IP != "192.168.1.10" || ssh.Target != "10.0.0.5" || ssh.Type != "tcp" {
+		t.Errorf("Unexpected ssh rule: %+v", ssh)
+	}
+	if rules[1].PublicPort != 27015 || rules[2].PublicPort != 27016 || rules[2].PrivatePort != 27016 {
+		t.Errorf("Expected range to expand without remapping, got %+v", rules[1:])
+	}
+}
+
+func TestForwardsFileSource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "forwards.json")
+	os.WriteFile(path, []byte(`[{"name":"nas-ssh","public_port":2222,"private_port":22,"target":"10.0.0.5"}]`), 0o644)
+
+	src := &ForwardsFileSource{Path: path}
+	entries, err := src.Containers(context.Background())
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(entries) != 1 || entries[0].Names[0] != "nas-ssh" || entries[0].Ports[0].Type != "tcp" {
+		t.Errorf("Unexpected entries: %+v", entries)
+	}
+}
+
+func TestGetContainersMergesSources(t *testing.T) {
+	orig := runIptablesSave
+	defer func() { runIptablesSave = orig }()
+	runIptablesSave = func(ctx context.Context) ([]byte, error) {
+		return []byte(iptablesDump), nil
+	}
+
+	mockClient := &MockDockerClient{Containers: []types.Container{{ID: "123", State: "running"}}}
+	server := &Server{client: mockClient, sources: []PortSource{
+		&IptablesSource{},
+		&ForwardsFileSource{Path: "/nonexistent"},
+	}}
+
+	containers, err := server.getContainers(context.Background())
+	if err != nil {
+		t.Fatalf("Expected failing source to be skipped, got %v", err)
+	}
+	if len(containers) != 4 {
+		t.Fatalf("Expected 4 entries, got %d", len(containers))
+	}
+
+	used := buildPortIndex(containers)
+	if !used.Used(2222) || !used.Used(27016) {
+		t.Error("Expected forwarded ports to be used")
+	}
+	if used.Used(8080) {
+		t.Error("Expected DOCKER chain rules to be skipped")
+	}
+}
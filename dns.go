@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// DNSConfig is where reserved services are published in DNS: names under
+// Domain, all pointing at Address
+type DNSConfig struct {
+	Domain  string
+	Address string
+}
+
+// dnsFromEnv reads QUAYCHECK_DNS_DOMAIN, "lan" by default, and
+// QUAYCHECK_DNS_ADDRESS, the address the names resolve to
+func dnsFromEnv() DNSConfig {
+	cfg := DNSConfig{Domain: os.Getenv("QUAYCHECK_DNS_DOMAIN"), Address: os.Getenv("QUAYCHECK_DNS_ADDRESS")}
+	if cfg.Domain == "" {
+		cfg.Domain = "lan"
+	}
+	return cfg
+}
+
+// DNSRecord maps a reserved service to the host and port it answers on:
+// Host resolves to Address, and _Name._Protocol.Domain is an SRV record
+// pointing at Host:Port
+type DNSRecord struct {
+	Name     string `json:"name"`
+	Host     string `json:"host"`
+	Address  string `json:"address"`
+	Port     int    `json:"port"`
+	Protocol string `json:"protocol"`
+}
+
+// SRV is the record's service name, e.g. _grafana._tcp.lan
+func (r DNSRecord) SRV(domain string) string {
+	return "_" + r.Name + "._" + r.Protocol + "." + domain
+}
+
+// dnsLabel turns a reservation name into a DNS label: lowercase letters,
+// digits and hyphens
+func dnsLabel(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+			b.WriteRune(r)
+		} else if b.Len() > 0 && !strings.HasSuffix(b.String(), "-") {
+			b.WriteByte('-')
+		}
+	}
+	label := strings.TrimSuffix(b.String(), "-")
+	if len(label) > 63 {
+		label = strings.TrimSuffix(label[:63], "-")
+	}
+	return label
+}
+
+// dnsRecords maps reservations to records, skipping names that leave no
+// valid label and those taken by an earlier one
+func dnsRecords(reservations []Reservation, cfg DNSConfig) []DNSRecord {
+	records := []DNSRecord{}
+	seen := make(map[string]bool)
+	for _, r := range reservations {
+		label := dnsLabel(r.Name)
+		proto := r.Protocol
+		if proto == "" {
+			proto = "tcp"
+		}
+		if label == "" || seen[label+"/"+proto] {
+			continue
+		}
+		seen[label+"/"+proto] = true
+		records = append(records, DNSRecord{Name: label, Host: label + "." + cfg.Domain, Address: cfg.Address, Port: r.Port, Protocol: proto})
+	}
+	return records
+}
+
+// renderHosts writes /etc/hosts lines; hosts files have no ports, so each
+// line notes its own
+func renderHosts(records []DNSRecord) string {
+	var b strings.Builder
+	b.WriteString("# Generated by quaycheck from its reservations\n")
+	written := make(map[string]bool)
+	for _, r := range records {
+		if written[r.Host] {
+			continue
+		}
+		written[r.Host] = true
+		fmt.Fprintf(&b, "%s\t%s\t# %d/%s\n", r.Address, r.Host, r.Port, r.Protocol)
+	}
+	return b.String()
+}
+
+// renderDnsmasq writes an address and an SRV record per service, to drop
+// into /etc/dnsmasq.d
+func renderDnsmasq(records []DNSRecord, domain string) string {
+	var b strings.Builder
+	b.WriteString("# Generated by quaycheck from its reservations\n")
+	written := make(map[string]bool)
+	for _, r := range records {
+		if !written[r.Host] {
+			written[r.Host] = true
+			fmt.Fprintf(&b, "host-record=%s,%s\n", r.Host, r.Address)
+		}
+		fmt.Fprintf(&b, "srv-host=%s,%s,%d\n", r.SRV(domain), r.Host, r.Port)
+	}
+	return b.String()
+}
+
+// renderZone writes an RFC 1035 zone for CoreDNS's file plugin. The serial
+// is the time of rendering, so it goes up with every fetch.
+func renderZone(records []DNSRecord, domain string, now time.Time) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "; Generated by quaycheck from its reservations\n$ORIGIN %s.\n$TTL 60\n", domain)
+	fmt.Fprintf(&b, "@\tIN\tSOA\tquaycheck.%s. hostmaster.%s. %d 3600 600 86400 60\n", domain, domain, now.Unix())
+	fmt.Fprintf(&b, "@\tIN\tNS\tquaycheck.%s.\n", domain)
+	written := make(map[string]bool)
+	for _, r := range records {
+		if written[r.Name] {
+			continue
+		}
+		written[r.Name] = true
+		kind := "A"
+		if ip := net.ParseIP(r.Address); ip != nil && ip.To4() == nil {
+			kind = "AAAA"
+		}
+		fmt.Fprintf(&b, "%s\tIN\t%s\t%s\n", r.Name, kind, r.Address)
+	}
+	for _, r := range records {
+		fmt.Fprintf(&b, "_%s._%s\tIN\tSRV\t0 0 %d %s\n", r.Name, r.Protocol, r.Port, r.Name)
+	}
+	return b.String()
+}
+
+// handleDNSExport renders the reservations as a hosts file, dnsmasq config
+// or CoreDNS zone. domain and address override QUAYCHECK_DNS_DOMAIN and
+// QUAYCHECK_DNS_ADDRESS; with ?env=, only that environment's reservations
+// and those of no environment are listed.
+func (s *Server) handleDNSExport(w http.ResponseWriter, r *http.Request) {
+	if s.reservations == nil {
+		writeError(w, http.StatusNotImplemented, "reservations_disabled", "Reservations are not enabled on this server")
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "hosts"
+	}
+	if format != "hosts" && format != "dnsmasq" && format != "coredns" {
+		writeError(w, http.StatusBadRequest, "invalid_param", "format must be hosts, dnsmasq or coredns")
+		return
+	}
+	cfg := s.dns
+	if d := r.URL.Query().Get("domain"); d != "" {
+		cfg.Domain = d
+	}
+	if a := r.URL.Query().Get("address"); a != "" {
+		cfg.Address = a
+	}
+	cfg.Domain = strings.Trim(cfg.Domain, ".")
+	if cfg.Address == "" {
+		writeError(w, http.StatusBadRequest, "missing_param", "Set QUAYCHECK_DNS_ADDRESS or pass address")
+		return
+	}
+	if net.ParseIP(cfg.Address) == nil {
+		writeError(w, http.StatusBadRequest, "invalid_param", "address must be an IP address")
+		return
+	}
+
+	env := environmentFrom(r.Context())
+	var reservations []Reservation
+	for _, res := range s.reservations.List() {
+		if sharesEnvironment(res.Environment, env) {
+			reservations = append(reservations, res)
+		}
+	}
+	records := dnsRecords(reservations, cfg)
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	switch format {
+	case "hosts":
+		fmt.Fprint(w, renderHosts(records))
+	case "dnsmasq":
+		fmt.Fprint(w, renderDnsmasq(records, cfg.Domain))
+	case "coredns":
+		fmt.Fprint(w, renderZone(records, cfg.Domain, time.Now()))
+	}
+}
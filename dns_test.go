@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDNSLabel(t *testing.T) {
+	for name, want := range map[string]string{
+		"grafana":         "grafana",
+		"Staging_Grafana": "staging-grafana",
+		"--a..b--":        "a-b",
+		"日本":              "",
+	} {
+		if got := dnsLabel(name); got != want {
+			t.Errorf("%q: expected %q, got %q", name, want, got)
+		}
+	}
+}
+
+func TestDNSExport(t *testing.T) {
+	store, _ := NewReservationStore(nil)
+	store.Ensure(Reservation{Name: "grafana", Port: 3000}, nil, 0, false)
+	store.Ensure(Reservation{Name: "dns", Port: 5353, Protocol: "udp"}, nil, 0, false)
+	store.Ensure(Reservation{Name: "Grafana", Port: 3001}, nil, 0, false)
+	server := &Server{reservations: store, dns: DNSConfig{Domain: "lan", Address: "10.0.0.5"}}
+	mux := SetupRouter(server)
+
+	get := func(query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", "/api/export/dns"+query, nil))
+		return w
+	}
+	if body := get("").Body.String(); !strings.Contains(body, "10.0.0.5\tgrafana.lan\t# 3000/tcp\n10.0.0.5\tdns.lan\t# 5353/udp\n") || strings.Contains(body, "3001") {
+		t.Errorf("Unexpected hosts file:\n%s", body)
+	}
+	if body := get("?format=dnsmasq&domain=home.arpa").Body.String(); !strings.Contains(body, "host-record=grafana.home.arpa,10.0.0.5\nsrv-host=_grafana._tcp.home.arpa,grafana.home.arpa,3000\n") {
+		t.Errorf("Unexpected dnsmasq config:\n%s", body)
+	}
+	if w := get("?format=bind"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unknown format, got %d", w.Code)
+	}
+	server.dns.Address = ""
+	if w := get(""); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without an address, got %d", w.Code)
+	}
+
+	zone := renderZone(dnsRecords(store.List(), DNSConfig{Domain: "lan", Address: "fd00::5"}), "lan", time.Unix(1700000000, 0))
+	for _, line := range []string{"$ORIGIN lan.", "1700000000 3600", "grafana\tIN\tAAAA\tfd00::5", "_dns._udp\tIN\tSRV\t0 0 5353 dns"} {
+		if !strings.Contains(zone, line) {
+			t.Errorf("Expected %q in the zone:\n%s", line, zone)
+		}
+	}
+}
@@ -0,0 +1,415 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"time"
+)
+
+// DNSUpdater publishes the reservations' records on a DNS server. Sync
+// replaces whatever it published before, so a released reservation's
+// records go away with it.
+type DNSUpdater interface {
+	Name() string
+	Sync(ctx context.Context, domain string, records []DNSRecord) error
+}
+
+// dnsUpdaterFromEnv reads QUAYCHECK_DNS_UPDATER, where to publish the
+// records, and QUAYCHECK_DNS_UPDATER_SECRET, the credential it takes
+func dnsUpdaterFromEnv() DNSUpdater {
+	raw := os.Getenv("QUAYCHECK_DNS_UPDATER")
+	if raw == "" {
+		return nil
+	}
+	u, err := parseDNSUpdater(raw, os.Getenv("QUAYCHECK_DNS_UPDATER_SECRET"))
+	if err != nil {
+		log.Fatalf("Invalid QUAYCHECK_DNS_UPDATER: %v", err)
+	}
+	return u
+}
+
+// parseDNSUpdater picks the updater from the URL's scheme:
+// rfc2136://ns1.lan:53 sends dynamic updates with nsupdate, the secret
+// being its TSIG key file; cloudflare://<zone id> calls the Cloudflare API
+// with the secret as the token; pihole://pi.hole sets local records
+// through the Pi-hole v6 API, logging in with the secret as the password
+// (?tls=true for https).
+func parseDNSUpdater(raw, secret string) (DNSUpdater, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("%s: missing host", u.Scheme)
+	}
+	switch u.Scheme {
+	case "rfc2136":
+		server := u.Host
+		if u.Port() == "" {
+			server = net.JoinHostPort(u.Host, "53")
+		}
+		return &RFC2136Updater{Server: server, KeyFile: secret}, nil
+	case "cloudflare":
+		if secret == "" {
+			return nil, fmt.Errorf("cloudflare: QUAYCHECK_DNS_UPDATER_SECRET must hold an API token")
+		}
+		return &CloudflareUpdater{BaseURL: cloudflareAPI, Zone: u.Host, Token: secret, HTTPClient: &http.Client{Timeout: 10 * time.Second}}, nil
+	case "pihole":
+		scheme := "http"
+		if u.Query().Get("tls") == "true" {
+			scheme = "https"
+		}
+		return &PiholeUpdater{BaseURL: scheme + "://" + u.Host, Password: secret, HTTPClient: &http.Client{Timeout: 10 * time.Second}}, nil
+	}
+	return nil, fmt.Errorf("unknown updater %q, expected rfc2136, cloudflare or pihole", u.Scheme)
+}
+
+// addressType is A or AAAA, depending on the address
+func addressType(addr string) string {
+	if ip := net.ParseIP(addr); ip != nil && ip.To4() == nil {
+		return "AAAA"
+	}
+	return "A"
+}
+
+// RFC2136Updater sends dynamic updates to an authoritative server
+type RFC2136Updater struct {
+	Server  string
+	KeyFile string
+
+	// published are the names the last Sync added, to delete those that
+	// aren't in the next one. A restart forgets them.
+	published []string
+}
+
+// runNsupdate feeds a script to nsupdate; tests swap it out
+var runNsupdate = func(ctx context.Context, keyFile, script string) ([]byte, error) {
+	var args []string
+	if keyFile != "" {
+		args = append(args, "-k", keyFile)
+	}
+	cmd := exec.CommandContext(ctx, "nsupdate", args...)
+	cmd.Stdin = strings.NewReader(script)
+	return cmd.CombinedOutput()
+}
+
+func (u *RFC2136Updater) Name() string { return "rfc2136" }
+
+func (u *RFC2136Updater) Sync(ctx context.Context, domain string, records []DNSRecord) error {
+	host, port, _ := net.SplitHostPort(u.Server)
+	var b strings.Builder
+	fmt.Fprintf(&b, "server %s %s\nzone %s\n", host, port, domain)
+	var names []string
+	for _, r := range records {
+		if !slices.Contains(names, r.Host) {
+			names = append(names, r.Host)
+			fmt.Fprintf(&b, "update delete %s. %s\n", r.Host, addressType(r.Address))
+			fmt.Fprintf(&b, "update add %s. 60 %s %s\n", r.Host, addressType(r.Address), r.Address)
+		}
+		srv := r.SRV(domain)
+		if !slices.Contains(names, srv) {
+			names = append(names, srv)
+			fmt.Fprintf(&b, "update delete %s. SRV\n", srv)
+		}
+		fmt.Fprintf(&b, "update add %s. 60 SRV 0 0 %d %s.\n", srv, r.Port, r.Host)
+	}
+	for _, name := range u.published {
+		if !slices.Contains(names, name) {
+			fmt.Fprintf(&b, "update delete %s.\n", name)
+		}
+	}
+	b.WriteString("send\n")
+
+	if out, err := runNsupdate(ctx, u.KeyFile, b.String()); err != nil {
+		return fmt.Errorf("nsupdate: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	u.published = names
+	return nil
+}
+
+// cloudflareAPI is the Cloudflare v4 API
+const cloudflareAPI = "https://api.cloudflare.com/client/v4"
+
+// cloudflareComment marks the records quaycheck manages, so it leaves the
+// rest of the zone alone
+const cloudflareComment = "managed by quaycheck"
+
+// CloudflareUpdater keeps a Cloudflare zone's records in line with the
+// reservations. The domain must be the zone or a subdomain of it.
+type CloudflareUpdater struct {
+	BaseURL    string
+	Zone       string
+	Token      string
+	HTTPClient *http.Client
+}
+
+type cloudflareRecord struct {
+	ID      string         `json:"id,omitempty"`
+	Type    string         `json:"type"`
+	Name    string         `json:"name"`
+	Content string         `json:"content,omitempty"`
+	Data    *cloudflareSRV `json:"data,omitempty"`
+	TTL     int            `json:"ttl,omitempty"`
+	Comment string         `json:"comment,omitempty"`
+}
+
+type cloudflareSRV struct {
+	Priority int    `json:"priority"`
+	Weight   int    `json:"weight"`
+	Port     int    `json:"port"`
+	Target   string `json:"target"`
+}
+
+// key identifies a record by what it says, ignoring its ID
+func (r cloudflareRecord) key() string {
+	if r.Data != nil {
+		return fmt.Sprintf("%s %s %d %s", r.Type, r.Name, r.Data.Port, strings.TrimSuffix(r.Data.Target, "."))
+	}
+	return r.Type + " " + r.Name + " " + r.Content
+}
+
+func (u *CloudflareUpdater) Name() string { return "cloudflare" }
+
+// do calls the API and decodes the result into out
+func (u *CloudflareUpdater) do(ctx context.Context, method, path string, body, out any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.BaseURL+"/zones/"+url.PathEscape(u.Zone)+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+u.Token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := u.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("cloudflare: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var envelope struct {
+		Success bool `json:"success"`
+		Errors  []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+		Result json.RawMessage `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+		return fmt.Errorf("cloudflare: %s: %s", method, resp.Status)
+	}
+	if !envelope.Success {
+		msgs := []string{resp.Status}
+		for _, e := range envelope.Errors {
+			msgs = append(msgs, e.Message)
+		}
+		return fmt.Errorf("cloudflare: %s", strings.Join(msgs, ": "))
+	}
+	if out != nil {
+		return json.Unmarshal(envelope.Result, out)
+	}
+	return nil
+}
+
+func (u *CloudflareUpdater) Sync(ctx context.Context, domain string, records []DNSRecord) error {
+	var existing []cloudflareRecord
+	if err := u.do(ctx, "GET", "/dns_records?per_page=5000&comment.exact="+url.QueryEscape(cloudflareComment), nil, &existing); err != nil {
+		return err
+	}
+	have := make(map[string]cloudflareRecord)
+	for _, r := range existing {
+		if r.Comment == cloudflareComment {
+			have[r.key()] = r
+		}
+	}
+
+	var want []cloudflareRecord
+	for _, r := range records {
+		addr := cloudflareRecord{Type: addressType(r.Address), Name: r.Host, Content: r.Address}
+		if !slices.ContainsFunc(want, func(w cloudflareRecord) bool { return w.key() == addr.key() }) {
+			want = append(want, addr)
+		}
+		want = append(want, cloudflareRecord{Type: "SRV", Name: r.SRV(domain), Data: &cloudflareSRV{Port: r.Port, Target: r.Host}})
+	}
+
+	for _, r := range want {
+		if _, ok := have[r.key()]; ok {
+			delete(have, r.key())
+			continue
+		}
+		r.TTL, r.Comment = 60, cloudflareComment
+		if err := u.do(ctx, "POST", "/dns_records", r, nil); err != nil {
+			return err
+		}
+	}
+	for _, r := range have {
+		if err := u.do(ctx, "DELETE", "/dns_records/"+url.PathEscape(r.ID), nil, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PiholeUpdater writes the records as dnsmasq lines in a Pi-hole's
+// config. Lines for names under the domain are quaycheck's; the others
+// are kept as they are.
+type PiholeUpdater struct {
+	BaseURL    string
+	Password   string
+	HTTPClient *http.Client
+}
+
+func (u *PiholeUpdater) Name() string { return "pihole" }
+
+// call sends a request with the session ID and decodes the response
+func (u *PiholeUpdater) call(ctx context.Context, method, path, sid string, body, out any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.BaseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	if sid != "" {
+		req.Header.Set("X-FTL-SID", sid)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := u.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("pihole: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		var e struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&e)
+		return fmt.Errorf("pihole: %s %s: %s %s", method, path, resp.Status, e.Error.Message)
+	}
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	return nil
+}
+
+func (u *PiholeUpdater) Sync(ctx context.Context, domain string, records []DNSRecord) error {
+	var auth struct {
+		Session struct {
+			Valid bool   `json:"valid"`
+			SID   string `json:"sid"`
+		} `json:"session"`
+	}
+	if err := u.call(ctx, "POST", "/api/auth", "", map[string]string{"password": u.Password}, &auth); err != nil {
+		return err
+	}
+	if !auth.Session.Valid {
+		return fmt.Errorf("pihole: login refused")
+	}
+	sid := auth.Session.SID
+	// Sessions are few; give this one back
+	defer u.call(context.WithoutCancel(ctx), "DELETE", "/api/auth", sid, nil, nil)
+
+	var current struct {
+		Config struct {
+			Misc struct {
+				DnsmasqLines []string `json:"dnsmasq_lines"`
+			} `json:"misc"`
+		} `json:"config"`
+	}
+	if err := u.call(ctx, "GET", "/api/config/misc/dnsmasq_lines", sid, nil, &current); err != nil {
+		return err
+	}
+	lines := []string{}
+	for _, line := range current.Config.Misc.DnsmasqLines {
+		if !piholeOwns(line, domain) {
+			lines = append(lines, line)
+		}
+	}
+	for _, line := range strings.Split(renderDnsmasq(records, domain), "\n") {
+		if line != "" && !strings.HasPrefix(line, "#") {
+			lines = append(lines, line)
+		}
+	}
+	if slices.Equal(lines, current.Config.Misc.DnsmasqLines) {
+		return nil
+	}
+
+	var patch struct {
+		Config struct {
+			Misc struct {
+				DnsmasqLines []string `json:"dnsmasq_lines"`
+			} `json:"misc"`
+		} `json:"config"`
+	}
+	patch.Config.Misc.DnsmasqLines = lines
+	return u.call(ctx, "PATCH", "/api/config", sid, patch, nil)
+}
+
+// piholeOwns is whether a dnsmasq line is a record quaycheck wrote: a
+// host-record or srv-host for a name under the domain
+func piholeOwns(line, domain string) bool {
+	for _, prefix := range []string{"host-record=", "srv-host="} {
+		if rest, ok := strings.CutPrefix(line, prefix); ok {
+			name, _, _ := strings.Cut(rest, ",")
+			return strings.HasSuffix(name, "."+domain)
+		}
+	}
+	return false
+}
+
+// dnsUpdateLoop publishes the reservations through the updater, checking
+// every interval whether they changed since the last sync. A failed sync
+// is retried on the next round.
+func (s *Server) dnsUpdateLoop(ctx context.Context, u DNSUpdater, interval time.Duration) {
+	if u == nil || s.reservations == nil {
+		return
+	}
+	var synced []DNSRecord
+	first := true
+	run := func() {
+		records := dnsRecords(s.reservations.List(), s.dns)
+		if !first && slices.Equal(records, synced) {
+			return
+		}
+		if err := u.Sync(ctx, s.dns.Domain, records); err != nil {
+			log.Printf("DNS update via %s failed: %v", u.Name(), err)
+			return
+		}
+		synced, first = records, false
+		log.Printf("Published %d DNS records via %s", len(records), u.Name())
+	}
+
+	run()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			run()
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+var updaterRecords = []DNSRecord{
+	{Name: "grafana", Host: "grafana.lan", Address: "10.0.0.5", Port: 3000, Protocol: "tcp"},
+	{Name: "dns", Host: "dns.lan", Address: "10.0.0.5", Port: 5353, Protocol: "udp"},
+}
+
+func TestParseDNSUpdater(t *testing.T) {
+	u, err := parseDNSUpdater("rfc2136://ns1.lan", "/etc/quaycheck.key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, ok := u.(*RFC2136Updater); !ok || r.Server != "ns1.lan:53" || r.KeyFile != "/etc/quaycheck.key" {
+		t.Errorf("Unexpected updater %+v", u)
+	}
+	if u, _ := parseDNSUpdater("pihole://pi.hole:8443?tls=true", "pw"); u.(*PiholeUpdater).BaseURL != "https://pi.hole:8443" {
+		t.Errorf("Unexpected Pi-hole URL %q", u.(*PiholeUpdater).BaseURL)
+	}
+	for _, raw := range []string{"cloudflare://zone", "route53://zone", "rfc2136://"} {
+		if _, err := parseDNSUpdater(raw, ""); err == nil {
+			t.Errorf("%s: expected an error", raw)
+		}
+	}
+}
+
+func TestRFC2136Updater(t *testing.T) {
+	var scripts []string
+	defer func(orig func(context.Context, string, string) ([]byte, error)) { runNsupdate = orig }(runNsupdate)
+	runNsupdate = func(_ context.Context, keyFile, script string) ([]byte, error) {
+		scripts = append(scripts, script)
+		return nil, nil
+	}
+
+	u := &RFC2136Updater{Server: "ns1.lan:53"}
+	if err := u.Sync(context.Background(), "lan", updaterRecords); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"server ns1.lan 53", "zone lan", "update add grafana.lan. 60 A 10.0.0.5", "update add _dns._udp.lan. 60 SRV 0 0 5353 dns.lan.", "send"} {
+		if !strings.Contains(scripts[0], line+"\n") {
+			t.Errorf("Expected %q in the script:\n%s", line, scripts[0])
+		}
+	}
+	if err := u.Sync(context.Background(), "lan", updaterRecords[:1]); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(scripts[1], "update delete dns.lan.\nupdate delete _dns._udp.lan.\n") {
+		t.Errorf("Expected the released names to be deleted:\n%s", scripts[1])
+	}
+}
+
+func TestCloudflareUpdater(t *testing.T) {
+	var created []cloudflareRecord
+	var deleted []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]any{"success": false, "errors": []map[string]string{{"message": "bad token"}}})
+			return
+		}
+		var result any
+		switch r.Method {
+		case "GET":
+			result = []cloudflareRecord{
+				{ID: "1", Type: "A", Name: "grafana.lan", Content: "10.0.0.5", Comment: cloudflareComment},
+				{ID: "2", Type: "SRV", Name: "_old._tcp.lan", Data: &cloudflareSRV{Port: 9000, Target: "old.lan"}, Comment: cloudflareComment},
+			}
+		case "POST":
+			var rec cloudflareRecord
+			json.NewDecoder(r.Body).Decode(&rec)
+			created = append(created, rec)
+		case "DELETE":
+			deleted = append(deleted, strings.TrimPrefix(r.URL.Path, "/zones/zone/dns_records/"))
+		}
+		json.NewEncoder(w).Encode(map[string]any{"success": true, "result": result})
+	}))
+	defer ts.Close()
+
+	u := &CloudflareUpdater{BaseURL: ts.URL, Zone: "zone", Token: "token", HTTPClient: ts.Client()}
+	if err := u.Sync(context.Background(), "lan", updaterRecords); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, rec := range created {
+		if rec.Comment != cloudflareComment {
+			t.Errorf("Expected %s to carry the comment", rec.Name)
+		}
+		names = append(names, rec.Type+" "+rec.Name)
+	}
+	if want := []string{"SRV _grafana._tcp.lan", "A dns.lan", "SRV _dns._udp.lan"}; !slices.Equal(names, want) {
+		t.Errorf("Expected %v created, got %v", want, names)
+	}
+	if !slices.Equal(deleted, []string{"2"}) {
+		t.Errorf("Expected the stale record deleted, got %v", deleted)
+	}
+
+	u.Token = "wrong"
+	if err := u.Sync(context.Background(), "lan", updaterRecords); err == nil || !strings.Contains(err.Error(), "bad token") {
+		t.Errorf("Expected the API's error, got %v", err)
+	}
+}
+
+func TestPiholeUpdater(t *testing.T) {
+	var patched []string
+	loggedOut := false
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/auth" && r.Header.Get("X-FTL-SID") != "sid" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.Method + " " + r.URL.Path {
+		case "POST /api/auth":
+			var body struct{ Password string }
+			json.NewDecoder(r.Body).Decode(&body)
+			json.NewEncoder(w).Encode(map[string]any{"session": map[string]any{"valid": body.Password == "pw", "sid": "sid"}})
+		case "DELETE /api/auth":
+			loggedOut = true
+		case "GET /api/config/misc/dnsmasq_lines":
+			w.Write([]byte(`{"config":{"misc":{"dnsmasq_lines":["address=/nas.home/10.0.0.2","host-record=old.lan,10.0.0.5","srv-host=_old._tcp.lan,old.lan,9000"]}}}`))
+		case "PATCH /api/config":
+			var body struct {
+				Config struct {
+					Misc struct {
+						DnsmasqLines []string `json:"dnsmasq_lines"`
+					} `json:"misc"`
+				} `json:"config"`
+			}
+			json.NewDecoder(r.Body).Decode(&body)
+			patched = body.Config.Misc.DnsmasqLines
+		}
+	}))
+	defer ts.Close()
+
+	u := &PiholeUpdater{BaseURL: ts.URL, Password: "pw", HTTPClient: ts.Client()}
+	if err := u.Sync(context.Background(), "lan", updaterRecords); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"address=/nas.home/10.0.0.2",
+		"host-record=grafana.lan,10.0.0.5",
+		"srv-host=_grafana._tcp.lan,grafana.lan,3000",
+		"host-record=dns.lan,10.0.0.5",
+		"srv-host=_dns._udp.lan,dns.lan,5353",
+	}
+	if !slices.Equal(patched, want) {
+		t.Errorf("Expected %v, got %v", want, patched)
+	}
+	if !loggedOut {
+		t.Error("Expected the session to be closed")
+	}
+
+	u.Password = "wrong"
+	if err := u.Sync(context.Background(), "lan", updaterRecords); err == nil {
+		t.Error("Expected a refused login to fail")
+	}
+}
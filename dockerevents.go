@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+)
+
+var errStreamClosed = errors.New("stream closed")
+
+// dockerEventsClient is implemented by the real Docker client; mocks that
+// don't implement it simply fall back to polling
+type dockerEventsClient interface {
+	Events(ctx context.Context, options types.EventsOptions) (<-chan events.Message, <-chan error)
+}
+
+// containerEventFilter limits the stream to events that can change port usage
+func containerEventFilter() filters.Args {
+	return filters.NewArgs(
+		filters.Arg("type", string(events.ContainerEventType)),
+		filters.Arg("event", string(events.ActionCreate)),
+		filters.Arg("event", string(events.ActionStart)),
+		filters.Arg("event", string(events.ActionDie)),
+		filters.Arg("event", string(events.ActionPause)),
+		filters.Arg("event", string(events.ActionUnPause)),
+		filters.Arg("event", string(events.ActionDestroy)),
+	)
+}
+
+// followDockerEvents keeps the inventory current from container events. When
+// the stream drops (daemon restart, socket proxy redeploy) it resubscribes
+// with backoff and does a full resync, since events were missed meanwhile.
+func (s *Server) followDockerEvents(ctx context.Context, maxBackoff time.Duration) {
+	ec, ok := s.client.(dockerEventsClient)
+	if !ok {
+		return
+	}
+
+	backoff := time.Second
+	for {
+		subCtx, cancel := context.WithCancel(ctx)
+		msgs, errs := ec.Events(subCtx, types.EventsOptions{Filters: containerEventFilter()})
+		s.resync(ctx)
+
+		err := s.drainEvents(ctx, msgs, errs, &backoff)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("Docker events stream lost: %v; resubscribing in %v", err, backoff)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// drainEvents handles messages until the stream ends, resetting backoff once
+// the subscription has proven healthy by delivering something
+func (s *Server) drainEvents(ctx context.Context, msgs <-chan events.Message, errs <-chan error, backoff *time.Duration) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-errs:
+			return err
+		case msg, ok := <-msgs:
+			if !ok {
+				return errStreamClosed
+			}
+			*backoff = time.Second
+			if s.applyDockerEvent(msg) {
+				s.wakeWatcher()
+			} else {
+				s.resync(ctx)
+			}
+		}
+	}
+}
+
+// resync rebuilds the snapshot straight from the sources, replacing the
+// cached one, and wakes the watcher so port events go out immediately
+func (s *Server) resync(ctx context.Context) {
+	snap, err := s.collectContainers(ctx)
+	if err != nil {
+		log.Printf("Resync failed: %v", err)
+		return
+	}
+	if s.cache != nil {
+		s.cache.store(snap)
+	}
+	s.wakeWatcher()
+}
+
+func (s *Server) wakeWatcher() {
+	if s.wake != nil {
+		select {
+		case s.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// applyDockerEvent updates the cached snapshot in place for events whose
+// effect is known without asking Docker: a container dying or going away
+// frees its ports in the index, a pause or unpause only changes its state.
+// It reports false when the inventory must be collected again, as for a
+// container starting, whose ports only Docker knows.
+func (s *Server) applyDockerEvent(msg events.Message) bool {
+	if s.cache == nil {
+		return false
+	}
+	var state string
+	switch msg.Action {
+	case events.ActionDie:
+		state = "exited"
+	case events.ActionDestroy:
+		state = ""
+	case events.ActionPause:
+		state = "paused"
+	case events.ActionUnPause:
+		state = "running"
+	default:
+		return false
+	}
+	return s.cache.update(func(snap *Snapshot) bool {
+		i := slices.IndexFunc(snap.Containers, func(c ContainerData) bool { return c.Source == "" && c.ID == msg.Actor.ID })
+		if i == -1 {
+			return false
+		}
+		c := snap.Containers[i]
+		snap.Containers = slices.Clone(snap.Containers)
+		if state == "" {
+			snap.Containers = slices.Delete(snap.Containers, i, i+1)
+		} else {
+			snap.Containers[i].State = state
+		}
+		if indexHolds(c) && (state == "" || !indexHolds(snap.Containers[i])) {
+			snap.Index = snap.Index.Clone()
+			for _, p := range c.Ports {
+				if !portHeld(snap.Containers, int(p.PublicPort)) {
+					snap.Index.Release(int(p.PublicPort))
+				}
+			}
+		}
+		return true
+	})
+}
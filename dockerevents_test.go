@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
+)
+
+// eventsDockerClient serves a scripted Docker events stream per subscription
+type eventsDockerClient struct {
+	MockDockerClient
+	mu    sync.Mutex
+	subs  int
+	lists int
+}
+
+func (c *eventsDockerClient) ContainerList(ctx context.Context, options types.ContainerListOptions) ([]types.Container, error) {
+	c.mu.Lock()
+	c.lists++
+	c.mu.Unlock()
+	return c.MockDockerClient.ContainerList(ctx, options)
+}
+
+func (c *eventsDockerClient) Events(ctx context.Context, options types.EventsOptions) (<-chan events.Message, <-chan error) {
+	c.mu.Lock()
+	c.subs++
+	n := c.subs
+	c.mu.Unlock()
+
+	msgs := make(chan events.Message, 1)
+	errs := make(chan error, 1)
+	if n == 1 {
+		// First subscription drops as if the daemon restarted
+		errs <- errors.New("unexpected EOF")
+	} else {
+		msgs <- events.Message{Type: events.ContainerEventType, Action: events.ActionStart}
+	}
+	return msgs, errs
+}
+
+func (c *eventsDockerClient) counts() (int, int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.subs, c.lists
+}
+
+func TestFollowDockerEventsResubscribes(t *testing.T) {
+	client := &eventsDockerClient{MockDockerClient: MockDockerClient{Containers: []types.Container{{ID: "123", State: "running"}}}}
+	server := &Server{client: client, cache: &snapshotCache{TTL: time.Hour}, wake: make(chan struct{}, 1)}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		server.followDockerEvents(ctx, 10*time.Millisecond)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		subs, lists := client.counts()
+		// Resync on each subscription, plus one for the start event
+		if subs >= 2 && lists >= 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected resubscribe and resync, got %d subscriptions and %d lists", subs, lists)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	select {
+	case <-server.wake:
+	default:
+		t.Error("Expected the watcher to be woken")
+	}
+	if server.cache.current == nil || len(server.cache.current.Containers) != 1 {
+		t.Error("Expected the resync to replace the cached snapshot")
+	}
+}
+
+func TestApplyDockerEvent(t *testing.T) {
+	containers := []ContainerData{
+		{ID: "abc", State: "running", Ports: []PortMapping{{PublicPort: 8080}, {PublicPort: 9000}}},
+		{ID: "def", State: "running", Ports: []PortMapping{{PublicPort: 9090}}},
+		// Another source publishing 9000 keeps it taken
+		{ID: "lxd:web", Source: "lxd", State: "running", Ports: []PortMapping{{PublicPort: 9000}}},
+	}
+	server := &Server{cache: &snapshotCache{TTL: time.Hour}}
+	server.cache.store(Snapshot{Containers: containers, Index: buildPortIndex(containers), TakenAt: time.Now()})
+	before, _ := server.cache.get(context.Background(), nil)
+
+	event := func(action events.Action, id string) events.Message {
+		return events.Message{Type: events.ContainerEventType, Action: action, Actor: events.Actor{ID: id}}
+	}
+	if !server.applyDockerEvent(event(events.ActionDie, "abc")) {
+		t.Fatal("Expected a known container dying to be applied")
+	}
+	snap, _ := server.cache.get(context.Background(), nil)
+	if snap.Index.Used(8080) || !snap.Index.Used(9000) || snap.Containers[0].State != "exited" {
+		t.Errorf("Expected 8080 released and 9000 still held, got %+v", snap.Containers)
+	}
+	if !before.Index.Used(8080) || before.Containers[0].State != "running" {
+		t.Error("Expected snapshots already handed out to stay as they were")
+	}
+
+	if !server.applyDockerEvent(event(events.ActionPause, "def")) {
+		t.Fatal("Expected a pause to be applied")
+	}
+	if !server.applyDockerEvent(event(events.ActionDestroy, "def")) {
+		t.Fatal("Expected a destroy to be applied")
+	}
+	if snap, _ := server.cache.get(context.Background(), nil); snap.Index.Used(9090) || len(snap.Containers) != 2 {
+		t.Errorf("Expected def gone with its port, got %+v", snap.Containers)
+	}
+
+	if server.applyDockerEvent(event(events.ActionStart, "abc")) || server.applyDockerEvent(event(events.ActionDie, "xyz")) {
+		t.Error("Expected starts and unknown containers to need a resync")
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/registry"
+)
+
+// dockerCall is one recorded Docker API call: Call names the method, Arg
+// the container or image it was about, and Result or Error what came back
+type dockerCall struct {
+	Call   string          `json:"call"`
+	Arg    string          `json:"arg,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// recordingClient passes calls through to Docker and appends what they
+// returned to a file, one JSON object per line, for replayClient to play
+// back. An answer identical to the previous one for the same call isn't
+// written again, so polling doesn't grow the file. Environment variables
+// are dropped from inspected containers, being where secrets usually are,
+// so a recording can be attached to a bug report.
+type recordingClient struct {
+	DockerClient
+
+	mu   sync.Mutex
+	file *os.File
+	last map[string]string
+}
+
+func newRecordingClient(client DockerClient, path string) (*recordingClient, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &recordingClient{DockerClient: client, file: f, last: make(map[string]string)}, nil
+}
+
+func (c *recordingClient) record(call, arg string, result any, err error) {
+	entry := dockerCall{Call: call, Arg: arg}
+	if err != nil {
+		entry.Error = err.Error()
+	} else if entry.Result, err = json.Marshal(result); err != nil {
+		return
+	}
+	line, _ := json.Marshal(entry)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := call + " " + arg
+	if c.last[key] == string(line) {
+		return
+	}
+	c.last[key] = string(line)
+	c.file.Write(append(line, '\n'))
+}
+
+func (c *recordingClient) ContainerList(ctx context.Context, options types.ContainerListOptions) ([]types.Container, error) {
+	containers, err := c.DockerClient.ContainerList(ctx, options)
+	c.record("ContainerList", "", containers, err)
+	return containers, err
+}
+
+func (c *recordingClient) ContainerInspect(ctx context.Context, containerID string) (types.ContainerJSON, error) {
+	ic, ok := c.DockerClient.(dockerInspectClient)
+	if !ok {
+		return types.ContainerJSON{}, errors.New("the Docker client cannot inspect containers")
+	}
+	details, err := ic.ContainerInspect(ctx, containerID)
+	sanitized := details
+	if details.Config != nil {
+		config := *details.Config
+		config.Env = nil
+		sanitized.Config = &config
+	}
+	c.record("ContainerInspect", containerID, sanitized, err)
+	return details, err
+}
+
+func (c *recordingClient) ImageInspectWithRaw(ctx context.Context, imageID string) (types.ImageInspect, []byte, error) {
+	ic, ok := c.DockerClient.(dockerImageClient)
+	if !ok {
+		return types.ImageInspect{}, nil, errors.New("the Docker client cannot inspect images")
+	}
+	img, raw, err := ic.ImageInspectWithRaw(ctx, imageID)
+	sanitized := img
+	if img.Config != nil {
+		config := *img.Config
+		config.Env = nil
+		sanitized.Config = &config
+	}
+	c.record("ImageInspect", imageID, sanitized, err)
+	return img, raw, err
+}
+
+func (c *recordingClient) DistributionInspect(ctx context.Context, image, encodedRegistryAuth string) (registry.DistributionInspect, error) {
+	rc, ok := c.DockerClient.(dockerRegistryClient)
+	if !ok {
+		return registry.DistributionInspect{}, errors.New("the Docker client cannot query registries")
+	}
+	info, err := rc.DistributionInspect(ctx, image, encodedRegistryAuth)
+	c.record("DistributionInspect", image, info, err)
+	return info, err
+}
+
+// Events and container actions pass through unrecorded: replaying a
+// stream or a stop has nothing to reproduce that the inventory doesn't show
+
+func (c *recordingClient) Events(ctx context.Context, options types.EventsOptions) (<-chan events.Message, <-chan error) {
+	if ec, ok := c.DockerClient.(dockerEventsClient); ok {
+		return ec.Events(ctx, options)
+	}
+	errs := make(chan error, 1)
+	errs <- errors.New("the Docker client cannot stream events")
+	return nil, errs
+}
+
+func (c *recordingClient) ContainerStop(ctx context.Context, containerID string, options container.StopOptions) error {
+	if lc, ok := c.DockerClient.(dockerLifecycleClient); ok {
+		return lc.ContainerStop(ctx, containerID, options)
+	}
+	return errors.New("the Docker client cannot stop containers")
+}
+
+func (c *recordingClient) ContainerRestart(ctx context.Context, containerID string, options container.StopOptions) error {
+	if lc, ok := c.DockerClient.(dockerLifecycleClient); ok {
+		return lc.ContainerRestart(ctx, containerID, options)
+	}
+	return errors.New("the Docker client cannot restart containers")
+}
+
+// replayClient answers from a recording instead of a daemon. Each call
+// gets the recorded answers for it in order, then the last one for good,
+// so a recording of a daemon going wrong plays out the same way. It has no
+// events and can't act on containers.
+type replayClient struct {
+	mu    sync.Mutex
+	calls map[string][]dockerCall
+	next  map[string]int
+}
+
+func loadDockerRecording(path string) (*replayClient, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	c := &replayClient{calls: make(map[string][]dockerCall), next: make(map[string]int)}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 64<<20)
+	for line := 1; scanner.Scan(); line++ {
+		var call dockerCall
+		if err := json.Unmarshal(scanner.Bytes(), &call); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		key := call.Call + " " + call.Arg
+		c.calls[key] = append(c.calls[key], call)
+	}
+	return c, scanner.Err()
+}
+
+func (c *replayClient) replay(call, arg string, result any) error {
+	key := call + " " + arg
+	c.mu.Lock()
+	recorded := c.calls[key]
+	if len(recorded) == 0 {
+		c.mu.Unlock()
+		return fmt.Errorf("no %s of %q in the recording", call, arg)
+	}
+	entry := recorded[min(c.next[key], len(recorded)-1)]
+	c.next[key]++
+	c.mu.Unlock()
+
+	if entry.Error != "" {
+		return errors.New(entry.Error)
+	}
+	return json.Unmarshal(entry.Result, result)
+}
+
+func (c *replayClient) ContainerList(ctx context.Context, options types.ContainerListOptions) ([]types.Container, error) {
+	var containers []types.Container
+	err := c.replay("ContainerList", "", &containers)
+	return containers, err
+}
+
+func (c *replayClient) ContainerInspect(ctx context.Context, containerID string) (types.ContainerJSON, error) {
+	var details types.ContainerJSON
+	err := c.replay("ContainerInspect", containerID, &details)
+	return details, err
+}
+
+func (c *replayClient) ImageInspectWithRaw(ctx context.Context, imageID string) (types.ImageInspect, []byte, error) {
+	var img types.ImageInspect
+	err := c.replay("ImageInspect", imageID, &img)
+	return img, nil, err
+}
+
+func (c *replayClient) DistributionInspect(ctx context.Context, image, encodedRegistryAuth string) (registry.DistributionInspect, error) {
+	var info registry.DistributionInspect
+	err := c.replay("DistributionInspect", image, &info)
+	return info, err
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+)
+
+func TestDockerRecordReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "docker.jsonl")
+	inner := &inspectDockerClient{
+		MockDockerClient: MockDockerClient{Containers: []types.Container{{ID: "a", State: "running"}}},
+		details: map[string]types.ContainerJSON{
+			"a": {ContainerJSONBase: &types.ContainerJSONBase{RestartCount: 2}, Config: &container.Config{Env: []string{"DB_PASSWORD=hunter2"}}},
+		},
+	}
+	rec, err := newRecordingClient(inner, path)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	ctx := context.Background()
+
+	rec.ContainerList(ctx, types.ContainerListOptions{All: true})
+	rec.ContainerList(ctx, types.ContainerListOptions{All: true})
+	details, _ := rec.ContainerInspect(ctx, "a")
+	if len(details.Config.Env) != 1 {
+		t.Error("Expected the caller to get the environment")
+	}
+	rec.ContainerInspect(ctx, "gone")
+	inner.Err = errors.New("Cannot connect to the Docker daemon")
+	rec.ContainerList(ctx, types.ContainerListOptions{All: true})
+
+	data, _ := os.ReadFile(path)
+	if strings.Contains(string(data), "hunter2") {
+		t.Error("Expected environment variables left out of the recording")
+	}
+	if n := strings.Count(string(data), "\n"); n != 4 {
+		t.Errorf("Expected the repeated list recorded once, 4 lines in all, got %d:\n%s", n, data)
+	}
+
+	replay, err := loadDockerRecording(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	containers, err := replay.ContainerList(ctx, types.ContainerListOptions{})
+	if err != nil || len(containers) != 1 || containers[0].ID != "a" {
+		t.Errorf("Expected the recorded list first, got %v %v", containers, err)
+	}
+	for range 2 {
+		if _, err := replay.ContainerList(ctx, types.ContainerListOptions{}); err == nil || err.Error() != "Cannot connect to the Docker daemon" {
+			t.Errorf("Expected the recorded error from then on, got %v", err)
+		}
+	}
+	if details, err := replay.ContainerInspect(ctx, "a"); err != nil || details.RestartCount != 2 {
+		t.Errorf("Expected the recorded inspect, got %+v %v", details, err)
+	}
+	if _, err := replay.ContainerInspect(ctx, "gone"); err == nil {
+		t.Error("Expected the recorded inspect error")
+	}
+	if _, err := replay.ContainerInspect(ctx, "never"); err == nil {
+		t.Error("Expected an error for a call missing from the recording")
+	}
+}
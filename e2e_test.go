@@ -0,0 +1,247 @@
+//go:build e2e
+
+package main
+
+// The end-to-end suite runs quaycheck's HTTP API against the Docker daemon
+// at DOCKER_HOST, starting throwaway busybox containers for what a mock
+// can't stand in for: how the daemon itself reports host networking, port
+// ranges, IPv6 bindings and udp. Run it with `make e2e`; it needs a daemon
+// that can pull busybox.
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/docker/go-connections/nat"
+)
+
+const e2eImage = "busybox:1.36"
+
+// e2eEnv is the daemon the suite drives and a quaycheck server reading it
+type e2eEnv struct {
+	docker *client.Client
+	server *httptest.Server
+}
+
+func newE2EEnv(t *testing.T) *e2eEnv {
+	t.Helper()
+	ctx := context.Background()
+	docker, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		t.Fatalf("Cannot connect to Docker: %v", err)
+	}
+	if _, err := docker.Ping(ctx); err != nil {
+		t.Fatalf("Docker is not answering: %v", err)
+	}
+	if _, _, err := docker.ImageInspectWithRaw(ctx, e2eImage); err != nil {
+		pull, err := docker.ImagePull(ctx, e2eImage, types.ImagePullOptions{})
+		if err != nil {
+			t.Fatalf("Cannot pull %s: %v", e2eImage, err)
+		}
+		io.Copy(io.Discard, pull)
+		pull.Close()
+	}
+
+	cli, err := NewDockerClient()
+	if err != nil {
+		t.Fatalf("Cannot create the quaycheck client: %v", err)
+	}
+	server := httptest.NewServer(SetupRouter(&Server{client: cli}))
+	t.Cleanup(func() {
+		server.Close()
+		docker.Close()
+	})
+	return &e2eEnv{docker: docker, server: server}
+}
+
+// run starts a container that sleeps, publishing bindings ("[ip:]host:container[/proto]"
+// as for docker run -p), and removes it when the test ends
+func (e *e2eEnv) run(t *testing.T, networkMode string, bindings ...string) string {
+	t.Helper()
+	exposed, portMap, err := nat.ParsePortSpecs(bindings)
+	if err != nil {
+		t.Fatalf("Invalid bindings %q: %v", bindings, err)
+	}
+	name := fmt.Sprintf("quaycheck-e2e-%s-%d", strings.ToLower(strings.ReplaceAll(t.Name(), "/", "-")), time.Now().UnixNano())
+	ctx := context.Background()
+	created, err := e.docker.ContainerCreate(ctx,
+		&container.Config{Image: e2eImage, Cmd: []string{"sleep", "600"}, ExposedPorts: exposed},
+		&container.HostConfig{NetworkMode: container.NetworkMode(networkMode), PortBindings: portMap},
+		nil, nil, name)
+	if err != nil {
+		t.Fatalf("Cannot create %s: %v", name, err)
+	}
+	t.Cleanup(func() {
+		e.docker.ContainerRemove(context.Background(), created.ID, container.RemoveOptions{Force: true})
+	})
+	if err := e.docker.ContainerStart(ctx, created.ID, container.StartOptions{}); err != nil {
+		if strings.Contains(err.Error(), "::") {
+			t.Skipf("The daemon has no IPv6: %v", err)
+		}
+		t.Fatalf("Cannot start %s: %v", name, err)
+	}
+	return name
+}
+
+// get decodes the JSON answer to path into v, failing the test unless it
+// is a 200
+func (e *e2eEnv) get(t *testing.T, path string, v any) {
+	t.Helper()
+	resp, err := http.Get(e.server.URL + path)
+	if err != nil {
+		t.Fatalf("GET %s: %v", path, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		t.Fatalf("GET %s: %s: %s", path, resp.Status, body)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatalf("GET %s: %v", path, err)
+	}
+}
+
+// container finds name in /api/ports
+func (e *e2eEnv) container(t *testing.T, name string) ContainerData {
+	t.Helper()
+	var containers []ContainerData
+	e.get(t, "/api/ports", &containers)
+	for _, c := range containers {
+		if containerName(c) == name {
+			return c
+		}
+	}
+	t.Fatalf("Expected %s in /api/ports", name)
+	return ContainerData{}
+}
+
+func (e *e2eEnv) check(t *testing.T, port int) CheckResponse {
+	t.Helper()
+	var resp CheckResponse
+	e.get(t, "/api/check?port="+strconv.Itoa(port), &resp)
+	return resp
+}
+
+// freePorts finds n consecutive host ports nothing listens on, tcp or udp
+func freePorts(t *testing.T, n int) int {
+	t.Helper()
+	for base := 20000 + int(time.Now().UnixNano()%20000); base < 65000; base += n {
+		free := true
+		for p := base; p < base+n && free; p++ {
+			l, err := net.Listen("tcp", ":"+strconv.Itoa(p))
+			if err != nil {
+				free = false
+				break
+			}
+			l.Close()
+			u, err := net.ListenPacket("udp", ":"+strconv.Itoa(p))
+			if err != nil {
+				free = false
+				break
+			}
+			u.Close()
+		}
+		if free {
+			return base
+		}
+	}
+	t.Fatalf("No %d consecutive free ports", n)
+	return 0
+}
+
+func TestE2EPublishedTCP(t *testing.T) {
+	e := newE2EEnv(t)
+	port := freePorts(t, 1)
+	name := e.run(t, "bridge", fmt.Sprintf("127.0.0.1:%d:80", port))
+
+	c := e.container(t, name)
+	if len(c.Ports) != 1 || int(c.Ports[0].PublicPort) != port || c.Ports[0].PrivatePort != 80 || c.Ports[0].Type != "tcp" || c.Ports[0].IP != "127.0.0.1" {
+		t.Errorf("Expected 127.0.0.1:%d->80/tcp, got %+v", port, c.Ports)
+	}
+	if resp := e.check(t, port); resp.Available || resp.OccupiedBy != name {
+		t.Errorf("Expected %d held by %s, got %+v", port, name, resp)
+	}
+}
+
+func TestE2EPublishedUDP(t *testing.T) {
+	e := newE2EEnv(t)
+	port := freePorts(t, 1)
+	name := e.run(t, "bridge", fmt.Sprintf("%d:53/udp", port))
+
+	c := e.container(t, name)
+	if len(c.Ports) == 0 {
+		t.Fatalf("Expected %s to publish %d/udp", name, port)
+	}
+	for _, p := range c.Ports {
+		if int(p.PublicPort) != port || p.Type != "udp" {
+			t.Errorf("Expected only %d/udp, got %+v", port, p)
+		}
+	}
+	if resp := e.check(t, port); resp.Available {
+		t.Errorf("Expected udp port %d in use, got %+v", port, resp)
+	}
+}
+
+func TestE2EPortRange(t *testing.T) {
+	e := newE2EEnv(t)
+	base := freePorts(t, 6)
+	e.run(t, "bridge", fmt.Sprintf("%d-%d:7000-7002", base+1, base+3))
+
+	var ranges RangesResponse
+	e.get(t, fmt.Sprintf("/api/ranges?start=%d&end=%d", base, base+5), &ranges)
+	want := []PortRange{{Start: base, End: base}, {Start: base + 4, End: base + 5}}
+	if len(ranges.Free) != len(want) || ranges.Free[0] != want[0] || ranges.Free[1] != want[1] {
+		t.Errorf("Expected free %v around the published range, got %v", want, ranges.Free)
+	}
+	for p := base + 1; p <= base+3; p++ {
+		if resp := e.check(t, p); resp.Available {
+			t.Errorf("Expected %d of the range in use", p)
+		}
+	}
+}
+
+func TestE2EIPv6(t *testing.T) {
+	e := newE2EEnv(t)
+	port := freePorts(t, 1)
+	name := e.run(t, "bridge", fmt.Sprintf("[::1]:%d:80", port))
+
+	c := e.container(t, name)
+	if len(c.Ports) != 1 || int(c.Ports[0].PublicPort) != port || c.Ports[0].IP != "::1" {
+		t.Errorf("Expected [::1]:%d->80/tcp, got %+v", port, c.Ports)
+	}
+	if resp := e.check(t, port); resp.Available {
+		t.Errorf("Expected %d in use on ::1, got %+v", port, resp)
+	}
+}
+
+// A host-networked container binds the host's ports directly, and Docker
+// reports none of them: quaycheck lists the container without ports, and
+// can't tell what it listens on
+func TestE2EHostNetwork(t *testing.T) {
+	if os.Getenv("DOCKER_HOST") != "" && !strings.HasPrefix(os.Getenv("DOCKER_HOST"), "unix://") {
+		t.Skip("host networking is the remote daemon's, not this machine's")
+	}
+	e := newE2EEnv(t)
+	name := e.run(t, "host")
+
+	c := e.container(t, name)
+	if len(c.Ports) != 0 {
+		t.Errorf("Expected no ports reported for a host-networked container, got %+v", c.Ports)
+	}
+	if c.State != "running" {
+		t.Errorf("Expected %s running, got %s", name, c.State)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+const mimeMsgpack = "application/msgpack"
+
+// wantsMsgpack reports whether the Accept header prefers MessagePack over JSON
+func wantsMsgpack(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mt, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		switch mt {
+		case mimeMsgpack, "application/x-msgpack", "application/vnd.msgpack":
+			return true
+		case "application/json":
+			return false
+		}
+	}
+	return false
+}
+
+// writeEncoded writes v as MessagePack or JSON depending on the Accept
+// header. MessagePack uses the JSON field names so both decode the same way.
+func writeEncoded(w http.ResponseWriter, r *http.Request, v any) {
+	w.Header().Add("Vary", "Accept")
+	if wantsMsgpack(r) {
+		w.Header().Set("Content-Type", mimeMsgpack)
+		enc := msgpack.NewEncoder(w)
+		enc.SetCustomStructTag("json")
+		enc.Encode(v)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// decodeResponse decodes a body written by writeEncoded
+func decodeResponse(resp *http.Response, v any) error {
+	mt, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mt == mimeMsgpack {
+		dec := msgpack.NewDecoder(resp.Body)
+		dec.SetCustomStructTag("json")
+		return dec.Decode(v)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// snapshotEncoding memoizes the encoded inventory of one snapshot so polling
+// dashboards don't re-marshal hundreds of containers on every request. A new
+// snapshot gets a new snapshotEncoding, which is what invalidates it.
+type snapshotEncoding struct {
+	count int
+
+	mu     sync.Mutex
+	bodies map[string][]byte
+}
+
+func newSnapshotEncoding(containers []ContainerData) *snapshotEncoding {
+	return &snapshotEncoding{count: len(containers), bodies: make(map[string][]byte)}
+}
+
+func marshalAs(contentType string, containers []ContainerData) ([]byte, error) {
+	if contentType == mimeMsgpack {
+		var buf bytes.Buffer
+		enc := msgpack.NewEncoder(&buf)
+		enc.SetCustomStructTag("json")
+		err := enc.Encode(containers)
+		return buf.Bytes(), err
+	}
+	data, err := appendContainersJSON(make([]byte, 0, 512*len(containers)), containers)
+	return append(data, '\n'), err
+}
+
+// encodeContainers returns the snapshot inventory encoded as contentType.
+// Entries appended after the snapshot was taken (reservations) are encoded
+// per call and spliced onto the memoized JSON body.
+func (snap Snapshot) encodeContainers(contentType string) ([]byte, error) {
+	e := snap.encoding
+	if e == nil || e.count > len(snap.Containers) {
+		return marshalAs(contentType, snap.Containers)
+	}
+
+	e.mu.Lock()
+	base, ok := e.bodies[contentType]
+	if !ok {
+		var err error
+		if base, err = marshalAs(contentType, snap.Containers[:e.count]); err != nil {
+			e.mu.Unlock()
+			return nil, err
+		}
+		e.bodies[contentType] = base
+	}
+	e.mu.Unlock()
+
+	tail := snap.Containers[e.count:]
+	switch {
+	case len(tail) == 0:
+		return base, nil
+	case contentType != "application/json" || e.count == 0:
+		return marshalAs(contentType, snap.Containers)
+	}
+
+	extra, err := appendContainersJSON(nil, tail)
+	if err != nil {
+		return nil, err
+	}
+	body := make([]byte, 0, len(base)+len(extra))
+	body = append(body, bytes.TrimRight(base, "]\n")...)
+	body = append(body, ',')
+	body = append(body, extra[1:]...)
+	return append(body, '\n'), nil
+}
+
+// writeSnapshotBody writes the inventory with an ETag, answering 304 when the
+// client already has it
+func writeSnapshotBody(w http.ResponseWriter, r *http.Request, snap Snapshot) {
+	contentType := "application/json"
+	if wantsMsgpack(r) {
+		contentType = mimeMsgpack
+	}
+	body, err := snap.encodeContainers(contentType)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "encoding_error", err.Error())
+		return
+	}
+
+	sum := fnv.New64a()
+	sum.Write(body)
+	etag := fmt.Sprintf(`"%x"`, sum.Sum64())
+
+	w.Header().Add("Vary", "Accept")
+	w.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Write(body)
+}
@@ -0,0 +1,146 @@
+//go:build !stdjson
+
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+)
+
+// appendContainersJSON appends containers as JSON, byte for byte what
+// encoding/json produces. Reflection dominates marshalling a 600-container
+// inventory, so /api/ports writes the fields by hand; build with -tags
+// stdjson to go back to encoding/json. A field added to ContainerData or
+// PortMapping must be added here too, TestAppendContainersJSON catches it.
+func appendContainersJSON(dst []byte, containers []ContainerData) ([]byte, error) {
+	if containers == nil {
+		return append(dst, "null"...), nil
+	}
+	dst = append(dst, '[')
+	for i := range containers {
+		if i > 0 {
+			dst = append(dst, ',')
+		}
+		var err error
+		if dst, err = appendContainerJSON(dst, &containers[i]); err != nil {
+			return nil, err
+		}
+	}
+	return append(dst, ']'), nil
+}
+
+func appendContainerJSON(dst []byte, c *ContainerData) ([]byte, error) {
+	dst = append(dst, `{"id":`...)
+	dst = appendJSONString(dst, c.ID)
+	dst = append(dst, `,"names":`...)
+	dst = appendJSONStrings(dst, c.Names)
+	dst = append(dst, `,"image":`...)
+	dst = appendJSONString(dst, c.Image)
+	dst = append(dst, `,"state":`...)
+	dst = appendJSONString(dst, c.State)
+	dst = appendOptionalString(dst, "status", c.Status)
+	dst = appendOptionalString(dst, "health", c.Health)
+	dst = append(dst, `,"ports":`...)
+	if c.Ports == nil {
+		dst = append(dst, "null"...)
+	} else {
+		dst = append(dst, '[')
+		for i, p := range c.Ports {
+			if i > 0 {
+				dst = append(dst, ',')
+			}
+			dst = append(dst, `{"private_port":`...)
+			dst = strconv.AppendUint(dst, uint64(p.PrivatePort), 10)
+			dst = append(dst, `,"public_port":`...)
+			dst = strconv.AppendUint(dst, uint64(p.PublicPort), 10)
+			dst = append(dst, `,"type":`...)
+			dst = appendJSONString(dst, p.Type)
+			dst = appendOptionalString(dst, "ip", p.IP)
+			dst = append(dst, '}')
+		}
+		dst = append(dst, ']')
+	}
+	dst = appendOptionalString(dst, "source", c.Source)
+	if c.StartedAt != nil {
+		var err error
+		dst = append(dst, `,"started_at":"`...)
+		if dst, err = c.StartedAt.AppendText(dst); err != nil {
+			return nil, err
+		}
+		dst = append(dst, '"')
+	}
+	if c.UptimeSeconds != 0 {
+		dst = append(dst, `,"uptime_seconds":`...)
+		dst = strconv.AppendInt(dst, c.UptimeSeconds, 10)
+	}
+	if c.RestartCount != 0 {
+		dst = append(dst, `,"restart_count":`...)
+		dst = strconv.AppendInt(dst, int64(c.RestartCount), 10)
+	}
+	dst = appendOptionalString(dst, "image_digest", c.ImageDigest)
+	if c.UpdateAvailable {
+		dst = append(dst, `,"update_available":true`...)
+	}
+	dst = appendOptionalString(dst, "project", c.Project)
+	dst = appendOptionalString(dst, "service", c.Service)
+	if c.Replica != 0 {
+		dst = append(dst, `,"replica":`...)
+		dst = strconv.AppendInt(dst, int64(c.Replica), 10)
+	}
+	dst = appendOptionalStrings(dst, "compose_files", c.ComposeFiles)
+	dst = appendOptionalString(dst, "compose_dir", c.ComposeDir)
+	dst = appendOptionalString(dst, "owner", c.Owner)
+	dst = appendOptionalStrings(dst, "networks", c.Networks)
+	dst = appendOptionalString(dst, "environment", c.Environment)
+	dst = appendOptionalStrings(dst, "advertise", c.Advertise)
+	return append(dst, '}'), nil
+}
+
+func appendOptionalString(dst []byte, key, s string) []byte {
+	if s == "" {
+		return dst
+	}
+	dst = append(dst, ",\""...)
+	dst = append(dst, key...)
+	dst = append(dst, "\":"...)
+	return appendJSONString(dst, s)
+}
+
+func appendOptionalStrings(dst []byte, key string, ss []string) []byte {
+	if len(ss) == 0 {
+		return dst
+	}
+	dst = append(dst, ",\""...)
+	dst = append(dst, key...)
+	dst = append(dst, "\":"...)
+	return appendJSONStrings(dst, ss)
+}
+
+func appendJSONStrings(dst []byte, ss []string) []byte {
+	if ss == nil {
+		return append(dst, "null"...)
+	}
+	dst = append(dst, '[')
+	for i, s := range ss {
+		if i > 0 {
+			dst = append(dst, ',')
+		}
+		dst = appendJSONString(dst, s)
+	}
+	return append(dst, ']')
+}
+
+// appendJSONString quotes s. Names, images and states are plain ASCII, so
+// anything that would need escaping is left to encoding/json.
+func appendJSONString(dst []byte, s string) []byte {
+	for i := 0; i < len(s); i++ {
+		b := s[i]
+		if b < 0x20 || b >= 0x7f || b == '"' || b == '\\' || b == '<' || b == '>' || b == '&' {
+			quoted, _ := json.Marshal(s)
+			return append(dst, quoted...)
+		}
+	}
+	dst = append(dst, '"')
+	dst = append(dst, s...)
+	return append(dst, '"')
+}
@@ -0,0 +1,14 @@
+//go:build stdjson
+
+package main
+
+import "encoding/json"
+
+// appendContainersJSON appends containers as JSON using encoding/json
+func appendContainersJSON(dst []byte, containers []ContainerData) ([]byte, error) {
+	data, err := json.Marshal(containers)
+	if err != nil {
+		return nil, err
+	}
+	return append(dst, data...), nil
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+func TestWantsMsgpack(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   bool
+	}{
+		{"", false},
+		{"*/*", false},
+		{"application/json", false},
+		{"application/msgpack", true},
+		{"application/x-msgpack, application/json;q=0.5", true},
+		{"application/json, application/msgpack", false},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/api/ports", nil)
+		req.Header.Set("Accept", tt.accept)
+		if got := wantsMsgpack(req); got != tt.want {
+			t.Errorf("Accept %q: expected %v, got %v", tt.accept, tt.want, got)
+		}
+	}
+}
+
+func TestHandlePortsMsgpack(t *testing.T) {
+	server := &Server{client: &MockDockerClient{Containers: []types.Container{
+		{ID: "123", Names: []string{"/web"}, State: "running", Ports: []types.Port{{PrivatePort: 80, PublicPort: 8080, Type: "tcp"}}},
+	}}}
+
+	req := httptest.NewRequest("GET", "/api/ports", nil)
+	req.Header.Set("Accept", "application/msgpack")
+	w := httptest.NewRecorder()
+	server.handlePorts(w, req)
+
+	resp := w.Result()
+	if resp.Header.Get("Content-Type") != "application/msgpack" || resp.Header.Get("Vary") != "Accept" {
+		t.Fatalf("Unexpected headers: %v", resp.Header)
+	}
+
+	var containers []ContainerData
+	if err := decodeResponse(resp, &containers); err != nil {
+		t.Fatalf("Expected valid msgpack, got %v", err)
+	}
+	if len(containers) != 1 || containers[0].ID != "123" || containers[0].Ports[0].PublicPort != 8080 {
+		t.Errorf("Unexpected decoded containers: %+v", containers)
+	}
+}
+
+func TestSnapshotEncodingSplicesReservations(t *testing.T) {
+	store, _ := NewReservationStore(nil)
+	store.Ensure(Reservation{Name: "grafana", Port: 3000}, nil, 0, false)
+	server := &Server{
+		client:       &MockDockerClient{Containers: []types.Container{{ID: "123", State: "running"}}},
+		reservations: store,
+		cache:        &snapshotCache{TTL: time.Hour},
+	}
+
+	snap, _ := server.loadSnapshot(context.Background())
+	body, err := snap.encodeContainers("application/json")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	want, _ := json.Marshal(snap.Containers)
+	if strings.TrimSpace(string(body)) != string(want) {
+		t.Errorf("Spliced body differs:\n got %s\nwant %s", body, want)
+	}
+
+	again, _ := server.loadSnapshot(context.Background())
+	again.encodeContainers("application/json")
+	if snap.encoding != again.encoding || len(snap.encoding.bodies) != 1 {
+		t.Error("Expected the base encoding to be memoized across requests")
+	}
+}
+
+func TestHandlePortsETag(t *testing.T) {
+	server := &Server{client: &MockDockerClient{Containers: []types.Container{{ID: "123"}}}}
+
+	w := httptest.NewRecorder()
+	server.handlePorts(w, httptest.NewRequest("GET", "/api/ports", nil))
+	etag := w.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("Expected an ETag")
+	}
+
+	req := httptest.NewRequest("GET", "/api/ports", nil)
+	req.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	server.handlePorts(w, req)
+	if w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Errorf("Expected 304 with empty body, got %d", w.Code)
+	}
+}
+
+// filledContainer sets every field of ContainerData, so a field the hand
+// encoder doesn't know about shows up as a difference
+func filledContainer(s string) ContainerData {
+	var c ContainerData
+	started := time.Date(2026, 3, 1, 12, 0, 0, 500, time.UTC)
+	v := reflect.ValueOf(&c).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Field(i)
+		switch f.Kind() {
+		case reflect.String:
+			f.SetString(s)
+		case reflect.Int, reflect.Int64:
+			f.SetInt(42)
+		case reflect.Bool:
+			f.SetBool(true)
+		case reflect.Pointer:
+			f.Set(reflect.ValueOf(&started))
+		case reflect.Slice:
+			if f.Type().Elem().Kind() == reflect.String {
+				f.Set(reflect.ValueOf([]string{s, "b"}))
+			} else {
+				f.Set(reflect.ValueOf([]PortMapping{{PrivatePort: 80, PublicPort: 8080, Type: "tcp", IP: s}, {PublicPort: 53, Type: "udp"}}))
+			}
+		default:
+			panic("filledContainer: unhandled " + f.Kind().String())
+		}
+	}
+	return c
+}
+
+func TestAppendContainersJSON(t *testing.T) {
+	tests := [][]ContainerData{
+		nil,
+		{},
+		{{}},
+		{{ID: "abc", Names: []string{}, Ports: []PortMapping{}}},
+		{filledContainer("web"), filledContainer(`"a<b>&c\   é` + "\x00\xff")},
+	}
+
+	for _, containers := range tests {
+		want, _ := json.Marshal(containers)
+		got, err := appendContainersJSON(nil, containers)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if string(got) != string(want) {
+			t.Errorf("Encoding differs from encoding/json:\n got %s\nwant %s", got, want)
+		}
+	}
+}
+
+// benchmarkContainers is a 600-container inventory like a busy host's
+func benchmarkContainers() []ContainerData {
+	started := time.Now()
+	containers := make([]ContainerData, 600)
+	for i := range containers {
+		containers[i] = ContainerData{
+			ID:            fmt.Sprintf("%064x", i),
+			Names:         []string{fmt.Sprintf("stack%d-web-%d", i/10, i%10)},
+			Image:         "ghcr.io/example/web:1.2.3",
+			State:         "running",
+			Status:        "Up 3 days",
+			Health:        "healthy",
+			Ports:         []PortMapping{{PrivatePort: 80, PublicPort: uint16(10000 + i), Type: "tcp", IP: "0.0.0.0"}, {PrivatePort: 80, PublicPort: uint16(10000 + i), Type: "tcp", IP: "::"}},
+			StartedAt:     &started,
+			UptimeSeconds: 259200,
+			Project:       fmt.Sprintf("stack%d", i/10),
+			Service:       "web",
+			Replica:       i%10 + 1,
+			Networks:      []string{fmt.Sprintf("stack%d_default", i/10)},
+		}
+	}
+	return containers
+}
+
+func BenchmarkEncodeContainers(b *testing.B) {
+	containers := benchmarkContainers()
+
+	b.Run("encoding/json", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			json.Marshal(containers)
+		}
+	})
+	b.Run("append", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			marshalAs("application/json", containers)
+		}
+	})
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Environments groups hosts under labels such as prod or staging, each
+// with a port plan of its own: a request with ?env= only sees the hosts and
+// reservations of that environment.
+type Environments map[string][]string
+
+// parseEnvironments reads env=host,host;env=host, e.g.
+// prod=local,lb1;staging=lb2. A host belongs to one environment at most.
+func parseEnvironments(v string) (Environments, error) {
+	envs := make(Environments)
+	seen := make(map[string]string)
+	for _, group := range strings.Split(v, ";") {
+		group = strings.TrimSpace(group)
+		if group == "" {
+			continue
+		}
+		name, hosts, ok := strings.Cut(group, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" || strings.ContainsAny(name, " ,/") {
+			return nil, fmt.Errorf("invalid environment %q, expected name=host,host", group)
+		}
+		if _, dup := envs[name]; dup {
+			return nil, fmt.Errorf("environment %s is listed twice", name)
+		}
+		list := []string{}
+		for _, h := range strings.Split(hosts, ",") {
+			if h = strings.TrimSpace(h); h == "" {
+				continue
+			}
+			if other, dup := seen[h]; dup {
+				return nil, fmt.Errorf("host %s is in both %s and %s", h, other, name)
+			}
+			seen[h] = name
+			list = append(list, h)
+		}
+		envs[name] = list
+	}
+	return envs, nil
+}
+
+func environmentsFromEnv() Environments {
+	v := os.Getenv("QUAYCHECK_ENVIRONMENTS")
+	if v == "" {
+		return nil
+	}
+	envs, err := parseEnvironments(v)
+	if err != nil {
+		log.Fatalf("Invalid QUAYCHECK_ENVIRONMENTS: %v", err)
+	}
+	return envs
+}
+
+// of returns the environment host belongs to, or ""
+func (e Environments) of(host string) string {
+	for name, hosts := range e {
+		if slices.Contains(hosts, host) {
+			return name
+		}
+	}
+	return ""
+}
+
+type environmentKey struct{}
+
+// environmentFrom returns the environment a request is scoped to, or ""
+func environmentFrom(ctx context.Context) string {
+	env, _ := ctx.Value(environmentKey{}).(string)
+	return env
+}
+
+// withEnvironment scopes the request to ?env=, refusing environments that
+// aren't configured
+func (s *Server) withEnvironment(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		env := r.URL.Query().Get("env")
+		if env == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if _, ok := s.environments[env]; !ok {
+			writeError(w, http.StatusBadRequest, "invalid_param", localize(w, "Unknown environment %q", env))
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), environmentKey{}, env)))
+	})
+}
+
+// sharesEnvironment tells whether reservations in environments a and b
+// compete for ports. Reservations without an environment hold their port in
+// all of them.
+func sharesEnvironment(a, b string) bool {
+	return a == "" || b == "" || a == b
+}
+
+// scopeSnapshot keeps what the request's environment sees: entries on its
+// hosts, plus reservations made in it or in no environment
+func (s *Server) scopeSnapshot(ctx context.Context, snap Snapshot) Snapshot {
+	env := environmentFrom(ctx)
+	if env == "" {
+		return snap
+	}
+	hosts := s.environments[env]
+	var kept []ContainerData
+	for _, c := range snap.Containers {
+		if holdsEverywhere(c) {
+			if sharesEnvironment(c.Environment, env) {
+				kept = append(kept, c)
+			}
+		} else if slices.Contains(hosts, hostOf(c)) {
+			kept = append(kept, c)
+		}
+	}
+	snap.Containers = kept
+	snap.Index = buildPortIndexFunc(kept, func(c ContainerData) bool { return occupiesPorts(c.State) })
+	snap.scoped = true
+	snap.encoding = nil
+	return snap
+}
+
+// collectScoped is a fresh inventory, without reservations, limited to the
+// request's environment. Reservation writes plan against it rather than the
+// cache, joining a collection already in flight so a burst of them doesn't
+// list containers once each.
+func (s *Server) collectScoped(ctx context.Context) (Snapshot, error) {
+	snap, err := s.sharedCollect(ctx)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	return s.scopeSnapshot(ctx, snap), nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseEnvironments(t *testing.T) {
+	envs, err := parseEnvironments(" prod = local, lb1 ;staging=lb2;")
+	if err != nil || len(envs) != 2 || envs.of("lb1") != "prod" || envs.of("lb2") != "staging" || envs.of("nas") != "" {
+		t.Errorf("Unexpected environments: %v, %v", envs, err)
+	}
+	for _, v := range []string{"prod", "=lb1", "prod=lb1;staging=lb1", "prod=lb1;prod=lb2"} {
+		if _, err := parseEnvironments(v); err == nil {
+			t.Errorf("Expected %q to be refused", v)
+		}
+	}
+}
+
+func TestEnvironmentsKeepSeparatePlans(t *testing.T) {
+	db := openTestDB(t)
+	server := newMultiHostServer()
+	server.reservations, _ = NewReservationStore(db)
+	server.environments = Environments{"prod": {"local", "lb1"}, "staging": {"lb2"}}
+	mux := SetupRouter(server)
+
+	allocate := func(env, name string) AllocateResponse {
+		t.Helper()
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("POST", "/api/allocate"+env, strings.NewReader(`{"name":"`+name+`","start":9000}`)))
+		var resp AllocateResponse
+		json.NewDecoder(w.Body).Decode(&resp)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("Expected 201 for %s, got %d", name, w.Code)
+		}
+		return resp
+	}
+	// Each environment gets 9000; a reservation outside any environment
+	// must avoid both
+	if got := allocate("?env=prod", "prod-web"); got.Port != 9000 || got.Environment != "prod" {
+		t.Errorf("Expected prod-web on 9000 in prod, got %+v", got.Reservation)
+	}
+	if got := allocate("?env=staging", "staging-web"); got.Port != 9000 {
+		t.Errorf("Expected staging-web on 9000 too, got %d", got.Port)
+	}
+	if got := allocate("", "shared"); got.Port != 9001 {
+		t.Errorf("Expected the shared reservation to skip 9000, got %d", got.Port)
+	}
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/api/reservations?env=staging", nil))
+	var list []Reservation
+	json.NewDecoder(w.Body).Decode(&list)
+	if len(list) != 2 || list[0].Name != "staging-web" || list[1].Name != "shared" {
+		t.Errorf("Expected staging's and the shared reservation, got %+v", list)
+	}
+
+	// staging only holds lb2: local's 8000 is free there, lb2's 8002 isn't
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/api/check?port=8000&env=staging", nil))
+	var check CheckResponse
+	json.NewDecoder(w.Body).Decode(&check)
+	if !check.Available {
+		t.Errorf("Expected 8000 to be free in staging, got %+v", check)
+	}
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/api/suggest/common?env=prod&start=8000", nil))
+	var common CommonPortResponse
+	json.NewDecoder(w.Body).Decode(&common)
+	if common.Port != 8002 || len(common.Hosts) != 2 {
+		t.Errorf("Expected 8002 on prod's two hosts, got %+v", common)
+	}
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/api/hosts?env=prod", nil))
+	var hosts []HostInfo
+	json.NewDecoder(w.Body).Decode(&hosts)
+	if len(hosts) != 2 || hosts[1].Name != "lb1" || hosts[1].Environment != "prod" {
+		t.Errorf("Expected prod's hosts, got %+v", hosts)
+	}
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/api/ports?env=dev", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unknown environment, got %d", w.Code)
+	}
+
+	reloaded, _ := NewReservationStore(db)
+	if res, _ := reloaded.Get("staging", "staging-web"); res.Environment != "staging" {
+		t.Errorf("Expected the environment to be stored, got %+v", res)
+	}
+}
+
+func TestEnvironmentsReuseReservationNames(t *testing.T) {
+	db := openTestDB(t)
+	server := newMultiHostServer()
+	server.reservations, _ = NewReservationStore(db)
+	server.environments = Environments{"prod": {"local", "lb1"}, "staging": {"lb2"}}
+	mux := SetupRouter(server)
+
+	for _, env := range []string{"prod", "staging"} {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("POST", "/api/reservations?env="+env, strings.NewReader(`{"name":"grafana","port":9000,"owner":"`+env+`"}`)))
+		if w.Code != http.StatusCreated {
+			t.Fatalf("Expected %s to reserve grafana, got %d %s", env, w.Code, w.Body)
+		}
+	}
+
+	req := httptest.NewRequest("DELETE", "/api/reservations/grafana?env=staging", nil)
+	req.Header.Set("If-Match", "*")
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("Expected staging's grafana to be deleted, got %d", w.Code)
+	}
+
+	reloaded, _ := NewReservationStore(db)
+	if res, ok := reloaded.Get("prod", "grafana"); !ok || res.Owner != "prod" {
+		t.Errorf("Expected prod's grafana to stay, got %+v", res)
+	}
+	if _, ok := reloaded.Get("staging", "grafana"); ok {
+		t.Error("Expected staging's grafana to be gone")
+	}
+	if trash := reloaded.Trash(); len(trash) != 1 || trash[0].Environment != "staging" {
+		t.Errorf("Expected staging's grafana in the trash, got %+v", trash)
+	}
+}
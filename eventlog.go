@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"quaycheck/internal/storage"
+)
+
+// LogEntry is one record of the event log. Kind is "action" for changes
+// made through the API and "port" for transitions the watcher saw.
+type LogEntry struct {
+	Seq      int64     `json:"seq"`
+	Time     time.Time `json:"time"`
+	Kind     string    `json:"kind"`
+	Type     string    `json:"type"`
+	Subject  string    `json:"subject,omitempty"`
+	Detail   string    `json:"detail,omitempty"`
+	Actor    string    `json:"actor,omitempty"`
+	Remote   string    `json:"remote,omitempty"`
+	PrevHash string    `json:"prev_hash,omitempty"`
+	Hash     string    `json:"hash,omitempty"`
+}
+
+const (
+	LogKindAction = "action"
+	LogKindPort   = "port"
+)
+
+// hash covers every field and the previous entry's hash, so changing,
+// removing or reordering an entry breaks the chain from there on
+func (e LogEntry) hash(prev string) string {
+	sum := sha256.Sum256(fmt.Appendf(nil, "%s\n%d\n%d\n%s\n%s\n%s\n%s\n%s\n%s",
+		prev, e.Seq, e.Time.UnixNano(), e.Kind, e.Type, e.Subject, e.Detail, e.Actor, e.Remote))
+	return hex.EncodeToString(sum[:])
+}
+
+// EventLog appends to the event_log table, which triggers keep append-only.
+// Retention never prunes it.
+type EventLog struct {
+	db *storage.DB
+	// chain hashes every entry with the previous one
+	chain bool
+
+	// mu serializes appends so sequence numbers and chain links are computed
+	// against the row actually last written
+	mu sync.Mutex
+}
+
+func NewEventLog(db *storage.DB, chain bool) *EventLog {
+	if db == nil {
+		return nil
+	}
+	return &EventLog{db: db, chain: chain}
+}
+
+func eventLogChainFromEnv() bool {
+	return os.Getenv("QUAYCHECK_EVENT_LOG_CHAIN") == "true"
+}
+
+// Append writes entries in one transaction, in order
+func (l *EventLog) Append(ctx context.Context, entries ...LogEntry) error {
+	if l == nil || len(entries) == 0 {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	tx, err := l.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var seq int64
+	var prev string
+	err = tx.QueryRowContext(ctx, `SELECT seq, hash FROM event_log ORDER BY seq DESC LIMIT 1`).Scan(&seq, &prev)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	stmt, err := tx.PrepareContext(ctx, `INSERT INTO event_log (seq, at, kind, type, subject, detail, actor, remote, prev_hash, hash) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, e := range entries {
+		seq++
+		e.Seq = seq
+		e.PrevHash, e.Hash = "", ""
+		if l.chain {
+			e.PrevHash, e.Hash = prev, e.hash(prev)
+			prev = e.Hash
+		}
+		if _, err := stmt.ExecContext(ctx, e.Seq, e.Time.UnixNano(), e.Kind, e.Type, e.Subject, e.Detail, e.Actor, e.Remote, e.PrevHash, e.Hash); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// List returns up to limit entries after seq, oldest first, so a reader can
+// page through the whole log with ?after=; kind filters when set
+func (l *EventLog) List(ctx context.Context, after int64, kind string, limit int) ([]LogEntry, error) {
+	result := []LogEntry{}
+	if l == nil {
+		return result, nil
+	}
+	query := `SELECT seq, at, kind, type, subject, detail, actor, remote, prev_hash, hash FROM event_log WHERE seq > ?`
+	args := []any{after}
+	if kind != "" {
+		query += ` AND kind = ?`
+		args = append(args, kind)
+	}
+	query += ` ORDER BY seq LIMIT ?`
+	rows, err := l.db.QueryContext(ctx, query, append(args, limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e LogEntry
+		var at int64
+		if err := rows.Scan(&e.Seq, &at, &e.Kind, &e.Type, &e.Subject, &e.Detail, &e.Actor, &e.Remote, &e.PrevHash, &e.Hash); err != nil {
+			return nil, err
+		}
+		e.Time = time.Unix(0, at).UTC()
+		result = append(result, e)
+	}
+	return result, rows.Err()
+}
+
+// ChainReport is the outcome of walking the hash chain
+type ChainReport struct {
+	Checked int64 `json:"checked"`
+	Valid   bool  `json:"valid"`
+	// BrokenAt is the first entry whose hash doesn't match
+	BrokenAt int64 `json:"broken_at,omitempty"`
+}
+
+// Verify recomputes the chain over every hashed entry. Entries written
+// while chaining was off carry no hash and restart the chain.
+func (l *EventLog) Verify(ctx context.Context) (ChainReport, error) {
+	report := ChainReport{Valid: true}
+	if l == nil {
+		return report, nil
+	}
+	var after int64
+	prev := ""
+	for {
+		page, err := l.List(ctx, after, "", 1000)
+		if err != nil {
+			return report, err
+		}
+		for _, e := range page {
+			after = e.Seq
+			if e.Hash == "" {
+				prev = ""
+				continue
+			}
+			report.Checked++
+			if e.PrevHash != prev || e.hash(prev) != e.Hash {
+				report.Valid, report.BrokenAt = false, e.Seq
+				return report, nil
+			}
+			prev = e.Hash
+		}
+		if len(page) < 1000 {
+			return report, nil
+		}
+	}
+}
+
+// logPortEvents appends what the watcher saw
+func (s *Server) logPortEvents(ctx context.Context, events []PortEvent) {
+	entries := make([]LogEntry, 0, len(events))
+	for _, e := range events {
+		entries = append(entries, LogEntry{
+			Time:    e.Time.UTC(),
+			Kind:    LogKindPort,
+			Type:    e.Type,
+			Subject: fmt.Sprintf("%d/%s", e.Port, e.Protocol),
+			Detail:  e.Container,
+			Actor:   e.Source,
+		})
+	}
+	if err := s.eventLog.Append(ctx, entries...); err != nil {
+		log.Printf("Cannot append to the event log: %v", err)
+	}
+}
+
+// handleEventLog pages through the log: ?after=<seq>&limit=&kind=action|port
+func (s *Server) handleEventLog(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var after int64
+	if v := q.Get("after"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, "invalid_param", "Invalid after parameter")
+			return
+		}
+		after = n
+	}
+	kind := q.Get("kind")
+	if kind != "" && kind != LogKindAction && kind != LogKindPort {
+		writeError(w, http.StatusBadRequest, "invalid_param", "kind must be action or port")
+		return
+	}
+	limit, ok := limitParam(r, 100)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "invalid_param", "Invalid limit")
+		return
+	}
+	entries, err := s.eventLog.List(r.Context(), after, kind, limit)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "store_error", "Cannot read event log: "+err.Error())
+		return
+	}
+	writeEncoded(w, r, entries)
+}
+
+func (s *Server) handleVerifyEventLog(w http.ResponseWriter, r *http.Request) {
+	report, err := s.eventLog.Verify(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "store_error", "Cannot read event log: "+err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// PortEvent is emitted when a host port becomes occupied or free
+type PortEvent struct {
+	Type        string    `json:"type"`
+	Port        int       `json:"port"`
+	Protocol    string    `json:"protocol"`
+	IP          string    `json:"ip,omitempty"`
+	Container   string    `json:"container"`
+	ContainerID string    `json:"container_id"`
+	Source      string    `json:"source,omitempty"`
+	Time        time.Time `json:"time"`
+}
+
+const (
+	EventPortOccupied = "port_occupied"
+	EventPortFreed    = "port_freed"
+)
+
+type portBinding struct {
+	port      int
+	protocol  string
+	ip        string
+	id        string
+	container string
+	source    string
+}
+
+func occupiedBindings(containers []ContainerData) map[string]portBinding {
+	bindings := make(map[string]portBinding)
+	for _, c := range containers {
+		if !occupiesPorts(c.State) {
+			continue
+		}
+		for _, p := range c.Ports {
+			if p.PublicPort == 0 {
+				continue
+			}
+			b := portBinding{
+				port:      int(p.PublicPort),
+				protocol:  p.Type,
+				ip:        p.IP,
+				id:        c.ID,
+				container: containerName(c),
+				source:    c.Source,
+			}
+			bindings[fmt.Sprintf("%s|%s|%d/%s", c.ID, b.ip, b.port, b.protocol)] = b
+		}
+	}
+	return bindings
+}
+
+// diffSnapshots returns the port events that turn prev into next
+func diffSnapshots(prev, next []ContainerData, now time.Time) []PortEvent {
+	before, after := occupiedBindings(prev), occupiedBindings(next)
+
+	var events []PortEvent
+	emit := func(typ string, b portBinding) {
+		events = append(events, PortEvent{
+			Type:        typ,
+			Port:        b.port,
+			Protocol:    b.protocol,
+			IP:          b.ip,
+			Container:   b.container,
+			ContainerID: b.id,
+			Source:      b.source,
+			Time:        now,
+		})
+	}
+	for key, b := range before {
+		if _, ok := after[key]; !ok {
+			emit(EventPortFreed, b)
+		}
+	}
+	for key, b := range after {
+		if _, ok := before[key]; !ok {
+			emit(EventPortOccupied, b)
+		}
+	}
+
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].Port != events[j].Port {
+			return events[i].Port < events[j].Port
+		}
+		return events[i].Type > events[j].Type
+	})
+	return events
+}
+
+// EventHub fans port events out to subscribers. Each subscriber has a bounded
+// buffer; a subscriber that cannot keep up loses events rather than blocking
+// everybody else.
+type EventHub struct {
+	mu   sync.Mutex
+	subs map[chan PortEvent]struct{}
+}
+
+func NewEventHub() *EventHub {
+	return &EventHub{subs: make(map[chan PortEvent]struct{})}
+}
+
+func (h *EventHub) Subscribe() (<-chan PortEvent, func()) {
+	ch := make(chan PortEvent, 64)
+	h.mu.Lock()
+	h.subs[ch] = struct{}{}
+	h.mu.Unlock()
+
+	return ch, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if _, ok := h.subs[ch]; ok {
+			delete(h.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+func (h *EventHub) Publish(events ...PortEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs {
+		for _, e := range events {
+			select {
+			case ch <- e:
+			default:
+			}
+		}
+	}
+}
+
+// watch polls the inventory every interval and publishes the differences
+func (s *Server) watch(ctx context.Context, interval time.Duration) {
+	var prev []ContainerData
+	first := true
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		snap, err := s.loadSnapshot(ctx)
+		if err != nil {
+			log.Printf("Port watcher: %v", err)
+		} else {
+			if !first {
+				events := diffSnapshots(prev, snap.Containers, time.Now().UTC())
+				if s.freed != nil {
+					s.freed.record(events)
+				}
+				// Every replica diffs to feed its own subscribers, but only
+				// the leader writes, so shared state gets each event once
+				if s.isLeader() {
+					s.recordHistory(ctx, events)
+					s.logPortEvents(ctx, events)
+				}
+				s.events.Publish(events...)
+			}
+			prev, first = snap.Containers, false
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-s.wake:
+		}
+	}
+}
+
+// handleEventStream streams port events as Server-Sent Events
+func (s *Server) handleEventStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming_unsupported", "Streaming is not supported by this connection")
+		return
+	}
+
+	events, unsubscribe := s.events.Subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, ": connected\n\n")
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(30 * time.Second)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			data, _ := json.Marshal(e)
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data)
+		}
+		flusher.Flush()
+	}
+}
+
+func watchIntervalFromEnv() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("QUAYCHECK_WATCH_INTERVAL")); err == nil && d > 0 {
+		return d
+	}
+	return 5 * time.Second
+}
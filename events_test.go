@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDiffSnapshots(t *testing.T) {
+	prev := []ContainerData{
+		{ID: "a", Names: []string{"/old"}, State: "running", Ports: []PortMapping{{PublicPort: 8080, Type: "tcp"}}},
+		{ID: "b", Names: []string{"/db"}, State: "running", Ports: []PortMapping{{PublicPort: 5432, Type: "tcp"}}},
+	}
+	next := []ContainerData{
+		{ID: "b", Names: []string{"/db"}, State: "running", Ports: []PortMapping{{PublicPort: 5432, Type: "tcp"}}},
+		{ID: "c", Names: []string{"/new"}, State: "running", Ports: []PortMapping{{PublicPort: 8080, Type: "tcp"}}},
+	}
+
+	events := diffSnapshots(prev, next, time.Now())
+	if len(events) != 2 {
+		t.Fatalf("Expected 2 events, got %+v", events)
+	}
+	if events[0].Type != EventPortOccupied || events[0].Container != "new" {
+		t.Errorf("Expected new to occupy 8080, got %+v", events[0])
+	}
+	if events[1].Type != EventPortFreed || events[1].Container != "old" {
+		t.Errorf("Expected old to free 8080, got %+v", events[1])
+	}
+}
+
+func TestEventHubDropsForSlowSubscribers(t *testing.T) {
+	hub := NewEventHub()
+	ch, unsubscribe := hub.Subscribe()
+
+	for i := 0; i < 100; i++ {
+		hub.Publish(PortEvent{Port: i})
+	}
+	if len(ch) != cap(ch) {
+		t.Errorf("Expected buffer to be full, got %d/%d", len(ch), cap(ch))
+	}
+
+	unsubscribe()
+	unsubscribe()
+	hub.Publish(PortEvent{Port: 1})
+}
+
+func TestHandleEventStream(t *testing.T) {
+	server := &Server{client: &MockDockerClient{}, events: NewEventHub()}
+	ts := httptest.NewServer(SetupRouter(server))
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", ts.URL+"/api/events/stream", nil)
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatalf("Expected stream to open, got %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("Unexpected content type %s", resp.Header.Get("Content-Type"))
+	}
+
+	reader := bufio.NewReader(resp.Body)
+	reader.ReadString('\n')
+	reader.ReadString('\n')
+
+	server.events.Publish(PortEvent{Type: EventPortOccupied, Port: 8080, Container: "web"})
+
+	line, _ := reader.ReadString('\n')
+	if strings.TrimSpace(line) != "event: port_occupied" {
+		t.Fatalf("Unexpected event line %q", line)
+	}
+	line, _ = reader.ReadString('\n')
+	var e PortEvent
+	json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &e)
+	if e.Port != 8080 || e.Container != "web" {
+		t.Errorf("Unexpected event %+v", e)
+	}
+}
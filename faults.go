@@ -0,0 +1,232 @@
+//go:build faults
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/registry"
+)
+
+// FaultConfig is what the Docker client is made to suffer, set through
+// /api/admin/faults. Calls limits it to some methods (ContainerList,
+// ContainerInspect, ImageInspect, DistributionInspect, Events,
+// ContainerStop, ContainerRestart); empty means all of them.
+type FaultConfig struct {
+	Latency   Duration `json:"latency"`
+	ErrorRate float64  `json:"error_rate"`
+	Error     string   `json:"error,omitempty"`
+	// TruncateEvents ends the event stream with an error after that many
+	// messages
+	TruncateEvents int      `json:"truncate_events"`
+	Calls          []string `json:"calls,omitempty"`
+}
+
+// faults is the one fault configuration of the process, shared by every
+// client withFaults wrapped
+var faults struct {
+	mu  sync.Mutex
+	cfg FaultConfig
+}
+
+func currentFaults() FaultConfig {
+	faults.mu.Lock()
+	defer faults.mu.Unlock()
+	return faults.cfg
+}
+
+func setFaults(cfg FaultConfig) {
+	faults.mu.Lock()
+	faults.cfg = cfg
+	faults.mu.Unlock()
+}
+
+// withFaults wraps client so calls go through the configured faults. This
+// file is only built with -tags faults: release binaries have no way to
+// break their own Docker client.
+func withFaults(client DockerClient) DockerClient {
+	return &faultClient{DockerClient: client}
+}
+
+// faultClient delays and fails Docker calls as currentFaults says
+type faultClient struct {
+	DockerClient
+}
+
+// inject waits out the configured latency, then fails the call at the
+// configured rate
+func (c *faultClient) inject(ctx context.Context, call string) error {
+	cfg := currentFaults()
+	if len(cfg.Calls) > 0 && !slices.Contains(cfg.Calls, call) {
+		return nil
+	}
+	if cfg.Latency > 0 {
+		t := time.NewTimer(time.Duration(cfg.Latency))
+		defer t.Stop()
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if cfg.ErrorRate > 0 && rand.Float64() < cfg.ErrorRate {
+		if cfg.Error != "" {
+			return errors.New(cfg.Error)
+		}
+		return errors.New("injected fault: " + call)
+	}
+	return nil
+}
+
+func (c *faultClient) ContainerList(ctx context.Context, options types.ContainerListOptions) ([]types.Container, error) {
+	if err := c.inject(ctx, "ContainerList"); err != nil {
+		return nil, err
+	}
+	return c.DockerClient.ContainerList(ctx, options)
+}
+
+func (c *faultClient) ContainerInspect(ctx context.Context, containerID string) (types.ContainerJSON, error) {
+	ic, ok := c.DockerClient.(dockerInspectClient)
+	if !ok {
+		return types.ContainerJSON{}, errors.New("the Docker client cannot inspect containers")
+	}
+	if err := c.inject(ctx, "ContainerInspect"); err != nil {
+		return types.ContainerJSON{}, err
+	}
+	return ic.ContainerInspect(ctx, containerID)
+}
+
+func (c *faultClient) ImageInspectWithRaw(ctx context.Context, imageID string) (types.ImageInspect, []byte, error) {
+	ic, ok := c.DockerClient.(dockerImageClient)
+	if !ok {
+		return types.ImageInspect{}, nil, errors.New("the Docker client cannot inspect images")
+	}
+	if err := c.inject(ctx, "ImageInspect"); err != nil {
+		return types.ImageInspect{}, nil, err
+	}
+	return ic.ImageInspectWithRaw(ctx, imageID)
+}
+
+func (c *faultClient) DistributionInspect(ctx context.Context, image, encodedRegistryAuth string) (registry.DistributionInspect, error) {
+	rc, ok := c.DockerClient.(dockerRegistryClient)
+	if !ok {
+		return registry.DistributionInspect{}, errors.New("the Docker client cannot query registries")
+	}
+	if err := c.inject(ctx, "DistributionInspect"); err != nil {
+		return registry.DistributionInspect{}, err
+	}
+	return rc.DistributionInspect(ctx, image, encodedRegistryAuth)
+}
+
+// Events injects its latency and error when subscribing, and cuts the
+// stream short after TruncateEvents messages as a daemon restart would
+func (c *faultClient) Events(ctx context.Context, options types.EventsOptions) (<-chan events.Message, <-chan error) {
+	ec, ok := c.DockerClient.(dockerEventsClient)
+	errs := make(chan error, 1)
+	if !ok {
+		errs <- errors.New("the Docker client cannot stream events")
+		return nil, errs
+	}
+	if err := c.inject(ctx, "Events"); err != nil {
+		errs <- err
+		return nil, errs
+	}
+	cfg := currentFaults()
+	if cfg.TruncateEvents <= 0 || (len(cfg.Calls) > 0 && !slices.Contains(cfg.Calls, "Events")) {
+		return ec.Events(ctx, options)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	in, inErrs := ec.Events(ctx, options)
+	out := make(chan events.Message)
+	go func() {
+		defer cancel()
+		for n := 0; ; n++ {
+			if n == cfg.TruncateEvents {
+				errs <- errors.New("injected fault: event stream truncated")
+				return
+			}
+			select {
+			case msg, ok := <-in:
+				if !ok {
+					return
+				}
+				select {
+				case out <- msg:
+				case <-ctx.Done():
+					return
+				}
+			case err := <-inErrs:
+				errs <- err
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, errs
+}
+
+func (c *faultClient) ContainerStop(ctx context.Context, containerID string, options container.StopOptions) error {
+	lc, ok := c.DockerClient.(dockerLifecycleClient)
+	if !ok {
+		return errors.New("the Docker client cannot stop containers")
+	}
+	if err := c.inject(ctx, "ContainerStop"); err != nil {
+		return err
+	}
+	return lc.ContainerStop(ctx, containerID, options)
+}
+
+func (c *faultClient) ContainerRestart(ctx context.Context, containerID string, options container.StopOptions) error {
+	lc, ok := c.DockerClient.(dockerLifecycleClient)
+	if !ok {
+		return errors.New("the Docker client cannot restart containers")
+	}
+	if err := c.inject(ctx, "ContainerRestart"); err != nil {
+		return err
+	}
+	return lc.ContainerRestart(ctx, containerID, options)
+}
+
+// registerFaultRoutes adds /api/admin/faults. The configuration lives in
+// this process only, so it isn't gated on being the writer.
+func registerFaultRoutes(mux *http.ServeMux, server *Server) {
+	log.Printf("Fault injection is built in: /api/admin/faults can break the Docker client")
+	mux.HandleFunc("GET /api/admin/faults", server.requireScope(ScopeAdmin, handleGetFaults))
+	mux.HandleFunc("PUT /api/admin/faults", server.requireScope(ScopeAdmin, handleSetFaults))
+	mux.HandleFunc("DELETE /api/admin/faults", server.requireScope(ScopeAdmin, handleClearFaults))
+}
+
+func handleGetFaults(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(currentFaults())
+}
+
+func handleSetFaults(w http.ResponseWriter, r *http.Request) {
+	var cfg FaultConfig
+	if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil || cfg.Latency < 0 || cfg.ErrorRate < 0 || cfg.ErrorRate > 1 || cfg.TruncateEvents < 0 {
+		writeError(w, http.StatusBadRequest, "invalid_body", "Expected a JSON fault configuration with an error_rate between 0 and 1")
+		return
+	}
+	setFaults(cfg)
+	log.Printf("Docker faults set: %+v", cfg)
+	handleGetFaults(w, r)
+}
+
+func handleClearFaults(w http.ResponseWriter, r *http.Request) {
+	setFaults(FaultConfig{})
+	log.Printf("Docker faults cleared")
+	w.WriteHeader(http.StatusNoContent)
+}
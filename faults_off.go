@@ -0,0 +1,13 @@
+//go:build !faults
+
+package main
+
+import "net/http"
+
+// withFaults returns client as is; build with -tags faults for the fault
+// injection layer
+func withFaults(client DockerClient) DockerClient {
+	return client
+}
+
+func registerFaultRoutes(mux *http.ServeMux, server *Server) {}
@@ -0,0 +1,102 @@
+//go:build faults
+
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
+)
+
+func TestFaultsAPI(t *testing.T) {
+	defer setFaults(FaultConfig{})
+	client := &MockDockerClient{Containers: []types.Container{{Names: []string{"/web"}, State: "running"}}}
+	server := &Server{client: withFaults(client)}
+	router := SetupRouter(server)
+	t.Setenv("QUAYCHECK_ADMIN_TOKEN", testAdminToken)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, adminRequest("PUT", "/api/admin/faults", strings.NewReader(`{"error_rate": 2}`)))
+	if w.Code != 400 {
+		t.Errorf("Expected 400 for an error rate above 1, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, adminRequest("PUT", "/api/admin/faults", strings.NewReader(`{"error_rate": 1, "error": "daemon gone", "calls": ["ContainerList"]}`)))
+	if w.Code != 200 {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body)
+	}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/ports", nil))
+	if w.Code != 500 || !strings.Contains(w.Body.String(), "daemon gone") {
+		t.Errorf("Expected the injected error, got %d: %s", w.Code, w.Body)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, adminRequest("DELETE", "/api/admin/faults", nil))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/ports", nil))
+	if w.Code != 200 {
+		t.Errorf("Expected 200 once faults are cleared, got %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestFaultLatencyShedsListings(t *testing.T) {
+	defer setFaults(FaultConfig{})
+	setFaults(FaultConfig{Latency: Duration(200 * time.Millisecond)})
+	server := &Server{client: withFaults(&MockDockerClient{}), shedAfter: time.Millisecond}
+	router := SetupRouter(server)
+
+	go router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/check?port=8080", nil))
+	for server.flight.busyFor() < 2*time.Millisecond {
+		time.Sleep(time.Millisecond)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/ports", nil))
+	if w.Code != 429 {
+		t.Errorf("Expected the slow daemon to shed listings, got %d", w.Code)
+	}
+}
+
+func TestFaultTruncatesEvents(t *testing.T) {
+	defer setFaults(FaultConfig{})
+	setFaults(FaultConfig{TruncateEvents: 2})
+	client := withFaults(&streamDockerClient{}).(dockerEventsClient)
+
+	msgs, errs := client.Events(context.Background(), types.EventsOptions{})
+	for i := 0; i < 2; i++ {
+		<-msgs
+	}
+	select {
+	case err := <-errs:
+		if !strings.Contains(err.Error(), "truncated") {
+			t.Errorf("Expected the stream to be truncated, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected the stream to end after 2 messages")
+	}
+}
+
+// streamDockerClient streams start events until cancelled
+type streamDockerClient struct {
+	MockDockerClient
+}
+
+func (c *streamDockerClient) Events(ctx context.Context, options types.EventsOptions) (<-chan events.Message, <-chan error) {
+	msgs := make(chan events.Message)
+	go func() {
+		for {
+			select {
+			case msgs <- events.Message{Type: events.ContainerEventType, Action: events.ActionStart}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return msgs, make(chan error)
+}
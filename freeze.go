@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"quaycheck/internal/storage"
+)
+
+// Freeze blocks suggestions and reservations in a port range, or on the
+// whole host when Start and End are zero, until Until (forever when nil)
+type Freeze struct {
+	ID        string     `json:"id"`
+	Start     int        `json:"start,omitempty"`
+	End       int        `json:"end,omitempty"`
+	Reason    string     `json:"reason"`
+	Until     *time.Time `json:"until,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// errFrozen is wrapped by frozenError so callers can map it to 423
+var errFrozen = errors.New("allocations are frozen")
+
+type frozenError struct{ Freeze Freeze }
+
+func (e *frozenError) Error() string { return e.Freeze.message() }
+func (e *frozenError) Unwrap() error { return errFrozen }
+
+func (f Freeze) covers(port int) bool {
+	if f.Start == 0 && f.End == 0 {
+		return true
+	}
+	return port >= f.Start && port <= f.End
+}
+
+func (f Freeze) active(now time.Time) bool {
+	return f.Until == nil || now.Before(*f.Until)
+}
+
+// message explains the freeze to whoever just got a 423
+func (f Freeze) message() string {
+	scope := "on this host"
+	if f.Start != 0 || f.End != 0 {
+		scope = fmt.Sprintf("for ports %d-%d", f.Start, f.End)
+	}
+	msg := "Allocations are frozen " + scope
+	if f.Until != nil {
+		msg += " until " + f.Until.UTC().Format(time.RFC3339)
+	}
+	if f.Reason != "" {
+		msg += ": " + f.Reason
+	}
+	return msg
+}
+
+// FreezeStore persists freezes like ReservationStore does reservations
+type FreezeStore struct {
+	db *storage.DB
+
+	mu     sync.Mutex
+	items  map[string]Freeze
+	nextID int
+}
+
+func NewFreezeStore(db *storage.DB) (*FreezeStore, error) {
+	s := &FreezeStore{db: db}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reload replaces the in-memory freezes with the database's
+func (s *FreezeStore) Reload() error {
+	var list []Freeze
+	if s.db != nil {
+		rows, err := s.db.Query(`SELECT id, start_port, end_port, reason, until, created_at FROM freezes`)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var f Freeze
+			var until sql.NullInt64
+			var created int64
+			if err := rows.Scan(&f.ID, &f.Start, &f.End, &f.Reason, &until, &created); err != nil {
+				return err
+			}
+			if until.Valid {
+				t := time.Unix(0, until.Int64).UTC()
+				f.Until = &t
+			}
+			f.CreatedAt = time.Unix(0, created).UTC()
+			list = append(list, f)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.items, s.nextID = make(map[string]Freeze), 0
+	for _, f := range list {
+		s.addLoaded(f)
+	}
+	return nil
+}
+
+func (s *FreezeStore) addLoaded(f Freeze) {
+	s.items[f.ID] = f
+	if n, err := strconv.Atoi(f.ID); err == nil && n > s.nextID {
+		s.nextID = n
+	}
+}
+
+func (s *FreezeStore) saveLocked() error {
+	if s.db == nil {
+		return nil
+	}
+	var rows [][]any
+	for _, f := range s.listLocked() {
+		var until any
+		if f.Until != nil {
+			until = f.Until.UnixNano()
+		}
+		rows = append(rows, []any{f.ID, f.Start, f.End, f.Reason, until, f.CreatedAt.UnixNano()})
+	}
+	return s.db.Replace(context.Background(), "freezes", []string{"id", "start_port", "end_port", "reason", "until", "created_at"}, rows)
+}
+
+func (s *FreezeStore) listLocked() []Freeze {
+	list := make([]Freeze, 0, len(s.items))
+	for _, f := range s.items {
+		list = append(list, f)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+	return list
+}
+
+func (s *FreezeStore) List() []Freeze {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.listLocked()
+}
+
+func (s *FreezeStore) Add(f Freeze) (Freeze, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextID++
+	f.ID = strconv.Itoa(s.nextID)
+	f.CreatedAt = time.Now().UTC()
+	s.items[f.ID] = f
+	return f, s.saveLocked()
+}
+
+func (s *FreezeStore) Delete(id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.items[id]; !ok {
+		return false, nil
+	}
+	delete(s.items, id)
+	return true, s.saveLocked()
+}
+
+// Covering returns the active freeze that blocks port, if any
+func (s *FreezeStore) Covering(port int) (Freeze, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for _, f := range s.listLocked() {
+		if f.active(now) && f.covers(port) {
+			return f, true
+		}
+	}
+	return Freeze{}, false
+}
+
+// frozen reports the freeze blocking port on this server, if any
+func (s *Server) frozen(port int) (Freeze, bool) {
+	if s.freezes == nil || port < 1 {
+		return Freeze{}, false
+	}
+	return s.freezes.Covering(port)
+}
+
+// checkFrozen returns a frozenError when port is frozen
+func (s *Server) checkFrozen(port int) error {
+	if f, ok := s.frozen(port); ok {
+		return &frozenError{f}
+	}
+	return nil
+}
+
+// withoutFrozen returns ix with the ranges of active freezes marked as used,
+// leaving ix itself untouched, so picking skips them. A freeze of the whole
+// host isn't marked: the pick still lands on a port, which gets the 423.
+func (s *Server) withoutFrozen(ix *PortIndex) *PortIndex {
+	if s.freezes == nil {
+		return ix
+	}
+	var ranges []PortRange
+	now := time.Now()
+	for _, f := range s.freezes.List() {
+		if f.active(now) && (f.Start != 0 || f.End != 0) {
+			ranges = append(ranges, PortRange{Start: f.Start, End: f.End})
+		}
+	}
+	return withoutExcluded(ix, ranges)
+}
+
+// writeIfFrozen answers 423 Locked when port is frozen and reports whether it did
+func (s *Server) writeIfFrozen(w http.ResponseWriter, port int) bool {
+	f, ok := s.frozen(port)
+	if ok {
+		writeError(w, http.StatusLocked, "frozen", f.message())
+	}
+	return ok
+}
+
+type FreezeRequest struct {
+	Start  int    `json:"start,omitempty"`
+	End    int    `json:"end,omitempty"`
+	Reason string `json:"reason"`
+	// Duration is an alternative to Until, e.g. "2h"
+	Duration string     `json:"duration,omitempty"`
+	Until    *time.Time `json:"until,omitempty"`
+}
+
+func (s *Server) handleListFreezes(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.freezes.List())
+}
+
+func (s *Server) handleCreateFreeze(w http.ResponseWriter, r *http.Request) {
+	var req FreezeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_body", "Expected a JSON body with a reason and optional start, end and until")
+		return
+	}
+	if (req.Start == 0) != (req.End == 0) || req.End < req.Start || req.End > maxPort || req.Start < 0 {
+		writeError(w, http.StatusBadRequest, "invalid_body", "Give both start and end, or neither to freeze the whole host")
+		return
+	}
+	until := req.Until
+	if req.Duration != "" {
+		d, err := time.ParseDuration(req.Duration)
+		if err != nil || d <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid_body", "Invalid duration")
+			return
+		}
+		t := time.Now().Add(d).UTC()
+		until = &t
+	}
+
+	f, err := s.freezes.Add(Freeze{Start: req.Start, End: req.End, Reason: req.Reason, Until: until})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "store_error", "Cannot save freezes: "+err.Error())
+		return
+	}
+	s.audit(r, "freeze.create", f.ID, f.message())
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(f)
+}
+
+func (s *Server) handleDeleteFreeze(w http.ResponseWriter, r *http.Request) {
+	deleted, err := s.freezes.Delete(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "store_error", "Cannot save freezes: "+err.Error())
+		return
+	}
+	if !deleted {
+		writeError(w, http.StatusNotFound, "not_found", "No freeze with this id")
+		return
+	}
+	s.audit(r, "freeze.delete", r.PathValue("id"), "")
+	w.WriteHeader(http.StatusNoContent)
+}
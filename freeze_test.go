@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFreezeStore(t *testing.T) {
+	db := openTestDB(t)
+	store, _ := NewFreezeStore(db)
+	past := time.Now().Add(-time.Minute)
+	store.Add(Freeze{Start: 8000, End: 8099, Reason: "replanning"})
+	store.Add(Freeze{Reason: "over", Until: &past})
+
+	if f, ok := store.Covering(8050); !ok || f.Reason != "replanning" {
+		t.Errorf("Expected 8050 to be frozen, got %+v", f)
+	}
+	if _, ok := store.Covering(9000); ok {
+		t.Error("Expected an expired host-wide freeze not to apply")
+	}
+
+	reloaded, err := NewFreezeStore(db)
+	if err != nil || len(reloaded.List()) != 2 {
+		t.Fatalf("Expected freezes to persist, got %v, %v", reloaded.List(), err)
+	}
+	if f, _ := reloaded.Add(Freeze{}); f.ID != "3" {
+		t.Errorf("Expected ids to continue after reload, got %q", f.ID)
+	}
+}
+
+func TestFreezeBlocksAllocation(t *testing.T) {
+	reservations, _ := NewReservationStore(nil)
+	freezes, _ := NewFreezeStore(nil)
+	server := &Server{client: &MockDockerClient{}, reservations: reservations, freezes: freezes}
+	mux := SetupRouter(server)
+
+	w := httptest.NewRecorder()
+	t.Setenv("QUAYCHECK_ADMIN_TOKEN", testAdminToken)
+	mux.ServeHTTP(w, adminRequest("POST", "/api/admin/freezes", strings.NewReader(`{"start":8000,"end":8099,"reason":"moving to new plan","duration":"1h"}`)))
+	if w.Code != 201 {
+		t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// Picking skips the frozen range; asking for a port in it is refused
+	requests := []struct {
+		method, url, body string
+		status            int
+		port              string
+	}{
+		{"GET", "/api/suggest?start=8000", "", 200, `"port":8100`},
+		{"POST", "/api/reservations", `{"name":"grafana","port":8010}`, 423, ""},
+		{"POST", "/api/reservations", `{"name":"grafana","port":9010}`, 201, ""},
+		{"POST", "/api/suggest/batch", `{"items":[{"name":"a","start":8000,"end":8200}],"reserve":true}`, 200, `"port":8100`},
+		{"POST", "/api/ansible/reservation", `{"name":"b","start":8050}`, 200, `"port":8101`},
+		{"POST", "/api/ansible/reservation", `{"name":"c","port":8050}`, 423, ""},
+		{"POST", "/api/terraform/suggest", `{"start":"8000"}`, 200, `"8102"`},
+		{"GET", "/api/check?port=8050", "", 200, ""},
+	}
+	for _, tt := range requests {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(tt.method, tt.url, strings.NewReader(tt.body)))
+		if w.Code != tt.status || !strings.Contains(w.Body.String(), tt.port) {
+			t.Errorf("%s %s: expected %d %s, got %d: %s", tt.method, tt.url, tt.status, tt.port, w.Code, w.Body.String())
+		}
+	}
+	if _, ok := reservations.Get("", "grafana"); !ok || len(reservations.List()) != 3 {
+		t.Errorf("Expected grafana, a and b reserved, got %+v", reservations.List())
+	}
+
+	var resp ErrorResponse
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("POST", "/api/reservations", strings.NewReader(`{"name":"d","port":8020}`)))
+	json.NewDecoder(w.Body).Decode(&resp)
+	if resp.Code != "frozen" || !strings.Contains(resp.Message, "moving to new plan") {
+		t.Errorf("Expected an explanatory message, got %+v", resp)
+	}
+
+	// With the whole host frozen there is nothing to skip to
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, adminRequest("POST", "/api/admin/freezes", strings.NewReader(`{"reason":"maintenance","duration":"1h"}`)))
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/api/suggest?start=8000", nil))
+	if w.Code != http.StatusLocked {
+		t.Errorf("Expected 423 with the whole host frozen, got %d", w.Code)
+	}
+}
+
+func TestRequireAdmin(t *testing.T) {
+	t.Setenv("QUAYCHECK_ADMIN_TOKEN", "s3cret")
+	freezes, _ := NewFreezeStore(nil)
+	mux := SetupRouter(&Server{client: &MockDockerClient{}, freezes: freezes})
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/api/admin/freezes", nil))
+	if w.Code != 401 {
+		t.Errorf("Expected 401 without a token, got %d", w.Code)
+	}
+
+	req := httptest.NewRequest("GET", "/api/admin/freezes", nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != 200 {
+		t.Errorf("Expected 200 with the token, got %d", w.Code)
+	}
+}
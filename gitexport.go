@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// runGit is swapped out in tests
+var runGit = func(ctx context.Context, dir string, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	return cmd.CombinedOutput()
+}
+
+// GitExport commits the port allocation to a git working copy, giving the
+// port plan a reviewable history outside quaycheck
+type GitExport struct {
+	// Dir is an existing clone; quaycheck only adds commits to its
+	// current branch
+	Dir      string
+	Push     bool
+	Interval time.Duration
+}
+
+// gitExportFromEnv reads QUAYCHECK_GIT_EXPORT_DIR, _PUSH and _INTERVAL;
+// nil when the export is off
+func gitExportFromEnv() *GitExport {
+	dir := os.Getenv("QUAYCHECK_GIT_EXPORT_DIR")
+	if dir == "" {
+		return nil
+	}
+	g := &GitExport{Dir: dir, Push: os.Getenv("QUAYCHECK_GIT_EXPORT_PUSH") == "true", Interval: time.Hour}
+	if d, err := time.ParseDuration(os.Getenv("QUAYCHECK_GIT_EXPORT_INTERVAL")); err == nil && d > 0 {
+		g.Interval = d
+	}
+	return g
+}
+
+// AllocationEntry is one published port in the exported plan. It leaves
+// out anything that changes without the plan changing (status, uptime), so
+// commits only happen on real changes.
+type AllocationEntry struct {
+	Port     int      `yaml:"port"`
+	Protocol string   `yaml:"protocol"`
+	IP       string   `yaml:"ip,omitempty"`
+	Owner    string   `yaml:"owner"`
+	Source   string   `yaml:"source"`
+	Image    string   `yaml:"image,omitempty"`
+	Project  string   `yaml:"project,omitempty"`
+	Tags     []string `yaml:"tags,omitempty"`
+	Note     string   `yaml:"note,omitempty"`
+}
+
+// allocationEntries lists ports held by live entries, sorted by port
+func allocationEntries(containers []ContainerData, tags []PortTag) []AllocationEntry {
+	byPort := make(map[int]PortTag, len(tags))
+	for _, t := range tags {
+		byPort[t.Port] = t
+	}
+
+	type binding struct {
+		port            int
+		proto, ip, name string
+	}
+	seen := make(map[binding]bool)
+	var result []AllocationEntry
+	for _, c := range containers {
+		if !occupiesPorts(c.State) {
+			continue
+		}
+		for _, p := range c.Ports {
+			if p.PublicPort == 0 {
+				continue
+			}
+			e := AllocationEntry{
+				Port:     int(p.PublicPort),
+				Protocol: p.Type,
+				IP:       p.IP,
+				Owner:    containerName(c),
+				Source:   c.Source,
+				Image:    c.Image,
+				Project:  c.Project,
+			}
+			// Docker lists IPv4 and IPv6 bindings separately
+			if e.IP == "0.0.0.0" || e.IP == "::" {
+				e.IP = ""
+			}
+			key := binding{e.Port, e.Protocol, e.IP, e.Owner}
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			if t, ok := byPort[e.Port]; ok {
+				e.Tags, e.Note = t.Tags, t.Note
+			}
+			result = append(result, e)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if a.Port != b.Port {
+			return a.Port < b.Port
+		}
+		if a.Protocol != b.Protocol {
+			return a.Protocol < b.Protocol
+		}
+		return a.Owner < b.Owner
+	})
+	return result
+}
+
+func renderAllocationYAML(entries []AllocationEntry) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString("# Generated by quaycheck; edits are overwritten\n")
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(map[string][]AllocationEntry{"ports": entries}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), enc.Close()
+}
+
+func renderAllocationMarkdown(entries []AllocationEntry) []byte {
+	var b strings.Builder
+	b.WriteString("# Port allocation\n\nGenerated by quaycheck; edits are overwritten.\n\n")
+	b.WriteString("| Port | Protocol | Owner | Source | Image | Notes |\n|---:|---|---|---|---|---|\n")
+	for _, e := range entries {
+		port := fmt.Sprint(e.Port)
+		if e.IP != "" {
+			port = e.IP + ":" + port
+		}
+		notes := strings.Join(e.Tags, ", ")
+		if e.Note != "" {
+			notes = strings.TrimPrefix(notes+"; "+e.Note, "; ")
+		}
+		fmt.Fprintf(&b, "| %s | %s | %s | %s | %s | %s |\n", port, e.Protocol, mdEscape(e.Owner), e.Source, mdEscape(e.Image), mdEscape(notes))
+	}
+	return []byte(b.String())
+}
+
+func mdEscape(s string) string {
+	return strings.ReplaceAll(s, "|", `\|`)
+}
+
+// export writes ports.yaml and PORTS.md and commits them when they changed.
+// It reports whether a commit was made.
+func (g *GitExport) export(ctx context.Context, entries []AllocationEntry) (bool, error) {
+	yml, err := renderAllocationYAML(entries)
+	if err != nil {
+		return false, err
+	}
+	files := map[string][]byte{"ports.yaml": yml, "PORTS.md": renderAllocationMarkdown(entries)}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(g.Dir, name), data, 0o644); err != nil {
+			return false, err
+		}
+	}
+
+	git := func(args ...string) ([]byte, error) {
+		out, err := runGit(ctx, g.Dir, args...)
+		if err != nil {
+			return out, fmt.Errorf("git %s: %v: %s", args[0], err, strings.TrimSpace(string(out)))
+		}
+		return out, nil
+	}
+	if _, err := git("add", "ports.yaml", "PORTS.md"); err != nil {
+		return false, err
+	}
+	status, err := git("status", "--porcelain", "--", "ports.yaml", "PORTS.md")
+	if err != nil {
+		return false, err
+	}
+	if len(bytes.TrimSpace(status)) == 0 {
+		return false, nil
+	}
+	msg := fmt.Sprintf("Update port allocation (%d ports)", len(entries))
+	if _, err := git("-c", "user.name=quaycheck", "-c", "user.email=quaycheck@localhost", "commit", "-m", msg, "--", "ports.yaml", "PORTS.md"); err != nil {
+		return false, err
+	}
+	if g.Push {
+		if _, err := git("push"); err != nil {
+			return true, err
+		}
+	}
+	return true, nil
+}
+
+// gitExportLoop exports on every interval and shortly after port events,
+// waiting for events to settle so a compose up is one commit
+func (s *Server) gitExportLoop(ctx context.Context, g *GitExport, settle time.Duration) {
+	if g == nil {
+		return
+	}
+	var changes <-chan PortEvent
+	if s.events != nil {
+		ch, unsubscribe := s.events.Subscribe()
+		defer unsubscribe()
+		changes = ch
+	}
+
+	run := func() {
+		snap, err := s.loadSnapshot(ctx)
+		if err != nil {
+			log.Printf("Git export skipped: %v", err)
+			return
+		}
+		var tags []PortTag
+		if s.tags != nil {
+			tags = s.tags.List()
+		}
+		committed, err := g.export(ctx, allocationEntries(snap.Containers, tags))
+		switch {
+		case err != nil:
+			log.Printf("Git export failed: %v", err)
+		case committed:
+			log.Printf("Committed port allocation to %s", g.Dir)
+		}
+	}
+
+	run()
+	ticker := time.NewTicker(g.Interval)
+	defer ticker.Stop()
+	var debounce <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			run()
+		case <-changes:
+			debounce = time.After(settle)
+		case <-debounce:
+			debounce = nil
+			run()
+		}
+	}
+}
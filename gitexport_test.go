@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAllocationEntries(t *testing.T) {
+	entries := allocationEntries([]ContainerData{
+		{Names: []string{"/web"}, State: "running", Source: "docker", Image: "nginx", Ports: []PortMapping{
+			{PublicPort: 8080, Type: "tcp", IP: "0.0.0.0"},
+			{PublicPort: 8080, Type: "tcp", IP: "::"},
+			{PrivatePort: 9000, Type: "tcp"},
+		}},
+		{Names: []string{"/old"}, State: "exited", Ports: []PortMapping{{PublicPort: 7000, Type: "tcp"}}},
+		{Names: []string{"grafana"}, State: "reserved", Source: "reservation", Ports: []PortMapping{{PublicPort: 3000, Type: "tcp"}}},
+	}, []PortTag{{Port: 8080, Tags: []string{"public"}}})
+
+	if len(entries) != 2 {
+		t.Fatalf("Expected grafana and one web binding, got %+v", entries)
+	}
+	if entries[0].Port != 3000 || entries[1].Owner != "web" || entries[1].IP != "" || entries[1].Tags[0] != "public" {
+		t.Errorf("Unexpected entries: %+v", entries)
+	}
+}
+
+func TestGitExportCommitsOnChange(t *testing.T) {
+	dir := t.TempDir()
+	if out, err := runGit(context.Background(), dir, "init", "-q"); err != nil {
+		t.Skipf("git unavailable: %v %s", err, out)
+	}
+	g := &GitExport{Dir: dir}
+	entries := []AllocationEntry{{Port: 8080, Protocol: "tcp", Owner: "web", Source: "docker"}}
+
+	if committed, err := g.export(context.Background(), entries); err != nil || !committed {
+		t.Fatalf("Expected a first commit, got %v, %v", committed, err)
+	}
+	if committed, err := g.export(context.Background(), entries); err != nil || committed {
+		t.Errorf("Expected no commit without changes, got %v, %v", committed, err)
+	}
+	entries = append(entries, AllocationEntry{Port: 9000, Protocol: "tcp", Owner: "minio", Source: "docker"})
+	if committed, _ := g.export(context.Background(), entries); !committed {
+		t.Error("Expected a commit after a change")
+	}
+
+	log, _ := runGit(context.Background(), dir, "log", "--format=%s")
+	if lines := strings.Split(strings.TrimSpace(string(log)), "\n"); len(lines) != 2 || lines[0] != "Update port allocation (2 ports)" {
+		t.Errorf("Unexpected git log: %q", log)
+	}
+	md, _ := os.ReadFile(filepath.Join(dir, "PORTS.md"))
+	if !strings.Contains(string(md), "| 9000 | tcp | minio | docker |") {
+		t.Errorf("Unexpected PORTS.md:\n%s", md)
+	}
+}
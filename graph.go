@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// topologyGraph is the host, its published ports, the entries behind them
+// and the Docker networks those are attached to, ready to render
+type topologyGraph struct {
+	nodes []graphNode
+	edges []graphEdge
+}
+
+type graphNode struct {
+	id    string
+	label string
+	kind  string // host, port, container, network
+}
+
+type graphEdge struct {
+	from, to string
+	label    string
+	dashed   bool
+}
+
+// buildTopology includes every entry in a state that holds ports. Node ids are stable across calls so diffs of the output stay readable.
+func buildTopology(containers []ContainerData) topologyGraph {
+	var g topologyGraph
+	g.nodes = append(g.nodes, graphNode{id: "host", label: "host", kind: "host"})
+
+	networks := make(map[string]bool)
+	ports := make(map[string]bool)
+	for _, c := range containers {
+		if !occupiesPorts(c.State) {
+			continue
+		}
+		id := "c_" + c.ID
+		if c.ID == "" {
+			id = "c_" + containerName(c)
+		}
+		label := containerName(c)
+		if c.Image != "" && c.Source != "reservation" {
+			label += "\n" + c.Image
+		}
+		if c.Source != "" && c.Source != "docker" {
+			label += "\n(" + c.Source + ")"
+		}
+		g.nodes = append(g.nodes, graphNode{id: id, label: label, kind: "container"})
+
+		for _, p := range c.Ports {
+			if p.PublicPort == 0 {
+				continue
+			}
+			portID := fmt.Sprintf("p_%d_%s", p.PublicPort, p.Type)
+			if !ports[portID] {
+				ports[portID] = true
+				g.nodes = append(g.nodes, graphNode{id: portID, label: fmt.Sprintf("%d/%s", p.PublicPort, p.Type), kind: "port"})
+				g.edges = append(g.edges, graphEdge{from: "host", to: portID})
+			}
+			edge := graphEdge{from: portID, to: id}
+			if p.PrivatePort != 0 && p.PrivatePort != p.PublicPort {
+				edge.label = fmt.Sprint(p.PrivatePort)
+			}
+			if !containsEdge(g.edges, edge) {
+				g.edges = append(g.edges, edge)
+			}
+		}
+		for _, n := range c.Networks {
+			netID := "n_" + n
+			if !networks[netID] {
+				networks[netID] = true
+				g.nodes = append(g.nodes, graphNode{id: netID, label: n, kind: "network"})
+			}
+			g.edges = append(g.edges, graphEdge{from: id, to: netID, dashed: true})
+		}
+	}
+
+	sort.SliceStable(g.nodes[1:], func(i, j int) bool { return g.nodes[i+1].id < g.nodes[j+1].id })
+	sort.SliceStable(g.edges, func(i, j int) bool {
+		if g.edges[i].from != g.edges[j].from {
+			return g.edges[i].from < g.edges[j].from
+		}
+		return g.edges[i].to < g.edges[j].to
+	})
+	return g
+}
+
+// containsEdge dedupes IPv4/IPv6 copies of the same binding
+func containsEdge(edges []graphEdge, e graphEdge) bool {
+	for _, x := range edges {
+		if x == e {
+			return true
+		}
+	}
+	return false
+}
+
+func dotQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return `"` + strings.ReplaceAll(s, "\n", `\n`) + `"`
+}
+
+func renderDOT(g topologyGraph) string {
+	shapes := map[string]string{"host": "house", "port": "box", "container": "ellipse", "network": "hexagon"}
+	var b strings.Builder
+	b.WriteString("digraph quaycheck {\n  rankdir=LR;\n  node [fontname=\"Helvetica\"];\n")
+	for _, n := range g.nodes {
+		fmt.Fprintf(&b, "  %s [label=%s, shape=%s];\n", dotQuote(n.id), dotQuote(n.label), shapes[n.kind])
+	}
+	for _, e := range g.edges {
+		var attrs []string
+		if e.label != "" {
+			attrs = append(attrs, "label="+dotQuote(e.label))
+		}
+		if e.dashed {
+			attrs = append(attrs, "style=dashed", "arrowhead=none")
+		}
+		fmt.Fprintf(&b, "  %s -> %s", dotQuote(e.from), dotQuote(e.to))
+		if len(attrs) > 0 {
+			fmt.Fprintf(&b, " [%s]", strings.Join(attrs, ", "))
+		}
+		b.WriteString(";\n")
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// renderMermaid uses generated ids since mermaid ids can't hold the
+// characters container names and networks do
+func renderMermaid(g topologyGraph) string {
+	ids := make(map[string]string, len(g.nodes))
+	var b strings.Builder
+	b.WriteString("graph LR\n")
+	for i, n := range g.nodes {
+		ids[n.id] = fmt.Sprintf("n%d", i)
+		label := strings.ReplaceAll(n.label, `"`, "#quot;")
+		label = strings.ReplaceAll(label, "\n", "<br/>")
+		left, right := "(", ")"
+		switch n.kind {
+		case "port":
+			left, right = "[", "]"
+		case "network":
+			left, right = "{{", "}}"
+		case "host":
+			left, right = "[[", "]]"
+		}
+		fmt.Fprintf(&b, "  %s%s\"%s\"%s\n", ids[n.id], left, label, right)
+	}
+	for _, e := range g.edges {
+		arrow := "-->"
+		if e.dashed {
+			arrow = "-.-"
+		}
+		if e.label != "" {
+			fmt.Fprintf(&b, "  %s %s|%s| %s\n", ids[e.from], arrow, e.label, ids[e.to])
+		} else {
+			fmt.Fprintf(&b, "  %s %s %s\n", ids[e.from], arrow, ids[e.to])
+		}
+	}
+	return b.String()
+}
+
+// handleGraphExport renders the topology as DOT (default) or mermaid
+func (s *Server) handleGraphExport(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "dot"
+	}
+	if format != "dot" && format != "mermaid" {
+		writeError(w, http.StatusBadRequest, "invalid_param", "format must be dot or mermaid")
+		return
+	}
+	snap, err := s.loadSnapshot(r.Context())
+	if err != nil {
+		status, code, msg := classifyDockerError(err)
+		writeError(w, status, code, msg)
+		return
+	}
+	s.writeSnapshotHeaders(w, snap)
+
+	g := buildTopology(snap.Containers)
+	if format == "mermaid" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprint(w, renderMermaid(g))
+		return
+	}
+	w.Header().Set("Content-Type", "text/vnd.graphviz; charset=utf-8")
+	fmt.Fprint(w, renderDOT(g))
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/network"
+)
+
+func TestGraphExport(t *testing.T) {
+	mock := &MockDockerClient{Containers: []types.Container{
+		{
+			ID: "abc", Names: []string{"/web"}, Image: "nginx", State: "running",
+			Ports: []types.Port{
+				{PrivatePort: 80, PublicPort: 8080, Type: "tcp", IP: "0.0.0.0"},
+				{PrivatePort: 80, PublicPort: 8080, Type: "tcp", IP: "::"},
+			},
+			NetworkSettings: &types.SummaryNetworkSettings{Networks: map[string]*network.EndpointSettings{"front": {}, "back": {}}},
+		},
+		{ID: "def", Names: []string{"/stopped"}, State: "exited", Ports: []types.Port{{PublicPort: 9000, Type: "tcp"}}},
+	}}
+	router := SetupRouter(&Server{client: mock})
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/export/graph", nil))
+	dot := rr.Body.String()
+	for _, want := range []string{
+		`"host" -> "p_8080_tcp";`,
+		`"p_8080_tcp" -> "c_abc" [label="80"];`,
+		`"c_abc" -> "n_back" [style=dashed, arrowhead=none];`,
+		`"c_abc" [label="web\nnginx", shape=ellipse];`,
+	} {
+		if !strings.Contains(dot, want) {
+			t.Errorf("Expected DOT to contain %s:\n%s", want, dot)
+		}
+	}
+	if strings.Count(dot, `"p_8080_tcp" -> "c_abc"`) != 1 || strings.Contains(dot, "9000") {
+		t.Errorf("Expected one edge per binding and no stopped containers:\n%s", dot)
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/export/graph?format=mermaid", nil))
+	if m := rr.Body.String(); !strings.HasPrefix(m, "graph LR\n") || !strings.Contains(m, `"web<br/>nginx"`) || !strings.Contains(m, "-->|80|") {
+		t.Errorf("Unexpected mermaid output:\n%s", m)
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/export/graph?format=svg", nil))
+	if rr.Code != 400 {
+		t.Errorf("Expected 400 for an unknown format, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+)
+
+// latencyBuckets are the upper bounds, in seconds, of the source call histogram
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type sourceHealth struct {
+	calls       uint64
+	errors      uint64
+	latencySum  float64
+	buckets     []uint64
+	lastSuccess time.Time
+	lastError   string
+	up          bool
+	seen        bool
+}
+
+// HealthMonitor tracks call latency, errors and reachability of every port
+// source, Docker included, and logs when one goes down or comes back
+type HealthMonitor struct {
+	mu      sync.Mutex
+	sources map[string]*sourceHealth
+}
+
+func NewHealthMonitor() *HealthMonitor {
+	return &HealthMonitor{sources: make(map[string]*sourceHealth)}
+}
+
+func (m *HealthMonitor) Observe(source string, d time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	h, ok := m.sources[source]
+	if !ok {
+		h = &sourceHealth{buckets: make([]uint64, len(latencyBuckets))}
+		m.sources[source] = h
+	}
+
+	secs := d.Seconds()
+	h.calls++
+	h.latencySum += secs
+	for i, le := range latencyBuckets {
+		if secs <= le {
+			h.buckets[i]++
+		}
+	}
+
+	wasUp, wasSeen := h.up, h.seen
+	h.seen = true
+	if err != nil {
+		h.errors++
+		h.lastError = err.Error()
+		h.up = false
+		if wasUp || !wasSeen {
+			log.Printf("Source %s is unreachable: %v", source, err)
+		}
+		return
+	}
+	h.lastSuccess = time.Now()
+	h.lastError = ""
+	h.up = true
+	if wasSeen && !wasUp {
+		log.Printf("Source %s is reachable again", source)
+	}
+}
+
+// monitoredSource reports every call of the wrapped source to a HealthMonitor
+type monitoredSource struct {
+	PortSource
+	health *HealthMonitor
+}
+
+func (m *monitoredSource) Containers(ctx context.Context) ([]ContainerData, error) {
+	start := time.Now()
+	entries, err := m.PortSource.Containers(ctx)
+	m.health.Observe(m.Name(), time.Since(start), err)
+	return entries, err
+}
+
+type SourceStatus struct {
+	Up          bool       `json:"up"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+	Calls       uint64     `json:"calls"`
+	Errors      uint64     `json:"errors"`
+}
+
+func (m *HealthMonitor) Status() map[string]SourceStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	status := make(map[string]SourceStatus, len(m.sources))
+	for name, h := range m.sources {
+		st := SourceStatus{Up: h.up, LastError: h.lastError, Calls: h.calls, Errors: h.errors}
+		if !h.lastSuccess.IsZero() {
+			t := h.lastSuccess.UTC()
+			st.LastSuccess = &t
+		}
+		status[name] = st
+	}
+	return status
+}
+
+func (m *HealthMonitor) sourceNames() []string {
+	names := make([]string, 0, len(m.sources))
+	for name := range m.sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// handleMetrics serves Prometheus text exposition format
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	fmt.Fprintf(w, "# HELP quaycheck_uptime_seconds Time since the process started.\n# TYPE quaycheck_uptime_seconds gauge\nquaycheck_uptime_seconds %d\n", int64(time.Since(startTime).Seconds()))
+	fmt.Fprintf(w, "# HELP go_goroutines Number of goroutines.\n# TYPE go_goroutines gauge\ngo_goroutines %d\n", runtime.NumGoroutine())
+	s.writeStoreMetrics(r.Context(), w)
+	fmt.Fprintf(w, "# HELP quaycheck_snapshot_shared_total Requests served by a collection another request started.\n# TYPE quaycheck_snapshot_shared_total counter\nquaycheck_snapshot_shared_total %d\n", s.flight.shared.Load())
+	fmt.Fprintf(w, "# HELP quaycheck_requests_shed_total Requests turned away with 429 while Docker was slow.\n# TYPE quaycheck_requests_shed_total counter\nquaycheck_requests_shed_total %d\n", s.shed.Load())
+	if s.leader != nil {
+		leader := 0
+		if s.leader.IsLeader() {
+			leader = 1
+		}
+		fmt.Fprintf(w, "# HELP quaycheck_leader Whether this replica holds the leader lease.\n# TYPE quaycheck_leader gauge\nquaycheck_leader{instance=%q} %d\n", s.leader.ID, leader)
+	}
+
+	if s.health == nil {
+		return
+	}
+	m := s.health
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	names := m.sourceNames()
+
+	fmt.Fprint(w, "# HELP quaycheck_source_up Whether the last call to the source succeeded.\n# TYPE quaycheck_source_up gauge\n")
+	for _, name := range names {
+		up := 0
+		if m.sources[name].up {
+			up = 1
+		}
+		fmt.Fprintf(w, "quaycheck_source_up{source=%q} %d\n", name, up)
+	}
+
+	fmt.Fprint(w, "# HELP quaycheck_source_requests_total Calls made to the source.\n# TYPE quaycheck_source_requests_total counter\n")
+	for _, name := range names {
+		fmt.Fprintf(w, "quaycheck_source_requests_total{source=%q} %d\n", name, m.sources[name].calls)
+	}
+
+	fmt.Fprint(w, "# HELP quaycheck_source_errors_total Failed calls to the source.\n# TYPE quaycheck_source_errors_total counter\n")
+	for _, name := range names {
+		fmt.Fprintf(w, "quaycheck_source_errors_total{source=%q} %d\n", name, m.sources[name].errors)
+	}
+
+	fmt.Fprint(w, "# HELP quaycheck_source_last_success_timestamp_seconds Unix time of the last successful call.\n# TYPE quaycheck_source_last_success_timestamp_seconds gauge\n")
+	for _, name := range names {
+		var ts int64
+		if h := m.sources[name]; !h.lastSuccess.IsZero() {
+			ts = h.lastSuccess.Unix()
+		}
+		fmt.Fprintf(w, "quaycheck_source_last_success_timestamp_seconds{source=%q} %d\n", name, ts)
+	}
+
+	fmt.Fprint(w, "# HELP quaycheck_source_request_duration_seconds Latency of calls to the source.\n# TYPE quaycheck_source_request_duration_seconds histogram\n")
+	for _, name := range names {
+		h := m.sources[name]
+		for i, le := range latencyBuckets {
+			fmt.Fprintf(w, "quaycheck_source_request_duration_seconds_bucket{source=%q,le=\"%g\"} %d\n", name, le, h.buckets[i])
+		}
+		fmt.Fprintf(w, "quaycheck_source_request_duration_seconds_bucket{source=%q,le=\"+Inf\"} %d\n", name, h.calls)
+		fmt.Fprintf(w, "quaycheck_source_request_duration_seconds_sum{source=%q} %g\n", name, h.latencySum)
+		fmt.Fprintf(w, "quaycheck_source_request_duration_seconds_count{source=%q} %d\n", name, h.calls)
+	}
+}
+
+type ReadyResponse struct {
+	Ready   bool                    `json:"ready"`
+	Sources map[string]SourceStatus `json:"sources"`
+	// Leader is set under leader election; followers are ready too, since
+	// they serve reads
+	Leader *bool `json:"leader,omitempty"`
+}
+
+// handleReady reports ready once Docker answers; other sources are listed
+// but don't affect readiness since quaycheck works without them
+func (s *Server) handleReady(w http.ResponseWriter, r *http.Request) {
+	_, err := s.loadSnapshot(r.Context())
+
+	resp := ReadyResponse{Ready: err == nil, Sources: map[string]SourceStatus{}}
+	if s.health != nil {
+		resp.Sources = s.health.Status()
+		if docker, ok := resp.Sources["docker"]; ok && !docker.Up {
+			resp.Ready = false
+		}
+	}
+
+	if s.leader != nil {
+		leader := s.leader.IsLeader()
+		resp.Leader = &leader
+	}
+
+	status := http.StatusOK
+	if !resp.Ready {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+func TestHealthMonitorTransitions(t *testing.T) {
+	m := NewHealthMonitor()
+	m.Observe("docker", 3*time.Millisecond, nil)
+	m.Observe("docker", 2*time.Second, errors.New("connection refused"))
+
+	st := m.Status()["docker"]
+	if st.Up || st.Calls != 2 || st.Errors != 1 || st.LastError != "connection refused" || st.LastSuccess == nil {
+		t.Errorf("Unexpected status after failure: %+v", st)
+	}
+
+	m.Observe("docker", time.Millisecond, nil)
+	if st := m.Status()["docker"]; !st.Up || st.LastError != "" {
+		t.Errorf("Expected docker to be back up, got %+v", st)
+	}
+}
+
+func TestHandleMetrics(t *testing.T) {
+	server := &Server{
+		client: &MockDockerClient{Containers: []types.Container{{ID: "123"}}},
+		health: NewHealthMonitor(),
+	}
+	server.getContainers(httptest.NewRequest("GET", "/", nil).Context())
+
+	w := httptest.NewRecorder()
+	server.handleMetrics(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	body := w.Body.String()
+	for _, want := range []string{
+		`quaycheck_source_up{source="docker"} 1`,
+		`quaycheck_source_requests_total{source="docker"} 1`,
+		`quaycheck_source_errors_total{source="docker"} 0`,
+		`quaycheck_source_request_duration_seconds_bucket{source="docker",le="+Inf"} 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected %q in metrics:\n%s", want, body)
+		}
+	}
+}
+
+func TestHandleReady(t *testing.T) {
+	mock := &MockDockerClient{}
+	server := &Server{client: mock, health: NewHealthMonitor()}
+
+	w := httptest.NewRecorder()
+	server.handleReady(w, httptest.NewRequest("GET", "/readyz", nil))
+	if w.Code != 200 {
+		t.Fatalf("Expected 200, got %d", w.Code)
+	}
+
+	mock.Err = errors.New("Cannot connect to the Docker daemon")
+	w = httptest.NewRecorder()
+	server.handleReady(w, httptest.NewRequest("GET", "/readyz", nil))
+	if w.Code != 503 {
+		t.Fatalf("Expected 503 when Docker is down, got %d", w.Code)
+	}
+	var resp ReadyResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	if resp.Ready || resp.Sources["docker"].Up || resp.Sources["docker"].LastSuccess == nil {
+		t.Errorf("Unexpected readiness: %+v", resp)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"quaycheck/internal/storage"
+)
+
+// HistoryStore keeps every port event the watcher produces, so "what used
+// 8443 last month?" has an answer after the container is long gone
+type HistoryStore struct {
+	db *storage.DB
+}
+
+func NewHistoryStore(db *storage.DB) *HistoryStore {
+	if db == nil {
+		return nil
+	}
+	return &HistoryStore{db: db}
+}
+
+// Record stores events in one transaction
+func (h *HistoryStore) Record(ctx context.Context, events []PortEvent) error {
+	if h == nil || len(events) == 0 {
+		return nil
+	}
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.PrepareContext(ctx, `INSERT INTO history (at, type, port, protocol, ip, container, container_id, source) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, e := range events {
+		if _, err := stmt.ExecContext(ctx, e.Time.UnixNano(), e.Type, e.Port, e.Protocol, e.IP, e.Container, e.ContainerID, e.Source); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// List returns the latest events, newest first, for port or for all ports
+// when port is zero
+func (h *HistoryStore) List(ctx context.Context, port, limit int) ([]PortEvent, error) {
+	result := []PortEvent{}
+	if h == nil {
+		return result, nil
+	}
+	query := `SELECT at, type, port, protocol, ip, container, container_id, source FROM history`
+	args := []any{}
+	if port != 0 {
+		query += ` WHERE port = ?`
+		args = append(args, port)
+	}
+	query += ` ORDER BY at DESC, id DESC LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := h.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanEvents(rows, result)
+}
+
+// Since returns the events recorded from since on, oldest first
+func (h *HistoryStore) Since(ctx context.Context, since time.Time) ([]PortEvent, error) {
+	result := []PortEvent{}
+	if h == nil {
+		return result, nil
+	}
+	rows, err := h.db.QueryContext(ctx, `SELECT at, type, port, protocol, ip, container, container_id, source FROM history WHERE at >= ? ORDER BY at, id`, since.UnixNano())
+	if err != nil {
+		return nil, err
+	}
+	return scanEvents(rows, result)
+}
+
+func scanEvents(rows *sql.Rows, result []PortEvent) ([]PortEvent, error) {
+	defer rows.Close()
+	for rows.Next() {
+		var e PortEvent
+		var at int64
+		if err := rows.Scan(&at, &e.Type, &e.Port, &e.Protocol, &e.IP, &e.Container, &e.ContainerID, &e.Source); err != nil {
+			return nil, err
+		}
+		e.Time = time.Unix(0, at).UTC()
+		result = append(result, e)
+	}
+	return result, rows.Err()
+}
+
+// recordHistory is called by the watcher for every diff
+func (s *Server) recordHistory(ctx context.Context, events []PortEvent) {
+	if err := s.history.Record(ctx, events); err != nil {
+		log.Printf("Cannot record port history: %v", err)
+	}
+}
+
+// limitParam reads ?limit=, defaulting to def and capped at 1000
+func limitParam(r *http.Request, def int) (int, bool) {
+	v := r.URL.Query().Get("limit")
+	if v == "" {
+		return def, true
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 {
+		return 0, false
+	}
+	return min(n, 1000), true
+}
+
+func (s *Server) handleHistory(w http.ResponseWriter, r *http.Request) {
+	port := 0
+	if v := r.URL.Query().Get("port"); v != "" {
+		p, err := strconv.Atoi(v)
+		if err != nil || p < 1 || p > maxPort {
+			writeError(w, http.StatusBadRequest, "invalid_param", "Invalid port")
+			return
+		}
+		port = p
+	}
+	limit, ok := limitParam(r, 100)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "invalid_param", "Invalid limit")
+		return
+	}
+	events, err := s.history.List(r.Context(), port, limit)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "store_error", "Cannot read history: "+err.Error())
+		return
+	}
+	writeEncoded(w, r, events)
+}
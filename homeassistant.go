@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// WatchedPort is a port Home Assistant gets a binary sensor for
+type WatchedPort struct {
+	Name     string `json:"name"`
+	Port     int    `json:"port"`
+	Protocol string `json:"protocol"`
+}
+
+// parseWatchedPorts reads "minecraft=25565,valheim=2456/udp"
+func parseWatchedPorts(s string) ([]WatchedPort, error) {
+	var result []WatchedPort
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, spec, ok := strings.Cut(entry, "=")
+		spec, proto, hasProto := strings.Cut(spec, "/")
+		port, err := strconv.Atoi(spec)
+		if !hasProto {
+			proto = "tcp"
+		}
+		if !ok || name == "" || err != nil || port < 1 || port > maxPort || (proto != "tcp" && proto != "udp") {
+			return nil, fmt.Errorf("invalid watched port %q, expected name=port[/proto]", entry)
+		}
+		result = append(result, WatchedPort{Name: name, Port: port, Protocol: proto})
+	}
+	return result, nil
+}
+
+func watchedPortsFromEnv() []WatchedPort {
+	watched, err := parseWatchedPorts(os.Getenv("QUAYCHECK_HA_WATCH"))
+	if err != nil {
+		log.Printf("Home Assistant watch list ignored: %v", err)
+		return nil
+	}
+	return watched
+}
+
+// HASensor is a binary sensor in the shape Home Assistant's REST
+// integration reads: state is "on" or "off", the rest are attributes
+type HASensor struct {
+	UniqueID    string   `json:"unique_id"`
+	Name        string   `json:"name"`
+	Port        int      `json:"port"`
+	Protocol    string   `json:"protocol"`
+	State       string   `json:"state"`
+	DeviceClass string   `json:"device_class"`
+	Holders     []string `json:"holders"`
+	ReservedFor string   `json:"reserved_for,omitempty"`
+}
+
+// haSensors builds a sensor for every watched port and every reservation.
+// A plain watched port is on while anything holds it. A reserved port is a
+// problem sensor, on while something other than the container it was
+// reserved for holds it.
+func haSensors(containers []ContainerData, watched []WatchedPort, reservations []Reservation) []HASensor {
+	type key struct {
+		port  int
+		proto string
+	}
+	sensors := make(map[key]*HASensor)
+	sensor := func(name string, port int, proto string) *HASensor {
+		k := key{port, proto}
+		if s, ok := sensors[k]; ok {
+			return s
+		}
+		s := &HASensor{
+			UniqueID:    fmt.Sprintf("quaycheck_port_%d_%s", port, proto),
+			Name:        name,
+			Port:        port,
+			Protocol:    proto,
+			DeviceClass: "occupancy",
+			Holders:     []string{},
+		}
+		sensors[k] = s
+		return s
+	}
+	for _, w := range watched {
+		sensor(w.Name, w.Port, w.Protocol)
+	}
+	for _, r := range reservations {
+		s := sensor(r.Name, r.Port, r.Protocol)
+		s.ReservedFor, s.DeviceClass = r.Name, "problem"
+	}
+
+	for _, c := range containers {
+		if !occupiesPorts(c.State) || c.Source == "reservation" {
+			continue
+		}
+		name := containerName(c)
+		for _, p := range c.Ports {
+			s, ok := sensors[key{int(p.PublicPort), p.Type}]
+			if ok && !slices.Contains(s.Holders, name) {
+				s.Holders = append(s.Holders, name)
+			}
+		}
+	}
+
+	result := make([]HASensor, 0, len(sensors))
+	for _, s := range sensors {
+		s.State = "off"
+		for _, h := range s.Holders {
+			if s.ReservedFor == "" || h != s.ReservedFor {
+				s.State = "on"
+			}
+		}
+		result = append(result, *s)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Port != result[j].Port {
+			return result[i].Port < result[j].Port
+		}
+		return result[i].Protocol < result[j].Protocol
+	})
+	return result
+}
+
+func (s *Server) currentHASensors(r *http.Request) ([]HASensor, error) {
+	snap, err := s.loadSnapshot(r.Context())
+	if err != nil {
+		return nil, err
+	}
+	var reservations []Reservation
+	if s.reservations != nil {
+		reservations = s.reservations.List()
+	}
+	return haSensors(snap.Containers, s.config().Watched, reservations), nil
+}
+
+// handleHASensors lists every sensor, for a single REST resource feeding
+// several binary sensors
+func (s *Server) handleHASensors(w http.ResponseWriter, r *http.Request) {
+	sensors, err := s.currentHASensors(r)
+	if err != nil {
+		status, code, msg := classifyDockerError(err)
+		writeError(w, status, code, msg)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sensors)
+}
+
+// handleHASensor returns the sensor for one port (?protocol=udp for UDP)
+func (s *Server) handleHASensor(w http.ResponseWriter, r *http.Request) {
+	port, err := strconv.Atoi(r.PathValue("port"))
+	if err != nil || port < 1 || port > maxPort {
+		writeError(w, http.StatusBadRequest, "invalid_param", "Invalid port")
+		return
+	}
+	proto := r.URL.Query().Get("protocol")
+	if proto == "" {
+		proto = "tcp"
+	}
+	sensors, err := s.currentHASensors(r)
+	if err != nil {
+		status, code, msg := classifyDockerError(err)
+		writeError(w, status, code, msg)
+		return
+	}
+	for _, sensor := range sensors {
+		if sensor.Port == port && sensor.Protocol == proto {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(sensor)
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, "not_watched", localize(w, "Port %d/%s is neither watched nor reserved", port, proto))
+}
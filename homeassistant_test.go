@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+)
+
+func TestParseWatchedPorts(t *testing.T) {
+	watched, err := parseWatchedPorts("minecraft=25565, valheim=2456/udp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(watched) != 2 || watched[0] != (WatchedPort{"minecraft", 25565, "tcp"}) || watched[1] != (WatchedPort{"valheim", 2456, "udp"}) {
+		t.Errorf("Unexpected watched ports: %+v", watched)
+	}
+	for _, bad := range []string{"25565", "x=0", "x=80/sctp"} {
+		if _, err := parseWatchedPorts(bad); err == nil {
+			t.Errorf("Expected an error for %q", bad)
+		}
+	}
+}
+
+func TestHASensors(t *testing.T) {
+	containers := []ContainerData{
+		{Names: []string{"minecraft"}, State: "reserved", Source: "reservation", Ports: []PortMapping{{PublicPort: 25565, Type: "tcp"}}},
+		{Names: []string{"/minecraft"}, State: "running", Ports: []PortMapping{{PublicPort: 25565, Type: "tcp"}}},
+		{Names: []string{"/web"}, State: "running", Ports: []PortMapping{{PublicPort: 8080, Type: "tcp"}, {PublicPort: 27015, Type: "tcp"}}},
+	}
+	watched := []WatchedPort{{"web", 8080, "tcp"}, {"spare", 9000, "tcp"}}
+	reservations := []Reservation{{Name: "minecraft", Port: 25565, Protocol: "tcp"}, {Name: "cs", Port: 27015, Protocol: "tcp"}}
+
+	got := make(map[int]HASensor)
+	for _, s := range haSensors(containers, watched, reservations) {
+		got[s.Port] = s
+	}
+	if s := got[25565]; s.State != "off" || s.DeviceClass != "problem" {
+		t.Errorf("Expected a reserved port held by its own container to be off, got %+v", s)
+	}
+	if s := got[27015]; s.State != "on" || len(s.Holders) != 1 || s.Holders[0] != "web" {
+		t.Errorf("Expected a reserved port taken by another container to be on, got %+v", s)
+	}
+	if s := got[8080]; s.State != "on" || s.DeviceClass != "occupancy" {
+		t.Errorf("Expected an occupied watched port to be on, got %+v", s)
+	}
+	if s := got[9000]; s.State != "off" || s.UniqueID != "quaycheck_port_9000_tcp" {
+		t.Errorf("Expected a free watched port to be off, got %+v", s)
+	}
+}
+
+func TestHASensorEndpoint(t *testing.T) {
+	mock := &MockDockerClient{Containers: []types.Container{
+		{ID: "a", Names: []string{"/web"}, State: "running", Ports: []types.Port{{PublicPort: 8080, Type: "tcp"}}},
+	}}
+	router := SetupRouter(&Server{client: mock, watched: []WatchedPort{{"web", 8080, "tcp"}}})
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/homeassistant/sensors/8080", nil))
+	var sensor HASensor
+	if err := json.NewDecoder(rr.Body).Decode(&sensor); err != nil || sensor.State != "on" {
+		t.Errorf("Expected the sensor to be on, got %d %+v", rr.Code, sensor)
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/homeassistant/sensors/8080?protocol=udp", nil))
+	if rr.Code != 404 {
+		t.Errorf("Expected 404 for an unwatched port, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// localHost names this machine: Docker and every local source
+const localHost = "local"
+
+// hostOf names the host an inventory entry lives on: probed hosts by their
+// name, everything else on this one
+func hostOf(c ContainerData) string {
+	if h, ok := strings.CutPrefix(c.ID, "remote:"); ok {
+		return h
+	}
+	return localHost
+}
+
+// holdsEverywhere is true for entries that claim a port on every host, i.e.
+// reservations, which aren't tied to one
+func holdsEverywhere(c ContainerData) bool {
+	return c.Source == "reservation"
+}
+
+func (s *Server) probeSource() *ProbeSource {
+	for _, src := range s.sources {
+		if p, ok := src.(*ProbeSource); ok {
+			return p
+		}
+	}
+	return nil
+}
+
+// HostInfo describes a host quaycheck knows ports of
+type HostInfo struct {
+	Name        string `json:"name"`
+	Environment string `json:"environment,omitempty"`
+	// Probed is how many ports are probed on a remote host; only those are
+	// known to be free or not
+	Probed int `json:"probed_ports,omitempty"`
+	Used   int `json:"used_ports"`
+}
+
+// hosts lists the local host then every probe target, in configuration
+// order, keeping those of env unless it's empty
+func (s *Server) hosts(env string) []HostInfo {
+	list := []HostInfo{{Name: localHost}}
+	if p := s.probeSource(); p != nil {
+		for _, t := range p.Targets {
+			list = append(list, HostInfo{Name: t.Host, Probed: len(t.Ports)})
+		}
+	}
+	for i := range list {
+		list[i].Environment = s.environments.of(list[i].Name)
+	}
+	if env != "" {
+		list = slices.DeleteFunc(list, func(h HostInfo) bool { return h.Environment != env })
+	}
+	return list
+}
+
+// hostPlan finds ports free on several hosts at once
+type hostPlan struct {
+	used map[string]*PortIndex
+	// probed lists the ports known on each remote host; a port outside it
+	// may well be taken, so it never counts as free
+	probed map[string]map[int]bool
+}
+
+func newHostPlan(containers []ContainerData, hosts []HostInfo, probe *ProbeSource) hostPlan {
+	plan := hostPlan{used: make(map[string]*PortIndex), probed: make(map[string]map[int]bool)}
+	for _, h := range hosts {
+		plan.used[h.Name] = buildPortIndexFunc(containers, func(c ContainerData) bool {
+			return occupiesPorts(c.State) && (hostOf(c) == h.Name || holdsEverywhere(c))
+		})
+	}
+	if probe != nil {
+		for _, t := range probe.Targets {
+			known := make(map[int]bool, len(t.Ports))
+			for _, p := range t.Ports {
+				known[p] = true
+			}
+			plan.probed[t.Host] = known
+		}
+	}
+	return plan
+}
+
+// freeOn reports whether port is known to be free on host
+func (p hostPlan) freeOn(host string, port int) bool {
+	if p.used[host].Used(port) {
+		return false
+	}
+	if known, remote := p.probed[host]; remote && !known[port] {
+		return false
+	}
+	return true
+}
+
+// first returns the lowest port in [start, end] free on every host, or -1
+func (p hostPlan) first(hosts []string, start, end int, skip *PortIndex) int {
+	for port := start; port <= end; port++ {
+		if skip.Used(port) {
+			continue
+		}
+		if !slices.ContainsFunc(hosts, func(h string) bool { return !p.freeOn(h, port) }) {
+			return port
+		}
+	}
+	return -1
+}
+
+type CommonPortResponse struct {
+	Port    int           `json:"port"`
+	Hosts   []string      `json:"hosts"`
+	Code    string        `json:"code"`
+	Message string        `json:"message"`
+	Meta    *ResponseMeta `json:"meta,omitempty"`
+}
+
+func (s *Server) handleHosts(w http.ResponseWriter, r *http.Request) {
+	snap, err := s.loadSnapshot(r.Context())
+	if err != nil {
+		status, code, msg := classifyDockerError(err)
+		writeError(w, status, code, msg)
+		return
+	}
+	list := s.hosts(environmentFrom(r.Context()))
+	for i := range list {
+		for _, c := range snap.Containers {
+			if hostOf(c) == list[i].Name && occupiesPorts(c.State) {
+				list[i].Used += len(c.Ports)
+			}
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// handleSuggestCommon finds a port free on every selected host at once, for
+// services that must listen on the same port everywhere, e.g. behind a
+// keepalived VIP. hosts= selects hosts, all by default, or all of ?env=;
+// start, end and preset work as for suggest.
+func (s *Server) handleSuggestCommon(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	preset, hasPreset, errMsg := s.presetRange(r)
+	if errMsg != "" {
+		writeError(w, http.StatusBadRequest, "invalid_param", errMsg)
+		return
+	}
+	start, end := 8000, maxPort
+	if hasPreset {
+		start, end = preset.Start, preset.End
+	}
+	if v := q.Get("start"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxPort {
+			writeError(w, http.StatusBadRequest, "invalid_param", "Invalid start parameter")
+			return
+		}
+		start = n
+	}
+	if v := q.Get("end"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n > maxPort {
+			writeError(w, http.StatusBadRequest, "invalid_param", "Invalid end parameter")
+			return
+		}
+		end = n
+	}
+	if start > end {
+		writeError(w, http.StatusBadRequest, "invalid_param", "Invalid end parameter")
+		return
+	}
+
+	known := s.hosts(environmentFrom(r.Context()))
+	var hosts []string
+	for _, name := range queryList(q["hosts"]) {
+		if !slices.ContainsFunc(known, func(h HostInfo) bool { return h.Name == name }) {
+			writeError(w, http.StatusBadRequest, "invalid_param", localize(w, "Unknown host %q", name))
+			return
+		}
+		hosts = append(hosts, name)
+	}
+	if len(hosts) == 0 {
+		for _, h := range known {
+			hosts = append(hosts, h.Name)
+		}
+	}
+
+	snap, err := s.loadSnapshot(r.Context())
+	if err != nil {
+		status, code, msg := classifyDockerError(err)
+		writeError(w, status, code, msg)
+		return
+	}
+	cfg := s.config()
+	skip := s.withoutFrozen(s.withoutRecentlyFreed(withoutExcluded(&PortIndex{}, cfg.Excluded), time.Duration(cfg.ReleaseCooldown)))
+	port := newHostPlan(snap.Containers, known, s.probeSource()).first(hosts, start, end, skip)
+	if s.writeIfFrozen(w, port) {
+		return
+	}
+
+	code, msg := "port_suggested", localize(w, "Suggested port: %d", port)
+	if port == -1 {
+		code, msg = "no_free_port", localize(w, "No port in %d-%d is known to be free on every host", start, end)
+	}
+	s.writeSnapshotHeaders(w, snap)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(CommonPortResponse{Port: port, Hosts: hosts, Code: code, Message: msg, Meta: s.snapshotMeta(snap)})
+}
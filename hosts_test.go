@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+)
+
+func newMultiHostServer() *Server {
+	probe := &ProbeSource{Targets: []ProbeTarget{
+		{Host: "lb1", Ports: []int{8000, 8001, 8002, 8003}},
+		{Host: "lb2", Ports: []int{8000, 8001, 8002}},
+	}}
+	probe.results = []ContainerData{
+		{ID: "remote:lb1", Names: []string{"lb1"}, State: "running", Source: "remote", Ports: []PortMapping{{PublicPort: 8001, IP: "lb1"}}},
+		{ID: "remote:lb2", Names: []string{"lb2"}, State: "running", Source: "remote", Ports: []PortMapping{{PublicPort: 8002, IP: "lb2"}}},
+	}
+	return &Server{
+		client:  &MockDockerClient{Containers: []types.Container{{State: "running", Ports: []types.Port{{PublicPort: 8000}}}}},
+		sources: []PortSource{probe},
+	}
+}
+
+func TestSuggestCommon(t *testing.T) {
+	mux := SetupRouter(newMultiHostServer())
+	for query, want := range map[string]int{
+		// 8000 is taken locally, 8001 on lb1, 8002 on lb2, and 8003 isn't
+		// probed on lb2 so it can't be vouched for
+		"":                       -1,
+		"?hosts=lb1,lb2":         8000,
+		"?hosts=local,lb1":       8002,
+		"?hosts=local,lb2":       8001,
+		"?hosts=local&start=100": 100,
+	} {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", "/api/suggest/common"+query, nil))
+		var resp CommonPortResponse
+		json.NewDecoder(w.Body).Decode(&resp)
+		if w.Code != http.StatusOK || resp.Port != want {
+			t.Errorf("%q: expected %d, got %d %+v", query, want, w.Code, resp)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/api/suggest/common?hosts=nope", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unknown host, got %d", w.Code)
+	}
+}
+
+func TestHandleHosts(t *testing.T) {
+	w := httptest.NewRecorder()
+	SetupRouter(newMultiHostServer()).ServeHTTP(w, httptest.NewRequest("GET", "/api/hosts", nil))
+	var hosts []HostInfo
+	json.NewDecoder(w.Body).Decode(&hosts)
+	if len(hosts) != 3 || hosts[0].Name != "local" || hosts[0].Used != 1 || hosts[1].Name != "lb1" || hosts[1].Probed != 4 {
+		t.Errorf("Unexpected hosts: %+v", hosts)
+	}
+}
+
+func TestDefaultPlanIsThisHost(t *testing.T) {
+	mux := SetupRouter(newMultiHostServer())
+
+	// lb1 holding 8001 leaves it free here
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/api/check?port=8001", nil))
+	var check CheckResponse
+	json.NewDecoder(w.Body).Decode(&check)
+	if !check.Available {
+		t.Errorf("Expected 8001 to be free on this host, got %+v", check)
+	}
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/api/suggest?start=8000", nil))
+	var suggest SuggestResponse
+	json.NewDecoder(w.Body).Decode(&suggest)
+	if suggest.Port != 8001 {
+		t.Errorf("Expected 8001, got %d", suggest.Port)
+	}
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/api/widget?start=8000&end=8009", nil))
+	var widget WidgetResponse
+	json.NewDecoder(w.Body).Decode(&widget)
+	if widget.Ports != 1 || widget.Free != 9 {
+		t.Errorf("Expected only this host's 8000 counted, got %+v", widget)
+	}
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Human-readable messages are written in English in the code and looked up
+// by their English format string in a per-language catalog, gettext style,
+// so a missing translation simply falls back to English
+
+// supportedLanguages lists the catalogs, English first as the fallback
+var supportedLanguages = []string{"en", "fr"}
+
+var catalogs = map[string]map[string]string{
+	"fr": {
+		// check and suggest
+		"Port is available": "Le port est disponible",
+		"Port is currently in use by a Docker container": "Le port est actuellement utilisé par un conteneur Docker",
+		"%s, but %s":                                        "%s, mais %s",
+		"container is being removed":                        "le conteneur est en cours de suppression",
+		"container is restarting":                           "le conteneur redémarre",
+		"container is unhealthy":                            "le conteneur est en mauvaise santé",
+		"No free ports found in range":                      "Aucun port libre dans la plage",
+		"Suggested port: %d":                                "Port suggéré : %d",
+		"Preferred port %d is free":                         "Le port préféré %d est libre",
+		"Preferred port %d is taken, nearest free port: %d": "Le port préféré %d est pris, port libre le plus proche : %d",
+		"Suggested port: %d, same as the container port":    "Port suggéré : %d, identique au port du conteneur",
+		"Suggested port: %d for container port %d":          "Port suggéré : %d pour le port de conteneur %d",
+		"port is already in use":                            "le port est déjà utilisé",
+		"no free ports found in range":                      "aucun port libre dans la plage",
+		"reservation already exists":                        "la réservation existe déjà",
+
+		// Docker
+		"Docker API version mismatch. Check socket-proxy compatibility.": "Version de l'API Docker incompatible. Vérifiez la compatibilité du socket-proxy.",
+		"Cannot connect to Docker. Is the daemon running?":               "Impossible de se connecter à Docker. Le démon est-il démarré ?",
+		"Permission denied accessing Docker socket.":                     "Accès au socket Docker refusé.",
+		"Docker request timed out.":                                      "La requête Docker a expiré.",
+		"Docker error":                                                   "Erreur Docker",
+
+		// parameters and bodies
+		"Missing port parameter":                             "Paramètre port manquant",
+		"Invalid port parameter":                             "Paramètre port invalide",
+		"Invalid refresh parameter":                          "Paramètre refresh invalide",
+		"Invalid theme parameter":                            "Paramètre theme invalide",
+		"Invalid port":                                       "Port invalide",
+		"Invalid start parameter":                            "Paramètre start invalide",
+		"Invalid end parameter":                              "Paramètre end invalide",
+		"Invalid start/end range":                            "Plage start/end invalide",
+		"Invalid prefer parameter":                           "Paramètre prefer invalide",
+		"Invalid prefer parameter: below start":              "Paramètre prefer invalide : inférieur à start",
+		"Invalid internal parameter":                         "Paramètre internal invalide",
+		"Invalid offsets parameter":                          "Paramètre offsets invalide",
+		"Invalid cooldown parameter":                         "Paramètre cooldown invalide",
+		"Invalid include_created parameter":                  "Paramètre include_created invalide",
+		"Invalid limit":                                      "Limite invalide",
+		"Invalid duration":                                   "Durée invalide",
+		"Use either prefer or internal, not both":            "Utilisez prefer ou internal, pas les deux",
+		"Method not allowed":                                 "Méthode non autorisée",
+		"Cannot read body":                                   "Impossible de lire le corps de la requête",
+		"Expected a JSON body with at least a name":          "Corps JSON attendu avec au moins un nom",
+		"Expected a JSON body with items":                    "Corps JSON attendu avec des éléments (items)",
+		"Expected a JSON body with stacks or mappings":       "Corps JSON attendu avec des stacks ou des mappings",
+		"Expected a JSON body with tags and/or a note":       "Corps JSON attendu avec des tags et/ou une note",
+		"Expected a JSON object of strings":                  "Objet JSON de chaînes attendu",
+		"Expected a JSON object of settings":                 "Objet JSON de réglages attendu",
+		"Expected a JSON body with a token":                  "Corps JSON attendu avec un jeton (token)",
+		"Missing or invalid CSRF token":                      "Jeton CSRF absent ou invalide",
+		"Expected a JSON body with a name and scopes":        "Corps JSON attendu avec un nom (name) et des droits (scopes)",
+		"Unknown scope %q":                                   "Droit %q inconnu",
+		"Unknown host %q":                                    "Hôte %q inconnu",
+		"Unknown environment %q":                             "Environnement %q inconnu",
+		"No port in %d-%d is known to be free on every host": "Aucun port de %d-%d n'est connu comme libre sur tous les hôtes",
+		"Unknown template %q":                                "Modèle %q inconnu",
+		"Create an admin token first, or the admin endpoints would lock you out":      "Créez d'abord un jeton d'administration, sinon les points d'accès d'administration vous seraient fermés",
+		"Create another admin token first, or the admin endpoints would lock you out": "Créez d'abord un autre jeton d'administration, sinon les points d'accès d'administration vous seraient fermés",
+		"Set QUAYCHECK_ADMIN_TOKEN to restore backups":                                "Définissez QUAYCHECK_ADMIN_TOKEN pour restaurer des sauvegardes",
+		"Set QUAYCHECK_ADMIN_TOKEN to use the admin endpoints":                        "Définissez QUAYCHECK_ADMIN_TOKEN pour utiliser les points d'accès d'administration",
+		"Expected an event object or an array of events":                              "Un événement ou un tableau d'événements est attendu",
+		"Every mapping needs a public_port":                                           "Chaque mapping doit avoir un public_port",
+		"A mapping's public_port_end is below its public_port":                        "La public_port_end d'un mapping est inférieure à son public_port",
+		"format must be hosts, dnsmasq or coredns":                                    "format doit valoir hosts, dnsmasq ou coredns",
+		"Set QUAYCHECK_DNS_ADDRESS or pass address":                                   "Définissez QUAYCHECK_DNS_ADDRESS ou passez address",
+		"address must be an IP address":                                               "address doit être une adresse IP",
+		"format must be dot or mermaid":                                               "format doit valoir dot ou mermaid",
+		"period must be daily or weekly":                                              "period doit valoir daily ou weekly",
+		"Use POST with a JSON object of strings":                                      "Utilisez POST avec un objet JSON de chaînes",
+		"Give both start and end, or neither to freeze the whole host":                "Indiquez start et end, ou aucun des deux pour geler tout l'hôte",
+		"Expected a JSON body with a reason and optional start, end and until":        "Corps JSON attendu avec une raison (reason) et éventuellement start, end et until",
+
+		// state
+		"A reservation with this name already exists":   "Une réservation porte déjà ce nom",
+		"No deleted reservation with this name":         "Aucune réservation supprimée ne porte ce nom",
+		"No reservation with this name":                 "Aucune réservation ne porte ce nom",
+		"Reservations are not enabled on this server":   "Les réservations ne sont pas activées sur ce serveur",
+		"Port is already in use or reserved":            "Le port est déjà utilisé ou réservé",
+		"No freeze with this id":                        "Aucun gel avec cet identifiant",
+		"No token with this id":                         "Aucun jeton avec cet identifiant",
+		"Cannot save tokens":                            "Impossible d'enregistrer les jetons",
+		"No tags on this port":                          "Aucun tag sur ce port",
+		"No state database on this server":              "Pas de base d'état sur ce serveur",
+		"State is being restored; try again shortly":    "Restauration de l'état en cours ; réessayez dans un instant",
+		"Another restore is in progress":                "Une autre restauration est en cours",
+		"Cannot save reservations":                      "Impossible d'enregistrer les réservations",
+		"Cannot save freezes":                           "Impossible d'enregistrer les gels",
+		"Cannot save tags":                              "Impossible d'enregistrer les tags",
+		"Cannot save ingested ports":                    "Impossible d'enregistrer les ports reçus",
+		"Cannot save settings":                          "Impossible d'enregistrer les réglages",
+		"Invalid setting":                               "Réglage invalide",
+		"Cannot read history":                           "Impossible de lire l'historique",
+		"Cannot read audit log":                         "Impossible de lire le journal d'audit",
+		"Cannot read event log":                         "Impossible de lire le journal des événements",
+		"Invalid after parameter":                       "Paramètre after invalide",
+		"kind must be action or port":                   "kind doit valoir action ou port",
+		"Cannot back up state":                          "Impossible de sauvegarder l'état",
+		"Cannot restore":                                "Impossible de restaurer",
+		"Cannot stage restore":                          "Impossible de préparer la restauration",
+		"Restored, but cannot reload state":             "Restauré, mais impossible de recharger l'état",
+		"Cannot list host interfaces":                   "Impossible de lister les interfaces de l'hôte",
+		"Streaming is not supported by this connection": "Le streaming n'est pas pris en charge par cette connexion",
+		"Admin token required":                          "Jeton d'administration requis",
+		"Ingest token required":                         "Jeton d'ingestion requis",
+		"This token lacks the %s scope":                 "Ce jeton n'a pas le droit %s",
+		"Port %d/%s is neither watched nor reserved":    "Le port %d/%s n'est ni surveillé ni réservé",
+
+		// widgets
+		"Port %d":                 "Port %d",
+		"free":                    "libre",
+		"in use":                  "utilisé",
+		"in use by %s":            "utilisé par %s",
+		"ports in use":            "ports utilisés",
+		"containers":              "conteneurs",
+		"conflicts":               "conflits",
+		"free in %s":              "libres dans %s",
+		"Data may be out of date": "Les données sont peut-être périmées",
+
+		// message catalog
+		"Port is in use": "Le port est utilisé",
+		"Port is in use, but its holder may release it soon":               "Le port est utilisé, mais pourrait bientôt être libéré",
+		"A free port was found":                                            "Un port libre a été trouvé",
+		"The preferred port is free":                                       "Le port préféré est libre",
+		"The preferred port is taken; the nearest free port was suggested": "Le port préféré est pris ; le port libre le plus proche est suggéré",
+		"The container port is free on the host":                           "Le port du conteneur est libre sur l'hôte",
+		"A port at an offset from the container port was suggested":        "Un port décalé par rapport au port du conteneur est suggéré",
+		"Port reserved":                                       "Port réservé",
+		"Reservation already present":                         "Réservation déjà présente",
+		"Reservation removed":                                 "Réservation supprimée",
+		"Reservation already absent":                          "Réservation déjà absente",
+		"Port is published by two services of the same stack": "Le port est publié par deux services de la même stack",
+		"Too few ports of a published range are free for the service's replicas": "Trop peu de ports de la plage publiée sont libres pour les réplicas du service",
+		"Allocations are frozen for this port":                                   "Les allocations sont gelées pour ce port",
+		"A required parameter is missing":                                        "Un paramètre obligatoire est manquant",
+		"A parameter is invalid":                                                 "Un paramètre est invalide",
+		"The request body is invalid":                                            "Le corps de la requête est invalide",
+		"The compose file cannot be parsed":                                      "Le fichier compose est illisible",
+		"The systemd unit cannot be parsed":                                      "L'unité systemd est illisible",
+		"The proxy forwards to a port nothing publishes":                         "Le proxy redirige vers un port que rien ne publie",
+		"The proxy forwards to a container that doesn't expose the port":         "Le proxy redirige vers un conteneur qui n'expose pas ce port",
+		"No proxy configs are set up on this server":                             "Aucune configuration de proxy n'est définie sur ce serveur",
+		"No reachability probe is set up on this server":                         "Aucune sonde d'accessibilité n'est configurée sur ce serveur",
+		"No router is set up on this server":                                     "Aucun routeur n'est configuré sur ce serveur",
+		"Cannot read the router's forwards":                                      "Impossible de lire les redirections du routeur",
+		"Cannot read the router's forwards: %v":                                  "Impossible de lire les redirections du routeur : %v",
+		"Cannot read the connection table":                                       "Impossible de lire la table des connexions",
+		"Cannot read the connection table: %v":                                   "Impossible de lire la table des connexions : %v",
+		"Traffic sampling is not enabled on this server":                         "L'échantillonnage du trafic n'est pas activé sur ce serveur",
+		"The port has taken no traffic for a while":                              "Le port n'a reçu aucun trafic depuis un moment",
+		"No traffic for %d days":                                                 "Aucun trafic depuis %d jours",
+		"Port is reachable from the internet":                                    "Le port est accessible depuis internet",
+		"The reachability probe failed":                                          "La sonde d'accessibilité a échoué",
+		"The proxy config cannot be parsed":                                      "La configuration du proxy est illisible",
+		"Expected a proxy config as the body":                                    "Le corps doit contenir une configuration de proxy",
+		"Cannot parse the proxy config: %v":                                      "Impossible de lire la configuration du proxy : %v",
+		"The Kubernetes manifests cannot be parsed":                              "Les manifestes Kubernetes sont illisibles",
+		"Expected Kubernetes manifests as the body":                              "Le corps doit contenir des manifestes Kubernetes",
+		"Cannot parse the manifests: %v":                                         "Impossible de lire les manifestes : %v",
+		"An ingest event is invalid":                                             "Un événement d'ingestion est invalide",
+		"A setting is invalid":                                                   "Un réglage est invalide",
+		"A valid token is required":                                              "Un jeton valide est requis",
+		"The token does not grant this scope":                                    "Le jeton n'accorde pas ce droit",
+		"The CSRF token is missing or invalid":                                   "Le jeton CSRF est absent ou invalide",
+		"The change would leave no admin token":                                  "La modification ne laisserait aucun jeton d'administration",
+		"No admin token is configured":                                           "Aucun jeton d'administration n'est configuré",
+		"Not found":                                                              "Introuvable",
+		"Port is neither watched nor reserved":                                   "Le port n'est ni surveillé ni réservé",
+		"Port is held by another owner":                                          "Le port appartient à un autre propriétaire",
+		"An If-Match header is required":                                         "Un en-tête If-Match est requis",
+		"The reservation was changed since you read it":                          "La réservation a changé depuis votre lecture",
+		"Send If-Match with the reservation's ETag, or * to skip the check":      "Envoyez If-Match avec l'ETag de la réservation, ou * pour ignorer la vérification",
+		"Cannot read or write the state database":                                "Impossible de lire ou d'écrire la base d'état",
+		"Restore failed":                                                         "La restauration a échoué",
+		"The backup archive is invalid":                                          "L'archive de sauvegarde est invalide",
+		"Backup failed":                                                          "La sauvegarde a échoué",
+		"Cannot encode the response":                                             "Impossible d'encoder la réponse",
+		"Docker API version mismatch":                                            "Version de l'API Docker incompatible",
+		"Cannot connect to Docker":                                               "Impossible de se connecter à Docker",
+		"Permission denied accessing Docker socket":                              "Accès au socket Docker refusé",
+		"Cannot reach the quaycheck server":                                      "Impossible de joindre le serveur quaycheck",
+		"Docker request timed out":                                               "La requête Docker a expiré",
+		"The Docker socket is read-only":                                         "Le socket Docker est en lecture seule",
+		"Docker is slow to answer; try again shortly":                            "Docker tarde à répondre ; réessayez dans un instant",
+		"The Docker socket is read-only; container actions need one that allows POST on containers": "Le socket Docker est en lecture seule ; les actions sur les conteneurs en demandent un qui autorise POST sur les conteneurs",
+		"The container no longer exists":                         "Le conteneur n'existe plus",
+		"This Docker client cannot stop or restart containers":   "Ce client Docker ne peut pas arrêter ni redémarrer de conteneurs",
+		"Port is not held by a Docker container":                 "Le port n'est pas tenu par un conteneur Docker",
+		"Port %d is held by %s, which is not a Docker container": "Le port %d est tenu par %s, qui n'est pas un conteneur Docker",
+		"No container publishes port %d":                         "Aucun conteneur ne publie le port %d",
+		"Container stopped":                                      "Conteneur arrêté",
+		"Container restarted":                                    "Conteneur redémarré",
+		"Stopped %s":                                             "%s arrêté",
+		"Restarted %s":                                           "%s redémarré",
+		"Nothing but the reservation holds the port":             "Seule la réservation tient ce port",
+		"Nothing but the reservation holds port %d":              "Seule la réservation tient le port %d",
+		"A reassignment of this reservation is already pending":  "Une réaffectation de cette réservation est déjà en cours",
+		"Invalid grace period":                                   "Délai de grâce invalide",
+		"Stopping needs QUAYCHECK_CONTAINER_ACTIONS":             "L'arrêt nécessite QUAYCHECK_CONTAINER_ACTIONS",
+		"No pending reassignment with this id":                   "Aucune réaffectation en cours avec cet identifiant",
+		"Cannot save reassignments":                              "Impossible d'enregistrer les réaffectations",
+		"No compose project named %q":                            "Aucun projet compose nommé %q",
+		"Invalid timeout parameter":                              "Paramètre timeout invalide",
+	},
+}
+
+// negotiateLanguage picks the supported language the Accept-Language header
+// ranks highest, matching on the primary subtag (fr-CA is fr)
+func negotiateLanguage(header string) string {
+	type pref struct {
+		lang string
+		q    float64
+	}
+	var prefs []pref
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		primary, _, _ := strings.Cut(strings.ToLower(tag), "-")
+		if primary != "" && q > 0 {
+			prefs = append(prefs, pref{primary, q})
+		}
+	}
+	sort.SliceStable(prefs, func(i, j int) bool { return prefs[i].q > prefs[j].q })
+	for _, p := range prefs {
+		for _, lang := range supportedLanguages {
+			if p.lang == lang {
+				return lang
+			}
+		}
+	}
+	return supportedLanguages[0]
+}
+
+// withLanguage negotiates the response language, ?lang= overriding
+// Accept-Language, and records it as Content-Language where writeError and
+// handlers pick it up through localize
+func withLanguage(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Accept-Language")
+		if v := r.URL.Query().Get("lang"); v != "" {
+			header = v
+		}
+		w.Header().Set("Content-Language", negotiateLanguage(header))
+		w.Header().Add("Vary", "Accept-Language")
+		next.ServeHTTP(w, r)
+	})
+}
+
+// localize translates format into the response language and formats it.
+// Messages of the form "Known prefix: detail" get the prefix translated, so
+// wrapped errors keep their (untranslated) detail.
+func localize(w http.ResponseWriter, format string, args ...any) string {
+	catalog := catalogs[w.Header().Get("Content-Language")]
+	if t, ok := catalog[format]; ok {
+		format = t
+	} else if prefix, detail, found := strings.Cut(format, ": "); found && catalog != nil && len(args) == 0 {
+		if t, ok := catalog[prefix]; ok {
+			return t + " : " + detail
+		}
+	}
+	if len(args) == 0 {
+		return format
+	}
+	return fmt.Sprintf(format, args...)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNegotiateLanguage(t *testing.T) {
+	cases := map[string]string{
+		"":                        "en",
+		"fr-FR,fr;q=0.9,en;q=0.8": "fr",
+		"de-DE,en;q=0.5,fr;q=0.7": "fr",
+		"de,es":                   "en",
+		"fr;q=0,en":               "en",
+	}
+	for header, want := range cases {
+		if got := negotiateLanguage(header); got != want {
+			t.Errorf("negotiateLanguage(%q) = %q, want %q", header, got, want)
+		}
+	}
+}
+
+func TestLocalizedMessages(t *testing.T) {
+	router := SetupRouter(&Server{client: &MockDockerClient{}})
+
+	req := httptest.NewRequest("GET", "/api/check?port=8080", nil)
+	req.Header.Set("Accept-Language", "fr-CA,fr;q=0.9")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	var check CheckResponse
+	json.NewDecoder(rr.Body).Decode(&check)
+	if check.Message != "Le port est disponible" || rr.Header().Get("Content-Language") != "fr" {
+		t.Errorf("Expected a French message, got %q (%s)", check.Message, rr.Header().Get("Content-Language"))
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/check?lang=fr", nil))
+	var e ErrorResponse
+	json.NewDecoder(rr.Body).Decode(&e)
+	if e.Message != "Paramètre port manquant" || e.Code != "missing_param" {
+		t.Errorf("Expected a French error with its code unchanged, got %+v", e)
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/check?port=8080", nil))
+	json.NewDecoder(rr.Body).Decode(&check)
+	if check.Message != "Port is available" {
+		t.Errorf("Expected English by default, got %q", check.Message)
+	}
+}
+
+func TestLocalizePrefix(t *testing.T) {
+	rr := httptest.NewRecorder()
+	rr.Header().Set("Content-Language", "fr")
+	if got := localize(rr, "Cannot save tags: disk full"); got != "Impossible d'enregistrer les tags : disk full" {
+		t.Errorf("Expected the prefix translated, got %q", got)
+	}
+}
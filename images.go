@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/registry"
+)
+
+// dockerImageClient is implemented by the real Docker client. Clients
+// without it leave image_digest empty.
+type dockerImageClient interface {
+	ImageInspectWithRaw(ctx context.Context, imageID string) (types.ImageInspect, []byte, error)
+}
+
+// dockerRegistryClient asks the registry, through the daemon and its
+// credentials, what a tag currently points to
+type dockerRegistryClient interface {
+	DistributionInspect(ctx context.Context, image, encodedRegistryAuth string) (registry.DistributionInspect, error)
+}
+
+// imageTracker maps containers to the digests of their images and, when
+// update checks are on, remembers the latest digest the registry reported
+// for each image reference
+type imageTracker struct {
+	mu      sync.Mutex
+	digests map[string][]string // image ID -> repo digests, immutable per ID
+	latest  map[string]string   // image reference -> registry digest
+}
+
+func newImageTracker() *imageTracker {
+	return &imageTracker{digests: make(map[string][]string), latest: make(map[string]string)}
+}
+
+// digestOf strips the repository from "nginx@sha256:..."
+func digestOf(repoDigest string) string {
+	if _, d, ok := strings.Cut(repoDigest, "@"); ok {
+		return d
+	}
+	return repoDigest
+}
+
+// enrich sets ImageDigest on Docker entries and flags UpdateAvailable when the
+// registry's digest for the image reference is none of the local ones. Image
+// IDs never change content, so each is inspected once.
+func (t *imageTracker) enrich(ctx context.Context, client DockerClient, imageIDs map[string]string, containers []ContainerData) {
+	ic, ok := client.(dockerImageClient)
+	if !ok {
+		return
+	}
+
+	for i := range containers {
+		id := imageIDs[containers[i].ID]
+		if id == "" {
+			continue
+		}
+		t.mu.Lock()
+		digests, known := t.digests[id]
+		t.mu.Unlock()
+		if !known {
+			img, _, err := ic.ImageInspectWithRaw(ctx, id)
+			if err != nil {
+				continue
+			}
+			digests = img.RepoDigests
+			t.mu.Lock()
+			t.digests[id] = digests
+			t.mu.Unlock()
+		}
+		if len(digests) == 0 {
+			// Locally built images have no registry digest
+			continue
+		}
+		containers[i].ImageDigest = digestOf(digests[0])
+
+		t.mu.Lock()
+		latest := t.latest[containers[i].Image]
+		t.mu.Unlock()
+		if latest == "" {
+			continue
+		}
+		containers[i].UpdateAvailable = true
+		for _, d := range digests {
+			if digestOf(d) == latest {
+				containers[i].UpdateAvailable = false
+				break
+			}
+		}
+	}
+}
+
+// checkable reports whether ref is a tag worth asking the registry about;
+// pinned digests and bare image IDs can't be outdated
+func checkable(ref string) bool {
+	return ref != "" && !strings.Contains(ref, "@") && !strings.HasPrefix(ref, "sha256:")
+}
+
+// refresh asks the registry for the current digest of every reference.
+// Failures (private registry without credentials, rate limits) keep the
+// previous answer.
+func (t *imageTracker) refresh(ctx context.Context, client DockerClient, refs []string) {
+	rc, ok := client.(dockerRegistryClient)
+	if !ok {
+		return
+	}
+	for _, ref := range refs {
+		info, err := rc.DistributionInspect(ctx, ref, "")
+		if err != nil {
+			log.Printf("Image update check for %s failed: %v", ref, err)
+			continue
+		}
+		t.mu.Lock()
+		t.latest[ref] = string(info.Descriptor.Digest)
+		t.mu.Unlock()
+	}
+}
+
+// checkImageUpdates periodically refreshes registry digests for the images
+// in use. It's opt-in since every check is a registry request and Docker Hub
+// rate-limits anonymous ones.
+func (s *Server) checkImageUpdates(ctx context.Context, interval time.Duration) {
+	if s.images == nil || interval <= 0 {
+		return
+	}
+	for {
+		snap, err := s.loadSnapshot(ctx)
+		if err == nil {
+			seen := make(map[string]bool)
+			var refs []string
+			for _, c := range snap.Containers {
+				if c.Source == "docker" && checkable(c.Image) && !seen[c.Image] {
+					seen[c.Image] = true
+					refs = append(refs, c.Image)
+				}
+			}
+			s.images.refresh(ctx, s.client, refs)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// imageCheckIntervalFromEnv reads QUAYCHECK_IMAGE_UPDATE_INTERVAL; unset
+// disables registry checks
+func imageCheckIntervalFromEnv() time.Duration {
+	d, _ := time.ParseDuration(os.Getenv("QUAYCHECK_IMAGE_UPDATE_INTERVAL"))
+	return d
+}
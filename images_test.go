@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/registry"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// imageDockerClient answers image inspects and registry lookups from tables
+type imageDockerClient struct {
+	MockDockerClient
+	repoDigests map[string][]string
+	registry    map[string]string
+	inspects    atomic.Int32
+}
+
+func (c *imageDockerClient) ImageInspectWithRaw(ctx context.Context, id string) (types.ImageInspect, []byte, error) {
+	c.inspects.Add(1)
+	digests, ok := c.repoDigests[id]
+	if !ok {
+		return types.ImageInspect{}, nil, errors.New("no such image")
+	}
+	return types.ImageInspect{ID: id, RepoDigests: digests}, nil, nil
+}
+
+func (c *imageDockerClient) DistributionInspect(ctx context.Context, ref, auth string) (registry.DistributionInspect, error) {
+	d, ok := c.registry[ref]
+	if !ok {
+		return registry.DistributionInspect{}, errors.New("unauthorized")
+	}
+	return registry.DistributionInspect{Descriptor: ocispec.Descriptor{Digest: digest.Digest(d)}}, nil
+}
+
+func TestImageDigestsAndUpdates(t *testing.T) {
+	client := &imageDockerClient{
+		MockDockerClient: MockDockerClient{Containers: []types.Container{
+			{ID: "web", Image: "nginx:1.25", ImageID: "sha256:aaa"},
+			{ID: "db", Image: "postgres:16", ImageID: "sha256:bbb"},
+			{ID: "local", Image: "myapp", ImageID: "sha256:ccc"},
+		}},
+		repoDigests: map[string][]string{
+			"sha256:aaa": {"nginx@sha256:111"},
+			"sha256:bbb": {"postgres@sha256:222"},
+			"sha256:ccc": {},
+		},
+		registry: map[string]string{
+			"nginx:1.25":  "sha256:111",
+			"postgres:16": "sha256:999",
+		},
+	}
+	server := &Server{client: client, images: newImageTracker()}
+
+	containers, _ := server.getContainers(context.Background())
+	if containers[0].ImageDigest != "sha256:111" || containers[2].ImageDigest != "" {
+		t.Errorf("Unexpected digests: %q, %q", containers[0].ImageDigest, containers[2].ImageDigest)
+	}
+	for _, c := range containers {
+		if c.UpdateAvailable {
+			t.Errorf("Expected no update flag before a registry check: %+v", c)
+		}
+	}
+
+	server.images.refresh(context.Background(), client, []string{"nginx:1.25", "postgres:16", "myapp"})
+	containers, _ = server.getContainers(context.Background())
+	if containers[0].UpdateAvailable || !containers[1].UpdateAvailable || containers[2].UpdateAvailable {
+		t.Errorf("Expected only postgres to be outdated: %+v", containers)
+	}
+	if n := client.inspects.Load(); n != 3 {
+		t.Errorf("Expected each image to be inspected once, got %d inspects", n)
+	}
+}
+
+func TestCheckable(t *testing.T) {
+	for ref, want := range map[string]bool{
+		"nginx:1.25":              true,
+		"ghcr.io/foo/bar":         true,
+		"nginx@sha256:111":        false,
+		"sha256:0123456789abcdef": false,
+		"":                        false,
+	} {
+		if got := checkable(ref); got != want {
+			t.Errorf("checkable(%q) = %v, want %v", ref, got, want)
+		}
+	}
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"quaycheck/internal/storage"
+)
+
+// IngestedPort is a port an external system (CI, a provisioning script, a
+// firewall) reported as taken. It lives in the manual source next to the
+// forwards file, until freed or until ExpiresAt.
+type IngestedPort struct {
+	Port      int        `json:"port"`
+	Protocol  string     `json:"protocol"`
+	IP        string     `json:"ip,omitempty"`
+	Owner     string     `json:"owner"`
+	Target    string     `json:"target,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+type ingestKey struct {
+	port  int
+	proto string
+	ip    string
+}
+
+func (p IngestedPort) key() ingestKey { return ingestKey{p.Port, p.Protocol, p.IP} }
+
+var errHeldByOther = errors.New("port is held by another owner")
+
+// IngestStore persists ingested ports to the ingested table
+type IngestStore struct {
+	db *storage.DB
+
+	mu    sync.Mutex
+	items map[ingestKey]IngestedPort
+}
+
+func NewIngestStore(db *storage.DB) (*IngestStore, error) {
+	s := &IngestStore{db: db}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reload replaces the in-memory ports with the database's
+func (s *IngestStore) Reload() error {
+	items := make(map[ingestKey]IngestedPort)
+	if s.db != nil {
+		rows, err := s.db.Query(`SELECT port, protocol, ip, owner, target, expires_at, updated_at FROM ingested`)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var p IngestedPort
+			var expires sql.NullInt64
+			var updated int64
+			if err := rows.Scan(&p.Port, &p.Protocol, &p.IP, &p.Owner, &p.Target, &expires, &updated); err != nil {
+				return err
+			}
+			if expires.Valid {
+				t := time.Unix(0, expires.Int64).UTC()
+				p.ExpiresAt = &t
+			}
+			p.UpdatedAt = time.Unix(0, updated).UTC()
+			items[p.key()] = p
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+	s.mu.Lock()
+	s.items = items
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *IngestStore) saveLocked() error {
+	if s.db == nil {
+		return nil
+	}
+	var rows [][]any
+	for _, p := range s.listLocked(time.Time{}) {
+		var expires any
+		if p.ExpiresAt != nil {
+			expires = p.ExpiresAt.UnixNano()
+		}
+		rows = append(rows, []any{p.Port, p.Protocol, p.IP, p.Owner, p.Target, expires, p.UpdatedAt.UnixNano()})
+	}
+	return s.db.Replace(context.Background(), "ingested", []string{"port", "protocol", "ip", "owner", "target", "expires_at", "updated_at"}, rows)
+}
+
+// listLocked returns the ports not expired at now; a zero now keeps all
+func (s *IngestStore) listLocked(now time.Time) []IngestedPort {
+	list := make([]IngestedPort, 0, len(s.items))
+	for _, p := range s.items {
+		if !now.IsZero() && p.ExpiresAt != nil && !now.Before(*p.ExpiresAt) {
+			continue
+		}
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Port != list[j].Port {
+			return list[i].Port < list[j].Port
+		}
+		return list[i].Protocol < list[j].Protocol
+	})
+	return list
+}
+
+// List returns the ports that haven't expired
+func (s *IngestStore) List() []IngestedPort {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.listLocked(time.Now())
+}
+
+// Occupy records p, replacing whatever was reported on the same binding
+func (s *IngestStore) Occupy(p IngestedPort) (IngestedPort, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p.UpdatedAt = time.Now().UTC()
+	s.items[p.key()] = p
+	return p, s.saveLocked()
+}
+
+// Free drops the binding of p. An owner, when given, must match the one
+// that reported it, so one system can't free another's port by mistake.
+func (s *IngestStore) Free(p IngestedPort) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cur, ok := s.items[p.key()]
+	if !ok {
+		return false, nil
+	}
+	if p.Owner != "" && p.Owner != cur.Owner {
+		return false, fmt.Errorf("%w (%s)", errHeldByOther, cur.Owner)
+	}
+	delete(s.items, p.key())
+	return true, s.saveLocked()
+}
+
+// rules turns the live ports into forward rules for the manual source
+func (s *IngestStore) rules() []ForwardRule {
+	var result []ForwardRule
+	for _, p := range s.List() {
+		rule := ForwardRule{Name: p.Owner, PublicPort: uint16(p.Port), PrivatePort: uint16(p.Port), Type: p.Protocol, IP: p.IP, Target: p.Target}
+		if host, port, err := net.SplitHostPort(p.Target); err == nil {
+			if n, err := strconv.Atoi(port); err == nil && n > 0 && n <= maxPort {
+				rule.Target, rule.PrivatePort = host, uint16(n)
+			}
+		}
+		result = append(result, rule)
+	}
+	return result
+}
+
+// IngestEvent is what external systems POST to /api/ingest
+type IngestEvent struct {
+	// Event is "occupied" or "freed"
+	Event    string `json:"event"`
+	Port     int    `json:"port"`
+	Protocol string `json:"protocol,omitempty"`
+	IP       string `json:"ip,omitempty"`
+	Owner    string `json:"owner,omitempty"`
+	// Target is where the port leads, e.g. "10.0.0.5:22"
+	Target string `json:"target,omitempty"`
+	// TTL expires an occupied port unless it's reported again, e.g. "24h"
+	TTL string `json:"ttl,omitempty"`
+}
+
+type IngestResult struct {
+	Event   string `json:"event"`
+	Port    int    `json:"port"`
+	Changed bool   `json:"changed"`
+}
+
+// parse validates e and returns the port it refers to
+func (e IngestEvent) parse(now time.Time) (IngestedPort, error) {
+	p := IngestedPort{Port: e.Port, Protocol: e.Protocol, IP: e.IP, Owner: e.Owner, Target: e.Target}
+	if p.Protocol == "" {
+		p.Protocol = "tcp"
+	}
+	switch {
+	case e.Event != "occupied" && e.Event != "freed":
+		return p, fmt.Errorf("event must be occupied or freed, got %q", e.Event)
+	case e.Port < 1 || e.Port > maxPort:
+		return p, fmt.Errorf("invalid port %d", e.Port)
+	case p.Protocol != "tcp" && p.Protocol != "udp":
+		return p, fmt.Errorf("protocol must be tcp or udp, got %q", p.Protocol)
+	case e.Event == "occupied" && e.Owner == "":
+		return p, errors.New("occupied events need an owner")
+	}
+	if e.TTL != "" {
+		d, err := time.ParseDuration(e.TTL)
+		if err != nil || d <= 0 {
+			return p, fmt.Errorf("invalid ttl %q", e.TTL)
+		}
+		t := now.Add(d).UTC()
+		p.ExpiresAt = &t
+	}
+	return p, nil
+}
+
+// requireIngestToken checks the QUAYCHECK_INGEST_TOKEN bearer. Unlike the
+// admin token there's no open mode: the route isn't registered without one.
+func requireIngestToken(token string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+token)) != 1 {
+			writeError(w, http.StatusUnauthorized, "unauthorized", "Ingest token required")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// handleIngest applies one event or a JSON array of them. Events are
+// validated up front so a malformed batch changes nothing.
+func (s *Server) handleIngest(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_body", "Cannot read body: "+err.Error())
+		return
+	}
+	var events []IngestEvent
+	if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '[' {
+		err = json.Unmarshal(trimmed, &events)
+	} else {
+		var e IngestEvent
+		err = json.Unmarshal(trimmed, &e)
+		events = []IngestEvent{e}
+	}
+	if err != nil || len(events) == 0 {
+		writeError(w, http.StatusBadRequest, "invalid_body", "Expected an event object or an array of events")
+		return
+	}
+
+	now := time.Now()
+	ports := make([]IngestedPort, len(events))
+	for i, e := range events {
+		if ports[i], err = e.parse(now); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_event", fmt.Sprintf("Event %d: %v", i, err))
+			return
+		}
+	}
+
+	results := make([]IngestResult, 0, len(events))
+	for i, e := range events {
+		changed := true
+		if e.Event == "occupied" {
+			_, err = s.ingest.Occupy(ports[i])
+		} else {
+			changed, err = s.ingest.Free(ports[i])
+		}
+		if errors.Is(err, errHeldByOther) {
+			writeError(w, http.StatusConflict, "held_by_other", fmt.Sprintf("Event %d: %v", i, err))
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "store_error", "Cannot save ingested ports: "+err.Error())
+			return
+		}
+		if changed {
+			s.audit(r, "ingest."+e.Event, fmt.Sprintf("%d/%s", ports[i].Port, ports[i].Protocol), ports[i].Owner)
+		}
+		results = append(results, IngestResult{Event: e.Event, Port: e.Port, Changed: changed})
+	}
+	s.resync(r.Context())
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
+
+func (s *Server) handleListIngested(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.ingest.List())
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIngestStore(t *testing.T) {
+	db := openTestDB(t)
+	store, err := NewIngestStore(db)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	past := time.Now().Add(-time.Minute)
+	store.Occupy(IngestedPort{Port: 2222, Protocol: "tcp", Owner: "ci", Target: "10.0.0.5:22"})
+	store.Occupy(IngestedPort{Port: 3333, Protocol: "tcp", Owner: "old", ExpiresAt: &past})
+
+	reloaded, _ := NewIngestStore(db)
+	if list := reloaded.List(); len(list) != 1 || list[0].Port != 2222 {
+		t.Fatalf("Expected only the unexpired port after reload, got %+v", list)
+	}
+	if rules := reloaded.rules(); rules[0].Target != "10.0.0.5" || rules[0].PrivatePort != 22 {
+		t.Errorf("Expected the target split into host and port, got %+v", rules[0])
+	}
+
+	if _, err := store.Free(IngestedPort{Port: 2222, Protocol: "tcp", Owner: "someone"}); err == nil {
+		t.Error("Expected freeing another owner's port to fail")
+	}
+	if freed, err := store.Free(IngestedPort{Port: 2222, Protocol: "tcp"}); !freed || err != nil {
+		t.Errorf("Expected the port to be freed, got %v %v", freed, err)
+	}
+}
+
+func TestIngestEndpoint(t *testing.T) {
+	store, _ := NewIngestStore(nil)
+	server := &Server{client: &MockDockerClient{}, ingest: store, ingestToken: "secret"}
+	server.sources = withIngest(nil, store)
+	router := SetupRouter(server)
+
+	post := func(body, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/ingest", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := post(`{"event":"occupied","port":2222,"owner":"ci"}`, "wrong"); rr.Code != 401 {
+		t.Errorf("Expected 401 with a wrong token, got %d", rr.Code)
+	}
+	if rr := post(`[{"event":"occupied","port":2222,"owner":"ci"},{"event":"bogus","port":1}]`, "secret"); rr.Code != 400 || len(store.List()) != 0 {
+		t.Errorf("Expected a bad batch to change nothing, got %d %v", rr.Code, store.List())
+	}
+	if rr := post(`[{"event":"occupied","port":2222,"owner":"ci"},{"event":"occupied","port":5353,"protocol":"udp","owner":"dns"}]`, "secret"); rr.Code != 200 {
+		t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/check?port=2222", nil))
+	var check map[string]any
+	json.NewDecoder(rr.Body).Decode(&check)
+	if check["available"] != false {
+		t.Errorf("Expected an ingested port to be in use, got %v", check)
+	}
+
+	if rr := post(`{"event":"freed","port":2222,"owner":"ci"}`, "secret"); rr.Code != 200 || len(store.List()) != 1 {
+		t.Errorf("Expected the port to be freed, got %d %v", rr.Code, store.List())
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+// dockerInspectClient is implemented by the real Docker client. Clients
+// without it simply leave the uptime and restart fields empty.
+type dockerInspectClient interface {
+	ContainerInspect(ctx context.Context, containerID string) (types.ContainerJSON, error)
+}
+
+// inspectTTL bounds how long restart counts may lag behind
+const inspectTTL = time.Minute
+
+type inspectInfo struct {
+	state        string
+	startedAt    time.Time
+	restartCount int
+	fetched      time.Time
+}
+
+// inspectCache remembers per-container inspect results so a snapshot only
+// inspects containers that are new, changed state or expired, rather than
+// every container every time
+type inspectCache struct {
+	mu    sync.Mutex
+	items map[string]inspectInfo
+}
+
+func newInspectCache() *inspectCache {
+	return &inspectCache{items: make(map[string]inspectInfo)}
+}
+
+// enrich fills StartedAt, UptimeSeconds and RestartCount on Docker entries,
+// inspecting up to 8 containers at a time. Failed inspects leave the
+// entry as it was.
+func (c *inspectCache) enrich(ctx context.Context, client DockerClient, containers []ContainerData) {
+	ic, ok := client.(dockerInspectClient)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	var stale []int
+	c.mu.Lock()
+	live := make(map[string]bool, len(containers))
+	for i, ct := range containers {
+		live[ct.ID] = true
+		info, ok := c.items[ct.ID]
+		if !ok || info.state != ct.State || now.Sub(info.fetched) > inspectTTL {
+			stale = append(stale, i)
+		}
+	}
+	for id := range c.items {
+		if !live[id] {
+			delete(c.items, id)
+		}
+	}
+	c.mu.Unlock()
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, 8)
+	for _, i := range stale {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(ct ContainerData) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			details, err := ic.ContainerInspect(ctx, ct.ID)
+			if err != nil || details.ContainerJSONBase == nil {
+				return
+			}
+			info := inspectInfo{state: ct.State, restartCount: details.RestartCount, fetched: now}
+			if details.State != nil {
+				info.startedAt, _ = time.Parse(time.RFC3339Nano, details.State.StartedAt)
+			}
+			c.mu.Lock()
+			c.items[ct.ID] = info
+			c.mu.Unlock()
+		}(containers[i])
+	}
+	wg.Wait()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i := range containers {
+		info, ok := c.items[containers[i].ID]
+		if !ok {
+			continue
+		}
+		containers[i].RestartCount = info.restartCount
+		if !info.startedAt.IsZero() {
+			started := info.startedAt.UTC()
+			containers[i].StartedAt = &started
+			if occupiesPorts(containers[i].State) {
+				containers[i].UptimeSeconds = int64(now.Sub(started).Seconds())
+			}
+		}
+	}
+}
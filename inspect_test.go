@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+// inspectDockerClient answers inspects from a fixed table and counts them
+type inspectDockerClient struct {
+	MockDockerClient
+	details  map[string]types.ContainerJSON
+	inspects atomic.Int32
+}
+
+func (c *inspectDockerClient) ContainerInspect(ctx context.Context, id string) (types.ContainerJSON, error) {
+	c.inspects.Add(1)
+	d, ok := c.details[id]
+	if !ok {
+		return types.ContainerJSON{}, errors.New("no such container")
+	}
+	return d, nil
+}
+
+func TestInspectEnrichment(t *testing.T) {
+	started := time.Now().Add(-time.Hour).UTC()
+	client := &inspectDockerClient{
+		MockDockerClient: MockDockerClient{Containers: []types.Container{
+			{ID: "a", State: "running"},
+			{ID: "b", State: "exited"},
+			{ID: "gone", State: "running"},
+		}},
+		details: map[string]types.ContainerJSON{
+			"a": {ContainerJSONBase: &types.ContainerJSONBase{RestartCount: 3, State: &types.ContainerState{StartedAt: started.Format(time.RFC3339Nano)}}},
+			"b": {ContainerJSONBase: &types.ContainerJSONBase{RestartCount: 1, State: &types.ContainerState{StartedAt: started.Format(time.RFC3339Nano)}}},
+		},
+	}
+	server := &Server{client: client, inspect: newInspectCache()}
+
+	containers, err := server.getContainers(context.Background())
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	a, b := containers[0], containers[1]
+	if a.RestartCount != 3 || a.StartedAt == nil || a.UptimeSeconds < 3599 {
+		t.Errorf("Unexpected running container details: %+v", a)
+	}
+	if b.RestartCount != 1 || b.UptimeSeconds != 0 {
+		t.Errorf("Expected no uptime for an exited container: %+v", b)
+	}
+	if containers[2].StartedAt != nil {
+		t.Errorf("Expected a failed inspect to leave the entry alone: %+v", containers[2])
+	}
+
+	server.getContainers(context.Background())
+	if n := client.inspects.Load(); n != 4 {
+		t.Errorf("Expected only the failed container to be inspected again, got %d inspects", n)
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// InterfaceInfo describes a host network interface and its addresses
+type InterfaceInfo struct {
+	Name string `json:"name"`
+	// VPN is set for WireGuard, Tailscale, ZeroTier and other tunnels
+	VPN       bool            `json:"vpn,omitempty"`
+	Addresses []AddressStatus `json:"addresses"`
+}
+
+// AddressStatus reports whether a port is free on a single bind address
+type AddressStatus struct {
+	IP string `json:"ip"`
+	// Scope is who can reach the address: loopback, vpn, lan or public
+	Scope     string   `json:"scope,omitempty"`
+	Available *bool    `json:"available,omitempty"`
+	UsedBy    []string `json:"used_by,omitempty"`
+}
+
+// Address scopes, narrowest first
+const (
+	scopeLoopback = "loopback"
+	scopeVPN      = "vpn"
+	scopeLAN      = "lan"
+	scopePublic   = "public"
+)
+
+// vpnInterfacePrefixes name tunnel interfaces: WireGuard, Tailscale,
+// OpenVPN and other tun devices, ZeroTier, Nebula, Netbird.
+// QUAYCHECK_VPN_INTERFACES adds more.
+var vpnInterfacePrefixes = []string{"wg", "tailscale", "tun", "utun", "zt", "nebula", "wt"}
+
+// tailnetRanges are what Tailscale hands out, whatever the interface is
+// called: CGNAT space and its IPv6 ULA
+var tailnetRanges = []*net.IPNet{
+	{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)},
+	{IP: net.ParseIP("fd7a:115c:a1e0::"), Mask: net.CIDRMask(48, 128)},
+}
+
+func isVPNInterface(name string) bool {
+	prefixes := append(slices.Clone(vpnInterfacePrefixes), queryList([]string{os.Getenv("QUAYCHECK_VPN_INTERFACES")})...)
+	return slices.ContainsFunc(prefixes, func(p string) bool { return strings.HasPrefix(name, p) })
+}
+
+// addressScope classifies an address, on a VPN interface or not
+func addressScope(addr string, vpn bool) string {
+	ip := net.ParseIP(addr)
+	switch {
+	case ip == nil:
+		return ""
+	case ip.IsLoopback():
+		return scopeLoopback
+	case vpn || slices.ContainsFunc(tailnetRanges, func(n *net.IPNet) bool { return n.Contains(ip) }):
+		return scopeVPN
+	case ip.IsPrivate() || ip.IsLinkLocalUnicast():
+		return scopeLAN
+	}
+	return scopePublic
+}
+
+// classifyInterfaces fills in which interfaces are VPNs and the scope of
+// every address
+func classifyInterfaces(ifaces []InterfaceInfo) {
+	for i := range ifaces {
+		ifaces[i].VPN = isVPNInterface(ifaces[i].Name)
+		for j := range ifaces[i].Addresses {
+			ifaces[i].Addresses[j].Scope = addressScope(ifaces[i].Addresses[j].IP, ifaces[i].VPN)
+		}
+	}
+}
+
+// bindScope is the widest scope a port bound to bindIP is reachable from:
+// that of the address for a specific one, the widest of the host's
+// addresses it covers for a wildcard
+func bindScope(bindIP string, ifaces []InterfaceInfo) string {
+	widest := ""
+	rank := func(scope string) int {
+		return slices.Index([]string{scopeLoopback, scopeVPN, scopeLAN, scopePublic}, scope)
+	}
+	for _, iface := range ifaces {
+		for _, a := range iface.Addresses {
+			if bindCovers(bindIP, a.IP) && rank(a.Scope) > rank(widest) {
+				widest = a.Scope
+			}
+		}
+	}
+	if widest == "" && bindIP != "" && bindIP != "0.0.0.0" && bindIP != "::" {
+		return addressScope(bindIP, false)
+	}
+	return widest
+}
+
+type InterfacesResponse struct {
+	Port       int             `json:"port,omitempty"`
+	Interfaces []InterfaceInfo `json:"interfaces"`
+	Meta       *ResponseMeta   `json:"meta,omitempty"`
+}
+
+// hostInterfaces is swapped out in tests
+var hostInterfaces = listHostInterfaces
+
+func listHostInterfaces() ([]InterfaceInfo, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	var result []InterfaceInfo
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		info := InterfaceInfo{Name: iface.Name}
+		for _, a := range addrs {
+			ipnet, ok := a.(*net.IPNet)
+			if !ok {
+				continue
+			}
+			info.Addresses = append(info.Addresses, AddressStatus{IP: ipnet.IP.String()})
+		}
+		if len(info.Addresses) > 0 {
+			result = append(result, info)
+		}
+	}
+	return result, nil
+}
+
+// bindCovers reports whether a published binding on bindIP occupies addr.
+// Docker reports wildcard bindings as 0.0.0.0 and :: (or empty).
+func bindCovers(bindIP, addr string) bool {
+	ip := net.ParseIP(addr)
+	switch bindIP {
+	case "":
+		return true
+	case "0.0.0.0":
+		return ip != nil && ip.To4() != nil
+	case "::":
+		return ip != nil && ip.To4() == nil
+	}
+	return net.ParseIP(bindIP).Equal(ip)
+}
+
+func annotateInterfaces(ifaces []InterfaceInfo, containers []ContainerData, port int) {
+	for i := range ifaces {
+		for j := range ifaces[i].Addresses {
+			addr := &ifaces[i].Addresses[j]
+			for _, c := range containers {
+				if !occupiesPorts(c.State) {
+					continue
+				}
+				for _, p := range c.Ports {
+					if int(p.PublicPort) == port && bindCovers(p.IP, addr.IP) {
+						addr.UsedBy = append(addr.UsedBy, containerName(c))
+						break
+					}
+				}
+			}
+			available := len(addr.UsedBy) == 0
+			addr.Available = &available
+		}
+	}
+}
+
+func (s *Server) handleInterfaces(w http.ResponseWriter, r *http.Request) {
+	ifaces, err := hostInterfaces()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "interfaces_error", "Cannot list host interfaces: "+err.Error())
+		return
+	}
+
+	classifyInterfaces(ifaces)
+	resp := InterfacesResponse{Interfaces: ifaces}
+
+	if portStr := r.URL.Query().Get("port"); portStr != "" {
+		port, err := strconv.Atoi(portStr)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_param", "Invalid port parameter")
+			return
+		}
+
+		snap, err := s.loadSnapshot(r.Context())
+		if err != nil {
+			status, code, msg := classifyDockerError(err)
+			writeError(w, status, code, msg)
+			return
+		}
+
+		annotateInterfaces(ifaces, snap.Containers, port)
+		resp.Port = port
+		resp.Meta = s.snapshotMeta(snap)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+)
+
+func TestBindCovers(t *testing.T) {
+	tests := []struct {
+		bind, addr string
+		want       bool
+	}{
+		{"", "192.168.1.10", true},
+		{"0.0.0.0", "192.168.1.10", true},
+		{"0.0.0.0", "fe80::1", false},
+		{"::", "fe80::1", true},
+		{"::", "10.0.0.1", false},
+		{"10.0.0.1", "10.0.0.1", true},
+		{"10.0.0.1", "10.0.0.2", false},
+	}
+
+	for _, tt := range tests {
+		if got := bindCovers(tt.bind, tt.addr); got != tt.want {
+			t.Errorf("bindCovers(%q, %q) = %v, want %v", tt.bind, tt.addr, got, tt.want)
+		}
+	}
+}
+
+func TestHandleInterfaces(t *testing.T) {
+	orig := hostInterfaces
+	defer func() { hostInterfaces = orig }()
+	hostInterfaces = func() ([]InterfaceInfo, error) {
+		return []InterfaceInfo{
+			{Name: "eth0", Addresses: []AddressStatus{{IP: "192.168.1.10"}}},
+			{Name: "wg0", Addresses: []AddressStatus{{IP: "10.8.0.1"}}},
+		}, nil
+	}
+
+	mockClient := &MockDockerClient{Containers: []types.Container{
+		{
+			Names: []string{"/web"},
+			State: "running",
+			Ports: []types.Port{{PublicPort: 8080, IP: "192.168.1.10"}},
+		},
+	}}
+	server := &Server{client: mockClient}
+
+	req := httptest.NewRequest("GET", "/api/interfaces?port=8080", nil)
+	w := httptest.NewRecorder()
+	server.handleInterfaces(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+
+	var result InterfacesResponse
+	json.NewDecoder(w.Body).Decode(&result)
+
+	if len(result.Interfaces) != 2 {
+		t.Fatalf("Expected 2 interfaces, got %d", len(result.Interfaces))
+	}
+	eth0 := result.Interfaces[0].Addresses[0]
+	if *eth0.Available || len(eth0.UsedBy) != 1 || eth0.UsedBy[0] != "web" {
+		t.Errorf("Expected eth0 to be used by web, got %+v", eth0)
+	}
+	if !*result.Interfaces[1].Addresses[0].Available {
+		t.Error("Expected wg0 to be available")
+	}
+	if eth0.Scope != "lan" || !result.Interfaces[1].VPN || result.Interfaces[1].Addresses[0].Scope != "vpn" {
+		t.Errorf("Expected eth0 on the LAN and wg0 a VPN, got %+v", result.Interfaces)
+	}
+
+	req = httptest.NewRequest("GET", "/api/interfaces?port=abc", nil)
+	w = httptest.NewRecorder()
+	server.handleInterfaces(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 on invalid port, got %d", w.Code)
+	}
+}
+
+func TestBindScope(t *testing.T) {
+	t.Setenv("QUAYCHECK_VPN_INTERFACES", "corp")
+	ifaces := []InterfaceInfo{
+		{Name: "lo", Addresses: []AddressStatus{{IP: "127.0.0.1"}, {IP: "::1"}}},
+		{Name: "eth0", Addresses: []AddressStatus{{IP: "192.168.1.10"}, {IP: "2001:db8::10"}}},
+		{Name: "tailscale0", Addresses: []AddressStatus{{IP: "100.101.1.2"}}},
+		{Name: "corp0", Addresses: []AddressStatus{{IP: "10.20.0.5"}}},
+		{Name: "docker0", Addresses: []AddressStatus{{IP: "172.17.0.1"}}},
+	}
+	classifyInterfaces(ifaces)
+	for bind, want := range map[string]string{
+		"127.0.0.1":   "loopback",
+		"100.101.1.2": "vpn",
+		"10.20.0.5":   "vpn",
+		"172.17.0.1":  "lan",
+		"0.0.0.0":     "lan",
+		"::":          "public",
+		"":            "public",
+		"203.0.113.7": "public",
+	} {
+		if got := bindScope(bind, ifaces); got != want {
+			t.Errorf("bindScope(%q) = %q, want %q", bind, got, want)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+// Package portspec parses the port notations users paste into quaycheck:
+// docker run and compose port specs, port ranges, and the -p flags of a
+// docker or podman command line. Everything here reads untrusted input from
+// the analyze endpoints, so malformed text is an error, never a panic.
+package portspec
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Mapping is one published port or range. Published is 0 when the port
+// isn't bound on the host; the End fields are 0 unless the side is a range.
+type Mapping struct {
+	HostIP       string
+	Published    int
+	PublishedEnd int
+	Target       int
+	TargetEnd    int
+	Protocol     string
+}
+
+// Parse parses the docker run / compose short syntax:
+// [[host_ip:]published:]target[/protocol]
+func Parse(spec string) (Mapping, error) {
+	spec = strings.TrimSpace(spec)
+	proto := "tcp"
+	if idx := strings.LastIndex(spec, "/"); idx >= 0 {
+		proto = strings.ToLower(spec[idx+1:])
+		spec = spec[:idx]
+	}
+
+	var hostIP string
+	if strings.HasPrefix(spec, "[") {
+		end := strings.Index(spec, "]:")
+		if end < 0 {
+			return Mapping{}, fmt.Errorf("invalid port %q", spec)
+		}
+		hostIP, spec = spec[1:end], spec[end+2:]
+	}
+
+	parts := strings.Split(spec, ":")
+	var published, target string
+	switch len(parts) {
+	case 1:
+		target = parts[0]
+	case 2:
+		published, target = parts[0], parts[1]
+	case 3:
+		if hostIP != "" {
+			return Mapping{}, fmt.Errorf("invalid port %q", spec)
+		}
+		hostIP, published, target = parts[0], parts[1], parts[2]
+	default:
+		return Mapping{}, fmt.Errorf("invalid port %q", spec)
+	}
+
+	return Pair(hostIP, published, target, proto)
+}
+
+// Pair reads both sides of a mapping given apart, as in the compose long
+// syntax, keeping a range as one Mapping
+func Pair(hostIP, published, target, proto string) (Mapping, error) {
+	tlo, thi, ok := Range(target, "-")
+	if !ok {
+		return Mapping{}, fmt.Errorf("invalid container port %q", target)
+	}
+	if published == "" {
+		return Mapping{}, nil
+	}
+	plo, phi, ok := Range(published, "-")
+	if !ok {
+		return Mapping{}, fmt.Errorf("invalid published port %q", published)
+	}
+	if thi > tlo && thi-tlo != phi-plo {
+		return Mapping{}, fmt.Errorf("port ranges %s and %s differ in size", published, target)
+	}
+
+	m := Mapping{HostIP: hostIP, Published: plo, Target: tlo, Protocol: proto}
+	if phi > plo {
+		m.PublishedEnd = phi
+	}
+	if thi > tlo {
+		m.TargetEnd = thi
+	}
+	return m, nil
+}
+
+// Range parses "8080" or "8000<sep>8010"
+func Range(s, sep string) (int, int, bool) {
+	loStr, hiStr, found := strings.Cut(s, sep)
+	lo, err := strconv.Atoi(loStr)
+	if err != nil || lo < 1 || lo > 65535 {
+		return 0, 0, false
+	}
+	if !found {
+		return lo, lo, true
+	}
+	hi, err := strconv.Atoi(hiStr)
+	if err != nil || hi < lo || hi > 65535 {
+		return 0, 0, false
+	}
+	return lo, hi, true
+}
+
+// PublishFlags returns the port specs a docker or podman run/create
+// command line publishes with -p or --publish
+func PublishFlags(words []string) []string {
+	if len(words) == 0 {
+		return nil
+	}
+	switch filepath.Base(strings.TrimLeft(words[0], "-@+!:")) {
+	case "docker", "podman":
+	default:
+		return nil
+	}
+	if !slices.Contains(words, "run") && !slices.Contains(words, "create") {
+		return nil
+	}
+	var specs []string
+	for i := 1; i < len(words); i++ {
+		w := words[i]
+		switch {
+		case (w == "-p" || w == "--publish") && i+1 < len(words):
+			specs = append(specs, words[i+1])
+			i++
+		case strings.HasPrefix(w, "--publish="):
+			specs = append(specs, strings.TrimPrefix(w, "--publish="))
+		case strings.HasPrefix(w, "-p") && len(w) > 2 && !strings.HasPrefix(w, "--"):
+			specs = append(specs, strings.TrimPrefix(w[2:], "="))
+		}
+	}
+	return specs
+}
+
+// SplitCommand splits a command line into words the way systemd does,
+// honouring single and double quotes and backslash escapes
+func SplitCommand(s string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+	escaped := false
+	for _, r := range s {
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+		case r == '\\':
+			escaped, inWord = true, true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote, inWord = r, true
+		case r == ' ' || r == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote in %q", s)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
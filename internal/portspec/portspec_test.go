@@ -0,0 +1,215 @@
+package portspec
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    Mapping
+		wantErr bool
+	}{
+		{"80", Mapping{}, false},
+		{"80/udp", Mapping{}, false},
+		{"8080:80", Mapping{Published: 8080, Target: 80, Protocol: "tcp"}, false},
+		{" 8080:80 ", Mapping{Published: 8080, Target: 80, Protocol: "tcp"}, false},
+		{"8080:80/UDP", Mapping{Published: 8080, Target: 80, Protocol: "udp"}, false},
+		{"127.0.0.1:8080:80", Mapping{HostIP: "127.0.0.1", Published: 8080, Target: 80, Protocol: "tcp"}, false},
+		{"[::1]:8080:80/udp", Mapping{HostIP: "::1", Published: 8080, Target: 80, Protocol: "udp"}, false},
+		{"127.0.0.1::80", Mapping{}, false},
+		{"8000-8002:80-82", Mapping{Published: 8000, PublishedEnd: 8002, Target: 80, TargetEnd: 82, Protocol: "tcp"}, false},
+		{"8000-8002:80", Mapping{Published: 8000, PublishedEnd: 8002, Target: 80, Protocol: "tcp"}, false},
+		{"65535:65535", Mapping{Published: 65535, Target: 65535, Protocol: "tcp"}, false},
+		{"8000-8002:80-81", Mapping{}, true},
+		{"abc:80", Mapping{}, true},
+		{"8080:abc", Mapping{}, true},
+		{"0:80", Mapping{}, true},
+		{"65536:80", Mapping{}, true},
+		{"8080:-80", Mapping{}, true},
+		{"8002-8000:80", Mapping{}, true},
+		{"1:2:3:4", Mapping{}, true},
+		{"[::1]:1:2:3", Mapping{}, true},
+		{"[::1:8080:80", Mapping{}, true},
+		{"[", Mapping{}, true},
+		{"", Mapping{}, true},
+		{"/", Mapping{}, true},
+		{":", Mapping{}, true},
+	}
+
+	for _, tt := range tests {
+		got, err := Parse(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q: expected error=%v, got %v", tt.spec, tt.wantErr, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%q: expected %+v, got %+v", tt.spec, tt.want, got)
+		}
+	}
+}
+
+func TestPair(t *testing.T) {
+	tests := []struct {
+		hostIP, published, target string
+		want                      Mapping
+		wantErr                   bool
+	}{
+		{"", "8080", "80", Mapping{Published: 8080, Target: 80, Protocol: "tcp"}, false},
+		{"10.0.0.1", "53", "53", Mapping{HostIP: "10.0.0.1", Published: 53, Target: 53, Protocol: "tcp"}, false},
+		{"", "", "80", Mapping{}, false},
+		{"", "", "abc", Mapping{}, true},
+		{"", "abc", "80", Mapping{}, true},
+		{"", "9000-9001", "90-92", Mapping{}, true},
+	}
+
+	for _, tt := range tests {
+		got, err := Pair(tt.hostIP, tt.published, tt.target, "tcp")
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q:%q: expected error=%v, got %v", tt.published, tt.target, tt.wantErr, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%q:%q: expected %+v, got %+v", tt.published, tt.target, tt.want, got)
+		}
+	}
+}
+
+func TestRange(t *testing.T) {
+	tests := []struct {
+		s, sep string
+		lo, hi int
+		ok     bool
+	}{
+		{"8080", "-", 8080, 8080, true},
+		{"8000-8010", "-", 8000, 8010, true},
+		{"8000:8010", ":", 8000, 8010, true},
+		{"1", "-", 1, 1, true},
+		{"65535", "-", 65535, 65535, true},
+		{"8000-8000", "-", 8000, 8000, true},
+		{"0", "-", 0, 0, false},
+		{"65536", "-", 0, 0, false},
+		{"-1", "-", 0, 0, false},
+		{"8010-8000", "-", 0, 0, false},
+		{"8000-", "-", 0, 0, false},
+		{"8000-70000", "-", 0, 0, false},
+		{"8000:8010", "-", 0, 0, false},
+		{"+80", "-", 80, 80, true},
+		{"", "-", 0, 0, false},
+		{"abc", "-", 0, 0, false},
+	}
+
+	for _, tt := range tests {
+		lo, hi, ok := Range(tt.s, tt.sep)
+		if lo != tt.lo || hi != tt.hi || ok != tt.ok {
+			t.Errorf("%q: expected %d-%d %v, got %d-%d %v", tt.s, tt.lo, tt.hi, tt.ok, lo, hi, ok)
+		}
+	}
+}
+
+func TestPublishFlags(t *testing.T) {
+	tests := []struct {
+		command string
+		want    []string
+	}{
+		{"/usr/bin/docker run -p 8080:80 nginx", []string{"8080:80"}},
+		{"podman run --publish 53:53/udp --publish=8443:443 -p9000:9000 -p=9001:9001 img", []string{"53:53/udp", "8443:443", "9000:9000", "9001:9001"}},
+		{"-/usr/bin/docker create -p 80:80 img", []string{"80:80"}},
+		{"docker run --pull always img", nil},
+		{"docker run img -p", nil},
+		{"docker start web -p 80:80", nil},
+		{"/usr/bin/nginx -p 80:80 run", nil},
+		{"", nil},
+	}
+
+	for _, tt := range tests {
+		got := PublishFlags(strings.Fields(tt.command))
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%q: expected %q, got %q", tt.command, tt.want, got)
+		}
+	}
+}
+
+func TestSplitCommand(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    []string
+		wantErr bool
+	}{
+		{"docker run -p 80:80", []string{"docker", "run", "-p", "80:80"}, false},
+		{"  a\t b  ", []string{"a", "b"}, false},
+		{`sh -c "echo hi; exit"`, []string{"sh", "-c", "echo hi; exit"}, false},
+		{`a 'b "c"' d`, []string{"a", `b "c"`, "d"}, false},
+		{`a\ b c`, []string{"a b", "c"}, false},
+		{`""`, []string{""}, false},
+		{`a\`, []string{"a"}, false},
+		{"", nil, false},
+		{`a "b`, nil, true},
+		{`'`, nil, true},
+	}
+
+	for _, tt := range tests {
+		got, err := SplitCommand(tt.s)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q: expected error=%v, got %v", tt.s, tt.wantErr, err)
+			continue
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%q: expected %q, got %q", tt.s, tt.want, got)
+		}
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	for _, seed := range []string{"80", "8080:80/udp", "[::1]:8080:80", "127.0.0.1::80", "8000-8002:80-82", "[", "1:2:3:4", "/"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, spec string) {
+		m, err := Parse(spec)
+		if err != nil {
+			return
+		}
+		if m.Published == 0 {
+			if m != (Mapping{}) {
+				t.Errorf("%q: unpublished port came back as %+v", spec, m)
+			}
+			return
+		}
+		if m.Published > 65535 || m.Target < 1 || m.Target > 65535 {
+			t.Errorf("%q: port out of range in %+v", spec, m)
+		}
+		if m.PublishedEnd != 0 && (m.PublishedEnd <= m.Published || m.PublishedEnd > 65535) {
+			t.Errorf("%q: bad published range in %+v", spec, m)
+		}
+		if m.TargetEnd != 0 && m.TargetEnd-m.Target != m.PublishedEnd-m.Published {
+			t.Errorf("%q: ranges differ in size in %+v", spec, m)
+		}
+	})
+}
+
+func FuzzRange(f *testing.F) {
+	for _, seed := range []string{"8080", "8000-8010", "8010-8000", "-", "0", "65536"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		lo, hi, ok := Range(s, "-")
+		if ok && (lo < 1 || hi < lo || hi > 65535) {
+			t.Errorf("%q: accepted %d-%d", s, lo, hi)
+		}
+	})
+}
+
+func FuzzSplitCommand(f *testing.F) {
+	for _, seed := range []string{"docker run -p 80:80 img", `sh -c "a b"`, `a\ b`, `'x`, `a\`} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		words, err := SplitCommand(s)
+		if err != nil {
+			return
+		}
+		PublishFlags(words)
+	})
+}
@@ -0,0 +1,50 @@
+CREATE TABLE reservations (
+    name       TEXT PRIMARY KEY,
+    port       INTEGER NOT NULL,
+    protocol   TEXT NOT NULL DEFAULT 'tcp',
+    owner      TEXT NOT NULL DEFAULT '',
+    note       TEXT NOT NULL DEFAULT '',
+    created_at INTEGER NOT NULL
+);
+
+CREATE TABLE freezes (
+    id         TEXT PRIMARY KEY,
+    start_port INTEGER NOT NULL DEFAULT 0,
+    end_port   INTEGER NOT NULL DEFAULT 0,
+    reason     TEXT NOT NULL DEFAULT '',
+    until      INTEGER,
+    created_at INTEGER NOT NULL
+);
+
+CREATE TABLE tags (
+    port       INTEGER PRIMARY KEY,
+    tags       TEXT NOT NULL DEFAULT '[]',
+    note       TEXT NOT NULL DEFAULT '',
+    updated_at INTEGER NOT NULL
+);
+
+-- Port events as produced by the watcher
+CREATE TABLE history (
+    id           INTEGER PRIMARY KEY AUTOINCREMENT,
+    at           INTEGER NOT NULL,
+    type         TEXT NOT NULL,
+    port         INTEGER NOT NULL,
+    protocol     TEXT NOT NULL,
+    ip           TEXT NOT NULL DEFAULT '',
+    container    TEXT NOT NULL DEFAULT '',
+    container_id TEXT NOT NULL DEFAULT '',
+    source       TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX history_port ON history (port, at);
+CREATE INDEX history_at ON history (at);
+
+-- Changes made through the API
+CREATE TABLE audit (
+    id      INTEGER PRIMARY KEY AUTOINCREMENT,
+    at      INTEGER NOT NULL,
+    action  TEXT NOT NULL,
+    subject TEXT NOT NULL DEFAULT '',
+    detail  TEXT NOT NULL DEFAULT '',
+    remote  TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX audit_at ON audit (at);
@@ -0,0 +1,11 @@
+-- Ports pushed through /api/ingest by systems quaycheck can't see
+CREATE TABLE ingested (
+    port       INTEGER NOT NULL,
+    protocol   TEXT NOT NULL DEFAULT 'tcp',
+    ip         TEXT NOT NULL DEFAULT '',
+    owner      TEXT NOT NULL,
+    target     TEXT NOT NULL DEFAULT '',
+    expires_at INTEGER,
+    updated_at INTEGER NOT NULL,
+    PRIMARY KEY (port, protocol, ip)
+);
@@ -0,0 +1,6 @@
+-- Runtime settings saved through /api/admin/config, overriding the env
+CREATE TABLE settings (
+    key        TEXT PRIMARY KEY,
+    value      TEXT NOT NULL,
+    updated_at INTEGER NOT NULL
+);
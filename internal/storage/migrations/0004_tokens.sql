@@ -0,0 +1,10 @@
+-- API tokens managed through /api/admin/tokens; only secret hashes are kept
+CREATE TABLE tokens (
+    id           TEXT PRIMARY KEY,
+    name         TEXT NOT NULL,
+    hash         TEXT NOT NULL UNIQUE,
+    scopes       TEXT NOT NULL,
+    created_at   INTEGER NOT NULL,
+    rotated_at   INTEGER,
+    last_used_at INTEGER
+);
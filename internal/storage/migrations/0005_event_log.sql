@@ -0,0 +1,24 @@
+-- Append-only log of every API change and port transition. Rows can't be
+-- changed or removed, only added; hash chains them when enabled.
+CREATE TABLE event_log (
+    seq       INTEGER PRIMARY KEY,
+    at        INTEGER NOT NULL,
+    kind      TEXT NOT NULL,
+    type      TEXT NOT NULL,
+    subject   TEXT NOT NULL DEFAULT '',
+    detail    TEXT NOT NULL DEFAULT '',
+    actor     TEXT NOT NULL DEFAULT '',
+    remote    TEXT NOT NULL DEFAULT '',
+    prev_hash TEXT NOT NULL DEFAULT '',
+    hash      TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TRIGGER event_log_no_update BEFORE UPDATE ON event_log
+BEGIN
+    SELECT RAISE(ABORT, 'event_log is append-only');
+END;
+
+CREATE TRIGGER event_log_no_delete BEFORE DELETE ON event_log
+BEGIN
+    SELECT RAISE(ABORT, 'event_log is append-only');
+END;
@@ -0,0 +1,6 @@
+-- Leases for leader election between replicas sharing this database
+CREATE TABLE leader_lease (
+    name       TEXT PRIMARY KEY,
+    holder     TEXT NOT NULL,
+    expires_at INTEGER NOT NULL
+);
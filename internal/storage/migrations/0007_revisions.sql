@@ -0,0 +1,6 @@
+-- Revision counters for tables written with compare-and-swap, so replicas
+-- sharing the database notice each other's writes
+CREATE TABLE revisions (
+    name TEXT PRIMARY KEY,
+    rev  INTEGER NOT NULL
+);
@@ -0,0 +1,2 @@
+-- Per-reservation revision, served as its ETag for If-Match
+ALTER TABLE reservations ADD COLUMN revision INTEGER NOT NULL DEFAULT 1;
@@ -0,0 +1,18 @@
+-- Deleted reservations stay in the table for a grace period, marked with
+-- deleted_at (0 while live). A live reservation and a deleted one may share
+-- a name, so the key grows to include it.
+CREATE TABLE reservations_new (
+    name       TEXT NOT NULL,
+    port       INTEGER NOT NULL,
+    protocol   TEXT NOT NULL DEFAULT 'tcp',
+    owner      TEXT NOT NULL DEFAULT '',
+    note       TEXT NOT NULL DEFAULT '',
+    created_at INTEGER NOT NULL,
+    revision   INTEGER NOT NULL DEFAULT 1,
+    deleted_at INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (name, deleted_at)
+);
+INSERT INTO reservations_new (name, port, protocol, owner, note, created_at, revision)
+    SELECT name, port, protocol, owner, note, created_at, revision FROM reservations;
+DROP TABLE reservations;
+ALTER TABLE reservations_new RENAME TO reservations;
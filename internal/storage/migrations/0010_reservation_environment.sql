@@ -0,0 +1,2 @@
+-- Environment a reservation was made in; '' holds the port in all of them
+ALTER TABLE reservations ADD COLUMN environment TEXT NOT NULL DEFAULT '';
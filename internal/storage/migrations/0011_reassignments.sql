@@ -0,0 +1,15 @@
+-- Ports being reclaimed from the containers squatting on reservations
+CREATE TABLE reassignments (
+    id           TEXT PRIMARY KEY,
+    reservation  TEXT NOT NULL,
+    port         INTEGER NOT NULL,
+    container    TEXT NOT NULL DEFAULT '',
+    container_id TEXT NOT NULL DEFAULT '',
+    owner        TEXT NOT NULL DEFAULT '',
+    message      TEXT NOT NULL DEFAULT '',
+    stop         INTEGER NOT NULL DEFAULT 0,
+    deadline     INTEGER NOT NULL,
+    status       TEXT NOT NULL,
+    error        TEXT NOT NULL DEFAULT '',
+    created_at   INTEGER NOT NULL
+);
@@ -0,0 +1,2 @@
+-- Whether the reservation is forwarded on the router
+ALTER TABLE reservations ADD COLUMN public INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,11 @@
+-- Rule counters of published ports, per sampling interval
+CREATE TABLE traffic (
+    id          INTEGER PRIMARY KEY AUTOINCREMENT,
+    at          INTEGER NOT NULL,
+    port        INTEGER NOT NULL,
+    protocol    TEXT NOT NULL,
+    connections INTEGER NOT NULL DEFAULT 0,
+    bytes_in    INTEGER NOT NULL DEFAULT 0
+);
+CREATE INDEX traffic_port ON traffic (port, at);
+CREATE INDEX traffic_at ON traffic (at);
@@ -0,0 +1,19 @@
+-- Names are unique within an environment, not across them: staging and
+-- prod may each reserve a "grafana". The key grows to include it.
+CREATE TABLE reservations_new (
+    name        TEXT NOT NULL,
+    port        INTEGER NOT NULL,
+    protocol    TEXT NOT NULL DEFAULT 'tcp',
+    owner       TEXT NOT NULL DEFAULT '',
+    note        TEXT NOT NULL DEFAULT '',
+    created_at  INTEGER NOT NULL,
+    revision    INTEGER NOT NULL DEFAULT 1,
+    deleted_at  INTEGER NOT NULL DEFAULT 0,
+    environment TEXT NOT NULL DEFAULT '',
+    public      INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (environment, name, deleted_at)
+);
+INSERT INTO reservations_new (name, port, protocol, owner, note, created_at, revision, deleted_at, environment, public)
+    SELECT name, port, protocol, owner, note, created_at, revision, deleted_at, environment, public FROM reservations;
+DROP TABLE reservations;
+ALTER TABLE reservations_new RENAME TO reservations;
@@ -0,0 +1,3 @@
+-- Environment of the reservation being reclaimed, now that names are only
+-- unique within one
+ALTER TABLE reassignments ADD COLUMN environment TEXT NOT NULL DEFAULT '';
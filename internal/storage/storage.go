@@ -0,0 +1,337 @@
+// Package storage is quaycheck's SQLite state: reservations, freezes, tags,
+// port history and the audit log, with schema migrations embedded in the
+// binary.
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// DB is an open state database with its schema up to date
+type DB struct {
+	*sql.DB
+	path string
+}
+
+// Open opens or creates the database at path and applies pending
+// migrations
+func Open(path string) (*DB, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	dsn := "file:" + path + "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=foreign_keys(1)"
+	sqlDB, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows one writer; a single connection avoids SQLITE_BUSY
+	// between our own goroutines
+	sqlDB.SetMaxOpenConns(1)
+
+	db := &DB{DB: sqlDB, path: path}
+	if err := db.migrate(context.Background()); err != nil {
+		sqlDB.Close()
+		return nil, fmt.Errorf("migrate %s: %w", path, err)
+	}
+	return db, nil
+}
+
+// Path is the database file
+func (db *DB) Path() string { return db.path }
+
+type migration struct {
+	version int
+	name    string
+}
+
+// pending lists embedded migrations, named NNNN_description.sql, in order
+func pending() ([]migration, error) {
+	entries, err := fs.ReadDir(migrations, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	var list []migration
+	for _, e := range entries {
+		prefix, _, _ := strings.Cut(e.Name(), "_")
+		v, err := strconv.Atoi(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s has no numeric prefix", e.Name())
+		}
+		list = append(list, migration{version: v, name: e.Name()})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].version < list[j].version })
+	return list, nil
+}
+
+// migrate applies every migration newer than the recorded version, each in
+// its own transaction
+func (db *DB) migrate(ctx context.Context) error {
+	if _, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		applied_at INTEGER NOT NULL
+	)`); err != nil {
+		return err
+	}
+	current, err := db.Version(ctx)
+	if err != nil {
+		return err
+	}
+	list, err := pending()
+	if err != nil {
+		return err
+	}
+	for _, m := range list {
+		if m.version <= current {
+			continue
+		}
+		script, err := migrations.ReadFile("migrations/" + m.name)
+		if err != nil {
+			return err
+		}
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, string(script)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("%s: %w", m.name, err)
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)`, m.version, time.Now().Unix()); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Version is the latest applied migration, 0 for an empty database
+func (db *DB) Version(ctx context.Context) (int, error) {
+	var v sql.NullInt64
+	err := db.QueryRowContext(ctx, `SELECT MAX(version) FROM schema_migrations`).Scan(&v)
+	return int(v.Int64), err
+}
+
+// Replace swaps the whole content of table for rows in one transaction.
+// The stores keep their data in memory and are small, so rewriting a table
+// keeps them as simple as the JSON files they replace.
+func (db *DB) Replace(ctx context.Context, table string, columns []string, rows [][]any) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM "+table); err != nil {
+		return err
+	}
+	if len(rows) > 0 {
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ")
+		stmt, err := tx.PrepareContext(ctx, fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", table, strings.Join(columns, ", "), placeholders))
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		for _, row := range rows {
+			if _, err := stmt.ExecContext(ctx, row...); err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// Backup writes a consistent copy of the database to w. VACUUM INTO takes
+// the snapshot inside one read transaction, so writes carry on meanwhile.
+func (db *DB) Backup(ctx context.Context, w io.Writer) error {
+	tmp, err := os.CreateTemp(filepath.Dir(db.path), ".backup-*.db")
+	if err != nil {
+		return err
+	}
+	name := tmp.Name()
+	tmp.Close()
+	// VACUUM INTO refuses to overwrite an existing file
+	os.Remove(name)
+	defer os.Remove(name)
+
+	if _, err := db.ExecContext(ctx, `VACUUM INTO ?`, name); err != nil {
+		return err
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+var (
+	// ErrCorrupt is returned by Restore for a file that fails SQLite's
+	// integrity check
+	ErrCorrupt = errors.New("database failed the integrity check")
+	// ErrNewerSchema is returned by Restore for a backup made by a newer
+	// quaycheck than this one
+	ErrNewerSchema = errors.New("database schema is newer than this version supports")
+)
+
+// LatestVersion is the schema version this binary migrates to
+func LatestVersion() int {
+	list, err := pending()
+	if err != nil || len(list) == 0 {
+		return 0
+	}
+	return list[len(list)-1].version
+}
+
+// IntegrityCheck runs PRAGMA integrity_check
+func (db *DB) IntegrityCheck(ctx context.Context) error {
+	var result string
+	if err := db.QueryRowContext(ctx, `PRAGMA integrity_check`).Scan(&result); err != nil {
+		return err
+	}
+	if result != "ok" {
+		return fmt.Errorf("%w: %s", ErrCorrupt, result)
+	}
+	return nil
+}
+
+// Restore replaces every table's content with the content of the database
+// file at path, in one transaction. The file is checked and migrated to the
+// current schema first, so backups from older versions restore cleanly.
+func (db *DB) Restore(ctx context.Context, path string) error {
+	// Check the version before Open would migrate it
+	if err := checkVersion(ctx, path); err != nil {
+		return err
+	}
+	src, err := Open(path)
+	if err != nil {
+		return err
+	}
+	err = src.IntegrityCheck(ctx)
+	src.Close()
+	if err != nil {
+		return err
+	}
+
+	// ATTACH can't run inside a transaction, and has to happen on the
+	// connection the copy runs on
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, `ATTACH DATABASE ? AS restored`, path); err != nil {
+		return err
+	}
+	defer conn.ExecContext(context.Background(), `DETACH DATABASE restored`)
+
+	rows, err := conn.QueryContext(ctx, `SELECT name FROM main.sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' AND name != 'schema_migrations'`)
+	if err != nil {
+		return err
+	}
+	var tables []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		tables = append(tables, name)
+	}
+	rows.Close()
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, t := range tables {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM main."+t); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf("INSERT INTO main.%s SELECT * FROM restored.%s", t, t)); err != nil {
+			return fmt.Errorf("restore %s: %w", t, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// checkVersion refuses files migrated past what this binary knows
+func checkVersion(ctx context.Context, path string) error {
+	sqlDB, err := sql.Open("sqlite", "file:"+path+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer sqlDB.Close()
+	var v sql.NullInt64
+	if err := sqlDB.QueryRowContext(ctx, `SELECT MAX(version) FROM schema_migrations`).Scan(&v); err != nil {
+		return fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	if int(v.Int64) > LatestVersion() {
+		return fmt.Errorf("%w (backup is at %d, this binary at %d)", ErrNewerSchema, v.Int64, LatestVersion())
+	}
+	return nil
+}
+
+// Prune deletes rows of an append-only table (history, audit) older than
+// before, when set, and beyond the newest maxRows, when positive. It
+// returns how many rows went.
+func (db *DB) Prune(ctx context.Context, table string, before time.Time, maxRows int) (int64, error) {
+	var total int64
+	if !before.IsZero() {
+		res, err := db.ExecContext(ctx, "DELETE FROM "+table+" WHERE at < ?", before.UnixNano())
+		if err != nil {
+			return 0, err
+		}
+		n, _ := res.RowsAffected()
+		total += n
+	}
+	if maxRows > 0 {
+		res, err := db.ExecContext(ctx, "DELETE FROM "+table+" WHERE id <= (SELECT id FROM "+table+" ORDER BY id DESC LIMIT 1 OFFSET ?)", maxRows)
+		if err != nil {
+			return total, err
+		}
+		n, _ := res.RowsAffected()
+		total += n
+	}
+	return total, nil
+}
+
+// Size is the database file size in bytes, not counting the WAL
+func (db *DB) Size(ctx context.Context) (int64, error) {
+	var pages, pageSize int64
+	if err := db.QueryRowContext(ctx, `PRAGMA page_count`).Scan(&pages); err != nil {
+		return 0, err
+	}
+	if err := db.QueryRowContext(ctx, `PRAGMA page_size`).Scan(&pageSize); err != nil {
+		return 0, err
+	}
+	return pages * pageSize, nil
+}
+
+// Count returns the number of rows in table
+func (db *DB) Count(ctx context.Context, table string) (int64, error) {
+	var n int64
+	err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+table).Scan(&n)
+	return n, err
+}
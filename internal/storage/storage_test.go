@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestOpenMigrates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.db")
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	list, _ := pending()
+	want := list[len(list)-1].version
+	if v, err := db.Version(context.Background()); err != nil || v != want {
+		t.Errorf("Expected version %d, got %d (%v)", want, v, err)
+	}
+	db.Close()
+
+	// Reopening must not reapply anything
+	db, err = Open(path)
+	if err != nil {
+		t.Fatalf("Expected reopen to succeed, got %v", err)
+	}
+	defer db.Close()
+	var n int
+	db.QueryRow(`SELECT COUNT(*) FROM schema_migrations`).Scan(&n)
+	if n != len(list) {
+		t.Errorf("Expected %d recorded migrations, got %d", len(list), n)
+	}
+}
+
+func TestReplace(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "state.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+	cols := []string{"port", "tags", "note", "updated_at"}
+
+	db.Replace(ctx, "tags", cols, [][]any{{80, "[]", "a", 1}, {443, "[]", "b", 1}})
+	if err := db.Replace(ctx, "tags", cols, [][]any{{8080, "[]", "c", 2}}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	var n int
+	db.QueryRow(`SELECT COUNT(*) FROM tags`).Scan(&n)
+	if n != 1 {
+		t.Errorf("Expected the table to be replaced, got %d rows", n)
+	}
+
+	// A failing row rolls the whole replacement back
+	if err := db.Replace(ctx, "tags", cols, [][]any{{1, "[]", "", 1}, {1, "[]", "", 1}}); err == nil {
+		t.Error("Expected a duplicate key error")
+	}
+	db.QueryRow(`SELECT COUNT(*) FROM tags`).Scan(&n)
+	if n != 1 {
+		t.Errorf("Expected the failed replacement to leave the table alone, got %d rows", n)
+	}
+}
+
+func TestRestoreRefusesNewerSchema(t *testing.T) {
+	dir := t.TempDir()
+	db, _ := Open(filepath.Join(dir, "live.db"))
+	defer db.Close()
+
+	newer, _ := Open(filepath.Join(dir, "newer.db"))
+	newer.Exec(`INSERT INTO schema_migrations (version, applied_at) VALUES (?, 0)`, LatestVersion()+1)
+	newer.Close()
+
+	if err := db.Restore(context.Background(), filepath.Join(dir, "newer.db")); !errors.Is(err, ErrNewerSchema) {
+		t.Errorf("Expected ErrNewerSchema, got %v", err)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	db, _ := Open(filepath.Join(t.TempDir(), "state.db"))
+	defer db.Close()
+	ctx := context.Background()
+
+	now := time.Now()
+	for i := 0; i < 10; i++ {
+		// Two rows a day old, eight recent
+		at := now
+		if i < 2 {
+			at = now.Add(-24 * time.Hour)
+		}
+		db.Exec(`INSERT INTO audit (at, action) VALUES (?, 'x')`, at.UnixNano())
+	}
+
+	n, err := db.Prune(ctx, "audit", now.Add(-time.Hour), 5)
+	if err != nil || n != 5 {
+		t.Errorf("Expected 5 rows pruned (2 by age, 3 by count), got %d (%v)", n, err)
+	}
+	if left, _ := db.Count(ctx, "audit"); left != 5 {
+		t.Errorf("Expected 5 rows left, got %d", left)
+	}
+	if n, _ := db.Prune(ctx, "audit", time.Time{}, 0); n != 0 {
+		t.Errorf("Expected no limits to prune nothing, got %d", n)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import "time"
+
+// The inventory types. This file, compose.go and planner.go are also built
+// on their own for js/wasm, as the web UI's planner (make wasm): keep them
+// free of anything that doesn't build there, such as the Docker client.
+
+type PortMapping struct {
+	PrivatePort uint16 `json:"private_port"`
+	PublicPort  uint16 `json:"public_port"`
+	Type        string `json:"type"`
+	IP          string `json:"ip,omitempty"`
+}
+
+type ContainerData struct {
+	ID     string        `json:"id"`
+	Names  []string      `json:"names"`
+	Image  string        `json:"image"`
+	State  string        `json:"state"`
+	Status string        `json:"status,omitempty"`
+	Health string        `json:"health,omitempty"`
+	Ports  []PortMapping `json:"ports"`
+	Source string        `json:"source,omitempty"`
+
+	// Filled from docker inspect when available
+	StartedAt     *time.Time `json:"started_at,omitempty"`
+	UptimeSeconds int64      `json:"uptime_seconds,omitempty"`
+	RestartCount  int        `json:"restart_count,omitempty"`
+
+	// ImageDigest is the registry digest of the image the container runs;
+	// UpdateAvailable is set when the registry now has a different one
+	ImageDigest     string `json:"image_digest,omitempty"`
+	UpdateAvailable bool   `json:"update_available,omitempty"`
+
+	// Project is the compose project the container belongs to, if any, and
+	// the rest of what compose labels it with: the service, the replica
+	// number and where the project was started from
+	Project      string   `json:"project,omitempty"`
+	Service      string   `json:"service,omitempty"`
+	Replica      int      `json:"replica,omitempty"`
+	ComposeFiles []string `json:"compose_files,omitempty"`
+	ComposeDir   string   `json:"compose_dir,omitempty"`
+
+	// Owner is who answers for the container, from its labels
+	Owner string `json:"owner,omitempty"`
+
+	// Networks are the Docker networks the container is attached to
+	Networks []string `json:"networks,omitempty"`
+
+	// Environment is the environment a reservation was made in; empty for
+	// everything else
+	Environment string `json:"environment,omitempty"`
+
+	// Advertise are the mDNS service types the container opted into with
+	// the quaycheck.mdns label
+	Advertise []string `json:"advertise,omitempty"`
+}
+
+// PortRange is an inclusive range of ports
+type PortRange struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// occupiesPorts reports whether an entry in this state holds its host ports.
+// Paused and restarting containers keep their bindings, so they count too.
+func occupiesPorts(state string) bool {
+	switch state {
+	case "running", "paused", "restarting", "reserved":
+		return true
+	}
+	return false
+}
+
+// containerName is the name an entry goes by: its first name, else its
+// short ID
+func containerName(c ContainerData) string {
+	if len(c.Names) > 0 {
+		name := c.Names[0]
+		if len(name) > 0 && name[0] == '/' {
+			name = name[1:]
+		}
+		return name
+	}
+	if len(c.ID) > 12 {
+		return c.ID[:12]
+	}
+	return c.ID
+}
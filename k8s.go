@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// k8sHostnameLabel pins a pod to one node in a nodeSelector
+const k8sHostnameLabel = "kubernetes.io/hostname"
+
+// K8sAnalysis is what a set of manifests would publish on the nodes, and
+// what that conflicts with
+type K8sAnalysis struct {
+	OK        bool              `json:"ok"`
+	Ports     []ComposePort     `json:"ports"`
+	Conflicts []ComposeConflict `json:"conflicts"`
+	Meta      *ResponseMeta     `json:"meta,omitempty"`
+}
+
+// ParseManifests extracts the host ports Kubernetes manifests publish:
+// hostPort on the nodes their pods are pinned to by nodeName or a
+// kubernetes.io/hostname nodeSelector, every node otherwise, and a
+// NodePort or LoadBalancer service's nodePort on every node. Each port is
+// named after its object as "Kind/name"; other kinds are skipped.
+func ParseManifests(data []byte) ([]ComposePort, error) {
+	dec := yaml.NewDecoder(strings.NewReader(string(data)))
+	var result []ComposePort
+	for {
+		var doc yaml.Node
+		err := dec.Decode(&doc)
+		if errors.Is(err, io.EOF) {
+			return result, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(doc.Content) == 0 {
+			continue
+		}
+		ports, err := manifestPorts(doc.Content[0])
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, ports...)
+	}
+}
+
+// manifestPorts reads one object, or each item of a List
+func manifestPorts(obj *yaml.Node) ([]ComposePort, error) {
+	kind := scalarValue(obj, "kind")
+	if strings.HasSuffix(kind, "List") {
+		var result []ComposePort
+		if items := mappingValue(obj, "items"); items != nil && items.Kind == yaml.SequenceNode {
+			for _, item := range items.Content {
+				ports, err := manifestPorts(item)
+				if err != nil {
+					return nil, err
+				}
+				result = append(result, ports...)
+			}
+		}
+		return result, nil
+	}
+	name := kind + "/" + scalarValue(mappingValue(obj, "metadata"), "name")
+	spec := mappingValue(obj, "spec")
+
+	switch kind {
+	case "Service":
+		return nodePorts(name, spec)
+	case "Pod":
+	case "Deployment", "StatefulSet", "DaemonSet", "ReplicaSet", "ReplicationController", "Job":
+		spec = mappingValue(mappingValue(spec, "template"), "spec")
+	case "CronJob":
+		spec = mappingValue(mappingValue(mappingValue(mappingValue(spec, "jobTemplate"), "spec"), "template"), "spec")
+	default:
+		return nil, nil
+	}
+	return hostPorts(name, spec)
+}
+
+// hostPorts reads the hostPort of each container and init container of a
+// pod spec
+func hostPorts(name string, spec *yaml.Node) ([]ComposePort, error) {
+	var constraints []string
+	if node := scalarValue(spec, "nodeName"); node != "" {
+		constraints = append(constraints, "node.hostname == "+node)
+	}
+	if node := scalarValue(mappingValue(spec, "nodeSelector"), k8sHostnameLabel); node != "" {
+		constraints = append(constraints, "node.hostname == "+node)
+	}
+
+	var result []ComposePort
+	for _, key := range []string{"initContainers", "containers"} {
+		containers := mappingValue(spec, key)
+		if containers == nil || containers.Kind != yaml.SequenceNode {
+			continue
+		}
+		for _, c := range containers.Content {
+			ports := mappingValue(c, "ports")
+			if ports == nil || ports.Kind != yaml.SequenceNode {
+				continue
+			}
+			for _, item := range ports.Content {
+				var port struct {
+					ContainerPort int    `yaml:"containerPort"`
+					HostPort      int    `yaml:"hostPort"`
+					HostIP        string `yaml:"hostIP"`
+					Protocol      string `yaml:"protocol"`
+				}
+				if err := item.Decode(&port); err != nil {
+					return nil, fmt.Errorf("line %d: %s: %w", item.Line, name, err)
+				}
+				if port.HostPort == 0 {
+					continue
+				}
+				if port.HostPort < 1 || port.HostPort > maxPort {
+					return nil, fmt.Errorf("line %d: %s: invalid hostPort %d", item.Line, name, port.HostPort)
+				}
+				result = append(result, ComposePort{
+					PortMapping: PortMapping{
+						PublicPort:  uint16(port.HostPort),
+						PrivatePort: uint16(port.ContainerPort),
+						Type:        k8sProtocol(port.Protocol),
+						IP:          port.HostIP,
+					},
+					Service:     name,
+					Line:        item.Line,
+					Mode:        "host",
+					Constraints: constraints,
+				})
+			}
+		}
+	}
+	return result, nil
+}
+
+// nodePorts reads the nodePort of each port of a NodePort or LoadBalancer
+// service. Those without one get a random port from the cluster's range,
+// which can't be checked ahead.
+func nodePorts(name string, spec *yaml.Node) ([]ComposePort, error) {
+	if t := scalarValue(spec, "type"); t != "NodePort" && t != "LoadBalancer" {
+		return nil, nil
+	}
+	ports := mappingValue(spec, "ports")
+	if ports == nil || ports.Kind != yaml.SequenceNode {
+		return nil, nil
+	}
+	var result []ComposePort
+	for _, item := range ports.Content {
+		var port struct {
+			NodePort int    `yaml:"nodePort"`
+			Port     int    `yaml:"port"`
+			Protocol string `yaml:"protocol"`
+		}
+		if err := item.Decode(&port); err != nil {
+			return nil, fmt.Errorf("line %d: %s: %w", item.Line, name, err)
+		}
+		if port.NodePort == 0 {
+			continue
+		}
+		if port.NodePort < 1 || port.NodePort > maxPort {
+			return nil, fmt.Errorf("line %d: %s: invalid nodePort %d", item.Line, name, port.NodePort)
+		}
+		result = append(result, ComposePort{
+			PortMapping: PortMapping{PublicPort: uint16(port.NodePort), PrivatePort: uint16(port.Port), Type: k8sProtocol(port.Protocol)},
+			Service:     name,
+			Line:        item.Line,
+			Mode:        "ingress",
+		})
+	}
+	return result, nil
+}
+
+func k8sProtocol(p string) string {
+	if p == "" {
+		return "tcp"
+	}
+	return strings.ToLower(p)
+}
+
+func scalarValue(node *yaml.Node, key string) string {
+	if v := mappingValue(node, key); v != nil && v.Kind == yaml.ScalarNode {
+		return v.Value
+	}
+	return ""
+}
+
+// maxManifestSize caps the manifests /api/analyze/k8s reads, which are
+// decoded whole
+const maxManifestSize = 4 << 20
+
+// handleAnalyzeK8s checks Kubernetes manifests, sent as the YAML or JSON
+// body, against each known host's ports. ignore_container and
+// ignore_project work as with /api/simulate.
+func (s *Server) handleAnalyzeK8s(w http.ResponseWriter, r *http.Request) {
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxManifestSize))
+	if err != nil || len(data) == 0 {
+		writeError(w, http.StatusBadRequest, "invalid_body", "Expected Kubernetes manifests as the body")
+		return
+	}
+	ports, err := ParseManifests(data)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_manifest", localize(w, "Cannot parse the manifests: %v", err))
+		return
+	}
+
+	usage, err := s.usageFromRequest(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_param", err.Error())
+		return
+	}
+	snap, err := s.loadSnapshot(r.Context())
+	if err != nil {
+		status, code, msg := classifyDockerError(err)
+		writeError(w, status, code, msg)
+		return
+	}
+	live := slices.DeleteFunc(slices.Clone(snap.Containers), func(c ContainerData) bool { return !usage.holds(c) })
+	var hosts []string
+	for _, h := range s.hosts(environmentFrom(r.Context())) {
+		hosts = append(hosts, h.Name)
+	}
+
+	resp := K8sAnalysis{Ports: ports, Conflicts: FindStackConflicts(ports, live, hosts), Meta: s.snapshotMeta(snap)}
+	if resp.Ports == nil {
+		resp.Ports = []ComposePort{}
+	}
+	if resp.Conflicts == nil {
+		resp.Conflicts = []ComposeConflict{}
+	}
+	resp.OK = len(resp.Conflicts) == 0
+	s.writeSnapshotHeaders(w, snap)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
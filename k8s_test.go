@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const manifests = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: ingress
+spec:
+  template:
+    spec:
+      nodeSelector:
+        kubernetes.io/hostname: lb1
+      containers:
+        - name: nginx
+          ports:
+            - containerPort: 80
+              hostPort: 80
+            - containerPort: 9113
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: grafana
+spec:
+  type: NodePort
+  ports:
+    - port: 3000
+      nodePort: 30300
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: settings
+`
+
+func TestParseManifests(t *testing.T) {
+	ports, err := ParseManifests([]byte(manifests))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(ports) != 2 {
+		t.Fatalf("Expected the hostPort and the nodePort, got %+v", ports)
+	}
+	if p := ports[0]; p.Service != "Deployment/ingress" || p.Mode != "host" || p.Line != 13 || len(p.Constraints) != 1 || p.Constraints[0] != "node.hostname == lb1" {
+		t.Errorf("Unexpected hostPort: %+v", p)
+	}
+	if p := ports[1]; p.Service != "Service/grafana" || p.PublicPort != 30300 || p.Mode != "ingress" || p.Line != 24 {
+		t.Errorf("Unexpected nodePort: %+v", p)
+	}
+
+	list := `{"kind":"List","items":[{"kind":"Pod","metadata":{"name":"dns"},"spec":{"containers":[{"ports":[{"containerPort":53,"hostPort":53,"protocol":"UDP"}]}]}}]}`
+	if ports, err := ParseManifests([]byte(list)); err != nil || len(ports) != 1 || ports[0].Type != "udp" {
+		t.Errorf("Expected the pod of the list, got %+v %v", ports, err)
+	}
+	if _, err := ParseManifests([]byte("kind: Pod\nspec: {containers: [{ports: [{hostPort: 70000}]}]}\n")); err == nil {
+		t.Error("Expected an out of range hostPort to fail")
+	}
+}
+
+func TestHandleAnalyzeK8s(t *testing.T) {
+	// 8000 is taken locally, 8001 on lb1 and 8002 on lb2
+	server := newMultiHostServer()
+	body := `kind: DaemonSet
+metadata: {name: edge}
+spec:
+  template:
+    spec:
+      nodeSelector: {kubernetes.io/hostname: lb1}
+      containers: [{ports: [{containerPort: 80, hostPort: 8000}]}]
+---
+kind: Service
+metadata: {name: api}
+spec:
+  type: NodePort
+  ports: [{port: 80, nodePort: 8002}]
+`
+
+	w := httptest.NewRecorder()
+	server.handleAnalyzeK8s(w, httptest.NewRequest("POST", "/api/analyze/k8s", strings.NewReader(body)))
+	var resp K8sAnalysis
+	json.NewDecoder(w.Body).Decode(&resp)
+	if w.Code != http.StatusOK || resp.OK || len(resp.Ports) != 2 || len(resp.Conflicts) != 1 {
+		t.Fatalf("Expected only the nodePort to conflict, got %d %+v", w.Code, resp)
+	}
+	if c := resp.Conflicts[0]; c.Port.Service != "Service/api" || len(c.Hosts) != 1 || c.Hosts[0] != "lb2" {
+		t.Errorf("Expected the conflict on lb2, got %+v", c)
+	}
+
+	w = httptest.NewRecorder()
+	server.handleAnalyzeK8s(w, httptest.NewRequest("POST", "/api/analyze/k8s", strings.NewReader("kind: [")))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for broken YAML, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	big := strings.Repeat("# padding\n", maxManifestSize/10+1)
+	server.handleAnalyzeK8s(w, httptest.NewRequest("POST", "/api/analyze/k8s", strings.NewReader(big)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for manifests over the size limit, got %d", w.Code)
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"quaycheck/internal/storage"
+)
+
+// LeaderElector holds a lease in the shared store so that, of several
+// replicas, only one runs the background jobs: schedulers, notifiers and
+// the Docker event loop. Every replica keeps serving requests.
+type LeaderElector struct {
+	lease leaseBackend
+	// ID names this replica in the lease
+	ID string
+	// TTL is how long a lease lasts without renewal; it is renewed every
+	// TTL/3, so a dead leader is replaced within TTL
+	TTL time.Duration
+
+	leader atomic.Bool
+}
+
+// leaseBackend keeps the lease where every replica sees it
+type leaseBackend interface {
+	// acquire takes the lease for id until ttl after now if it's free or
+	// expired, or renews it if id holds it, in one atomic step so two
+	// replicas can't both win
+	acquire(ctx context.Context, id string, ttl time.Duration, now time.Time) (bool, error)
+	// release gives the lease up if id holds it
+	release(ctx context.Context, id string) error
+}
+
+// leaderElectorFromEnv holds the lease in Redis when replicas share state
+// through it, since their databases are then their own, and in the
+// database otherwise
+func leaderElectorFromEnv(db *storage.DB, shared *redisReservations) *LeaderElector {
+	if os.Getenv("QUAYCHECK_LEADER_ELECTION") != "true" {
+		return nil
+	}
+	var lease leaseBackend
+	switch {
+	case shared != nil:
+		lease = &redisLease{redis: shared, key: shared.key + ":leader"}
+	case db != nil:
+		lease = &sqliteLease{db: db}
+	default:
+		return nil
+	}
+	e := &LeaderElector{lease: lease, ID: os.Getenv("QUAYCHECK_INSTANCE_ID"), TTL: 15 * time.Second}
+	if e.ID == "" {
+		host, _ := os.Hostname()
+		e.ID = fmt.Sprintf("%s-%d", host, os.Getpid())
+	}
+	if v := os.Getenv("QUAYCHECK_LEADER_LEASE"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d >= 3*time.Second {
+			e.TTL = d
+		} else {
+			log.Printf("Ignoring QUAYCHECK_LEADER_LEASE=%q, expected a duration of at least 3s", v)
+		}
+	}
+	return e
+}
+
+// IsLeader reports whether this replica held the lease at its last renewal
+func (e *LeaderElector) IsLeader() bool {
+	return e.leader.Load()
+}
+
+// tryAcquire takes the lease if it's free or expired, or renews it if
+// it's ours
+func (e *LeaderElector) tryAcquire(ctx context.Context, now time.Time) (bool, error) {
+	return e.lease.acquire(ctx, e.ID, e.TTL, now)
+}
+
+// release gives the lease up so another replica takes over at once
+func (e *LeaderElector) release() {
+	if err := e.lease.release(context.Background(), e.ID); err != nil {
+		log.Printf("Cannot release leadership: %v", err)
+	}
+}
+
+// sqliteLease keeps the lease in the leader_lease table, for replicas
+// sharing a database
+type sqliteLease struct {
+	db *storage.DB
+}
+
+func (l *sqliteLease) acquire(ctx context.Context, id string, ttl time.Duration, now time.Time) (bool, error) {
+	res, err := l.db.ExecContext(ctx, `INSERT INTO leader_lease (name, holder, expires_at) VALUES ('leader', ?, ?)
+		ON CONFLICT (name) DO UPDATE SET holder = excluded.holder, expires_at = excluded.expires_at
+		WHERE leader_lease.holder = excluded.holder OR leader_lease.expires_at < ?`,
+		id, now.Add(ttl).UnixNano(), now.UnixNano())
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+func (l *sqliteLease) release(ctx context.Context, id string) error {
+	_, err := l.db.ExecContext(ctx, `DELETE FROM leader_lease WHERE name = 'leader' AND holder = ?`, id)
+	return err
+}
+
+// redisAcquireLease takes KEYS[1] for ARGV[1] with SET NX PX, or renews it
+// if ARGV[1] already holds it. Expiry runs on the Redis clock.
+const redisAcquireLease = `if redis.call('SET', KEYS[1], ARGV[1], 'NX', 'PX', ARGV[2]) then return 1 end
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+  redis.call('PEXPIRE', KEYS[1], ARGV[2])
+  return 1
+end
+return 0`
+
+// redisReleaseLease deletes KEYS[1] only while ARGV[1] holds it
+const redisReleaseLease = `if redis.call('GET', KEYS[1]) == ARGV[1] then return redis.call('DEL', KEYS[1]) end
+return 0`
+
+// redisLease keeps the lease under key in the Redis that replicas share
+// reservations through
+type redisLease struct {
+	redis *redisReservations
+	key   string
+}
+
+func (l *redisLease) acquire(ctx context.Context, id string, ttl time.Duration, now time.Time) (bool, error) {
+	replies, err := l.redis.do(ctx, []string{"EVAL", redisAcquireLease, "1", l.key, id, strconv.FormatInt(ttl.Milliseconds(), 10)})
+	if err != nil {
+		return false, err
+	}
+	won, ok := replies[0].(int64)
+	if !ok {
+		return false, fmt.Errorf("redis: unexpected EVAL reply %v", replies[0])
+	}
+	return won == 1, nil
+}
+
+func (l *redisLease) release(ctx context.Context, id string) error {
+	_, err := l.redis.do(ctx, []string{"EVAL", redisReleaseLease, "1", l.key, id})
+	return err
+}
+
+// Run keeps trying to acquire or renew the lease until ctx is done. A
+// failed renewal steps down right away rather than risk two leaders.
+func (e *LeaderElector) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.TTL / 3)
+	defer ticker.Stop()
+	for {
+		ok, err := e.tryAcquire(ctx, time.Now())
+		if err != nil {
+			log.Printf("Leader election: %v", err)
+		}
+		if was := e.leader.Swap(ok); was != ok {
+			if ok {
+				log.Printf("Replica %s is now the leader", e.ID)
+			} else {
+				log.Printf("Replica %s is no longer the leader", e.ID)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			if e.leader.Swap(false) {
+				e.release()
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// isLeader is true on a single instance, where there's nobody to elect
+func (s *Server) isLeader() bool {
+	return s.leader == nil || s.leader.IsLeader()
+}
+
+// runAsLeader runs job only while this replica leads: it starts it on
+// winning the lease and cancels its context on losing it. Without leader
+// election it simply runs job.
+func (s *Server) runAsLeader(ctx context.Context, job func(context.Context)) {
+	if s.leader == nil {
+		job(ctx)
+		return
+	}
+	poll := time.NewTicker(time.Second)
+	defer poll.Stop()
+	for {
+		for !s.leader.IsLeader() {
+			select {
+			case <-ctx.Done():
+				return
+			case <-poll.C:
+			}
+		}
+		jobCtx, cancel := context.WithCancel(ctx)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			job(jobCtx)
+		}()
+		for s.leader.IsLeader() && ctx.Err() == nil {
+			select {
+			case <-ctx.Done():
+			case <-poll.C:
+			case <-done:
+				// The job ended on its own (nothing configured); don't restart it
+				cancel()
+				<-ctx.Done()
+				return
+			}
+		}
+		cancel()
+		<-done
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLeaderLease(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	a := &LeaderElector{lease: &sqliteLease{db: db}, ID: "a", TTL: 10 * time.Second}
+	b := &LeaderElector{lease: &sqliteLease{db: db}, ID: "b", TTL: 10 * time.Second}
+	now := time.Now()
+
+	if ok, err := a.tryAcquire(ctx, now); !ok || err != nil {
+		t.Fatalf("Expected a to take the free lease, got %v, %v", ok, err)
+	}
+	if ok, _ := b.tryAcquire(ctx, now); ok {
+		t.Fatal("Expected b to be refused while a holds the lease")
+	}
+	if ok, _ := a.tryAcquire(ctx, now.Add(5*time.Second)); !ok {
+		t.Fatal("Expected a to renew its own lease")
+	}
+	if ok, _ := b.tryAcquire(ctx, now.Add(12*time.Second)); ok {
+		t.Fatal("Expected the renewal to push the expiry back")
+	}
+	if ok, _ := b.tryAcquire(ctx, now.Add(16*time.Second)); !ok {
+		t.Fatal("Expected b to take over once a's lease expired")
+	}
+	if ok, _ := a.tryAcquire(ctx, now.Add(16*time.Second)); ok {
+		t.Fatal("Expected a to have lost the lease")
+	}
+
+	b.release()
+	if ok, _ := a.tryAcquire(ctx, now.Add(17*time.Second)); !ok {
+		t.Fatal("Expected a released lease to be free at once")
+	}
+}
+
+func TestRedisLeaderLease(t *testing.T) {
+	f := startFakeRedis(t, "")
+	backend, _ := parseRedisURL("redis://"+f.addr, "quaycheck:reservations")
+	t.Setenv("QUAYCHECK_LEADER_ELECTION", "true")
+	// Replicas sharing Redis keep their own databases, so the lease must
+	// live in Redis
+	a := leaderElectorFromEnv(openTestDB(t), backend)
+	b := leaderElectorFromEnv(openTestDB(t), backend)
+	a.ID, b.ID, a.TTL, b.TTL = "a", "b", 100*time.Millisecond, 100*time.Millisecond
+	ctx := context.Background()
+
+	if ok, err := a.tryAcquire(ctx, time.Now()); !ok || err != nil {
+		t.Fatalf("Expected a to take the free lease, got %v, %v", ok, err)
+	}
+	if ok, _ := b.tryAcquire(ctx, time.Now()); ok {
+		t.Fatal("Expected b to be refused while a holds the lease")
+	}
+	if ok, _ := a.tryAcquire(ctx, time.Now()); !ok {
+		t.Fatal("Expected a to renew its own lease")
+	}
+	time.Sleep(150 * time.Millisecond)
+	if ok, _ := b.tryAcquire(ctx, time.Now()); !ok {
+		t.Fatal("Expected b to take over once a's lease expired")
+	}
+
+	a.release()
+	if ok, _ := a.tryAcquire(ctx, time.Now()); ok {
+		t.Fatal("Expected a's release to leave b's lease alone")
+	}
+	b.release()
+	if ok, _ := a.tryAcquire(ctx, time.Now()); !ok {
+		t.Fatal("Expected a released lease to be free at once")
+	}
+}
+
+func TestLeaderRunReleasesOnStop(t *testing.T) {
+	db := openTestDB(t)
+	a := &LeaderElector{lease: &sqliteLease{db: db}, ID: "a", TTL: time.Minute}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		a.Run(ctx)
+		close(done)
+	}()
+	for deadline := time.Now().Add(time.Second); !a.IsLeader(); {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected a to become leader")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	<-done
+	if a.IsLeader() {
+		t.Error("Expected a to step down when stopped")
+	}
+	b := &LeaderElector{lease: &sqliteLease{db: db}, ID: "b", TTL: time.Minute}
+	if ok, _ := b.tryAcquire(context.Background(), time.Now()); !ok {
+		t.Error("Expected the lease to be released on stop")
+	}
+}
+
+func TestRunAsLeaderFollowsLease(t *testing.T) {
+	e := &LeaderElector{ID: "a"}
+	s := &Server{leader: e}
+	if s.isLeader() {
+		t.Fatal("Expected a follower before the lease is won")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	started := make(chan struct{}, 2)
+	stopped := make(chan struct{}, 2)
+	go s.runAsLeader(ctx, func(ctx context.Context) {
+		started <- struct{}{}
+		<-ctx.Done()
+		stopped <- struct{}{}
+	})
+
+	select {
+	case <-started:
+		t.Fatal("Expected the job to wait for leadership")
+	case <-time.After(50 * time.Millisecond):
+	}
+	e.leader.Store(true)
+	select {
+	case <-started:
+	case <-time.After(3 * time.Second):
+		t.Fatal("Expected the job to start on winning the lease")
+	}
+	e.leader.Store(false)
+	select {
+	case <-stopped:
+	case <-time.After(3 * time.Second):
+		t.Fatal("Expected the job to stop on losing the lease")
+	}
+
+	if !(&Server{}).isLeader() {
+		t.Error("Expected a single instance to always lead")
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// runVirsh is swapped out in tests
+var runVirsh = func(ctx context.Context, args ...string) ([]byte, error) {
+	return exec.CommandContext(ctx, "virsh", args...).Output()
+}
+
+// LibvirtSource lists VM port forwards through virsh: user-mode networking
+// hostfwd rules, passt portForward elements, and ports declared in the domain
+// metadata for bridged VMs whose services quaycheck cannot otherwise see.
+type LibvirtSource struct {
+	URI string
+}
+
+type libvirtDomain struct {
+	Name       string `xml:"name"`
+	UUID       string `xml:"uuid"`
+	Interfaces []struct {
+		PortForwards []struct {
+			Proto   string `xml:"proto,attr"`
+			Address string `xml:"address,attr"`
+			Ranges  []struct {
+				Start string `xml:"start,attr"`
+				End   string `xml:"end,attr"`
+				To    string `xml:"to,attr"`
+			} `xml:"range"`
+		} `xml:"portForward"`
+	} `xml:"devices>interface"`
+	QemuArgs []struct {
+		Value string `xml:"value,attr"`
+	} `xml:"commandline>arg"`
+	MetadataPorts []struct {
+		Public  uint16 `xml:"public,attr"`
+		Private uint16 `xml:"private,attr"`
+		Proto   string `xml:"proto,attr"`
+		IP      string `xml:"ip,attr"`
+	} `xml:"metadata>ports>port"`
+}
+
+func (s *LibvirtSource) Name() string { return "libvirt" }
+
+func (s *LibvirtSource) virsh(ctx context.Context, args ...string) ([]byte, error) {
+	if s.URI != "" {
+		args = append([]string{"-c", s.URI}, args...)
+	}
+	return runVirsh(ctx, args...)
+}
+
+func (s *LibvirtSource) Containers(ctx context.Context) ([]ContainerData, error) {
+	all, err := s.virsh(ctx, "list", "--all", "--name")
+	if err != nil {
+		return nil, fmt.Errorf("virsh list: %w", err)
+	}
+	running, err := s.virsh(ctx, "list", "--state-running", "--name")
+	if err != nil {
+		return nil, fmt.Errorf("virsh list: %w", err)
+	}
+	isRunning := make(map[string]bool)
+	for _, name := range strings.Fields(string(running)) {
+		isRunning[name] = true
+	}
+
+	var result []ContainerData
+	for _, name := range strings.Fields(string(all)) {
+		dump, err := s.virsh(ctx, "dumpxml", name)
+		if err != nil {
+			return nil, fmt.Errorf("virsh dumpxml %s: %w", name, err)
+		}
+		var dom libvirtDomain
+		if err := xml.Unmarshal(dump, &dom); err != nil {
+			return nil, fmt.Errorf("parse domain %s: %w", name, err)
+		}
+
+		state := "shut off"
+		if isRunning[name] {
+			state = "running"
+		}
+		result = append(result, ContainerData{
+			ID:     dom.UUID,
+			Names:  []string{dom.Name},
+			Image:  "vm",
+			State:  state,
+			Ports:  domainPorts(dom),
+			Source: s.Name(),
+		})
+	}
+	return result, nil
+}
+
+func domainPorts(dom libvirtDomain) []PortMapping {
+	var ports []PortMapping
+
+	for _, iface := range dom.Interfaces {
+		for _, pf := range iface.PortForwards {
+			for _, r := range pf.Ranges {
+				start, err := strconv.Atoi(r.Start)
+				if err != nil {
+					continue
+				}
+				end := start
+				if e, err := strconv.Atoi(r.End); err == nil && e >= start {
+					end = e
+				}
+				offset := 0
+				if to, err := strconv.Atoi(r.To); err == nil {
+					offset = to - start
+				}
+				for p := start; p <= end; p++ {
+					ports = append(ports, PortMapping{
+						PublicPort:  uint16(p),
+						PrivatePort: uint16(p + offset),
+						Type:        pf.Proto,
+						IP:          pf.Address,
+					})
+				}
+			}
+		}
+	}
+
+	for _, arg := range dom.QemuArgs {
+		ports = append(ports, parseHostfwd(arg.Value)...)
+	}
+
+	for _, mp := range dom.MetadataPorts {
+		proto := mp.Proto
+		if proto == "" {
+			proto = "tcp"
+		}
+		private := mp.Private
+		if private == 0 {
+			private = mp.Public
+		}
+		ports = append(ports, PortMapping{PublicPort: mp.Public, PrivatePort: private, Type: proto, IP: mp.IP})
+	}
+
+	return ports
+}
+
+// parseHostfwd extracts hostfwd=[tcp|udp]:[hostaddr]:hostport-[guestaddr]:guestport
+// rules from a QEMU -netdev user argument
+func parseHostfwd(arg string) []PortMapping {
+	var ports []PortMapping
+	for _, opt := range strings.Split(arg, ",") {
+		rule, ok := strings.CutPrefix(opt, "hostfwd=")
+		if !ok {
+			continue
+		}
+		host, guest, ok := strings.Cut(rule, "-")
+		if !ok {
+			continue
+		}
+		hostParts := strings.Split(host, ":")
+		if len(hostParts) != 3 {
+			continue
+		}
+		proto := hostParts[0]
+		if proto == "" {
+			proto = "tcp"
+		}
+		hostPort, err := strconv.Atoi(hostParts[2])
+		if err != nil {
+			continue
+		}
+		guestPort, err := strconv.Atoi(guest[strings.LastIndex(guest, ":")+1:])
+		if err != nil {
+			continue
+		}
+		ports = append(ports, PortMapping{
+			PublicPort:  uint16(hostPort),
+			PrivatePort: uint16(guestPort),
+			Type:        proto,
+			IP:          hostParts[1],
+		})
+	}
+	return ports
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+const domainXML = `<domain type='kvm' xmlns:qemu='http://libvirt.org/schemas/domain/qemu/1.0'>
+  <name>homeassistant</name>
+  <uuid>6a1f0c0e-0000-4000-8000-000000000001</uuid>
+  <metadata>
+    <quaycheck:ports xmlns:quaycheck="https://github.com/fabienpiette/quaycheck">
+      <quaycheck:port public="8123"/>
+    </quaycheck:ports>
+  </metadata>
+  <devices>
+    <interface type='user'>
+      <portForward proto='tcp'>
+        <range start='2222' to='22'/>
+        <range start='5900' end='5901'/>
+      </portForward>
+    </interface>
+  </devices>
+  <qemu:commandline>
+    <qemu:arg value='-netdev'/>
+    <qemu:arg value='user,id=net1,hostfwd=tcp:127.0.0.1:8443-:443,hostfwd=udp::5353-:53'/>
+  </qemu:commandline>
+</domain>`
+
+func TestLibvirtSource(t *testing.T) {
+	orig := runVirsh
+	defer func() { runVirsh = orig }()
+	runVirsh = func(ctx context.Context, args ...string) ([]byte, error) {
+		switch strings.Join(args, " ") {
+		case "-c qemu:///system list --all --name":
+			return []byte("homeassistant\n\n"), nil
+		case "-c qemu:///system list --state-running --name":
+			return []byte("homeassistant\n"), nil
+		case "-c qemu:///system dumpxml homeassistant":
+			return []byte(domainXML), nil
+		}
+		return nil, errors.New("unexpected virsh call: " + strings.Join(args, " "))
+	}
+
+	src := &LibvirtSource{URI: "qemu:///system"}
+	vms, err := src.Containers(context.Background())
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(vms) != 1 || vms[0].State != "running" || vms[0].Names[0] != "homeassistant" {
+		t.Fatalf("Unexpected VMs: %+v", vms)
+	}
+
+	want := map[uint16]uint16{2222: 22, 5900: 5900, 5901: 5901, 8443: 443, 5353: 53, 8123: 8123}
+	if len(vms[0].Ports) != len(want) {
+		t.Fatalf("Expected %d ports, got %+v", len(want), vms[0].Ports)
+	}
+	for _, p := range vms[0].Ports {
+		if want[p.PublicPort] != p.PrivatePort {
+			t.Errorf("Port %d: expected private %d, got %d", p.PublicPort, want[p.PublicPort], p.PrivatePort)
+		}
+	}
+}
+
+func TestParseHostfwd(t *testing.T) {
+	ports := parseHostfwd("user,id=n0,hostfwd=::2222-:22,hostfwd=bogus")
+	if len(ports) != 1 || ports[0].PublicPort != 2222 || ports[0].Type != "tcp" {
+		t.Errorf("Unexpected ports: %+v", ports)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+// dockerLifecycleClient is implemented by the real Docker client. Container
+// actions also need a socket that accepts writes, which the read-only
+// socket proxy this project recommends refuses.
+type dockerLifecycleClient interface {
+	ContainerStop(ctx context.Context, containerID string, options container.StopOptions) error
+	ContainerRestart(ctx context.Context, containerID string, options container.StopOptions) error
+}
+
+// containerActionsFromEnv reads QUAYCHECK_CONTAINER_ACTIONS; actions are off
+// unless it's set to true
+func containerActionsFromEnv() bool {
+	on, _ := strconv.ParseBool(os.Getenv("QUAYCHECK_CONTAINER_ACTIONS"))
+	return on
+}
+
+type ContainerActionResponse struct {
+	Port   int    `json:"port"`
+	Action string `json:"action"`
+	// Containers names every container the action was applied to
+	Containers []string `json:"containers"`
+	Code       string   `json:"code"`
+	Message    string   `json:"message"`
+}
+
+// classifyActionError adds what only a write can run into to
+// classifyDockerError: a proxy refusing it, or the container being gone
+func classifyActionError(err error) (int, string, string) {
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, "Forbidden"):
+		return http.StatusForbidden, "docker_read_only", "The Docker socket is read-only; container actions need one that allows POST on containers"
+	case strings.Contains(msg, "No such container"):
+		return http.StatusNotFound, "not_found", "The container no longer exists"
+	}
+	return classifyDockerError(err)
+}
+
+// handleContainerAction stops or restarts the Docker containers publishing
+// a port, so a conflict spotted in the UI can be resolved there. ?timeout=
+// is how many seconds a container gets to stop before it's killed; the
+// container's own default otherwise.
+func (s *Server) handleContainerAction(action string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		port, err := strconv.Atoi(r.PathValue("port"))
+		if err != nil || port < 1 || port > maxPort {
+			writeError(w, http.StatusBadRequest, "invalid_param", "Invalid port parameter")
+			return
+		}
+		var opts container.StopOptions
+		if v := r.URL.Query().Get("timeout"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				writeError(w, http.StatusBadRequest, "invalid_param", "Invalid timeout parameter")
+				return
+			}
+			opts.Timeout = &n
+		}
+		lc, ok := s.client.(dockerLifecycleClient)
+		if !ok {
+			writeError(w, http.StatusNotImplemented, "actions_unsupported", "This Docker client cannot stop or restart containers")
+			return
+		}
+
+		snap, err := s.loadSnapshot(r.Context())
+		if err != nil {
+			status, code, msg := classifyDockerError(err)
+			writeError(w, status, code, msg)
+			return
+		}
+		var ids, names []string
+		var other *ContainerData
+		for _, c := range snap.Containers {
+			if !occupiesPorts(c.State) || !slices.ContainsFunc(c.Ports, func(p PortMapping) bool { return int(p.PublicPort) == port }) {
+				continue
+			}
+			if c.Source != "" {
+				other = &c
+				continue
+			}
+			if !slices.Contains(ids, c.ID) {
+				ids = append(ids, c.ID)
+				names = append(names, containerName(c))
+			}
+		}
+		switch {
+		case len(ids) == 0 && other != nil:
+			writeError(w, http.StatusConflict, "not_a_container", localize(w, "Port %d is held by %s, which is not a Docker container", port, containerName(*other)))
+			return
+		case len(ids) == 0:
+			writeError(w, http.StatusNotFound, "not_found", localize(w, "No container publishes port %d", port))
+			return
+		}
+
+		for i, id := range ids {
+			if action == "stop" {
+				err = lc.ContainerStop(r.Context(), id, opts)
+			} else {
+				err = lc.ContainerRestart(r.Context(), id, opts)
+			}
+			if err != nil {
+				status, code, msg := classifyActionError(err)
+				writeError(w, status, code, msg)
+				return
+			}
+			s.audit(r, "container."+action, names[i], strconv.Itoa(port))
+		}
+		s.resync(r.Context())
+
+		code, msg := "container_stopped", localize(w, "Stopped %s", strings.Join(names, ", "))
+		if action == "restart" {
+			code, msg = "container_restarted", localize(w, "Restarted %s", strings.Join(names, ", "))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ContainerActionResponse{Port: port, Action: action, Containers: names, Code: code, Message: msg})
+	}
+}
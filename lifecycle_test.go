@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+)
+
+// lifecycleDockerClient records the containers it's asked to stop or
+// restart, failing with err when set
+type lifecycleDockerClient struct {
+	MockDockerClient
+	calls []string
+	err   error
+}
+
+func (c *lifecycleDockerClient) ContainerStop(ctx context.Context, id string, options container.StopOptions) error {
+	c.calls = append(c.calls, "stop "+id)
+	return c.err
+}
+
+func (c *lifecycleDockerClient) ContainerRestart(ctx context.Context, id string, options container.StopOptions) error {
+	c.calls = append(c.calls, "restart "+id)
+	return c.err
+}
+
+func newLifecycleServer(client DockerClient) *Server {
+	store, _ := NewReservationStore(nil)
+	store.Ensure(Reservation{Name: "grafana", Port: 3000}, nil, 0, false)
+	return &Server{
+		client:           client,
+		reservations:     store,
+		containerActions: true,
+		tokens: []APIToken{
+			{Name: "ops", Secret: "ops", Scopes: []Scope{ScopeContainers}},
+			{Name: "ci", Secret: "ci", Scopes: []Scope{ScopeReserve}},
+		},
+	}
+}
+
+func postAction(mux http.Handler, path, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	return w
+}
+
+func TestContainerActions(t *testing.T) {
+	client := &lifecycleDockerClient{MockDockerClient: MockDockerClient{Containers: []types.Container{
+		{ID: "abc", Names: []string{"/web"}, State: "running", Ports: []types.Port{{PublicPort: 8080, PrivatePort: 80, IP: "0.0.0.0"}, {PublicPort: 8080, PrivatePort: 80, IP: "::"}}},
+	}}}
+	mux := SetupRouter(newLifecycleServer(client))
+
+	w := postAction(mux, "/api/ports/8080/restart?timeout=5", "ops")
+	var resp ContainerActionResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	if w.Code != http.StatusOK || resp.Code != "container_restarted" || len(resp.Containers) != 1 || resp.Containers[0] != "web" {
+		t.Fatalf("Expected web to be restarted, got %d %+v", w.Code, resp)
+	}
+	if len(client.calls) != 1 || client.calls[0] != "restart abc" {
+		t.Errorf("Expected one restart of abc, got %v", client.calls)
+	}
+
+	for path, want := range map[string]int{
+		"/api/ports/3000/stop":           http.StatusConflict,
+		"/api/ports/9999/stop":           http.StatusNotFound,
+		"/api/ports/0/stop":              http.StatusBadRequest,
+		"/api/ports/8080/stop?timeout=x": http.StatusBadRequest,
+	} {
+		if w := postAction(mux, path, "ops"); w.Code != want {
+			t.Errorf("%s: expected %d, got %d", path, want, w.Code)
+		}
+	}
+
+	// Tokens without the scope, or none at all, get nothing done
+	if w := postAction(mux, "/api/ports/8080/stop", "ci"); w.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 without the containers scope, got %d", w.Code)
+	}
+	if w := postAction(mux, "/api/ports/8080/stop", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without a token, got %d", w.Code)
+	}
+	if len(client.calls) != 1 {
+		t.Errorf("Expected no further actions, got %v", client.calls)
+	}
+}
+
+func TestContainerActionsNeedAWritableSocket(t *testing.T) {
+	client := &lifecycleDockerClient{
+		MockDockerClient: MockDockerClient{Containers: []types.Container{{ID: "abc", State: "running", Ports: []types.Port{{PublicPort: 8080}}}}},
+		err:              errors.New("Error response from daemon: <html><body><h1>403 Forbidden</h1></body></html>"),
+	}
+	w := postAction(SetupRouter(newLifecycleServer(client)), "/api/ports/8080/stop", "ops")
+	var resp ErrorResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	if w.Code != http.StatusForbidden || resp.Code != "docker_read_only" {
+		t.Errorf("Expected docker_read_only, got %d %+v", w.Code, resp)
+	}
+}
+
+func TestContainerActionsOffByDefault(t *testing.T) {
+	server := newLifecycleServer(&lifecycleDockerClient{})
+	server.containerActions = false
+	if w := postAction(SetupRouter(server), "/api/ports/8080/stop", "ops"); w.Code == http.StatusOK {
+		t.Errorf("Expected no stop endpoint unless enabled, got %d", w.Code)
+	}
+}
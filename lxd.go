@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"quaycheck/internal/portspec"
+)
+
+// LXDSource reads proxy devices from the LXD API. Proxy devices are how LXD
+// publishes instance ports on the host, so they compete with Docker for them.
+type LXDSource struct {
+	BaseURL    string
+	HTTPClient *http.Client
+}
+
+// NewLXDSource talks to the LXD daemon over its unix socket
+func NewLXDSource(socket string) *LXDSource {
+	return &LXDSource{
+		BaseURL: "http://lxd",
+		HTTPClient: &http.Client{Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		}},
+	}
+}
+
+type lxdInstance struct {
+	Name            string                       `json:"name"`
+	Status          string                       `json:"status"`
+	Type            string                       `json:"type"`
+	Config          map[string]string            `json:"config"`
+	ExpandedDevices map[string]map[string]string `json:"expanded_devices"`
+}
+
+func (s *LXDSource) Name() string { return "lxd" }
+
+func (s *LXDSource) Containers(ctx context.Context) ([]ContainerData, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", s.BaseURL+"/1.0/instances?recursion=1", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("lxd: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Error    string        `json:"error"`
+		Metadata []lxdInstance `json:"metadata"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("lxd: decode instances: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("lxd: %s", body.Error)
+	}
+
+	var result []ContainerData
+	for _, inst := range body.Metadata {
+		var ports []PortMapping
+		for _, dev := range inst.ExpandedDevices {
+			if dev["type"] != "proxy" {
+				continue
+			}
+			ports = append(ports, parseLXDProxy(dev["listen"], dev["connect"])...)
+		}
+		result = append(result, ContainerData{
+			ID:     "lxd:" + inst.Name,
+			Names:  []string{inst.Name},
+			Image:  inst.Config["image.description"],
+			State:  strings.ToLower(inst.Status),
+			Ports:  ports,
+			Source: s.Name(),
+		})
+	}
+	return result, nil
+}
+
+// parseLXDProxy maps a proxy device's listen/connect addresses, e.g.
+// listen=tcp:0.0.0.0:8000-8002,9000 connect=tcp:127.0.0.1:80
+func parseLXDProxy(listen, connect string) []PortMapping {
+	proto, listenAddr, ok := strings.Cut(listen, ":")
+	if !ok || (proto != "tcp" && proto != "udp") {
+		return nil
+	}
+	idx := strings.LastIndex(listenAddr, ":")
+	if idx < 0 {
+		return nil
+	}
+	ip := strings.Trim(listenAddr[:idx], "[]")
+
+	var listenPorts []int
+	for _, part := range strings.Split(listenAddr[idx+1:], ",") {
+		lo, hi, ok := portspec.Range(part, "-")
+		if !ok {
+			return nil
+		}
+		for p := lo; p <= hi; p++ {
+			listenPorts = append(listenPorts, p)
+		}
+	}
+
+	// A single connect port receives every listen port; otherwise they pair up
+	var connectPorts []int
+	if cidx := strings.LastIndex(connect, ":"); cidx >= 0 {
+		for _, part := range strings.Split(connect[cidx+1:], ",") {
+			if lo, hi, ok := portspec.Range(part, "-"); ok {
+				for p := lo; p <= hi; p++ {
+					connectPorts = append(connectPorts, p)
+				}
+			}
+		}
+	}
+
+	var ports []PortMapping
+	for i, p := range listenPorts {
+		private := p
+		switch {
+		case len(connectPorts) == 1:
+			private = connectPorts[0]
+		case i < len(connectPorts):
+			private = connectPorts[i]
+		}
+		ports = append(ports, PortMapping{
+			PublicPort:  uint16(p),
+			PrivatePort: uint16(private),
+			Type:        proto,
+			IP:          ip,
+		})
+	}
+	return ports
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLXDSource(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/1.0/instances" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"type":"sync","status_code":200,"metadata":[{
+			"name": "proxy",
+			"status": "Running",
+			"config": {"image.description": "Ubuntu 24.04"},
+			"expanded_devices": {
+				"http": {"type": "proxy", "listen": "tcp:0.0.0.0:80", "connect": "tcp:127.0.0.1:8080"},
+				"https": {"type": "proxy", "listen": "tcp:0.0.0.0:443", "connect": "tcp:127.0.0.1:443"},
+				"root": {"type": "disk", "path": "/"}
+			}
+		}]}`))
+	}))
+	defer ts.Close()
+
+	src := &LXDSource{BaseURL: ts.URL, HTTPClient: ts.Client()}
+	instances, err := src.Containers(context.Background())
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(instances) != 1 || instances[0].State != "running" || len(instances[0].Ports) != 2 {
+		t.Fatalf("Unexpected instances: %+v", instances)
+	}
+
+	used := buildPortIndex(instances)
+	if !used.Used(80) || !used.Used(443) {
+		t.Error("Expected 80 and 443 to be used by the LXD proxy")
+	}
+}
+
+func TestParseLXDProxy(t *testing.T) {
+	ports := parseLXDProxy("udp:[::]:8000-8002", "udp:127.0.0.1:9000-9002")
+	if len(ports) != 3 || ports[2].PublicPort != 8002 || ports[2].PrivatePort != 9002 || ports[0].IP != "::" {
+		t.Errorf("Unexpected ports: %+v", ports)
+	}
+
+	if ports := parseLXDProxy("unix:/run/socket", "tcp:127.0.0.1:80"); ports != nil {
+		t.Errorf("Expected unix listeners to be ignored, got %+v", ports)
+	}
+}
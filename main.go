@@ -3,17 +3,22 @@ package main
 import (
 	"context"
 	"encoding/json"
-	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"net/netip"
 	"os"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/client"
+
+	"quaycheck/internal/storage"
 )
 
 var startTime = time.Now()
@@ -25,33 +30,163 @@ type DockerClient interface {
 
 // Server holds dependencies for the application
 type Server struct {
-	client DockerClient
-}
-
-type PortMapping struct {
-	PrivatePort uint16 `json:"private_port"`
-	PublicPort  uint16 `json:"public_port"`
-	Type        string `json:"type"`
-	IP          string `json:"ip,omitempty"`
-}
-
-type ContainerData struct {
-	ID    string        `json:"id"`
-	Names []string      `json:"names"`
-	Image string        `json:"image"`
-	State string        `json:"state"`
-	Ports []PortMapping `json:"ports"`
+	client       DockerClient
+	sources      []PortSource
+	reservations *ReservationStore
+	freezes      *FreezeStore
+	tags         *TagStore
+	history      *HistoryStore
+	auditLog     *AuditLog
+	eventLog     *EventLog
+	db           *storage.DB
+	inspect      *inspectCache
+	images       *imageTracker
+	cache        *snapshotCache
+	events       *EventHub
+	health       *HealthMonitor
+
+	// retention bounds the history, audit and traffic tables; pruned
+	// counts what it removed
+	retention map[string]RetentionPolicy
+	pruned    *pruneStats
+
+	// readOnly is set while a restore swaps the state
+	readOnly atomic.Bool
+
+	// wake makes the watcher diff immediately after a Docker event resync
+	wake chan struct{}
+
+	// presets are named suggest ranges, e.g. web → 8000-8999
+	presets map[string]PortRange
+
+	// mirrorOffsets are tried in order by suggest?internal=, default 0,8000
+	mirrorOffsets []int
+
+	// freed tracks released ports; suggest skips those released within
+	// freedCooldown unless the request sets its own cooldown
+	freed         *freedPorts
+	freedCooldown time.Duration
+
+	// countCreated makes created-but-not-started containers count as used
+	// by default on check, suggest and ranges
+	countCreated bool
+
+	// staleAfter marks data older than this as stale in response meta, 5m when zero
+	staleAfter time.Duration
+
+	// sourceTimeout bounds each source during collection, 10s when zero
+	sourceTimeout time.Duration
+
+	// widgetOrigins may fetch /api/widget cross-origin; empty allows any
+	widgetOrigins []string
+	// trustedProxies are the reverse proxies whose forwarding headers
+	// clientIP believes
+	trustedProxies []netip.Prefix
+	// frameOrigins may show the UI in an iframe besides quaycheck itself
+	frameOrigins []string
+	// accessLog records every request, when QUAYCHECK_ACCESS_LOG is set
+	accessLog *AccessLog
+
+	// watched ports get Home Assistant sensors alongside reservations
+	watched []WatchedPort
+
+	// ingest holds ports pushed by external systems; /api/ingest is only
+	// served when ingestToken is set
+	ingest      *IngestStore
+	ingestToken string
+
+	// tokens are the scoped bearer tokens; without any, only admin
+	// endpoints may be guarded, by QUAYCHECK_ADMIN_TOKEN
+	tokens []APIToken
+
+	// apiTokens are the tokens managed through /api/admin/tokens
+	apiTokens *TokenStore
+
+	// sessions log browsers in with a token; nil disables /api/session
+	sessions *sessionStore
+
+	// settings override presets, watched, mirrorOffsets, freedCooldown and
+	// countCreated at runtime; read them through config()
+	settings *SettingsStore
+
+	// leader is set when replicas share the database; only the one holding
+	// the lease runs the background jobs. nil means this instance leads.
+	leader *LeaderElector
+
+	// environments group hosts under labels; ?env= scopes a request to one
+	environments Environments
+
+	// containerActions serves the stop and restart endpoints; off by default
+	// since they need a Docker socket that accepts writes
+	containerActions bool
+
+	// reassignments reclaim reserved ports from squatting containers,
+	// mailing their owners through smtp when it's set
+	reassignments *ReassignStore
+	smtp          *SMTPConfig
+
+	// composeRoot is prepended to the compose file paths containers are
+	// labelled with, for when the host's filesystem is mounted elsewhere
+	composeRoot string
+
+	// proxyConfigs are the reverse proxy configs /api/validate/upstreams
+	// reads, as paths or globs
+	proxyConfigs []string
+
+	// dns names reserved services for the DNS exports
+	dns DNSConfig
+	// dnsUpdater publishes those names on a DNS server, if set
+	dnsUpdater DNSUpdater
+	// mdns advertises the local containers that opted in, if set
+	mdns *MDNSResponder
+	// reachability checks published ports from outside, if set;
+	// serveReach answers such checks for another instance
+	reachability *ReachabilityProbe
+	serveReach   bool
+	// attempts reads who is connecting to exposed ports, if set
+	attempts *AttemptTracker
+	// traffic keeps sampled rule counters of published ports, if
+	// sampling is on
+	traffic *TrafficStore
+	// idleDays is how long a port must take no traffic to be recommended
+	// for cleanup
+	idleDays int
+
+	// flight coalesces concurrent snapshot collections
+	flight snapshotFlight
+
+	// shedAfter is how long a collection may run before listings get 429;
+	// shed counts the requests turned away
+	shedAfter time.Duration
+	shed      atomic.Int64
+
+	// offline is the exported snapshot served instead of Docker's, with
+	// serve --snapshot
+	offline *offlineSnapshot
 }
 
 type CheckResponse struct {
-	Port      int    `json:"port"`
-	Available bool   `json:"available"`
-	Message   string `json:"message"`
+	Port      int           `json:"port"`
+	Available bool          `json:"available"`
+	Code      string        `json:"code"`
+	Message   string        `json:"message"`
+	Meta      *ResponseMeta `json:"meta,omitempty"`
+
+	// OccupiedBy names the holder of an unavailable port. Hint and
+	// MayFreeSoon are set when that holder looks like it is going away,
+	// so automation can wait instead of picking another port.
+	OccupiedBy    string `json:"occupied_by,omitempty"`
+	OccupiedState string `json:"occupied_state,omitempty"`
+	Hint          string `json:"hint,omitempty"`
+	HintCode      string `json:"hint_code,omitempty"`
+	MayFreeSoon   bool   `json:"may_free_soon,omitempty"`
 }
 
 type SuggestResponse struct {
-	Port    int    `json:"port"`
-	Message string `json:"message"`
+	Port    int           `json:"port"`
+	Code    string        `json:"code"`
+	Message string        `json:"message"`
+	Meta    *ResponseMeta `json:"meta,omitempty"`
 }
 
 type ErrorResponse struct {
@@ -72,7 +207,7 @@ func writeError(w http.ResponseWriter, status int, code, message string) {
 	w.WriteHeader(status)
 	json.NewEncoder(w).Encode(ErrorResponse{
 		Error:   http.StatusText(status),
-		Message: message,
+		Message: localize(w, message),
 		Code:    code,
 	})
 }
@@ -94,18 +229,109 @@ func classifyDockerError(err error) (int, string, string) {
 	}
 }
 
+// NewDockerClient connects to DOCKER_HOST. QUAYCHECK_DOCKER_REPLAY answers
+// from a recording instead, with no daemon; QUAYCHECK_DOCKER_RECORD records
+// to that file what the daemon answers. Built with -tags faults, either goes
+// through the fault injection layer of /api/admin/faults.
 func NewDockerClient() (DockerClient, error) {
-	return client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if path := os.Getenv("QUAYCHECK_DOCKER_REPLAY"); path != "" {
+		replay, err := loadDockerRecording(path)
+		if err != nil {
+			return nil, err
+		}
+		return withFaults(replay), nil
+	}
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return nil, err
+	}
+	if path := os.Getenv("QUAYCHECK_DOCKER_RECORD"); path != "" {
+		rec, err := newRecordingClient(cli, path)
+		if err != nil {
+			return nil, err
+		}
+		return withFaults(rec), nil
+	}
+	return withFaults(cli), nil
 }
 
 func (s *Server) getContainers(ctx context.Context) ([]ContainerData, error) {
-	containers, err := s.client.ContainerList(ctx, types.ContainerListOptions{All: true})
+	snap, err := s.loadSnapshot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return snap.Containers, nil
+}
+
+// collectContainers queries Docker and every extra port source concurrently,
+// or returns the offline snapshot being served instead
+func (s *Server) collectContainers(ctx context.Context) (Snapshot, error) {
+	if s.offline != nil {
+		return s.offline.snap, nil
+	}
+	sources := append([]PortSource{&dockerSource{client: s.client, inspect: s.inspect, images: s.images}}, s.sources...)
+	if s.health != nil {
+		for i, src := range sources {
+			sources[i] = &monitoredSource{PortSource: src, health: s.health}
+		}
+	}
+	return collectSources(ctx, sources, s.sourceTimeout)
+}
+
+// healthFromStatus extracts the healthcheck result Docker appends to the
+// status line, e.g. "Up 2 hours (unhealthy)" or "Up 3 seconds (health: starting)"
+func healthFromStatus(status string) string {
+	switch {
+	case strings.HasSuffix(status, "(healthy)"):
+		return "healthy"
+	case strings.HasSuffix(status, "(unhealthy)"):
+		return "unhealthy"
+	case strings.HasSuffix(status, "(health: starting)"):
+		return "starting"
+	}
+	return ""
+}
+
+const (
+	composeProjectLabel     = "com.docker.compose.project"
+	composeServiceLabel     = "com.docker.compose.service"
+	composeNumberLabel      = "com.docker.compose.container-number"
+	composeConfigFilesLabel = "com.docker.compose.project.config_files"
+	composeWorkingDirLabel  = "com.docker.compose.project.working_dir"
+)
+
+// ownerLabels name a container's owner, most specific first; images often
+// carry the OCI authors label, which containers inherit
+var ownerLabels = []string{"quaycheck.owner", "org.opencontainers.image.authors"}
+
+func containerOwner(labels map[string]string) string {
+	for _, l := range ownerLabels {
+		if v := strings.TrimSpace(labels[l]); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// dockerSource adapts the Docker API to a PortSource
+type dockerSource struct {
+	client  DockerClient
+	inspect *inspectCache
+	images  *imageTracker
+}
+
+func (d *dockerSource) Name() string { return "docker" }
+
+func (d *dockerSource) Containers(ctx context.Context) ([]ContainerData, error) {
+	containers, err := d.client.ContainerList(ctx, types.ContainerListOptions{All: true})
 	if err != nil {
 		return nil, err
 	}
 
 	var result []ContainerData
+	imageIDs := make(map[string]string, len(containers))
 	for _, c := range containers {
+		imageIDs[c.ID] = c.ImageID
 		var ports []PortMapping
 		for _, p := range c.Ports {
 			ports = append(ports, PortMapping{
@@ -116,38 +342,86 @@ func (s *Server) getContainers(ctx context.Context) ([]ContainerData, error) {
 			})
 		}
 
+		replica, _ := strconv.Atoi(c.Labels[composeNumberLabel])
 		result = append(result, ContainerData{
-			ID:    c.ID,
-			Names: c.Names,
-			Image: c.Image,
-			State: c.State,
-			Ports: ports,
+			ID:     c.ID,
+			Names:  c.Names,
+			Image:  c.Image,
+			State:  c.State,
+			Status: c.Status,
+			Health: healthFromStatus(c.Status),
+			Ports:  ports,
+
+			Project:      c.Labels[composeProjectLabel],
+			Service:      c.Labels[composeServiceLabel],
+			Replica:      replica,
+			ComposeFiles: queryList([]string{c.Labels[composeConfigFilesLabel]}),
+			ComposeDir:   c.Labels[composeWorkingDirLabel],
+			Owner:        containerOwner(c.Labels),
+			Networks:     containerNetworks(c),
+			Advertise:    parseAdvertise(c.Labels),
 		})
 	}
+	if d.inspect != nil {
+		d.inspect.enrich(ctx, d.client, result)
+	}
+	if d.images != nil {
+		d.images.enrich(ctx, d.client, imageIDs, result)
+	}
 	return result, nil
 }
 
-func getAllUsedPorts(containers []ContainerData) map[int]bool {
-	used := make(map[int]bool)
-	for _, c := range containers {
-		if c.State == "running" {
-			for _, p := range c.Ports {
-				used[int(p.PublicPort)] = true
-			}
-		}
+// containerNetworks lists the networks of c, sorted
+func containerNetworks(c types.Container) []string {
+	if c.NetworkSettings == nil {
+		return nil
+	}
+	var names []string
+	for name := range c.NetworkSettings.Networks {
+		names = append(names, name)
 	}
-	return used
+	sort.Strings(names)
+	return names
 }
 
 func (s *Server) handlePorts(w http.ResponseWriter, r *http.Request) {
-	containers, err := s.getContainers(r.Context())
+	snap, err := s.loadSnapshot(r.Context())
 	if err != nil {
 		status, code, msg := classifyDockerError(err)
 		writeError(w, status, code, msg)
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(containers)
+	s.writeSnapshotHeaders(w, snap)
+
+	// Filtered views are small and rare, so they skip the memoized encoding
+	q := r.URL.Query()
+	if q.Get("tag") == "" && q.Get("health") == "" {
+		writeSnapshotBody(w, r, snap)
+		return
+	}
+	containers := snap.Containers
+	if tag := q.Get("tag"); tag != "" && s.tags != nil {
+		containers = filterByTag(containers, s.tags.PortsWithTag(tag))
+	}
+	if health := q.Get("health"); health != "" {
+		containers = filterByHealth(containers, health)
+	}
+	writeEncoded(w, r, containers)
+}
+
+// filterByHealth keeps entries whose healthcheck status is health; "none"
+// matches containers without a healthcheck
+func filterByHealth(containers []ContainerData, health string) []ContainerData {
+	if health == "none" {
+		health = ""
+	}
+	result := []ContainerData{}
+	for _, c := range containers {
+		if c.Health == health {
+			result = append(result, c)
+		}
+	}
+	return result
 }
 
 func (s *Server) handleCheck(w http.ResponseWriter, r *http.Request) {
@@ -161,66 +435,203 @@ func (s *Server) handleCheck(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusBadRequest, "invalid_param", "Invalid port parameter")
 		return
 	}
+	usage, err := s.usageFromRequest(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_param", err.Error())
+		return
+	}
 
-	containers, err := s.getContainers(r.Context())
+	snap, err := s.loadSnapshot(r.Context())
 	if err != nil {
 		status, code, msg := classifyDockerError(err)
 		writeError(w, status, code, msg)
 		return
 	}
 
-	used := getAllUsedPorts(containers)
-	available := !used[port]
-
-	msg := "Port is available"
-	if !available {
-		msg = "Port is currently in use by a Docker container"
+	resp := CheckResponse{
+		Port:      port,
+		Available: !usage.index(snap).Used(port),
+		Code:      "port_available",
+		Message:   localize(w, "Port is available"),
+		Meta:      s.snapshotMeta(snap),
+	}
+	if !resp.Available {
+		resp.Code = "port_in_use"
+		resp.Message = localize(w, "Port is currently in use by a Docker container")
+		if holder, ok := usage.holder(snap, port); ok {
+			resp.OccupiedBy = containerName(holder)
+			resp.OccupiedState = holder.State
+			if hint := availabilityHint(holder); hint != "" {
+				resp.Hint = localize(w, hint)
+				resp.HintCode = hintCodes[hint]
+				resp.Code = "port_in_use_may_free"
+				resp.MayFreeSoon = true
+				resp.Message = localize(w, "%s, but %s", resp.Message, resp.Hint)
+			}
+		}
 	}
 
+	s.writeSnapshotHeaders(w, snap)
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(CheckResponse{
-		Port:      port,
-		Available: available,
-		Message:   msg,
-	})
+	json.NewEncoder(w).Encode(resp)
+}
+
+// availabilityHint explains why a port holder may release it soon, based on
+// Docker's state and status line, or returns "" if it looks settled
+func availabilityHint(c ContainerData) string {
+	status := strings.ToLower(c.Status)
+	switch {
+	case c.State == "removing" || strings.Contains(status, "removal in progress"):
+		return "container is being removed"
+	case c.State == "restarting" || strings.HasPrefix(status, "restarting"):
+		return "container is restarting"
+	case c.Health == "unhealthy":
+		return "container is unhealthy"
+	}
+	return ""
 }
 
 func (s *Server) handleSuggest(w http.ResponseWriter, r *http.Request) {
-	startStr := r.URL.Query().Get("start")
+	q := r.URL.Query()
+	preset, hasPreset, errMsg := s.presetRange(r)
+	if errMsg != "" {
+		writeError(w, http.StatusBadRequest, "invalid_param", errMsg)
+		return
+	}
+
+	startStr := q.Get("start")
 	if startStr == "" {
 		startStr = "8000"
+		if hasPreset {
+			startStr = strconv.Itoa(preset.Start)
+		}
 	}
 	start, _ := strconv.Atoi(startStr)
-	if start < 1024 {
+	if start < 1024 && !hasPreset {
 		start = 1024
 	}
 
-	containers, err := s.getContainers(r.Context())
+	end := maxPort
+	if hasPreset {
+		end = preset.End
+	}
+	if v := q.Get("end"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < start || n > maxPort {
+			writeError(w, http.StatusBadRequest, "invalid_param", "Invalid end parameter")
+			return
+		}
+		end = n
+	}
+
+	// prefer asks for a specific port, falling back to the nearest free one.
+	// Without an explicit start the search may go down to 1024, or to the
+	// preferred port itself if that's lower.
+	prefer := 0
+	if v := q.Get("prefer"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > end {
+			writeError(w, http.StatusBadRequest, "invalid_param", "Invalid prefer parameter")
+			return
+		}
+		if q.Get("start") == "" && !hasPreset {
+			start = min(n, 1024)
+		}
+		if n < start {
+			writeError(w, http.StatusBadRequest, "invalid_param", "Invalid prefer parameter: below start")
+			return
+		}
+		prefer = n
+	}
+
+	// internal mirrors a container port on the host, at the same number or
+	// at one of the configured offsets from it
+	internal := 0
+	cfg := s.config()
+	offsets := cfg.MirrorOffsets
+	if v := q.Get("internal"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxPort {
+			writeError(w, http.StatusBadRequest, "invalid_param", "Invalid internal parameter")
+			return
+		}
+		if prefer != 0 {
+			writeError(w, http.StatusBadRequest, "invalid_param", "Use either prefer or internal, not both")
+			return
+		}
+		if v := q.Get("offsets"); v != "" {
+			if offsets, err = parseOffsets(v); err != nil {
+				writeError(w, http.StatusBadRequest, "invalid_param", "Invalid offsets parameter: "+err.Error())
+				return
+			}
+		}
+		if len(offsets) == 0 {
+			offsets = defaultMirrorOffsets
+		}
+		if q.Get("start") == "" && !hasPreset {
+			start = 1
+		}
+		internal = n
+	}
+
+	cooldown := time.Duration(cfg.ReleaseCooldown)
+	if v := q.Get("cooldown"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			writeError(w, http.StatusBadRequest, "invalid_param", "Invalid cooldown parameter")
+			return
+		}
+		cooldown = d
+	}
+
+	usage, err := s.usageFromRequest(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_param", err.Error())
+		return
+	}
+
+	snap, err := s.loadSnapshot(r.Context())
 	if err != nil {
 		status, code, msg := classifyDockerError(err)
 		writeError(w, status, code, msg)
 		return
 	}
 
-	used := getAllUsedPorts(containers)
-	suggested := -1
+	ix := s.withoutFrozen(s.withoutRecentlyFreed(withoutExcluded(usage.index(snap), cfg.Excluded), cooldown))
+	var suggested int
+	if prefer != 0 {
+		suggested = ix.Nearest(prefer, start, end)
+	} else if internal != 0 {
+		suggested = suggestMirror(ix, internal, offsets, start, end)
+	} else if suggested = ix.NextFree(start); suggested > end {
+		suggested = -1
+	}
 
-	for i := start; i <= 65535; i++ {
-		if !used[i] {
-			suggested = i
-			break
-		}
+	if s.writeIfFrozen(w, suggested) {
+		return
 	}
 
-	msg := fmt.Sprintf("Suggested port: %d", suggested)
-	if suggested == -1 {
-		msg = "No free ports found in range"
+	code, msg := "port_suggested", localize(w, "Suggested port: %d", suggested)
+	switch {
+	case suggested == -1:
+		code, msg = "no_free_port", localize(w, "No free ports found in range")
+	case prefer != 0 && suggested == prefer:
+		code, msg = "preferred_port_free", localize(w, "Preferred port %d is free", prefer)
+	case prefer != 0:
+		code, msg = "preferred_port_taken", localize(w, "Preferred port %d is taken, nearest free port: %d", prefer, suggested)
+	case internal != 0 && suggested == internal:
+		code, msg = "mirror_same_port", localize(w, "Suggested port: %d, same as the container port", suggested)
+	case internal != 0:
+		code, msg = "mirror_offset_port", localize(w, "Suggested port: %d for container port %d", suggested, internal)
 	}
 
+	s.writeSnapshotHeaders(w, snap)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(SuggestResponse{
 		Port:    suggested,
+		Code:    code,
 		Message: msg,
+		Meta:    s.snapshotMeta(snap),
 	})
 }
 
@@ -247,31 +658,309 @@ func handleStats(w http.ResponseWriter, r *http.Request) {
 // SetupRouter creates and configures the HTTP router
 func SetupRouter(server *Server) *http.ServeMux {
 	mux := http.NewServeMux()
-	fs := http.FileServer(http.Dir("./static"))
-	mux.Handle("/", fs)
-	mux.HandleFunc("/api/ports", server.handlePorts)
-	mux.HandleFunc("/api/check", server.handleCheck)
-	mux.HandleFunc("/api/suggest", server.handleSuggest)
+	mux.Handle("/", server.withUIHeaders(staticHandler()))
+	mux.HandleFunc("GET /api/presets", server.handlePresets)
+	mux.HandleFunc("GET /api/messages", server.handleMessages)
+	mux.HandleFunc("GET /api/capabilities", server.handleCapabilities)
 	mux.HandleFunc("/api/stats", handleStats)
-	return mux
+	mux.HandleFunc("GET /metrics", server.handleMetrics)
+	mux.HandleFunc("GET /readyz", server.handleReady)
+
+	read := func(h http.HandlerFunc) http.HandlerFunc {
+		return server.sheddable(server.requireScope(ScopePortsRead, h))
+	}
+	mux.HandleFunc("/api/ports", read(server.handlePorts))
+	if server.containerActions {
+		mux.HandleFunc("POST /api/ports/{port}/stop", server.requireScope(ScopeContainers, server.handleContainerAction("stop")))
+		mux.HandleFunc("POST /api/ports/{port}/restart", server.requireScope(ScopeContainers, server.handleContainerAction("restart")))
+	}
+	mux.HandleFunc("/api/interfaces", read(server.handleInterfaces))
+	mux.HandleFunc("GET /api/topology", read(server.handleTopology))
+	mux.HandleFunc("/api/ranges", read(server.handleRanges))
+	mux.HandleFunc("GET /api/export/graph", read(server.handleGraphExport))
+	mux.HandleFunc("GET /api/export/dns", read(server.handleDNSExport))
+	mux.HandleFunc("GET /api/export/report", read(server.handleReport))
+	// Preflights carry no credentials
+	mux.HandleFunc("OPTIONS /api/widget", server.handleWidget)
+	mux.HandleFunc("/api/widget", read(server.handleWidget))
+	mux.HandleFunc("GET /widget/summary", widgetToken(read(server.handleSummaryWidget)))
+	mux.HandleFunc("GET /widget/port/{port}", widgetToken(read(server.handlePortWidget)))
+	mux.HandleFunc("GET /api/homeassistant/sensors", read(server.handleHASensors))
+	mux.HandleFunc("GET /api/homeassistant/sensors/{port}", read(server.handleHASensor))
+
+	mux.HandleFunc("/api/check", server.requireScope(ScopeCheck, server.handleCheck))
+	mux.HandleFunc("POST /api/simulate", server.requireScope(ScopeCheck, server.handleSimulate))
+	mux.HandleFunc("POST /api/analyze/k8s", server.requireScope(ScopeCheck, server.handleAnalyzeK8s))
+	mux.HandleFunc("POST /api/analyze/proxy", server.requireScope(ScopeCheck, server.handleAnalyzeProxy))
+	mux.HandleFunc("GET /api/validate/upstreams", read(server.handleValidateUpstreams))
+	mux.HandleFunc("GET /api/reachability", server.requireScope(ScopeCheck, server.handleReachability))
+	if server.serveReach {
+		mux.HandleFunc("GET /api/reach/{port}", server.requireScope(ScopeCheck, server.handleReach))
+	}
+	mux.HandleFunc("POST /api/ansible/check", server.requireScope(ScopeCheck, server.handleAnsibleCheck))
+
+	mux.HandleFunc("/api/suggest", server.requireScope(ScopeSuggest, server.handleSuggest))
+	mux.HandleFunc("GET /api/suggest/common", server.requireScope(ScopeSuggest, server.handleSuggestCommon))
+	mux.HandleFunc("GET /api/hosts", read(server.handleHosts))
+	mux.HandleFunc("GET /api/router/forwards", read(server.handleRouterForwards))
+	mux.HandleFunc("GET /api/connections", read(server.handleConnections))
+	mux.HandleFunc("GET /api/stacks", read(server.handleStacks))
+	mux.HandleFunc("GET /api/stacks/{project}", read(server.handleStack))
+	mux.HandleFunc("POST /api/suggest/batch", server.requireScope(ScopeSuggest, server.handleBatchSuggest))
+	mux.HandleFunc("GET /api/templates", read(server.handleListTemplates))
+	mux.HandleFunc("POST /api/templates/{name}/instantiate", server.requireScope(ScopeReserve, server.handleInstantiateTemplate))
+	mux.HandleFunc("/api/terraform/suggest", server.requireScope(ScopeSuggest, server.handleTerraformSuggest))
+	if server.events != nil {
+		mux.HandleFunc("GET /api/events/stream", read(server.handleEventStream))
+	}
+	if server.tags != nil {
+		mux.HandleFunc("GET /api/tags", read(server.handleListTags))
+		mux.HandleFunc("PUT /api/tags/{port}", server.writable(server.requireScope(ScopeReserve, server.handleSetTag)))
+		mux.HandleFunc("DELETE /api/tags/{port}", server.writable(server.requireScope(ScopeReserve, server.handleDeleteTag)))
+	}
+	if server.traffic != nil {
+		mux.HandleFunc("GET /api/traffic", read(server.handleTraffic))
+	}
+	mux.HandleFunc("GET /api/recommendations", read(server.handleRecommendations))
+	if server.history != nil {
+		mux.HandleFunc("GET /api/history", read(server.handleHistory))
+		mux.HandleFunc("GET /api/admin/digest", server.requireScope(ScopeAdmin, server.handleDigestPreview))
+	}
+	if server.eventLog != nil {
+		mux.HandleFunc("GET /api/events/log", server.requireScope(ScopeAdmin, server.handleEventLog))
+		mux.HandleFunc("GET /api/events/log/verify", server.requireScope(ScopeAdmin, server.handleVerifyEventLog))
+	}
+	if server.db != nil {
+		mux.HandleFunc("GET /api/admin/audit", server.requireScope(ScopeAdmin, server.handleAudit))
+		mux.HandleFunc("GET /api/admin/backup", server.requireScope(ScopeAdmin, server.handleBackup))
+		mux.HandleFunc("POST /api/admin/restore", server.requireScope(ScopeAdmin, server.handleRestore))
+	}
+	if server.settings != nil {
+		mux.HandleFunc("GET /api/admin/config", server.requireScope(ScopeAdmin, server.handleGetConfig))
+		mux.HandleFunc("PUT /api/admin/config", server.writable(server.requireScope(ScopeAdmin, server.handleUpdateConfig)))
+	}
+	if server.sessions != nil {
+		mux.HandleFunc("GET /api/session", server.handleGetSession)
+		mux.HandleFunc("POST /api/session", server.handleLogin)
+		mux.HandleFunc("DELETE /api/session", server.handleLogout)
+	}
+	if server.apiTokens != nil {
+		mux.HandleFunc("GET /api/admin/tokens", server.requireScope(ScopeAdmin, server.handleListTokens))
+		mux.HandleFunc("POST /api/admin/tokens", server.writable(server.requireScope(ScopeAdmin, server.handleCreateToken)))
+		mux.HandleFunc("POST /api/admin/tokens/{id}/rotate", server.writable(server.requireScope(ScopeAdmin, server.handleRotateToken)))
+		mux.HandleFunc("DELETE /api/admin/tokens/{id}", server.writable(server.requireScope(ScopeAdmin, server.handleRevokeToken)))
+	}
+	if server.freezes != nil {
+		mux.HandleFunc("GET /api/admin/freezes", server.requireScope(ScopeAdmin, server.handleListFreezes))
+		mux.HandleFunc("POST /api/admin/freezes", server.writable(server.requireScope(ScopeAdmin, server.handleCreateFreeze)))
+		mux.HandleFunc("DELETE /api/admin/freezes/{id}", server.writable(server.requireScope(ScopeAdmin, server.handleDeleteFreeze)))
+	}
+	registerFaultRoutes(mux, server)
+	if server.reservations != nil {
+		mux.HandleFunc("GET /api/reservations", read(server.handleListReservations))
+		mux.HandleFunc("POST /api/reservations", server.writable(server.requireScope(ScopeReserve, server.handleCreateReservation)))
+		mux.HandleFunc("POST /api/allocate", server.writable(server.requireScope(ScopeReserve, server.handleAllocate)))
+		mux.HandleFunc("GET /api/reservations/{name}", read(server.handleGetReservation))
+		mux.HandleFunc("GET /api/reservations/trash", read(server.handleListTrash))
+		mux.HandleFunc("POST /api/reservations/trash/{name}/restore", server.writable(server.requireScope(ScopeReserve, server.handleRestoreReservation)))
+		mux.HandleFunc("DELETE /api/reservations/trash/{name}", server.writable(server.requireScope(ScopeReserve, server.handlePurgeReservation)))
+		mux.HandleFunc("PUT /api/reservations/{name}", server.writable(server.requireScope(ScopeReserve, server.handleUpdateReservation)))
+		mux.HandleFunc("DELETE /api/reservations/{name}", server.writable(server.requireScope(ScopeReserve, server.handleDeleteReservation)))
+		mux.HandleFunc("POST /api/ansible/reservation", server.writable(server.requireScope(ScopeReserve, server.handleAnsibleReservation)))
+		if server.reassignments != nil {
+			mux.HandleFunc("POST /api/reservations/{name}/reassign", server.writable(server.requireScope(ScopeContainers, server.handleReassign)))
+			mux.HandleFunc("GET /api/reassignments", read(server.handleListReassignments))
+			mux.HandleFunc("DELETE /api/reassignments/{id}", server.writable(server.requireScope(ScopeContainers, server.handleCancelReassignment)))
+		}
+	}
+	if server.ingest != nil && server.ingestToken != "" {
+		mux.HandleFunc("GET /api/ingest", requireIngestToken(server.ingestToken, server.handleListIngested))
+		mux.HandleFunc("POST /api/ingest", server.writable(requireIngestToken(server.ingestToken, server.handleIngest)))
+	}
+	// Every route answers in the negotiated language, scoped to ?env=, and
+	// goes to the access log
+	root := http.NewServeMux()
+	root.Handle("/", server.withAccessLog(withLanguage(server.withEnvironment(mux))))
+	return root
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "serve":
+			snapshot, err := serveFlags(os.Args[2:])
+			if err != nil {
+				os.Exit(2)
+			}
+			if snapshot != "" {
+				runOffline(snapshot)
+				return
+			}
+		case "webhook":
+			runWebhook()
+			return
+		case "check":
+			os.Exit(runCheck(os.Args[2:], os.Stdout, os.Stderr))
+		case "suggest":
+			os.Exit(runSuggest(os.Args[2:], os.Stdin, os.Stdout, os.Stderr))
+		case "reserve":
+			os.Exit(runReserve(os.Args[2:], os.Stdout, os.Stderr))
+		case "healthcheck":
+			os.Exit(runHealthcheck())
+		default:
+			log.Fatalf("Unknown command %q (expected serve, check, suggest, reserve, healthcheck or webhook)", os.Args[1])
+		}
+	}
+	runServer()
+}
+
+func runServer() {
 	cli, err := NewDockerClient()
 	if err != nil {
 		log.Fatalf("Error initializing Docker client: %v", err)
 	}
 
-	server := &Server{client: cli}
-	mux := SetupRouter(server)
+	dataDir := os.Getenv("QUAYCHECK_DATA_DIR")
+	if dataDir == "" {
+		dataDir = "data"
+	}
+	if err := checkDataDir(dataDir); err != nil {
+		log.Fatalf("Startup check failed: %v", err)
+	}
+	if err := checkDocker(context.Background(), cli); err != nil {
+		// Not fatal: the daemon or socket proxy may simply come up later
+		log.Printf("Startup check: Docker is not reachable yet: %v", err)
+	}
+	db, reservations, freezes, tags, err := openState(dataDir)
+	if err != nil {
+		log.Fatalf("Error opening state: %v", err)
+	}
+	shared := redisFromEnv()
+	if shared != nil {
+		if reservations, err = NewSharedReservationStore(shared, sharedRefresh); err != nil {
+			log.Fatalf("Error loading reservations from Redis: %v", err)
+		}
+	}
+	reservations.TrashFor = trashRetentionFromEnv()
+	ingest, err := NewIngestStore(db)
+	if err != nil {
+		log.Fatalf("Error loading ingested ports: %v", err)
+	}
 
+	server := &Server{
+		client:       cli,
+		sources:      withIngest(sourcesFromEnv(), ingest),
+		reservations: reservations,
+		freezes:      freezes,
+		tags:         tags,
+		history:      NewHistoryStore(db),
+		auditLog:     NewAuditLog(db),
+		eventLog:     NewEventLog(db, eventLogChainFromEnv()),
+		db:           db,
+		inspect:      newInspectCache(),
+		images:       newImageTracker(),
+		cache:        newSnapshotCacheFromEnv(),
+		events:       NewEventHub(),
+		health:       NewHealthMonitor(),
+		wake:         make(chan struct{}, 1),
+
+		sourceTimeout: sourceTimeoutFromEnv(),
+		staleAfter:    staleAfterFromEnv(),
+		countCreated:  countCreatedFromEnv(),
+		mirrorOffsets: mirrorOffsetsFromEnv(),
+		presets:       presetsFromEnv(),
+		freed:         newFreedPorts(),
+		freedCooldown: freedCooldownFromEnv(),
+		retention:     retentionFromEnv(),
+		pruned:        newPruneStats(),
+		widgetOrigins: widgetOriginsFromEnv(),
+		frameOrigins:  frameOriginsFromEnv(),
+		watched:       watchedPortsFromEnv(),
+		ingest:        ingest,
+		ingestToken:   os.Getenv("QUAYCHECK_INGEST_TOKEN"),
+		tokens:        tokensFromEnv(),
+		sessions:      newSessionStore(sessionTTLFromEnv()),
+		environments:  environmentsFromEnv(),
+
+		containerActions: containerActionsFromEnv(),
+		composeRoot:      composeRootFromEnv(),
+		proxyConfigs:     proxyConfigsFromEnv(),
+		dns:              dnsFromEnv(),
+		mdns:             mdnsFromEnv(),
+		reachability:     reachabilityFromEnv(),
+		serveReach:       os.Getenv("QUAYCHECK_SERVE_REACHABILITY") == "true",
+		attempts:         attemptsFromEnv(),
+		idleDays:         idleDaysFromEnv(),
+		shedAfter:        shedAfterFromEnv(),
+		trustedProxies:   trustedProxiesFromEnv(),
+		accessLog:        accessLogFromEnv(),
+	}
+	trafficInterval := trafficIntervalFromEnv()
+	if trafficInterval > 0 {
+		server.traffic = NewTrafficStore(db)
+	}
+	smtpConfig := smtpFromEnv()
+	defaults := server.envSettings()
+	if smtpConfig != nil {
+		defaults.DigestTo = smtpConfig.To
+	}
+	if server.settings, err = NewSettingsStore(db, defaults); err != nil {
+		log.Fatalf("Error loading settings: %v", err)
+	}
+	if server.apiTokens, err = NewTokenStore(db); err != nil {
+		log.Fatalf("Error loading tokens: %v", err)
+	}
+	if server.reassignments, err = NewReassignStore(db); err != nil {
+		log.Fatalf("Error loading reassignments: %v", err)
+	}
+	server.reassignments.Grace = reassignGraceFromEnv()
+	server.smtp = smtpConfig
+	if server.dnsUpdater = dnsUpdaterFromEnv(); server.dnsUpdater != nil && net.ParseIP(server.dns.Address) == nil {
+		log.Fatalf("QUAYCHECK_DNS_UPDATER needs QUAYCHECK_DNS_ADDRESS set to an IP address")
+	}
+	if server.leader = leaderElectorFromEnv(db, shared); server.leader != nil {
+		go server.leader.Run(context.Background())
+	}
+	if server.accessLog != nil {
+		server.accessLog.reopenOnSignal()
+	}
+	if server.containerActions {
+		log.Printf("Container actions are enabled: tokens with the containers scope can stop and restart containers")
+	}
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8080"
 	}
+	listener, err := server.listenOrFallback(context.Background(), port, portFallbackFromEnv())
+	if err != nil {
+		log.Fatalf("Cannot start: %v", err)
+	}
+	port = strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+	if err := os.WriteFile(portFileFromEnv(dataDir), []byte(port+"\n"), 0o644); err != nil {
+		log.Printf("Cannot write the port file: %v", err)
+	}
+	if self := selfSourceFor(port); self != nil {
+		server.sources = append(server.sources, self)
+	}
+	startSources(context.Background(), server.sources)
+	go server.watch(context.Background(), watchIntervalFromEnv())
+	go server.mdnsLoop(context.Background(), server.mdns, 30*time.Second)
+	gitExport := gitExportFromEnv()
+	imageInterval := imageCheckIntervalFromEnv()
+	digestSchedule := digestScheduleFromEnv()
+	go server.runAsLeader(context.Background(), func(ctx context.Context) { server.followDockerEvents(ctx, 30*time.Second) })
+	go server.runAsLeader(context.Background(), func(ctx context.Context) { server.gitExportLoop(ctx, gitExport, 10*time.Second) })
+	go server.runAsLeader(context.Background(), func(ctx context.Context) { server.pruneLoop(ctx, time.Hour) })
+	go server.runAsLeader(context.Background(), func(ctx context.Context) { server.checkImageUpdates(ctx, imageInterval) })
+	go server.runAsLeader(context.Background(), func(ctx context.Context) { server.digestLoop(ctx, smtpConfig, digestSchedule) })
+	go server.runAsLeader(context.Background(), func(ctx context.Context) { server.reassignLoop(ctx, time.Minute) })
+	go server.runAsLeader(context.Background(), func(ctx context.Context) { server.dnsUpdateLoop(ctx, server.dnsUpdater, 30*time.Second) })
+	go server.runAsLeader(context.Background(), func(ctx context.Context) { server.routerForwardLoop(ctx, 5*time.Minute) })
+	go server.runAsLeader(context.Background(), func(ctx context.Context) { server.trafficLoop(ctx, trafficInterval) })
+	mux := SetupRouter(server)
 
 	log.Printf("Server starting on port %s...", port)
-	if err := http.ListenAndServe(":"+port, mux); err != nil {
+	if err := http.Serve(listener, mux); err != nil {
 		log.Fatal(err)
 	}
 }
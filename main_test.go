@@ -71,15 +71,15 @@ func TestGetAllUsedPorts(t *testing.T) {
 		},
 	}
 
-	used := getAllUsedPorts(containers)
+	used := buildPortIndex(containers)
 
-	if !used[8080] {
+	if !used.Used(8080) {
 		t.Error("Expected 8080 to be used")
 	}
-	if !used[9090] {
+	if !used.Used(9090) {
 		t.Error("Expected 9090 to be used")
 	}
-	if used[3000] {
+	if used.Used(3000) {
 		t.Error("Expected 3000 to NOT be used (container exited)")
 	}
 }
@@ -159,6 +159,99 @@ func TestHandleCheck(t *testing.T) {
 	}
 }
 
+func TestHandleCheckHint(t *testing.T) {
+	server := &Server{client: &MockDockerClient{Containers: []types.Container{
+		{ID: "1", Names: []string{"/web"}, State: "running", Status: "Up 3 hours (unhealthy)", Ports: []types.Port{{PublicPort: 8080}}},
+		{ID: "2", Names: []string{"/db"}, State: "running", Status: "Up 3 hours (healthy)", Ports: []types.Port{{PublicPort: 5432}}},
+	}}}
+
+	tests := []struct {
+		port     string
+		occupant string
+		hint     string
+	}{
+		{"8080", "web", "container is unhealthy"},
+		{"5432", "db", ""},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		server.handleCheck(w, httptest.NewRequest("GET", "/api/check?port="+tt.port, nil))
+
+		var result CheckResponse
+		json.NewDecoder(w.Body).Decode(&result)
+		if result.Available || result.OccupiedBy != tt.occupant || result.Hint != tt.hint || result.MayFreeSoon != (tt.hint != "") {
+			t.Errorf("Port %s: unexpected result %+v", tt.port, result)
+		}
+	}
+
+	if hint := availabilityHint(ContainerData{State: "restarting"}); hint != "container is restarting" {
+		t.Errorf("Unexpected hint for restarting container: %q", hint)
+	}
+}
+
+func TestPausedAndRestartingHoldPorts(t *testing.T) {
+	server := &Server{client: &MockDockerClient{Containers: []types.Container{
+		{Names: []string{"/frozen"}, State: "paused", Ports: []types.Port{{PublicPort: 8080}}},
+		{Names: []string{"/flappy"}, State: "restarting", Ports: []types.Port{{PublicPort: 8081}}},
+		{Names: []string{"/gone"}, State: "exited", Ports: []types.Port{{PublicPort: 8082}}},
+	}}}
+
+	tests := []struct {
+		port      string
+		available bool
+		state     string
+	}{
+		{"8080", false, "paused"},
+		{"8081", false, "restarting"},
+		{"8082", true, ""},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		server.handleCheck(w, httptest.NewRequest("GET", "/api/check?port="+tt.port, nil))
+
+		var result CheckResponse
+		json.NewDecoder(w.Body).Decode(&result)
+		if result.Available != tt.available || result.OccupiedState != tt.state {
+			t.Errorf("Port %s: unexpected result %+v", tt.port, result)
+		}
+	}
+}
+
+func TestHealthFromStatus(t *testing.T) {
+	tests := map[string]string{
+		"Up 2 hours (healthy)":            "healthy",
+		"Up 2 hours (unhealthy)":          "unhealthy",
+		"Up 3 seconds (health: starting)": "starting",
+		"Up 2 hours":                      "",
+		"Exited (0) 5 minutes ago":        "",
+	}
+	for status, want := range tests {
+		if got := healthFromStatus(status); got != want {
+			t.Errorf("%q: expected %q, got %q", status, want, got)
+		}
+	}
+}
+
+func TestHandlePortsHealthFilter(t *testing.T) {
+	server := &Server{client: &MockDockerClient{Containers: []types.Container{
+		{ID: "1", State: "running", Status: "Up 1 hour (unhealthy)"},
+		{ID: "2", State: "running", Status: "Up 1 hour (healthy)"},
+		{ID: "3", State: "running", Status: "Up 1 hour"},
+	}}}
+
+	for query, want := range map[string]string{"unhealthy": "1", "healthy": "2", "none": "3"} {
+		w := httptest.NewRecorder()
+		server.handlePorts(w, httptest.NewRequest("GET", "/api/ports?health="+query, nil))
+		var containers []ContainerData
+		json.NewDecoder(w.Body).Decode(&containers)
+		if len(containers) != 1 || containers[0].ID != want {
+			t.Errorf("health=%s: expected container %s, got %+v", query, want, containers)
+		}
+	}
+}
+
 func TestHandleSuggest(t *testing.T) {
 	mockContainers := []types.Container{
 		{
@@ -194,6 +287,44 @@ func TestHandleSuggest(t *testing.T) {
 	}
 }
 
+func TestHandleSuggestPrefer(t *testing.T) {
+	mockClient := &MockDockerClient{Containers: []types.Container{
+		{State: "running", Ports: []types.Port{{PublicPort: 8080}, {PublicPort: 8081}, {PublicPort: 8082}}},
+	}}
+	server := &Server{client: mockClient}
+
+	tests := []struct {
+		query        string
+		status       int
+		expectedPort int
+	}{
+		{"prefer=9000", 200, 9000},
+		{"prefer=8080", 200, 8079},
+		{"prefer=8081", 200, 8083}, // tie goes up
+		{"prefer=8081&start=8080", 200, 8083},
+		{"prefer=8081&start=8080&end=8082", 200, -1},
+		{"start=8080&end=8082", 200, -1},
+		{"prefer=80", 200, 80},
+		{"prefer=8000&start=9000", 400, 0},
+		{"prefer=abc", 400, 0},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		server.handleSuggest(w, httptest.NewRequest("GET", "/api/suggest?"+tt.query, nil))
+		if w.Code != tt.status {
+			t.Errorf("%s: expected status %d, got %d", tt.query, tt.status, w.Code)
+			continue
+		}
+
+		var result SuggestResponse
+		json.NewDecoder(w.Body).Decode(&result)
+		if tt.status == 200 && result.Port != tt.expectedPort {
+			t.Errorf("%s: expected port %d, got %d", tt.query, tt.expectedPort, result.Port)
+		}
+	}
+}
+
 func TestHandleErrors(t *testing.T) {
 	mockClient := &MockDockerClient{Err: errors.New("docker down")}
 	server := &Server{client: mockClient}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// mdnsLabel opts a container into mDNS advertisement. Its value lists
+// service types, each optionally followed by the container port it
+// answers on, e.g. "_http._tcp" or "_http._tcp:8080,_ssh._tcp:22".
+const mdnsLabel = "quaycheck.mdns"
+
+// mdnsGroup is where mDNS queries and announcements go
+var mdnsGroup = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+// mdnsTTL is how long answers are cached; services are announced again
+// well before
+const mdnsTTL = 120
+
+// MDNSService is one advertised service: Instance._type._proto.local
+// pointing at the host's Port
+type MDNSService struct {
+	Instance  string `json:"instance"`
+	Type      string `json:"type"`
+	Port      int    `json:"port"`
+	Container string `json:"container"`
+}
+
+func (s MDNSService) name() string {
+	return s.Instance + "." + s.Type + ".local."
+}
+
+// mdnsFromEnv reads QUAYCHECK_MDNS=true, and QUAYCHECK_MDNS_HOSTNAME, the
+// .local name services point at (this host's name by default). They
+// resolve to QUAYCHECK_DNS_ADDRESS, else the address of the interface
+// holding the default route.
+func mdnsFromEnv() *MDNSResponder {
+	if os.Getenv("QUAYCHECK_MDNS") != "true" {
+		return nil
+	}
+	host := os.Getenv("QUAYCHECK_MDNS_HOSTNAME")
+	if host == "" {
+		host, _ = os.Hostname()
+		host, _, _ = strings.Cut(host, ".")
+	}
+	addr := net.ParseIP(lanAddress())
+	if host == "" || addr.To4() == nil {
+		log.Fatalf("QUAYCHECK_MDNS needs QUAYCHECK_MDNS_HOSTNAME and an IPv4 QUAYCHECK_DNS_ADDRESS")
+	}
+	return &MDNSResponder{Host: dnsLabel(host) + ".local.", Address: addr.To4()}
+}
+
+// mdnsServices lists the services the local containers opted into. The
+// port is the host port published for the given container port, or the
+// first one of the type's protocol when none is given. Instances are
+// named after the container.
+func mdnsServices(containers []ContainerData) []MDNSService {
+	var result []MDNSService
+	for _, c := range containers {
+		if c.State != "running" || hostOf(c) != localHost {
+			continue
+		}
+		for _, spec := range c.Advertise {
+			typ, portStr, _ := strings.Cut(spec, ":")
+			proto := "tcp"
+			if strings.HasSuffix(typ, "._udp") {
+				proto = "udp"
+			}
+			want, _ := strconv.Atoi(portStr)
+			i := slices.IndexFunc(c.Ports, func(p PortMapping) bool {
+				return p.PublicPort != 0 && (p.Type == proto || p.Type == "") && (want == 0 || int(p.PrivatePort) == want)
+			})
+			if i < 0 || !validServiceType(typ) {
+				continue
+			}
+			name := containerName(c)
+			result = append(result, MDNSService{
+				Instance:  strings.ReplaceAll(name, ".", "-"),
+				Type:      typ,
+				Port:      int(c.Ports[i].PublicPort),
+				Container: name,
+			})
+		}
+	}
+	slices.SortFunc(result, func(a, b MDNSService) int { return strings.Compare(a.name(), b.name()) })
+	return slices.CompactFunc(result, func(a, b MDNSService) bool { return a.name() == b.name() })
+}
+
+// validServiceType accepts _service._tcp and _service._udp
+func validServiceType(typ string) bool {
+	service, proto, ok := strings.Cut(typ, ".")
+	return ok && len(service) > 1 && service[0] == '_' && dnsLabel(service[1:]) == service[1:] && (proto == "_tcp" || proto == "_udp")
+}
+
+// MDNSResponder answers mDNS queries for the advertised services and the
+// host name they point at
+type MDNSResponder struct {
+	Host    string
+	Address net.IP
+
+	mu       sync.Mutex
+	services []MDNSService
+}
+
+// setServices replaces the advertised services and returns those that
+// appeared and those that went away
+func (m *MDNSResponder) setServices(services []MDNSService) (added, removed []MDNSService) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, s := range services {
+		if !slices.Contains(m.services, s) {
+			added = append(added, s)
+		}
+	}
+	for _, s := range m.services {
+		if !slices.Contains(services, s) {
+			removed = append(removed, s)
+		}
+	}
+	m.services = services
+	return added, removed
+}
+
+// records are the PTR, SRV and TXT records of a service, and the
+// browsing PTR from _services._dns-sd._udp
+func (m *MDNSResponder) records(s MDNSService, ttl uint32) []dnsmessage.Resource {
+	instance := dnsmessage.MustNewName(s.name())
+	typ := dnsmessage.MustNewName(s.Type + ".local.")
+	header := func(name dnsmessage.Name, kind dnsmessage.Type, flush bool) dnsmessage.ResourceHeader {
+		class := dnsmessage.ClassINET
+		if flush {
+			// The cache-flush bit: these records are ours alone
+			class |= 1 << 15
+		}
+		return dnsmessage.ResourceHeader{Name: name, Type: kind, Class: class, TTL: ttl}
+	}
+	return []dnsmessage.Resource{
+		{Header: header(dnsmessage.MustNewName("_services._dns-sd._udp.local."), dnsmessage.TypePTR, false), Body: &dnsmessage.PTRResource{PTR: typ}},
+		{Header: header(typ, dnsmessage.TypePTR, false), Body: &dnsmessage.PTRResource{PTR: instance}},
+		{Header: header(instance, dnsmessage.TypeSRV, true), Body: &dnsmessage.SRVResource{Port: uint16(s.Port), Target: dnsmessage.MustNewName(m.Host)}},
+		{Header: header(instance, dnsmessage.TypeTXT, true), Body: &dnsmessage.TXTResource{TXT: []string{"container=" + s.Container}}},
+	}
+}
+
+func (m *MDNSResponder) addressRecord(ttl uint32) dnsmessage.Resource {
+	var a [4]byte
+	copy(a[:], m.Address.To4())
+	return dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName(m.Host), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET | 1<<15, TTL: ttl},
+		Body:   &dnsmessage.AResource{A: a},
+	}
+}
+
+// announcement is an unsolicited response carrying the services' records;
+// a TTL of 0 says goodbye
+func (m *MDNSResponder) announcement(services []MDNSService, ttl uint32) ([]byte, error) {
+	var answers []dnsmessage.Resource
+	for _, s := range services {
+		answers = append(answers, m.records(s, ttl)...)
+	}
+	if ttl > 0 {
+		answers = append(answers, m.addressRecord(ttl))
+	}
+	msg := dnsmessage.Message{Header: dnsmessage.Header{Response: true, Authoritative: true}, Answers: answers}
+	return msg.Pack()
+}
+
+// respond answers a query, returning nil when there's nothing to say.
+// Queries from a port other than 5353 are legacy unicast ones, answered
+// with their ID and questions as a plain DNS server would.
+func (m *MDNSResponder) respond(packet []byte, legacy bool) ([]byte, error) {
+	var query dnsmessage.Message
+	if err := query.Unpack(packet); err != nil || query.Header.Response {
+		return nil, err
+	}
+	m.mu.Lock()
+	services := slices.Clone(m.services)
+	m.mu.Unlock()
+
+	var all []dnsmessage.Resource
+	for _, s := range services {
+		all = append(all, m.records(s, mdnsTTL)...)
+	}
+	all = append(all, m.addressRecord(mdnsTTL))
+
+	var answers, additionals []dnsmessage.Resource
+	for _, q := range query.Questions {
+		for _, r := range all {
+			if !strings.EqualFold(r.Header.Name.String(), q.Name.String()) || (q.Type != dnsmessage.TypeALL && q.Type != r.Header.Type) {
+				continue
+			}
+			answers = appendRecord(answers, r)
+			// A PTR answer comes with what it points at, and the host's
+			// address, to save round trips
+			if ptr, ok := r.Body.(*dnsmessage.PTRResource); ok {
+				for _, extra := range all {
+					if extra.Header.Name == ptr.PTR || extra.Header.Type == dnsmessage.TypeA {
+						additionals = appendRecord(additionals, extra)
+					}
+				}
+			}
+		}
+	}
+	if len(answers) == 0 {
+		return nil, nil
+	}
+	additionals = slices.DeleteFunc(additionals, func(a dnsmessage.Resource) bool {
+		return slices.ContainsFunc(answers, func(b dnsmessage.Resource) bool { return sameRecord(a, b) })
+	})
+
+	resp := dnsmessage.Message{Header: dnsmessage.Header{Response: true, Authoritative: true}, Answers: answers, Additionals: additionals}
+	if legacy {
+		resp.ID, resp.Questions = query.ID, query.Questions
+		for i := range resp.Answers {
+			resp.Answers[i].Header.Class &^= 1 << 15
+		}
+		resp.Additionals = nil
+	}
+	return resp.Pack()
+}
+
+func sameRecord(a, b dnsmessage.Resource) bool {
+	return a.Header.Name == b.Header.Name && a.Header.Type == b.Header.Type && a.Body.GoString() == b.Body.GoString()
+}
+
+func appendRecord(list []dnsmessage.Resource, r dnsmessage.Resource) []dnsmessage.Resource {
+	if slices.ContainsFunc(list, func(a dnsmessage.Resource) bool { return sameRecord(a, r) }) {
+		return list
+	}
+	return append(list, r)
+}
+
+// mdnsLoop advertises the local containers' services until ctx is done,
+// checking for changes every interval. Every instance of quaycheck
+// advertises its own host's containers, so it runs on followers too.
+func (s *Server) mdnsLoop(ctx context.Context, m *MDNSResponder, interval time.Duration) {
+	if m == nil {
+		return
+	}
+	conn, err := net.ListenMulticastUDP("udp4", nil, mdnsGroup)
+	if err != nil {
+		log.Printf("mDNS disabled: %v", err)
+		return
+	}
+	defer conn.Close()
+	send := func(services []MDNSService, ttl uint32) {
+		if len(services) == 0 {
+			return
+		}
+		packet, err := m.announcement(services, ttl)
+		if err == nil {
+			_, err = conn.WriteToUDP(packet, mdnsGroup)
+		}
+		if err != nil {
+			log.Printf("mDNS announcement failed: %v", err)
+		}
+	}
+
+	go func() {
+		buf := make([]byte, 9000)
+		for {
+			n, from, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			legacy := from.Port != mdnsGroup.Port
+			resp, err := m.respond(buf[:n], legacy)
+			if err != nil || resp == nil {
+				continue
+			}
+			to := mdnsGroup
+			if legacy {
+				to = from
+			}
+			conn.WriteToUDP(resp, to)
+		}
+	}()
+
+	refresh := func() {
+		snap, err := s.loadSnapshot(ctx)
+		if err != nil {
+			log.Printf("mDNS refresh skipped: %v", err)
+			return
+		}
+		added, removed := m.setServices(mdnsServices(snap.Containers))
+		send(removed, 0)
+		send(added, mdnsTTL)
+		for _, svc := range added {
+			log.Printf("Advertising %s on port %d over mDNS", svc.name(), svc.Port)
+		}
+	}
+
+	current := func() []MDNSService {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		return m.services
+	}
+
+	refresh()
+	announced := time.Now()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			send(current(), 0)
+			return
+		case <-ticker.C:
+			refresh()
+			// Announce everything again before caches let it expire
+			if time.Since(announced) >= mdnsTTL*time.Second/2 {
+				send(current(), mdnsTTL)
+				announced = time.Now()
+			}
+		}
+	}
+}
+
+// parseAdvertise splits the quaycheck.mdns label
+func parseAdvertise(labels map[string]string) []string {
+	var specs []string
+	for _, spec := range strings.Split(labels[mdnsLabel], ",") {
+		if spec = strings.TrimSpace(spec); spec != "" {
+			specs = append(specs, spec)
+		}
+	}
+	return specs
+}
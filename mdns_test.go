@@ -0,0 +1,105 @@
+package main
+
+import (
+	"net"
+	"slices"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestMDNSServices(t *testing.T) {
+	containers := []ContainerData{
+		{Names: []string{"/grafana"}, State: "running", Advertise: parseAdvertise(map[string]string{mdnsLabel: "_http._tcp:3000, _ssh._tcp"}), Ports: []PortMapping{
+			{PrivatePort: 22, PublicPort: 2222, Type: "tcp"},
+			{PrivatePort: 3000, PublicPort: 3000, Type: "tcp"},
+		}},
+		{Names: []string{"/dns"}, State: "running", Advertise: []string{"_dns._udp"}, Ports: []PortMapping{{PrivatePort: 53, PublicPort: 5300, Type: "udp"}}},
+		// Stopped, unpublished, remote or with a bad type: left out
+		{Names: []string{"/old"}, State: "exited", Advertise: []string{"_http._tcp"}, Ports: []PortMapping{{PrivatePort: 80, PublicPort: 8080, Type: "tcp"}}},
+		{Names: []string{"/internal"}, State: "running", Advertise: []string{"_http._tcp"}, Ports: []PortMapping{{PrivatePort: 80, Type: "tcp"}}},
+		{ID: "remote:lb1:abc", Names: []string{"/web"}, State: "running", Source: "remote:lb1", Advertise: []string{"_http._tcp"}, Ports: []PortMapping{{PrivatePort: 80, PublicPort: 80, Type: "tcp"}}},
+		{Names: []string{"/bad"}, State: "running", Advertise: []string{"http"}, Ports: []PortMapping{{PrivatePort: 80, PublicPort: 8081, Type: "tcp"}}},
+	}
+	got := mdnsServices(containers)
+	want := []MDNSService{
+		{Instance: "dns", Type: "_dns._udp", Port: 5300, Container: "dns"},
+		{Instance: "grafana", Type: "_http._tcp", Port: 3000, Container: "grafana"},
+		{Instance: "grafana", Type: "_ssh._tcp", Port: 2222, Container: "grafana"},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("Expected %+v, got %+v", want, got)
+	}
+}
+
+func TestMDNSRespond(t *testing.T) {
+	m := &MDNSResponder{Host: "nas.local.", Address: net.IPv4(10, 0, 0, 5)}
+	added, _ := m.setServices([]MDNSService{{Instance: "grafana", Type: "_http._tcp", Port: 3000, Container: "grafana"}})
+	if len(added) != 1 {
+		t.Fatalf("Expected the service to be added, got %v", added)
+	}
+
+	query := func(name string, kind dnsmessage.Type, id uint16) []byte {
+		msg := dnsmessage.Message{Header: dnsmessage.Header{ID: id}, Questions: []dnsmessage.Question{{Name: dnsmessage.MustNewName(name), Type: kind, Class: dnsmessage.ClassINET}}}
+		packet, err := msg.Pack()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return packet
+	}
+	ask := func(packet []byte, legacy bool) dnsmessage.Message {
+		resp, err := m.respond(packet, legacy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var msg dnsmessage.Message
+		if resp != nil {
+			if err := msg.Unpack(resp); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return msg
+	}
+
+	msg := ask(query("_http._tcp.local.", dnsmessage.TypePTR, 0), false)
+	if len(msg.Answers) != 1 || msg.Answers[0].Body.(*dnsmessage.PTRResource).PTR.String() != "grafana._http._tcp.local." {
+		t.Fatalf("Unexpected answers %+v", msg.Answers)
+	}
+	var kinds []dnsmessage.Type
+	for _, r := range msg.Additionals {
+		kinds = append(kinds, r.Header.Type)
+		if srv, ok := r.Body.(*dnsmessage.SRVResource); ok && (srv.Port != 3000 || srv.Target.String() != "nas.local.") {
+			t.Errorf("Unexpected SRV %+v", srv)
+		}
+	}
+	if want := []dnsmessage.Type{dnsmessage.TypeSRV, dnsmessage.TypeTXT, dnsmessage.TypeA}; !slices.Equal(kinds, want) {
+		t.Errorf("Expected additionals %v, got %v", want, kinds)
+	}
+
+	msg = ask(query("NAS.local.", dnsmessage.TypeA, 7), true)
+	if msg.ID != 7 || len(msg.Questions) != 1 || len(msg.Answers) != 1 || msg.Answers[0].Body.(*dnsmessage.AResource).A != [4]byte{10, 0, 0, 5} {
+		t.Errorf("Unexpected legacy answer %+v", msg)
+	}
+	if msg.Answers[0].Header.Class != dnsmessage.ClassINET {
+		t.Errorf("Expected no cache-flush bit in a legacy answer, got class %d", msg.Answers[0].Header.Class)
+	}
+
+	if resp, _ := m.respond(query("printer._ipp._tcp.local.", dnsmessage.TypeSRV, 0), false); resp != nil {
+		t.Error("Expected no answer for someone else's name")
+	}
+
+	_, removed := m.setServices(nil)
+	packet, err := m.announcement(removed, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var goodbye dnsmessage.Message
+	if err := goodbye.Unpack(packet); err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range goodbye.Answers {
+		if r.Header.TTL != 0 {
+			t.Errorf("Expected a goodbye with TTL 0, got %+v", r.Header)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// messageCatalog gives every code the API puts next to a human message a
+// generic English text. Codes are stable: clients switch on them and
+// render their own copy, while the text here and in the message fields may
+// change. Texts are translated through the i18n catalogs like any message.
+var messageCatalog = map[string]string{
+	// check
+	"port_available":       "Port is available",
+	"port_in_use":          "Port is in use",
+	"port_in_use_may_free": "Port is in use, but its holder may release it soon",
+	"holder_removing":      "container is being removed",
+	"holder_restarting":    "container is restarting",
+	"holder_unhealthy":     "container is unhealthy",
+
+	// suggest
+	"port_suggested":       "A free port was found",
+	"preferred_port_free":  "The preferred port is free",
+	"preferred_port_taken": "The preferred port is taken; the nearest free port was suggested",
+	"mirror_same_port":     "The container port is free on the host",
+	"mirror_offset_port":   "A port at an offset from the container port was suggested",
+	"no_free_port":         "No free ports found in range",
+
+	// reservations, compose and Ansible
+	"port_reserved":         "Port reserved",
+	"reservation_present":   "Reservation already present",
+	"reservation_removed":   "Reservation removed",
+	"reservation_absent":    "Reservation already absent",
+	"reservation_exists":    "A reservation with this name already exists",
+	"reservations_disabled": "Reservations are not enabled on this server",
+	"port_published_twice":  "Port is published by two services of the same stack",
+	"port_range_exhausted":  "Too few ports of a published range are free for the service's replicas",
+	"upstream_unpublished":  "The proxy forwards to a port nothing publishes",
+	"upstream_not_exposed":  "The proxy forwards to a container that doesn't expose the port",
+	"publicly_reachable":    "Port is reachable from the internet",
+	"probe_failed":          "The reachability probe failed",
+	"frozen":                "Allocations are frozen for this port",
+	"request_error":         "Cannot reach the quaycheck server",
+
+	// request errors
+	"missing_param":         "A required parameter is missing",
+	"invalid_param":         "A parameter is invalid",
+	"invalid_body":          "The request body is invalid",
+	"invalid_compose":       "The compose file cannot be parsed",
+	"invalid_manifest":      "The Kubernetes manifests cannot be parsed",
+	"invalid_unit":          "The systemd unit cannot be parsed",
+	"invalid_proxy_config":  "The proxy config cannot be parsed",
+	"invalid_event":         "An ingest event is invalid",
+	"invalid_setting":       "A setting is invalid",
+	"method_not_allowed":    "Method not allowed",
+	"unauthorized":          "A valid token is required",
+	"forbidden":             "The token does not grant this scope",
+	"csrf":                  "The CSRF token is missing or invalid",
+	"admin_token_required":  "The change would leave no admin token",
+	"admin_not_configured":  "No admin token is configured",
+	"not_found":             "Not found",
+	"not_watched":           "Port is neither watched nor reserved",
+	"held_by_other":         "Port is held by another owner",
+	"precondition_required": "An If-Match header is required",
+	"precondition_failed":   "The reservation was changed since you read it",
+	"not_a_container":       "Port is not held by a Docker container",
+	"not_squatted":          "Nothing but the reservation holds the port",
+	"reassign_pending":      "A reassignment of this reservation is already pending",
+
+	// server state
+	"store_error":           "Cannot read or write the state database",
+	"storage_disabled":      "No state database on this server",
+	"read_only":             "State is being restored; try again shortly",
+	"restore_in_progress":   "Another restore is in progress",
+	"restore_failed":        "Restore failed",
+	"invalid_backup":        "The backup archive is invalid",
+	"backup_failed":         "Backup failed",
+	"encoding_error":        "Cannot encode the response",
+	"interfaces_error":      "Cannot list host interfaces",
+	"streaming_unsupported": "Streaming is not supported by this connection",
+
+	// container actions
+	"container_stopped":       "Container stopped",
+	"container_restarted":     "Container restarted",
+	"actions_unsupported":     "This Docker client cannot stop or restart containers",
+	"proxy_configs_unset":     "No proxy configs are set up on this server",
+	"reachability_unset":      "No reachability probe is set up on this server",
+	"router_unset":            "No router is set up on this server",
+	"router_error":            "Cannot read the router's forwards",
+	"connections_unavailable": "Cannot read the connection table",
+	"traffic_unset":           "Traffic sampling is not enabled on this server",
+	"port_idle":               "The port has taken no traffic for a while",
+
+	// Docker
+	"docker_api_version": "Docker API version mismatch",
+	"docker_unavailable": "Cannot connect to Docker",
+	"docker_permission":  "Permission denied accessing Docker socket",
+	"docker_timeout":     "Docker request timed out",
+	"docker_error":       "Docker error",
+	"docker_read_only":   "The Docker socket is read-only",
+	"docker_busy":        "Docker is slow to answer; try again shortly",
+}
+
+// Hints are matched to their code by text, which availabilityHint returns
+var hintCodes = map[string]string{
+	"container is being removed": "holder_removing",
+	"container is restarting":    "holder_restarting",
+	"container is unhealthy":     "holder_unhealthy",
+}
+
+type MessageCatalogResponse struct {
+	Lang     string            `json:"lang"`
+	Messages map[string]string `json:"messages"`
+}
+
+// handleMessages serves the catalog in the negotiated language, for
+// clients that render their own text and only need a fallback
+func (s *Server) handleMessages(w http.ResponseWriter, r *http.Request) {
+	resp := MessageCatalogResponse{Lang: w.Header().Get("Content-Language"), Messages: make(map[string]string, len(messageCatalog))}
+	for code, text := range messageCatalog {
+		resp.Messages[code] = localize(w, text)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// TestMessageCatalogComplete makes sure no code reaches clients without a
+// catalog entry, and that every entry has a French text
+func TestMessageCatalogComplete(t *testing.T) {
+	patterns := []*regexp.Regexp{
+		regexp.MustCompile(`writeError\(w, [^,]+, "([a-z_]+)"`),
+		regexp.MustCompile(`ansibleFailure\(op, "([a-z_]+)"`),
+		regexp.MustCompile(`Code: +"([a-z_]+)"`),
+		regexp.MustCompile(`code, msg :?= "([a-z_]+)"`),
+		regexp.MustCompile(`http\.Status[A-Za-z]+, "([a-z_]+)", "`),
+	}
+	files, _ := filepath.Glob("*.go")
+	for _, file := range files {
+		if strings.HasSuffix(file, "_test.go") {
+			continue
+		}
+		src, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, re := range patterns {
+			for _, m := range re.FindAllStringSubmatch(string(src), -1) {
+				if _, ok := messageCatalog[m[1]]; !ok {
+					t.Errorf("%s: code %q is missing from messageCatalog", file, m[1])
+				}
+			}
+		}
+	}
+	for code, text := range messageCatalog {
+		if _, ok := catalogs["fr"][text]; !ok {
+			t.Errorf("No French text for %s (%q)", code, text)
+		}
+	}
+}
+
+func TestCodes(t *testing.T) {
+	router := SetupRouter(&Server{client: &MockDockerClient{}})
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/suggest?prefer=9000", nil))
+	var suggest SuggestResponse
+	json.NewDecoder(rr.Body).Decode(&suggest)
+	if suggest.Code != "preferred_port_free" {
+		t.Errorf("Expected preferred_port_free, got %+v", suggest)
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/messages?lang=fr", nil))
+	var catalog MessageCatalogResponse
+	json.NewDecoder(rr.Body).Decode(&catalog)
+	if catalog.Lang != "fr" || catalog.Messages["port_available"] != "Le port est disponible" || len(catalog.Messages) != len(messageCatalog) {
+		t.Errorf("Unexpected catalog: %s %d entries", catalog.Lang, len(catalog.Messages))
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// defaultMirrorOffsets gives 80 → 80, 8080 and 5432 → 5432, 13432
+var defaultMirrorOffsets = []int{0, 8000}
+
+func parseOffsets(s string) ([]int, error) {
+	var offsets []int
+	for _, f := range strings.Split(s, ",") {
+		if f = strings.TrimSpace(f); f == "" {
+			continue
+		}
+		n, err := strconv.Atoi(f)
+		if err != nil || n < 0 || n >= maxPort {
+			return nil, errors.New("Invalid offset " + f)
+		}
+		offsets = append(offsets, n)
+	}
+	if len(offsets) == 0 {
+		return nil, errors.New("No offsets given")
+	}
+	return offsets, nil
+}
+
+func mirrorOffsetsFromEnv() []int {
+	if v := os.Getenv("QUAYCHECK_MIRROR_OFFSETS"); v != "" {
+		if offsets, err := parseOffsets(v); err == nil {
+			return offsets
+		}
+	}
+	return defaultMirrorOffsets
+}
+
+// suggestMirror tries to give the host the same port as the container, or
+// the container port plus one of offsets, in order. When all are taken it
+// counts up from the last candidate (80 → 8080 → 8081...). Candidates
+// outside [lo, hi] are skipped. It returns -1 if nothing fits.
+func suggestMirror(ix *PortIndex, internal int, offsets []int, lo, hi int) int {
+	last := -1
+	for _, off := range offsets {
+		p := internal + off
+		if p < lo || p > hi {
+			continue
+		}
+		if !ix.Used(p) {
+			return p
+		}
+		last = p
+	}
+	if last == -1 {
+		return -1
+	}
+	if p := ix.NextFree(last); p <= hi {
+		return p
+	}
+	return -1
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+)
+
+func TestSuggestMirror(t *testing.T) {
+	ix := buildPortIndex([]ContainerData{{State: "running", Ports: []PortMapping{
+		{PublicPort: 80}, {PublicPort: 8080}, {PublicPort: 8081}, {PublicPort: 5432},
+	}}})
+
+	tests := []struct {
+		internal int
+		offsets  []int
+		lo, hi   int
+		want     int
+	}{
+		{3000, defaultMirrorOffsets, 1, maxPort, 3000},
+		{5432, defaultMirrorOffsets, 1, maxPort, 13432},
+		{80, defaultMirrorOffsets, 1, maxPort, 8082},
+		{80, defaultMirrorOffsets, 1024, maxPort, 8082},
+		{80, []int{0}, 1024, maxPort, -1},
+		{80, defaultMirrorOffsets, 1, 8081, -1},
+		{5432, []int{0, 10000}, 1, maxPort, 15432},
+	}
+	for _, tt := range tests {
+		if got := suggestMirror(ix, tt.internal, tt.offsets, tt.lo, tt.hi); got != tt.want {
+			t.Errorf("suggestMirror(%d, %v, %d, %d) = %d, want %d", tt.internal, tt.offsets, tt.lo, tt.hi, got, tt.want)
+		}
+	}
+}
+
+func TestHandleSuggestInternal(t *testing.T) {
+	server := &Server{client: &MockDockerClient{Containers: []types.Container{
+		{State: "running", Ports: []types.Port{{PublicPort: 5432}}},
+	}}}
+
+	tests := []struct {
+		query        string
+		status       int
+		expectedPort int
+	}{
+		{"internal=5432", 200, 13432},
+		{"internal=5432&offsets=0,1", 200, 5433},
+		{"internal=6379", 200, 6379},
+		{"internal=5432&offsets=x", 400, 0},
+		{"internal=5432&prefer=8000", 400, 0},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		server.handleSuggest(w, httptest.NewRequest("GET", "/api/suggest?"+tt.query, nil))
+		if w.Code != tt.status {
+			t.Errorf("%s: expected status %d, got %d", tt.query, tt.status, w.Code)
+			continue
+		}
+		var result SuggestResponse
+		json.NewDecoder(w.Body).Decode(&result)
+		if tt.status == 200 && result.Port != tt.expectedPort {
+			t.Errorf("%s: expected port %d, got %d", tt.query, tt.expectedPort, result.Port)
+		}
+	}
+}
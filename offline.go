@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+// offlineSnapshot is an inventory exported from /api/ports, served in place
+// of Docker's by `quaycheck serve --snapshot`. It is taken as of the file's
+// modification time, so responses say how old it is.
+type offlineSnapshot struct {
+	Path string
+	snap Snapshot
+}
+
+func loadOfflineSnapshot(path string) (*offlineSnapshot, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	var containers []ContainerData
+	if err := json.Unmarshal(data, &containers); err != nil {
+		return nil, fmt.Errorf("%s: expected the JSON array /api/ports returns: %w", path, err)
+	}
+	return &offlineSnapshot{Path: path, snap: Snapshot{
+		Containers: containers,
+		Index:      buildPortIndex(containers),
+		TakenAt:    info.ModTime(),
+		encoding:   newSnapshotEncoding(containers),
+	}}, nil
+}
+
+// serveFlags parses the arguments of `quaycheck serve`
+func serveFlags(args []string) (snapshot string, err error) {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	fs.StringVar(&snapshot, "snapshot", "", "serve this exported /api/ports JSON instead of connecting to Docker")
+	err = fs.Parse(args)
+	return snapshot, err
+}
+
+// runOffline serves an exported snapshot with no Docker connection and no
+// state: for looking back at an incident, or trying the UI without a
+// Docker host. Reservations and everything else that changes state are
+// off; the reservations in the export still show and still hold their ports.
+func runOffline(path string) {
+	offline, err := loadOfflineSnapshot(path)
+	if err != nil {
+		log.Fatalf("Error loading snapshot: %v", err)
+	}
+	server := &Server{
+		offline:       offline,
+		staleAfter:    staleAfterFromEnv(),
+		countCreated:  countCreatedFromEnv(),
+		mirrorOffsets: mirrorOffsetsFromEnv(),
+		presets:       presetsFromEnv(),
+		widgetOrigins: widgetOriginsFromEnv(),
+		frameOrigins:  frameOriginsFromEnv(),
+		tokens:        tokensFromEnv(),
+		sessions:      newSessionStore(sessionTTLFromEnv()),
+		environments:  environmentsFromEnv(),
+		idleDays:      idleDaysFromEnv(),
+	}
+	mux := SetupRouter(server)
+
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8080"
+	}
+	log.Printf("Serving %d entries from %s, taken %s, on port %s...", len(offline.snap.Containers), path, offline.snap.TakenAt.Format(time.RFC3339), port)
+	if err := http.ListenAndServe(":"+port, mux); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestOfflineSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ports.json")
+	data, _ := json.Marshal([]ContainerData{
+		{ID: "abc", Names: []string{"/web"}, State: "running", Ports: []PortMapping{{PrivatePort: 80, PublicPort: 8080, Type: "tcp"}}},
+		{ID: "reservation:grafana", Names: []string{"grafana"}, State: "reserved", Source: "reservations", Ports: []PortMapping{{PublicPort: 3000, Type: "tcp"}}},
+	})
+	os.WriteFile(path, data, 0o644)
+	taken := time.Now().Add(-time.Hour).Truncate(time.Second)
+	os.Chtimes(path, taken, taken)
+
+	offline, err := loadOfflineSnapshot(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	router := SetupRouter(&Server{offline: offline})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/check?port=3000", nil))
+	var check CheckResponse
+	json.NewDecoder(w.Body).Decode(&check)
+	if check.Available || check.Meta == nil || !check.Meta.Stale || !check.Meta.SnapshotTime.Equal(taken) {
+		t.Errorf("Expected port 3000 held as of the file's time, got %+v %+v", check, check.Meta)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/ports", nil))
+	var containers []ContainerData
+	json.NewDecoder(w.Body).Decode(&containers)
+	if len(containers) != 2 || containers[0].Source != "" || containers[1].Source != "reservations" {
+		t.Errorf("Expected the export back as it was, got %+v", containers)
+	}
+
+	// Nothing else may reach for Docker or the state database
+	for _, url := range []string{"/api/suggest", "/api/ranges", "/api/interfaces", "/api/stacks", "/api/hosts", "/api/recommendations", "/api/capabilities", "/readyz", "/metrics", "/api/export/report", "/api/reservations"} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+		if w.Code == 500 {
+			t.Errorf("%s: got %d %s", url, w.Code, w.Body)
+		}
+	}
+
+	if _, err := loadOfflineSnapshot(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("Expected an error for a missing file")
+	}
+}
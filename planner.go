@@ -0,0 +1,36 @@
+package main
+
+import "slices"
+
+// PlanRequest is a compose file to check against an inventory the caller
+// already has, such as the one the web UI last fetched from /api/ports
+type PlanRequest struct {
+	Compose    string          `json:"compose"`
+	Profiles   []string        `json:"profiles,omitempty"`
+	Containers []ContainerData `json:"containers"`
+	// IgnoreProject is a compose project about to be recreated, whose
+	// running containers don't count
+	IgnoreProject string `json:"ignore_project,omitempty"`
+}
+
+// PlanResult lists the ports a compose file publishes and those that
+// conflict, or why the file couldn't be read
+type PlanResult struct {
+	Ports     []ComposePort     `json:"ports"`
+	Conflicts []ComposeConflict `json:"conflicts"`
+	Error     string            `json:"error,omitempty"`
+}
+
+// plan runs what `quaycheck check` does on a compose file, without a
+// server: the web UI calls it in the browser through planner_js.go
+func plan(req PlanRequest) PlanResult {
+	ports, err := ParseCompose([]byte(req.Compose), req.Profiles)
+	if err != nil {
+		return PlanResult{Error: err.Error()}
+	}
+	containers := req.Containers
+	if req.IgnoreProject != "" {
+		containers = slices.DeleteFunc(slices.Clone(containers), func(c ContainerData) bool { return c.Project == req.IgnoreProject })
+	}
+	return PlanResult{Ports: ports, Conflicts: FindComposeConflicts(ports, containers)}
+}
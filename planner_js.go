@@ -0,0 +1,36 @@
+//go:build js && wasm
+
+// The planner is built on its own from the files it needs, as the server
+// doesn't build for js/wasm:
+//
+//	GOOS=js GOARCH=wasm go build -o static/planner.wasm planner_js.go planner.go compose.go inventory.go
+//
+// `make wasm` does that and copies Go's wasm_exec.js next to it.
+
+package main
+
+import (
+	"encoding/json"
+	"syscall/js"
+)
+
+// main registers quaycheckPlan(request) for the page: it takes a
+// PlanRequest as JSON and returns a PlanResult as JSON
+func main() {
+	js.Global().Set("quaycheckPlan", js.FuncOf(func(this js.Value, args []js.Value) any {
+		var req PlanRequest
+		if len(args) == 0 {
+			return planJSON(PlanResult{Error: "expected a request"})
+		}
+		if err := json.Unmarshal([]byte(args[0].String()), &req); err != nil {
+			return planJSON(PlanResult{Error: err.Error()})
+		}
+		return planJSON(plan(req))
+	}))
+	select {}
+}
+
+func planJSON(result PlanResult) string {
+	data, _ := json.Marshal(result)
+	return string(data)
+}
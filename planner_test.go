@@ -0,0 +1,30 @@
+package main
+
+import "testing"
+
+func TestPlan(t *testing.T) {
+	containers := []ContainerData{
+		{Names: []string{"/grafana"}, State: "running", Project: "monitoring", Ports: []PortMapping{{PublicPort: 3000, Type: "tcp"}}},
+	}
+	compose := "services:\n  web:\n    ports:\n      - \"3000:3000\"\n      - \"8080:80\"\n"
+
+	result := plan(PlanRequest{Compose: compose, Containers: containers})
+	if result.Error != "" || len(result.Ports) != 2 {
+		t.Fatalf("Expected two ports, got %+v", result)
+	}
+	if len(result.Conflicts) != 1 || result.Conflicts[0].Code != "port_in_use" || result.Conflicts[0].Port.PublicPort != 3000 {
+		t.Errorf("Expected port 3000 in use, got %+v", result.Conflicts)
+	}
+
+	result = plan(PlanRequest{Compose: compose, Containers: containers, IgnoreProject: "monitoring"})
+	if len(result.Conflicts) != 0 {
+		t.Errorf("Expected the ignored project not to conflict, got %+v", result.Conflicts)
+	}
+	if len(containers) != 1 {
+		t.Error("Expected the caller's containers to be left alone")
+	}
+
+	if result := plan(PlanRequest{Compose: "services: ["}); result.Error == "" {
+		t.Error("Expected an error for invalid YAML")
+	}
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"encoding/json"
+	"math/bits"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+)
+
+const maxPort = 65535
+
+// PortIndex is a bitmap of used host ports plus the sorted list of free
+// ranges between them. It is built once per snapshot so lookups, suggestions
+// and range queries don't rescan the inventory on every request.
+type PortIndex struct {
+	bits [(maxPort + 64) / 64]uint64
+	free []PortRange
+}
+
+func buildPortIndex(containers []ContainerData) *PortIndex {
+	return buildPortIndexFunc(containers, indexHolds)
+}
+
+// indexHolds reports whether c's ports go into a snapshot's index: those of
+// entries on this host holding them
+func indexHolds(c ContainerData) bool {
+	return occupiesPorts(c.State) && hostOf(c) == localHost
+}
+
+// portHeld reports whether any entry the index counts publishes port
+func portHeld(containers []ContainerData, port int) bool {
+	return slices.ContainsFunc(containers, func(c ContainerData) bool {
+		return indexHolds(c) && slices.ContainsFunc(c.Ports, func(p PortMapping) bool { return int(p.PublicPort) == port })
+	})
+}
+
+// buildPortIndexFunc indexes the ports of the entries for which holds is true
+func buildPortIndexFunc(containers []ContainerData, holds func(ContainerData) bool) *PortIndex {
+	ix := &PortIndex{}
+	for _, c := range containers {
+		if !holds(c) {
+			continue
+		}
+		for _, p := range c.Ports {
+			if p.PublicPort != 0 {
+				ix.set(int(p.PublicPort))
+			}
+		}
+	}
+	ix.rebuildFree()
+	return ix
+}
+
+func (ix *PortIndex) set(p int)   { ix.bits[p>>6] |= 1 << (p & 63) }
+func (ix *PortIndex) clear(p int) { ix.bits[p>>6] &^= 1 << (p & 63) }
+
+func (ix *PortIndex) rebuildFree() {
+	ix.free = ix.free[:0]
+	start := -1
+	for p := 1; p <= maxPort; p++ {
+		if p&63 == 0 && p+63 <= maxPort {
+			// Skip whole words that are entirely free or entirely used
+			switch ix.bits[p>>6] {
+			case 0:
+				if start == -1 {
+					start = p
+				}
+				p += 63
+				continue
+			case ^uint64(0):
+				if start != -1 {
+					ix.free = append(ix.free, PortRange{start, p - 1})
+					start = -1
+				}
+				p += 63
+				continue
+			}
+		}
+		if ix.Used(p) {
+			if start != -1 {
+				ix.free = append(ix.free, PortRange{start, p - 1})
+				start = -1
+			}
+		} else if start == -1 {
+			start = p
+		}
+	}
+	if start != -1 {
+		ix.free = append(ix.free, PortRange{start, maxPort})
+	}
+}
+
+// Used reports whether port is taken; nothing is in a nil index
+func (ix *PortIndex) Used(port int) bool {
+	if ix == nil || port < 1 || port > maxPort {
+		return false
+	}
+	return ix.bits[port>>6]&(1<<(port&63)) != 0
+}
+
+// Count returns how many ports are taken
+func (ix *PortIndex) Count() int {
+	n := 0
+	for _, w := range ix.bits {
+		n += bits.OnesCount64(w)
+	}
+	return n
+}
+
+// rangeAtOrAfter returns the index of the first free range ending at or after port
+func (ix *PortIndex) rangeAtOrAfter(port int) int {
+	return sort.Search(len(ix.free), func(i int) bool { return ix.free[i].End >= port })
+}
+
+// NextFree returns the first free port from start, or -1
+func (ix *PortIndex) NextFree(start int) int {
+	if start < 1 {
+		start = 1
+	}
+	i := ix.rangeAtOrAfter(start)
+	if i == len(ix.free) {
+		return -1
+	}
+	return max(start, ix.free[i].Start)
+}
+
+// Nearest returns the free port in [lo, hi] closest to port, which must lie
+// in that range, preferring the higher one on a tie, or -1
+func (ix *PortIndex) Nearest(port, lo, hi int) int {
+	up, down := -1, -1
+	i := ix.rangeAtOrAfter(port)
+	if i < len(ix.free) && max(port, ix.free[i].Start) <= hi {
+		up = max(port, ix.free[i].Start)
+	}
+	if i > 0 && ix.free[i-1].End >= lo {
+		down = ix.free[i-1].End
+	}
+	switch {
+	case down == -1:
+		return up
+	case up == -1 || port-down < up-port:
+		return down
+	}
+	return up
+}
+
+// FreeRanges returns the free ranges clipped to [lo, hi]
+func (ix *PortIndex) FreeRanges(lo, hi int) []PortRange {
+	result := []PortRange{}
+	for i := ix.rangeAtOrAfter(lo); i < len(ix.free) && ix.free[i].Start <= hi; i++ {
+		result = append(result, PortRange{max(lo, ix.free[i].Start), min(hi, ix.free[i].End)})
+	}
+	return result
+}
+
+// Occupy marks port as used, splitting the free range it falls in
+func (ix *PortIndex) Occupy(port int) {
+	if port < 1 || port > maxPort || ix.Used(port) {
+		return
+	}
+	ix.set(port)
+
+	i := ix.rangeAtOrAfter(port)
+	r := ix.free[i]
+	switch {
+	case r.Start == port && r.End == port:
+		ix.free = append(ix.free[:i], ix.free[i+1:]...)
+	case r.Start == port:
+		ix.free[i].Start++
+	case r.End == port:
+		ix.free[i].End--
+	default:
+		ix.free = append(ix.free, PortRange{})
+		copy(ix.free[i+2:], ix.free[i+1:])
+		ix.free[i] = PortRange{r.Start, port - 1}
+		ix.free[i+1] = PortRange{port + 1, r.End}
+	}
+}
+
+// Release marks port as free, merging it with neighbouring free ranges
+func (ix *PortIndex) Release(port int) {
+	if port < 1 || port > maxPort || !ix.Used(port) {
+		return
+	}
+	ix.clear(port)
+
+	i := ix.rangeAtOrAfter(port)
+	joinsPrev := i > 0 && ix.free[i-1].End == port-1
+	joinsNext := i < len(ix.free) && ix.free[i].Start == port+1
+	switch {
+	case joinsPrev && joinsNext:
+		ix.free[i-1].End = ix.free[i].End
+		ix.free = append(ix.free[:i], ix.free[i+1:]...)
+	case joinsPrev:
+		ix.free[i-1].End = port
+	case joinsNext:
+		ix.free[i].Start = port
+	default:
+		ix.free = append(ix.free, PortRange{})
+		copy(ix.free[i+1:], ix.free[i:])
+		ix.free[i] = PortRange{port, port}
+	}
+}
+
+// Clone returns a copy that can be modified; a nil index clones to an empty one
+func (ix *PortIndex) Clone() *PortIndex {
+	if ix == nil {
+		return buildPortIndex(nil)
+	}
+	c := &PortIndex{bits: ix.bits}
+	c.free = append([]PortRange(nil), ix.free...)
+	return c
+}
+
+type RangesResponse struct {
+	Start int           `json:"start"`
+	End   int           `json:"end"`
+	Free  []PortRange   `json:"free"`
+	Count int           `json:"free_count"`
+	Meta  *ResponseMeta `json:"meta,omitempty"`
+}
+
+// rangeFromRequest reads ?preset=, ?start= and ?end= (which override the
+// preset's bounds), defaulting to 1024-65535. It returns a message for
+// invalid parameters.
+func (s *Server) rangeFromRequest(r *http.Request) (PortRange, string) {
+	lo, hi := 1024, maxPort
+	preset, hasPreset, errMsg := s.presetRange(r)
+	if errMsg != "" {
+		return PortRange{}, errMsg
+	}
+	if hasPreset {
+		lo, hi = preset.Start, preset.End
+	}
+	if v := r.URL.Query().Get("start"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxPort {
+			return PortRange{}, "Invalid start parameter"
+		}
+		lo = n
+	}
+	if v := r.URL.Query().Get("end"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < lo || n > maxPort {
+			return PortRange{}, "Invalid end parameter"
+		}
+		hi = n
+	}
+	return PortRange{Start: lo, End: hi}, ""
+}
+
+func (s *Server) handleRanges(w http.ResponseWriter, r *http.Request) {
+	pr, errMsg := s.rangeFromRequest(r)
+	if errMsg != "" {
+		writeError(w, http.StatusBadRequest, "invalid_param", errMsg)
+		return
+	}
+	lo, hi := pr.Start, pr.End
+	usage, err := s.usageFromRequest(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_param", err.Error())
+		return
+	}
+
+	snap, err := s.loadSnapshot(r.Context())
+	if err != nil {
+		status, code, msg := classifyDockerError(err)
+		writeError(w, status, code, msg)
+		return
+	}
+
+	free := usage.index(snap).FreeRanges(lo, hi)
+	count := 0
+	for _, fr := range free {
+		count += fr.End - fr.Start + 1
+	}
+
+	s.writeSnapshotHeaders(w, snap)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(RangesResponse{Start: lo, End: hi, Free: free, Count: count, Meta: s.snapshotMeta(snap)})
+}
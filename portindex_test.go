@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"math/rand"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+)
+
+func TestPortIndexMatchesMap(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	var containers []ContainerData
+	for i := 0; i < 500; i++ {
+		port := uint16(1 + rng.Intn(maxPort))
+		containers = append(containers, ContainerData{State: "running", Ports: []PortMapping{{PublicPort: port}}})
+	}
+	// A fully used word exercises the fast path
+	for p := 64 * 200; p < 64*201; p++ {
+		containers = append(containers, ContainerData{State: "running", Ports: []PortMapping{{PublicPort: uint16(p)}}})
+	}
+
+	ix := buildPortIndex(containers)
+	used := make(map[int]bool)
+	for _, c := range containers {
+		used[int(c.Ports[0].PublicPort)] = true
+	}
+
+	for p := 1; p <= maxPort; p++ {
+		if ix.Used(p) != used[p] {
+			t.Fatalf("Port %d: index says used=%v, map says %v", p, ix.Used(p), used[p])
+		}
+	}
+	for _, start := range []int{1, 1024, 8000, 64 * 200, 65535} {
+		want := -1
+		for p := start; p <= maxPort; p++ {
+			if !used[p] {
+				want = p
+				break
+			}
+		}
+		if got := ix.NextFree(start); got != want {
+			t.Errorf("NextFree(%d) = %d, want %d", start, got, want)
+		}
+	}
+	if ix.Count() != len(used) {
+		t.Errorf("Count() = %d, want %d", ix.Count(), len(used))
+	}
+}
+
+func TestPortIndexOccupyRelease(t *testing.T) {
+	ix := buildPortIndex(nil)
+	if len(ix.free) != 1 || ix.free[0] != (PortRange{1, maxPort}) {
+		t.Fatalf("Expected one free range, got %+v", ix.free)
+	}
+
+	ix.Occupy(8000)
+	ix.Occupy(8001)
+	ix.Occupy(1)
+	ix.Occupy(maxPort)
+	if ix.NextFree(8000) != 8002 || ix.NextFree(1) != 2 || ix.NextFree(maxPort) != -1 {
+		t.Errorf("Unexpected NextFree after occupy: %+v", ix.free)
+	}
+	free := ix.FreeRanges(7990, 8010)
+	if len(free) != 2 || free[0] != (PortRange{7990, 7999}) || free[1] != (PortRange{8002, 8010}) {
+		t.Errorf("Unexpected free ranges: %+v", free)
+	}
+
+	ix.Release(8000)
+	ix.Release(8001)
+	ix.Release(1)
+	ix.Release(maxPort)
+	if len(ix.free) != 1 || ix.free[0] != (PortRange{1, maxPort}) {
+		t.Errorf("Expected ranges to merge back, got %+v", ix.free)
+	}
+}
+
+func TestPortIndexNearest(t *testing.T) {
+	ix := buildPortIndex(nil)
+	for _, p := range []int{8078, 8079, 8080, 8081, 8082, 8083} {
+		ix.Occupy(p)
+	}
+
+	tests := []struct {
+		port, lo, hi, want int
+	}{
+		{8090, 1024, maxPort, 8090},
+		{8080, 1024, maxPort, 8077},
+		{8081, 1024, maxPort, 8084},
+		{8080, 8078, maxPort, 8084},
+		{8080, 1024, 8083, 8077},
+		{8080, 8078, 8083, -1},
+	}
+	for _, tt := range tests {
+		if got := ix.Nearest(tt.port, tt.lo, tt.hi); got != tt.want {
+			t.Errorf("Nearest(%d, %d, %d) = %d, want %d", tt.port, tt.lo, tt.hi, got, tt.want)
+		}
+	}
+}
+
+func TestHandleRanges(t *testing.T) {
+	server := &Server{client: &MockDockerClient{Containers: []types.Container{
+		{State: "running", Ports: []types.Port{{PublicPort: 8001}, {PublicPort: 8005}}},
+	}}}
+
+	req := httptest.NewRequest("GET", "/api/ranges?start=8000&end=8009", nil)
+	w := httptest.NewRecorder()
+	server.handleRanges(w, req)
+
+	var result RangesResponse
+	json.NewDecoder(w.Body).Decode(&result)
+	if result.Count != 8 || len(result.Free) != 3 || result.Free[1] != (PortRange{8002, 8004}) {
+		t.Errorf("Unexpected ranges: %+v", result)
+	}
+
+	req = httptest.NewRequest("GET", "/api/ranges?start=9000&end=8000", nil)
+	w = httptest.NewRecorder()
+	server.handleRanges(w, req)
+	if w.Code != 400 {
+		t.Errorf("Expected 400 for inverted range, got %d", w.Code)
+	}
+}
+
+func BenchmarkSuggest(b *testing.B) {
+	var containers []ContainerData
+	for p := 8000; p < 9000; p++ {
+		containers = append(containers, ContainerData{State: "running", Ports: []PortMapping{{PublicPort: uint16(p)}}})
+	}
+	ix := buildPortIndex(containers)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ix.NextFree(8000)
+	}
+}
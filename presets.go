@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"quaycheck/internal/portspec"
+)
+
+// parsePresets reads named suggest ranges, e.g. "web=8000-8999,db=15000-15999"
+func parsePresets(s string) (map[string]PortRange, error) {
+	presets := make(map[string]PortRange)
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, spec, ok := strings.Cut(entry, "=")
+		if !ok {
+			name, spec, ok = strings.Cut(entry, ":")
+		}
+		name = strings.TrimSpace(name)
+		lo, hi, valid := portspec.Range(strings.TrimSpace(spec), "-")
+		if !ok || name == "" || !valid {
+			return nil, fmt.Errorf("invalid preset %q, expected name=start-end", entry)
+		}
+		presets[name] = PortRange{lo, hi}
+	}
+	return presets, nil
+}
+
+func presetsFromEnv() map[string]PortRange {
+	v := os.Getenv("QUAYCHECK_PRESETS")
+	if v == "" {
+		return nil
+	}
+	presets, err := parsePresets(v)
+	if err != nil {
+		log.Printf("Presets disabled: %v", err)
+		return nil
+	}
+	return presets
+}
+
+// presetRange looks up the preset query parameter. It returns ok=false with
+// an error message when the preset is unknown.
+func (s *Server) presetRange(r *http.Request) (PortRange, bool, string) {
+	name := r.URL.Query().Get("preset")
+	if name == "" {
+		return PortRange{}, false, ""
+	}
+	pr, ok := s.config().Presets[name]
+	if !ok {
+		return PortRange{}, false, fmt.Sprintf("Unknown preset %q", name)
+	}
+	return pr, true, ""
+}
+
+type PresetInfo struct {
+	Name string `json:"name"`
+	PortRange
+}
+
+func (s *Server) handlePresets(w http.ResponseWriter, r *http.Request) {
+	presets := s.config().Presets
+	list := make([]PresetInfo, 0, len(presets))
+	for name, pr := range presets {
+		list = append(list, PresetInfo{Name: name, PortRange: pr})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Start < list[j].Start })
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+)
+
+func TestParsePresets(t *testing.T) {
+	presets, err := parsePresets("web=8000-8999, db: 15000-15999")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if presets["web"] != (PortRange{8000, 8999}) || presets["db"] != (PortRange{15000, 15999}) {
+		t.Errorf("Unexpected presets: %+v", presets)
+	}
+
+	for _, bad := range []string{"web", "web=9000-8000", "=1-2", "web=abc"} {
+		if _, err := parsePresets(bad); err == nil {
+			t.Errorf("Expected an error for %q", bad)
+		}
+	}
+}
+
+func TestSuggestPreset(t *testing.T) {
+	server := &Server{
+		client:  &MockDockerClient{Containers: []types.Container{{State: "running", Ports: []types.Port{{PublicPort: 15000}}}}},
+		presets: map[string]PortRange{"db": {15000, 15001}, "tiny": {15000, 15000}},
+	}
+
+	tests := []struct {
+		query        string
+		status       int
+		expectedPort int
+	}{
+		{"preset=db", 200, 15001},
+		{"preset=tiny", 200, -1},
+		{"preset=db&prefer=15000", 200, 15001},
+		{"preset=nope", 400, 0},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		server.handleSuggest(w, httptest.NewRequest("GET", "/api/suggest?"+tt.query, nil))
+		if w.Code != tt.status {
+			t.Errorf("%s: expected status %d, got %d", tt.query, tt.status, w.Code)
+			continue
+		}
+		var result SuggestResponse
+		json.NewDecoder(w.Body).Decode(&result)
+		if tt.status == 200 && result.Port != tt.expectedPort {
+			t.Errorf("%s: expected %d, got %d", tt.query, tt.expectedPort, result.Port)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	server.handleBatchSuggest(w, httptest.NewRequest("POST", "/api/suggest/batch", strings.NewReader(`{"items":[{"name":"pg","preset":"db"}]}`)))
+	var batch BatchResponse
+	json.NewDecoder(w.Body).Decode(&batch)
+	if len(batch.Allocations) != 1 || batch.Allocations[0].Port != 15001 {
+		t.Errorf("Expected the batch item to use the preset, got %d %+v", w.Code, batch)
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"quaycheck/internal/portspec"
+)
+
+// ProbeTarget is a remote host and the ports to probe on it
+type ProbeTarget struct {
+	Host  string
+	Ports []int
+}
+
+// probeDial is swapped out in tests
+var probeDial = func(ctx context.Context, addr string, timeout time.Duration) error {
+	d := net.Dialer{Timeout: timeout}
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// ProbeSource TCP-connects to remote hosts on a schedule, for appliances and
+// VMs where no agent can run. Results are served from the last completed scan.
+type ProbeSource struct {
+	Targets  []ProbeTarget
+	Interval time.Duration
+	Timeout  time.Duration
+	// Pool bounds how many connects run at once; a default one when nil
+	Pool *probePool
+
+	mu      sync.RWMutex
+	results []ContainerData
+}
+
+// probePool bounds concurrent probes overall and per host, so a target
+// with thousands of ports doesn't take a burst of SYNs all at once
+type probePool struct {
+	global  chan struct{}
+	perHost int
+
+	mu    sync.Mutex
+	hosts map[string]chan struct{}
+}
+
+func newProbePool(global, perHost int) *probePool {
+	return &probePool{global: make(chan struct{}, global), perHost: perHost, hosts: make(map[string]chan struct{})}
+}
+
+// probePoolFromEnv reads QUAYCHECK_PROBE_CONCURRENCY, the connects in
+// flight across all targets (32 by default), and
+// QUAYCHECK_PROBE_HOST_CONCURRENCY, those to any one host (8)
+func probePoolFromEnv() *probePool {
+	global, perHost := 32, 8
+	if n, err := strconv.Atoi(os.Getenv("QUAYCHECK_PROBE_CONCURRENCY")); err == nil && n > 0 {
+		global = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("QUAYCHECK_PROBE_HOST_CONCURRENCY")); err == nil && n > 0 {
+		perHost = n
+	}
+	return newProbePool(global, min(perHost, global))
+}
+
+// acquire waits for a slot for host, and returns the function releasing
+// it. The host's slot is taken first so that a busy host doesn't hold
+// global slots others could use.
+func (p *probePool) acquire(ctx context.Context, host string) (func(), error) {
+	p.mu.Lock()
+	hostSlots, ok := p.hosts[host]
+	if !ok {
+		hostSlots = make(chan struct{}, p.perHost)
+		p.hosts[host] = hostSlots
+	}
+	p.mu.Unlock()
+
+	select {
+	case hostSlots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	select {
+	case p.global <- struct{}{}:
+	case <-ctx.Done():
+		<-hostSlots
+		return nil, ctx.Err()
+	}
+	return func() {
+		<-p.global
+		<-hostSlots
+	}, nil
+}
+
+func (s *ProbeSource) Name() string { return "remote" }
+
+func (s *ProbeSource) Containers(ctx context.Context) ([]ContainerData, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]ContainerData(nil), s.results...), nil
+}
+
+// Run scans immediately, then every Interval until ctx is done
+func (s *ProbeSource) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+	for {
+		s.scan(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *ProbeSource) scan(ctx context.Context) {
+	pool := s.Pool
+	if pool == nil {
+		pool = newProbePool(32, 8)
+	}
+	timeout := s.Timeout
+	if timeout <= 0 {
+		timeout = 2 * time.Second
+	}
+
+	open := make([][]int, len(s.Targets))
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	// One goroutine per target hands its ports out as slots free up, so
+	// only the connects in flight have goroutines of their own
+	for i, target := range s.Targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var dials sync.WaitGroup
+			for _, port := range target.Ports {
+				release, err := pool.acquire(ctx, target.Host)
+				if err != nil {
+					break
+				}
+				dials.Add(1)
+				go func() {
+					defer dials.Done()
+					defer release()
+					addr := net.JoinHostPort(target.Host, strconv.Itoa(port))
+					if probeDial(ctx, addr, timeout) == nil {
+						mu.Lock()
+						open[i] = append(open[i], port)
+						mu.Unlock()
+					}
+				}()
+			}
+			dials.Wait()
+		}()
+	}
+	wg.Wait()
+
+	var results []ContainerData
+	for i, target := range s.Targets {
+		sort.Ints(open[i])
+		entry := ContainerData{
+			ID:     "remote:" + target.Host,
+			Names:  []string{target.Host},
+			State:  "running",
+			Source: s.Name(),
+		}
+		for _, port := range open[i] {
+			entry.Ports = append(entry.Ports, PortMapping{
+				PrivatePort: uint16(port),
+				PublicPort:  uint16(port),
+				Type:        "tcp",
+				IP:          target.Host,
+			})
+		}
+		results = append(results, entry)
+	}
+
+	s.mu.Lock()
+	s.results = results
+	s.mu.Unlock()
+}
+
+// parseProbeTargets parses "host:22,80,8000-8100;other:5432"
+func parseProbeTargets(spec string) ([]ProbeTarget, error) {
+	var targets []ProbeTarget
+	for _, item := range strings.FieldsFunc(spec, func(r rune) bool { return r == ';' || r == ' ' }) {
+		idx := strings.LastIndex(item, ":")
+		if idx <= 0 {
+			return nil, fmt.Errorf("invalid probe target %q: expected host:ports", item)
+		}
+		host := strings.Trim(item[:idx], "[]")
+
+		var ports []int
+		for _, part := range strings.Split(item[idx+1:], ",") {
+			lo, hi, ok := portspec.Range(part, "-")
+			if !ok {
+				return nil, fmt.Errorf("invalid port %q in probe target %q", part, item)
+			}
+			for p := lo; p <= hi; p++ {
+				ports = append(ports, p)
+			}
+		}
+		targets = append(targets, ProbeTarget{Host: host, Ports: ports})
+	}
+	return targets, nil
+}
+
+func newProbeSourceFromEnv(spec, interval string) *ProbeSource {
+	targets, err := parseProbeTargets(spec)
+	if err != nil {
+		log.Printf("Remote probing disabled: %v", err)
+		return nil
+	}
+	every := time.Minute
+	if d, err := time.ParseDuration(interval); err == nil && d > 0 {
+		every = d
+	}
+	timeout := 2 * time.Second
+	if d, err := time.ParseDuration(os.Getenv("QUAYCHECK_PROBE_TIMEOUT")); err == nil && d > 0 {
+		timeout = d
+	}
+	return &ProbeSource{Targets: targets, Interval: every, Timeout: timeout, Pool: probePoolFromEnv()}
+}
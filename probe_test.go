@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseProbeTargets(t *testing.T) {
+	targets, err := parseProbeTargets("nas.lan:22,80,8000-8002;[fd00::1]:443")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(targets) != 2 {
+		t.Fatalf("Expected 2 targets, got %d", len(targets))
+	}
+	if targets[0].Host != "nas.lan" || len(targets[0].Ports) != 5 {
+		t.Errorf("Unexpected first target: %+v", targets[0])
+	}
+	if targets[1].Host != "fd00::1" || targets[1].Ports[0] != 443 {
+		t.Errorf("Unexpected second target: %+v", targets[1])
+	}
+
+	for _, bad := range []string{"nas.lan", "nas.lan:abc", "nas.lan:90-80"} {
+		if _, err := parseProbeTargets(bad); err == nil {
+			t.Errorf("Expected error for %q", bad)
+		}
+	}
+}
+
+func TestProbeSourceScan(t *testing.T) {
+	orig := probeDial
+	defer func() { probeDial = orig }()
+	probeDial = func(ctx context.Context, addr string, timeout time.Duration) error {
+		if addr == "nas.lan:80" {
+			return nil
+		}
+		return errors.New("connection refused")
+	}
+
+	src := &ProbeSource{Targets: []ProbeTarget{{Host: "nas.lan", Ports: []int{22, 80}}}}
+	src.scan(context.Background())
+
+	entries, _ := src.Containers(context.Background())
+	if len(entries) != 1 {
+		t.Fatalf("Expected 1 entry, got %d", len(entries))
+	}
+	if len(entries[0].Ports) != 1 || entries[0].Ports[0].PublicPort != 80 {
+		t.Errorf("Expected only port 80 open, got %+v", entries[0].Ports)
+	}
+	if entries[0].Source != "remote" {
+		t.Errorf("Expected source remote, got %s", entries[0].Source)
+	}
+}
+
+func TestProbePoolLimits(t *testing.T) {
+	orig := probeDial
+	defer func() { probeDial = orig }()
+	var (
+		mu                sync.Mutex
+		inFlight, peak    int
+		perHost, hostPeak = map[string]int{}, map[string]int{}
+	)
+	probeDial = func(ctx context.Context, addr string, timeout time.Duration) error {
+		host, _, _ := net.SplitHostPort(addr)
+		mu.Lock()
+		inFlight++
+		perHost[host]++
+		peak = max(peak, inFlight)
+		hostPeak[host] = max(hostPeak[host], perHost[host])
+		mu.Unlock()
+		time.Sleep(time.Millisecond)
+		mu.Lock()
+		inFlight--
+		perHost[host]--
+		mu.Unlock()
+		return nil
+	}
+
+	ports := make([]int, 50)
+	for i := range ports {
+		ports[i] = 1000 + i
+	}
+	src := &ProbeSource{
+		Targets: []ProbeTarget{{Host: "a", Ports: ports}, {Host: "b", Ports: ports}, {Host: "c", Ports: ports}},
+		Pool:    newProbePool(5, 2),
+	}
+	src.scan(context.Background())
+
+	if peak > 5 {
+		t.Errorf("Expected at most 5 probes in flight, saw %d", peak)
+	}
+	for host, n := range hostPeak {
+		if n > 2 {
+			t.Errorf("Expected at most 2 probes in flight to %s, saw %d", host, n)
+		}
+	}
+	entries, _ := src.Containers(context.Background())
+	for _, e := range entries {
+		if len(e.Ports) != len(ports) {
+			t.Errorf("Expected all %d ports of %s probed, got %d", len(ports), e.Names[0], len(e.Ports))
+		}
+	}
+}
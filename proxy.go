@@ -0,0 +1,571 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ProxyConfig is what a reverse proxy's config says it listens on and
+// forwards to
+type ProxyConfig struct {
+	Listens   []ProxyListen   `json:"listens"`
+	Upstreams []ProxyUpstream `json:"upstreams"`
+}
+
+// ProxyListen is a host port the proxy binds
+type ProxyListen struct {
+	Site string `json:"site,omitempty"`
+	Line int    `json:"line"`
+	IP   string `json:"ip,omitempty"`
+	Port int    `json:"port"`
+	Type string `json:"type"`
+}
+
+// ProxyUpstream is a host and port the proxy forwards to
+type ProxyUpstream struct {
+	Site string `json:"site,omitempty"`
+	Line int    `json:"line"`
+	Host string `json:"host"`
+	Port int    `json:"port"`
+}
+
+// ProxyFinding is a mismatch between a proxy config and what's running
+type ProxyFinding struct {
+	Site   string `json:"site,omitempty"`
+	Line   int    `json:"line"`
+	Host   string `json:"host,omitempty"`
+	Port   int    `json:"port"`
+	Code   string `json:"code"`
+	Reason string `json:"reason"`
+}
+
+// configToken is a word of a proxy config and the line it's on
+type configToken struct {
+	text string
+	line int
+}
+
+// tokenizeConfig splits nginx and Caddyfile syntax alike: words, quoted
+// strings, # comments, and ; { } standing alone. Braces within a word, as
+// in Caddy's {$PORT} placeholders, stay part of it.
+func tokenizeConfig(data []byte) ([]configToken, error) {
+	var tokens []configToken
+	line := 1
+	s := string(data)
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == '\n':
+			tokens = append(tokens, configToken{"\n", line})
+			line++
+			i++
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case c == '#':
+			for i < len(s) && s[i] != '\n' {
+				i++
+			}
+		case c == ';' || c == '}' || (c == '{' && (i+1 == len(s) || strings.ContainsRune(" \t\r\n", rune(s[i+1])))):
+			tokens = append(tokens, configToken{string(c), line})
+			i++
+		case c == '"' || c == '\'' || c == '`':
+			end := strings.IndexByte(s[i+1:], c)
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated quote", line)
+			}
+			text := s[i+1 : i+1+end]
+			tokens = append(tokens, configToken{text, line})
+			line += strings.Count(text, "\n")
+			i += end + 2
+		default:
+			start, nested := i, 0
+			for ; i < len(s) && !strings.ContainsRune(" \t\r\n;\"'", rune(s[i])); i++ {
+				if s[i] == '{' {
+					nested++
+				} else if s[i] == '}' {
+					if nested == 0 {
+						break
+					}
+					nested--
+				}
+			}
+			tokens = append(tokens, configToken{s[start:i], line})
+		}
+	}
+	return tokens, nil
+}
+
+// ParseNginx reads listen directives of server blocks, and where
+// proxy_pass, grpc_pass, fastcgi_pass and uwsgi_pass forward to, through
+// upstream blocks when they name one. A server without listen gets port 80.
+func ParseNginx(data []byte) (ProxyConfig, error) {
+	tokens, err := tokenizeConfig(data)
+	if err != nil {
+		return ProxyConfig{}, err
+	}
+	var cfg ProxyConfig
+	type pass struct {
+		site   string
+		target configToken
+	}
+	var passes []pass
+	pools := make(map[string][]configToken)
+
+	var stack []string
+	var directive []configToken
+	var site, pool string
+	serverLine, listened := 0, false
+	// Where the current server's listens and passes start, to name them
+	// after its server_name, wherever it comes
+	firstListen, firstPass := 0, 0
+	for _, t := range tokens {
+		switch t.text {
+		case "\n":
+			continue
+		case "{":
+			name := ""
+			if len(directive) > 0 {
+				name = directive[0].text
+			}
+			switch {
+			case name == "server" && !slices.Contains(stack, "server"):
+				site, serverLine, listened = "", t.line, false
+				firstListen, firstPass = len(cfg.Listens), len(passes)
+			case name == "upstream" && len(directive) > 1:
+				pool = directive[1].text
+			}
+			stack = append(stack, name)
+			directive = nil
+			continue
+		case "}":
+			if len(stack) == 0 {
+				return ProxyConfig{}, fmt.Errorf("line %d: unexpected }", t.line)
+			}
+			switch stack[len(stack)-1] {
+			case "server":
+				if !listened && !slices.Contains(stack[:len(stack)-1], "stream") {
+					cfg.Listens = append(cfg.Listens, ProxyListen{Line: serverLine, Port: 80, Type: "tcp"})
+				}
+				for i := firstListen; i < len(cfg.Listens); i++ {
+					cfg.Listens[i].Site = site
+				}
+				for i := firstPass; i < len(passes); i++ {
+					passes[i].site = site
+				}
+			case "upstream":
+				pool = ""
+			}
+			stack = stack[:len(stack)-1]
+			directive = nil
+			continue
+		case ";":
+		default:
+			directive = append(directive, t)
+			continue
+		}
+
+		if len(directive) < 2 {
+			directive = nil
+			continue
+		}
+		name, args := directive[0].text, directive[1:]
+		switch {
+		case name == "server_name" && site == "":
+			site = args[0].text
+		case name == "listen":
+			listened = true
+			l, ok, err := nginxListen(args)
+			if err != nil {
+				return ProxyConfig{}, fmt.Errorf("line %d: %w", directive[0].line, err)
+			}
+			if ok {
+				l.Line = directive[0].line
+				cfg.Listens = append(cfg.Listens, l)
+			}
+		case name == "server" && pool != "":
+			pools[pool] = append(pools[pool], args[0])
+		case name == "proxy_pass" || name == "grpc_pass" || name == "fastcgi_pass" || name == "uwsgi_pass":
+			passes = append(passes, pass{target: args[0]})
+		}
+		directive = nil
+	}
+	if len(stack) > 0 {
+		return ProxyConfig{}, fmt.Errorf("unclosed %s block", stack[len(stack)-1])
+	}
+
+	for _, p := range passes {
+		target := p.target.text
+		scheme, rest, ok := strings.Cut(target, "://")
+		if !ok {
+			scheme, rest = "", target
+		}
+		hostport, _, _ := strings.Cut(rest, "/")
+		if servers, ok := pools[hostport]; ok {
+			for _, s := range servers {
+				if u, ok := proxyUpstream(s.text, ""); ok {
+					u.Site, u.Line = p.site, s.line
+					cfg.Upstreams = append(cfg.Upstreams, u)
+				}
+			}
+			continue
+		}
+		if u, ok := proxyUpstream(hostport, scheme); ok {
+			u.Site, u.Line = p.site, p.target.line
+			cfg.Upstreams = append(cfg.Upstreams, u)
+		}
+	}
+	return cfg, nil
+}
+
+// nginxListen reads a listen directive's address and its udp flag; unix
+// sockets aren't ports
+func nginxListen(args []configToken) (ProxyListen, bool, error) {
+	addr := args[0].text
+	if strings.HasPrefix(addr, "unix:") {
+		return ProxyListen{}, false, nil
+	}
+	l := ProxyListen{Type: "tcp"}
+	for _, a := range args[1:] {
+		if a.text == "udp" || a.text == "quic" {
+			l.Type = "udp"
+		}
+	}
+	port := addr
+	if host, p, err := net.SplitHostPort(addr); err == nil {
+		l.IP, port = host, p
+	} else if !isDigits(addr) {
+		// A bare address listens on 80
+		l.IP, port = strings.Trim(addr, "[]"), "80"
+	}
+	n, err := strconv.Atoi(port)
+	if err != nil || n < 1 || n > maxPort {
+		return ProxyListen{}, false, fmt.Errorf("invalid listen %q", addr)
+	}
+	if l.IP == "*" {
+		l.IP = ""
+	}
+	l.Port = n
+	return l, true, nil
+}
+
+// ParseCaddyfile reads the ports each site address binds, 443 and 80 for a
+// bare domain, and where reverse_proxy forwards to. The global options
+// block and snippets are skipped.
+func ParseCaddyfile(data []byte) (ProxyConfig, error) {
+	tokens, err := tokenizeConfig(data)
+	if err != nil {
+		return ProxyConfig{}, err
+	}
+	var cfg ProxyConfig
+	var line []configToken
+	depth := 0
+	site := ""
+	skip := false
+	// inProxy is the depth of a reverse_proxy block, whose to lines list
+	// more upstreams
+	inProxy := 0
+
+	for _, t := range tokens {
+		switch t.text {
+		case "{":
+			switch {
+			case depth == 0 && len(line) == 0:
+				skip = true
+			case depth == 0 && (strings.HasPrefix(line[0].text, "(") || strings.HasPrefix(line[0].text, "&(")):
+				skip = true
+			case depth == 0:
+				site = caddySite(line)
+				for _, addr := range line {
+					cfg.Listens = append(cfg.Listens, caddyListens(strings.TrimSuffix(addr.text, ","), site, addr.line)...)
+				}
+			case !skip && len(line) > 0 && line[0].text == "reverse_proxy":
+				cfg.Upstreams = append(cfg.Upstreams, caddyUpstreams(line[1:], site)...)
+				inProxy = depth + 1
+			}
+			depth++
+			line = nil
+			continue
+		case "}":
+			depth--
+			if depth < 0 {
+				return ProxyConfig{}, fmt.Errorf("line %d: unexpected }", t.line)
+			}
+			if depth < inProxy {
+				inProxy = 0
+			}
+			if depth == 0 {
+				skip, site = false, ""
+			}
+			line = nil
+			continue
+		case "\n", ";":
+			// Site addresses may go on over several lines
+			if depth == 0 && len(line) > 0 && strings.HasSuffix(line[len(line)-1].text, ",") {
+				continue
+			}
+		default:
+			line = append(line, t)
+			continue
+		}
+
+		switch {
+		case len(line) == 0 || skip:
+		case depth == 0:
+			// A site without a block: one line of addresses
+			s := caddySite(line)
+			for _, addr := range line {
+				cfg.Listens = append(cfg.Listens, caddyListens(strings.TrimSuffix(addr.text, ","), s, addr.line)...)
+			}
+		case line[0].text == "reverse_proxy":
+			cfg.Upstreams = append(cfg.Upstreams, caddyUpstreams(line[1:], site)...)
+		case inProxy > 0 && depth == inProxy && line[0].text == "to":
+			cfg.Upstreams = append(cfg.Upstreams, caddyUpstreams(line[1:], site)...)
+		}
+		line = nil
+	}
+	if depth > 0 {
+		return ProxyConfig{}, fmt.Errorf("unclosed block")
+	}
+	return cfg, nil
+}
+
+func caddySite(addrs []configToken) string {
+	names := make([]string, len(addrs))
+	for i, a := range addrs {
+		names[i] = strings.TrimSuffix(a.text, ",")
+	}
+	return strings.Join(names, ", ")
+}
+
+// caddyListens maps a site address to the ports Caddy binds for it
+func caddyListens(addr, site string, line int) []ProxyListen {
+	if addr == "" {
+		return nil
+	}
+	scheme, rest, ok := strings.Cut(addr, "://")
+	if !ok {
+		scheme, rest = "", addr
+	}
+	hostport, _, _ := strings.Cut(rest, "/")
+	if i := strings.LastIndex(hostport, ":"); i >= 0 && isDigits(hostport[i+1:]) {
+		port, _ := strconv.Atoi(hostport[i+1:])
+		return []ProxyListen{{Site: site, Line: line, Port: port, Type: "tcp"}}
+	}
+	if scheme == "http" {
+		return []ProxyListen{{Site: site, Line: line, Port: 80, Type: "tcp"}}
+	}
+	// HTTPS, plus 80 for the redirect
+	return []ProxyListen{{Site: site, Line: line, Port: 443, Type: "tcp"}, {Site: site, Line: line, Port: 80, Type: "tcp"}}
+}
+
+// caddyUpstreams reads the upstreams of a reverse_proxy line, after any
+// matcher
+func caddyUpstreams(args []configToken, site string) []ProxyUpstream {
+	var result []ProxyUpstream
+	for i, a := range args {
+		if i == 0 && (strings.HasPrefix(a.text, "/") || strings.HasPrefix(a.text, "@") || a.text == "*") {
+			continue
+		}
+		scheme, rest, ok := strings.Cut(a.text, "://")
+		if !ok {
+			scheme, rest = "", a.text
+		}
+		if u, ok := proxyUpstream(rest, scheme); ok {
+			u.Site, u.Line = site, a.line
+			result = append(result, u)
+		}
+	}
+	return result
+}
+
+// proxyUpstream reads host:port, defaulting the port from the scheme.
+// Variables, unix sockets and names without a port aren't resolvable here.
+func proxyUpstream(hostport, scheme string) (ProxyUpstream, bool) {
+	if strings.ContainsAny(hostport, "${") || strings.HasPrefix(hostport, "unix:") || scheme == "unix" {
+		return ProxyUpstream{}, false
+	}
+	host, port, err := net.SplitHostPort(hostport)
+	if err != nil {
+		host = strings.Trim(hostport, "[]")
+		switch scheme {
+		case "http", "h2c":
+			port = "80"
+		case "https", "grpcs":
+			port = "443"
+		default:
+			return ProxyUpstream{}, false
+		}
+	}
+	n, err := strconv.Atoi(port)
+	if err != nil || n < 1 || n > maxPort {
+		return ProxyUpstream{}, false
+	}
+	if host == "" {
+		host = "localhost"
+	}
+	return ProxyUpstream{Host: host, Port: n}, true
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseProxyConfig reads a proxy config as format, "nginx" or "caddy", or
+// as the file name suggests when format is empty: a Caddyfile or
+// *.caddy is Caddy, anything else nginx
+func ParseProxyConfig(name, format string, data []byte) (ProxyConfig, error) {
+	if format == "" {
+		format = "nginx"
+		if base := filepath.Base(name); strings.EqualFold(base, "Caddyfile") || strings.HasSuffix(base, ".caddy") || strings.HasSuffix(base, ".Caddyfile") {
+			format = "caddy"
+		}
+	}
+	switch format {
+	case "nginx":
+		return ParseNginx(data)
+	case "caddy":
+		return ParseCaddyfile(data)
+	}
+	return ProxyConfig{}, fmt.Errorf("unknown proxy config format %q", format)
+}
+
+// localUpstream is true for upstream hosts that mean this machine, whose
+// ports come from container publications
+func localUpstream(host string) bool {
+	switch host {
+	case "localhost", "127.0.0.1", "::1", "0.0.0.0", "host.docker.internal", "host-gateway", "172.17.0.1":
+		return true
+	}
+	name, err := os.Hostname()
+	return err == nil && strings.EqualFold(host, name)
+}
+
+// AnalyzeProxy checks a proxy config against this host's containers: a
+// port it listens on held by anything but the proxy itself, an upstream on
+// this host nothing publishes, and an upstream naming a container that
+// doesn't expose that port. Containers named in proxies are the proxy.
+func AnalyzeProxy(cfg ProxyConfig, containers []ContainerData, proxies []string) []ProxyFinding {
+	containers = localContainers(containers)
+	findings := []ProxyFinding{}
+	isProxy := func(c ContainerData) bool { return slices.Contains(proxies, containerName(c)) }
+
+	for _, l := range cfg.Listens {
+		for _, c := range containers {
+			if !occupiesPorts(c.State) || isProxy(c) || !publishes(c, l.Port) {
+				continue
+			}
+			findings = append(findings, ProxyFinding{
+				Site: l.Site, Line: l.Line, Port: l.Port, Code: "port_in_use",
+				Reason: fmt.Sprintf("the proxy listens on port %d, already in use by %s", l.Port, ownerLabel(c)),
+			})
+			break
+		}
+	}
+
+	for _, u := range cfg.Upstreams {
+		if f, ok := checkUpstream(u, containers); !ok {
+			findings = append(findings, f)
+		}
+	}
+	return findings
+}
+
+// checkUpstream reports whether something answers for u: a running
+// container publishing its port when it's on this host, or exposing it
+// when u names the container. A reservation holds a port but answers on
+// none.
+func checkUpstream(u ProxyUpstream, containers []ContainerData) (ProxyFinding, bool) {
+	f := ProxyFinding{Site: u.Site, Line: u.Line, Host: u.Host, Port: u.Port}
+	if localUpstream(u.Host) {
+		if slices.ContainsFunc(containers, func(c ContainerData) bool { return c.State == "running" && publishes(c, u.Port) }) {
+			return f, true
+		}
+		f.Code = "upstream_unpublished"
+		f.Reason = fmt.Sprintf("the proxy forwards to %s:%d, which nothing publishes", u.Host, u.Port)
+		return f, false
+	}
+	i := slices.IndexFunc(containers, func(c ContainerData) bool { return containerName(c) == u.Host || c.Service == u.Host })
+	if i < 0 {
+		// Somewhere else; nothing to tell
+		return f, true
+	}
+	c := containers[i]
+	if c.State == "running" && slices.ContainsFunc(c.Ports, func(p PortMapping) bool { return int(p.PrivatePort) == u.Port }) {
+		return f, true
+	}
+	f.Code = "upstream_not_exposed"
+	if c.State != "running" {
+		f.Reason = fmt.Sprintf("the proxy forwards to %s:%d, but %s is %s", u.Host, u.Port, containerName(c), c.State)
+	} else {
+		f.Reason = fmt.Sprintf("the proxy forwards to %s:%d, but %s doesn't expose port %d", u.Host, u.Port, containerName(c), u.Port)
+	}
+	return f, false
+}
+
+// localContainers keeps the entries on this host
+func localContainers(containers []ContainerData) []ContainerData {
+	return slices.DeleteFunc(slices.Clone(containers), func(c ContainerData) bool { return hostOf(c) != localHost })
+}
+
+func publishes(c ContainerData, port int) bool {
+	return slices.ContainsFunc(c.Ports, func(p PortMapping) bool { return int(p.PublicPort) == port })
+}
+
+// ProxyAnalysis is a parsed proxy config with its findings
+type ProxyAnalysis struct {
+	OK       bool           `json:"ok"`
+	Config   ProxyConfig    `json:"config"`
+	Findings []ProxyFinding `json:"findings"`
+	Meta     *ResponseMeta  `json:"meta,omitempty"`
+}
+
+// maxProxyConfigSize caps the config /api/analyze/proxy reads
+const maxProxyConfigSize = 1 << 20
+
+// handleAnalyzeProxy checks an nginx config or Caddyfile sent as the body.
+// format picks the syntax, nginx or caddy, nginx by default; proxy names
+// the containers running the proxy, whose ports are its own.
+func (s *Server) handleAnalyzeProxy(w http.ResponseWriter, r *http.Request) {
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxProxyConfigSize))
+	if err != nil || len(data) == 0 {
+		writeError(w, http.StatusBadRequest, "invalid_body", "Expected a proxy config as the body")
+		return
+	}
+	cfg, err := ParseProxyConfig("", r.URL.Query().Get("format"), data)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_proxy_config", localize(w, "Cannot parse the proxy config: %v", err))
+		return
+	}
+	snap, err := s.loadSnapshot(r.Context())
+	if err != nil {
+		status, code, msg := classifyDockerError(err)
+		writeError(w, status, code, msg)
+		return
+	}
+	if cfg.Listens == nil {
+		cfg.Listens = []ProxyListen{}
+	}
+	if cfg.Upstreams == nil {
+		cfg.Upstreams = []ProxyUpstream{}
+	}
+	findings := AnalyzeProxy(cfg, snap.Containers, queryList(r.URL.Query()["proxy"]))
+	s.writeSnapshotHeaders(w, snap)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ProxyAnalysis{OK: len(findings) == 0, Config: cfg, Findings: findings, Meta: s.snapshotMeta(snap)})
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+)
+
+const nginxConf = `upstream grafana {
+    server 127.0.0.1:3000;
+    server 127.0.0.1:3001 backup;
+}
+
+server {
+    listen 443 ssl;
+    listen [::]:443 ssl;
+    server_name grafana.lan;
+    location / {
+        proxy_pass http://grafana;
+    }
+    location /api/ {
+        proxy_pass http://api:8080/;
+    }
+    location /ws {
+        proxy_pass http://$backend;
+    }
+}
+
+server {
+    server_name "old.lan";
+    location / { proxy_pass http://localhost:8081; }
+}
+`
+
+func TestParseNginx(t *testing.T) {
+	cfg, err := ParseNginx([]byte(nginxConf))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(cfg.Listens) != 3 || cfg.Listens[1].IP != "::" || cfg.Listens[1].Site != "grafana.lan" || cfg.Listens[2].Port != 80 || cfg.Listens[2].Site != "old.lan" {
+		t.Errorf("Unexpected listens: %+v", cfg.Listens)
+	}
+	want := []ProxyUpstream{
+		{Site: "grafana.lan", Line: 2, Host: "127.0.0.1", Port: 3000},
+		{Site: "grafana.lan", Line: 3, Host: "127.0.0.1", Port: 3001},
+		{Site: "grafana.lan", Line: 14, Host: "api", Port: 8080},
+		{Site: "old.lan", Line: 23, Host: "localhost", Port: 8081},
+	}
+	if len(cfg.Upstreams) != len(want) {
+		t.Fatalf("Expected %d upstreams, got %+v", len(want), cfg.Upstreams)
+	}
+	for i := range want {
+		if cfg.Upstreams[i] != want[i] {
+			t.Errorf("Upstream %d: expected %+v, got %+v", i, want[i], cfg.Upstreams[i])
+		}
+	}
+
+	if _, err := ParseNginx([]byte("server {\n listen 80;\n")); err == nil {
+		t.Error("Expected an unclosed block to fail")
+	}
+}
+
+func TestParseCaddyfile(t *testing.T) {
+	cfg, err := ParseCaddyfile([]byte(`{
+	email ops@lan
+}
+
+(common) {
+	reverse_proxy localhost:1
+}
+
+grafana.lan, :8443 {
+	reverse_proxy /api/* api:8080
+	reverse_proxy {
+		to localhost:3000 localhost:3001
+	}
+}
+
+http://old.lan,
+http://older.lan {
+	reverse_proxy {$OLD_UPSTREAM}
+}
+`))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	var ports []int
+	for _, l := range cfg.Listens {
+		ports = append(ports, l.Port)
+	}
+	if len(ports) != 5 || ports[0] != 443 || ports[1] != 80 || ports[2] != 8443 || ports[3] != 80 || cfg.Listens[0].Site != "grafana.lan, :8443" {
+		t.Errorf("Unexpected listens: %+v", cfg.Listens)
+	}
+	if len(cfg.Upstreams) != 3 || cfg.Upstreams[0].Host != "api" || cfg.Upstreams[2].Port != 3001 || cfg.Upstreams[2].Line != 12 {
+		t.Errorf("Unexpected upstreams: %+v", cfg.Upstreams)
+	}
+}
+
+func TestHandleAnalyzeProxy(t *testing.T) {
+	server := &Server{client: &MockDockerClient{Containers: []types.Container{
+		{Names: []string{"/nginx"}, State: "running", Ports: []types.Port{{PublicPort: 443, PrivatePort: 443}}},
+		{Names: []string{"/grafana"}, State: "running", Ports: []types.Port{{PublicPort: 3000, PrivatePort: 3000}}},
+		{Names: []string{"/api"}, State: "running", Ports: []types.Port{{PrivatePort: 9090}}},
+		{Names: []string{"/legacy"}, State: "running", Ports: []types.Port{{PublicPort: 80}}},
+	}}}
+
+	w := httptest.NewRecorder()
+	server.handleAnalyzeProxy(w, httptest.NewRequest("POST", "/api/analyze/proxy?proxy=nginx", strings.NewReader(nginxConf)))
+	var resp ProxyAnalysis
+	json.NewDecoder(w.Body).Decode(&resp)
+	codes := make([]string, len(resp.Findings))
+	for i, f := range resp.Findings {
+		codes[i] = f.Code
+	}
+	// legacy holds old.lan's 80, grafana's backup and old.lan's upstream
+	// are gone, api listens on 9090 rather than 8080
+	if resp.OK || strings.Join(codes, ",") != "port_in_use,upstream_unpublished,upstream_not_exposed,upstream_unpublished" {
+		t.Fatalf("Unexpected findings: %+v", resp.Findings)
+	}
+	if resp.Findings[0].Reason != "the proxy listens on port 80, already in use by legacy" {
+		t.Errorf("Unexpected reason: %q", resp.Findings[0].Reason)
+	}
+
+	w = httptest.NewRecorder()
+	server.handleAnalyzeProxy(w, httptest.NewRequest("POST", "/api/analyze/proxy", strings.NewReader("server {")))
+	if w.Code != 400 {
+		t.Errorf("Expected 400 for a broken config, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	big := strings.Repeat("# padding\n", maxProxyConfigSize/10+1)
+	server.handleAnalyzeProxy(w, httptest.NewRequest("POST", "/api/analyze/proxy", strings.NewReader(big)))
+	if w.Code != 400 {
+		t.Errorf("Expected 400 for a config over the size limit, got %d", w.Code)
+	}
+}
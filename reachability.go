@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ReachabilityProbe asks something outside the network whether a port is
+// reachable: URL is a template where {host} and {port} are filled in, and
+// the response a JSON object with a boolean "reachable". Another quaycheck
+// with QUAYCHECK_SERVE_REACHABILITY=true answers at /api/reach/{port}.
+type ReachabilityProbe struct {
+	URL   string
+	Host  string
+	Token string
+
+	HTTPClient *http.Client
+}
+
+// reachabilityFromEnv reads QUAYCHECK_REACHABILITY_PROBE, the probe's URL
+// template; QUAYCHECK_PUBLIC_HOST, the WAN address or name it fills {host}
+// with; and QUAYCHECK_REACHABILITY_TOKEN, sent as a bearer token
+func reachabilityFromEnv() *ReachabilityProbe {
+	raw := os.Getenv("QUAYCHECK_REACHABILITY_PROBE")
+	if raw == "" {
+		return nil
+	}
+	p := &ReachabilityProbe{
+		URL:        raw,
+		Host:       os.Getenv("QUAYCHECK_PUBLIC_HOST"),
+		Token:      os.Getenv("QUAYCHECK_REACHABILITY_TOKEN"),
+		HTTPClient: &http.Client{Timeout: 15 * time.Second},
+	}
+	if !strings.Contains(raw, "{port}") {
+		log.Fatalf("QUAYCHECK_REACHABILITY_PROBE must contain {port}")
+	}
+	if strings.Contains(raw, "{host}") && p.Host == "" {
+		log.Fatalf("QUAYCHECK_REACHABILITY_PROBE uses {host}: set QUAYCHECK_PUBLIC_HOST")
+	}
+	return p
+}
+
+// Check asks the probe about one TCP port
+func (p *ReachabilityProbe) Check(ctx context.Context, port int) (bool, error) {
+	target := strings.NewReplacer("{host}", url.QueryEscape(p.Host), "{port}", strconv.Itoa(port)).Replace(p.URL)
+	req, err := http.NewRequestWithContext(ctx, "GET", target, nil)
+	if err != nil {
+		return false, err
+	}
+	if p.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+p.Token)
+	}
+	resp, err := p.HTTPClient.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("probe: %w", err)
+	}
+	defer resp.Body.Close()
+	var body struct {
+		Reachable *bool  `json:"reachable"`
+		Message   string `json:"message"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || resp.StatusCode != http.StatusOK || body.Reachable == nil {
+		if body.Message != "" {
+			return false, fmt.Errorf("probe: %s: %s", resp.Status, body.Message)
+		}
+		return false, fmt.Errorf("probe: %s: expected {\"reachable\": true|false}", resp.Status)
+	}
+	return *body.Reachable, nil
+}
+
+// Reachability is whether a published port can be reached from outside,
+// next to how it's bound: Exposed is a binding on all or non-loopback
+// addresses, which the firewall or router may still block
+type Reachability struct {
+	Port      int    `json:"port"`
+	Protocol  string `json:"protocol"`
+	IP        string `json:"ip,omitempty"`
+	Container string `json:"container"`
+	Exposed   bool   `json:"exposed"`
+	// Scope is the widest network the binding is open to: loopback, vpn,
+	// lan or public
+	Scope string `json:"scope,omitempty"`
+	// Reachable is unset when the probe failed or wasn't asked: UDP, and
+	// ports bound to loopback or a VPN only
+	Reachable *bool  `json:"reachable,omitempty"`
+	Code      string `json:"code,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+	// Attempts is who has recently been connecting, when tracked
+	Attempts *ConnectionAttempts `json:"attempts,omitempty"`
+}
+
+type ReachabilityResponse struct {
+	Ports []Reachability `json:"ports"`
+	// AttemptsError is set when connection attempts couldn't all be read
+	AttemptsError string        `json:"attempts_error,omitempty"`
+	Meta          *ResponseMeta `json:"meta,omitempty"`
+}
+
+// exposedBinding is whether a port bound to ip answers on other hosts
+func exposedBinding(ip string) bool {
+	if ip == "" {
+		return true
+	}
+	parsed := net.ParseIP(ip)
+	return parsed == nil || !parsed.IsLoopback()
+}
+
+// handleReachability probes the ports this host publishes from outside,
+// ?port= only that one. Probes run a few at a time, each with its own
+// timeout. With attempt tracking, exposed ports say who has been
+// connecting, and ?sort=attempts puts the busiest first.
+func (s *Server) handleReachability(w http.ResponseWriter, r *http.Request) {
+	if s.reachability == nil && s.attempts == nil {
+		writeError(w, http.StatusNotImplemented, "reachability_unset", "No reachability probe is set up on this server")
+		return
+	}
+	only := 0
+	if v := r.URL.Query().Get("port"); v != "" {
+		port, err := strconv.Atoi(v)
+		if err != nil || port < 1 || port > maxPort {
+			writeError(w, http.StatusBadRequest, "invalid_param", "Invalid port parameter")
+			return
+		}
+		only = port
+	}
+	snap, err := s.loadSnapshot(r.Context())
+	if err != nil {
+		status, code, msg := classifyDockerError(err)
+		writeError(w, status, code, msg)
+		return
+	}
+
+	ifaces, _ := hostInterfaces()
+	classifyInterfaces(ifaces)
+	ports := []Reachability{}
+	for _, c := range localContainers(snap.Containers) {
+		if !occupiesPorts(c.State) || c.Source == "reservation" {
+			continue
+		}
+		for _, p := range c.Ports {
+			if p.PublicPort == 0 || only != 0 && int(p.PublicPort) != only {
+				continue
+			}
+			entry := Reachability{Port: int(p.PublicPort), Protocol: p.Type, IP: p.IP, Container: containerName(c), Exposed: exposedBinding(p.IP), Scope: bindScope(p.IP, ifaces)}
+			if !slices.ContainsFunc(ports, func(e Reachability) bool { return e.Port == entry.Port && e.Protocol == entry.Protocol }) {
+				ports = append(ports, entry)
+			}
+		}
+	}
+	slices.SortFunc(ports, func(a, b Reachability) int { return a.Port - b.Port })
+
+	resp := ReachabilityResponse{Ports: ports, Meta: s.snapshotMeta(snap)}
+	if s.attempts != nil {
+		attempts, err := s.attempts.Collect(r.Context())
+		if err != nil {
+			resp.AttemptsError = err.Error()
+		}
+		for i, p := range ports {
+			if p.Exposed && p.Scope != scopeVPN {
+				if a := attempts[portKey{p.Port, p.Protocol}]; a != nil {
+					ports[i].Attempts = a
+				} else {
+					ports[i].Attempts = &ConnectionAttempts{}
+				}
+			}
+		}
+	}
+
+	const maxInFlight = 8
+	sem := make(chan struct{}, maxInFlight)
+	var wg sync.WaitGroup
+	for i := range ports {
+		if s.reachability == nil || !ports[i].Exposed || ports[i].Scope == scopeVPN || ports[i].Protocol == "udp" {
+			continue
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(e *Reachability) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			reachable, err := s.reachability.Check(r.Context(), e.Port)
+			switch {
+			case err != nil:
+				e.Code, e.Reason = "probe_failed", err.Error()
+			case reachable:
+				e.Reachable = &reachable
+				e.Code, e.Reason = "publicly_reachable", localize(w, "Port is reachable from the internet")
+			default:
+				e.Reachable = &reachable
+			}
+		}(&ports[i])
+	}
+	wg.Wait()
+
+	if r.URL.Query().Get("sort") == "attempts" {
+		// Reachable ports first, then by how much they're being hit
+		slices.SortStableFunc(ports, func(a, b Reachability) int {
+			if ra, rb := a.Reachable != nil && *a.Reachable, b.Reachable != nil && *b.Reachable; ra != rb {
+				if ra {
+					return -1
+				}
+				return 1
+			}
+			return attemptCount(b) - attemptCount(a)
+		})
+	}
+
+	s.writeSnapshotHeaders(w, snap)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func attemptCount(r Reachability) int {
+	if r.Attempts == nil {
+		return 0
+	}
+	return r.Attempts.Connections
+}
+
+// reachDial is swapped out in tests
+var reachDial = func(ctx context.Context, addr string) error {
+	return probeDial(ctx, addr, 5*time.Second)
+}
+
+// handleReach is the probe side: it dials the caller's own address on the
+// port and says whether that connected. Dialing only the caller keeps it
+// from being used to scan anyone else.
+func (s *Server) handleReach(w http.ResponseWriter, r *http.Request) {
+	port, err := strconv.Atoi(r.PathValue("port"))
+	if err != nil || port < 1 || port > maxPort {
+		writeError(w, http.StatusBadRequest, "invalid_param", "Invalid port parameter")
+		return
+	}
+	host := s.clientIP(r)
+	err = reachDial(r.Context(), net.JoinHostPort(host, strconv.Itoa(port)))
+	resp := map[string]any{"host": host, "port": port, "reachable": err == nil}
+	if err != nil {
+		resp["error"] = err.Error()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+)
+
+func TestReachability(t *testing.T) {
+	var dialed []string
+	defer func(orig func(context.Context, string) error) { reachDial = orig }(reachDial)
+	reachDial = func(_ context.Context, addr string) error {
+		dialed = append(dialed, addr)
+		if strings.HasSuffix(addr, ":3000") {
+			return nil
+		}
+		return errors.New("connection refused")
+	}
+	defer func(orig func() ([]InterfaceInfo, error)) { hostInterfaces = orig }(hostInterfaces)
+	hostInterfaces = func() ([]InterfaceInfo, error) {
+		return []InterfaceInfo{
+			{Name: "lo", Addresses: []AddressStatus{{IP: "127.0.0.1"}}},
+			{Name: "eth0", Addresses: []AddressStatus{{IP: "192.168.1.10"}}},
+			{Name: "tailscale0", Addresses: []AddressStatus{{IP: "100.101.1.2"}}},
+		}, nil
+	}
+	// A second instance does the probing
+	probe := httptest.NewServer(SetupRouter(&Server{client: &MockDockerClient{}, serveReach: true}))
+	defer probe.Close()
+
+	server := &Server{
+		client: &MockDockerClient{Containers: []types.Container{
+			{Names: []string{"/grafana"}, State: "running", Ports: []types.Port{{PublicPort: 3000, PrivatePort: 3000, Type: "tcp", IP: "0.0.0.0"}}},
+			{Names: []string{"/db"}, State: "running", Ports: []types.Port{{PublicPort: 5432, PrivatePort: 5432, Type: "tcp", IP: "0.0.0.0"}}},
+			{Names: []string{"/admin"}, State: "running", Ports: []types.Port{{PublicPort: 9000, PrivatePort: 9000, Type: "tcp", IP: "127.0.0.1"}}},
+			{Names: []string{"/ssh"}, State: "running", Ports: []types.Port{{PublicPort: 2222, PrivatePort: 22, Type: "tcp", IP: "100.101.1.2"}}},
+		}},
+		reachability: &ReachabilityProbe{URL: probe.URL + "/api/reach/{port}", HTTPClient: probe.Client()},
+	}
+	w := httptest.NewRecorder()
+	SetupRouter(server).ServeHTTP(w, httptest.NewRequest("GET", "/api/reachability", nil))
+	var resp ReachabilityResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	if w.Code != http.StatusOK || len(resp.Ports) != 4 {
+		t.Fatalf("Expected four ports, got %d %+v", w.Code, resp)
+	}
+	if p := resp.Ports[0]; p.Port != 2222 || !p.Exposed || p.Scope != "vpn" || p.Reachable != nil {
+		t.Errorf("Expected tailnet-only 2222 not to be probed, got %+v", p)
+	}
+	if p := resp.Ports[1]; p.Port != 3000 || p.Scope != "lan" || p.Reachable == nil || !*p.Reachable || p.Code != "publicly_reachable" {
+		t.Errorf("Expected 3000 to be reachable, got %+v", p)
+	}
+	if p := resp.Ports[2]; p.Port != 5432 || p.Reachable == nil || *p.Reachable || p.Code != "" {
+		t.Errorf("Expected 5432 to be exposed but blocked, got %+v", p)
+	}
+	if p := resp.Ports[3]; p.Port != 9000 || p.Exposed || p.Scope != "loopback" || p.Reachable != nil {
+		t.Errorf("Expected loopback-only 9000 not to be probed, got %+v", p)
+	}
+	for _, addr := range dialed {
+		if !strings.HasPrefix(addr, "127.0.0.1:") {
+			t.Errorf("Expected the probe to dial its caller only, got %s", addr)
+		}
+	}
+
+	server.reachability.URL = probe.URL + "/api/nope/{port}"
+	w = httptest.NewRecorder()
+	SetupRouter(server).ServeHTTP(w, httptest.NewRequest("GET", "/api/reachability?port=3000", nil))
+	resp = ReachabilityResponse{}
+	json.NewDecoder(w.Body).Decode(&resp)
+	if len(resp.Ports) != 1 || resp.Ports[0].Code != "probe_failed" || resp.Ports[0].Reachable != nil {
+		t.Errorf("Expected a failed probe to leave reachability unknown, got %+v", resp.Ports)
+	}
+
+	w = httptest.NewRecorder()
+	SetupRouter(&Server{client: &MockDockerClient{}}).ServeHTTP(w, httptest.NewRequest("GET", "/api/reachability", nil))
+	if w.Code != http.StatusNotImplemented {
+		t.Errorf("Expected 501 without a probe, got %d", w.Code)
+	}
+}
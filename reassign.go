@@ -0,0 +1,410 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+
+	"quaycheck/internal/storage"
+)
+
+// Reassignment reclaims a reserved port from the container squatting on it:
+// the container's owner is told, and once Deadline passes the container is
+// stopped if Stop is set
+type Reassignment struct {
+	ID          string `json:"id"`
+	Reservation string `json:"reservation"`
+	// Environment is the reservation's, empty for one held everywhere
+	Environment string    `json:"environment,omitempty"`
+	Port        int       `json:"port"`
+	Container   string    `json:"container"`
+	ContainerID string    `json:"container_id"`
+	Owner       string    `json:"owner,omitempty"`
+	Message     string    `json:"message,omitempty"`
+	Stop        bool      `json:"stop"`
+	Deadline    time.Time `json:"deadline"`
+	Status      string    `json:"status"`
+	Error       string    `json:"error,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+const (
+	ReassignPending   = "pending"
+	ReassignResolved  = "resolved"
+	ReassignStopped   = "stopped"
+	ReassignExpired   = "expired"
+	ReassignFailed    = "failed"
+	ReassignCancelled = "cancelled"
+)
+
+// Port events published as a reassignment goes along, so subscribers can
+// relay them to chat or paging
+const (
+	EventReassignRequested = "reassign_requested"
+	EventReassignStopped   = "reassign_stopped"
+)
+
+// defaultReassignGrace is how long a squatter gets before being stopped
+const defaultReassignGrace = 24 * time.Hour
+
+// reassignGraceFromEnv reads QUAYCHECK_REASSIGN_GRACE, e.g. 4h or 2d
+func reassignGraceFromEnv() time.Duration {
+	if v := os.Getenv("QUAYCHECK_REASSIGN_GRACE"); v != "" {
+		if d, err := parseRetentionAge(v); err == nil && d > 0 {
+			return d
+		}
+		log.Printf("Ignoring QUAYCHECK_REASSIGN_GRACE=%q", v)
+	}
+	return defaultReassignGrace
+}
+
+// ReassignStore persists reassignments like FreezeStore does freezes
+type ReassignStore struct {
+	db *storage.DB
+	// Grace is the default time a squatter gets
+	Grace time.Duration
+
+	mu     sync.Mutex
+	items  map[string]Reassignment
+	nextID int
+}
+
+func NewReassignStore(db *storage.DB) (*ReassignStore, error) {
+	s := &ReassignStore{db: db, Grace: defaultReassignGrace}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+var reassignColumns = []string{"id", "reservation", "port", "container", "container_id", "owner", "message", "stop", "deadline", "status", "error", "created_at", "environment"}
+
+// Reload replaces the in-memory reassignments with the database's
+func (s *ReassignStore) Reload() error {
+	var list []Reassignment
+	if s.db != nil {
+		rows, err := s.db.Query(`SELECT ` + strings.Join(reassignColumns, ", ") + ` FROM reassignments`)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var re Reassignment
+			var deadline, created int64
+			if err := rows.Scan(&re.ID, &re.Reservation, &re.Port, &re.Container, &re.ContainerID, &re.Owner, &re.Message, &re.Stop, &deadline, &re.Status, &re.Error, &created, &re.Environment); err != nil {
+				return err
+			}
+			re.Deadline = time.Unix(0, deadline).UTC()
+			re.CreatedAt = time.Unix(0, created).UTC()
+			list = append(list, re)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.items, s.nextID = make(map[string]Reassignment), 0
+	for _, re := range list {
+		s.items[re.ID] = re
+		if n, err := strconv.Atoi(re.ID); err == nil && n > s.nextID {
+			s.nextID = n
+		}
+	}
+	return nil
+}
+
+func (s *ReassignStore) saveLocked() error {
+	if s.db == nil {
+		return nil
+	}
+	var rows [][]any
+	for _, re := range s.listLocked() {
+		rows = append(rows, []any{re.ID, re.Reservation, re.Port, re.Container, re.ContainerID, re.Owner, re.Message, re.Stop, re.Deadline.UnixNano(), re.Status, re.Error, re.CreatedAt.UnixNano(), re.Environment})
+	}
+	return s.db.Replace(context.Background(), "reassignments", reassignColumns, rows)
+}
+
+func (s *ReassignStore) listLocked() []Reassignment {
+	list := make([]Reassignment, 0, len(s.items))
+	for _, re := range s.items {
+		list = append(list, re)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+	return list
+}
+
+func (s *ReassignStore) List() []Reassignment {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.listLocked()
+}
+
+var errReassignPending = errors.New("a reassignment of this reservation is already pending")
+
+// Add starts a reassignment, one pending per reservation at most
+func (s *ReassignStore) Add(re Reassignment) (Reassignment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, other := range s.items {
+		if other.Reservation == re.Reservation && other.Environment == re.Environment && other.Status == ReassignPending {
+			return Reassignment{}, errReassignPending
+		}
+	}
+	s.nextID++
+	re.ID = strconv.Itoa(s.nextID)
+	re.Status = ReassignPending
+	re.CreatedAt = time.Now().UTC()
+	s.items[re.ID] = re
+	return re, s.saveLocked()
+}
+
+// Finish moves a pending reassignment to status; false when it isn't
+// pending anymore
+func (s *ReassignStore) Finish(id, status, errMsg string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	re, ok := s.items[id]
+	if !ok || re.Status != ReassignPending {
+		return false, nil
+	}
+	re.Status, re.Error = status, errMsg
+	s.items[id] = re
+	return true, s.saveLocked()
+}
+
+// notifyReassign tells the squatter's owner, by mail when the owner is an
+// address and SMTP is set up, and everyone subscribed to events
+func (s *Server) notifyReassign(re Reassignment, eventType, subject, body string) {
+	s.events.Publish(PortEvent{Type: eventType, Port: re.Port, Protocol: "tcp", Container: re.Container, ContainerID: re.ContainerID, Source: "reassign", Time: time.Now().UTC()})
+	if s.smtp == nil || !strings.Contains(re.Owner, "@") {
+		return
+	}
+	cfg := *s.smtp
+	cfg.To = []string{re.Owner}
+	if err := cfg.send(subject, body); err != nil {
+		log.Printf("Cannot mail %s about reassignment %s: %v", re.Owner, re.ID, err)
+	}
+}
+
+func (re Reassignment) requestMail() (string, string) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Port %d, used by your container %s, is reserved for %s.\n\n", re.Port, re.Container, re.Reservation)
+	if re.Message != "" {
+		fmt.Fprintf(&b, "%s\n\n", re.Message)
+	}
+	if re.Stop {
+		fmt.Fprintf(&b, "Please move it to another port before %s, when it will be stopped.\n", re.Deadline.Format(time.RFC1123))
+	} else {
+		fmt.Fprintf(&b, "Please move it to another port before %s.\n", re.Deadline.Format(time.RFC1123))
+	}
+	return fmt.Sprintf("quaycheck: port %d is needed back", re.Port), b.String()
+}
+
+// squatter returns the entry other than a reservation publishing port
+func squatter(containers []ContainerData, port int) (ContainerData, bool) {
+	for _, c := range containers {
+		if c.Source == "reservation" || !occupiesPorts(c.State) {
+			continue
+		}
+		if slices.ContainsFunc(c.Ports, func(p PortMapping) bool { return int(p.PublicPort) == port }) {
+			return c, true
+		}
+	}
+	return ContainerData{}, false
+}
+
+// stillPublishes reports whether the entry with id still publishes port,
+// whatever else may publish it on other hosts
+func stillPublishes(containers []ContainerData, id string, port int) bool {
+	for _, c := range containers {
+		if c.ID != id || !occupiesPorts(c.State) {
+			continue
+		}
+		return slices.ContainsFunc(c.Ports, func(p PortMapping) bool { return int(p.PublicPort) == port })
+	}
+	return false
+}
+
+// processReassignments settles pending reassignments: resolved once the
+// squatter has moved, and at the deadline stopped or expired. A stop asked
+// for while container actions were on fails if they've since been turned off.
+func (s *Server) processReassignments(ctx context.Context, now time.Time) {
+	var pending []Reassignment
+	for _, re := range s.reassignments.List() {
+		if re.Status == ReassignPending {
+			pending = append(pending, re)
+		}
+	}
+	if len(pending) == 0 {
+		return
+	}
+	snap, err := s.collectContainers(ctx)
+	if err != nil {
+		log.Printf("Reassignments: %v", err)
+		return
+	}
+	for _, re := range pending {
+		status, errMsg := "", ""
+		switch {
+		case !stillPublishes(snap.Containers, re.ContainerID, re.Port):
+			status = ReassignResolved
+		case now.Before(re.Deadline):
+			continue
+		case !re.Stop:
+			status = ReassignExpired
+		case !s.containerActions:
+			// Saved while stops were allowed; they're opt-in, so never
+			// stop once the operator turned them off
+			status, errMsg = ReassignFailed, "Stopping needs QUAYCHECK_CONTAINER_ACTIONS"
+		default:
+			status = ReassignStopped
+			if err := s.stopSquatter(ctx, re.ContainerID); err != nil {
+				status, errMsg = ReassignFailed, err.Error()
+			}
+		}
+		if ok, err := s.reassignments.Finish(re.ID, status, errMsg); err != nil || !ok {
+			// Cancelled meanwhile, or not saved; try again next round
+			continue
+		}
+		s.auditAs(ctx, "reassign", "", "reassign."+status, re.Reservation, fmt.Sprintf("%d %s", re.Port, re.Container))
+		if status == ReassignStopped {
+			s.notifyReassign(re, EventReassignStopped, fmt.Sprintf("quaycheck: stopped %s", re.Container),
+				fmt.Sprintf("Your container %s was stopped to hand port %d over to %s.\n", re.Container, re.Port, re.Reservation))
+			s.resync(ctx)
+		}
+	}
+}
+
+func (s *Server) stopSquatter(ctx context.Context, id string) error {
+	lc, ok := s.client.(dockerLifecycleClient)
+	if !ok {
+		return errors.New("this Docker client cannot stop containers")
+	}
+	return lc.ContainerStop(ctx, id, container.StopOptions{})
+}
+
+// reassignLoop settles reassignments every interval
+func (s *Server) reassignLoop(ctx context.Context, interval time.Duration) {
+	if s.reassignments == nil {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.processReassignments(ctx, time.Now())
+		}
+	}
+}
+
+type ReassignRequest struct {
+	// Grace overrides QUAYCHECK_REASSIGN_GRACE, e.g. 4h or 2d
+	Grace string `json:"grace,omitempty"`
+	// Stop stops the squatter at the deadline; it needs container actions
+	Stop    bool   `json:"stop,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// handleReassign starts reclaiming a reservation's port from the container
+// publishing it
+func (s *Server) handleReassign(w http.ResponseWriter, r *http.Request) {
+	var req ReassignRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_body", "Expected a JSON body")
+		return
+	}
+	grace := s.reassignments.Grace
+	if req.Grace != "" {
+		d, err := parseRetentionAge(req.Grace)
+		if err != nil || d <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid_body", "Invalid grace period")
+			return
+		}
+		grace = d
+	}
+	if req.Stop && !s.containerActions {
+		writeError(w, http.StatusBadRequest, "invalid_body", "Stopping needs QUAYCHECK_CONTAINER_ACTIONS")
+		return
+	}
+	res, ok := s.reservations.Get(environmentFrom(r.Context()), r.PathValue("name"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "not_found", "No reservation with this name")
+		return
+	}
+	snap, err := s.collectScoped(r.Context())
+	if err != nil {
+		status, code, msg := classifyDockerError(err)
+		writeError(w, status, code, msg)
+		return
+	}
+	c, held := squatter(snap.Containers, res.Port)
+	switch {
+	case !held:
+		writeError(w, http.StatusConflict, "not_squatted", localize(w, "Nothing but the reservation holds port %d", res.Port))
+		return
+	case req.Stop && c.Source != "":
+		writeError(w, http.StatusConflict, "not_a_container", localize(w, "Port %d is held by %s, which is not a Docker container", res.Port, containerName(c)))
+		return
+	}
+
+	re, err := s.reassignments.Add(Reassignment{
+		Reservation: res.Name,
+		Environment: res.Environment,
+		Port:        res.Port,
+		Container:   containerName(c),
+		ContainerID: c.ID,
+		Owner:       c.Owner,
+		Message:     req.Message,
+		Stop:        req.Stop,
+		Deadline:    time.Now().Add(grace).UTC(),
+	})
+	switch {
+	case errors.Is(err, errReassignPending):
+		writeError(w, http.StatusConflict, "reassign_pending", "A reassignment of this reservation is already pending")
+		return
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, "store_error", "Cannot save reassignments: "+err.Error())
+		return
+	}
+	s.audit(r, "reservation.reassign", re.Reservation, fmt.Sprintf("%d %s", re.Port, re.Container))
+	subject, body := re.requestMail()
+	s.notifyReassign(re, EventReassignRequested, subject, body)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(re)
+}
+
+func (s *Server) handleListReassignments(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.reassignments.List())
+}
+
+func (s *Server) handleCancelReassignment(w http.ResponseWriter, r *http.Request) {
+	ok, err := s.reassignments.Finish(r.PathValue("id"), ReassignCancelled, "")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "store_error", "Cannot save reassignments: "+err.Error())
+		return
+	}
+	if !ok {
+		writeError(w, http.StatusNotFound, "not_found", "No pending reassignment with this id")
+		return
+	}
+	s.audit(r, "reassign.cancel", r.PathValue("id"), "")
+	w.WriteHeader(http.StatusNoContent)
+}
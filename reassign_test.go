@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+func newReassignServer(t *testing.T, client DockerClient) *Server {
+	t.Helper()
+	server := newLifecycleServer(client)
+	server.events = NewEventHub()
+	server.smtp = &SMTPConfig{Host: "mail.lan", Port: 25, From: "quaycheck@lan"}
+	var err error
+	if server.reassignments, err = NewReassignStore(openTestDB(t)); err != nil {
+		t.Fatal(err)
+	}
+	return server
+}
+
+func serveRecorded(h http.Handler, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func squattingClient() *lifecycleDockerClient {
+	return &lifecycleDockerClient{MockDockerClient: MockDockerClient{Containers: []types.Container{
+		{ID: "abc", Names: []string{"/legacy"}, State: "running", Labels: map[string]string{"quaycheck.owner": "ops@lan"}, Ports: []types.Port{{PublicPort: 3000}}},
+	}}}
+}
+
+func TestReassignStopsTheSquatterAfterTheGrace(t *testing.T) {
+	var rcpt []string
+	var sent string
+	sendMail = func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+		rcpt, sent = append(rcpt, to...), sent+string(msg)
+		return nil
+	}
+	defer func() { sendMail = smtp.SendMail }()
+
+	client := squattingClient()
+	server := newReassignServer(t, client)
+	events, unsubscribe := server.events.Subscribe()
+	defer unsubscribe()
+	mux := SetupRouter(server)
+
+	req := func(body string) *http.Request {
+		r, _ := http.NewRequest("POST", "/api/reservations/grafana/reassign", strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer ops")
+		return r
+	}
+	w := serveRecorded(mux, req(`{"grace":"1h","stop":true,"message":"grafana moves in on Monday"}`))
+	var re Reassignment
+	json.NewDecoder(w.Body).Decode(&re)
+	if w.Code != http.StatusCreated || re.Container != "legacy" || re.Owner != "ops@lan" || re.Status != ReassignPending {
+		t.Fatalf("Expected a pending reassignment, got %d %+v", w.Code, re)
+	}
+	if len(rcpt) != 1 || rcpt[0] != "ops@lan" || !strings.Contains(sent, "grafana moves in on Monday") {
+		t.Errorf("Expected the owner to be mailed, got %v %q", rcpt, sent)
+	}
+	if e := <-events; e.Type != EventReassignRequested || e.Port != 3000 {
+		t.Errorf("Expected a reassign_requested event, got %+v", e)
+	}
+	if w := serveRecorded(mux, req(`{}`)); w.Code != http.StatusConflict {
+		t.Errorf("Expected 409 while one is pending, got %d", w.Code)
+	}
+
+	server.processReassignments(context.Background(), time.Now())
+	if len(client.calls) != 0 {
+		t.Fatalf("Expected nothing stopped within the grace, got %v", client.calls)
+	}
+	server.processReassignments(context.Background(), time.Now().Add(2*time.Hour))
+	if len(client.calls) != 1 || client.calls[0] != "stop abc" {
+		t.Errorf("Expected legacy to be stopped, got %v", client.calls)
+	}
+	if list := server.reassignments.List(); list[0].Status != ReassignStopped {
+		t.Errorf("Expected the reassignment to be done, got %+v", list)
+	}
+}
+
+func TestReassignResolvesWhenTheSquatterMoves(t *testing.T) {
+	client := squattingClient()
+	server := newReassignServer(t, client)
+	server.smtp = nil
+	re, _ := server.reassignments.Add(Reassignment{Reservation: "grafana", Port: 3000, ContainerID: "abc", Deadline: time.Now().Add(time.Hour)})
+
+	client.Containers[0].Ports[0].PublicPort = 3001
+	server.processReassignments(context.Background(), time.Now())
+	if list := server.reassignments.List(); list[0].ID != re.ID || list[0].Status != ReassignResolved {
+		t.Errorf("Expected the reassignment to be resolved, got %+v", list)
+	}
+	if len(client.calls) != 0 {
+		t.Errorf("Expected nothing stopped, got %v", client.calls)
+	}
+}
+
+func TestReassignFollowsItsOwnSquatter(t *testing.T) {
+	client := squattingClient()
+	// Listed first, as another host's entry publishing the same port would be
+	client.Containers = append([]types.Container{{ID: "aaa", Names: []string{"/elsewhere"}, State: "running", Ports: []types.Port{{PublicPort: 3000}}}}, client.Containers...)
+	server := newReassignServer(t, client)
+	server.smtp = nil
+	server.reassignments.Add(Reassignment{Reservation: "grafana", Port: 3000, ContainerID: "abc", Stop: true, Deadline: time.Now().Add(time.Hour)})
+
+	server.processReassignments(context.Background(), time.Now())
+	if list := server.reassignments.List(); list[0].Status != ReassignPending {
+		t.Fatalf("Expected the reassignment to stay pending, got %+v", list)
+	}
+	server.processReassignments(context.Background(), time.Now().Add(2*time.Hour))
+	if len(client.calls) != 1 || client.calls[0] != "stop abc" {
+		t.Errorf("Expected legacy to be stopped, got %v", client.calls)
+	}
+}
+
+func TestReassignNeverStopsWithContainerActionsOff(t *testing.T) {
+	client := squattingClient()
+	server := newReassignServer(t, client)
+	server.smtp = nil
+	server.reassignments.Add(Reassignment{Reservation: "grafana", Port: 3000, ContainerID: "abc", Stop: true, Deadline: time.Now().Add(time.Hour)})
+
+	// Restarted with QUAYCHECK_CONTAINER_ACTIONS off after the stop was asked for
+	server.containerActions = false
+	server.processReassignments(context.Background(), time.Now().Add(2*time.Hour))
+	if len(client.calls) != 0 {
+		t.Fatalf("Expected nothing stopped, got %v", client.calls)
+	}
+	if list := server.reassignments.List(); list[0].Status != ReassignFailed || !strings.Contains(list[0].Error, "QUAYCHECK_CONTAINER_ACTIONS") {
+		t.Errorf("Expected the reassignment to fail with a reason, got %+v", list)
+	}
+}
+
+func TestReassignRefusals(t *testing.T) {
+	server := newReassignServer(t, &lifecycleDockerClient{})
+	server.containerActions = false
+	mux := SetupRouter(server)
+	for body, want := range map[string]int{
+		`{"stop":true}`:    http.StatusBadRequest,
+		`{"grace":"soon"}`: http.StatusBadRequest,
+		// Only the reservation holds 3000
+		`{}`: http.StatusConflict,
+	} {
+		r, _ := http.NewRequest("POST", "/api/reservations/grafana/reassign", strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer ops")
+		if w := serveRecorded(mux, r); w.Code != want {
+			t.Errorf("%s: expected %d, got %d", body, want, w.Code)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// Recommendation is a published port that looks abandoned
+type Recommendation struct {
+	Port      int    `json:"port"`
+	Protocol  string `json:"protocol"`
+	Container string `json:"container"`
+	Project   string `json:"project,omitempty"`
+	Code      string `json:"code"`
+	Reason    string `json:"reason"`
+	// LastActive is when the port last took traffic, if ever sampled
+	LastActive    *time.Time `json:"last_active,omitempty"`
+	UptimeSeconds int64      `json:"uptime_seconds,omitempty"`
+}
+
+type RecommendationsResponse struct {
+	Days            int              `json:"days"`
+	Recommendations []Recommendation `json:"recommendations"`
+	// Since is when traffic sampling started, when that's less than the
+	// window ago and nothing can be called idle yet
+	Since *time.Time    `json:"since,omitempty"`
+	Meta  *ResponseMeta `json:"meta,omitempty"`
+}
+
+// idleDaysFromEnv reads QUAYCHECK_IDLE_DAYS, how long a port must take no
+// traffic before it's recommended for cleanup
+func idleDaysFromEnv() int {
+	v := os.Getenv("QUAYCHECK_IDLE_DAYS")
+	if v == "" {
+		return 14
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 {
+		log.Fatalf("Invalid QUAYCHECK_IDLE_DAYS %q", v)
+	}
+	return n
+}
+
+// idlePorts lists the running containers' ports with no traffic over the
+// last days. A port counts only once sampling covers the whole window, its
+// container has been up for all of it and nothing is connected right now;
+// ports without Docker's counters, e.g. host networking, are left out.
+// The returned time is when sampling started if it doesn't cover the
+// window yet.
+func (s *Server) idlePorts(ctx context.Context, snap Snapshot, now time.Time, days int) ([]Recommendation, *time.Time, error) {
+	result := []Recommendation{}
+	windowStart := now.AddDate(0, 0, -days)
+	first, err := s.traffic.First(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	if first == nil || first.After(windowStart) {
+		return result, first, nil
+	}
+	recent, err := s.traffic.Totals(ctx, windowStart)
+	if err != nil {
+		return nil, nil, err
+	}
+	allTime, err := s.traffic.Totals(ctx, time.Time{})
+	if err != nil {
+		return nil, nil, err
+	}
+	// Connections open right now outweigh quiet counters; without a
+	// connection table the counters decide alone
+	established, _, _ := establishedCounts(ctx)
+
+	find := func(totals []TrafficTotal, p PortMapping) (TrafficTotal, bool) {
+		i := slices.IndexFunc(totals, func(t TrafficTotal) bool { return t.Port == int(p.PublicPort) && t.Protocol == p.Type })
+		if i < 0 {
+			return TrafficTotal{}, false
+		}
+		return totals[i], true
+	}
+	for _, c := range localContainers(snap.Containers) {
+		if c.State != "running" || c.StartedAt != nil && c.StartedAt.After(windowStart) {
+			continue
+		}
+		for _, p := range c.Ports {
+			total, sampled := find(recent, p)
+			if p.PublicPort == 0 || !sampled || total.Connections > 0 || total.BytesIn > 0 || established[int(p.PublicPort)] > 0 {
+				continue
+			}
+			if slices.ContainsFunc(result, func(r Recommendation) bool { return r.Port == int(p.PublicPort) && r.Protocol == p.Type }) {
+				continue
+			}
+			rec := Recommendation{
+				Port:          int(p.PublicPort),
+				Protocol:      p.Type,
+				Container:     containerName(c),
+				Project:       c.Project,
+				Code:          "port_idle",
+				UptimeSeconds: c.UptimeSeconds,
+			}
+			if t, ok := find(allTime, p); ok {
+				rec.LastActive = t.LastActive
+			}
+			result = append(result, rec)
+		}
+	}
+	slices.SortFunc(result, func(a, b Recommendation) int { return a.Port - b.Port })
+	return result, nil, nil
+}
+
+// handleRecommendations lists ports that look abandoned: no traffic for
+// ?days=, QUAYCHECK_IDLE_DAYS by default
+func (s *Server) handleRecommendations(w http.ResponseWriter, r *http.Request) {
+	if s.traffic == nil {
+		writeError(w, http.StatusNotImplemented, "traffic_unset", "Traffic sampling is not enabled on this server")
+		return
+	}
+	days := s.idleDays
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			writeError(w, http.StatusBadRequest, "invalid_param", "Invalid days parameter")
+			return
+		}
+		days = n
+	}
+	snap, err := s.loadSnapshot(r.Context())
+	if err != nil {
+		status, code, msg := classifyDockerError(err)
+		writeError(w, status, code, msg)
+		return
+	}
+	recs, since, err := s.idlePorts(r.Context(), snap, time.Now(), days)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "store_error", "Cannot read traffic: "+err.Error())
+		return
+	}
+	for i := range recs {
+		recs[i].Reason = localize(w, "No traffic for %d days", days)
+	}
+	s.writeSnapshotHeaders(w, snap)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(RecommendationsResponse{Days: days, Recommendations: recs, Since: since, Meta: s.snapshotMeta(snap)})
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+func TestRecommendations(t *testing.T) {
+	defer func(orig func(context.Context) ([]byte, error)) { readConntrack = orig }(readConntrack)
+	defer func(orig func() ([]byte, error)) { readProcNetTCP = orig }(readProcNetTCP)
+	readConntrack = func(context.Context) ([]byte, error) { return nil, errors.New("no conntrack") }
+	// Someone is connected to 8080 right now
+	readProcNetTCP = func() ([]byte, error) { return []byte(procNetTCP), nil }
+
+	db := openTestDB(t)
+	now := time.Now()
+	server := &Server{
+		client: &MockDockerClient{Containers: []types.Container{
+			{Names: []string{"/web"}, State: "running", Ports: []types.Port{{PublicPort: 8080, PrivatePort: 80, Type: "tcp"}}},
+			{Names: []string{"/wiki"}, State: "running", Ports: []types.Port{{PublicPort: 8081, PrivatePort: 80, Type: "tcp"}}},
+			{Names: []string{"/grafana"}, State: "running", Ports: []types.Port{{PublicPort: 3000, PrivatePort: 3000, Type: "tcp"}}},
+			{Names: []string{"/host"}, State: "running", Ports: []types.Port{{PublicPort: 9000, PrivatePort: 9000, Type: "tcp"}}},
+		}},
+		traffic:  NewTrafficStore(db),
+		idleDays: 14,
+	}
+	get := func() RecommendationsResponse {
+		w := httptest.NewRecorder()
+		SetupRouter(server).ServeHTTP(w, httptest.NewRequest("GET", "/api/recommendations", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d %s", w.Code, w.Body)
+		}
+		var resp RecommendationsResponse
+		json.NewDecoder(w.Body).Decode(&resp)
+		return resp
+	}
+
+	server.traffic.Record(context.Background(), []TrafficSample{
+		{At: now.Add(-3 * 24 * time.Hour), Port: 8080, Protocol: "tcp"},
+		{At: now.Add(-3 * 24 * time.Hour), Port: 8081, Protocol: "tcp"},
+	})
+	if resp := get(); len(resp.Recommendations) != 0 || resp.Since == nil {
+		t.Errorf("Expected nothing idle after three days of sampling, got %+v", resp)
+	}
+
+	server.traffic.Record(context.Background(), []TrafficSample{
+		{At: now.Add(-40 * 24 * time.Hour), Port: 8081, Protocol: "tcp", Connections: 3, BytesIn: 300},
+		{At: now.Add(-20 * 24 * time.Hour), Port: 3000, Protocol: "tcp"},
+		{At: now.Add(-time.Hour), Port: 3000, Protocol: "tcp", Connections: 1, BytesIn: 80},
+	})
+	resp := get()
+	if len(resp.Recommendations) != 1 || resp.Since != nil {
+		t.Fatalf("Expected only the wiki to look abandoned, got %+v", resp)
+	}
+	rec := resp.Recommendations[0]
+	if rec.Port != 8081 || rec.Code != "port_idle" || rec.Reason != "No traffic for 14 days" || rec.LastActive == nil || rec.LastActive.After(now.Add(-39*24*time.Hour)) {
+		t.Errorf("Unexpected recommendation %+v", rec)
+	}
+
+	// A container started last week hasn't had the chance to be used
+	started := now.Add(-7 * 24 * time.Hour)
+	snap := Snapshot{Containers: []ContainerData{{Names: []string{"/wiki"}, State: "running", StartedAt: &started, Ports: []PortMapping{{PublicPort: 8081, PrivatePort: 80, Type: "tcp"}}}}}
+	if recs, _, _ := server.idlePorts(context.Background(), snap, now, 14); len(recs) != 0 {
+		t.Errorf("Expected a recently started container to be left alone, got %+v", recs)
+	}
+
+	w := httptest.NewRecorder()
+	SetupRouter(&Server{client: &MockDockerClient{}}).ServeHTTP(w, httptest.NewRequest("GET", "/api/recommendations", nil))
+	if w.Code != http.StatusNotImplemented {
+		t.Errorf("Expected 501 without traffic sampling, got %d", w.Code)
+	}
+}
+
+func TestDigestListsIdlePorts(t *testing.T) {
+	defer func(orig func(context.Context) ([]byte, error)) { readConntrack = orig }(readConntrack)
+	defer func(orig func() ([]byte, error)) { readProcNetTCP = orig }(readProcNetTCP)
+	readConntrack = func(context.Context) ([]byte, error) { return nil, errors.New("no conntrack") }
+	readProcNetTCP = func() ([]byte, error) { return nil, errors.New("no proc") }
+
+	db := openTestDB(t)
+	now := time.Now()
+	server := &Server{
+		client:   &MockDockerClient{Containers: []types.Container{{Names: []string{"/wiki"}, State: "running", Ports: []types.Port{{PublicPort: 8081, PrivatePort: 80, Type: "tcp"}}}}},
+		history:  NewHistoryStore(db),
+		traffic:  NewTrafficStore(db),
+		idleDays: 14,
+	}
+	server.traffic.Record(context.Background(), []TrafficSample{
+		{At: now.Add(-30 * 24 * time.Hour), Port: 8081, Protocol: "tcp"},
+		{At: now.Add(-time.Hour), Port: 8081, Protocol: "tcp"},
+	})
+	d, err := server.buildDigest(context.Background(), now, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out := d.render(); !strings.Contains(out, "Idle ports") || !strings.Contains(out, "8081/tcp  wiki, no traffic for 14 days") || strings.Contains(out, "Nothing changed") {
+		t.Errorf("Expected the idle wiki in the digest:\n%s", out)
+	}
+}
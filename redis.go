@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// redisReservations shares reservations between quaycheck instances
+// through Redis: the list as JSON under key, its revision under key:rev.
+// It speaks just enough RESP for the commands it needs.
+type redisReservations struct {
+	addr     string
+	username string
+	password string
+	db       int
+	tls      bool
+	key      string
+	timeout  time.Duration
+}
+
+// redisCAS stores ARGV[2] only if the revision is still ARGV[1], atomically
+// since Redis runs scripts one at a time
+const redisCAS = `local rev = tonumber(redis.call('GET', KEYS[2]) or '0')
+if rev ~= tonumber(ARGV[1]) then return false end
+redis.call('SET', KEYS[1], ARGV[2])
+return redis.call('INCR', KEYS[2])`
+
+// sharedRefresh is how long instances sharing a backend may read from
+// their cache before reloading
+const sharedRefresh = time.Second
+
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+// parseRedisURL reads redis://[user:password@]host[:port][/db], or
+// rediss:// for TLS
+func parseRedisURL(raw, key string) (*redisReservations, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "redis" && u.Scheme != "rediss" {
+		return nil, fmt.Errorf("unsupported scheme %q, expected redis or rediss", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return nil, errors.New("missing host")
+	}
+	b := &redisReservations{addr: u.Host, tls: u.Scheme == "rediss", key: key, timeout: 5 * time.Second}
+	if u.Port() == "" {
+		b.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		b.password, _ = u.User.Password()
+		b.username = u.User.Username()
+		if _, set := u.User.Password(); !set {
+			// redis://secret@host: a bare password, as redis-cli takes it
+			b.username, b.password = "", u.User.Username()
+		}
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		if b.db, err = strconv.Atoi(db); err != nil || b.db < 0 {
+			return nil, fmt.Errorf("invalid database %q", db)
+		}
+	}
+	return b, nil
+}
+
+// redisFromEnv returns the Redis backend of QUAYCHECK_REDIS_URL, or nil
+func redisFromEnv() *redisReservations {
+	raw := os.Getenv("QUAYCHECK_REDIS_URL")
+	if raw == "" {
+		return nil
+	}
+	key := os.Getenv("QUAYCHECK_REDIS_KEY")
+	if key == "" {
+		key = "quaycheck:reservations"
+	}
+	b, err := parseRedisURL(raw, key)
+	if err != nil {
+		// Don't echo the URL, it may hold a password
+		log.Fatalf("Invalid QUAYCHECK_REDIS_URL: %v", err)
+	}
+	return b
+}
+
+// do runs commands on a fresh connection and returns their replies. Calls
+// are rare enough (writes, and reads once per sharedRefresh) that
+// reconnecting keeps things simpler than pooling.
+func (b *redisReservations) do(ctx context.Context, cmds ...[]string) ([]any, error) {
+	dialer := &net.Dialer{Timeout: b.timeout}
+	var conn net.Conn
+	var err error
+	if b.tls {
+		host, _, _ := net.SplitHostPort(b.addr)
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: host}}).DialContext(ctx, "tcp", b.addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", b.addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	deadline := time.Now().Add(b.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+
+	var setup [][]string
+	if b.password != "" {
+		if b.username != "" {
+			setup = append(setup, []string{"AUTH", b.username, b.password})
+		} else {
+			setup = append(setup, []string{"AUTH", b.password})
+		}
+	}
+	if b.db != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(b.db)})
+	}
+	all := append(setup, cmds...)
+
+	w := bufio.NewWriter(conn)
+	for _, cmd := range all {
+		fmt.Fprintf(w, "*%d\r\n", len(cmd))
+		for _, arg := range cmd {
+			fmt.Fprintf(w, "$%d\r\n%s\r\n", len(arg), arg)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+	r := bufio.NewReader(conn)
+	replies := make([]any, 0, len(cmds))
+	for i := range all {
+		reply, err := readRESP(r)
+		if err != nil {
+			return nil, err
+		}
+		if i >= len(setup) {
+			replies = append(replies, reply)
+		}
+	}
+	return replies, nil
+}
+
+// readRESP reads one reply: string, int64, nil, []any, or a redisError
+// returned as the error
+func readRESP(r *bufio.Reader) (any, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]any, n)
+		for i := range items {
+			if items[i], err = readRESP(r); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply %q", line)
+}
+
+func (b *redisReservations) Load(ctx context.Context) ([]Reservation, int64, error) {
+	replies, err := b.do(ctx, []string{"MGET", b.key, b.key + ":rev"})
+	if err != nil {
+		return nil, 0, err
+	}
+	values, ok := replies[0].([]any)
+	if !ok || len(values) != 2 {
+		return nil, 0, fmt.Errorf("redis: unexpected MGET reply %v", replies[0])
+	}
+	var list []Reservation
+	if data, ok := values[0].(string); ok {
+		if err := json.Unmarshal([]byte(data), &list); err != nil {
+			return nil, 0, fmt.Errorf("redis: %s: %w", b.key, err)
+		}
+		for i := range list {
+			// Written by a version without revisions
+			list[i].Revision = max(list[i].Revision, 1)
+		}
+	}
+	var rev int64
+	if s, ok := values[1].(string); ok {
+		if rev, err = strconv.ParseInt(s, 10, 64); err != nil {
+			return nil, 0, fmt.Errorf("redis: %s:rev: %w", b.key, err)
+		}
+	}
+	return list, rev, nil
+}
+
+func (b *redisReservations) Store(ctx context.Context, rev int64, list []Reservation) (int64, error) {
+	data, err := json.Marshal(list)
+	if err != nil {
+		return 0, err
+	}
+	replies, err := b.do(ctx, []string{"EVAL", redisCAS, "2", b.key, b.key + ":rev", strconv.FormatInt(rev, 10), string(data)})
+	if err != nil {
+		return 0, err
+	}
+	switch v := replies[0].(type) {
+	case nil:
+		return 0, errStaleRevision
+	case int64:
+		return v, nil
+	}
+	return 0, fmt.Errorf("redis: unexpected EVAL reply %v", replies[0])
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// reportTemplate lays the port plan out for paper: A4, table headers
+// repeated on every page, rows never split across pages, no UI chrome
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"join": strings.Join,
+	"date": func(t time.Time) string { return t.Local().Format("2006-01-02 15:04 MST") },
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Port plan - {{.Host}} - {{date .GeneratedAt}}</title>
+<style>
+  @page { size: A4; margin: 15mm 12mm; }
+  body { font: 10pt/1.35 Helvetica, Arial, sans-serif; color: #000; margin: 0 auto; max-width: 190mm; }
+  h1 { font-size: 16pt; margin: 0 0 2mm; }
+  h2 { font-size: 12pt; margin: 8mm 0 2mm; border-bottom: 1px solid #000; break-after: avoid; }
+  .meta { color: #333; margin-bottom: 4mm; }
+  .summary td { padding-right: 8mm; }
+  table.list { width: 100%; border-collapse: collapse; }
+  table.list th, table.list td { border: 1px solid #999; padding: 1mm 1.5mm; text-align: left; vertical-align: top; }
+  table.list th { background: #eee; }
+  table.list thead { display: table-header-group; }
+  table.list tr { break-inside: avoid; }
+  td.num { text-align: right; font-variant-numeric: tabular-nums; }
+  .empty { color: #555; font-style: italic; }
+  .warn { font-weight: bold; }
+  footer { margin-top: 10mm; font-size: 8pt; color: #555; }
+  @media screen { body { padding: 10mm; } }
+</style>
+</head>
+<body>
+<h1>Port plan: {{.Host}}</h1>
+<div class="meta">Generated {{date .GeneratedAt}}{{if .Stale}} from data older than usual; some sources did not answer{{end}}</div>
+<table class="summary"><tr>
+  <td><b>{{len .Entries}}</b> published ports</td>
+  <td><b>{{len .Reservations}}</b> reservations</td>
+  <td><b>{{len .Freezes}}</b> active freezes</td>
+  <td{{if .Conflicts}} class="warn"{{end}}><b>{{len .Conflicts}}</b> conflicts</td>
+</tr></table>
+
+{{if .Conflicts}}
+<h2>Conflicts</h2>
+<table class="list">
+<thead><tr><th>Port</th><th>Protocol</th><th>Claimed by</th></tr></thead>
+<tbody>{{range .Conflicts}}<tr><td class="num">{{.Port}}</td><td>{{.Protocol}}</td><td>{{join .Holders ", "}}</td></tr>
+{{end}}</tbody>
+</table>
+{{end}}
+
+<h2>Published ports</h2>
+{{if .Entries}}
+<table class="list">
+<thead><tr><th>Port</th><th>Protocol</th><th>Address</th><th>Owner</th><th>Source</th><th>Image</th><th>Project</th><th>Notes</th></tr></thead>
+<tbody>{{range .Entries}}<tr><td class="num">{{.Port}}</td><td>{{.Protocol}}</td><td>{{if .IP}}{{.IP}}{{else}}all{{end}}{{with index $.Scopes .IP}} ({{.}}){{end}}</td><td>{{.Owner}}</td><td>{{.Source}}</td><td>{{.Image}}</td><td>{{.Project}}</td><td>{{join .Tags ", "}}{{if and .Tags .Note}}; {{end}}{{.Note}}</td></tr>
+{{end}}</tbody>
+</table>
+{{else}}<p class="empty">No published ports.</p>{{end}}
+
+<h2>Reservations</h2>
+{{if .Reservations}}
+<table class="list">
+<thead><tr><th>Port</th><th>Protocol</th><th>Name</th><th>Owner</th><th>Note</th><th>Since</th></tr></thead>
+<tbody>{{range .Reservations}}<tr><td class="num">{{.Port}}</td><td>{{.Protocol}}</td><td>{{.Name}}</td><td>{{.Owner}}</td><td>{{.Note}}</td><td>{{date .CreatedAt}}</td></tr>
+{{end}}</tbody>
+</table>
+{{else}}<p class="empty">No reservations.</p>{{end}}
+
+<h2>Freezes</h2>
+{{if .Freezes}}
+<table class="list">
+<thead><tr><th>Ports</th><th>Reason</th><th>Until</th></tr></thead>
+<tbody>{{range .Freezes}}<tr><td>{{if or .Start .End}}{{.Start}}-{{.End}}{{else}}all{{end}}</td><td>{{.Reason}}</td><td>{{if .Until}}{{date .Until}}{{else}}until lifted{{end}}</td></tr>
+{{end}}</tbody>
+</table>
+{{else}}<p class="empty">No active freezes.</p>{{end}}
+
+<footer>quaycheck port plan for {{.Host}}, generated {{date .GeneratedAt}}.</footer>
+</body>
+</html>
+`))
+
+// reportData is everything the printed report shows
+type reportData struct {
+	Host         string
+	GeneratedAt  time.Time
+	Stale        bool
+	Entries      []AllocationEntry
+	Reservations []Reservation
+	Freezes      []Freeze
+	Conflicts    []PortConflict
+	// Scopes maps each bind address to the widest network it's open to
+	Scopes map[string]string
+}
+
+// handleReport renders the full port plan as print-ready HTML; print it
+// or save it as PDF from the browser
+func (s *Server) handleReport(w http.ResponseWriter, r *http.Request) {
+	snap, err := s.loadSnapshot(r.Context())
+	if err != nil {
+		status, code, msg := classifyDockerError(err)
+		writeError(w, status, code, msg)
+		return
+	}
+
+	data := reportData{GeneratedAt: time.Now(), Stale: snap.Stale}
+	data.Host, _ = os.Hostname()
+	var tags []PortTag
+	if s.tags != nil {
+		tags = s.tags.List()
+	}
+	data.Entries = allocationEntries(snap.Containers, tags)
+	if s.reservations != nil {
+		data.Reservations = s.reservations.List()
+	}
+	if s.freezes != nil {
+		for _, f := range s.freezes.List() {
+			if f.active(data.GeneratedAt) {
+				data.Freezes = append(data.Freezes, f)
+			}
+		}
+	}
+	ifaces, _ := hostInterfaces()
+	classifyInterfaces(ifaces)
+	data.Scopes = make(map[string]string)
+	for _, e := range data.Entries {
+		if _, ok := data.Scopes[e.IP]; !ok {
+			data.Scopes[e.IP] = bindScope(e.IP, ifaces)
+		}
+	}
+	data.Conflicts = portConflicts(snap.Containers, func(c ContainerData) bool { return occupiesPorts(c.State) })
+
+	s.writeSnapshotHeaders(w, snap)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := reportTemplate.Execute(w, data); err != nil {
+		log.Printf("Rendering report failed: %v", err)
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+func TestReport(t *testing.T) {
+	mock := &MockDockerClient{Containers: []types.Container{
+		{ID: "a", Names: []string{"/web"}, Image: "nginx", State: "running", Ports: []types.Port{{PublicPort: 8080, Type: "tcp"}}},
+		{ID: "b", Names: []string{"/<script>"}, State: "running", Ports: []types.Port{{PublicPort: 8080, Type: "tcp"}}},
+	}}
+	freezes, _ := NewFreezeStore(nil)
+	until := time.Now().Add(time.Hour)
+	freezes.Add(Freeze{Start: 9000, End: 9100, Reason: "migration", Until: &until})
+	router := SetupRouter(&Server{client: mock, freezes: freezes})
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/export/report", nil))
+	if rr.Code != 200 || !strings.HasPrefix(rr.Header().Get("Content-Type"), "text/html") {
+		t.Fatalf("Expected an HTML report, got %d %s", rr.Code, rr.Header().Get("Content-Type"))
+	}
+	body := rr.Body.String()
+	for _, want := range []string{"@page", "<h2>Conflicts</h2>", "nginx", "9000-9100", "migration", "&lt;script&gt;"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected the report to contain %q", want)
+		}
+	}
+	if strings.Contains(body, "<script>") {
+		t.Error("Expected container names to be escaped")
+	}
+}
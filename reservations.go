@@ -0,0 +1,820 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"quaycheck/internal/storage"
+)
+
+// Reservation claims a host port ahead of the container that will use it
+type Reservation struct {
+	Name      string    `json:"name"`
+	Port      int       `json:"port"`
+	Protocol  string    `json:"protocol"`
+	Owner     string    `json:"owner,omitempty"`
+	Note      string    `json:"note,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	// Revision goes up with every change; it's served as the ETag
+	Revision int64 `json:"revision"`
+	// DeletedAt is set while the reservation sits in the trash
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	// Environment scopes the reservation to one environment's hosts; empty
+	// holds the port in every environment
+	Environment string `json:"environment,omitempty"`
+	// Public asks for a forward on the router, with QUAYCHECK_ROUTER_FORWARD
+	Public bool `json:"public,omitempty"`
+}
+
+// etag is the reservation's revision as a strong entity tag
+func (r Reservation) etag() string {
+	return `"` + strconv.FormatInt(r.Revision, 10) + `"`
+}
+
+var (
+	errPortTaken  = errors.New("port is already in use")
+	errNoFreePort = errors.New("no free ports found in range")
+
+	errReservationExists = errors.New("reservation already exists")
+
+	errReservationNotFound = errors.New("no reservation with this name")
+	// errRevisionMismatch means the caller acted on an outdated copy
+	errRevisionMismatch = errors.New("reservation was changed since it was read")
+	errNotInTrash       = errors.New("no deleted reservation with this name")
+)
+
+// defaultTrashRetention is how long deleted reservations can be restored
+const defaultTrashRetention = 7 * 24 * time.Hour
+
+// anyRevision skips the revision check, for If-Match: *
+const anyRevision = -1
+
+// reservationKey identifies a reservation: names are unique within an
+// environment, so staging and prod can each hold a "grafana"
+type reservationKey struct {
+	Environment string
+	Name        string
+}
+
+func (r Reservation) key() reservationKey {
+	return reservationKey{Environment: r.Environment, Name: r.Name}
+}
+
+// ReservationBackend is where reservations live. Writes are
+// compare-and-swap on a revision, so instances sharing a backend never
+// overwrite each other's changes.
+type ReservationBackend interface {
+	// Load returns every reservation and the revision they were read at
+	Load(ctx context.Context) ([]Reservation, int64, error)
+	// Store replaces every reservation with list if the backend is still at
+	// rev, and returns the new revision; otherwise it fails with
+	// errStaleRevision and changes nothing
+	Store(ctx context.Context, rev int64, list []Reservation) (int64, error)
+}
+
+var errStaleRevision = errors.New("reservations changed concurrently")
+
+// casAttempts bounds how often a write is retried against newer revisions
+const casAttempts = 5
+
+// ReservationStore caches reservations from a backend. Without a database
+// they only live in memory.
+type ReservationStore struct {
+	backend ReservationBackend
+	// refresh is how stale the cache may get before reads reload it, for a
+	// backend other instances write to; 0 trusts the cache
+	refresh time.Duration
+	// TrashFor is how long deleted reservations stay restorable; 0 deletes
+	// them outright
+	TrashFor time.Duration
+
+	mu    sync.Mutex
+	items map[reservationKey]Reservation
+	// trash holds the last deleted reservation of each name
+	trash  map[reservationKey]Reservation
+	rev    int64
+	loaded time.Time
+}
+
+func NewReservationStore(db *storage.DB) (*ReservationStore, error) {
+	if db == nil {
+		return NewSharedReservationStore(&memoryReservations{}, 0)
+	}
+	return NewSharedReservationStore(&sqliteReservations{db: db}, 0)
+}
+
+func NewSharedReservationStore(backend ReservationBackend, refresh time.Duration) (*ReservationStore, error) {
+	s := &ReservationStore{backend: backend, refresh: refresh, TrashFor: defaultTrashRetention}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reload replaces the cached reservations with the backend's
+func (s *ReservationStore) Reload() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.reloadLocked()
+}
+
+func (s *ReservationStore) reloadLocked() error {
+	list, rev, err := s.backend.Load(context.Background())
+	if err != nil {
+		return err
+	}
+	items := make(map[reservationKey]Reservation, len(list))
+	trash := make(map[reservationKey]Reservation)
+	for _, r := range list {
+		if r.DeletedAt != nil {
+			trash[r.key()] = r
+		} else {
+			items[r.key()] = r
+		}
+	}
+	s.items, s.trash, s.rev, s.loaded = items, trash, rev, time.Now()
+	return nil
+}
+
+// refreshLocked reloads a cache older than refresh. Reads carry on with
+// the stale copy if the backend is down; writes will fail anyway.
+func (s *ReservationStore) refreshLocked() {
+	if s.refresh <= 0 || time.Since(s.loaded) < s.refresh {
+		return
+	}
+	if err := s.reloadLocked(); err != nil {
+		log.Printf("Cannot reload reservations: %v", err)
+	}
+}
+
+// updateLocked applies change to a copy of the latest reservations and
+// stores it, starting over from a fresh load when another instance wrote
+// in between. change reports whether there is anything to store.
+func (s *ReservationStore) updateLocked(change func(items map[reservationKey]Reservation) (bool, error)) error {
+	return s.updateWithTrashLocked(func(items, _ map[reservationKey]Reservation) (bool, error) {
+		return change(items)
+	})
+}
+
+// updateWithTrashLocked is updateLocked for changes that touch the trash.
+// Expired trash is dropped with every write.
+func (s *ReservationStore) updateWithTrashLocked(change func(items, trash map[reservationKey]Reservation) (bool, error)) error {
+	s.refreshLocked()
+	for attempt := 1; ; attempt++ {
+		items, trash := maps.Clone(s.items), maps.Clone(s.trash)
+		changed, err := change(items, trash)
+		if err != nil || !changed {
+			return err
+		}
+		s.expireLocked(trash)
+		list := append(sortedReservations(items), sortedReservations(trash)...)
+		rev, err := s.backend.Store(context.Background(), s.rev, list)
+		if errors.Is(err, errStaleRevision) && attempt < casAttempts {
+			if err := s.reloadLocked(); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		s.items, s.trash, s.rev = items, trash, rev
+		return nil
+	}
+}
+
+func (s *ReservationStore) expireLocked(trash map[reservationKey]Reservation) {
+	cutoff := time.Now().Add(-s.TrashFor)
+	for k, r := range trash {
+		if s.TrashFor <= 0 || r.DeletedAt.Before(cutoff) {
+			delete(trash, k)
+		}
+	}
+}
+
+func sortedReservations(items map[reservationKey]Reservation) []Reservation {
+	list := make([]Reservation, 0, len(items))
+	for _, r := range items {
+		list = append(list, r)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Port < list[j].Port })
+	return list
+}
+
+func (s *ReservationStore) List() []Reservation {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refreshLocked()
+	return sortedReservations(s.items)
+}
+
+// Get returns the reservation named name in environment env
+func (s *ReservationStore) Get(env, name string) (Reservation, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refreshLocked()
+	r, ok := s.items[reservationKey{Environment: env, Name: name}]
+	return r, ok
+}
+
+func (s *ReservationStore) Delete(env, name string) (bool, error) {
+	err := s.DeleteIf(env, name, anyRevision)
+	if errors.Is(err, errReservationNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// DeleteIf deletes a reservation only while it's still at rev
+func (s *ReservationStore) DeleteIf(env, name string, rev int64) error {
+	key := reservationKey{Environment: env, Name: name}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.updateWithTrashLocked(func(items, trash map[reservationKey]Reservation) (bool, error) {
+		existing, ok := items[key]
+		if !ok {
+			return false, errReservationNotFound
+		}
+		if rev != anyRevision && existing.Revision != rev {
+			return false, errRevisionMismatch
+		}
+		delete(items, key)
+		now := time.Now().UTC()
+		existing.DeletedAt, existing.Revision = &now, existing.Revision+1
+		trash[key] = existing
+		return true, nil
+	})
+}
+
+// Trash lists deleted reservations that can still be restored, most
+// recently deleted first
+func (s *ReservationStore) Trash() []Reservation {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refreshLocked()
+	trash := maps.Clone(s.trash)
+	s.expireLocked(trash)
+	list := sortedReservations(trash)
+	sort.SliceStable(list, func(i, j int) bool { return list[i].DeletedAt.After(*list[j].DeletedAt) })
+	return list
+}
+
+// Restore brings a deleted reservation back, unless its name or port has
+// been taken since. ix holds ports taken by anything other than
+// reservations.
+func (s *ReservationStore) Restore(env, name string, ix *PortIndex) (Reservation, error) {
+	key := reservationKey{Environment: env, Name: name}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var result Reservation
+	err := s.updateWithTrashLocked(func(items, trash map[reservationKey]Reservation) (bool, error) {
+		s.expireLocked(trash)
+		r, ok := trash[key]
+		if !ok {
+			return false, errNotInTrash
+		}
+		if _, exists := items[key]; exists {
+			return false, errReservationExists
+		}
+		if ix.Used(r.Port) {
+			return false, errPortTaken
+		}
+		for _, other := range items {
+			if other.Port == r.Port && sharesEnvironment(other.Environment, r.Environment) {
+				return false, errPortTaken
+			}
+		}
+		delete(trash, key)
+		r.DeletedAt, r.Revision = nil, r.Revision+1
+		items[key] = r
+		result = r
+		return true, nil
+	})
+	return result, err
+}
+
+// Purge deletes a reservation from the trash for good
+func (s *ReservationStore) Purge(env, name string) error {
+	key := reservationKey{Environment: env, Name: name}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.updateWithTrashLocked(func(_, trash map[reservationKey]Reservation) (bool, error) {
+		if _, ok := trash[key]; !ok {
+			return false, errNotInTrash
+		}
+		delete(trash, key)
+		return true, nil
+	})
+}
+
+// Update replaces a reservation's port, protocol, owner and note while it's
+// still at rev. A zero port keeps the current one; ix holds ports taken by
+// anything other than reservations.
+func (s *ReservationStore) Update(env, name string, rev int64, r Reservation, ix *PortIndex) (Reservation, error) {
+	key := reservationKey{Environment: env, Name: name}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var result Reservation
+	err := s.updateLocked(func(items map[reservationKey]Reservation) (bool, error) {
+		existing, ok := items[key]
+		if !ok {
+			return false, errReservationNotFound
+		}
+		if rev != anyRevision && existing.Revision != rev {
+			return false, errRevisionMismatch
+		}
+		if r.Port == 0 {
+			r.Port = existing.Port
+		}
+		if r.Port != existing.Port {
+			if ix.Used(r.Port) {
+				return false, errPortTaken
+			}
+			for _, other := range items {
+				if other.key() != key && other.Port == r.Port && sharesEnvironment(other.Environment, existing.Environment) {
+					return false, errPortTaken
+				}
+			}
+		}
+		if r.Protocol == "" {
+			r.Protocol = existing.Protocol
+		}
+		r.Name, r.CreatedAt, r.Revision = name, existing.CreatedAt, existing.Revision+1
+		r.Environment = existing.Environment
+		items[key] = r
+		result = r
+		return true, nil
+	})
+	return result, err
+}
+
+// Import adds reservations as they are, replacing any of the same name and
+// environment
+func (s *ReservationStore) Import(list []Reservation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.updateLocked(func(items map[reservationKey]Reservation) (bool, error) {
+		for _, r := range list {
+			r.Revision = max(r.Revision, 1)
+			items[r.key()] = r
+		}
+		return len(list) > 0, nil
+	})
+}
+
+// Ensure makes sure a reservation named r.Name exists in r.Environment. If r.Port is zero an
+// existing reservation is kept as is, otherwise a free port from start is
+// picked. ix holds ports taken by anything other than reservations. With
+// dryRun nothing is saved. It reports whether anything changed.
+func (s *ReservationStore) Ensure(r Reservation, ix *PortIndex, start int, dryRun bool) (Reservation, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	want := r
+	var result Reservation
+	var changed bool
+	plan := func(items map[reservationKey]Reservation) (bool, error) {
+		r := want
+		existing, exists := items[r.key()]
+		if exists && (r.Port == 0 || r.Port == existing.Port) {
+			result, changed = existing, false
+			return false, nil
+		}
+
+		taken := ix.Clone()
+		for _, other := range items {
+			if other.key() != r.key() && sharesEnvironment(other.Environment, r.Environment) {
+				taken.Occupy(other.Port)
+			}
+		}
+
+		if r.Port == 0 {
+			r.Port = taken.NextFree(start)
+			if r.Port == -1 {
+				return false, errNoFreePort
+			}
+		} else if taken.Used(r.Port) {
+			return false, errPortTaken
+		}
+
+		if r.Protocol == "" {
+			r.Protocol = "tcp"
+		}
+		r.CreatedAt, r.Revision = time.Now().UTC(), 1
+		if exists {
+			r.CreatedAt, r.Revision = existing.CreatedAt, existing.Revision+1
+		}
+		items[r.key()] = r
+		result, changed = r, true
+		return !dryRun, nil
+	}
+
+	var err error
+	if dryRun {
+		s.refreshLocked()
+		_, err = plan(maps.Clone(s.items))
+	} else {
+		err = s.updateLocked(plan)
+	}
+	if err != nil {
+		return Reservation{}, false, err
+	}
+	return result, changed, nil
+}
+
+// memoryReservations keeps reservations for a server without a database
+type memoryReservations struct {
+	mu   sync.Mutex
+	list []Reservation
+	rev  int64
+}
+
+func (m *memoryReservations) Load(ctx context.Context) ([]Reservation, int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Clone(m.list), m.rev, nil
+}
+
+func (m *memoryReservations) Store(ctx context.Context, rev int64, list []Reservation) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if rev != m.rev {
+		return 0, errStaleRevision
+	}
+	m.list = slices.Clone(list)
+	m.rev++
+	return m.rev, nil
+}
+
+// sqliteReservations keeps reservations in the state database, with their
+// revision in the revisions table
+type sqliteReservations struct {
+	db *storage.DB
+}
+
+func (b *sqliteReservations) Load(ctx context.Context) ([]Reservation, int64, error) {
+	tx, err := b.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, 0, err
+	}
+	defer tx.Rollback()
+	rev, err := reservationsRevision(ctx, tx)
+	if err != nil {
+		return nil, 0, err
+	}
+	rows, err := tx.QueryContext(ctx, `SELECT name, port, protocol, owner, note, created_at, revision, deleted_at, environment, public FROM reservations`)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	var list []Reservation
+	for rows.Next() {
+		var r Reservation
+		var created, deleted int64
+		if err := rows.Scan(&r.Name, &r.Port, &r.Protocol, &r.Owner, &r.Note, &created, &r.Revision, &deleted, &r.Environment, &r.Public); err != nil {
+			return nil, 0, err
+		}
+		r.CreatedAt = time.Unix(0, created).UTC()
+		if deleted != 0 {
+			at := time.Unix(0, deleted).UTC()
+			r.DeletedAt = &at
+		}
+		list = append(list, r)
+	}
+	return list, rev, rows.Err()
+}
+
+func (b *sqliteReservations) Store(ctx context.Context, rev int64, list []Reservation) (int64, error) {
+	tx, err := b.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	current, err := reservationsRevision(ctx, tx)
+	if err != nil {
+		return 0, err
+	}
+	if current != rev {
+		return 0, errStaleRevision
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM reservations`); err != nil {
+		return 0, err
+	}
+	for _, r := range list {
+		var deleted int64
+		if r.DeletedAt != nil {
+			deleted = r.DeletedAt.UnixNano()
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO reservations (name, port, protocol, owner, note, created_at, revision, deleted_at, environment, public) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			r.Name, r.Port, r.Protocol, r.Owner, r.Note, r.CreatedAt.UnixNano(), r.Revision, deleted, r.Environment, r.Public); err != nil {
+			return 0, err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO revisions (name, rev) VALUES ('reservations', ?) ON CONFLICT (name) DO UPDATE SET rev = excluded.rev`, rev+1); err != nil {
+		return 0, err
+	}
+	return rev + 1, tx.Commit()
+}
+
+func reservationsRevision(ctx context.Context, tx *sql.Tx) (int64, error) {
+	var rev int64
+	err := tx.QueryRowContext(ctx, `SELECT rev FROM revisions WHERE name = 'reservations'`).Scan(&rev)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return rev, err
+}
+
+func (s *ReservationStore) Name() string { return "reservation" }
+
+// Containers exposes reservations as inventory entries so every check and
+// suggestion treats reserved ports as used
+func (s *ReservationStore) Containers(ctx context.Context) ([]ContainerData, error) {
+	var result []ContainerData
+	for _, r := range s.List() {
+		id := "reservation:" + r.Name
+		if r.Environment != "" {
+			id = "reservation:" + r.Environment + "/" + r.Name
+		}
+		result = append(result, ContainerData{
+			ID:     id,
+			Names:  []string{r.Name},
+			Image:  r.Owner,
+			State:  "reserved",
+			Source: s.Name(),
+
+			Environment: r.Environment,
+			Ports: []PortMapping{{
+				PrivatePort: uint16(r.Port),
+				PublicPort:  uint16(r.Port),
+				Type:        r.Protocol,
+			}},
+		})
+	}
+	return result, nil
+}
+
+// usedWithoutReservations returns the index of live ports taken by
+// everything except the reservation store itself, so the store can reason
+// about its own entries
+func (s *Server) usedWithoutReservations(ctx context.Context) (*PortIndex, error) {
+	snap, err := s.collectScoped(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return snap.Index, nil
+}
+
+type ReservationRequest struct {
+	Name     string `json:"name"`
+	Port     int    `json:"port,omitempty"`
+	Start    int    `json:"start,omitempty"`
+	Protocol string `json:"protocol,omitempty"`
+	Owner    string `json:"owner,omitempty"`
+	Note     string `json:"note,omitempty"`
+	Public   bool   `json:"public,omitempty"`
+}
+
+func (s *Server) handleListReservations(w http.ResponseWriter, r *http.Request) {
+	list := s.reservations.List()
+	if env := environmentFrom(r.Context()); env != "" {
+		list = slices.DeleteFunc(list, func(res Reservation) bool { return !sharesEnvironment(res.Environment, env) })
+	}
+	writeEncoded(w, r, list)
+}
+
+func (s *Server) handleCreateReservation(w http.ResponseWriter, r *http.Request) {
+	var req ReservationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Name == "" {
+		writeError(w, http.StatusBadRequest, "invalid_body", "Expected a JSON body with at least a name")
+		return
+	}
+	if req.Port < 0 || req.Port > maxPort {
+		writeError(w, http.StatusBadRequest, "invalid_param", "Invalid port parameter")
+		return
+	}
+	if _, exists := s.reservations.Get(environmentFrom(r.Context()), req.Name); exists {
+		writeError(w, http.StatusConflict, "reservation_exists", "A reservation with this name already exists")
+		return
+	}
+
+	used, err := s.usedWithoutReservations(r.Context())
+	if err != nil {
+		status, code, msg := classifyDockerError(err)
+		writeError(w, status, code, msg)
+		return
+	}
+
+	if req.Port == 0 {
+		used = s.pickable(used)
+	}
+
+	// Plan first so a frozen port is refused before anything is saved
+	want := reservationFromRequest(req)
+	want.Environment = environmentFrom(r.Context())
+	res, _, err := s.reservations.Ensure(want, used, suggestStart(req.Start), true)
+	if err == nil {
+		if s.writeIfFrozen(w, res.Port) {
+			return
+		}
+		res, _, err = s.reservations.Ensure(want, used, suggestStart(req.Start), false)
+	}
+	if err != nil {
+		writeReservationError(w, err)
+		return
+	}
+	s.audit(r, "reservation.create", res.Name, strconv.Itoa(res.Port))
+	w.Header().Set("ETag", res.etag())
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(res)
+}
+
+func (s *Server) handleGetReservation(w http.ResponseWriter, r *http.Request) {
+	res, ok := s.reservations.Get(environmentFrom(r.Context()), r.PathValue("name"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "not_found", "No reservation with this name")
+		return
+	}
+	w.Header().Set("ETag", res.etag())
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+// ifMatch reads the revision a change is conditional on. Changes must say
+// which revision they saw, so a pipeline acting on an old read can't
+// clobber another's update; If-Match: * opts out explicitly.
+func ifMatch(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	v := r.Header.Get("If-Match")
+	switch {
+	case v == "":
+		writeError(w, http.StatusPreconditionRequired, "precondition_required", "Send If-Match with the reservation's ETag, or * to skip the check")
+		return 0, false
+	case v == "*":
+		return anyRevision, true
+	}
+	rev, err := strconv.ParseInt(strings.Trim(v, `"`), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusPreconditionFailed, "precondition_failed", "The reservation was changed since you read it")
+		return 0, false
+	}
+	return rev, true
+}
+
+func (s *Server) handleUpdateReservation(w http.ResponseWriter, r *http.Request) {
+	var req ReservationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_body", "Expected a JSON body")
+		return
+	}
+	if req.Port < 0 || req.Port > maxPort {
+		writeError(w, http.StatusBadRequest, "invalid_param", "Invalid port parameter")
+		return
+	}
+	rev, ok := ifMatch(w, r)
+	if !ok {
+		return
+	}
+	if req.Port != 0 && s.writeIfFrozen(w, req.Port) {
+		return
+	}
+	used, err := s.usedWithoutReservations(r.Context())
+	if err != nil {
+		status, code, msg := classifyDockerError(err)
+		writeError(w, status, code, msg)
+		return
+	}
+	res, err := s.reservations.Update(environmentFrom(r.Context()), r.PathValue("name"), rev, reservationFromRequest(req), used)
+	if err != nil {
+		writeReservationError(w, err)
+		return
+	}
+	s.audit(r, "reservation.update", res.Name, strconv.Itoa(res.Port))
+	w.Header().Set("ETag", res.etag())
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+func (s *Server) handleDeleteReservation(w http.ResponseWriter, r *http.Request) {
+	rev, ok := ifMatch(w, r)
+	if !ok {
+		return
+	}
+	if err := s.reservations.DeleteIf(environmentFrom(r.Context()), r.PathValue("name"), rev); err != nil {
+		writeReservationError(w, err)
+		return
+	}
+	s.audit(r, "reservation.delete", r.PathValue("name"), "")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// trashRetentionFromEnv reads QUAYCHECK_RESERVATION_TRASH, e.g. 7d; 0
+// deletes reservations outright
+func trashRetentionFromEnv() time.Duration {
+	if v := os.Getenv("QUAYCHECK_RESERVATION_TRASH"); v != "" {
+		if d, err := parseRetentionAge(v); err == nil {
+			return d
+		}
+		log.Printf("Ignoring QUAYCHECK_RESERVATION_TRASH=%q", v)
+	}
+	return defaultTrashRetention
+}
+
+func (s *Server) handleListTrash(w http.ResponseWriter, r *http.Request) {
+	list := s.reservations.Trash()
+	if env := environmentFrom(r.Context()); env != "" {
+		list = slices.DeleteFunc(list, func(res Reservation) bool { return !sharesEnvironment(res.Environment, env) })
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+func (s *Server) handleRestoreReservation(w http.ResponseWriter, r *http.Request) {
+	env, name := environmentFrom(r.Context()), r.PathValue("name")
+	var port int
+	for _, t := range s.reservations.Trash() {
+		if t.Environment == env && t.Name == name {
+			port = t.Port
+		}
+	}
+	if port != 0 && s.writeIfFrozen(w, port) {
+		return
+	}
+	used, err := s.usedWithoutReservations(r.Context())
+	if err != nil {
+		status, code, msg := classifyDockerError(err)
+		writeError(w, status, code, msg)
+		return
+	}
+	res, err := s.reservations.Restore(env, name, used)
+	if err != nil {
+		writeReservationError(w, err)
+		return
+	}
+	s.audit(r, "reservation.restore", res.Name, strconv.Itoa(res.Port))
+	w.Header().Set("ETag", res.etag())
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+func (s *Server) handlePurgeReservation(w http.ResponseWriter, r *http.Request) {
+	if err := s.reservations.Purge(environmentFrom(r.Context()), r.PathValue("name")); err != nil {
+		writeReservationError(w, err)
+		return
+	}
+	s.audit(r, "reservation.purge", r.PathValue("name"), "")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func reservationFromRequest(req ReservationRequest) Reservation {
+	return Reservation{
+		Name:     req.Name,
+		Port:     req.Port,
+		Protocol: req.Protocol,
+		Owner:    req.Owner,
+		Note:     req.Note,
+		Public:   req.Public,
+	}
+}
+
+// suggestStart applies the same defaults as /api/suggest
+func suggestStart(start int) int {
+	if start == 0 {
+		return 8000
+	}
+	if start < 1024 {
+		return 1024
+	}
+	return start
+}
+
+func writeReservationError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errPortTaken):
+		writeError(w, http.StatusConflict, "port_in_use", "Port is already in use or reserved")
+	case errors.Is(err, errNoFreePort):
+		writeError(w, http.StatusConflict, "no_free_port", "No free ports found in range")
+	case errors.Is(err, errReservationExists):
+		writeError(w, http.StatusConflict, "reservation_exists", "A reservation with this name already exists")
+	case errors.Is(err, errNotInTrash):
+		writeError(w, http.StatusNotFound, "not_found", "No deleted reservation with this name")
+	case errors.Is(err, errReservationNotFound):
+		writeError(w, http.StatusNotFound, "not_found", "No reservation with this name")
+	case errors.Is(err, errRevisionMismatch):
+		writeError(w, http.StatusPreconditionFailed, "precondition_failed", "The reservation was changed since you read it")
+	default:
+		writeError(w, http.StatusInternalServerError, "store_error", "Cannot save reservations: "+err.Error())
+	}
+}
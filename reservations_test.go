@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+)
+
+func TestReservationStoreEnsure(t *testing.T) {
+	db := openTestDB(t)
+	store, err := NewReservationStore(db)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	used := buildPortIndex([]ContainerData{{State: "running", Ports: []PortMapping{{PublicPort: 8000}}}})
+	res, changed, err := store.Ensure(Reservation{Name: "grafana"}, used, 8000, false)
+	if err != nil || !changed || res.Port != 8001 {
+		t.Fatalf("Expected new reservation on 8001, got %+v changed=%v err=%v", res, changed, err)
+	}
+
+	res, changed, _ = store.Ensure(Reservation{Name: "grafana"}, used, 8000, false)
+	if changed || res.Port != 8001 {
+		t.Errorf("Expected ensure to be idempotent, got %+v changed=%v", res, changed)
+	}
+
+	if _, _, err := store.Ensure(Reservation{Name: "other", Port: 8001}, used, 8000, false); err != errPortTaken {
+		t.Errorf("Expected errPortTaken for a reserved port, got %v", err)
+	}
+
+	res, changed, _ = store.Ensure(Reservation{Name: "prom"}, used, 8000, true)
+	if !changed || res.Port != 8002 {
+		t.Errorf("Expected dry run to pick 8002, got %+v", res)
+	}
+	if _, ok := store.Get("", "prom"); ok {
+		t.Error("Expected dry run not to save")
+	}
+
+	reloaded, err := NewReservationStore(db)
+	if err != nil {
+		t.Fatalf("Expected reload to succeed, got %v", err)
+	}
+	if r, ok := reloaded.Get("", "grafana"); !ok || r.Port != 8001 {
+		t.Errorf("Expected grafana to persist, got %+v", r)
+	}
+}
+
+func TestReservationsCountAsUsed(t *testing.T) {
+	store, _ := NewReservationStore(nil)
+	store.Ensure(Reservation{Name: "grafana", Port: 3000}, nil, 0, false)
+	server := &Server{client: &MockDockerClient{}, reservations: store}
+
+	containers, _ := server.getContainers(context.Background())
+	if !buildPortIndex(containers).Used(3000) {
+		t.Error("Expected reserved port to be used")
+	}
+}
+
+func TestReservationHandlers(t *testing.T) {
+	store, _ := NewReservationStore(nil)
+	server := &Server{client: &MockDockerClient{Containers: []types.Container{
+		{State: "running", Ports: []types.Port{{PublicPort: 3000}}},
+	}}, reservations: store}
+	mux := SetupRouter(server)
+
+	req := httptest.NewRequest("POST", "/api/reservations", strings.NewReader(`{"name":"grafana","start":3000}`))
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	var res Reservation
+	json.NewDecoder(w.Body).Decode(&res)
+	if w.Code != http.StatusCreated || res.Port != 3001 {
+		t.Fatalf("Expected 201 with port 3001, got %d %+v", w.Code, res)
+	}
+
+	req = httptest.NewRequest("POST", "/api/reservations", strings.NewReader(`{"name":"grafana"}`))
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusConflict {
+		t.Errorf("Expected 409 for duplicate name, got %d", w.Code)
+	}
+
+	req = httptest.NewRequest("POST", "/api/reservations", strings.NewReader(`{"name":"loki","port":3000}`))
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusConflict {
+		t.Errorf("Expected 409 for a port used by a container, got %d", w.Code)
+	}
+
+	for _, body := range []string{`{"name":"loki","port":70000}`, `{"name":"loki","port":-1}`} {
+		w = httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("POST", "/api/reservations", strings.NewReader(body)))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %s, got %d", body, w.Code)
+		}
+	}
+
+	req = httptest.NewRequest("GET", "/api/reservations", nil)
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	var list []Reservation
+	json.NewDecoder(w.Body).Decode(&list)
+	if len(list) != 1 {
+		t.Errorf("Expected 1 reservation, got %d", len(list))
+	}
+
+	req = httptest.NewRequest("DELETE", "/api/reservations/grafana", nil)
+	req.Header.Set("If-Match", "*")
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusNoContent {
+		t.Errorf("Expected 204, got %d", w.Code)
+	}
+
+	req = httptest.NewRequest("DELETE", "/api/reservations/grafana", nil)
+	req.Header.Set("If-Match", "*")
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404, got %d", w.Code)
+	}
+}
+
+func TestReservationStoreRetriesStaleWrites(t *testing.T) {
+	db := openTestDB(t)
+	a, _ := NewReservationStore(db)
+	b, _ := NewReservationStore(db)
+
+	a.Ensure(Reservation{Name: "grafana", Port: 8000}, nil, 0, false)
+	res, _, err := b.Ensure(Reservation{Name: "prom"}, nil, 8000, false)
+	if err != nil || res.Port != 8001 {
+		t.Fatalf("Expected b to notice grafana and pick 8001, got %+v, %v", res, err)
+	}
+	if _, err := b.Delete("", "grafana"); err != nil {
+		t.Fatal(err)
+	}
+	a.Reload()
+	if _, ok := a.Get("", "grafana"); ok || len(a.List()) != 1 {
+		t.Errorf("Expected only prom left, got %+v", a.List())
+	}
+}
+
+func TestReservationIfMatch(t *testing.T) {
+	store, _ := NewReservationStore(nil)
+	mux := SetupRouter(&Server{client: &MockDockerClient{Containers: []types.Container{
+		{State: "running", Ports: []types.Port{{PublicPort: 3005}}},
+	}}, reservations: store})
+	do := func(method, body, ifMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/reservations/grafana", strings.NewReader(body))
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("POST", "/api/reservations", strings.NewReader(`{"name":"grafana","port":3000}`)))
+	first := w.Header().Get("ETag")
+	if first != `"1"` {
+		t.Fatalf("Expected ETag \"1\" on create, got %q", first)
+	}
+	if w := do("GET", "", ""); w.Code != http.StatusOK || w.Header().Get("ETag") != first {
+		t.Errorf("Expected GET to return the ETag, got %d %q", w.Code, w.Header().Get("ETag"))
+	}
+
+	if w := do("PUT", `{"note":"dashboards"}`, ""); w.Code != http.StatusPreconditionRequired {
+		t.Errorf("Expected 428 without If-Match, got %d", w.Code)
+	}
+	w = do("PUT", `{"note":"dashboards"}`, first)
+	var res Reservation
+	json.NewDecoder(w.Body).Decode(&res)
+	if w.Code != http.StatusOK || res.Port != 3000 || res.Note != "dashboards" || res.Revision != 2 || w.Header().Get("ETag") != `"2"` {
+		t.Fatalf("Expected the update to apply, got %d %+v", w.Code, res)
+	}
+
+	// Another pipeline still holding revision 1 must not clobber it
+	if w := do("PUT", `{"note":"mine"}`, first); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("Expected 412 for a stale update, got %d", w.Code)
+	}
+	if w := do("DELETE", "", first); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("Expected 412 for a stale delete, got %d", w.Code)
+	}
+	if w := do("PUT", `{"port":70000}`, `"2"`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 moving outside 1-65535, got %d", w.Code)
+	}
+	if w := do("PUT", `{"port":3005}`, `"2"`); w.Code != http.StatusConflict {
+		t.Errorf("Expected 409 moving onto a used port, got %d", w.Code)
+	}
+	if w := do("DELETE", "", `"2"`); w.Code != http.StatusNoContent {
+		t.Errorf("Expected the current revision to delete, got %d", w.Code)
+	}
+	if w := do("PUT", `{}`, "*"); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 once deleted, got %d", w.Code)
+	}
+}
+
+func TestReservationTrash(t *testing.T) {
+	db := openTestDB(t)
+	store, _ := NewReservationStore(db)
+	server := &Server{client: &MockDockerClient{}, reservations: store}
+	mux := SetupRouter(server)
+	do := func(method, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("If-Match", "*")
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+
+	store.Ensure(Reservation{Name: "grafana", Port: 3000, Owner: "ops"}, nil, 0, false)
+	if w := do("DELETE", "/api/reservations/grafana"); w.Code != http.StatusNoContent {
+		t.Fatalf("Expected 204, got %d", w.Code)
+	}
+	if _, ok := store.Get("", "grafana"); ok {
+		t.Fatal("Expected grafana to be gone from the live reservations")
+	}
+	var trash []Reservation
+	json.NewDecoder(do("GET", "/api/reservations/trash").Body).Decode(&trash)
+	if len(trash) != 1 || trash[0].Name != "grafana" || trash[0].DeletedAt == nil {
+		t.Fatalf("Expected grafana in the trash, got %+v", trash)
+	}
+
+	// The port is free again meanwhile; a new grafana can't be clobbered
+	store.Ensure(Reservation{Name: "grafana", Port: 3001}, nil, 0, false)
+	if w := do("POST", "/api/reservations/trash/grafana/restore"); w.Code != http.StatusConflict {
+		t.Errorf("Expected 409 while the name is taken, got %d", w.Code)
+	}
+	store.Delete("", "grafana")
+	store.Ensure(Reservation{Name: "other", Port: 3001}, nil, 0, false)
+
+	// Survives a reload: the live and deleted rows share the name
+	reloaded, err := NewReservationStore(db)
+	if err != nil || len(reloaded.Trash()) != 1 || reloaded.Trash()[0].Port != 3001 {
+		t.Fatalf("Expected the latest deletion to persist, got %+v, %v", reloaded.Trash(), err)
+	}
+	if w := do("POST", "/api/reservations/trash/grafana/restore"); w.Code != http.StatusConflict {
+		t.Errorf("Expected 409 while the port is taken, got %d", w.Code)
+	}
+	store.Delete("", "other")
+	w := do("POST", "/api/reservations/trash/grafana/restore")
+	var res Reservation
+	json.NewDecoder(w.Body).Decode(&res)
+	if w.Code != http.StatusOK || res.Port != 3001 || res.DeletedAt != nil {
+		t.Fatalf("Expected grafana back on 3001, got %d %+v", w.Code, res)
+	}
+
+	if w := do("DELETE", "/api/reservations/trash/other"); w.Code != http.StatusNoContent {
+		t.Errorf("Expected purge to succeed, got %d", w.Code)
+	}
+	if w := do("POST", "/api/reservations/trash/other/restore"); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 after purge, got %d", w.Code)
+	}
+
+	store.TrashFor = 0
+	store.Delete("", "grafana")
+	if len(store.Trash()) != 0 {
+		t.Errorf("Expected no trash when disabled, got %+v", store.Trash())
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RetentionPolicy bounds an append-only table by age and by row count;
+// zero disables a limit
+type RetentionPolicy struct {
+	MaxAge  time.Duration
+	MaxRows int
+}
+
+// prunedTables are the tables retention applies to, in metric order
+var prunedTables = []string{"history", "audit", "traffic"}
+
+// defaultRetention keeps 90 days or 100k events of history, the part that
+// grows with container churn, a year of audit entries and 90 days of
+// traffic samples
+var defaultRetention = map[string]RetentionPolicy{
+	"history": {MaxAge: 90 * 24 * time.Hour, MaxRows: 100000},
+	"audit":   {MaxAge: 365 * 24 * time.Hour},
+	"traffic": {MaxAge: 90 * 24 * time.Hour},
+}
+
+// parseRetentionAge accepts Go durations plus a "d" suffix for days, since
+// nobody thinks of retention in hours
+func parseRetentionAge(v string) (time.Duration, error) {
+	if n, ok := strings.CutSuffix(v, "d"); ok {
+		days, err := strconv.Atoi(n)
+		if err != nil || days < 0 {
+			return 0, fmt.Errorf("invalid retention %q", v)
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid retention %q", v)
+	}
+	return d, nil
+}
+
+// retentionFromEnv reads QUAYCHECK_<TABLE>_RETENTION (an age, "0" keeps
+// forever) and QUAYCHECK_<TABLE>_MAX_ROWS over the defaults
+func retentionFromEnv() map[string]RetentionPolicy {
+	policies := make(map[string]RetentionPolicy, len(prunedTables))
+	for _, table := range prunedTables {
+		p := defaultRetention[table]
+		prefix := "QUAYCHECK_" + strings.ToUpper(table)
+		if v := os.Getenv(prefix + "_RETENTION"); v != "" {
+			if d, err := parseRetentionAge(v); err == nil {
+				p.MaxAge = d
+			} else {
+				log.Printf("Ignoring %s_RETENTION: %v", prefix, err)
+			}
+		}
+		if v := os.Getenv(prefix + "_MAX_ROWS"); v != "" {
+			if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+				p.MaxRows = n
+			} else {
+				log.Printf("Ignoring %s_MAX_ROWS=%q", prefix, v)
+			}
+		}
+		policies[table] = p
+	}
+	return policies
+}
+
+// pruneStats counts rows removed by retention, for /metrics
+type pruneStats struct {
+	mu     sync.Mutex
+	pruned map[string]int64
+	last   time.Time
+}
+
+func newPruneStats() *pruneStats {
+	return &pruneStats{pruned: make(map[string]int64)}
+}
+
+// prune applies every policy once
+func (s *Server) prune(ctx context.Context) {
+	now := time.Now()
+	for _, table := range prunedTables {
+		p := s.retention[table]
+		var before time.Time
+		if p.MaxAge > 0 {
+			before = now.Add(-p.MaxAge)
+		}
+		n, err := s.db.Prune(ctx, table, before, p.MaxRows)
+		if err != nil {
+			log.Printf("Pruning %s failed: %v", table, err)
+			continue
+		}
+		if n > 0 {
+			log.Printf("Pruned %d %s rows", n, table)
+		}
+		s.pruned.mu.Lock()
+		s.pruned.pruned[table] += n
+		s.pruned.mu.Unlock()
+	}
+	s.pruned.mu.Lock()
+	s.pruned.last = now
+	s.pruned.mu.Unlock()
+}
+
+// pruneLoop prunes on startup and then every interval. Freed pages are
+// reused by later inserts, so the file stops growing rather than shrinking.
+func (s *Server) pruneLoop(ctx context.Context, interval time.Duration) {
+	if s.db == nil || s.pruned == nil {
+		return
+	}
+	for {
+		s.prune(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// writeStoreMetrics adds state database size, row counts and pruning
+// totals to /metrics
+func (s *Server) writeStoreMetrics(ctx context.Context, w io.Writer) {
+	if s.db == nil {
+		return
+	}
+	if size, err := s.db.Size(ctx); err == nil {
+		fmt.Fprintf(w, "# HELP quaycheck_store_size_bytes Size of the state database.\n# TYPE quaycheck_store_size_bytes gauge\nquaycheck_store_size_bytes %d\n", size)
+	}
+	fmt.Fprint(w, "# HELP quaycheck_store_rows Rows in the state database tables.\n# TYPE quaycheck_store_rows gauge\n")
+	for _, table := range append([]string{"reservations", "freezes", "tags", "ingested", "settings", "tokens", "event_log"}, prunedTables...) {
+		if n, err := s.db.Count(ctx, table); err == nil {
+			fmt.Fprintf(w, "quaycheck_store_rows{table=%q} %d\n", table, n)
+		}
+	}
+	if s.pruned == nil {
+		return
+	}
+	s.pruned.mu.Lock()
+	defer s.pruned.mu.Unlock()
+	fmt.Fprint(w, "# HELP quaycheck_store_pruned_rows_total Rows removed by retention.\n# TYPE quaycheck_store_pruned_rows_total counter\n")
+	for _, table := range prunedTables {
+		fmt.Fprintf(w, "quaycheck_store_pruned_rows_total{table=%q} %d\n", table, s.pruned.pruned[table])
+	}
+	if !s.pruned.last.IsZero() {
+		fmt.Fprintf(w, "# HELP quaycheck_store_last_prune_timestamp_seconds Unix time of the last retention run.\n# TYPE quaycheck_store_last_prune_timestamp_seconds gauge\nquaycheck_store_last_prune_timestamp_seconds %d\n", s.pruned.last.Unix())
+	}
+}
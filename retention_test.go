@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRetentionFromEnv(t *testing.T) {
+	t.Setenv("QUAYCHECK_HISTORY_RETENTION", "30d")
+	t.Setenv("QUAYCHECK_HISTORY_MAX_ROWS", "500")
+	t.Setenv("QUAYCHECK_AUDIT_RETENTION", "0")
+
+	p := retentionFromEnv()
+	if p["history"] != (RetentionPolicy{MaxAge: 30 * 24 * time.Hour, MaxRows: 500}) {
+		t.Errorf("Unexpected history policy: %+v", p["history"])
+	}
+	if p["audit"].MaxAge != 0 {
+		t.Errorf("Expected 0 to keep audit entries forever, got %+v", p["audit"])
+	}
+
+	t.Setenv("QUAYCHECK_HISTORY_RETENTION", "soon")
+	if p := retentionFromEnv(); p["history"].MaxAge != defaultRetention["history"].MaxAge {
+		t.Errorf("Expected an invalid value to keep the default, got %+v", p["history"])
+	}
+}
+
+func TestPruneAndStoreMetrics(t *testing.T) {
+	db := openTestDB(t)
+	server := &Server{
+		client:    &MockDockerClient{},
+		db:        db,
+		history:   NewHistoryStore(db),
+		retention: map[string]RetentionPolicy{"history": {MaxAge: time.Hour, MaxRows: 2}},
+		pruned:    newPruneStats(),
+	}
+	now := time.Now().UTC()
+	server.recordHistory(context.Background(), []PortEvent{
+		{Type: EventPortOccupied, Port: 1, Time: now.Add(-2 * time.Hour)},
+		{Type: EventPortOccupied, Port: 2, Time: now},
+		{Type: EventPortOccupied, Port: 3, Time: now},
+		{Type: EventPortOccupied, Port: 4, Time: now},
+	})
+
+	server.prune(context.Background())
+	events, _ := server.history.List(context.Background(), 0, 10)
+	if len(events) != 2 || events[0].Port != 4 || events[1].Port != 3 {
+		t.Errorf("Expected the two newest events to survive, got %+v", events)
+	}
+
+	rr := httptest.NewRecorder()
+	server.handleMetrics(rr, httptest.NewRequest("GET", "/metrics", nil))
+	body := rr.Body.String()
+	for _, want := range []string{
+		"quaycheck_store_size_bytes ",
+		`quaycheck_store_rows{table="history"} 2`,
+		`quaycheck_store_pruned_rows_total{table="history"} 2`,
+		"quaycheck_store_last_prune_timestamp_seconds ",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected metrics to contain %q:\n%s", want, body)
+		}
+	}
+}
@@ -0,0 +1,622 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// routerForwardPrefix starts the description of forwards quaycheck made,
+// so it only ever removes its own
+const routerForwardPrefix = "quaycheck: "
+
+// RouterForward is a port forward on the LAN router
+type RouterForward struct {
+	ExternalPort int    `json:"external_port"`
+	Protocol     string `json:"protocol"`
+	InternalHost string `json:"internal_host"`
+	InternalPort int    `json:"internal_port"`
+	Description  string `json:"description,omitempty"`
+	Enabled      bool   `json:"enabled"`
+	// LeaseSeconds is how long the forward has left; 0 is permanent
+	LeaseSeconds int `json:"lease_seconds,omitempty"`
+}
+
+// routerClient talks to the router over UPnP IGD or NAT-PMP
+type routerClient interface {
+	Forwards(ctx context.Context) ([]RouterForward, error)
+	Add(ctx context.Context, f RouterForward, lease time.Duration) error
+	Delete(ctx context.Context, f RouterForward) error
+}
+
+// RouterSource lists the router's port forwards: they are its ports, as
+// a host of their own called "router". With Forward set, it also keeps a
+// forward to this host for every reservation marked public.
+type RouterSource struct {
+	Client   routerClient
+	Interval time.Duration
+	// Forward turns on forwards for public reservations, to Address
+	Forward bool
+	Address string
+
+	mu       sync.RWMutex
+	forwards []RouterForward
+	err      error
+}
+
+// routerHost is the host name router forwards are listed under
+const routerHost = "router"
+
+// routerFromEnv reads QUAYCHECK_ROUTER: "upnp" to discover the gateway,
+// the URL of its UPnP description, or "natpmp" optionally with the
+// gateway as natpmp://192.168.1.1. QUAYCHECK_ROUTER_FORWARD=true forwards
+// public reservations to this host.
+func routerFromEnv() *RouterSource {
+	raw := os.Getenv("QUAYCHECK_ROUTER")
+	if raw == "" {
+		return nil
+	}
+	src := &RouterSource{Interval: time.Minute, Forward: os.Getenv("QUAYCHECK_ROUTER_FORWARD") == "true"}
+	switch {
+	case raw == "upnp":
+		src.Client = &upnpClient{HTTPClient: &http.Client{Timeout: 5 * time.Second}}
+	case strings.HasPrefix(raw, "http://") || strings.HasPrefix(raw, "https://"):
+		src.Client = &upnpClient{Description: raw, HTTPClient: &http.Client{Timeout: 5 * time.Second}}
+	case raw == "natpmp" || strings.HasPrefix(raw, "natpmp://"):
+		gateway := strings.TrimPrefix(raw, "natpmp://")
+		if gateway == "natpmp" {
+			gw, err := defaultGateway()
+			if err != nil {
+				log.Fatalf("QUAYCHECK_ROUTER=natpmp: %v; give the gateway as natpmp://<address>", err)
+			}
+			gateway = gw
+		}
+		if _, _, err := net.SplitHostPort(gateway); err != nil {
+			gateway = net.JoinHostPort(gateway, "5351")
+		}
+		src.Client = &natpmpClient{Gateway: gateway}
+	default:
+		log.Fatalf("Invalid QUAYCHECK_ROUTER %q: expected upnp, a UPnP description URL or natpmp", raw)
+	}
+	if src.Forward {
+		if src.Address = lanAddress(); src.Address == "" {
+			log.Fatalf("QUAYCHECK_ROUTER_FORWARD needs QUAYCHECK_DNS_ADDRESS, the address to forward to")
+		}
+	}
+	return src
+}
+
+// lanAddress is QUAYCHECK_DNS_ADDRESS, else the IPv4 address of the
+// interface holding the default route
+func lanAddress() string {
+	if addr := net.ParseIP(os.Getenv("QUAYCHECK_DNS_ADDRESS")); addr != nil {
+		return addr.String()
+	}
+	// Connecting a UDP socket picks the outgoing interface without
+	// sending anything
+	conn, err := net.Dial("udp4", "192.0.2.1:9")
+	if err != nil {
+		return ""
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP.String()
+}
+
+// defaultGateway reads the IPv4 default route from /proc/net/route
+func defaultGateway() (string, error) {
+	data, err := os.ReadFile("/proc/net/route")
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(data), "\n")[1:] {
+		fields := strings.Fields(line)
+		if len(fields) < 3 || fields[1] != "00000000" {
+			continue
+		}
+		gw, err := strconv.ParseUint(fields[2], 16, 32)
+		if err != nil {
+			continue
+		}
+		ip := make(net.IP, 4)
+		binary.LittleEndian.PutUint32(ip, uint32(gw))
+		return ip.String(), nil
+	}
+	return "", errors.New("no default route")
+}
+
+func (s *RouterSource) Name() string { return "router" }
+
+func (s *RouterSource) Containers(ctx context.Context) ([]ContainerData, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.err != nil {
+		return nil, s.err
+	}
+	entry := ContainerData{ID: "remote:" + routerHost, Names: []string{routerHost}, State: "running", Source: s.Name()}
+	for _, f := range s.forwards {
+		if f.Enabled {
+			entry.Ports = append(entry.Ports, PortMapping{PrivatePort: uint16(f.InternalPort), PublicPort: uint16(f.ExternalPort), Type: f.Protocol})
+		}
+	}
+	return []ContainerData{entry}, nil
+}
+
+// Forwards are the router's forwards as of the last scan
+func (s *RouterSource) Forwards() ([]RouterForward, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return slices.Clone(s.forwards), s.err
+}
+
+// Run scans the router immediately, then every Interval until ctx is done
+func (s *RouterSource) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+	for {
+		s.scan(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *RouterSource) scan(ctx context.Context) {
+	forwards, err := s.Client.Forwards(ctx)
+	if err != nil {
+		err = fmt.Errorf("router: %w", err)
+	}
+	slices.SortFunc(forwards, func(a, b RouterForward) int { return a.ExternalPort - b.ExternalPort })
+	s.mu.Lock()
+	s.forwards, s.err = forwards, err
+	s.mu.Unlock()
+}
+
+// syncForwards adds a forward for each public reservation and removes
+// quaycheck's forwards of those that are gone. A port the router already
+// forwards elsewhere is left alone.
+func (s *RouterSource) syncForwards(ctx context.Context, reservations []Reservation, lease time.Duration) error {
+	current, err := s.Client.Forwards(ctx)
+	if err != nil {
+		return err
+	}
+	var wanted []RouterForward
+	for _, r := range reservations {
+		if !r.Public {
+			continue
+		}
+		proto := r.Protocol
+		if proto == "" {
+			proto = "tcp"
+		}
+		wanted = append(wanted, RouterForward{ExternalPort: r.Port, Protocol: proto, InternalHost: s.Address, InternalPort: r.Port, Description: routerForwardPrefix + r.Name, Enabled: true})
+	}
+
+	var errs []error
+	for _, f := range wanted {
+		i := slices.IndexFunc(current, func(c RouterForward) bool { return c.ExternalPort == f.ExternalPort && c.Protocol == f.Protocol })
+		if i >= 0 && !strings.HasPrefix(current[i].Description, routerForwardPrefix) && current[i].InternalHost != f.InternalHost {
+			errs = append(errs, fmt.Errorf("%d/%s is already forwarded to %s:%d", f.ExternalPort, f.Protocol, current[i].InternalHost, current[i].InternalPort))
+			continue
+		}
+		// Adding again renews the lease
+		if err := s.Client.Add(ctx, f, lease); err != nil {
+			errs = append(errs, fmt.Errorf("forward %d/%s: %w", f.ExternalPort, f.Protocol, err))
+		}
+	}
+	for _, c := range current {
+		if !strings.HasPrefix(c.Description, routerForwardPrefix) {
+			continue
+		}
+		if !slices.ContainsFunc(wanted, func(f RouterForward) bool { return f.ExternalPort == c.ExternalPort && f.Protocol == c.Protocol }) {
+			if err := s.Client.Delete(ctx, c); err != nil {
+				errs = append(errs, fmt.Errorf("remove forward %d/%s: %w", c.ExternalPort, c.Protocol, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// routerForwardLoop keeps public reservations forwarded, renewing the
+// leases every interval; they run for an hour so forwards don't outlive
+// quaycheck by long
+func (s *Server) routerForwardLoop(ctx context.Context, interval time.Duration) {
+	src := s.routerSource()
+	if src == nil || !src.Forward || s.reservations == nil {
+		return
+	}
+	run := func() {
+		if err := src.syncForwards(ctx, s.reservations.List(), time.Hour); err != nil {
+			log.Printf("Router forwards: %v", err)
+		}
+		src.scan(ctx)
+	}
+	run()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			run()
+		}
+	}
+}
+
+func (s *Server) routerSource() *RouterSource {
+	for _, src := range s.sources {
+		if r, ok := src.(*RouterSource); ok {
+			return r
+		}
+	}
+	return nil
+}
+
+// handleRouterForwards lists the router's forwards as of the last scan
+func (s *Server) handleRouterForwards(w http.ResponseWriter, r *http.Request) {
+	src := s.routerSource()
+	if src == nil {
+		writeError(w, http.StatusNotImplemented, "router_unset", "No router is set up on this server")
+		return
+	}
+	forwards, err := src.Forwards()
+	if err != nil {
+		writeError(w, http.StatusBadGateway, "router_error", localize(w, "Cannot read the router's forwards: %v", err))
+		return
+	}
+	if forwards == nil {
+		forwards = []RouterForward{}
+	}
+	writeEncoded(w, r, forwards)
+}
+
+// upnpClient manages forwards through a UPnP Internet Gateway Device's
+// WANIPConnection or WANPPPConnection service. Without Description, the
+// gateway is found over SSDP.
+type upnpClient struct {
+	Description string
+	HTTPClient  *http.Client
+
+	mu         sync.Mutex
+	controlURL string
+	service    string
+}
+
+type upnpDevice struct {
+	Services []struct {
+		ServiceType string `xml:"serviceType"`
+		ControlURL  string `xml:"controlURL"`
+	} `xml:"serviceList>service"`
+	Devices []upnpDevice `xml:"deviceList>device"`
+}
+
+// ssdpSearch is swapped out in tests
+var ssdpSearch = func(ctx context.Context) (string, error) {
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	group := &net.UDPAddr{IP: net.IPv4(239, 255, 255, 250), Port: 1900}
+	msg := "M-SEARCH * HTTP/1.1\r\nHOST: 239.255.255.250:1900\r\nST: urn:schemas-upnp-org:device:InternetGatewayDevice:1\r\nMAN: \"ssdp:discover\"\r\nMX: 2\r\n\r\n"
+	if _, err := conn.WriteToUDP([]byte(msg), group); err != nil {
+		return "", err
+	}
+	deadline := time.Now().Add(3 * time.Second)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetReadDeadline(deadline)
+	buf := make([]byte, 2048)
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return "", errors.New("no UPnP gateway answered; set QUAYCHECK_ROUTER to its description URL")
+		}
+		resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(buf[:n])), nil)
+		if err != nil {
+			continue
+		}
+		if loc := resp.Header.Get("Location"); loc != "" {
+			return loc, nil
+		}
+	}
+}
+
+// connect finds the control URL of the gateway's connection service, once
+func (c *upnpClient) connect(ctx context.Context) (string, string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.controlURL != "" {
+		return c.controlURL, c.service, nil
+	}
+	desc := c.Description
+	if desc == "" {
+		loc, err := ssdpSearch(ctx)
+		if err != nil {
+			return "", "", err
+		}
+		desc = loc
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", desc, nil)
+	if err != nil {
+		return "", "", err
+	}
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+	var root struct {
+		URLBase string     `xml:"URLBase"`
+		Device  upnpDevice `xml:"device"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&root); err != nil {
+		return "", "", fmt.Errorf("UPnP description: %w", err)
+	}
+	base, err := url.Parse(desc)
+	if root.URLBase != "" {
+		base, err = url.Parse(root.URLBase)
+	}
+	if err != nil {
+		return "", "", err
+	}
+	var find func(d upnpDevice) bool
+	find = func(d upnpDevice) bool {
+		for _, svc := range d.Services {
+			if strings.Contains(svc.ServiceType, ":WANIPConnection:") || strings.Contains(svc.ServiceType, ":WANPPPConnection:") {
+				ref, err := url.Parse(svc.ControlURL)
+				if err != nil {
+					continue
+				}
+				c.controlURL, c.service = base.ResolveReference(ref).String(), svc.ServiceType
+				return true
+			}
+		}
+		return slices.ContainsFunc(d.Devices, find)
+	}
+	if !find(root.Device) {
+		return "", "", errors.New("the gateway has no WANIPConnection service")
+	}
+	return c.controlURL, c.service, nil
+}
+
+// upnpError is a SOAP fault carrying a UPnP error code
+type upnpError struct {
+	Code        int
+	Description string
+}
+
+func (e *upnpError) Error() string { return fmt.Sprintf("UPnP error %d: %s", e.Code, e.Description) }
+
+// call invokes a SOAP action and returns the response's leaf elements
+func (c *upnpClient) call(ctx context.Context, action string, args [][2]string) (map[string]string, error) {
+	control, service, err := c.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var body strings.Builder
+	fmt.Fprintf(&body, `<?xml version="1.0"?><s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/"><s:Body><u:%s xmlns:u="%s">`, action, service)
+	for _, a := range args {
+		fmt.Fprintf(&body, "<%s>%s</%s>", a[0], html.EscapeString(a[1]), a[0])
+	}
+	fmt.Fprintf(&body, "</u:%s></s:Body></s:Envelope>", action)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", control, strings.NewReader(body.String()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	req.Header.Set("SOAPAction", `"`+service+"#"+action+`"`)
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	fields, err := xmlLeaves(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", action, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		code, _ := strconv.Atoi(fields["errorCode"])
+		if code == 0 {
+			return nil, fmt.Errorf("%s: %s", action, resp.Status)
+		}
+		return nil, &upnpError{Code: code, Description: fields["errorDescription"]}
+	}
+	return fields, nil
+}
+
+// xmlLeaves maps the local name of every element holding only text to
+// that text
+func xmlLeaves(r io.Reader) (map[string]string, error) {
+	fields := make(map[string]string)
+	dec := xml.NewDecoder(r)
+	var name, text string
+	for {
+		tok, err := dec.Token()
+		if errors.Is(err, io.EOF) {
+			return fields, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			name, text = t.Name.Local, ""
+		case xml.CharData:
+			text += string(t)
+		case xml.EndElement:
+			if t.Name.Local == name {
+				fields[name] = strings.TrimSpace(text)
+			}
+			name = ""
+		}
+	}
+}
+
+func (c *upnpClient) Forwards(ctx context.Context) ([]RouterForward, error) {
+	var result []RouterForward
+	for i := 0; ; i++ {
+		f, err := c.call(ctx, "GetGenericPortMappingEntry", [][2]string{{"NewPortMappingIndex", strconv.Itoa(i)}})
+		var ue *upnpError
+		if errors.As(err, &ue) && (ue.Code == 713 || ue.Code == 714) {
+			// SpecifiedArrayIndexInvalid or NoSuchEntryInArray: the end
+			return result, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		ext, _ := strconv.Atoi(f["NewExternalPort"])
+		internal, _ := strconv.Atoi(f["NewInternalPort"])
+		lease, _ := strconv.Atoi(f["NewLeaseDuration"])
+		result = append(result, RouterForward{
+			ExternalPort: ext,
+			Protocol:     strings.ToLower(f["NewProtocol"]),
+			InternalHost: f["NewInternalClient"],
+			InternalPort: internal,
+			Description:  f["NewPortMappingDescription"],
+			Enabled:      f["NewEnabled"] == "1" || strings.EqualFold(f["NewEnabled"], "true"),
+			LeaseSeconds: lease,
+		})
+	}
+}
+
+func (c *upnpClient) Add(ctx context.Context, f RouterForward, lease time.Duration) error {
+	_, err := c.call(ctx, "AddPortMapping", [][2]string{
+		{"NewRemoteHost", ""},
+		{"NewExternalPort", strconv.Itoa(f.ExternalPort)},
+		{"NewProtocol", strings.ToUpper(f.Protocol)},
+		{"NewInternalPort", strconv.Itoa(f.InternalPort)},
+		{"NewInternalClient", f.InternalHost},
+		{"NewEnabled", "1"},
+		{"NewPortMappingDescription", f.Description},
+		{"NewLeaseDuration", strconv.Itoa(int(lease.Seconds()))},
+	})
+	return err
+}
+
+func (c *upnpClient) Delete(ctx context.Context, f RouterForward) error {
+	_, err := c.call(ctx, "DeletePortMapping", [][2]string{
+		{"NewRemoteHost", ""},
+		{"NewExternalPort", strconv.Itoa(f.ExternalPort)},
+		{"NewProtocol", strings.ToUpper(f.Protocol)},
+	})
+	return err
+}
+
+// natpmpClient manages forwards through NAT-PMP (RFC 6886). The protocol
+// can't list forwards, so Forwards returns the ones this client made, and
+// they always point at the host asking.
+type natpmpClient struct {
+	Gateway string
+
+	mu       sync.Mutex
+	forwards map[string]RouterForward
+}
+
+// request sends a mapping request and returns the mapped external port
+// and lifetime, retrying as the RFC suggests
+func (c *natpmpClient) request(ctx context.Context, proto string, internal, external int, lease time.Duration) (int, int, error) {
+	op := byte(2)
+	if proto == "udp" {
+		op = 1
+	}
+	msg := make([]byte, 12)
+	msg[1] = op
+	binary.BigEndian.PutUint16(msg[4:], uint16(internal))
+	binary.BigEndian.PutUint16(msg[6:], uint16(external))
+	binary.BigEndian.PutUint32(msg[8:], uint32(lease.Seconds()))
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp4", c.Gateway)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer conn.Close()
+	buf := make([]byte, 16)
+	wait := 250 * time.Millisecond
+	for try := 0; try < 4; try++ {
+		if _, err := conn.Write(msg); err != nil {
+			return 0, 0, err
+		}
+		conn.SetReadDeadline(time.Now().Add(wait))
+		n, err := conn.Read(buf)
+		wait *= 2
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() && ctx.Err() == nil {
+				continue
+			}
+			return 0, 0, err
+		}
+		if n < 16 || buf[1] != 128+op {
+			continue
+		}
+		if code := binary.BigEndian.Uint16(buf[2:]); code != 0 {
+			return 0, 0, fmt.Errorf("NAT-PMP result code %d", code)
+		}
+		return int(binary.BigEndian.Uint16(buf[10:])), int(binary.BigEndian.Uint32(buf[12:])), nil
+	}
+	return 0, 0, errors.New("the gateway didn't answer NAT-PMP")
+}
+
+func (c *natpmpClient) Forwards(ctx context.Context) ([]RouterForward, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var result []RouterForward
+	for _, f := range c.forwards {
+		result = append(result, f)
+	}
+	return result, nil
+}
+
+func (c *natpmpClient) Add(ctx context.Context, f RouterForward, lease time.Duration) error {
+	external, lifetime, err := c.request(ctx, f.Protocol, f.InternalPort, f.ExternalPort, lease)
+	if err != nil {
+		return err
+	}
+	if external != f.ExternalPort {
+		// The gateway picked another port; give it back rather than
+		// advertise the wrong one
+		c.request(ctx, f.Protocol, f.InternalPort, 0, 0)
+		return fmt.Errorf("the gateway offered port %d instead", external)
+	}
+	f.LeaseSeconds = lifetime
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.forwards == nil {
+		c.forwards = make(map[string]RouterForward)
+	}
+	c.forwards[fmt.Sprintf("%d/%s", f.ExternalPort, f.Protocol)] = f
+	return nil
+}
+
+func (c *natpmpClient) Delete(ctx context.Context, f RouterForward) error {
+	// A lifetime of 0 removes the mapping of the internal port
+	if _, _, err := c.request(ctx, f.Protocol, f.InternalPort, 0, 0); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.forwards, fmt.Sprintf("%d/%s", f.ExternalPort, f.Protocol))
+	return nil
+}
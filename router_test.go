@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeIGD serves a gateway description and its WANIPConnection control
+// endpoint, keeping forwards in memory
+type fakeIGD struct {
+	mu       sync.Mutex
+	forwards []RouterForward
+}
+
+func (g *fakeIGD) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/rootDesc.xml" {
+		fmt.Fprint(w, `<?xml version="1.0"?><root xmlns="urn:schemas-upnp-org:device-1-0"><device><deviceList><device><deviceList><device>
+<serviceList><service><serviceType>urn:schemas-upnp-org:service:WANIPConnection:1</serviceType><controlURL>/ctl/IPConn</controlURL></service></serviceList>
+</device></deviceList></device></deviceList></device></root>`)
+		return
+	}
+	body, _ := io.ReadAll(r.Body)
+	fields, _ := xmlLeaves(strings.NewReader(string(body)))
+	action := strings.TrimSuffix(strings.SplitN(r.Header.Get("SOAPAction"), "#", 2)[1], `"`)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	fault := func(code int) {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, `<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body><s:Fault><detail><UPnPError xmlns="urn:schemas-upnp-org:control-1-0"><errorCode>%d</errorCode><errorDescription>Invalid</errorDescription></UPnPError></detail></s:Fault></s:Body></s:Envelope>`, code)
+	}
+	switch action {
+	case "GetGenericPortMappingEntry":
+		i, _ := strconv.Atoi(fields["NewPortMappingIndex"])
+		if i >= len(g.forwards) {
+			fault(713)
+			return
+		}
+		f := g.forwards[i]
+		fmt.Fprintf(w, `<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body><u:GetGenericPortMappingEntryResponse xmlns:u="urn:schemas-upnp-org:service:WANIPConnection:1">
+<NewRemoteHost></NewRemoteHost><NewExternalPort>%d</NewExternalPort><NewProtocol>%s</NewProtocol><NewInternalPort>%d</NewInternalPort><NewInternalClient>%s</NewInternalClient>
+<NewEnabled>1</NewEnabled><NewPortMappingDescription>%s</NewPortMappingDescription><NewLeaseDuration>0</NewLeaseDuration></u:GetGenericPortMappingEntryResponse></s:Body></s:Envelope>`,
+			f.ExternalPort, strings.ToUpper(f.Protocol), f.InternalPort, f.InternalHost, f.Description)
+	case "AddPortMapping":
+		ext, _ := strconv.Atoi(fields["NewExternalPort"])
+		internal, _ := strconv.Atoi(fields["NewInternalPort"])
+		g.forwards = append(g.forwards, RouterForward{ExternalPort: ext, Protocol: strings.ToLower(fields["NewProtocol"]), InternalHost: fields["NewInternalClient"], InternalPort: internal, Description: fields["NewPortMappingDescription"], Enabled: true})
+		fmt.Fprint(w, `<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body><u:AddPortMappingResponse xmlns:u="urn:schemas-upnp-org:service:WANIPConnection:1"/></s:Body></s:Envelope>`)
+	case "DeletePortMapping":
+		ext, _ := strconv.Atoi(fields["NewExternalPort"])
+		for i, f := range g.forwards {
+			if f.ExternalPort == ext {
+				g.forwards = append(g.forwards[:i], g.forwards[i+1:]...)
+				fmt.Fprint(w, `<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body/></s:Envelope>`)
+				return
+			}
+		}
+		fault(714)
+	}
+}
+
+func TestRouterSourceUPnP(t *testing.T) {
+	igd := &fakeIGD{forwards: []RouterForward{
+		{ExternalPort: 443, Protocol: "tcp", InternalHost: "192.168.1.20", InternalPort: 8443, Description: "nas"},
+		{ExternalPort: 9000, Protocol: "tcp", InternalHost: "192.168.1.10", InternalPort: 9000, Description: routerForwardPrefix + "released"},
+	}}
+	ts := httptest.NewServer(igd)
+	defer ts.Close()
+
+	src := &RouterSource{Client: &upnpClient{Description: ts.URL + "/rootDesc.xml", HTTPClient: ts.Client()}, Forward: true, Address: "192.168.1.10"}
+	src.scan(context.Background())
+	entries, err := src.Containers(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || hostOf(entries[0]) != routerHost || len(entries[0].Ports) != 2 || entries[0].Ports[0].PublicPort != 443 || entries[0].Ports[0].PrivatePort != 8443 {
+		t.Fatalf("Expected the router's two forwards, got %+v", entries)
+	}
+
+	reservations := []Reservation{
+		{Name: "web", Port: 8080, Protocol: "tcp", Public: true},
+		{Name: "https", Port: 443, Protocol: "tcp", Public: true},
+		{Name: "db", Port: 5432, Protocol: "tcp"},
+	}
+	err = src.syncForwards(context.Background(), reservations, time.Hour)
+	if err == nil || !strings.Contains(err.Error(), "443/tcp is already forwarded to 192.168.1.20:8443") {
+		t.Errorf("Expected the nas forward to be left alone, got %v", err)
+	}
+	forwards, _ := src.Client.Forwards(context.Background())
+	var got []string
+	for _, f := range forwards {
+		got = append(got, fmt.Sprintf("%d->%s:%d %s", f.ExternalPort, f.InternalHost, f.InternalPort, f.Description))
+	}
+	if want := "443->192.168.1.20:8443 nas,8080->192.168.1.10:8080 quaycheck: web"; strings.Join(got, ",") != want {
+		t.Errorf("Expected %s, got %s", want, strings.Join(got, ","))
+	}
+}
+
+func TestRouterSourceNATPMP(t *testing.T) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go func() {
+		buf := make([]byte, 12)
+		for {
+			n, from, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			if n != 12 {
+				continue
+			}
+			resp := make([]byte, 16)
+			resp[1] = 128 + buf[1]
+			copy(resp[8:10], buf[4:6])
+			copy(resp[10:12], buf[6:8])
+			if binary.BigEndian.Uint16(buf[6:8]) == 25 {
+				// Port 25 is taken on the gateway: offer another
+				binary.BigEndian.PutUint16(resp[10:12], 2525)
+			}
+			copy(resp[12:16], buf[8:12])
+			conn.WriteToUDP(resp, from)
+		}
+	}()
+
+	src := &RouterSource{Client: &natpmpClient{Gateway: conn.LocalAddr().String()}, Forward: true}
+	err = src.syncForwards(context.Background(), []Reservation{{Name: "web", Port: 8080, Public: true}, {Name: "mail", Port: 25, Public: true}}, time.Hour)
+	if err == nil || !strings.Contains(err.Error(), "offered port 2525") {
+		t.Errorf("Expected port 25 to be refused, got %v", err)
+	}
+	forwards, _ := src.Client.Forwards(context.Background())
+	if len(forwards) != 1 || forwards[0].ExternalPort != 8080 || forwards[0].LeaseSeconds != 3600 {
+		t.Fatalf("Expected 8080 forwarded for an hour, got %+v", forwards)
+	}
+	if err := src.syncForwards(context.Background(), nil, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if forwards, _ := src.Client.Forwards(context.Background()); len(forwards) != 0 {
+		t.Errorf("Expected the forward to be removed, got %+v", forwards)
+	}
+}
+
+func TestRouterForwardsEndpoint(t *testing.T) {
+	w := httptest.NewRecorder()
+	SetupRouter(&Server{client: &MockDockerClient{}}).ServeHTTP(w, httptest.NewRequest("GET", "/api/router/forwards", nil))
+	if w.Code != http.StatusNotImplemented {
+		t.Errorf("Expected 501 without a router, got %d", w.Code)
+	}
+
+	src := &RouterSource{forwards: []RouterForward{{ExternalPort: 443, Protocol: "tcp", InternalHost: "192.168.1.20", InternalPort: 8443, Enabled: true}}}
+	w = httptest.NewRecorder()
+	SetupRouter(&Server{client: &MockDockerClient{}, sources: []PortSource{src}}).ServeHTTP(w, httptest.NewRequest("GET", "/api/router/forwards", nil))
+	var forwards []RouterForward
+	json.NewDecoder(w.Body).Decode(&forwards)
+	if w.Code != http.StatusOK || len(forwards) != 1 || forwards[0].InternalPort != 8443 {
+		t.Errorf("Expected the forward, got %d %+v", w.Code, forwards)
+	}
+}
+
+func TestPublicReservationIsStored(t *testing.T) {
+	db := openTestDB(t)
+	store, _ := NewReservationStore(db)
+	if _, _, err := store.Ensure(Reservation{Name: "web", Port: 8080, Public: true}, nil, 0, false); err != nil {
+		t.Fatal(err)
+	}
+	reloaded, _ := NewReservationStore(db)
+	if res, _ := reloaded.Get("", "web"); !res.Public {
+		t.Errorf("Expected the public flag to be stored, got %+v", res)
+	}
+}
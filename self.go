@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// SelfSource reports quaycheck's own listening port, so it isn't suggested
+// or planned onto, and advertises it over mDNS when that is on. Metrics are
+// served on the same port and there is no other listener to report.
+type SelfSource struct {
+	Port int
+}
+
+func (s *SelfSource) Name() string { return "self" }
+
+func (s *SelfSource) Containers(ctx context.Context) ([]ContainerData, error) {
+	return []ContainerData{{
+		ID:        "self",
+		Names:     []string{"quaycheck"},
+		Image:     "quaycheck",
+		State:     "running",
+		Status:    "serving the API",
+		Ports:     []PortMapping{{PublicPort: uint16(s.Port), PrivatePort: uint16(s.Port), Type: "tcp"}},
+		Advertise: []string{"_http._tcp"},
+	}}, nil
+}
+
+// inContainer reports whether quaycheck runs in a Docker container. Its
+// port is then the container's, and Docker already reports the host port
+// it is published on. Swapped out in tests.
+var inContainer = func() bool {
+	_, err := os.Stat("/.dockerenv")
+	return err == nil
+}
+
+// selfSourceFor returns the source registering port, or nil in a container
+func selfSourceFor(port string) PortSource {
+	p, err := strconv.Atoi(port)
+	if err != nil || p < 1 || p > 65535 || inContainer() {
+		return nil
+	}
+	return &SelfSource{Port: p}
+}
+
+// portConflict is quaycheck's port being held by something else
+type portConflict struct {
+	port  int
+	taken string
+}
+
+func (e *portConflict) Error() string {
+	return fmt.Sprintf("port %d is %s; set PORT to a free port, or QUAYCHECK_PORT_FALLBACK=true", e.port, e.taken)
+}
+
+// listen binds quaycheck's port. When something already holds it, or
+// Docker has a container set to publish it, the error is a portConflict
+// naming that holder instead of a raw bind error.
+func (s *Server) listen(ctx context.Context, port string) (net.Listener, error) {
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		return nil, fmt.Errorf("invalid PORT %q", port)
+	}
+	l, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		if !errors.Is(err, syscall.EADDRINUSE) {
+			return nil, err
+		}
+		if holder := s.portHolder(ctx, p); holder != "" {
+			return nil, &portConflict{p, "already in use by " + holder}
+		}
+		return nil, &portConflict{p, "already in use by a process outside Docker"}
+	}
+	if inContainer() {
+		return l, nil
+	}
+	if holder := s.portHolder(ctx, p); holder != "" {
+		l.Close()
+		return nil, &portConflict{p, "also published by " + holder}
+	}
+	return l, nil
+}
+
+// portFallbackFromEnv reads QUAYCHECK_PORT_FALLBACK=true, to listen on the
+// next free port when PORT is taken rather than exit and crash-loop
+func portFallbackFromEnv() bool {
+	return os.Getenv("QUAYCHECK_PORT_FALLBACK") == "true"
+}
+
+// listenOrFallback listens on port or, with fallback set and the port
+// taken, on the first port after it that suggest would hand out and that
+// can be bound
+func (s *Server) listenOrFallback(ctx context.Context, port string, fallback bool) (net.Listener, error) {
+	l, err := s.listen(ctx, port)
+	var conflict *portConflict
+	if err == nil || !fallback || !errors.As(err, &conflict) {
+		return l, err
+	}
+
+	var index *PortIndex
+	cctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	if snap, err := s.collectContainers(cctx); err == nil {
+		index = snap.Index
+	}
+	cancel()
+	for start, tries := conflict.port+1, 0; start <= maxPort && tries < 100; tries++ {
+		candidate := start
+		if index != nil {
+			if candidate = index.NextFree(start); candidate < 0 {
+				break
+			}
+		}
+		if l, err := net.Listen("tcp", ":"+strconv.Itoa(candidate)); err == nil {
+			log.Printf("==> Port %d is %s: listening on port %d instead (QUAYCHECK_PORT_FALLBACK) <==", conflict.port, conflict.taken, candidate)
+			return l, nil
+		}
+		start = candidate + 1
+	}
+	return nil, fmt.Errorf("%w, and no port after it could be bound", conflict)
+}
+
+// portFileFromEnv reads QUAYCHECK_PORT_FILE, where the port quaycheck
+// listens on is written for scripts to find it, the data directory's
+// "port" file by default
+func portFileFromEnv(dataDir string) string {
+	if path := os.Getenv("QUAYCHECK_PORT_FILE"); path != "" {
+		return path
+	}
+	return filepath.Join(dataDir, "port")
+}
+
+// portHolder names what holds tcp port in the inventory other than
+// quaycheck itself, or "" when nothing does or Docker can't be asked
+func (s *Server) portHolder(ctx context.Context, p int) string {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	snap, err := s.collectContainers(ctx)
+	if err != nil {
+		return ""
+	}
+	for _, c := range snap.Containers {
+		if c.Source == "self" || !indexHolds(c) {
+			continue
+		}
+		for _, m := range c.Ports {
+			if int(m.PublicPort) == p && m.Type != "udp" {
+				return ownerLabel(c)
+			}
+		}
+	}
+	return ""
+}
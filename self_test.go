@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+)
+
+func TestSelfSourceFor(t *testing.T) {
+	orig := inContainer
+	defer func() { inContainer = orig }()
+
+	inContainer = func() bool { return false }
+	src := selfSourceFor("8080")
+	if src == nil {
+		t.Fatal("Expected a self source outside a container")
+	}
+	entries, _ := src.Containers(context.Background())
+	if len(entries) != 1 || entries[0].Ports[0].PublicPort != 8080 || entries[0].State != "running" {
+		t.Errorf("Unexpected self entry: %+v", entries)
+	}
+	if selfSourceFor("http") != nil {
+		t.Error("Expected no self source for an invalid port")
+	}
+
+	inContainer = func() bool { return true }
+	if selfSourceFor("8080") != nil {
+		t.Error("Expected no self source in a container, Docker reports it")
+	}
+}
+
+func TestListenNamesHolder(t *testing.T) {
+	orig := inContainer
+	defer func() { inContainer = orig }()
+	inContainer = func() bool { return false }
+
+	taken, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+	port := taken.Addr().(*net.TCPAddr).Port
+
+	client := &MockDockerClient{Containers: []types.Container{
+		{Names: []string{"/grafana"}, State: "running", Ports: []types.Port{{PublicPort: uint16(port), Type: "tcp"}}},
+	}}
+	self := selfSourceFor(strconv.Itoa(port))
+	server := &Server{client: client, sources: []PortSource{self}}
+	if _, err := server.listen(context.Background(), strconv.Itoa(port)); err == nil || !strings.Contains(err.Error(), "in use by grafana") {
+		t.Errorf("Expected the bind error to name grafana, got %v", err)
+	}
+
+	// Nothing in the inventory holds it
+	server.client = &MockDockerClient{}
+	if _, err := server.listen(context.Background(), strconv.Itoa(port)); err == nil || !strings.Contains(err.Error(), "outside Docker") {
+		t.Errorf("Expected a process outside Docker, got %v", err)
+	}
+	taken.Close()
+
+	// Free to bind, but a container publishes it; quaycheck's own entry
+	// doesn't count
+	if l, err := server.listen(context.Background(), strconv.Itoa(port)); err != nil {
+		t.Errorf("Expected to listen, got %v", err)
+	} else {
+		l.Close()
+	}
+	server.client = &MockDockerClient{Containers: []types.Container{
+		{Names: []string{"/web"}, State: "paused", Ports: []types.Port{{PublicPort: uint16(port), Type: "tcp"}}},
+	}}
+	if _, err := server.listen(context.Background(), strconv.Itoa(port)); err == nil || !strings.Contains(err.Error(), "published by web (paused)") {
+		t.Errorf("Expected the conflict with web to refuse, got %v", err)
+	}
+}
+
+func TestListenOrFallback(t *testing.T) {
+	orig := inContainer
+	defer func() { inContainer = orig }()
+	inContainer = func() bool { return false }
+
+	taken, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+	port := taken.Addr().(*net.TCPAddr).Port
+	if port+2 > maxPort {
+		t.Skip("ephemeral port too high to look past")
+	}
+
+	// The next port is published by a container, so the one after is used
+	server := &Server{client: &MockDockerClient{Containers: []types.Container{
+		{Names: []string{"/web"}, State: "running", Ports: []types.Port{{PublicPort: uint16(port + 1), Type: "tcp"}}},
+	}}}
+	if _, err := server.listenOrFallback(context.Background(), strconv.Itoa(port), false); err == nil {
+		t.Fatal("Expected an error without the fallback")
+	}
+	l, err := server.listenOrFallback(context.Background(), strconv.Itoa(port), true)
+	if err != nil {
+		t.Fatalf("Expected a fallback port, got %v", err)
+	}
+	defer l.Close()
+	if got := l.Addr().(*net.TCPAddr).Port; got <= port+1 {
+		t.Errorf("Expected a port past %d, got %d", port+1, got)
+	}
+}
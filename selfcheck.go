@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+// checkDataDir makes sure the stores can write to dir, creating it if
+// needed, so a read-only mount fails at startup instead of on the first
+// reservation
+func checkDataDir(dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("cannot create data directory %s: %w; mount a writable volume there or set QUAYCHECK_DATA_DIR", dir, err)
+	}
+	f, err := os.CreateTemp(dir, ".write-test-*")
+	if err != nil {
+		return fmt.Errorf("data directory %s is not writable: %w; mount a writable volume there or set QUAYCHECK_DATA_DIR", dir, err)
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// checkDocker lists containers once to surface socket and permission
+// problems in the startup log rather than only in API responses
+func checkDocker(ctx context.Context, client DockerClient) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if _, err := client.ContainerList(ctx, types.ContainerListOptions{}); err != nil {
+		_, _, msg := classifyDockerError(err)
+		host := os.Getenv("DOCKER_HOST")
+		if host == "" {
+			host = "unix:///var/run/docker.sock"
+		}
+		return fmt.Errorf("%s (DOCKER_HOST=%s: %v)", msg, host, err)
+	}
+	return nil
+}
+
+// runHealthcheck asks the local server's /readyz, for HEALTHCHECK in images
+// that have no curl or wget
+func runHealthcheck() int {
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8080"
+	}
+	c := http.Client{Timeout: 5 * time.Second}
+	resp, err := c.Get("http://127.0.0.1:" + port + "/readyz")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		fmt.Fprintln(os.Stderr, "not ready:", resp.Status)
+		return 1
+	}
+	return 0
+}
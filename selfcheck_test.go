@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckDataDir(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "nested", "data")
+	if err := checkDataDir(dir); err != nil {
+		t.Fatalf("Expected a fresh directory to pass, got %v", err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 0 {
+		t.Errorf("Expected the write test to clean up, found %d files", len(entries))
+	}
+
+	file := filepath.Join(t.TempDir(), "file")
+	os.WriteFile(file, nil, 0o644)
+	err := checkDataDir(filepath.Join(file, "data"))
+	if err == nil || !strings.Contains(err.Error(), "QUAYCHECK_DATA_DIR") {
+		t.Errorf("Expected an error pointing at QUAYCHECK_DATA_DIR, got %v", err)
+	}
+}
+
+func TestCheckDocker(t *testing.T) {
+	if err := checkDocker(context.Background(), &MockDockerClient{}); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+	t.Setenv("DOCKER_HOST", "tcp://socket-proxy:2375")
+	err := checkDocker(context.Background(), &MockDockerClient{Err: errors.New("dial tcp: connection refused")})
+	if err == nil || !strings.Contains(err.Error(), "Cannot connect to Docker") || !strings.Contains(err.Error(), "socket-proxy:2375") {
+		t.Errorf("Expected an explained error naming DOCKER_HOST, got %v", err)
+	}
+}
+
+func TestEmbeddedStatic(t *testing.T) {
+	rr := httptest.NewRecorder()
+	staticHandler().ServeHTTP(rr, httptest.NewRequest("GET", "/app.js", nil))
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "function render") {
+		t.Errorf("Expected the embedded app.js, got %d", rr.Code)
+	}
+}
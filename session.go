@@ -0,0 +1,191 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	sessionCookie = "quaycheck_session"
+	csrfHeader    = "X-CSRF-Token"
+)
+
+// session is a browser logged in with a token. The secret stays in memory
+// so every request re-checks it: rotating or revoking the token ends the
+// session too.
+type session struct {
+	secret  string
+	csrf    string
+	expires time.Time
+}
+
+// sessionStore keeps sessions in memory; a restart logs everyone out
+type sessionStore struct {
+	ttl time.Duration
+
+	mu    sync.Mutex
+	items map[string]session
+}
+
+func newSessionStore(ttl time.Duration) *sessionStore {
+	return &sessionStore{ttl: ttl, items: make(map[string]session)}
+}
+
+func sessionTTLFromEnv() time.Duration {
+	if v := os.Getenv("QUAYCHECK_SESSION_TTL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			return d
+		}
+		log.Printf("Ignoring QUAYCHECK_SESSION_TTL=%q", v)
+	}
+	return 12 * time.Hour
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (s *sessionStore) create(secret string) (string, session) {
+	id := randomHex(32)
+	sess := session{secret: secret, csrf: randomHex(32), expires: time.Now().Add(s.ttl)}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for k, v := range s.items {
+		if now.After(v.expires) {
+			delete(s.items, k)
+		}
+	}
+	s.items[id] = sess
+	return id, sess
+}
+
+func (s *sessionStore) get(id string) (session, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess, ok := s.items[id]
+	if ok && time.Now().After(sess.expires) {
+		delete(s.items, id)
+		return session{}, false
+	}
+	return sess, ok
+}
+
+func (s *sessionStore) delete(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.items, id)
+}
+
+// fromRequest returns the session of the request's cookie, if any
+func (s *sessionStore) fromRequest(r *http.Request) (string, session, bool) {
+	if s == nil {
+		return "", session{}, false
+	}
+	c, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return "", session{}, false
+	}
+	sess, ok := s.get(c.Value)
+	return c.Value, sess, ok
+}
+
+// safeMethod is true for requests that must not change state, which
+// therefore don't need a CSRF token
+func safeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// checkCSRF requires state-changing requests on a session to echo its CSRF
+// token in a header, which another site can't read or set
+func checkCSRF(r *http.Request, sess session) bool {
+	return safeMethod(r.Method) || subtle.ConstantTimeCompare([]byte(r.Header.Get(csrfHeader)), []byte(sess.csrf)) == 1
+}
+
+type LoginRequest struct {
+	Token string `json:"token"`
+}
+
+// SessionResponse tells the UI whether it must log in, and once it has,
+// as whom and with which CSRF token
+type SessionResponse struct {
+	Required      bool       `json:"required"`
+	Authenticated bool       `json:"authenticated"`
+	Name          string     `json:"name,omitempty"`
+	Scopes        []Scope    `json:"scopes,omitempty"`
+	CSRFToken     string     `json:"csrf_token,omitempty"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+}
+
+func (s *Server) sessionResponse(t APIToken, sess session) SessionResponse {
+	expires := sess.expires.UTC()
+	return SessionResponse{
+		Required:      s.authRequired(ScopePortsRead),
+		Authenticated: true,
+		Name:          t.Name,
+		Scopes:        t.Scopes,
+		CSRFToken:     sess.csrf,
+		ExpiresAt:     &expires,
+	}
+}
+
+func (s *Server) handleGetSession(w http.ResponseWriter, r *http.Request) {
+	resp := SessionResponse{Required: s.authRequired(ScopePortsRead)}
+	if _, sess, ok := s.sessions.fromRequest(r); ok {
+		if t, ok := s.authenticate(sess.secret); ok {
+			resp = s.sessionResponse(t, sess)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleLogin trades a token for a session cookie
+func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	var req LoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		writeError(w, http.StatusBadRequest, "invalid_body", "Expected a JSON body with a token")
+		return
+	}
+	t, ok := s.authenticate(req.Token)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "unauthorized", "A valid token is required")
+		return
+	}
+	id, sess := s.sessions.create(req.Token)
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    id,
+		Path:     "/",
+		Expires:  sess.expires,
+		HttpOnly: true,
+		Secure:   s.isHTTPS(r),
+		SameSite: http.SameSiteStrictMode,
+	})
+	s.audit(r, "session.login", t.Name, "")
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(s.sessionResponse(t, sess))
+}
+
+func (s *Server) handleLogout(w http.ResponseWriter, r *http.Request) {
+	if id, sess, ok := s.sessions.fromRequest(r); ok {
+		if !checkCSRF(r, sess) {
+			writeError(w, http.StatusForbidden, "csrf", "Missing or invalid CSRF token")
+			return
+		}
+		s.sessions.delete(id)
+	}
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Value: "", Path: "/", MaxAge: -1, HttpOnly: true, SameSite: http.SameSiteStrictMode})
+	w.WriteHeader(http.StatusNoContent)
+}
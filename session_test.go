@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSessionLogin(t *testing.T) {
+	reservations, _ := NewReservationStore(nil)
+	server := &Server{
+		client:       &MockDockerClient{},
+		reservations: reservations,
+		tokens:       []APIToken{{Name: "ops", Secret: "s3cret", Scopes: []Scope{ScopeReserve, ScopePortsRead}}},
+		sessions:     newSessionStore(time.Hour),
+	}
+	mux := SetupRouter(server)
+	do := func(method, path, body string, cookie *http.Cookie, csrf string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		if csrf != "" {
+			req.Header.Set(csrfHeader, csrf)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+
+	var resp SessionResponse
+	json.NewDecoder(do("GET", "/api/session", "", nil, "").Body).Decode(&resp)
+	if !resp.Required || resp.Authenticated {
+		t.Errorf("Expected a login to be required, got %+v", resp)
+	}
+	if w := do("POST", "/api/session", `{"token":"wrong"}`, nil, ""); w.Code != 401 {
+		t.Errorf("Expected 401 for a wrong token, got %d", w.Code)
+	}
+
+	w := do("POST", "/api/session", `{"token":"s3cret"}`, nil, "")
+	if w.Code != 200 {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	json.NewDecoder(w.Body).Decode(&resp)
+	cookie := w.Result().Cookies()[0]
+	if !cookie.HttpOnly || cookie.SameSite != http.SameSiteStrictMode || resp.CSRFToken == "" || resp.Name != "ops" {
+		t.Errorf("Unexpected session: %+v %+v", cookie, resp)
+	}
+
+	if w := do("GET", "/api/ports", "", cookie, ""); w.Code != 200 {
+		t.Errorf("Expected the session to authorize reads, got %d", w.Code)
+	}
+	body := `{"name":"web","port":8080}`
+	if w := do("POST", "/api/reservations", body, cookie, ""); w.Code != 403 {
+		t.Errorf("Expected a write without CSRF token to be refused, got %d", w.Code)
+	}
+	if w := do("POST", "/api/reservations", body, cookie, resp.CSRFToken); w.Code != 201 {
+		t.Errorf("Expected a write with the CSRF token to pass, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if w := do("DELETE", "/api/session", "", cookie, resp.CSRFToken); w.Code != 204 {
+		t.Errorf("Expected 204 on logout, got %d", w.Code)
+	}
+	if w := do("GET", "/api/ports", "", cookie, ""); w.Code != 401 {
+		t.Errorf("Expected the session to end on logout, got %d", w.Code)
+	}
+}
+
+func TestSessionEndsWithToken(t *testing.T) {
+	tokens, _ := NewTokenStore(nil)
+	tok, secret, _ := tokens.Create("ops", []Scope{ScopeAdmin}, false)
+	server := &Server{client: &MockDockerClient{}, apiTokens: tokens, sessions: newSessionStore(time.Hour)}
+	id, _ := server.sessions.create(secret)
+	mux := SetupRouter(server)
+
+	req := httptest.NewRequest("GET", "/api/ports", nil)
+	req.AddCookie(&http.Cookie{Name: sessionCookie, Value: id})
+	tokens.Rotate(tok.ID)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != 401 {
+		t.Errorf("Expected rotating the token to end its sessions, got %d", w.Code)
+	}
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"quaycheck/internal/storage"
+)
+
+// Settings are the options an admin can change at runtime through
+// /api/admin/config. The environment gives the defaults; saved values
+// override them and survive restarts.
+type Settings struct {
+	// Presets are named suggest ranges, e.g. web → 8000-8999
+	Presets map[string]PortRange `json:"presets"`
+
+	// Excluded ranges are never suggested, whether or not anything holds them
+	Excluded []PortRange `json:"excluded"`
+
+	// Watched ports get Home Assistant sensors alongside reservations
+	Watched []WatchedPort `json:"watched"`
+
+	MirrorOffsets   []int    `json:"mirror_offsets"`
+	ReleaseCooldown Duration `json:"release_cooldown"`
+	CountCreated    bool     `json:"count_created"`
+
+	// DigestTo receives the email digest; SMTP itself stays in the env
+	DigestTo []string `json:"digest_to"`
+
+	// Templates are stacks that /api/templates/{name}/instantiate allocates
+	// in one go
+	Templates map[string]StackTemplate `json:"templates"`
+}
+
+// Duration is a time.Duration written as "10m" in JSON
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return errors.New("expected a duration such as \"10m\"")
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q", s)
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// validate checks every setting, normalizing empty watched protocols to tcp
+func (c *Settings) validate() error {
+	for name, pr := range c.Presets {
+		if name == "" || pr.Start < 1 || pr.End > maxPort || pr.Start > pr.End {
+			return fmt.Errorf("invalid preset %q: expected 1 <= start <= end <= %d", name, maxPort)
+		}
+	}
+	for _, pr := range c.Excluded {
+		if pr.Start < 1 || pr.End > maxPort || pr.Start > pr.End {
+			return fmt.Errorf("invalid excluded range %d-%d", pr.Start, pr.End)
+		}
+	}
+	for i, w := range c.Watched {
+		if w.Protocol == "" {
+			c.Watched[i].Protocol = "tcp"
+		}
+		if w.Name == "" || w.Port < 1 || w.Port > maxPort || (c.Watched[i].Protocol != "tcp" && c.Watched[i].Protocol != "udp") {
+			return fmt.Errorf("invalid watched port %q: expected a name, a port and tcp or udp", w.Name)
+		}
+	}
+	for _, off := range c.MirrorOffsets {
+		if off < 0 || off >= maxPort {
+			return fmt.Errorf("invalid mirror offset %d", off)
+		}
+	}
+	if c.ReleaseCooldown < 0 {
+		return errors.New("release_cooldown must not be negative")
+	}
+	for _, addr := range c.DigestTo {
+		if _, err := mail.ParseAddress(addr); err != nil {
+			return fmt.Errorf("invalid digest recipient %q", addr)
+		}
+	}
+	return validateTemplates(c.Templates, c.Presets)
+}
+
+// overlaySettings returns base with every saved key replaced by its value
+func overlaySettings(base Settings, saved map[string]json.RawMessage) (Settings, error) {
+	obj, err := json.Marshal(saved)
+	if err != nil {
+		return Settings{}, err
+	}
+	var over Settings
+	dec := json.NewDecoder(bytes.NewReader(obj))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&over); err != nil {
+		return Settings{}, err
+	}
+	for key := range saved {
+		switch key {
+		case "presets":
+			base.Presets = over.Presets
+		case "excluded":
+			base.Excluded = over.Excluded
+		case "watched":
+			base.Watched = over.Watched
+		case "mirror_offsets":
+			base.MirrorOffsets = over.MirrorOffsets
+		case "release_cooldown":
+			base.ReleaseCooldown = over.ReleaseCooldown
+		case "count_created":
+			base.CountCreated = over.CountCreated
+		case "digest_to":
+			base.DigestTo = over.DigestTo
+		case "templates":
+			base.Templates = over.Templates
+		}
+	}
+	return base, base.validate()
+}
+
+// SettingsStore persists setting overrides to the settings table, one JSON
+// value per key
+type SettingsStore struct {
+	db       *storage.DB
+	defaults Settings
+
+	mu      sync.Mutex
+	saved   map[string]json.RawMessage
+	current Settings
+}
+
+func NewSettingsStore(db *storage.DB, defaults Settings) (*SettingsStore, error) {
+	s := &SettingsStore{db: db, defaults: defaults}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reload replaces the in-memory settings with the database's. A saved value
+// this version can't read is logged and left to the default.
+func (s *SettingsStore) Reload() error {
+	saved := make(map[string]json.RawMessage)
+	if s.db != nil {
+		rows, err := s.db.Query(`SELECT key, value FROM settings`)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var key, value string
+			if err := rows.Scan(&key, &value); err != nil {
+				return err
+			}
+			saved[key] = json.RawMessage(value)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+	for key, value := range saved {
+		if _, err := overlaySettings(s.defaults, map[string]json.RawMessage{key: value}); err != nil {
+			log.Printf("Ignoring saved setting %s: %v", key, err)
+			delete(saved, key)
+		}
+	}
+	current, err := overlaySettings(s.defaults, saved)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.saved, s.current = saved, current
+	s.mu.Unlock()
+	return nil
+}
+
+// Get returns the effective settings
+func (s *SettingsStore) Get() Settings {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.current
+}
+
+// Saved lists the keys overriding the environment
+func (s *SettingsStore) Saved() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]string, 0, len(s.saved))
+	for key := range s.saved {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Update applies changes, a null value resetting its key to the default.
+// Nothing is saved unless every change is valid.
+func (s *SettingsStore) Update(changes map[string]json.RawMessage) (Settings, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	saved := make(map[string]json.RawMessage, len(s.saved)+len(changes))
+	for key, value := range s.saved {
+		saved[key] = value
+	}
+	for key, value := range changes {
+		if bytes.Equal(bytes.TrimSpace(value), []byte("null")) {
+			delete(saved, key)
+		} else {
+			saved[key] = value
+		}
+	}
+	current, err := overlaySettings(s.defaults, saved)
+	if err != nil {
+		return Settings{}, &settingsError{err}
+	}
+
+	if s.db != nil {
+		now := time.Now().UnixNano()
+		var rows [][]any
+		for key, value := range saved {
+			var compact bytes.Buffer
+			if err := json.Compact(&compact, value); err != nil {
+				return Settings{}, &settingsError{err}
+			}
+			rows = append(rows, []any{key, compact.String(), now})
+		}
+		if err := s.db.Replace(context.Background(), "settings", []string{"key", "value", "updated_at"}, rows); err != nil {
+			return Settings{}, err
+		}
+	}
+	s.saved, s.current = saved, current
+	return current, nil
+}
+
+// settingsError marks a rejected change, as opposed to a storage failure
+type settingsError struct{ err error }
+
+func (e *settingsError) Error() string { return e.err.Error() }
+func (e *settingsError) Unwrap() error { return e.err }
+
+// envSettings are the settings as the environment configured them
+func (s *Server) envSettings() Settings {
+	return Settings{
+		Presets:         s.presets,
+		Watched:         s.watched,
+		MirrorOffsets:   s.mirrorOffsets,
+		ReleaseCooldown: Duration(s.freedCooldown),
+		CountCreated:    s.countCreated,
+	}
+}
+
+// config returns the effective runtime settings
+func (s *Server) config() Settings {
+	if s.settings != nil {
+		return s.settings.Get()
+	}
+	return s.envSettings()
+}
+
+// withoutExcluded returns ix with the excluded ranges marked as used,
+// leaving ix itself untouched
+func withoutExcluded(ix *PortIndex, excluded []PortRange) *PortIndex {
+	if len(excluded) == 0 {
+		return ix
+	}
+	ix = ix.Clone()
+	for _, pr := range excluded {
+		for p := pr.Start; p <= pr.End; p++ {
+			ix.set(p)
+		}
+	}
+	ix.rebuildFree()
+	return ix
+}
+
+// pickable is ix narrowed to the ports quaycheck may pick on its own, for
+// paths that choose a port rather than check one the caller asked for:
+// excluded ranges, frozen ranges and recently released ports are taken
+func (s *Server) pickable(ix *PortIndex) *PortIndex {
+	cfg := s.config()
+	return s.withoutFrozen(s.withoutRecentlyFreed(withoutExcluded(ix, cfg.Excluded), time.Duration(cfg.ReleaseCooldown)))
+}
+
+type ConfigResponse struct {
+	Settings Settings `json:"settings"`
+	// Saved lists the settings overriding the environment
+	Saved []string `json:"saved"`
+}
+
+func (s *Server) handleGetConfig(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ConfigResponse{Settings: s.settings.Get(), Saved: s.settings.Saved()})
+}
+
+// handleUpdateConfig changes the settings named in the body and leaves the
+// others alone; {"excluded": null} goes back to the environment's value
+func (s *Server) handleUpdateConfig(w http.ResponseWriter, r *http.Request) {
+	var changes map[string]json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&changes); err != nil || len(changes) == 0 {
+		writeError(w, http.StatusBadRequest, "invalid_body", "Expected a JSON object of settings")
+		return
+	}
+	settings, err := s.settings.Update(changes)
+	var se *settingsError
+	if errors.As(err, &se) {
+		writeError(w, http.StatusBadRequest, "invalid_setting", "Invalid setting: "+se.Error())
+		return
+	} else if err != nil {
+		writeError(w, http.StatusInternalServerError, "store_error", "Cannot save settings: "+err.Error())
+		return
+	}
+
+	keys := make([]string, 0, len(changes))
+	for key := range changes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	detail, _ := json.Marshal(changes)
+	s.audit(r, "config.update", strings.Join(keys, ","), string(detail))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ConfigResponse{Settings: settings, Saved: s.settings.Saved()})
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+func TestSettingsStore(t *testing.T) {
+	db := openTestDB(t)
+	defaults := Settings{MirrorOffsets: []int{0, 8000}, ReleaseCooldown: Duration(time.Minute)}
+	store, err := NewSettingsStore(db, defaults)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if _, err := store.Update(map[string]json.RawMessage{"excluded": json.RawMessage(`[{"start":9000,"end":8000}]`)}); err == nil {
+		t.Error("Expected an inverted range to be rejected")
+	}
+	if _, err := store.Update(map[string]json.RawMessage{"bogus": json.RawMessage(`1`)}); err == nil {
+		t.Error("Expected an unknown setting to be rejected")
+	}
+	got, err := store.Update(map[string]json.RawMessage{
+		"release_cooldown": json.RawMessage(`"5m"`),
+		"watched":          json.RawMessage(`[{"name":"minecraft","port":25565}]`),
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if time.Duration(got.ReleaseCooldown) != 5*time.Minute || got.Watched[0].Protocol != "tcp" || len(got.MirrorOffsets) != 2 {
+		t.Errorf("Expected the changes over the defaults, got %+v", got)
+	}
+
+	reloaded, _ := NewSettingsStore(db, defaults)
+	if saved := reloaded.Saved(); len(saved) != 2 || saved[0] != "release_cooldown" {
+		t.Errorf("Expected both settings saved, got %v", saved)
+	}
+	if _, err := reloaded.Update(map[string]json.RawMessage{"release_cooldown": json.RawMessage(`null`)}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if time.Duration(reloaded.Get().ReleaseCooldown) != time.Minute {
+		t.Errorf("Expected null to restore the default, got %v", reloaded.Get().ReleaseCooldown)
+	}
+}
+
+func TestConfigEndpoint(t *testing.T) {
+	mock := &MockDockerClient{Containers: []types.Container{
+		{ID: "a", Names: []string{"/web"}, State: "running", Ports: []types.Port{{PublicPort: 1024, Type: "tcp"}}},
+	}}
+	server := &Server{client: mock}
+	server.settings, _ = NewSettingsStore(nil, server.envSettings())
+	server.reservations, _ = NewReservationStore(nil)
+	router := SetupRouter(server)
+
+	t.Setenv("QUAYCHECK_ADMIN_TOKEN", testAdminToken)
+	req := adminRequest("PUT", "/api/admin/config", strings.NewReader(`{"excluded":[{"start":1025,"end":2000}],"presets":{"web":{"start":1024,"end":3000}}}`))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != 200 {
+		t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/suggest?preset=web", nil))
+	var resp SuggestResponse
+	json.NewDecoder(rr.Body).Decode(&resp)
+	if resp.Port != 2001 {
+		t.Errorf("Expected the suggestion to skip the excluded range, got %d", resp.Port)
+	}
+
+	// Every path that picks a port skips it too
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, adminRequest("POST", "/api/terraform/suggest", strings.NewReader(`{"start":"1025"}`)))
+	if !strings.Contains(rr.Body.String(), `"2001"`) {
+		t.Errorf("Expected Terraform to get 2001, got %d %s", rr.Code, rr.Body)
+	}
+	for i, path := range []string{"/api/reservations", "/api/ansible/reservation"} {
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, adminRequest("POST", path, strings.NewReader(fmt.Sprintf(`{"name":"svc%d","start":1025}`, i))))
+		if want := fmt.Sprintf(`"port":%d`, 2001+i); !strings.Contains(rr.Body.String(), want) {
+			t.Errorf("%s: expected %s, got %d %s", path, want, rr.Code, rr.Body)
+		}
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, adminRequest("PUT", "/api/admin/config", strings.NewReader(`{"mirror_offsets":[-1]}`)))
+	if rr.Code != 400 {
+		t.Errorf("Expected 400 for a negative offset, got %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, adminRequest("GET", "/api/admin/config", nil))
+	var cfg ConfigResponse
+	json.NewDecoder(rr.Body).Decode(&cfg)
+	if len(cfg.Saved) != 2 || cfg.Settings.Presets["web"].End != 3000 {
+		t.Errorf("Expected the saved settings back, got %+v", cfg)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// shedAfterFromEnv reads QUAYCHECK_SHED_AFTER, how long a Docker collection
+// may run before listings are turned away; 0 never turns them away
+func shedAfterFromEnv() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("QUAYCHECK_SHED_AFTER")); err == nil && d >= 0 {
+		return d
+	}
+	return 5 * time.Second
+}
+
+// overloaded reports whether Docker has been answering for longer than
+// shedAfter and a request would have to wait for it. A cached snapshot
+// that can still be served means nobody waits, so nothing is shed.
+func (s *Server) overloaded() bool {
+	if s.shedAfter <= 0 || s.flight.busyFor() < s.shedAfter {
+		return false
+	}
+	return s.cache == nil || !s.cache.servable()
+}
+
+// sheddable answers 429 while Docker is overloaded instead of queueing one
+// more goroutine behind it. It wraps the listings dashboards poll; checks,
+// suggestions and reservations keep waiting, since a caller needs their
+// answer rather than a fresher copy of one it already has.
+func (s *Server) sheddable(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.overloaded() {
+			s.shed.Add(1)
+			w.Header().Set("Retry-After", strconv.Itoa(int(max(s.shedAfter, time.Second).Seconds())))
+			writeError(w, http.StatusTooManyRequests, "docker_busy", "Docker is slow to answer; try again shortly")
+			return
+		}
+		next(w, r)
+	}
+}
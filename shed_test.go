@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+func TestSheddable(t *testing.T) {
+	client := &blockingDockerClient{release: make(chan struct{})}
+	client.Containers = []types.Container{{Names: []string{"/web"}, State: "running", Ports: []types.Port{{PublicPort: 8080, Type: "tcp"}}}}
+	server := &Server{client: client, shedAfter: time.Millisecond}
+	router := SetupRouter(server)
+
+	// A check waits on the slow daemon
+	check := make(chan int)
+	go func() {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/api/check?port=8080", nil))
+		check <- w.Code
+	}()
+	for server.flight.busyFor() < 2*time.Millisecond {
+		time.Sleep(time.Millisecond)
+	}
+
+	// while the dashboard's poll is turned away
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/ports", nil))
+	if w.Code != 429 || w.Header().Get("Retry-After") != "1" {
+		t.Errorf("Expected 429 with Retry-After, got %d %v", w.Code, w.Header())
+	}
+
+	close(client.release)
+	if code := <-check; code != 200 {
+		t.Errorf("Expected the check to be served, got %d", code)
+	}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/ports", nil))
+	if w.Code != 200 {
+		t.Errorf("Expected 200 once Docker answered, got %d", w.Code)
+	}
+	if server.shed.Load() != 1 {
+		t.Errorf("Expected one request shed, got %d", server.shed.Load())
+	}
+}
+
+func TestOverloadedServesCache(t *testing.T) {
+	server := &Server{shedAfter: time.Millisecond, cache: &snapshotCache{TTL: time.Hour}}
+	server.cache.store(Snapshot{TakenAt: time.Now()})
+	server.flight.call = &flightCall{started: time.Now().Add(-time.Minute)}
+	if server.overloaded() {
+		t.Error("Expected no shedding while the cache can answer")
+	}
+	server.cache = nil
+	if !server.overloaded() {
+		t.Error("Expected shedding without a cache")
+	}
+}
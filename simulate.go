@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+)
+
+// SimulateStack is a compose file to roll out, named so services from
+// different stacks can be told apart
+type SimulateStack struct {
+	Name    string `json:"name"`
+	Compose string `json:"compose"`
+	// Profiles are the compose profiles the stack is started with
+	Profiles []string `json:"profiles,omitempty"`
+	// Swarm reads Compose as a docker stack file, checked on each host
+	Swarm bool `json:"swarm,omitempty"`
+}
+
+// SimulateUnit is a systemd unit or podman quadlet to roll out, named as
+// its file would be so its kind can be told
+type SimulateUnit struct {
+	Name    string `json:"name"`
+	Content string `json:"content"`
+}
+
+// SimulateMapping is a single hypothetical port mapping, or a range of
+// them up to PublicPortEnd
+type SimulateMapping struct {
+	PortMapping
+	PublicPortEnd uint16 `json:"public_port_end,omitempty"`
+	Service       string `json:"service"`
+}
+
+type SimulateRequest struct {
+	Stacks   []SimulateStack   `json:"stacks,omitempty"`
+	Units    []SimulateUnit    `json:"units,omitempty"`
+	Mappings []SimulateMapping `json:"mappings,omitempty"`
+	Start    int               `json:"start,omitempty"`
+	End      int               `json:"end,omitempty"`
+}
+
+type SimulateResponse struct {
+	OK        bool              `json:"ok"`
+	Conflicts []ComposeConflict `json:"conflicts"`
+	Start     int               `json:"start"`
+	End       int               `json:"end"`
+	Free      []PortRange       `json:"free"`
+	Count     int               `json:"free_count"`
+	Meta      *ResponseMeta     `json:"meta,omitempty"`
+}
+
+// handleSimulate is a dry run of a rollout: it reports what the given stacks
+// and mappings would conflict with, and the free ranges left once they're
+// up, without reserving or changing anything. ignore_container and
+// ignore_project in the query treat containers being replaced as gone.
+func (s *Server) handleSimulate(w http.ResponseWriter, r *http.Request) {
+	var req SimulateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_body", "Expected a JSON body with stacks or mappings")
+		return
+	}
+	lo, hi := req.Start, req.End
+	if lo == 0 {
+		lo = 1024
+	}
+	if hi == 0 {
+		hi = maxPort
+	}
+	if lo < 1 || hi < lo || hi > maxPort {
+		writeError(w, http.StatusBadRequest, "invalid_body", "Invalid start/end range")
+		return
+	}
+
+	var ports []ComposePort
+	swarm := false
+	for _, st := range req.Stacks {
+		parse := func(data []byte) ([]ComposePort, error) { return ParseCompose(data, st.Profiles) }
+		if st.Swarm {
+			parse, swarm = ParseStack, true
+		}
+		parsed, err := parse([]byte(st.Compose))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_compose", fmt.Sprintf("Cannot parse compose file for stack %q: %v", st.Name, err))
+			return
+		}
+		for _, p := range parsed {
+			if st.Name != "" {
+				p.Service = st.Name + "/" + p.Service
+			}
+			ports = append(ports, p)
+		}
+	}
+	for _, u := range req.Units {
+		parsed, err := ParseUnit(u.Name, []byte(u.Content))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_unit", fmt.Sprintf("Cannot parse unit %q: %v", u.Name, err))
+			return
+		}
+		ports = append(ports, parsed...)
+	}
+	for _, m := range req.Mappings {
+		if m.PublicPort == 0 {
+			writeError(w, http.StatusBadRequest, "invalid_body", "Every mapping needs a public_port")
+			return
+		}
+		if m.PublicPortEnd != 0 && m.PublicPortEnd < m.PublicPort {
+			writeError(w, http.StatusBadRequest, "invalid_body", "A mapping's public_port_end is below its public_port")
+			return
+		}
+		if m.Type == "" {
+			m.Type = "tcp"
+		}
+		ports = append(ports, ComposePort{PortMapping: m.PortMapping, PublicPortEnd: m.PublicPortEnd, Service: m.Service})
+	}
+
+	usage, err := s.usageFromRequest(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_param", err.Error())
+		return
+	}
+	snap, err := s.loadSnapshot(r.Context())
+	if err != nil {
+		status, code, msg := classifyDockerError(err)
+		writeError(w, status, code, msg)
+		return
+	}
+
+	live := slices.DeleteFunc(slices.Clone(snap.Containers), func(c ContainerData) bool { return !usage.holds(c) })
+	var conflicts []ComposeConflict
+	if swarm {
+		var hosts []string
+		for _, h := range s.hosts(environmentFrom(r.Context())) {
+			hosts = append(hosts, h.Name)
+		}
+		conflicts = FindStackConflicts(ports, live, hosts)
+	} else {
+		conflicts = FindComposeConflicts(ports, live)
+	}
+	if conflicts == nil {
+		conflicts = []ComposeConflict{}
+	}
+
+	ix := usage.index(snap).Clone()
+	for _, p := range ports {
+		for port := int(p.PublicPort); port <= p.last(); port++ {
+			ix.Occupy(port)
+		}
+	}
+	free := ix.FreeRanges(lo, hi)
+	count := 0
+	for _, fr := range free {
+		count += fr.End - fr.Start + 1
+	}
+
+	s.writeSnapshotHeaders(w, snap)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(SimulateResponse{
+		OK:        len(conflicts) == 0,
+		Conflicts: conflicts,
+		Start:     lo,
+		End:       hi,
+		Free:      free,
+		Count:     count,
+		Meta:      s.snapshotMeta(snap),
+	})
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+)
+
+func TestHandleSimulate(t *testing.T) {
+	store, _ := NewReservationStore(nil)
+	server := &Server{
+		client: &MockDockerClient{Containers: []types.Container{
+			{Names: []string{"/site-web-1"}, State: "running", Labels: map[string]string{composeProjectLabel: "site"}, Ports: []types.Port{{PublicPort: 8000}}},
+			{Names: []string{"/mail"}, State: "running", Ports: []types.Port{{PublicPort: 8003}}},
+		}},
+		reservations: store,
+	}
+
+	body, _ := json.Marshal(SimulateRequest{
+		Stacks: []SimulateStack{
+			{Name: "site", Compose: "services:\n  web:\n    ports:\n      - \"8000:80\"\n"},
+			{Name: "blog", Compose: "services:\n  app:\n    ports:\n      - \"8001:80\"\n"},
+		},
+		Mappings: []SimulateMapping{{Service: "extra", PortMapping: PortMapping{PublicPort: 8001}}},
+		Start:    8000,
+		End:      8005,
+	})
+
+	w := httptest.NewRecorder()
+	server.handleSimulate(w, httptest.NewRequest("POST", "/api/simulate", strings.NewReader(string(body))))
+	var resp SimulateResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	if resp.OK || len(resp.Conflicts) != 2 {
+		t.Fatalf("Expected 2 conflicts, got %+v", resp)
+	}
+	if resp.Conflicts[0].Port.Service != "site/web" || resp.Conflicts[1].Port.Service != "extra" {
+		t.Errorf("Unexpected conflicts: %+v", resp.Conflicts)
+	}
+	if resp.Count != 3 || resp.Free[0] != (PortRange{8002, 8002}) {
+		t.Errorf("Unexpected free ranges: %+v", resp.Free)
+	}
+
+	// Replacing the site stack: its own container no longer conflicts
+	w = httptest.NewRecorder()
+	server.handleSimulate(w, httptest.NewRequest("POST", "/api/simulate?ignore_project=site", strings.NewReader(string(body))))
+	resp = SimulateResponse{}
+	json.NewDecoder(w.Body).Decode(&resp)
+	if len(resp.Conflicts) != 1 || resp.Conflicts[0].Port.Service != "extra" {
+		t.Errorf("Expected only the extra/blog clash, got %+v", resp.Conflicts)
+	}
+
+	if len(store.List()) != 0 {
+		t.Error("Expected simulate not to change any state")
+	}
+
+	w = httptest.NewRecorder()
+	server.handleSimulate(w, httptest.NewRequest("POST", "/api/simulate", strings.NewReader(`{"stacks":[{"name":"x","compose":"services: ["}]}`)))
+	if w.Code != 400 {
+		t.Errorf("Expected 400 for invalid compose, got %d", w.Code)
+	}
+}
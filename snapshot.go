@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Snapshot is the inventory as of TakenAt. Failures lists the sources that
+// could not be read, in which case Containers is partial. Index is shared
+// between requests and must be cloned before being modified.
+type Snapshot struct {
+	Containers []ContainerData
+	Index      *PortIndex
+	TakenAt    time.Time
+	Stale      bool
+	Failures   []SourceFailure
+
+	// scoped is set once the snapshot is limited to an environment, whose
+	// hosts all count in Index
+	scoped   bool
+	encoding *snapshotEncoding
+}
+
+// counts reports whether c's ports belong in snap's index. Entries probed on
+// other hosts only do in a snapshot scoped to their environment; otherwise
+// they have their own plans in hosts.go.
+func (snap Snapshot) counts(c ContainerData) bool {
+	return snap.scoped || hostOf(c) == localHost
+}
+
+// snapshotCache keeps the last inventory for TTL. Once expired it is still
+// served for up to StaleWindow while a single background refresh runs, so
+// polling dashboards never wait on a slow daemon.
+type snapshotCache struct {
+	TTL         time.Duration
+	StaleWindow time.Duration
+
+	mu         sync.Mutex
+	current    *Snapshot
+	refreshing bool
+}
+
+func (c *snapshotCache) get(ctx context.Context, fetch func(context.Context) (Snapshot, error)) (Snapshot, error) {
+	c.mu.Lock()
+	if c.current != nil {
+		age := time.Since(c.current.TakenAt)
+		if age < c.TTL {
+			snap := *c.current
+			c.mu.Unlock()
+			return snap, nil
+		}
+		if age < c.TTL+c.StaleWindow {
+			snap := *c.current
+			snap.Stale = true
+			if !c.refreshing {
+				c.refreshing = true
+				go c.refresh(fetch)
+			}
+			c.mu.Unlock()
+			return snap, nil
+		}
+	}
+	c.mu.Unlock()
+
+	snap, err := fetch(ctx)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	c.store(snap)
+	return snap, nil
+}
+
+// servable reports whether get would answer without waiting on a collection
+func (c *snapshotCache) servable() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.current != nil && time.Since(c.current.TakenAt) < c.TTL+c.StaleWindow
+}
+
+func (c *snapshotCache) refresh(fetch func(context.Context) (Snapshot, error)) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	snap, err := fetch(ctx)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.refreshing = false
+	if err != nil {
+		log.Printf("Background snapshot refresh failed: %v", err)
+		return
+	}
+	c.current = &snap
+}
+
+// update applies change to a copy of the cached snapshot and keeps it if
+// change reports true. Snapshots already handed out stay as they were.
+func (c *snapshotCache) update(change func(snap *Snapshot) bool) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.current == nil {
+		return false
+	}
+	snap := *c.current
+	if !change(&snap) {
+		return false
+	}
+	snap.encoding = nil
+	c.current = &snap
+	return true
+}
+
+func (c *snapshotCache) store(snap Snapshot) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.current == nil || snap.TakenAt.After(c.current.TakenAt) {
+		c.current = &snap
+	}
+}
+
+func newSnapshotCacheFromEnv() *snapshotCache {
+	ttl := 2 * time.Second
+	if d, err := time.ParseDuration(os.Getenv("QUAYCHECK_CACHE_TTL")); err == nil {
+		ttl = d
+	}
+	stale := 10 * time.Second
+	if d, err := time.ParseDuration(os.Getenv("QUAYCHECK_CACHE_STALE")); err == nil {
+		stale = d
+	}
+	if ttl <= 0 {
+		return nil
+	}
+	return &snapshotCache{TTL: ttl, StaleWindow: stale}
+}
+
+// snapshotFlight lets concurrent callers share one collection, so fifty
+// dashboards refreshing at once cost one Docker call, not fifty
+type snapshotFlight struct {
+	mu   sync.Mutex
+	call *flightCall
+	// shared counts callers that got another's collection, for /metrics
+	shared atomic.Int64
+}
+
+type flightCall struct {
+	started time.Time
+	done    chan struct{}
+	snap    Snapshot
+	err     error
+}
+
+// do runs fetch unless a call is already in flight, and waits for it. The
+// collection is detached from the caller that started it, so that one
+// going away doesn't fail the rest; the sources' timeouts still bound it.
+func (f *snapshotFlight) do(ctx context.Context, fetch func(context.Context) (Snapshot, error)) (Snapshot, error) {
+	f.mu.Lock()
+	c := f.call
+	if c != nil {
+		f.shared.Add(1)
+	} else {
+		c = &flightCall{started: time.Now(), done: make(chan struct{})}
+		f.call = c
+		go func() {
+			c.snap, c.err = fetch(context.WithoutCancel(ctx))
+			f.mu.Lock()
+			f.call = nil
+			f.mu.Unlock()
+			close(c.done)
+		}()
+	}
+	f.mu.Unlock()
+
+	select {
+	case <-c.done:
+		return c.snap, c.err
+	case <-ctx.Done():
+		return Snapshot{}, ctx.Err()
+	}
+}
+
+// inFlight returns the collection in progress, if any
+func (f *snapshotFlight) inFlight() *flightCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.call
+}
+
+// busyFor is how long the collection in progress has been running, zero
+// when none is
+func (f *snapshotFlight) busyFor() time.Duration {
+	if c := f.inFlight(); c != nil {
+		return time.Since(c.started)
+	}
+	return 0
+}
+
+// sharedCollect collects the inventory, joining a collection in flight
+func (s *Server) sharedCollect(ctx context.Context) (Snapshot, error) {
+	return s.flight.do(ctx, s.collectContainers)
+}
+
+// loadSnapshot returns the inventory, cached when a cache is configured.
+// Reservations are always merged fresh since they change through this API.
+// A request scoped to an environment only sees that environment.
+func (s *Server) loadSnapshot(ctx context.Context) (Snapshot, error) {
+	var snap Snapshot
+	var err error
+	if s.cache != nil {
+		snap, err = s.cache.get(ctx, s.sharedCollect)
+	} else {
+		snap, err = s.sharedCollect(ctx)
+	}
+	if err != nil {
+		return Snapshot{}, err
+	}
+	// Shared with other callers
+	snap.Containers = append([]ContainerData(nil), snap.Containers...)
+
+	if s.reservations != nil {
+		reserved, _ := s.reservations.Containers(ctx)
+		if len(reserved) > 0 {
+			snap.Containers = append(snap.Containers, reserved...)
+			snap.Index = snap.Index.Clone()
+			for _, r := range reserved {
+				snap.Index.Occupy(int(r.Ports[0].PublicPort))
+			}
+		}
+	}
+	return s.scopeSnapshot(ctx, snap), nil
+}
+
+// ResponseMeta tells consumers how fresh the answer is, so "port free" can
+// be told apart from "we haven't heard from that source in a while"
+type ResponseMeta struct {
+	SnapshotTime time.Time                  `json:"snapshot_time"`
+	AgeSeconds   int64                      `json:"age_seconds"`
+	Stale        bool                       `json:"stale"`
+	Sources      map[string]SourceFreshness `json:"sources,omitempty"`
+}
+
+type SourceFreshness struct {
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	Stale       bool       `json:"stale"`
+	Error       string     `json:"error,omitempty"`
+}
+
+func staleAfterFromEnv() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("QUAYCHECK_STALE_AFTER")); err == nil && d > 0 {
+		return d
+	}
+	return 5 * time.Minute
+}
+
+// snapshotMeta describes snap. A source is stale when it hasn't answered
+// within staleAfter; the response is stale when the snapshot or any source is.
+func (s *Server) snapshotMeta(snap Snapshot) *ResponseMeta {
+	threshold := s.staleAfter
+	if threshold <= 0 {
+		threshold = 5 * time.Minute
+	}
+	age := time.Since(snap.TakenAt)
+	meta := &ResponseMeta{
+		SnapshotTime: snap.TakenAt.UTC(),
+		AgeSeconds:   int64(age.Seconds()),
+		Stale:        age > threshold,
+		Sources:      map[string]SourceFreshness{},
+	}
+
+	if s.health != nil {
+		for name, st := range s.health.Status() {
+			f := SourceFreshness{LastSuccess: st.LastSuccess, Error: st.LastError}
+			f.Stale = st.LastSuccess == nil || time.Since(*st.LastSuccess) > threshold
+			meta.Sources[name] = f
+		}
+	}
+	for _, failure := range snap.Failures {
+		f, ok := meta.Sources[failure.Source]
+		if !ok {
+			f.Stale = true
+		}
+		f.Error = failure.Error
+		meta.Sources[failure.Source] = f
+	}
+
+	for _, f := range meta.Sources {
+		if f.Stale {
+			meta.Stale = true
+		}
+	}
+	return meta
+}
+
+// writeSnapshotHeaders tells HTTP caches how long the response stays valid
+func (s *Server) writeSnapshotHeaders(w http.ResponseWriter, snap Snapshot) {
+	age := time.Since(snap.TakenAt)
+	w.Header().Set("Age", strconv.Itoa(int(age.Seconds())))
+	w.Header().Set("X-Snapshot-Time", snap.TakenAt.UTC().Format(time.RFC3339))
+	if snap.Stale {
+		w.Header().Set("X-Snapshot-Stale", "true")
+	}
+	if len(snap.Failures) > 0 {
+		failed := make([]string, len(snap.Failures))
+		for i, f := range snap.Failures {
+			failed[i] = f.Source
+		}
+		w.Header().Set("X-Snapshot-Failed-Sources", strings.Join(failed, ","))
+	}
+
+	if s.cache == nil {
+		w.Header().Set("Cache-Control", "no-cache")
+		return
+	}
+	maxAge := int((s.cache.TTL - age).Seconds())
+	if maxAge < 0 {
+		maxAge = 0
+	}
+	w.Header().Set("Cache-Control", "max-age="+strconv.Itoa(maxAge)+", stale-while-revalidate="+strconv.Itoa(int(s.cache.StaleWindow.Seconds())))
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+// countingDockerClient counts ContainerList calls
+type countingDockerClient struct {
+	MockDockerClient
+	calls atomic.Int32
+}
+
+func (c *countingDockerClient) ContainerList(ctx context.Context, options types.ContainerListOptions) ([]types.Container, error) {
+	c.calls.Add(1)
+	return c.MockDockerClient.ContainerList(ctx, options)
+}
+
+func TestSnapshotCache(t *testing.T) {
+	client := &countingDockerClient{}
+	server := &Server{client: client, cache: &snapshotCache{TTL: time.Hour, StaleWindow: time.Hour}}
+
+	server.getContainers(context.Background())
+	server.getContainers(context.Background())
+	if n := client.calls.Load(); n != 1 {
+		t.Errorf("Expected 1 Docker call within TTL, got %d", n)
+	}
+
+	// Age the snapshot past its TTL but inside the stale window
+	server.cache.mu.Lock()
+	server.cache.current.TakenAt = time.Now().Add(-90 * time.Minute)
+	server.cache.mu.Unlock()
+
+	snap, err := server.loadSnapshot(context.Background())
+	if err != nil || !snap.Stale {
+		t.Fatalf("Expected a stale snapshot, got %+v err=%v", snap, err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for client.calls.Load() < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := client.calls.Load(); n != 2 {
+		t.Fatalf("Expected a background refresh, got %d calls", n)
+	}
+	for time.Now().Before(deadline) {
+		if snap, _ := server.loadSnapshot(context.Background()); !snap.Stale {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Error("Expected the refreshed snapshot to be fresh")
+}
+
+// blockingDockerClient holds ContainerList until release is closed
+type blockingDockerClient struct {
+	countingDockerClient
+	release chan struct{}
+}
+
+func (c *blockingDockerClient) ContainerList(ctx context.Context, options types.ContainerListOptions) ([]types.Container, error) {
+	<-c.release
+	return c.countingDockerClient.ContainerList(ctx, options)
+}
+
+func TestSnapshotFlight(t *testing.T) {
+	client := &blockingDockerClient{release: make(chan struct{})}
+	client.Containers = []types.Container{{Names: []string{"/web"}, State: "running", Ports: []types.Port{{PublicPort: 8080, Type: "tcp"}}}}
+	server := &Server{client: client}
+	router := SetupRouter(server)
+
+	// The first caller gives up; the collection it started carries on
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error)
+	go func() {
+		_, err := server.loadSnapshot(ctx)
+		first <- err
+	}()
+	for server.flight.inFlight() == nil {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if err := <-first; err != context.Canceled {
+		t.Fatalf("Expected the first caller to be cancelled, got %v", err)
+	}
+
+	const requests = 50
+	codes := make(chan int, requests)
+	for range requests {
+		go func() {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest("GET", "/api/check?port=8080", nil))
+			codes <- w.Code
+		}()
+	}
+	for server.flight.shared.Load() < requests {
+		time.Sleep(time.Millisecond)
+	}
+	close(client.release)
+	for range requests {
+		if code := <-codes; code != 200 {
+			t.Errorf("Expected 200, got %d", code)
+		}
+	}
+	if n := client.calls.Load(); n != 1 {
+		t.Errorf("Expected one Docker call for %d requests, got %d", requests, n)
+	}
+}
+
+func TestSnapshotHeaders(t *testing.T) {
+	server := &Server{client: &MockDockerClient{}, cache: &snapshotCache{TTL: 5 * time.Second, StaleWindow: 30 * time.Second}}
+
+	req := httptest.NewRequest("GET", "/api/ports", nil)
+	w := httptest.NewRecorder()
+	server.handlePorts(w, req)
+
+	if cc := w.Header().Get("Cache-Control"); cc != "max-age=4, stale-while-revalidate=30" && cc != "max-age=5, stale-while-revalidate=30" {
+		t.Errorf("Unexpected Cache-Control: %s", cc)
+	}
+	if w.Header().Get("Age") != "0" {
+		t.Errorf("Expected Age 0, got %s", w.Header().Get("Age"))
+	}
+	if w.Header().Get("X-Snapshot-Stale") != "" {
+		t.Error("Expected fresh snapshot not to be flagged stale")
+	}
+
+	uncached := &Server{client: &MockDockerClient{}}
+	w = httptest.NewRecorder()
+	uncached.handlePorts(w, req)
+	if w.Header().Get("Cache-Control") != "no-cache" {
+		t.Errorf("Expected no-cache without a cache, got %s", w.Header().Get("Cache-Control"))
+	}
+}
+
+func TestSnapshotMeta(t *testing.T) {
+	server := &Server{health: NewHealthMonitor(), staleAfter: time.Minute}
+	server.health.Observe("docker", time.Millisecond, nil)
+
+	snap := Snapshot{TakenAt: time.Now()}
+	if meta := server.snapshotMeta(snap); meta.Stale || meta.Sources["docker"].Stale {
+		t.Errorf("Expected fresh meta, got %+v", meta)
+	}
+
+	snap.Failures = []SourceFailure{{Source: "remote", Error: "no route to host"}}
+	meta := server.snapshotMeta(snap)
+	if !meta.Stale || !meta.Sources["remote"].Stale || meta.Sources["remote"].Error != "no route to host" {
+		t.Errorf("Expected a never-seen failed source to be stale, got %+v", meta)
+	}
+
+	snap = Snapshot{TakenAt: time.Now().Add(-2 * time.Minute)}
+	if meta := server.snapshotMeta(snap); !meta.Stale || meta.AgeSeconds < 120 {
+		t.Errorf("Expected an old snapshot to be stale, got %+v", meta)
+	}
+}
+
+func TestHandleCheckMeta(t *testing.T) {
+	server := &Server{client: &MockDockerClient{}}
+
+	w := httptest.NewRecorder()
+	server.handleCheck(w, httptest.NewRequest("GET", "/api/check?port=8080", nil))
+
+	var resp CheckResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	if resp.Meta == nil || resp.Meta.Stale || resp.Meta.SnapshotTime.IsZero() {
+		t.Errorf("Expected fresh meta, got %+v", resp.Meta)
+	}
+}
+
+func TestReservationWritesCollectLive(t *testing.T) {
+	client := &blockingDockerClient{release: make(chan struct{})}
+	client.Containers = []types.Container{{State: "running", Ports: []types.Port{{PublicPort: 3000}}}}
+	server := &Server{client: client, cache: &snapshotCache{TTL: time.Hour, StaleWindow: time.Hour}}
+	server.reservations, _ = NewReservationStore(nil)
+	// The cached view predates the container binding 3000
+	server.cache.store(Snapshot{Index: buildPortIndex(nil), TakenAt: time.Now()})
+
+	names := []string{"grafana", "prometheus", "loki"}
+	results := make(chan *httptest.ResponseRecorder, len(names))
+	for _, name := range names {
+		go func() {
+			w := httptest.NewRecorder()
+			server.handleCreateReservation(w, httptest.NewRequest("POST", "/api/reservations", strings.NewReader(`{"name":"`+name+`","start":3000}`)))
+			results <- w
+		}()
+	}
+	for server.flight.shared.Load() < int64(len(names)-1) {
+		time.Sleep(time.Millisecond)
+	}
+	close(client.release)
+	for range names {
+		if w := <-results; w.Code != 201 {
+			t.Fatalf("Expected a reservation, got %d: %s", w.Code, w.Body.String())
+		}
+	}
+	if n := client.calls.Load(); n != 1 {
+		t.Errorf("Expected concurrent writes to share one Docker call, got %d", n)
+	}
+	for _, r := range server.reservations.List() {
+		if r.Port == 3000 {
+			t.Errorf("Expected writes to see the container on 3000, got %+v", r)
+		}
+	}
+}
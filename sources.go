@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// PortSource provides port occupancy from somewhere other than the Docker API
+type PortSource interface {
+	Name() string
+	Containers(ctx context.Context) ([]ContainerData, error)
+}
+
+// sourcesFromEnv builds the optional port sources enabled by environment variables
+func sourcesFromEnv() []PortSource {
+	var sources []PortSource
+	if os.Getenv("QUAYCHECK_IPTABLES") == "true" {
+		sources = append(sources, &IptablesSource{})
+	}
+	if path := os.Getenv("QUAYCHECK_FORWARDS_FILE"); path != "" {
+		sources = append(sources, &ForwardsFileSource{Path: path})
+	}
+	if os.Getenv("QUAYCHECK_LIBVIRT") == "true" {
+		sources = append(sources, &LibvirtSource{URI: os.Getenv("QUAYCHECK_LIBVIRT_URI")})
+	}
+	if socket := os.Getenv("QUAYCHECK_LXD_SOCKET"); socket != "" {
+		sources = append(sources, NewLXDSource(socket))
+	}
+	if router := routerFromEnv(); router != nil {
+		sources = append(sources, router)
+	}
+	if spec := os.Getenv("QUAYCHECK_PROBE_TARGETS"); spec != "" {
+		if probe := newProbeSourceFromEnv(spec, os.Getenv("QUAYCHECK_PROBE_INTERVAL")); probe != nil {
+			sources = append(sources, probe)
+		}
+	}
+	return sources
+}
+
+// withIngest attaches ingested ports to the manual source, adding one when
+// no forwards file is configured
+func withIngest(sources []PortSource, ingest *IngestStore) []PortSource {
+	for _, src := range sources {
+		if manual, ok := src.(*ForwardsFileSource); ok {
+			manual.Ingested = ingest
+			return sources
+		}
+	}
+	return append(sources, &ForwardsFileSource{Ingested: ingest})
+}
+
+// startSources launches the background loop of every source that has one
+func startSources(ctx context.Context, sources []PortSource) {
+	for _, src := range sources {
+		if r, ok := src.(interface{ Run(context.Context) }); ok {
+			go r.Run(ctx)
+		}
+	}
+}
+
+// SourceFailure records a source that errored or timed out during collection
+type SourceFailure struct {
+	Source string `json:"source"`
+	Error  string `json:"error"`
+}
+
+// collectSources queries every source concurrently, each bounded by timeout.
+// Failing sources are flagged in the snapshot and the rest is returned; it
+// only errors when no source answered at all. Docker entries keep an empty
+// Source, everything else is tagged with its source name.
+func collectSources(ctx context.Context, sources []PortSource, timeout time.Duration) (Snapshot, error) {
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+
+	type outcome struct {
+		entries []ContainerData
+		err     error
+	}
+	outcomes := make([]outcome, len(sources))
+
+	var wg sync.WaitGroup
+	for i, src := range sources {
+		wg.Add(1)
+		go func(i int, src PortSource) {
+			defer wg.Done()
+			sctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			entries, err := src.Containers(sctx)
+			outcomes[i] = outcome{entries, err}
+		}(i, src)
+	}
+	wg.Wait()
+
+	snap := Snapshot{TakenAt: time.Now()}
+	var firstErr error
+	for i, o := range outcomes {
+		name := sources[i].Name()
+		if o.err != nil {
+			log.Printf("Port source %s failed: %v", name, o.err)
+			snap.Failures = append(snap.Failures, SourceFailure{Source: name, Error: o.err.Error()})
+			if firstErr == nil {
+				firstErr = o.err
+			}
+			continue
+		}
+		if name != "docker" {
+			for j := range o.entries {
+				if o.entries[j].Source == "" {
+					o.entries[j].Source = name
+				}
+			}
+		}
+		snap.Containers = append(snap.Containers, o.entries...)
+	}
+
+	if len(sources) > 0 && len(snap.Failures) == len(sources) {
+		return Snapshot{}, firstErr
+	}
+	snap.Index = buildPortIndex(snap.Containers)
+	snap.encoding = newSnapshotEncoding(snap.Containers)
+	return snap, nil
+}
+
+func sourceTimeoutFromEnv() time.Duration {
+	d, _ := time.ParseDuration(os.Getenv("QUAYCHECK_SOURCE_TIMEOUT"))
+	return d
+}
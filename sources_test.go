@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+// stubSource returns fixed entries, an error, or blocks until cancelled
+type stubSource struct {
+	name    string
+	entries []ContainerData
+	err     error
+	block   bool
+}
+
+func (s *stubSource) Name() string { return s.name }
+
+func (s *stubSource) Containers(ctx context.Context) ([]ContainerData, error) {
+	if s.block {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	return s.entries, s.err
+}
+
+func TestCollectSourcesPartial(t *testing.T) {
+	sources := []PortSource{
+		&stubSource{name: "fast", entries: []ContainerData{{ID: "a"}}},
+		&stubSource{name: "slow", block: true},
+		&stubSource{name: "broken", err: errors.New("boom")},
+	}
+
+	start := time.Now()
+	snap, err := collectSources(context.Background(), sources, 50*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Expected partial result, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected sources to be collected concurrently with a timeout, took %v", elapsed)
+	}
+	if len(snap.Containers) != 1 || snap.Containers[0].Source != "fast" {
+		t.Errorf("Unexpected containers: %+v", snap.Containers)
+	}
+	if len(snap.Failures) != 2 || snap.Failures[0].Source != "slow" || snap.Failures[1].Source != "broken" {
+		t.Errorf("Unexpected failures: %+v", snap.Failures)
+	}
+
+	if _, err := collectSources(context.Background(), sources[2:], time.Second); err == nil {
+		t.Error("Expected an error when every source fails")
+	}
+}
+
+func TestHandlePortsFlagsFailedSources(t *testing.T) {
+	server := &Server{
+		client:  &MockDockerClient{Containers: []types.Container{{ID: "123", State: "running"}}},
+		sources: []PortSource{&stubSource{name: "lxd", err: errors.New("socket gone")}},
+	}
+
+	req := httptest.NewRequest("GET", "/api/ports", nil)
+	w := httptest.NewRecorder()
+	server.handlePorts(w, req)
+
+	if w.Code != 200 {
+		t.Fatalf("Expected 200 with partial data, got %d", w.Code)
+	}
+	if w.Header().Get("X-Snapshot-Failed-Sources") != "lxd" {
+		t.Errorf("Expected lxd to be flagged, got %q", w.Header().Get("X-Snapshot-Failed-Sources"))
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Stack is a compose project as its container labels describe it
+type Stack struct {
+	Project     string         `json:"project"`
+	WorkingDir  string         `json:"working_dir,omitempty"`
+	ConfigFiles []string       `json:"config_files,omitempty"`
+	Services    []StackService `json:"services"`
+}
+
+// StackService maps a compose service to the host ports its containers
+// publish
+type StackService struct {
+	Name       string        `json:"name"`
+	Containers []string      `json:"containers"`
+	Ports      []PortMapping `json:"ports"`
+	// Declared holds the service's ports: entries as written in the compose
+	// files, when quaycheck can read them
+	Declared []string `json:"declared,omitempty"`
+}
+
+// composeRootFromEnv reads QUAYCHECK_COMPOSE_ROOT, where the host's
+// filesystem is mounted, so compose files can be read from the paths their
+// labels give
+func composeRootFromEnv() string {
+	return os.Getenv("QUAYCHECK_COMPOSE_ROOT")
+}
+
+// buildStacks groups compose containers into stacks. readFile reads a
+// compose file by the path its labels give; files it can't read are skipped.
+func buildStacks(containers []ContainerData, readFile func(string) ([]byte, error)) []Stack {
+	byProject := make(map[string]*Stack)
+	for _, c := range containers {
+		if c.Project == "" || c.Source != "" {
+			continue
+		}
+		st, ok := byProject[c.Project]
+		if !ok {
+			st = &Stack{Project: c.Project, WorkingDir: c.ComposeDir, ConfigFiles: c.ComposeFiles}
+			byProject[c.Project] = st
+		}
+		name := c.Service
+		if name == "" {
+			name = containerName(c)
+		}
+		i := slices.IndexFunc(st.Services, func(s StackService) bool { return s.Name == name })
+		if i < 0 {
+			st.Services = append(st.Services, StackService{Name: name, Containers: []string{}, Ports: []PortMapping{}})
+			i = len(st.Services) - 1
+		}
+		svc := &st.Services[i]
+		svc.Containers = append(svc.Containers, containerName(c))
+		if !occupiesPorts(c.State) {
+			continue
+		}
+		for _, p := range c.Ports {
+			// IPv4 and IPv6 bindings of one mapping show up twice
+			if p.PublicPort == 0 || slices.ContainsFunc(svc.Ports, func(q PortMapping) bool {
+				return q.PublicPort == p.PublicPort && q.PrivatePort == p.PrivatePort && q.Type == p.Type
+			}) {
+				continue
+			}
+			p.IP = ""
+			svc.Ports = append(svc.Ports, p)
+		}
+	}
+
+	stacks := make([]Stack, 0, len(byProject))
+	for _, st := range byProject {
+		declared := make(map[string][]string)
+		for _, file := range st.ConfigFiles {
+			data, err := readFile(file)
+			if err != nil {
+				continue
+			}
+			ports, err := ComposeDeclaredPorts(data)
+			if err != nil {
+				log.Printf("Cannot parse %s of stack %s: %v", file, st.Project, err)
+				continue
+			}
+			// Override files add to the ports of the files before them
+			for svc, list := range ports {
+				declared[svc] = append(declared[svc], list...)
+			}
+		}
+		for i := range st.Services {
+			svc := &st.Services[i]
+			svc.Declared = declared[svc.Name]
+			sort.Strings(svc.Containers)
+			sort.Slice(svc.Ports, func(a, b int) bool { return svc.Ports[a].PublicPort < svc.Ports[b].PublicPort })
+		}
+		sort.Slice(st.Services, func(a, b int) bool { return st.Services[a].Name < st.Services[b].Name })
+		stacks = append(stacks, *st)
+	}
+	sort.Slice(stacks, func(i, j int) bool { return stacks[i].Project < stacks[j].Project })
+	return stacks
+}
+
+// dotenv writes SERVICE_PORT=port for each service's lowest port, then
+// SERVICE_PORT_<container port>=port for each container port, with the
+// lowest host port of a scaled service
+func (st Stack) dotenv() string {
+	var b strings.Builder
+	for _, svc := range st.Services {
+		if len(svc.Ports) == 0 {
+			continue
+		}
+		name := portVarName(svc.Name)
+		fmt.Fprintf(&b, "%s=%d\n", name, svc.Ports[0].PublicPort)
+		seen := make(map[uint16]bool)
+		for _, p := range svc.Ports {
+			if !seen[p.PrivatePort] {
+				seen[p.PrivatePort] = true
+				fmt.Fprintf(&b, "%s_%d=%d\n", name, p.PrivatePort, p.PublicPort)
+			}
+		}
+	}
+	return b.String()
+}
+
+func (s *Server) stacks(r *http.Request) ([]Stack, error) {
+	snap, err := s.loadSnapshot(r.Context())
+	if err != nil {
+		return nil, err
+	}
+	return buildStacks(snap.Containers, func(path string) ([]byte, error) {
+		return os.ReadFile(filepath.Join(s.composeRoot, path))
+	}), nil
+}
+
+func (s *Server) handleStacks(w http.ResponseWriter, r *http.Request) {
+	stacks, err := s.stacks(r)
+	if err != nil {
+		status, code, msg := classifyDockerError(err)
+		writeError(w, status, code, msg)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stacks)
+}
+
+// handleStack serves one stack; ?format=env answers with a .env file of
+// its ports for scripts
+func (s *Server) handleStack(w http.ResponseWriter, r *http.Request) {
+	stacks, err := s.stacks(r)
+	if err != nil {
+		status, code, msg := classifyDockerError(err)
+		writeError(w, status, code, msg)
+		return
+	}
+	i := slices.IndexFunc(stacks, func(st Stack) bool { return st.Project == r.PathValue("project") })
+	if i < 0 {
+		writeError(w, http.StatusNotFound, "not_found", localize(w, "No compose project named %q", r.PathValue("project")))
+		return
+	}
+	if r.URL.Query().Get("format") == "env" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprint(w, stacks[i].dotenv())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stacks[i])
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+)
+
+func composeContainer(id, service, number string, state string, ports ...types.Port) types.Container {
+	return types.Container{
+		ID:    id,
+		Names: []string{"/monitoring-" + service + "-" + number},
+		State: state,
+		Ports: ports,
+		Labels: map[string]string{
+			composeProjectLabel:     "monitoring",
+			composeServiceLabel:     service,
+			composeNumberLabel:      number,
+			composeConfigFilesLabel: "/srv/monitoring/compose.yaml,/srv/monitoring/compose.override.yaml",
+			composeWorkingDirLabel:  "/srv/monitoring",
+		},
+	}
+}
+
+func TestStacks(t *testing.T) {
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "srv/monitoring"), 0o755)
+	os.WriteFile(filepath.Join(root, "srv/monitoring/compose.yaml"), []byte(`services:
+  grafana:
+    ports:
+      - "3000:3000"
+  exporter:
+    ports:
+      - target: 9100
+        published: "9100-9101"
+`), 0o644)
+
+	server := &Server{composeRoot: root, client: &MockDockerClient{Containers: []types.Container{
+		composeContainer("g", "grafana", "1", "running",
+			types.Port{PublicPort: 3000, PrivatePort: 3000, Type: "tcp", IP: "0.0.0.0"},
+			types.Port{PublicPort: 3000, PrivatePort: 3000, Type: "tcp", IP: "::"}),
+		composeContainer("e1", "exporter", "1", "running", types.Port{PublicPort: 9100, PrivatePort: 9100, Type: "tcp"}),
+		composeContainer("e2", "exporter", "2", "running", types.Port{PublicPort: 9101, PrivatePort: 9100, Type: "tcp"}),
+		{ID: "x", Names: []string{"/standalone"}, State: "running"},
+	}}}
+	mux := SetupRouter(server)
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/api/stacks", nil))
+	var stacks []Stack
+	json.NewDecoder(w.Body).Decode(&stacks)
+	if len(stacks) != 1 || stacks[0].WorkingDir != "/srv/monitoring" || len(stacks[0].ConfigFiles) != 2 || len(stacks[0].Services) != 2 {
+		t.Fatalf("Expected the monitoring stack alone, got %+v", stacks)
+	}
+	exporter, grafana := stacks[0].Services[0], stacks[0].Services[1]
+	if len(exporter.Containers) != 2 || len(exporter.Ports) != 2 || exporter.Ports[1].PublicPort != 9101 {
+		t.Errorf("Expected both exporter replicas, got %+v", exporter)
+	}
+	if len(exporter.Declared) != 1 || exporter.Declared[0] != `{target: 9100, published: "9100-9101"}` {
+		t.Errorf("Expected the long syntax as written, got %q", exporter.Declared)
+	}
+	if len(grafana.Ports) != 1 || len(grafana.Declared) != 1 || grafana.Declared[0] != "3000:3000" {
+		t.Errorf("Expected grafana's port once, as written, got %+v", grafana)
+	}
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/api/stacks/monitoring?format=env", nil))
+	want := "EXPORTER_PORT=9100\nEXPORTER_PORT_9100=9100\nGRAFANA_PORT=3000\nGRAFANA_PORT_3000=3000\n"
+	if w.Body.String() != want {
+		t.Errorf("Unexpected env file:\n%s", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/api/stacks/nope", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown project, got %d", w.Code)
+	}
+}
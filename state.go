@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"quaycheck/internal/storage"
+)
+
+// openState opens the SQLite database in dataDir and moves over any JSON
+// files left by earlier versions
+func openState(dataDir string) (*storage.DB, *ReservationStore, *FreezeStore, *TagStore, error) {
+	db, err := storage.Open(filepath.Join(dataDir, "quaycheck.db"))
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	reservations, err := NewReservationStore(db)
+	if err != nil {
+		return nil, nil, nil, nil, fmt.Errorf("load reservations: %w", err)
+	}
+	freezes, err := NewFreezeStore(db)
+	if err != nil {
+		return nil, nil, nil, nil, fmt.Errorf("load freezes: %w", err)
+	}
+	tags, err := NewTagStore(db)
+	if err != nil {
+		return nil, nil, nil, nil, fmt.Errorf("load tags: %w", err)
+	}
+
+	imports := []struct {
+		file string
+		load func(path string) error
+	}{
+		{"reservations.json", func(path string) error {
+			var list []Reservation
+			if err := readJSONFile(path, &list); err != nil {
+				return err
+			}
+			return reservations.Import(list)
+		}},
+		{"freezes.json", func(path string) error {
+			var list []Freeze
+			if err := readJSONFile(path, &list); err != nil {
+				return err
+			}
+			freezes.mu.Lock()
+			defer freezes.mu.Unlock()
+			for _, f := range list {
+				freezes.addLoaded(f)
+			}
+			return freezes.saveLocked()
+		}},
+		{"tags.json", func(path string) error {
+			var list []PortTag
+			if err := readJSONFile(path, &list); err != nil {
+				return err
+			}
+			tags.mu.Lock()
+			defer tags.mu.Unlock()
+			for _, t := range list {
+				tags.items[t.Port] = t
+			}
+			return tags.saveLocked()
+		}},
+	}
+	for _, im := range imports {
+		path := filepath.Join(dataDir, im.file)
+		if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err := im.load(path); err != nil {
+			return nil, nil, nil, nil, fmt.Errorf("import %s: %w", path, err)
+		}
+		// Keep the file around, renamed so it's only imported once
+		if err := os.Rename(path, path+".imported"); err != nil {
+			return nil, nil, nil, nil, err
+		}
+		log.Printf("Imported %s into %s", path, db.Path())
+	}
+	return db, reservations, freezes, tags, nil
+}
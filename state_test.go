@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"quaycheck/internal/storage"
+)
+
+// openTestDB opens a fresh state database that is closed with the test
+func openTestDB(t *testing.T) *storage.DB {
+	t.Helper()
+	db, err := storage.Open(filepath.Join(t.TempDir(), "quaycheck.db"))
+	if err != nil {
+		t.Fatalf("Cannot open state database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestOpenStateImportsJSON(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "reservations.json"), []byte(`[{"name":"grafana","port":3000,"protocol":"tcp","created_at":"2024-01-01T00:00:00Z"}]`), 0o644)
+	os.WriteFile(filepath.Join(dir, "tags.json"), []byte(`[{"port":8443,"tags":["legacy"],"updated_at":"2024-01-01T00:00:00Z"}]`), 0o644)
+
+	db, reservations, _, tags, err := openState(dir)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if r, ok := reservations.Get("", "grafana"); !ok || r.Port != 3000 {
+		t.Errorf("Expected the JSON reservation to be imported, got %+v", r)
+	}
+	if len(tags.List()) != 1 {
+		t.Errorf("Expected the JSON tags to be imported, got %+v", tags.List())
+	}
+	if _, err := os.Stat(filepath.Join(dir, "reservations.json.imported")); err != nil {
+		t.Errorf("Expected the imported file to be renamed: %v", err)
+	}
+	db.Close()
+
+	// A second start reads from the database only
+	db, reservations, _, _, err = openState(dir)
+	if err != nil {
+		t.Fatalf("Expected reopen to succeed, got %v", err)
+	}
+	defer db.Close()
+	if r, _ := reservations.Get("", "grafana"); !r.CreatedAt.Equal(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected created_at to survive the round trip, got %v", r.CreatedAt)
+	}
+}
+
+func TestHistoryAndAudit(t *testing.T) {
+	db := openTestDB(t)
+	server := &Server{client: &MockDockerClient{}, history: NewHistoryStore(db), auditLog: NewAuditLog(db), db: db}
+	router := SetupRouter(server)
+
+	now := time.Now().UTC()
+	server.recordHistory(context.Background(), []PortEvent{
+		{Type: EventPortOccupied, Port: 8443, Protocol: "tcp", Container: "grafana", Time: now.Add(-time.Hour)},
+		{Type: EventPortFreed, Port: 8443, Protocol: "tcp", Container: "grafana", Time: now},
+		{Type: EventPortOccupied, Port: 9000, Protocol: "tcp", Container: "minio", Time: now},
+	})
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/history?port=8443", nil))
+	var events []PortEvent
+	json.NewDecoder(rr.Body).Decode(&events)
+	if len(events) != 2 || events[0].Type != EventPortFreed || events[1].Container != "grafana" {
+		t.Errorf("Expected 8443's two events newest first, got %+v", events)
+	}
+
+	server.audit(httptest.NewRequest("POST", "/api/reservations", nil), "reservation.create", "grafana", "3000")
+	rr = httptest.NewRecorder()
+	t.Setenv("QUAYCHECK_ADMIN_TOKEN", testAdminToken)
+	router.ServeHTTP(rr, adminRequest("GET", "/api/admin/audit", nil))
+	var entries []AuditEntry
+	json.NewDecoder(rr.Body).Decode(&entries)
+	if len(entries) != 1 || entries[0].Action != "reservation.create" || entries[0].Subject != "grafana" {
+		t.Errorf("Unexpected audit log: %+v", entries)
+	}
+}
@@ -1,6 +1,64 @@
 const esc = s => s.replace(/[&<>"']/g, c => ({'&':'&amp;','<':'&lt;','>':'&gt;','"':'&quot;',"'":'&#39;'})[c]);
 
+// UI strings; API messages come back already translated through
+// Accept-Language, which the browser sends on its own
+const messages = {
+    fr: {
+        'copied': 'copié',
+        'Unknown error': 'Erreur inconnue',
+        'failed to connect': 'connexion impossible',
+        'no containers': 'aucun conteneur',
+        'update available': 'mise à jour disponible',
+        'up {0}': 'actif depuis {0}',
+        '{0} restart': '{0} redémarrage',
+        '{0} restarts': '{0} redémarrages',
+        'available': 'disponible',
+        'in use': 'utilisé',
+        'suggested': 'suggéré',
+        'error': 'erreur',
+        'Check Port': 'Vérifier un port',
+        'check': 'vérifier',
+        'suggest': 'suggérer',
+        'Containers': 'Conteneurs',
+        'Name': 'Nom',
+        'State': 'État',
+        'Ports': 'Ports',
+        'mapped to host': "publié sur l'hôte",
+        'exposed only': 'exposé seulement',
+        'loading...': 'chargement...',
+        'Toggle theme': 'Changer de thème',
+        'Refresh': 'Actualiser',
+        'less is better': 'moins, c’est mieux',
+        'Log in': 'Connexion',
+        'log in': 'se connecter',
+        'Log out': 'Se déconnecter',
+        'API token': "Jeton d'API",
+        'stop': 'arrêter',
+        'restart': 'redémarrer',
+        'Stop the container publishing port {0}?': 'Arrêter le conteneur qui publie le port {0} ?',
+        'Restart the container publishing port {0}?': 'Redémarrer le conteneur qui publie le port {0} ?',
+        'Plan a Stack': 'Planifier une stack',
+        'Paste a compose file': 'Collez un fichier compose',
+        'check compose': 'vérifier le compose',
+        'no conflicts': 'aucun conflit',
+        'offline snapshot': 'instantané hors ligne',
+        'Served from an exported snapshot, not a live Docker host': "Servi depuis un instantané exporté, pas depuis un hôte Docker",
+    },
+};
+const lang = (navigator.language || 'en').split('-')[0].toLowerCase();
+const t = (key, ...args) => (messages[lang]?.[key] ?? key).replace(/\{(\d)\}/g, (_, i) => args[i]);
+
+function translatePage() {
+    document.documentElement.lang = messages[lang] ? lang : 'en';
+    document.querySelectorAll('[data-i18n]').forEach(el => { el.textContent = t(el.dataset.i18n); });
+    document.querySelectorAll('[data-i18n-title]').forEach(el => { el.title = t(el.dataset.i18nTitle); });
+    document.querySelectorAll('[data-i18n-placeholder]').forEach(el => { el.placeholder = t(el.dataset.i18nPlaceholder); });
+}
+
 let containersData = [];
+// containerActions is set when the server lets this UI stop and restart
+// containers
+let containerActions = false;
 let sortColumn = 'name';
 let sortAsc = true;
 
@@ -10,7 +68,7 @@ async function copyPort(el) {
     try {
         await navigator.clipboard.writeText(text);
         const original = el.textContent;
-        el.textContent = 'copied';
+        el.textContent = t('copied');
         el.classList.add('copied');
         setTimeout(() => {
             el.textContent = original;
@@ -19,9 +77,24 @@ async function copyPort(el) {
     } catch (e) {}
 }
 
+// uiActions run the buttons' data-action: the CSP allows no inline handlers
+const uiActions = {
+    toggleTheme: () => toggleTheme(),
+    logout: () => logout(),
+    check: () => check(),
+    suggest: () => suggest(),
+    planCompose: () => planCompose(),
+    load: () => load(),
+    sortBy: el => sortBy(el.dataset.column),
+    containerAction: el => containerAction(Number(el.dataset.port), el.dataset.verb),
+};
+
 document.addEventListener('click', e => {
     if (e.target.classList.contains('port')) copyPort(e.target);
+    const el = e.target.closest('[data-action]');
+    if (el) uiActions[el.dataset.action]?.(el);
 });
+document.getElementById('login-form').addEventListener('submit', login);
 
 function toggleTheme() {
     const html = document.documentElement;
@@ -37,17 +110,85 @@ function loadTheme() {
     }
 }
 
-async function api(url) {
-    const res = await fetch(url);
+// csrfToken comes with the session and must accompany every request that
+// changes state
+let csrfToken = '';
+
+async function api(url, options = {}) {
+    const method = options.method || 'GET';
+    const headers = { ...options.headers };
+    if (method !== 'GET' && csrfToken) headers['X-CSRF-Token'] = csrfToken;
+    if (options.body) headers['Content-Type'] = 'application/json';
+    const res = await fetch(url, { ...options, method, headers });
+    if (res.status === 204) return null;
     const data = await res.json();
     if (!res.ok) {
+        if (res.status === 401) showLogin();
         throw { status: res.status, ...data };
     }
     return data;
 }
 
+function showLogin() {
+    document.getElementById('login').hidden = false;
+    document.getElementById('app').hidden = true;
+    document.getElementById('logout').hidden = true;
+    document.getElementById('token').focus();
+}
+
+function showApp(session) {
+    csrfToken = session.csrf_token || '';
+    document.getElementById('login').hidden = true;
+    document.getElementById('app').hidden = false;
+    document.getElementById('logout').hidden = !session.authenticated;
+}
+
+async function login(e) {
+    e.preventDefault();
+    const input = document.getElementById('token');
+    try {
+        const session = await api('/api/session', { method: 'POST', body: JSON.stringify({ token: input.value }) });
+        input.value = '';
+        document.getElementById('login-error').innerHTML = '';
+        showApp(session);
+        load();
+    } catch (err) {
+        showError(document.getElementById('login-error'), err);
+    }
+}
+
+async function logout() {
+    try {
+        await api('/api/session', { method: 'DELETE' });
+    } catch (e) {}
+    csrfToken = '';
+    containersData = [];
+    showLogin();
+}
+
+// start shows the login form when the server requires a token and this
+// browser has no session yet
+async function start() {
+    try {
+        const session = await api('/api/session');
+        if (session.required && !session.authenticated) {
+            showLogin();
+            return;
+        }
+        showApp(session);
+    } catch (e) {
+        // Older servers have no sessions; carry on as before
+    }
+    try {
+        const caps = await api('/api/capabilities');
+        containerActions = !!caps.features?.container_actions;
+        document.getElementById('offline').hidden = !caps.features?.offline;
+    } catch (e) {}
+    load();
+}
+
 function showError(el, err) {
-    const msg = err.message || 'Unknown error';
+    const msg = err.message || t('Unknown error');
     const code = err.code || '';
     el.innerHTML = `<div class="error-banner">${esc(msg)}${code ? ` <code>${esc(code)}</code>` : ''}</div>`;
 }
@@ -58,10 +199,12 @@ async function load() {
         containersData = await api('/api/ports');
         sortAndRender();
     } catch (e) {
+        // Docker is slow: keep showing what we have until the next poll
+        if (e.status === 429 && containersData.length) return;
         if (e.message) {
             showError(tbody, e);
         } else {
-            tbody.innerHTML = '<tr><td colspan="3" class="empty">failed to connect</td></tr>';
+            tbody.innerHTML = `<tr><td colspan="3" class="empty">${t('failed to connect')}</td></tr>`;
         }
     }
 }
@@ -103,16 +246,28 @@ function updateSortIndicators() {
     });
 }
 
+function formatUptime(secs) {
+    if (secs >= 86400) return `${Math.floor(secs / 86400)}d`;
+    if (secs >= 3600) return `${Math.floor(secs / 3600)}h`;
+    if (secs >= 60) return `${Math.floor(secs / 60)}m`;
+    return `${secs}s`;
+}
+
 function render(containers) {
     const tbody = document.getElementById('containers');
     if (!containers || !containers.length) {
-        tbody.innerHTML = '<tr><td colspan="3" class="empty">no containers</td></tr>';
+        tbody.innerHTML = `<tr><td colspan="3" class="empty">${t('no containers')}</td></tr>`;
         return;
     }
     tbody.innerHTML = containers.map(c => {
         const name = esc(c.names?.[0]?.replace(/^\//, '') || c.id.slice(0, 12));
-        const image = esc(c.image || '');
+        const image = esc(c.image || '') + (c.update_available ? ` <span class="update">${t('update available')}</span>` : '');
         const state = esc(c.state || '');
+        const health = c.health ? ` <span class="health ${esc(c.health)}">${esc(c.health)}</span>` : '';
+        const stability = [
+            c.uptime_seconds ? t('up {0}', formatUptime(c.uptime_seconds)) : '',
+            c.restart_count ? t(c.restart_count > 1 ? '{0} restarts' : '{0} restart', c.restart_count) : '',
+        ].filter(Boolean).join(' · ');
         const seen = new Set();
         const ports = c.ports?.length
             ? c.ports.filter(p => {
@@ -125,17 +280,21 @@ function render(containers) {
                 : `<span class="port exposed">${esc(String(p.private_port))}</span>`
             ).join('')
             : '<span class="empty">—</span>';
+        const published = c.ports?.find(p => p.public_port)?.public_port;
+        const actions = containerActions && !c.source && c.state === 'running' && published
+            ? `<div>${['stop', 'restart'].map(a => `<button class="action" data-action="containerAction" data-port="${published}" data-verb="${a}">${t(a)}</button>`).join('')}</div>`
+            : '';
         return `<tr>
-            <td data-label="Name"><div class="name">${name}</div><div class="image">${image}</div></td>
-            <td data-label="State"><span class="state ${state}">${state}</span></td>
-            <td data-label="Ports" class="ports">${ports}</td>
+            <td data-label="${t('Name')}"><div class="name">${name}</div><div class="image">${image}</div></td>
+            <td data-label="${t('State')}"><span class="state ${state}">${state}</span>${health}${stability ? `<div class="image">${stability}</div>` : ''}${actions}</td>
+            <td data-label="${t('Ports')}" class="ports">${ports}</td>
         </tr>`;
     }).join('');
 }
 
 function addHistory(port, status, ok) {
     const history = document.getElementById('history');
-    const time = new Date().toLocaleTimeString('en-GB', { hour: '2-digit', minute: '2-digit' });
+    const time = new Date().toLocaleTimeString(lang === 'en' ? 'en-GB' : lang, { hour: '2-digit', minute: '2-digit' });
     const entry = document.createElement('div');
     entry.className = `history-entry ${ok ? 'ok' : 'err'}`;
     entry.innerHTML = `<span class="port">${esc(String(port))}</span><span class="status">${esc(status)}</span><span class="time">${time}</span>`;
@@ -148,9 +307,9 @@ async function check() {
     if (!port) return;
     try {
         const data = await api(`/api/check?port=${port}`);
-        addHistory(port, data.available ? 'available' : 'in use', data.available);
+        addHistory(port, data.available ? t('available') : t('in use'), data.available);
     } catch (e) {
-        addHistory(port, e.message || 'error', false);
+        addHistory(port, e.message || t('error'), false);
     }
 }
 
@@ -159,11 +318,73 @@ async function suggest() {
         const data = await api('/api/suggest');
         if (data.port > 0) {
             document.getElementById('port').value = data.port;
-            addHistory(data.port, 'suggested', true);
+            addHistory(data.port, t('suggested'), true);
         }
     } catch (e) {
-        addHistory('—', e.message || 'error', false);
+        addHistory('—', e.message || t('error'), false);
+    }
+}
+
+async function containerAction(port, action) {
+    const question = action === 'stop' ? 'Stop the container publishing port {0}?' : 'Restart the container publishing port {0}?';
+    if (!confirm(t(question, port))) return;
+    try {
+        const data = await api(`/api/ports/${port}/${action}`, { method: 'POST' });
+        addHistory(port, data.message, true);
+        load();
+    } catch (e) {
+        addHistory(port, e.message || t('error'), false);
+    }
+}
+
+// planner is the compose checker compiled to WebAssembly (make wasm), loaded
+// on first use. It checks against the containers already on the page, so
+// planning needs no round trip and keeps working if the server goes away.
+// null when this build doesn't ship it: the server checks instead.
+let planner;
+
+async function loadPlanner() {
+    if (planner !== undefined) return planner;
+    try {
+        await new Promise((resolve, reject) => {
+            const script = document.createElement('script');
+            script.src = 'wasm_exec.js';
+            script.onload = resolve;
+            script.onerror = reject;
+            document.head.appendChild(script);
+        });
+        const go = new Go();
+        const { instance } = await WebAssembly.instantiateStreaming(fetch('planner.wasm'), go.importObject);
+        go.run(instance);
+        planner = window.quaycheckPlan;
+    } catch (e) {
+        planner = null;
+    }
+    return planner;
+}
+
+async function planCompose() {
+    const compose = document.getElementById('compose').value;
+    if (!compose.trim()) return;
+    const el = document.getElementById('plan');
+    let conflicts;
+    try {
+        const run = await loadPlanner();
+        if (run) {
+            const result = JSON.parse(run(JSON.stringify({ compose, containers: containersData })));
+            if (result.error) throw { message: result.error };
+            conflicts = result.conflicts || [];
+        } else {
+            const data = await api('/api/simulate', { method: 'POST', body: JSON.stringify({ stacks: [{ compose }] }) });
+            conflicts = data.conflicts || [];
+        }
+    } catch (e) {
+        el.innerHTML = `<div class="history-entry err"><span class="status">${esc(e.message || t('error'))}</span></div>`;
+        return;
     }
+    el.innerHTML = conflicts.length
+        ? conflicts.map(c => `<div class="history-entry err"><span class="port">${esc(String(c.port.public_port))}</span><span class="status">${esc(c.reason)}</span><span class="time">${esc(c.code)}</span></div>`).join('')
+        : `<div class="history-entry ok"><span class="status">${t('no conflicts')}</span></div>`;
 }
 
 async function loadStats() {
@@ -179,5 +400,6 @@ async function loadStats() {
 }
 
 loadTheme();
-load();
+translatePage();
+start();
 loadStats();
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"os"
+	"slices"
+	"strings"
+)
+
+// ParseStack extracts published ports from a docker stack file. Each port
+// carries its mode, ingress unless the long syntax says host, and the
+// service's placement constraints.
+func ParseStack(data []byte) ([]ComposePort, error) {
+	return parseCompose(data, nil, true)
+}
+
+// placementAllows reports whether constraints let a task run on host. Only
+// node.hostname can be told from here; any other constraint may hold
+// anywhere. The local host also answers to this machine's hostname.
+func placementAllows(constraints []string, host string) bool {
+	names := []string{host}
+	if host == localHost {
+		if name, err := os.Hostname(); err == nil {
+			names = append(names, name)
+		}
+	}
+	for _, c := range constraints {
+		op := "=="
+		key, value, ok := strings.Cut(c, "==")
+		if !ok {
+			op = "!="
+			if key, value, ok = strings.Cut(c, "!="); !ok {
+				continue
+			}
+		}
+		if strings.TrimSpace(key) != "node.hostname" {
+			continue
+		}
+		if slices.Contains(names, strings.TrimSpace(value)) != (op == "==") {
+			return false
+		}
+	}
+	return true
+}
+
+// portHosts lists the hosts p is published on: every one for an ingress
+// port, those its placement allows for a host one, and only this machine
+// for a plain compose port
+func portHosts(p ComposePort, hosts []string) []string {
+	switch p.Mode {
+	case "ingress":
+		return hosts
+	case "host":
+		return slices.DeleteFunc(slices.Clone(hosts), func(h string) bool { return !placementAllows(p.Constraints, h) })
+	}
+	return []string{localHost}
+}
+
+// FindStackConflicts checks ports against each host's own containers. The
+// same conflict found on several hosts is reported once, naming them all.
+func FindStackConflicts(ports []ComposePort, containers []ContainerData, hosts []string) []ComposeConflict {
+	var result []ComposeConflict
+	for _, h := range hosts {
+		var onHost []ComposePort
+		for _, p := range ports {
+			if slices.Contains(portHosts(p, hosts), h) {
+				onHost = append(onHost, p)
+			}
+		}
+		live := slices.DeleteFunc(slices.Clone(containers), func(c ContainerData) bool {
+			return hostOf(c) != h && !holdsEverywhere(c)
+		})
+		for _, c := range FindComposeConflicts(onHost, live) {
+			i := slices.IndexFunc(result, func(r ComposeConflict) bool {
+				return r.Code == c.Code && r.Reason == c.Reason && r.Port.Service == c.Port.Service && r.Port.Line == c.Port.Line
+			})
+			if i < 0 {
+				result = append(result, c)
+				i = len(result) - 1
+			}
+			result[i].Hosts = append(result[i].Hosts, h)
+		}
+	}
+	for i := range result {
+		result[i].Reason += " on " + strings.Join(result[i].Hosts, ", ")
+	}
+	return result
+}
+
+// inventoryHosts names the hosts containers live on, this one first
+func inventoryHosts(containers []ContainerData) []string {
+	hosts := []string{localHost}
+	for _, c := range containers {
+		if h := hostOf(c); !slices.Contains(hosts, h) {
+			hosts = append(hosts, h)
+		}
+	}
+	return hosts
+}
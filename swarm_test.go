@@ -0,0 +1,80 @@
+package main
+
+import "testing"
+
+const stackFile = `services:
+  proxy:
+    image: traefik
+    ports:
+      - "80:80"
+  metrics:
+    image: node-exporter
+    deploy:
+      mode: global
+    ports:
+      - target: 9100
+        published: 9100
+        mode: host
+  db:
+    image: postgres
+    deploy:
+      replicas: 2
+      placement:
+        constraints: ["node.hostname == lb2", "node.role == worker"]
+    ports:
+      - target: 5432
+        published: 5432
+        mode: host
+`
+
+func TestParseStack(t *testing.T) {
+	ports, err := ParseStack([]byte(stackFile))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(ports) != 3 || ports[0].Mode != "ingress" || ports[1].Mode != "host" || ports[2].Replica != 0 || len(ports[2].Constraints) != 2 {
+		t.Fatalf("Unexpected stack ports: %+v", ports)
+	}
+	if _, err := ParseStack([]byte("services:\n  web:\n    ports:\n      - {target: 80, published: 80, mode: vip}\n")); err == nil {
+		t.Error("Expected an unknown mode to fail")
+	}
+}
+
+func TestPlacementAllows(t *testing.T) {
+	for _, tt := range []struct {
+		constraints []string
+		host        string
+		want        bool
+	}{
+		{nil, "lb1", true},
+		{[]string{"node.hostname == lb1"}, "lb1", true},
+		{[]string{"node.hostname==lb1"}, "lb2", false},
+		{[]string{"node.hostname != lb1"}, "lb1", false},
+		{[]string{"node.labels.zone == eu"}, "lb1", true},
+	} {
+		if got := placementAllows(tt.constraints, tt.host); got != tt.want {
+			t.Errorf("%v on %s: expected %v, got %v", tt.constraints, tt.host, tt.want, got)
+		}
+	}
+}
+
+func TestFindStackConflicts(t *testing.T) {
+	ports, _ := ParseStack([]byte(stackFile))
+	containers := []ContainerData{
+		{ID: "a", Names: []string{"/nginx"}, State: "running", Ports: []PortMapping{{PublicPort: 80}}},
+		{ID: "remote:lb1", Names: []string{"lb1:80"}, State: "running", Ports: []PortMapping{{PublicPort: 80}}},
+		{ID: "remote:lb1", Names: []string{"lb1:5432"}, State: "running", Ports: []PortMapping{{PublicPort: 5432}}},
+		{ID: "remote:lb2", Names: []string{"lb2:9100"}, State: "running", Ports: []PortMapping{{PublicPort: 9100}}},
+	}
+
+	conflicts := FindStackConflicts(ports, containers, []string{localHost, "lb1", "lb2"})
+	if len(conflicts) != 3 {
+		t.Fatalf("Expected the ingress port on two hosts and metrics on lb2, got %+v", conflicts)
+	}
+	if conflicts[0].Reason != "port 80 (service proxy) is already in use by nginx on local" || conflicts[1].Hosts[0] != "lb1" {
+		t.Errorf("Expected the ingress port to conflict per host, got %+v", conflicts[:2])
+	}
+	if conflicts[2].Port.Service != "metrics" || len(conflicts[2].Hosts) != 1 || conflicts[2].Hosts[0] != "lb2" {
+		t.Errorf("Expected metrics to conflict on lb2 alone, got %+v", conflicts[2])
+	}
+}
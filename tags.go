@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"quaycheck/internal/storage"
+)
+
+// PortTag is free-form knowledge attached to a host port, like
+// "8443 = old grafana, do not reuse until Q3"
+type PortTag struct {
+	Port      int       `json:"port"`
+	Tags      []string  `json:"tags,omitempty"`
+	Note      string    `json:"note,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// matches reports whether q appears in the port number, a tag or the note
+func (t PortTag) matches(q string) bool {
+	q = strings.ToLower(q)
+	if strconv.Itoa(t.Port) == q || strings.Contains(strings.ToLower(t.Note), q) {
+		return true
+	}
+	for _, tag := range t.Tags {
+		if strings.Contains(strings.ToLower(tag), q) {
+			return true
+		}
+	}
+	return false
+}
+
+// TagStore persists port tags to the tags table
+type TagStore struct {
+	db *storage.DB
+
+	mu    sync.Mutex
+	items map[int]PortTag
+}
+
+func NewTagStore(db *storage.DB) (*TagStore, error) {
+	s := &TagStore{db: db}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reload replaces the in-memory tags with the database's
+func (s *TagStore) Reload() error {
+	items := make(map[int]PortTag)
+	if s.db != nil {
+		rows, err := s.db.Query(`SELECT port, tags, note, updated_at FROM tags`)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var t PortTag
+			var tags string
+			var updated int64
+			if err := rows.Scan(&t.Port, &tags, &t.Note, &updated); err != nil {
+				return err
+			}
+			if err := json.Unmarshal([]byte(tags), &t.Tags); err != nil {
+				return err
+			}
+			t.UpdatedAt = time.Unix(0, updated).UTC()
+			items[t.Port] = t
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+	s.mu.Lock()
+	s.items = items
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *TagStore) saveLocked() error {
+	if s.db == nil {
+		return nil
+	}
+	var rows [][]any
+	for _, t := range s.listLocked() {
+		tags, _ := json.Marshal(t.Tags)
+		rows = append(rows, []any{t.Port, string(tags), t.Note, t.UpdatedAt.UnixNano()})
+	}
+	return s.db.Replace(context.Background(), "tags", []string{"port", "tags", "note", "updated_at"}, rows)
+}
+
+func (s *TagStore) listLocked() []PortTag {
+	list := make([]PortTag, 0, len(s.items))
+	for _, t := range s.items {
+		list = append(list, t)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Port < list[j].Port })
+	return list
+}
+
+func (s *TagStore) List() []PortTag {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.listLocked()
+}
+
+// Set replaces the tags and note of a port
+func (s *TagStore) Set(t PortTag) (PortTag, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t.UpdatedAt = time.Now().UTC()
+	s.items[t.Port] = t
+	return t, s.saveLocked()
+}
+
+func (s *TagStore) Delete(port int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.items[port]; !ok {
+		return false, nil
+	}
+	delete(s.items, port)
+	return true, s.saveLocked()
+}
+
+// PortsWithTag returns the ports carrying tag
+func (s *TagStore) PortsWithTag(tag string) map[int]bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ports := make(map[int]bool)
+	for port, t := range s.items {
+		if slices.Contains(t.Tags, tag) {
+			ports[port] = true
+		}
+	}
+	return ports
+}
+
+// filterByTag keeps the entries publishing at least one port tagged tag
+func filterByTag(containers []ContainerData, ports map[int]bool) []ContainerData {
+	result := []ContainerData{}
+	for _, c := range containers {
+		for _, p := range c.Ports {
+			if ports[int(p.PublicPort)] {
+				result = append(result, c)
+				break
+			}
+		}
+	}
+	return result
+}
+
+type TagRequest struct {
+	Tags []string `json:"tags"`
+	Note string   `json:"note,omitempty"`
+}
+
+// handleListTags lists tagged ports, narrowed by ?tag= (exact) and ?q=
+// (substring of the port, a tag or the note)
+func (s *Server) handleListTags(w http.ResponseWriter, r *http.Request) {
+	tag, q := r.URL.Query().Get("tag"), r.URL.Query().Get("q")
+	result := []PortTag{}
+	for _, t := range s.tags.List() {
+		if tag != "" && !slices.Contains(t.Tags, tag) {
+			continue
+		}
+		if q != "" && !t.matches(q) {
+			continue
+		}
+		result = append(result, t)
+	}
+	writeEncoded(w, r, result)
+}
+
+func (s *Server) handleSetTag(w http.ResponseWriter, r *http.Request) {
+	port, err := strconv.Atoi(r.PathValue("port"))
+	if err != nil || port < 1 || port > maxPort {
+		writeError(w, http.StatusBadRequest, "invalid_param", "Invalid port")
+		return
+	}
+	var req TagRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || (len(req.Tags) == 0 && req.Note == "") {
+		writeError(w, http.StatusBadRequest, "invalid_body", "Expected a JSON body with tags and/or a note")
+		return
+	}
+
+	t, err := s.tags.Set(PortTag{Port: port, Tags: req.Tags, Note: req.Note})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "store_error", "Cannot save tags: "+err.Error())
+		return
+	}
+	s.audit(r, "tag.set", strconv.Itoa(port), strings.Join(t.Tags, ","))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(t)
+}
+
+func (s *Server) handleDeleteTag(w http.ResponseWriter, r *http.Request) {
+	port, err := strconv.Atoi(r.PathValue("port"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_param", "Invalid port")
+		return
+	}
+	deleted, err := s.tags.Delete(port)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "store_error", "Cannot save tags: "+err.Error())
+		return
+	}
+	if !deleted {
+		writeError(w, http.StatusNotFound, "not_found", "No tags on this port")
+		return
+	}
+	s.audit(r, "tag.delete", strconv.Itoa(port), "")
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+)
+
+func TestTagStorePersists(t *testing.T) {
+	db := openTestDB(t)
+	store, _ := NewTagStore(db)
+	store.Set(PortTag{Port: 8443, Tags: []string{"legacy"}, Note: "old grafana, do not reuse until Q3"})
+
+	reloaded, err := NewTagStore(db)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	list := reloaded.List()
+	if len(list) != 1 || list[0].Port != 8443 || list[0].Note == "" {
+		t.Errorf("Unexpected tags after reload: %+v", list)
+	}
+}
+
+func TestTagsAPI(t *testing.T) {
+	tags, _ := NewTagStore(nil)
+	server := &Server{
+		client: &MockDockerClient{Containers: []types.Container{
+			{ID: "1", Names: []string{"/grafana-old"}, State: "running", Ports: []types.Port{{PublicPort: 8443}}},
+			{ID: "2", Names: []string{"/web"}, State: "running", Ports: []types.Port{{PublicPort: 8080}}},
+		}},
+		tags: tags,
+	}
+	mux := SetupRouter(server)
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("PUT", "/api/tags/8443", strings.NewReader(`{"tags":["legacy","grafana"],"note":"do not reuse until Q3"}`)))
+	if w.Code != 200 {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	searches := []struct {
+		query string
+		count int
+	}{
+		{"", 1},
+		{"?tag=legacy", 1},
+		{"?tag=leg", 0},
+		{"?q=q3", 1},
+		{"?q=GRAF", 1},
+		{"?q=8443", 1},
+		{"?q=nginx", 0},
+	}
+	for _, tt := range searches {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", "/api/tags"+tt.query, nil))
+		var list []PortTag
+		json.NewDecoder(w.Body).Decode(&list)
+		if len(list) != tt.count {
+			t.Errorf("/api/tags%s: expected %d results, got %d", tt.query, tt.count, len(list))
+		}
+	}
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/api/ports?tag=legacy", nil))
+	var containers []ContainerData
+	json.NewDecoder(w.Body).Decode(&containers)
+	if len(containers) != 1 || containers[0].ID != "1" {
+		t.Errorf("Expected only the tagged container, got %+v", containers)
+	}
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("DELETE", "/api/tags/8443", nil))
+	if w.Code != 204 || len(tags.List()) != 0 {
+		t.Errorf("Expected the tag to be removed, got %d", w.Code)
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// StackTemplate is a reusable set of ports a stack needs, e.g. an
+// observability stack wanting grafana near 3000 and prometheus near 9090
+type StackTemplate struct {
+	Description string         `json:"description,omitempty"`
+	Items       []TemplateItem `json:"items"`
+}
+
+// TemplateItem is a batch item plus the environment variable its port is
+// written to
+type TemplateItem struct {
+	BatchItem
+	// Env defaults to the item name in upper case with a _PORT suffix
+	Env string `json:"env,omitempty"`
+}
+
+var envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// envName is the variable an item's port goes to
+func (it TemplateItem) envName() string {
+	if it.Env != "" {
+		return it.Env
+	}
+	return portVarName(it.Name)
+}
+
+// portVarName turns a name into NAME_PORT, with anything but letters and
+// digits replaced by underscores
+func portVarName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, name)
+	return strings.ToUpper(name) + "_PORT"
+}
+
+// validateTemplates checks templates the way a batch request is checked,
+// plus the env names, which must be valid and distinct
+func validateTemplates(templates map[string]StackTemplate, presets map[string]PortRange) error {
+	for name, t := range templates {
+		if name == "" || strings.Contains(name, "/") {
+			return fmt.Errorf("invalid template name %q", name)
+		}
+		req := BatchRequest{}
+		envs := make(map[string]bool, len(t.Items))
+		for _, it := range t.Items {
+			req.Items = append(req.Items, it.BatchItem)
+			env := it.envName()
+			if !envNamePattern.MatchString(env) {
+				return fmt.Errorf("template %s: invalid env name %q", name, env)
+			}
+			if envs[env] {
+				return fmt.Errorf("template %s: env %s is used twice", name, env)
+			}
+			envs[env] = true
+		}
+		if err := req.validate(presets); err != nil {
+			return fmt.Errorf("template %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+type TemplateInfo struct {
+	Name string `json:"name"`
+	StackTemplate
+}
+
+func (s *Server) handleListTemplates(w http.ResponseWriter, r *http.Request) {
+	templates := s.config().Templates
+	list := make([]TemplateInfo, 0, len(templates))
+	for name, t := range templates {
+		list = append(list, TemplateInfo{Name: name, StackTemplate: t})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// InstantiateRequest names one deployment of a template. Reservations are
+// named <name>-<item>, so the same template can be instantiated many times.
+type InstantiateRequest struct {
+	Name  string `json:"name"`
+	Owner string `json:"owner,omitempty"`
+	// Reserve defaults to true; false only plans
+	Reserve *bool `json:"reserve,omitempty"`
+}
+
+type InstantiateResponse struct {
+	Template    string            `json:"template"`
+	Allocations []BatchAllocation `json:"allocations"`
+	Reserved    bool              `json:"reserved"`
+	// Env maps each item's variable to its port
+	Env  map[string]int `json:"env"`
+	Meta *ResponseMeta  `json:"meta,omitempty"`
+}
+
+// dotenv writes env as KEY=value lines in template order
+func dotenv(items []TemplateItem, allocs []BatchAllocation) string {
+	var b strings.Builder
+	for i, it := range items {
+		fmt.Fprintf(&b, "%s=%d\n", it.envName(), allocs[i].Port)
+	}
+	return b.String()
+}
+
+// handleInstantiateTemplate allocates a template's ports as one batch, all
+// or nothing. ?format=env answers with a .env file instead of JSON.
+func (s *Server) handleInstantiateTemplate(w http.ResponseWriter, r *http.Request) {
+	cfg := s.config()
+	name := r.PathValue("name")
+	t, ok := cfg.Templates[name]
+	if !ok {
+		writeError(w, http.StatusNotFound, "not_found", localize(w, "Unknown template %q", name))
+		return
+	}
+	var req InstantiateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Name == "" {
+		writeError(w, http.StatusBadRequest, "invalid_body", "Expected a JSON body with at least a name")
+		return
+	}
+	reserve := req.Reserve == nil || *req.Reserve
+	if reserve && s.reservations == nil {
+		writeError(w, http.StatusNotImplemented, "reservations_disabled", "Reservations are not enabled on this server")
+		return
+	}
+	if reserve && s.readOnly.Load() {
+		writeError(w, http.StatusServiceUnavailable, "read_only", "State is being restored; try again shortly")
+		return
+	}
+
+	batch := BatchRequest{Owner: req.Owner}
+	for _, it := range t.Items {
+		item := it.BatchItem
+		item.Name = req.Name + "-" + it.Name
+		batch.Items = append(batch.Items, item)
+	}
+	if err := batch.validate(cfg.Presets); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_body", err.Error())
+		return
+	}
+
+	load := s.loadSnapshot
+	if reserve {
+		load = s.collectScoped
+	}
+	snap, err := load(r.Context())
+	if err != nil {
+		status, code, msg := classifyDockerError(err)
+		writeError(w, status, code, msg)
+		return
+	}
+	var allocs []BatchAllocation
+	ix := s.pickable(snap.Index)
+	if reserve {
+		allocs, err = s.reservations.ReserveBatch(batch.Items, ix, req.Owner, environmentFrom(r.Context()), s.checkFrozen)
+	} else if allocs, err = planBatch(ix.Clone(), batch.Items); err == nil {
+		err = checkAllocations(allocs, s.checkFrozen)
+	}
+	if err != nil {
+		writeBatchError(w, err)
+		return
+	}
+
+	if reserve {
+		for _, a := range allocs {
+			s.audit(r, "reservation.create", a.Name, strconv.Itoa(a.Port))
+		}
+	}
+	if r.URL.Query().Get("format") == "env" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprint(w, dotenv(t.Items, allocs))
+		return
+	}
+	env := make(map[string]int, len(allocs))
+	for i, it := range t.Items {
+		env[it.envName()] = allocs[i].Port
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(InstantiateResponse{Template: name, Allocations: allocs, Reserved: reserve, Env: env, Meta: s.snapshotMeta(snap)})
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+)
+
+func TestValidateTemplates(t *testing.T) {
+	presets := map[string]PortRange{"web": {Start: 8000, End: 8999}}
+	ok := map[string]StackTemplate{"obs": {Items: []TemplateItem{
+		{BatchItem: BatchItem{Name: "grafana", Port: 3000, Start: 3000, End: 3099}},
+		{BatchItem: BatchItem{Name: "ui", Preset: "web"}, Env: "UI_HTTP"},
+	}}}
+	if err := validateTemplates(ok, presets); err != nil {
+		t.Errorf("Expected a valid template, got %v", err)
+	}
+	for name, bad := range map[string]StackTemplate{
+		"duplicate env":  {Items: []TemplateItem{{BatchItem: BatchItem{Name: "a"}, Env: "X"}, {BatchItem: BatchItem{Name: "b"}, Env: "X"}}},
+		"bad env":        {Items: []TemplateItem{{BatchItem: BatchItem{Name: "a"}, Env: "1X"}}},
+		"unknown preset": {Items: []TemplateItem{{BatchItem: BatchItem{Name: "a", Preset: "db"}}}},
+		"empty":          {},
+	} {
+		if err := validateTemplates(map[string]StackTemplate{"t": bad}, presets); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+	if got := (TemplateItem{BatchItem: BatchItem{Name: "alert-manager"}}).envName(); got != "ALERT_MANAGER_PORT" {
+		t.Errorf("Expected ALERT_MANAGER_PORT, got %s", got)
+	}
+}
+
+func TestInstantiateTemplate(t *testing.T) {
+	store, _ := NewReservationStore(nil)
+	settings, _ := NewSettingsStore(nil, Settings{})
+	server := &Server{
+		client:       &MockDockerClient{Containers: []types.Container{{State: "running", Ports: []types.Port{{PublicPort: 9090}}}}},
+		reservations: store,
+		settings:     settings,
+	}
+	_, err := settings.Update(map[string]json.RawMessage{"templates": json.RawMessage(`{"observability": {"items": [
+		{"name": "grafana", "port": 3000, "start": 3000, "end": 3099},
+		{"name": "prometheus", "port": 9090, "start": 9090, "end": 9099},
+		{"name": "alertmanager", "port": 9093, "start": 9090, "end": 9099, "env": "AM_PORT"}
+	]}}`)})
+	if err != nil {
+		t.Fatalf("Expected the templates to save, got %v", err)
+	}
+	mux := SetupRouter(server)
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/api/templates", nil))
+	var list []TemplateInfo
+	json.NewDecoder(w.Body).Decode(&list)
+	if len(list) != 1 || list[0].Name != "observability" || len(list[0].Items) != 3 {
+		t.Fatalf("Expected the template listed, got %+v", list)
+	}
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("POST", "/api/templates/observability/instantiate", strings.NewReader(`{"name":"staging","owner":"ci"}`)))
+	var resp InstantiateResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	if w.Code != http.StatusOK || !resp.Reserved || resp.Env["GRAFANA_PORT"] != 3000 || resp.Env["PROMETHEUS_PORT"] != 9091 || resp.Env["AM_PORT"] != 9093 {
+		t.Fatalf("Unexpected response %d %+v", w.Code, resp)
+	}
+	if r, ok := store.Get("", "staging-prometheus"); !ok || r.Port != 9091 || r.Owner != "ci" {
+		t.Errorf("Expected staging-prometheus reserved, got %+v", r)
+	}
+
+	// A second instance gets its own ports, and a dry run as .env saves nothing
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("POST", "/api/templates/observability/instantiate?format=env", strings.NewReader(`{"name":"prod","reserve":false}`)))
+	if want := "GRAFANA_PORT=3001\nPROMETHEUS_PORT=9092\nAM_PORT=9094\n"; w.Body.String() != want {
+		t.Errorf("Expected %q, got %q", want, w.Body.String())
+	}
+	if _, ok := store.Get("", "prod-grafana"); ok {
+		t.Error("Expected a dry run not to reserve")
+	}
+
+	for path, want := range map[string]int{
+		"/api/templates/observability/instantiate": http.StatusConflict,
+		"/api/templates/nope/instantiate":          http.StatusNotFound,
+	} {
+		w = httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("POST", path, strings.NewReader(`{"name":"staging"}`)))
+		if w.Code != want {
+			t.Errorf("%s: expected %d, got %d", path, want, w.Code)
+		}
+	}
+}
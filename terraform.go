@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// The Terraform external data source protocol passes a flat JSON object of
+// strings on stdin and expects a flat JSON object of strings back
+
+func terraformStart(query map[string]string) (int, error) {
+	start := 8000
+	if s, ok := query["start"]; ok && s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return 0, fmt.Errorf("invalid start %q", s)
+		}
+		start = n
+	}
+	if start < 1024 {
+		start = 1024
+	}
+	return start, nil
+}
+
+func terraformResult(port int) (map[string]string, error) {
+	if port == -1 {
+		return nil, fmt.Errorf("no free ports found in range")
+	}
+	return map[string]string{"port": strconv.Itoa(port)}, nil
+}
+
+func (s *Server) handleTerraformSuggest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Use POST with a JSON object of strings")
+		return
+	}
+	query := map[string]string{}
+	if err := json.NewDecoder(r.Body).Decode(&query); err != nil && err != io.EOF {
+		writeError(w, http.StatusBadRequest, "invalid_body", "Expected a JSON object of strings")
+		return
+	}
+	start, err := terraformStart(query)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_param", err.Error())
+		return
+	}
+
+	snap, err := s.loadSnapshot(r.Context())
+	if err != nil {
+		status, code, msg := classifyDockerError(err)
+		writeError(w, status, code, msg)
+		return
+	}
+
+	port := s.pickable(snap.Index).NextFree(start)
+	result, err := terraformResult(port)
+	if err != nil {
+		writeError(w, http.StatusConflict, "no_free_port", err.Error())
+		return
+	}
+	if s.writeIfFrozen(w, port) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// runSuggest implements `quaycheck suggest`. With -terraform it speaks the
+// external data source protocol so it can be used as a `program` directly.
+func runSuggest(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("suggest", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	start := fs.Int("start", 8000, "first port to consider")
+	server := fs.String("server", os.Getenv("QUAYCHECK_SERVER"), "quaycheck URL to query instead of the local Docker daemon")
+	terraform := fs.Bool("terraform", false, "read a Terraform external data source query on stdin")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	query := map[string]string{"start": strconv.Itoa(*start)}
+	if *terraform {
+		if err := json.NewDecoder(stdin).Decode(&query); err != nil && err != io.EOF {
+			fmt.Fprintf(stderr, "quaycheck: invalid query on stdin: %v\n", err)
+			return 1
+		}
+	}
+	from, err := terraformStart(query)
+	if err != nil {
+		fmt.Fprintf(stderr, "quaycheck: %v\n", err)
+		return 1
+	}
+
+	ctx, cancel := cliContext()
+	defer cancel()
+	containers, err := fetchContainers(ctx, *server)
+	if err != nil {
+		fmt.Fprintf(stderr, "quaycheck: cannot list containers: %v\n", err)
+		return 1
+	}
+
+	port := buildPortIndex(containers).NextFree(from)
+	if !*terraform {
+		if port == -1 {
+			fmt.Fprintln(stderr, "quaycheck: no free ports found in range")
+			return 1
+		}
+		fmt.Fprintln(stdout, port)
+		return 0
+	}
+
+	result, err := terraformResult(port)
+	if err != nil {
+		fmt.Fprintf(stderr, "quaycheck: %v\n", err)
+		return 1
+	}
+	json.NewEncoder(stdout).Encode(result)
+	return 0
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+)
+
+func TestHandleTerraformSuggest(t *testing.T) {
+	server := &Server{client: &MockDockerClient{Containers: []types.Container{
+		{State: "running", Ports: []types.Port{{PublicPort: 9000}}},
+	}}}
+
+	req := httptest.NewRequest("POST", "/api/terraform/suggest", strings.NewReader(`{"start":"9000"}`))
+	w := httptest.NewRecorder()
+	server.handleTerraformSuggest(w, req)
+
+	var result map[string]string
+	json.NewDecoder(w.Body).Decode(&result)
+	if w.Code != http.StatusOK || result["port"] != "9001" {
+		t.Errorf("Expected port 9001, got %d %v", w.Code, result)
+	}
+
+	req = httptest.NewRequest("POST", "/api/terraform/suggest", strings.NewReader(`{"start":"abc"}`))
+	w = httptest.NewRecorder()
+	server.handleTerraformSuggest(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 on invalid start, got %d", w.Code)
+	}
+}
+
+func TestRunSuggestTerraform(t *testing.T) {
+	agent := &Server{client: &MockDockerClient{Containers: []types.Container{
+		{State: "running", Ports: []types.Port{{PublicPort: 8000}}},
+	}}}
+	ts := httptest.NewServer(SetupRouter(agent))
+	defer ts.Close()
+
+	var stdout, stderr bytes.Buffer
+	code := runSuggest([]string{"-server", ts.URL, "-terraform"}, strings.NewReader(`{"start":"8000"}`), &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("Expected exit code 0, got %d (stderr: %s)", code, stderr.String())
+	}
+	if strings.TrimSpace(stdout.String()) != `{"port":"8001"}` {
+		t.Errorf("Unexpected output: %s", stdout.String())
+	}
+
+	stdout.Reset()
+	runSuggest([]string{"-server", ts.URL, "-start", "8000"}, nil, &stdout, &stderr)
+	if strings.TrimSpace(stdout.String()) != "8001" {
+		t.Errorf("Expected plain port output, got %s", stdout.String())
+	}
+}
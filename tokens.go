@@ -0,0 +1,359 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"maps"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"quaycheck/internal/storage"
+)
+
+// StoredToken is an API token managed through /api/admin/tokens. Only a
+// hash of its secret is kept; the secret itself is shown once, when the
+// token is created or rotated.
+type StoredToken struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Scopes     []Scope    `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	RotatedAt  *time.Time `json:"rotated_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+
+	hash string
+	// saved is when LastUsedAt was last written to the database
+	saved time.Time
+}
+
+// lastUsedEvery bounds how often a token's last use is written, so busy
+// clients don't turn every request into a database write
+const lastUsedEvery = time.Minute
+
+var errLastAdmin = errors.New("an admin token must remain")
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func newSecret() string {
+	b := make([]byte, 24)
+	rand.Read(b)
+	return "qc_" + hex.EncodeToString(b)
+}
+
+// TokenStore persists API tokens to the tokens table
+type TokenStore struct {
+	db *storage.DB
+
+	mu     sync.Mutex
+	items  map[string]StoredToken
+	nextID int
+}
+
+func NewTokenStore(db *storage.DB) (*TokenStore, error) {
+	s := &TokenStore{db: db}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reload replaces the in-memory tokens with the database's
+func (s *TokenStore) Reload() error {
+	items := make(map[string]StoredToken)
+	nextID := 0
+	if s.db != nil {
+		rows, err := s.db.Query(`SELECT id, name, hash, scopes, created_at, rotated_at, last_used_at FROM tokens`)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var t StoredToken
+			var scopes string
+			var created int64
+			var rotated, used sql.NullInt64
+			if err := rows.Scan(&t.ID, &t.Name, &t.hash, &scopes, &created, &rotated, &used); err != nil {
+				return err
+			}
+			for _, scope := range strings.Split(scopes, ",") {
+				t.Scopes = append(t.Scopes, Scope(scope))
+			}
+			t.CreatedAt = time.Unix(0, created).UTC()
+			if rotated.Valid {
+				at := time.Unix(0, rotated.Int64).UTC()
+				t.RotatedAt = &at
+			}
+			if used.Valid {
+				at := time.Unix(0, used.Int64).UTC()
+				t.LastUsedAt, t.saved = &at, at
+			}
+			items[t.ID] = t
+			if n, err := strconv.Atoi(t.ID); err == nil && n > nextID {
+				nextID = n
+			}
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+	s.mu.Lock()
+	s.items, s.nextID = items, nextID
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *TokenStore) saveLocked() error {
+	if s.db == nil {
+		return nil
+	}
+	var rows [][]any
+	for _, t := range s.listLocked() {
+		var rotated, used any
+		if t.RotatedAt != nil {
+			rotated = t.RotatedAt.UnixNano()
+		}
+		if t.LastUsedAt != nil {
+			used = t.LastUsedAt.UnixNano()
+		}
+		scopes := make([]string, len(t.Scopes))
+		for i, scope := range t.Scopes {
+			scopes[i] = string(scope)
+		}
+		rows = append(rows, []any{t.ID, t.Name, t.hash, strings.Join(scopes, ","), t.CreatedAt.UnixNano(), rotated, used})
+	}
+	return s.db.Replace(context.Background(), "tokens", []string{"id", "name", "hash", "scopes", "created_at", "rotated_at", "last_used_at"}, rows)
+}
+
+func (s *TokenStore) listLocked() []StoredToken {
+	list := make([]StoredToken, 0, len(s.items))
+	for _, t := range s.items {
+		list = append(list, t)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+	return list
+}
+
+func (s *TokenStore) List() []StoredToken {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.listLocked()
+}
+
+func (s *TokenStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.items)
+}
+
+// HasAdmin reports whether a stored token grants admin
+func (s *TokenStore) HasAdmin() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, t := range s.items {
+		if slices.Contains(t.Scopes, ScopeAdmin) {
+			return true
+		}
+	}
+	return false
+}
+
+// keepsAdmin reports whether items, once changed, still leave a way
+// in to manage them: an admin token, here or elsewhere
+func keepsAdmin(items map[string]StoredToken, adminElsewhere bool) bool {
+	if adminElsewhere {
+		return true
+	}
+	for _, t := range items {
+		if slices.Contains(t.Scopes, ScopeAdmin) {
+			return true
+		}
+	}
+	return false
+}
+
+// Create adds a token and returns it with its secret. adminElsewhere says
+// an admin token exists outside the store, e.g. QUAYCHECK_ADMIN_TOKEN.
+func (s *TokenStore) Create(name string, scopes []Scope, adminElsewhere bool) (StoredToken, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	secret := newSecret()
+	s.nextID++
+	t := StoredToken{ID: strconv.Itoa(s.nextID), Name: name, Scopes: scopes, CreatedAt: time.Now().UTC(), hash: hashSecret(secret)}
+
+	items := maps.Clone(s.items)
+	items[t.ID] = t
+	if !keepsAdmin(items, adminElsewhere) {
+		s.nextID--
+		return StoredToken{}, "", errLastAdmin
+	}
+	s.items[t.ID] = t
+	if err := s.saveLocked(); err != nil {
+		delete(s.items, t.ID)
+		return StoredToken{}, "", err
+	}
+	return t, secret, nil
+}
+
+// Rotate replaces a token's secret, invalidating the old one at once
+func (s *TokenStore) Rotate(id string) (StoredToken, string, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, ok := s.items[id]
+	if !ok {
+		return StoredToken{}, "", false, nil
+	}
+	secret := newSecret()
+	now := time.Now().UTC()
+	t := old
+	t.hash, t.RotatedAt = hashSecret(secret), &now
+	s.items[id] = t
+	if err := s.saveLocked(); err != nil {
+		s.items[id] = old
+		return StoredToken{}, "", true, err
+	}
+	return t, secret, true, nil
+}
+
+// Revoke deletes a token, refusing to remove the last way to manage the rest
+func (s *TokenStore) Revoke(id string, adminElsewhere bool) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, ok := s.items[id]
+	if !ok {
+		return false, nil
+	}
+	delete(s.items, id)
+	if !keepsAdmin(s.items, adminElsewhere) {
+		s.items[id] = old
+		return true, errLastAdmin
+	}
+	if err := s.saveLocked(); err != nil {
+		s.items[id] = old
+		return true, err
+	}
+	return true, nil
+}
+
+// Authenticate finds the token with this secret and records its use
+func (s *TokenStore) Authenticate(secret string) (APIToken, bool) {
+	if secret == "" {
+		return APIToken{}, false
+	}
+	hash := hashSecret(secret)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, t := range s.items {
+		if t.hash != hash {
+			continue
+		}
+		now := time.Now().UTC()
+		t.LastUsedAt = &now
+		if s.db != nil && now.Sub(t.saved) >= lastUsedEvery {
+			t.saved = now
+			if _, err := s.db.Exec(`UPDATE tokens SET last_used_at = ? WHERE id = ?`, now.UnixNano(), id); err != nil {
+				log.Printf("Cannot record use of token %s: %v", t.Name, err)
+			}
+		}
+		s.items[id] = t
+		return APIToken{Name: t.Name, Scopes: t.Scopes}, true
+	}
+	return APIToken{}, false
+}
+
+// adminElsewhere reports whether an admin token is configured in the env
+func (s *Server) adminElsewhere() bool {
+	return slices.ContainsFunc(s.envTokens(), func(t APIToken) bool { return t.allows(ScopeAdmin) })
+}
+
+// adminConfigured reports whether any admin token exists, in the env or
+// created through the API
+func (s *Server) adminConfigured() bool {
+	return s.adminElsewhere() || (s.apiTokens != nil && s.apiTokens.HasAdmin())
+}
+
+type TokenRequest struct {
+	Name   string  `json:"name"`
+	Scopes []Scope `json:"scopes"`
+}
+
+// TokenResponse carries the secret, which is never shown again
+type TokenResponse struct {
+	StoredToken
+	Secret string `json:"secret"`
+}
+
+func (s *Server) handleListTokens(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.apiTokens.List())
+}
+
+func (s *Server) handleCreateToken(w http.ResponseWriter, r *http.Request) {
+	var req TokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.Name) == "" || len(req.Scopes) == 0 {
+		writeError(w, http.StatusBadRequest, "invalid_body", "Expected a JSON body with a name and scopes")
+		return
+	}
+	for _, scope := range req.Scopes {
+		if !slices.Contains(allScopes, scope) {
+			writeError(w, http.StatusBadRequest, "invalid_body", localize(w, "Unknown scope %q", scope))
+			return
+		}
+	}
+	t, secret, err := s.apiTokens.Create(strings.TrimSpace(req.Name), req.Scopes, s.adminElsewhere())
+	if errors.Is(err, errLastAdmin) {
+		writeError(w, http.StatusConflict, "admin_token_required", "Create an admin token first, or the admin endpoints would lock you out")
+		return
+	} else if err != nil {
+		writeError(w, http.StatusInternalServerError, "store_error", "Cannot save tokens: "+err.Error())
+		return
+	}
+	s.audit(r, "token.create", t.ID, t.Name)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(TokenResponse{StoredToken: t, Secret: secret})
+}
+
+func (s *Server) handleRotateToken(w http.ResponseWriter, r *http.Request) {
+	t, secret, found, err := s.apiTokens.Rotate(r.PathValue("id"))
+	if !found {
+		writeError(w, http.StatusNotFound, "not_found", "No token with this id")
+		return
+	} else if err != nil {
+		writeError(w, http.StatusInternalServerError, "store_error", "Cannot save tokens: "+err.Error())
+		return
+	}
+	s.audit(r, "token.rotate", t.ID, t.Name)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(TokenResponse{StoredToken: t, Secret: secret})
+}
+
+func (s *Server) handleRevokeToken(w http.ResponseWriter, r *http.Request) {
+	found, err := s.apiTokens.Revoke(r.PathValue("id"), s.adminElsewhere())
+	switch {
+	case !found:
+		writeError(w, http.StatusNotFound, "not_found", "No token with this id")
+		return
+	case errors.Is(err, errLastAdmin):
+		writeError(w, http.StatusConflict, "admin_token_required", "Create another admin token first, or the admin endpoints would lock you out")
+		return
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, "store_error", "Cannot save tokens: "+err.Error())
+		return
+	}
+	s.audit(r, "token.revoke", r.PathValue("id"), "")
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTokenStore(t *testing.T) {
+	db := openTestDB(t)
+	store, _ := NewTokenStore(db)
+
+	if _, _, err := store.Create("dashboard", []Scope{ScopePortsRead}, false); err != errLastAdmin {
+		t.Errorf("Expected a first non-admin token to be refused, got %v", err)
+	}
+	admin, adminSecret, err := store.Create("ops", []Scope{ScopeAdmin}, false)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	dash, secret, _ := store.Create("dashboard", []Scope{ScopePortsRead}, false)
+
+	reloaded, _ := NewTokenStore(db)
+	if tok, ok := reloaded.Authenticate(secret); !ok || tok.Name != "dashboard" || tok.allows(ScopeCheck) {
+		t.Errorf("Expected the secret to authenticate after reload, got %+v %v", tok, ok)
+	}
+	if list := reloaded.List(); list[1].LastUsedAt == nil {
+		t.Error("Expected last use to be tracked")
+	}
+	if _, ok := reloaded.Authenticate(admin.hash); ok {
+		t.Error("Expected the stored hash not to work as a secret")
+	}
+
+	_, rotated, _, _ := reloaded.Rotate(dash.ID)
+	if _, ok := reloaded.Authenticate(secret); ok {
+		t.Error("Expected the old secret to stop working after rotation")
+	}
+	if _, ok := reloaded.Authenticate(rotated); !ok {
+		t.Error("Expected the new secret to work")
+	}
+
+	if _, err := reloaded.Revoke(admin.ID, false); err != errLastAdmin {
+		t.Errorf("Expected revoking the last admin token to be refused, got %v", err)
+	}
+	reloaded.Revoke(dash.ID, false)
+	if _, err := reloaded.Revoke(admin.ID, false); err != errLastAdmin {
+		t.Errorf("Expected the last token to stay while no other admin token exists, got %v", err)
+	}
+	if found, err := reloaded.Revoke(admin.ID, true); !found || err != nil {
+		t.Errorf("Expected the last token to be revocable with QUAYCHECK_ADMIN_TOKEN set, got %v %v", found, err)
+	}
+	if _, ok := reloaded.Authenticate(adminSecret); ok {
+		t.Error("Expected a revoked token to stop working")
+	}
+}
+
+func TestTokenEndpoints(t *testing.T) {
+	tokens, _ := NewTokenStore(nil)
+	mux := SetupRouter(&Server{client: &MockDockerClient{}, apiTokens: tokens})
+
+	do := func(method, path, body, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := do("GET", "/api/ports", "", ""); w.Code != 200 {
+		t.Fatalf("Expected an open API without tokens, got %d", w.Code)
+	}
+	if w := do("POST", "/api/admin/tokens", `{"name":"ops","scopes":["admin"]}`, ""); w.Code != 401 || !strings.Contains(w.Body.String(), "admin_not_configured") {
+		t.Fatalf("Expected anyone to be refused the first admin token, got %d: %s", w.Code, w.Body.String())
+	}
+
+	t.Setenv("QUAYCHECK_ADMIN_TOKEN", testAdminToken)
+	w := do("POST", "/api/admin/tokens", `{"name":"ops","scopes":["admin"]}`, testAdminToken)
+	if w.Code != 201 {
+		t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var created TokenResponse
+	json.NewDecoder(w.Body).Decode(&created)
+	if !strings.HasPrefix(created.Secret, "qc_") {
+		t.Errorf("Expected a secret, got %+v", created)
+	}
+
+	if w := do("GET", "/api/ports", "", ""); w.Code != 401 {
+		t.Errorf("Expected tokens to be required once one exists, got %d", w.Code)
+	}
+	if w := do("POST", "/api/admin/tokens", `{"name":"ci","scopes":["root"]}`, created.Secret); w.Code != 400 {
+		t.Errorf("Expected an unknown scope to be rejected, got %d", w.Code)
+	}
+	w = do("GET", "/api/admin/tokens", "", created.Secret)
+	if w.Code != 200 || strings.Contains(w.Body.String(), created.Secret) || strings.Contains(w.Body.String(), "hash") {
+		t.Errorf("Expected the list without secrets, got %d %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+)
+
+// TopologyResponse is the port flow map as a plain node and edge list, the
+// shape graph libraries such as Cytoscape and D3 take as is. It feeds the
+// UI's upcoming map and may still change until that ships.
+type TopologyResponse struct {
+	Nodes []TopologyNode `json:"nodes"`
+	Edges []TopologyEdge `json:"edges"`
+	Meta  *ResponseMeta  `json:"meta,omitempty"`
+}
+
+// TopologyNode is a host interface, a container (or any inventory entry)
+// or a Docker network. Publications bound to an address no interface has
+// start from a single "host" node instead.
+type TopologyNode struct {
+	ID    string `json:"id"`
+	Kind  string `json:"kind"` // interface, container, network, host
+	Label string `json:"label"`
+
+	// Interfaces only
+	VPN       bool            `json:"vpn,omitempty"`
+	Addresses []AddressStatus `json:"addresses,omitempty"`
+
+	// Containers only
+	Image   string `json:"image,omitempty"`
+	State   string `json:"state,omitempty"`
+	Source  string `json:"source,omitempty"`
+	Project string `json:"project,omitempty"`
+}
+
+// TopologyEdge is a publication, traffic to a host port on an interface
+// reaching a container, or an attachment of a container to a network
+type TopologyEdge struct {
+	ID     string `json:"id"`
+	Kind   string `json:"kind"` // publication, attachment
+	Source string `json:"source"`
+	Target string `json:"target"`
+
+	// Publications only. Addresses are the interface's addresses the port
+	// is bound on, IPv4 and IPv6 copies of a binding folded into one edge.
+	HostPort      int      `json:"host_port,omitempty"`
+	ContainerPort int      `json:"container_port,omitempty"`
+	Protocol      string   `json:"protocol,omitempty"`
+	Addresses     []string `json:"addresses,omitempty"`
+}
+
+// buildTopologyMap links every entry holds counts to the interfaces its
+// ports are bound on and the networks it is attached to. Ids are derived
+// from names, so they stay the same from one call to the next and a UI
+// can animate changes.
+func buildTopologyMap(containers []ContainerData, ifaces []InterfaceInfo, holds func(ContainerData) bool) TopologyResponse {
+	resp := TopologyResponse{Nodes: []TopologyNode{}, Edges: []TopologyEdge{}}
+	for _, iface := range ifaces {
+		resp.Nodes = append(resp.Nodes, TopologyNode{
+			ID:        "interface:" + iface.Name,
+			Kind:      "interface",
+			Label:     iface.Name,
+			VPN:       iface.VPN,
+			Addresses: iface.Addresses,
+		})
+	}
+
+	seen := make(map[string]int) // node or edge id to its index
+	hostNode := false
+	for _, c := range containers {
+		if !holds(c) {
+			continue
+		}
+		key := c.ID
+		if key == "" {
+			key = containerName(c)
+		}
+		id := "container:" + key
+		if _, ok := seen[id]; ok {
+			continue
+		}
+		seen[id] = len(resp.Nodes)
+		resp.Nodes = append(resp.Nodes, TopologyNode{
+			ID:      id,
+			Kind:    "container",
+			Label:   containerName(c),
+			Image:   c.Image,
+			State:   c.State,
+			Source:  c.Source,
+			Project: c.Project,
+		})
+
+		for _, p := range c.Ports {
+			if p.PublicPort == 0 {
+				continue
+			}
+			publish := func(from, addr string) {
+				edgeID := fmt.Sprintf("publication:%s:%d/%s:%s", from, p.PublicPort, p.Type, key)
+				i, ok := seen[edgeID]
+				if !ok {
+					i = len(resp.Edges)
+					seen[edgeID] = i
+					resp.Edges = append(resp.Edges, TopologyEdge{
+						ID:            edgeID,
+						Kind:          "publication",
+						Source:        from,
+						Target:        id,
+						HostPort:      int(p.PublicPort),
+						ContainerPort: int(p.PrivatePort),
+						Protocol:      p.Type,
+					})
+				}
+				if addr != "" && !slices.Contains(resp.Edges[i].Addresses, addr) {
+					resp.Edges[i].Addresses = append(resp.Edges[i].Addresses, addr)
+				}
+			}
+			bound := false
+			for _, iface := range ifaces {
+				for _, a := range iface.Addresses {
+					if bindCovers(p.IP, a.IP) {
+						publish("interface:"+iface.Name, a.IP)
+						bound = true
+					}
+				}
+			}
+			if !bound {
+				publish("host", p.IP)
+				hostNode = true
+			}
+		}
+
+		for _, n := range c.Networks {
+			netID := "network:" + n
+			if _, ok := seen[netID]; !ok {
+				seen[netID] = len(resp.Nodes)
+				resp.Nodes = append(resp.Nodes, TopologyNode{ID: netID, Kind: "network", Label: n})
+			}
+			resp.Edges = append(resp.Edges, TopologyEdge{
+				ID:     "attachment:" + key + ":" + n,
+				Kind:   "attachment",
+				Source: id,
+				Target: netID,
+			})
+		}
+	}
+	if hostNode {
+		resp.Nodes = append(resp.Nodes, TopologyNode{ID: "host", Kind: "host", Label: "host"})
+	}
+
+	sort.SliceStable(resp.Nodes, func(i, j int) bool { return resp.Nodes[i].ID < resp.Nodes[j].ID })
+	sort.SliceStable(resp.Edges, func(i, j int) bool { return resp.Edges[i].ID < resp.Edges[j].ID })
+	return resp
+}
+
+// handleTopology serves the port flow map. Like /api/ports it takes
+// include_created, ignore_container and ignore_project.
+func (s *Server) handleTopology(w http.ResponseWriter, r *http.Request) {
+	usage, err := s.usageFromRequest(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_param", err.Error())
+		return
+	}
+	ifaces, err := hostInterfaces()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "interfaces_error", "Cannot list host interfaces: "+err.Error())
+		return
+	}
+	classifyInterfaces(ifaces)
+	snap, err := s.loadSnapshot(r.Context())
+	if err != nil {
+		status, code, msg := classifyDockerError(err)
+		writeError(w, status, code, msg)
+		return
+	}
+
+	resp := buildTopologyMap(snap.Containers, ifaces, usage.holds)
+	resp.Meta = s.snapshotMeta(snap)
+
+	s.writeSnapshotHeaders(w, snap)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
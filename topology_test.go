@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/network"
+)
+
+func TestTopology(t *testing.T) {
+	orig := hostInterfaces
+	defer func() { hostInterfaces = orig }()
+	hostInterfaces = func() ([]InterfaceInfo, error) {
+		return []InterfaceInfo{
+			{Name: "eth0", Addresses: []AddressStatus{{IP: "192.168.1.10"}, {IP: "fe80::1"}}},
+			{Name: "lo", Addresses: []AddressStatus{{IP: "127.0.0.1"}}},
+		}, nil
+	}
+	mock := &MockDockerClient{Containers: []types.Container{
+		{
+			ID: "abc", Names: []string{"/web"}, Image: "nginx", State: "running",
+			Ports: []types.Port{
+				{PrivatePort: 80, PublicPort: 8080, Type: "tcp", IP: "0.0.0.0"},
+				{PrivatePort: 80, PublicPort: 8080, Type: "tcp", IP: "::"},
+				{PrivatePort: 5432, PublicPort: 5432, Type: "tcp", IP: "127.0.0.1"},
+				{PrivatePort: 53, PublicPort: 53, Type: "udp", IP: "10.9.9.9"},
+			},
+			NetworkSettings: &types.SummaryNetworkSettings{Networks: map[string]*network.EndpointSettings{"front": {}}},
+		},
+		{ID: "def", Names: []string{"/stopped"}, State: "exited", Ports: []types.Port{{PublicPort: 9000, Type: "tcp"}}},
+	}}
+	router := SetupRouter(&Server{client: mock})
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/topology", nil))
+	var resp TopologyResponse
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+
+	kinds := make(map[string]string)
+	for _, n := range resp.Nodes {
+		kinds[n.ID] = n.Kind
+	}
+	want := map[string]string{
+		"interface:eth0": "interface", "interface:lo": "interface", "container:abc": "container",
+		"network:front": "network", "host": "host",
+	}
+	if len(kinds) != len(want) {
+		t.Errorf("Expected nodes %v, got %v", want, kinds)
+	}
+	for id, kind := range want {
+		if kinds[id] != kind {
+			t.Errorf("Expected node %s of kind %s, got %q", id, kind, kinds[id])
+		}
+	}
+
+	edges := make(map[string]TopologyEdge)
+	for _, e := range resp.Edges {
+		edges[e.ID] = e
+		if kinds[e.Source] == "" || kinds[e.Target] == "" {
+			t.Errorf("Edge %s points at a missing node", e.ID)
+		}
+	}
+	web := edges["publication:interface:eth0:8080/tcp:abc"]
+	if web.Source != "interface:eth0" || web.Target != "container:abc" || web.ContainerPort != 80 || len(web.Addresses) != 2 {
+		t.Errorf("Expected the wildcard binding on both of eth0's addresses in one edge, got %+v", web)
+	}
+	if _, ok := edges["publication:interface:lo:8080/tcp:abc"]; !ok {
+		t.Error("Expected the wildcard binding on loopback too")
+	}
+	if _, ok := edges["publication:interface:eth0:5432/tcp:abc"]; ok {
+		t.Error("Expected a loopback binding only on lo")
+	}
+	if e := edges["publication:host:53/udp:abc"]; e.Source != "host" || len(e.Addresses) != 1 {
+		t.Errorf("Expected a binding on an unknown address to start from host, got %+v", e)
+	}
+	if e := edges["attachment:abc:front"]; e.Source != "container:abc" || e.Target != "network:front" {
+		t.Errorf("Expected the network attachment, got %+v", e)
+	}
+	if resp.Meta == nil {
+		t.Error("Expected snapshot meta")
+	}
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"quaycheck/internal/storage"
+)
+
+// TrafficSample is what a published port received over one sampling
+// interval: new connections and bytes toward the container
+type TrafficSample struct {
+	At          time.Time `json:"at"`
+	Port        int       `json:"port"`
+	Protocol    string    `json:"protocol"`
+	Connections uint64    `json:"connections"`
+	BytesIn     uint64    `json:"bytes_in"`
+}
+
+// TrafficTotal sums a port's samples over a window
+type TrafficTotal struct {
+	Port        int        `json:"port"`
+	Protocol    string     `json:"protocol"`
+	Container   string     `json:"container,omitempty"`
+	Connections uint64     `json:"connections"`
+	BytesIn     uint64     `json:"bytes_in"`
+	LastActive  *time.Time `json:"last_active,omitempty"`
+}
+
+type TrafficResponse struct {
+	Since time.Time      `json:"since"`
+	Ports []TrafficTotal `json:"ports"`
+	// Samples is the series for ?port=
+	Samples []TrafficSample `json:"samples,omitempty"`
+}
+
+// TrafficStore keeps the samples next to the port history
+type TrafficStore struct {
+	db *storage.DB
+}
+
+func NewTrafficStore(db *storage.DB) *TrafficStore {
+	if db == nil {
+		return nil
+	}
+	return &TrafficStore{db: db}
+}
+
+// Record stores samples in one transaction
+func (t *TrafficStore) Record(ctx context.Context, samples []TrafficSample) error {
+	if t == nil || len(samples) == 0 {
+		return nil
+	}
+	tx, err := t.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.PrepareContext(ctx, `INSERT INTO traffic (at, port, protocol, connections, bytes_in) VALUES (?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, s := range samples {
+		if _, err := stmt.ExecContext(ctx, s.At.UnixNano(), s.Port, s.Protocol, s.Connections, s.BytesIn); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// First is when the oldest sample kept was taken, nil without any
+func (t *TrafficStore) First(ctx context.Context) (*time.Time, error) {
+	var at *int64
+	if err := t.db.QueryRowContext(ctx, `SELECT MIN(at) FROM traffic`).Scan(&at); err != nil || at == nil {
+		return nil, err
+	}
+	first := time.Unix(0, *at).UTC()
+	return &first, nil
+}
+
+// Totals sums every port's samples from since on
+func (t *TrafficStore) Totals(ctx context.Context, since time.Time) ([]TrafficTotal, error) {
+	rows, err := t.db.QueryContext(ctx, `SELECT port, protocol, SUM(connections), SUM(bytes_in), MAX(CASE WHEN connections > 0 OR bytes_in > 0 THEN at END)
+		FROM traffic WHERE at >= ? GROUP BY port, protocol ORDER BY port, protocol`, since.UnixNano())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := []TrafficTotal{}
+	for rows.Next() {
+		var total TrafficTotal
+		var last *int64
+		if err := rows.Scan(&total.Port, &total.Protocol, &total.Connections, &total.BytesIn, &last); err != nil {
+			return nil, err
+		}
+		if last != nil {
+			at := time.Unix(0, *last).UTC()
+			total.LastActive = &at
+		}
+		result = append(result, total)
+	}
+	return result, rows.Err()
+}
+
+// Samples returns port's samples from since on, oldest first
+func (t *TrafficStore) Samples(ctx context.Context, port int, since time.Time) ([]TrafficSample, error) {
+	rows, err := t.db.QueryContext(ctx, `SELECT at, port, protocol, connections, bytes_in FROM traffic WHERE port = ? AND at >= ? ORDER BY at, id`, port, since.UnixNano())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := []TrafficSample{}
+	for rows.Next() {
+		var s TrafficSample
+		var at int64
+		if err := rows.Scan(&at, &s.Port, &s.Protocol, &s.Connections, &s.BytesIn); err != nil {
+			return nil, err
+		}
+		s.At = time.Unix(0, at).UTC()
+		result = append(result, s)
+	}
+	return result, rows.Err()
+}
+
+// trafficIntervalFromEnv reads QUAYCHECK_TRAFFIC_INTERVAL; sampling is off
+// when unset
+func trafficIntervalFromEnv() time.Duration {
+	v := os.Getenv("QUAYCHECK_TRAFFIC_INTERVAL")
+	if v == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 10*time.Second {
+		log.Fatalf("Invalid QUAYCHECK_TRAFFIC_INTERVAL %q: expected a duration of at least 10s", v)
+	}
+	return d
+}
+
+// runIptablesCounters is swapped out in tests
+var runIptablesCounters = func(ctx context.Context) ([]byte, error) {
+	return exec.CommandContext(ctx, "iptables-save", "-c").Output()
+}
+
+// trafficCounter is a published port's rule counters
+type trafficCounter struct {
+	connections, bytesIn uint64
+}
+
+// parseTrafficCounters reads Docker's rules from iptables-save -c. The
+// DNAT rule in the nat table only sees a connection's first packet, so its
+// packets count connections; bytes come from the filter table's ACCEPT
+// rule toward the container. Replies all go through one shared conntrack
+// rule, so nothing counts bytes out per port.
+func parseTrafficCounters(dump string) map[portKey]trafficCounter {
+	type target struct {
+		addr  string
+		proto string
+	}
+	published := map[target][]portKey{}
+	result := map[portKey]trafficCounter{}
+	bytesTo := map[target]uint64{}
+	table := ""
+	for _, line := range strings.Split(dump, "\n") {
+		if name, ok := strings.CutPrefix(line, "*"); ok {
+			table = name
+			continue
+		}
+		counters, rule, ok := strings.Cut(line, "] ")
+		if !ok || !strings.HasPrefix(counters, "[") {
+			continue
+		}
+		packetStr, byteStr, _ := strings.Cut(counters[1:], ":")
+		packets, _ := strconv.ParseUint(packetStr, 10, 64)
+		bytes, _ := strconv.ParseUint(byteStr, 10, 64)
+
+		fields := strings.Fields(rule)
+		if len(fields) < 2 || fields[0] != "-A" || fields[1] != "DOCKER" {
+			continue
+		}
+		flags := map[string]string{}
+		for i := 2; i < len(fields)-1; i++ {
+			if strings.HasPrefix(fields[i], "-") {
+				flags[fields[i]] = fields[i+1]
+			}
+		}
+		proto := flags["-p"]
+		switch {
+		case table == "nat" && flags["-j"] == "DNAT":
+			port, err := strconv.Atoi(flags["--dport"])
+			if err != nil {
+				continue
+			}
+			key := portKey{port, proto}
+			c := result[key]
+			c.connections += packets
+			result[key] = c
+			t := target{flags["--to-destination"], proto}
+			published[t] = append(published[t], key)
+		case table == "filter" && flags["-j"] == "ACCEPT" && flags["-d"] != "":
+			addr, _, _ := strings.Cut(flags["-d"], "/")
+			if strings.Contains(addr, ":") {
+				addr = "[" + addr + "]"
+			}
+			bytesTo[target{addr + ":" + flags["--dport"], proto}] += bytes
+		}
+	}
+	for t, keys := range published {
+		for _, key := range keys {
+			c := result[key]
+			c.bytesIn += bytesTo[t]
+			result[key] = c
+		}
+	}
+	return result
+}
+
+// TrafficSampler turns counters into per-interval samples
+type TrafficSampler struct {
+	last map[portKey]trafficCounter
+}
+
+// sample returns what each port received since the last call. The first
+// call only sets the baseline. Counters that went down were reset by the
+// rule being recreated, so they count from zero.
+func (t *TrafficSampler) sample(now time.Time, counters map[portKey]trafficCounter) []TrafficSample {
+	last := t.last
+	t.last = counters
+	if last == nil {
+		return nil
+	}
+	var samples []TrafficSample
+	for key, c := range counters {
+		prev, seen := last[key]
+		if !seen || c.connections < prev.connections || c.bytesIn < prev.bytesIn {
+			prev = trafficCounter{}
+		}
+		samples = append(samples, TrafficSample{At: now, Port: key.port, Protocol: key.proto, Connections: c.connections - prev.connections, BytesIn: c.bytesIn - prev.bytesIn})
+	}
+	slices.SortFunc(samples, func(a, b TrafficSample) int {
+		if a.Port != b.Port {
+			return a.Port - b.Port
+		}
+		return strings.Compare(a.Protocol, b.Protocol)
+	})
+	return samples
+}
+
+// trafficLoop samples the counters every interval
+func (s *Server) trafficLoop(ctx context.Context, interval time.Duration) {
+	if s.traffic == nil || interval <= 0 {
+		return
+	}
+	sampler := &TrafficSampler{}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if dump, err := runIptablesCounters(ctx); err != nil {
+			log.Printf("Cannot read iptables counters: %v", err)
+		} else if err := s.traffic.Record(ctx, sampler.sample(time.Now(), parseTrafficCounters(string(dump)))); err != nil {
+			log.Printf("Cannot record traffic: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// handleTraffic sums each port's traffic since ?since= (an age such as 7d,
+// the default, or 12h); ?port= adds that port's samples
+func (s *Server) handleTraffic(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	age := 7 * 24 * time.Hour
+	if v := q.Get("since"); v != "" {
+		d, err := parseRetentionAge(v)
+		if err != nil || d == 0 {
+			writeError(w, http.StatusBadRequest, "invalid_param", "Invalid since parameter")
+			return
+		}
+		age = d
+	}
+	port := 0
+	if v := q.Get("port"); v != "" {
+		p, err := strconv.Atoi(v)
+		if err != nil || p < 1 || p > maxPort {
+			writeError(w, http.StatusBadRequest, "invalid_param", "Invalid port parameter")
+			return
+		}
+		port = p
+	}
+	resp := TrafficResponse{Since: time.Now().Add(-age).UTC()}
+	totals, err := s.traffic.Totals(r.Context(), resp.Since)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "store_error", "Cannot read traffic: "+err.Error())
+		return
+	}
+	if port != 0 {
+		totals = slices.DeleteFunc(totals, func(t TrafficTotal) bool { return t.Port != port })
+		if resp.Samples, err = s.traffic.Samples(r.Context(), port, resp.Since); err != nil {
+			writeError(w, http.StatusInternalServerError, "store_error", "Cannot read traffic: "+err.Error())
+			return
+		}
+	}
+	// Name the current holders; the counters outlive them
+	if snap, err := s.loadSnapshot(r.Context()); err == nil {
+		for i := range totals {
+			for _, c := range localContainers(snap.Containers) {
+				if occupiesPorts(c.State) && publishes(c, totals[i].Port) {
+					totals[i].Container = containerName(c)
+					break
+				}
+			}
+		}
+	}
+	resp.Ports = totals
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+// trafficDump is iptables-save -c output for a web container on 8080 and
+// a DNS one on 5300
+func trafficDump(webConns, webBytes, dnsBytes int) string {
+	return fmt.Sprintf(`# Generated by iptables-save
+*nat
+:DOCKER - [0:0]
+[0:0] -A DOCKER -i docker0 -j RETURN
+[%d:0] -A DOCKER ! -i docker0 -p tcp -m tcp --dport 8080 -j DNAT --to-destination 172.17.0.2:80
+[4:240] -A DOCKER -d 127.0.0.1/32 ! -i docker0 -p udp -m udp --dport 5300 -j DNAT --to-destination 172.17.0.3:53
+COMMIT
+*filter
+:DOCKER - [0:0]
+[9:%d] -A DOCKER -d 172.17.0.2/32 ! -i docker0 -o docker0 -p tcp -m tcp --dport 80 -j ACCEPT
+[0:%d] -A DOCKER -d 172.17.0.3/32 ! -i docker0 -o docker0 -p udp -m udp --dport 53 -j ACCEPT
+[0:0] -A FORWARD -o docker0 -m conntrack --ctstate RELATED,ESTABLISHED -j ACCEPT
+COMMIT
+`, webConns, webBytes, dnsBytes)
+}
+
+func TestParseTrafficCounters(t *testing.T) {
+	counters := parseTrafficCounters(trafficDump(3, 900, 50))
+	if c := counters[portKey{8080, "tcp"}]; c != (trafficCounter{connections: 3, bytesIn: 900}) {
+		t.Errorf("Unexpected 8080 counters %+v", c)
+	}
+	if c := counters[portKey{5300, "udp"}]; c != (trafficCounter{connections: 4, bytesIn: 50}) {
+		t.Errorf("Unexpected 5300 counters %+v", c)
+	}
+	if len(counters) != 2 {
+		t.Errorf("Expected only the published ports, got %+v", counters)
+	}
+}
+
+func TestTrafficSampler(t *testing.T) {
+	sampler := &TrafficSampler{}
+	now := time.Now()
+	if samples := sampler.sample(now, parseTrafficCounters(trafficDump(3, 900, 50))); samples != nil {
+		t.Errorf("Expected the first read to be the baseline, got %+v", samples)
+	}
+	samples := sampler.sample(now, parseTrafficCounters(trafficDump(5, 1500, 50)))
+	if len(samples) != 2 || samples[1].Port != 8080 || samples[1].Connections != 2 || samples[1].BytesIn != 600 || samples[0].BytesIn != 0 {
+		t.Errorf("Unexpected samples %+v", samples)
+	}
+	// The container was recreated and its rules with it
+	samples = sampler.sample(now, parseTrafficCounters(trafficDump(1, 100, 50)))
+	if samples[1].Connections != 1 || samples[1].BytesIn != 100 {
+		t.Errorf("Expected reset counters to count from zero, got %+v", samples[1])
+	}
+}
+
+func TestTrafficEndpoint(t *testing.T) {
+	db := openTestDB(t)
+	store := NewTrafficStore(db)
+	now := time.Now()
+	store.Record(context.Background(), []TrafficSample{
+		{At: now.Add(-30 * 24 * time.Hour), Port: 8080, Protocol: "tcp", Connections: 100, BytesIn: 10000},
+		{At: now.Add(-2 * time.Hour), Port: 8080, Protocol: "tcp", Connections: 2, BytesIn: 600},
+		{At: now.Add(-time.Hour), Port: 8080, Protocol: "tcp"},
+		{At: now.Add(-time.Hour), Port: 8081, Protocol: "tcp"},
+	})
+	server := &Server{
+		client:  &MockDockerClient{Containers: []types.Container{{Names: []string{"/web"}, State: "running", Ports: []types.Port{{PublicPort: 8080, PrivatePort: 80, Type: "tcp"}}}}},
+		traffic: store,
+	}
+	get := func(url string) TrafficResponse {
+		w := httptest.NewRecorder()
+		SetupRouter(server).ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d %s", w.Code, w.Body)
+		}
+		var resp TrafficResponse
+		json.NewDecoder(w.Body).Decode(&resp)
+		return resp
+	}
+
+	resp := get("/api/traffic")
+	if len(resp.Ports) != 2 || resp.Ports[0].Connections != 2 || resp.Ports[0].BytesIn != 600 || resp.Ports[0].Container != "web" {
+		t.Fatalf("Expected a week of traffic, got %+v", resp.Ports)
+	}
+	if last := resp.Ports[0].LastActive; last == nil || last.Sub(now.Add(-2*time.Hour)).Abs() > time.Second {
+		t.Errorf("Expected 8080 last active two hours ago, got %v", last)
+	}
+	if resp.Ports[1].LastActive != nil {
+		t.Errorf("Expected 8081 never to have been active, got %v", resp.Ports[1].LastActive)
+	}
+	if resp := get("/api/traffic?since=90d&port=8080"); len(resp.Ports) != 1 || resp.Ports[0].Connections != 102 || len(resp.Samples) != 3 {
+		t.Errorf("Expected 90 days of 8080, got %+v", resp)
+	}
+
+	w := httptest.NewRecorder()
+	SetupRouter(&Server{client: &MockDockerClient{}}).ServeHTTP(w, httptest.NewRequest("GET", "/api/traffic", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected no traffic endpoint without sampling, got %d", w.Code)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"quaycheck/internal/portspec"
+)
+
+// unitExtensions are the files `quaycheck check` reads as systemd units:
+// podman quadlets and plain services running docker or podman
+var unitExtensions = []string{".container", ".pod", ".service"}
+
+func isUnitFile(name string) bool {
+	return slices.Contains(unitExtensions, filepath.Ext(name))
+}
+
+// ParseUnit extracts published ports from a systemd unit: PublishPort= in a
+// quadlet's [Container] or [Pod] section, and -p/--publish flags of a
+// docker or podman run in ExecStart=. Ports are named after ContainerName=
+// or PodName=, else the unit file's name.
+func ParseUnit(name string, data []byte) ([]ComposePort, error) {
+	service := strings.TrimSuffix(filepath.Base(name), filepath.Ext(name))
+	var result []ComposePort
+	var section string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		start, text := line, strings.TrimSpace(scanner.Text())
+		// A trailing backslash continues the line
+		for strings.HasSuffix(text, "\\") && scanner.Scan() {
+			line++
+			text = strings.TrimSuffix(text, "\\") + " " + strings.TrimSpace(scanner.Text())
+		}
+		if text == "" || text[0] == '#' || text[0] == ';' {
+			continue
+		}
+		if strings.HasPrefix(text, "[") && strings.HasSuffix(text, "]") {
+			section = text[1 : len(text)-1]
+			continue
+		}
+		key, value, ok := strings.Cut(text, "=")
+		if !ok {
+			continue
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+
+		var specs []string
+		switch {
+		case (section == "Container" || section == "Pod") && (key == "ContainerName" || key == "PodName"):
+			service = value
+		case (section == "Container" || section == "Pod") && key == "PublishPort":
+			specs = strings.Fields(value)
+		case section == "Service" && key == "ExecStart":
+			words, err := portspec.SplitCommand(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", start, err)
+			}
+			specs = portspec.PublishFlags(words)
+		}
+		for _, spec := range specs {
+			p, err := parsePortSpec(spec)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", start, err)
+			}
+			if p.PublicPort == 0 {
+				continue
+			}
+			p.Line = start
+			result = append(result, p)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	// ContainerName= may come after the ports it names
+	for i := range result {
+		result[i].Service = service
+	}
+	return result, nil
+}
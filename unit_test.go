@@ -0,0 +1,53 @@
+package main
+
+import "testing"
+
+func TestParseUnitQuadlet(t *testing.T) {
+	ports, err := ParseUnit("/etc/containers/systemd/web.container", []byte(`[Unit]
+Description=Web
+
+[Container]
+Image=docker.io/nginx
+PublishPort=8080:80
+PublishPort=127.0.0.1:8443:443/udp
+ContainerName=frontend
+`))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(ports) != 2 || ports[0].Service != "frontend" || ports[0].Line != 6 || ports[1].IP != "127.0.0.1" || ports[1].Type != "udp" {
+		t.Errorf("Unexpected quadlet ports: %+v", ports)
+	}
+}
+
+func TestParseUnitExecStart(t *testing.T) {
+	ports, err := ParseUnit("grafana.service", []byte(`[Service]
+# -p 1:1 in a comment doesn't count
+ExecStartPre=-/usr/bin/docker rm -f grafana
+ExecStart=/usr/bin/docker run --rm --name grafana \
+  -p 3000:3000 --publish=9090:9090 -p127.0.0.1:9091:9091 \
+  -e "GF_OPTS=-p 1:1" grafana/grafana
+`))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(ports) != 3 || ports[0].Service != "grafana" || ports[0].Line != 4 || ports[1].PublicPort != 9090 || ports[2].PublicPort != 9091 {
+		t.Errorf("Unexpected ExecStart ports: %+v", ports)
+	}
+
+	if ports, _ := ParseUnit("x.service", []byte("[Service]\nExecStart=/usr/bin/nc -l -p 9000\n")); len(ports) != 0 {
+		t.Errorf("Expected only docker and podman runs to count, got %+v", ports)
+	}
+	if _, err := ParseUnit("x.service", []byte("[Service]\nExecStart=/usr/bin/podman run -p \"80:80\n")); err == nil {
+		t.Error("Expected an unterminated quote to fail")
+	}
+}
+
+func FuzzParseUnit(f *testing.F) {
+	f.Add("web.container", []byte("[Container]\nPublishPort=8080:80\nContainerName=web\n"))
+	f.Add("web.service", []byte("[Service]\nExecStart=/usr/bin/docker run \\\n  -p 3000:3000 \"img\"\n"))
+	f.Add("web.service", []byte("[Service]\nExecStart=docker run -p 'x\n"))
+	f.Fuzz(func(t *testing.T, name string, data []byte) {
+		ParseUnit(name, data)
+	})
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// proxyConfigsFromEnv reads QUAYCHECK_PROXY_CONFIGS, the comma-separated
+// paths or globs of the reverse proxy configs to validate, e.g.
+// /etc/nginx/conf.d/*.conf,/etc/caddy/Caddyfile
+func proxyConfigsFromEnv() []string {
+	return queryList([]string{os.Getenv("QUAYCHECK_PROXY_CONFIGS")})
+}
+
+// UpstreamStatus is whether anything answers for one upstream of a proxy
+// config, and who published its port last when nothing does
+type UpstreamStatus struct {
+	File string `json:"file"`
+	ProxyUpstream
+	OK     bool   `json:"ok"`
+	Code   string `json:"code,omitempty"`
+	Reason string `json:"reason,omitempty"`
+	// LastHolder and FreedAt come from the history, for an upstream on
+	// this host: what the proxy was pointing at before it went away
+	LastHolder string     `json:"last_holder,omitempty"`
+	FreedAt    *time.Time `json:"freed_at,omitempty"`
+}
+
+// UpstreamReport validates every upstream of the configured proxy configs
+type UpstreamReport struct {
+	OK        bool             `json:"ok"`
+	Upstreams []UpstreamStatus `json:"upstreams"`
+	// Errors lists configs that couldn't be read or parsed
+	Errors []string      `json:"errors,omitempty"`
+	Meta   *ResponseMeta `json:"meta,omitempty"`
+}
+
+// proxyConfigFiles expands the configured globs, in order
+func (s *Server) proxyConfigFiles() ([]string, []string) {
+	var files, errs []string
+	for _, pattern := range s.proxyConfigs {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", pattern, err))
+			continue
+		}
+		if matches == nil {
+			errs = append(errs, fmt.Sprintf("%s: no such file", pattern))
+		}
+		files = append(files, matches...)
+	}
+	return files, errs
+}
+
+// handleValidateUpstreams reports the upstreams of the proxy configs that
+// nothing answers for anymore, typically after a service moved to another
+// port. ?all=true lists the healthy ones too.
+func (s *Server) handleValidateUpstreams(w http.ResponseWriter, r *http.Request) {
+	if len(s.proxyConfigs) == 0 {
+		writeError(w, http.StatusNotImplemented, "proxy_configs_unset", "No proxy configs are set up on this server")
+		return
+	}
+	snap, err := s.loadSnapshot(r.Context())
+	if err != nil {
+		status, code, msg := classifyDockerError(err)
+		writeError(w, status, code, msg)
+		return
+	}
+	all := r.URL.Query().Get("all") == "true"
+
+	files, errs := s.proxyConfigFiles()
+	report := UpstreamReport{Upstreams: []UpstreamStatus{}, Errors: errs}
+	containers := localContainers(snap.Containers)
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			report.Errors = append(report.Errors, err.Error())
+			continue
+		}
+		cfg, err := ParseProxyConfig(file, "", data)
+		if err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", file, err))
+			continue
+		}
+		for _, u := range cfg.Upstreams {
+			f, ok := checkUpstream(u, containers)
+			if ok && !all {
+				continue
+			}
+			status := UpstreamStatus{File: file, ProxyUpstream: u, OK: ok, Code: f.Code, Reason: f.Reason}
+			if !ok && localUpstream(u.Host) {
+				s.lastHolder(r, &status)
+			}
+			report.Upstreams = append(report.Upstreams, status)
+		}
+	}
+	report.OK = len(report.Errors) == 0
+	for _, u := range report.Upstreams {
+		report.OK = report.OK && u.OK
+	}
+	report.Meta = s.snapshotMeta(snap)
+
+	s.writeSnapshotHeaders(w, snap)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// lastHolder fills in who freed the upstream's port last, if the history
+// remembers
+func (s *Server) lastHolder(r *http.Request, status *UpstreamStatus) {
+	events, err := s.history.List(r.Context(), status.Port, 1)
+	if err != nil || len(events) == 0 || events[0].Type != EventPortFreed {
+		return
+	}
+	status.LastHolder, status.FreedAt = events[0].Container, &events[0].Time
+	status.Reason += fmt.Sprintf(" since %s released it", events[0].Container)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+func TestValidateUpstreams(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "grafana.conf"), []byte("server {\n  location / { proxy_pass http://127.0.0.1:3000; }\n  location /old { proxy_pass http://127.0.0.1:3001; }\n}\n"), 0o644)
+	os.WriteFile(filepath.Join(dir, "Caddyfile"), []byte(":80 {\n\treverse_proxy api:8080\n}\n"), 0o644)
+	os.WriteFile(filepath.Join(dir, "broken.conf"), []byte("server {\n"), 0o644)
+
+	server := &Server{
+		client: &MockDockerClient{Containers: []types.Container{
+			{Names: []string{"/grafana"}, State: "running", Ports: []types.Port{{PublicPort: 3000, PrivatePort: 3000}}},
+			{Names: []string{"/api"}, State: "exited", Ports: []types.Port{{PrivatePort: 8080}}},
+		}},
+		history:      NewHistoryStore(openTestDB(t)),
+		proxyConfigs: []string{filepath.Join(dir, "*.conf"), filepath.Join(dir, "Caddyfile")},
+	}
+	freed := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	server.history.Record(context.Background(), []PortEvent{{Type: EventPortFreed, Port: 3001, Protocol: "tcp", Container: "grafana-old", Time: freed}})
+
+	w := httptest.NewRecorder()
+	SetupRouter(server).ServeHTTP(w, httptest.NewRequest("GET", "/api/validate/upstreams", nil))
+	var report UpstreamReport
+	json.NewDecoder(w.Body).Decode(&report)
+	if w.Code != http.StatusOK || report.OK || len(report.Errors) != 1 || len(report.Upstreams) != 2 {
+		t.Fatalf("Expected two broken upstreams and one unreadable config, got %d %+v", w.Code, report)
+	}
+	old := report.Upstreams[0]
+	if old.Port != 3001 || old.Code != "upstream_unpublished" || old.LastHolder != "grafana-old" || !old.FreedAt.Equal(freed) || old.Line != 3 {
+		t.Errorf("Expected 3001 to be traced to grafana-old, got %+v", old)
+	}
+	if api := report.Upstreams[1]; api.Host != "api" || api.Code != "upstream_not_exposed" || filepath.Base(api.File) != "Caddyfile" {
+		t.Errorf("Expected the stopped api to be flagged, got %+v", api)
+	}
+
+	w = httptest.NewRecorder()
+	SetupRouter(server).ServeHTTP(w, httptest.NewRequest("GET", "/api/validate/upstreams?all=true", nil))
+	report = UpstreamReport{}
+	json.NewDecoder(w.Body).Decode(&report)
+	if len(report.Upstreams) != 3 || !report.Upstreams[0].OK {
+		t.Errorf("Expected the healthy upstream too, got %+v", report.Upstreams)
+	}
+
+	server.proxyConfigs = nil
+	w = httptest.NewRecorder()
+	SetupRouter(server).ServeHTTP(w, httptest.NewRequest("GET", "/api/validate/upstreams", nil))
+	if w.Code != http.StatusNotImplemented {
+		t.Errorf("Expected 501 without proxy configs, got %d", w.Code)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// portUsage adjusts, for one request, which entries count as holding their
+// ports. The zero value matches the snapshot's own index.
+type portUsage struct {
+	includeCreated bool
+
+	// ignore lists containers, by name or ID, whose ports are treated as
+	// free, e.g. the old version of a service about to be replaced
+	ignore []string
+
+	// ignoreProjects lists compose projects whose containers are treated
+	// as gone, for recreating a whole stack
+	ignoreProjects []string
+}
+
+func countCreatedFromEnv() bool {
+	return os.Getenv("QUAYCHECK_COUNT_CREATED") == "true"
+}
+
+// usageFromRequest reads include_created, defaulting to the server setting,
+// and ignore_container and ignore_project, which may be repeated or
+// comma-separated
+func (s *Server) usageFromRequest(r *http.Request) (portUsage, error) {
+	q := r.URL.Query()
+	u := portUsage{includeCreated: s.config().CountCreated}
+	if v := q.Get("include_created"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return portUsage{}, errors.New("Invalid include_created parameter")
+		}
+		u.includeCreated = b
+	}
+	for _, ref := range queryList(q["ignore_container"]) {
+		if ref = strings.TrimPrefix(ref, "/"); ref != "" {
+			u.ignore = append(u.ignore, ref)
+		}
+	}
+	u.ignoreProjects = queryList(q["ignore_project"])
+	return u, nil
+}
+
+// queryList flattens repeated, comma-separated query values
+func queryList(values []string) []string {
+	var result []string
+	for _, v := range values {
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				result = append(result, item)
+			}
+		}
+	}
+	return result
+}
+
+func (u portUsage) isDefault() bool {
+	return !u.includeCreated && len(u.ignore) == 0 && len(u.ignoreProjects) == 0
+}
+
+func (u portUsage) holds(c ContainerData) bool {
+	for _, ref := range u.ignore {
+		if matchesContainer(c, ref) {
+			return false
+		}
+	}
+	if c.Project != "" && slices.Contains(u.ignoreProjects, c.Project) {
+		return false
+	}
+	if u.includeCreated && c.State == "created" {
+		return true
+	}
+	return occupiesPorts(c.State)
+}
+
+// matchesContainer reports whether ref is c's name, full ID or an ID prefix
+// of at least 12 characters (the short form docker ps prints)
+func matchesContainer(c ContainerData, ref string) bool {
+	if ref == c.ID || (len(ref) >= 12 && strings.HasPrefix(c.ID, ref)) {
+		return true
+	}
+	for _, name := range c.Names {
+		if strings.TrimPrefix(name, "/") == ref {
+			return true
+		}
+	}
+	return false
+}
+
+// index returns the port index for this usage, reusing the snapshot's when
+// nothing is adjusted
+func (u portUsage) index(snap Snapshot) *PortIndex {
+	if u.isDefault() {
+		return snap.Index
+	}
+	return buildPortIndexFunc(snap.Containers, func(c ContainerData) bool { return u.holdsIn(snap, c) })
+}
+
+// holdsIn reports whether c holds its ports in snap's index
+func (u portUsage) holdsIn(snap Snapshot, c ContainerData) bool {
+	return u.holds(c) && snap.counts(c)
+}
+
+// holder returns the entry holding port in snap's index
+func (u portUsage) holder(snap Snapshot, port int) (ContainerData, bool) {
+	for _, c := range snap.Containers {
+		if !u.holdsIn(snap, c) {
+			continue
+		}
+		for _, p := range c.Ports {
+			if int(p.PublicPort) == port {
+				return c, true
+			}
+		}
+	}
+	return ContainerData{}, false
+}
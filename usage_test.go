@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+)
+
+func TestIncludeCreated(t *testing.T) {
+	server := &Server{client: &MockDockerClient{Containers: []types.Container{
+		{Names: []string{"/pending"}, State: "created", Ports: []types.Port{{PublicPort: 8000}}},
+	}}}
+
+	tests := []struct {
+		query        string
+		countCreated bool
+		expectedPort int
+	}{
+		{"", false, 8000},
+		{"&include_created=true", false, 8001},
+		{"", true, 8001},
+		{"&include_created=false", true, 8000},
+	}
+
+	for _, tt := range tests {
+		server.countCreated = tt.countCreated
+		w := httptest.NewRecorder()
+		server.handleSuggest(w, httptest.NewRequest("GET", "/api/suggest?start=8000"+tt.query, nil))
+
+		var result SuggestResponse
+		json.NewDecoder(w.Body).Decode(&result)
+		if result.Port != tt.expectedPort {
+			t.Errorf("%q (countCreated=%v): expected %d, got %d", tt.query, tt.countCreated, tt.expectedPort, result.Port)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	server.handleCheck(w, httptest.NewRequest("GET", "/api/check?port=8000&include_created=true", nil))
+	var check CheckResponse
+	json.NewDecoder(w.Body).Decode(&check)
+	if check.Available || check.OccupiedBy != "pending" || check.OccupiedState != "created" {
+		t.Errorf("Unexpected check result: %+v", check)
+	}
+
+	w = httptest.NewRecorder()
+	server.handleCheck(w, httptest.NewRequest("GET", "/api/check?port=8000&include_created=maybe", nil))
+	if w.Code != 400 {
+		t.Errorf("Expected 400 for invalid include_created, got %d", w.Code)
+	}
+}
+
+func TestIgnoreContainer(t *testing.T) {
+	server := &Server{client: &MockDockerClient{Containers: []types.Container{
+		{ID: "0123456789abcdef", Names: []string{"/app-blue"}, State: "running", Ports: []types.Port{{PublicPort: 8080}}},
+		{ID: "fedcba9876543210", Names: []string{"/other"}, State: "running", Ports: []types.Port{{PublicPort: 8081}}},
+	}}}
+
+	tests := []struct {
+		query     string
+		available bool
+	}{
+		{"port=8080", false},
+		{"port=8080&ignore_container=app-blue", true},
+		{"port=8080&ignore_container=/app-blue", true},
+		{"port=8080&ignore_container=0123456789ab", true},
+		{"port=8080&ignore_container=0123", false},
+		{"port=8080&ignore_container=other", false},
+		{"port=8081&ignore_container=app-blue,other", true},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		server.handleCheck(w, httptest.NewRequest("GET", "/api/check?"+tt.query, nil))
+
+		var result CheckResponse
+		json.NewDecoder(w.Body).Decode(&result)
+		if result.Available != tt.available {
+			t.Errorf("%s: expected available=%v, got %v", tt.query, tt.available, result.Available)
+		}
+	}
+}
+
+func TestIgnoreProject(t *testing.T) {
+	server := &Server{client: &MockDockerClient{Containers: []types.Container{
+		{Names: []string{"/site-web-1"}, State: "running", Labels: map[string]string{composeProjectLabel: "site"}, Ports: []types.Port{{PublicPort: 8000}}},
+		{Names: []string{"/site-db-1"}, State: "running", Labels: map[string]string{composeProjectLabel: "site"}, Ports: []types.Port{{PublicPort: 8001}}},
+		{Names: []string{"/mail"}, State: "running", Ports: []types.Port{{PublicPort: 8002}}},
+	}}}
+
+	tests := []struct {
+		query        string
+		expectedPort int
+	}{
+		{"start=8000", 8003},
+		{"start=8000&ignore_project=site", 8000},
+		{"start=8002&ignore_project=site", 8003},
+		{"start=8000&ignore_project=other", 8003},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		server.handleSuggest(w, httptest.NewRequest("GET", "/api/suggest?"+tt.query, nil))
+
+		var result SuggestResponse
+		json.NewDecoder(w.Body).Decode(&result)
+		if result.Port != tt.expectedPort {
+			t.Errorf("%s: expected %d, got %d", tt.query, tt.expectedPort, result.Port)
+		}
+	}
+}
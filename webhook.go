@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AdmissionReview is the admission.k8s.io/v1 envelope, trimmed to what the
+// webhook reads and writes
+type AdmissionReview struct {
+	APIVersion string             `json:"apiVersion"`
+	Kind       string             `json:"kind"`
+	Request    *AdmissionRequest  `json:"request,omitempty"`
+	Response   *AdmissionResponse `json:"response,omitempty"`
+}
+
+type AdmissionRequest struct {
+	UID    string          `json:"uid"`
+	Object json.RawMessage `json:"object"`
+}
+
+type AdmissionResponse struct {
+	UID      string           `json:"uid"`
+	Allowed  bool             `json:"allowed"`
+	Status   *AdmissionStatus `json:"status,omitempty"`
+	Warnings []string         `json:"warnings,omitempty"`
+}
+
+type AdmissionStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type pod struct {
+	Metadata struct {
+		Name      string `json:"name"`
+		Namespace string `json:"namespace"`
+	} `json:"metadata"`
+	Spec struct {
+		NodeName     string            `json:"nodeName"`
+		NodeSelector map[string]string `json:"nodeSelector"`
+		Containers   []struct {
+			Name  string `json:"name"`
+			Ports []struct {
+				HostPort int    `json:"hostPort"`
+				Protocol string `json:"protocol"`
+			} `json:"ports"`
+		} `json:"containers"`
+	} `json:"spec"`
+}
+
+// Webhook rejects Pods whose hostPort is already used on their target node,
+// by asking the quaycheck agent running on that node
+type Webhook struct {
+	Agents     map[string]string
+	HTTPClient *http.Client
+}
+
+// parseAgents parses "node1=http://node1:8080,node2=http://node2:8080"
+func parseAgents(spec string) (map[string]string, error) {
+	agents := make(map[string]string)
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		node, agent, ok := strings.Cut(item, "=")
+		if !ok || node == "" || agent == "" {
+			return nil, fmt.Errorf("invalid agent %q: expected node=url", item)
+		}
+		agents[node] = strings.TrimRight(agent, "/")
+	}
+	return agents, nil
+}
+
+func (wh *Webhook) portAvailable(ctx context.Context, agent string, port int) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", agent+"/api/check?port="+url.QueryEscape(strconv.Itoa(port)), nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := wh.HTTPClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("agent returned %s", resp.Status)
+	}
+	var result CheckResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return false, err
+	}
+	return result.Available, nil
+}
+
+func (wh *Webhook) review(ctx context.Context, p pod) *AdmissionResponse {
+	resp := &AdmissionResponse{Allowed: true}
+
+	node := p.Spec.NodeName
+	if node == "" {
+		node = p.Spec.NodeSelector["kubernetes.io/hostname"]
+	}
+
+	var hostPorts []int
+	for _, c := range p.Spec.Containers {
+		for _, port := range c.Ports {
+			if port.HostPort > 0 {
+				hostPorts = append(hostPorts, port.HostPort)
+			}
+		}
+	}
+	if len(hostPorts) == 0 {
+		return resp
+	}
+
+	agent, ok := wh.Agents[node]
+	if !ok {
+		resp.Warnings = append(resp.Warnings, fmt.Sprintf("quaycheck: no agent known for node %q, hostPort conflicts not checked", node))
+		return resp
+	}
+
+	var conflicts []string
+	for _, port := range hostPorts {
+		available, err := wh.portAvailable(ctx, agent, port)
+		if err != nil {
+			resp.Warnings = append(resp.Warnings, fmt.Sprintf("quaycheck: cannot check port %d on %s: %v", port, node, err))
+			continue
+		}
+		if !available {
+			conflicts = append(conflicts, strconv.Itoa(port))
+		}
+	}
+
+	if len(conflicts) > 0 {
+		resp.Allowed = false
+		resp.Status = &AdmissionStatus{
+			Code:    http.StatusConflict,
+			Message: fmt.Sprintf("hostPort %s already in use on node %s", strings.Join(conflicts, ", "), node),
+		}
+	}
+	return resp
+}
+
+func (wh *Webhook) handleAdmission(w http.ResponseWriter, r *http.Request) {
+	var review AdmissionReview
+	if err := json.NewDecoder(r.Body).Decode(&review); err != nil || review.Request == nil {
+		writeError(w, http.StatusBadRequest, "invalid_body", "Expected an AdmissionReview")
+		return
+	}
+
+	var p pod
+	if err := json.Unmarshal(review.Request.Object, &p); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_body", "Cannot decode Pod: "+err.Error())
+		return
+	}
+
+	resp := wh.review(r.Context(), p)
+	resp.UID = review.Request.UID
+	if !resp.Allowed {
+		log.Printf("Rejected pod %s/%s: %s", p.Metadata.Namespace, p.Metadata.Name, resp.Status.Message)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(AdmissionReview{
+		APIVersion: "admission.k8s.io/v1",
+		Kind:       "AdmissionReview",
+		Response:   resp,
+	})
+}
+
+func runWebhook() {
+	agents, err := parseAgents(os.Getenv("QUAYCHECK_WEBHOOK_AGENTS"))
+	if err != nil {
+		log.Fatalf("Error parsing QUAYCHECK_WEBHOOK_AGENTS: %v", err)
+	}
+	wh := &Webhook{Agents: agents, HTTPClient: &http.Client{Timeout: 5 * time.Second}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/validate", wh.handleAdmission)
+
+	port := os.Getenv("QUAYCHECK_WEBHOOK_PORT")
+	if port == "" {
+		port = "8443"
+	}
+
+	cert, key := os.Getenv("QUAYCHECK_TLS_CERT"), os.Getenv("QUAYCHECK_TLS_KEY")
+	log.Printf("Admission webhook starting on port %s with %d agents...", port, len(agents))
+	if cert == "" || key == "" {
+		log.Printf("QUAYCHECK_TLS_CERT/QUAYCHECK_TLS_KEY not set, serving plain HTTP")
+		log.Fatal(http.ListenAndServe(":"+port, mux))
+	}
+	log.Fatal(http.ListenAndServeTLS(":"+port, cert, key, mux))
+}
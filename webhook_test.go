@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+)
+
+func TestParseAgents(t *testing.T) {
+	agents, err := parseAgents("node1=http://node1:8080/, node2=http://node2:8080")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if agents["node1"] != "http://node1:8080" || agents["node2"] != "http://node2:8080" {
+		t.Errorf("Unexpected agents: %v", agents)
+	}
+	if _, err := parseAgents("node1"); err == nil {
+		t.Error("Expected error for missing url")
+	}
+}
+
+func TestWebhookAdmission(t *testing.T) {
+	agent := &Server{client: &MockDockerClient{Containers: []types.Container{
+		{State: "running", Ports: []types.Port{{PublicPort: 8080}}},
+	}}}
+	ts := httptest.NewServer(SetupRouter(agent))
+	defer ts.Close()
+
+	wh := &Webhook{Agents: map[string]string{"node1": ts.URL}, HTTPClient: ts.Client()}
+
+	tests := []struct {
+		name     string
+		pod      string
+		allowed  bool
+		warnings bool
+	}{
+		{"conflict", `{"spec":{"nodeName":"node1","containers":[{"ports":[{"hostPort":8080}]}]}}`, false, false},
+		{"free", `{"spec":{"nodeName":"node1","containers":[{"ports":[{"hostPort":9000}]}]}}`, true, false},
+		{"no hostPort", `{"spec":{"containers":[{"ports":[{"containerPort":80}]}]}}`, true, false},
+		{"selector", `{"spec":{"nodeSelector":{"kubernetes.io/hostname":"node1"},"containers":[{"ports":[{"hostPort":8080}]}]}}`, false, false},
+		{"unknown node", `{"spec":{"nodeName":"node9","containers":[{"ports":[{"hostPort":8080}]}]}}`, true, true},
+	}
+
+	for _, tt := range tests {
+		body, _ := json.Marshal(AdmissionReview{
+			APIVersion: "admission.k8s.io/v1",
+			Kind:       "AdmissionReview",
+			Request:    &AdmissionRequest{UID: "abc", Object: json.RawMessage(tt.pod)},
+		})
+		req := httptest.NewRequest("POST", "/validate", bytes.NewReader(body))
+		w := httptest.NewRecorder()
+		wh.handleAdmission(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected status 200, got %d", tt.name, w.Code)
+		}
+		var review AdmissionReview
+		json.NewDecoder(w.Body).Decode(&review)
+		if review.Response.UID != "abc" {
+			t.Errorf("%s: expected uid to be echoed", tt.name)
+		}
+		if review.Response.Allowed != tt.allowed {
+			t.Errorf("%s: expected allowed=%v, got %v", tt.name, tt.allowed, review.Response.Allowed)
+		}
+		if (len(review.Response.Warnings) > 0) != tt.warnings {
+			t.Errorf("%s: unexpected warnings %v", tt.name, review.Response.Warnings)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+)
+
+// WidgetResponse is flat on purpose: dashboard widgets such as Homepage's
+// customapi map top-level fields to tiles without any path syntax
+type WidgetResponse struct {
+	Ports      int    `json:"ports"`
+	Containers int    `json:"containers"`
+	Conflicts  int    `json:"conflicts"`
+	Free       int    `json:"free"`
+	Range      string `json:"range"`
+	Stale      bool   `json:"stale"`
+}
+
+// PortConflict is a host port claimed by more than one live entry
+type PortConflict struct {
+	Port     int      `json:"port"`
+	Protocol string   `json:"protocol"`
+	Holders  []string `json:"holders"`
+}
+
+// portConflicts lists (port, protocol) pairs held by more than one live
+// entry, by port. A reservation made for a container shares its name and
+// doesn't count, nor do the IPv4 and IPv6 copies of one binding.
+func portConflicts(containers []ContainerData, holds func(ContainerData) bool) []PortConflict {
+	type binding struct {
+		port  uint16
+		proto string
+	}
+	holders := make(map[binding][]string)
+	for _, c := range containers {
+		if !holds(c) {
+			continue
+		}
+		name := containerName(c)
+		for _, p := range c.Ports {
+			k := binding{p.PublicPort, p.Type}
+			if p.PublicPort == 0 || slices.Contains(holders[k], name) {
+				continue
+			}
+			holders[k] = append(holders[k], name)
+		}
+	}
+	var result []PortConflict
+	for k, names := range holders {
+		if len(names) > 1 {
+			result = append(result, PortConflict{Port: int(k.port), Protocol: k.proto, Holders: names})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Port != result[j].Port {
+			return result[i].Port < result[j].Port
+		}
+		return result[i].Protocol < result[j].Protocol
+	})
+	return result
+}
+
+// widgetOriginsFromEnv reads QUAYCHECK_WIDGET_ORIGINS, the comma-separated
+// origins a browser may fetch /api/widget from. Unset allows any origin,
+// as the summary holds nothing sensitive.
+func widgetOriginsFromEnv() []string {
+	return queryList([]string{os.Getenv("QUAYCHECK_WIDGET_ORIGINS")})
+}
+
+// widgetCORS sets the CORS headers for origin and reports whether it is
+// allowed
+func (s *Server) widgetCORS(w http.ResponseWriter, origin string) bool {
+	switch {
+	case origin == "":
+		return true
+	case len(s.widgetOrigins) == 0 || slices.Contains(s.widgetOrigins, "*"):
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	case slices.Contains(s.widgetOrigins, origin):
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Add("Vary", "Origin")
+	default:
+		return false
+	}
+	return true
+}
+
+// handleWidget serves a small summary for homelab dashboards. The free
+// count covers the same range parameters as /api/ranges.
+func (s *Server) handleWidget(w http.ResponseWriter, r *http.Request) {
+	allowed := s.widgetCORS(w, r.Header.Get("Origin"))
+	if r.Method == http.MethodOptions {
+		if allowed {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Authorization")
+			w.Header().Set("Access-Control-Max-Age", "86400")
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD, OPTIONS")
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	}
+
+	pr, errMsg := s.rangeFromRequest(r)
+	if errMsg != "" {
+		writeError(w, http.StatusBadRequest, "invalid_param", errMsg)
+		return
+	}
+	usage, err := s.usageFromRequest(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_param", err.Error())
+		return
+	}
+	snap, err := s.loadSnapshot(r.Context())
+	if err != nil {
+		status, code, msg := classifyDockerError(err)
+		writeError(w, status, code, msg)
+		return
+	}
+
+	resp := widgetSummary(snap, usage, pr)
+
+	s.writeSnapshotHeaders(w, snap)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// widgetSummary counts the ports and containers usage holds in snap, and
+// the ports free in pr
+func widgetSummary(snap Snapshot, usage portUsage, pr PortRange) WidgetResponse {
+	resp := WidgetResponse{
+		Conflicts: len(portConflicts(snap.Containers, func(c ContainerData) bool { return usage.holdsIn(snap, c) })),
+		Range:     fmt.Sprintf("%d-%d", pr.Start, pr.End),
+		Stale:     snap.Stale,
+	}
+	used := make(map[uint16]bool)
+	for _, c := range snap.Containers {
+		if !usage.holdsIn(snap, c) {
+			continue
+		}
+		if c.Source != "reservation" {
+			resp.Containers++
+		}
+		for _, p := range c.Ports {
+			if p.PublicPort != 0 {
+				used[p.PublicPort] = true
+			}
+		}
+	}
+	resp.Ports = len(used)
+	for _, fr := range usage.index(snap).FreeRanges(pr.Start, pr.End) {
+		resp.Free += fr.End - fr.Start + 1
+	}
+	return resp
+}
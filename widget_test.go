@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+)
+
+func TestWidget(t *testing.T) {
+	mock := &MockDockerClient{Containers: []types.Container{
+		{ID: "a", Names: []string{"/web"}, State: "running", Ports: []types.Port{
+			{PublicPort: 8080, Type: "tcp", IP: "0.0.0.0"},
+			{PublicPort: 8080, Type: "tcp", IP: "::"},
+		}},
+		{ID: "b", Names: []string{"/proxy"}, State: "running", Ports: []types.Port{{PublicPort: 8080, Type: "tcp"}}},
+		{ID: "c", Names: []string{"/db"}, State: "running", Ports: []types.Port{{PublicPort: 8081, Type: "tcp"}}},
+		{ID: "d", Names: []string{"/old"}, State: "exited", Ports: []types.Port{{PublicPort: 8082, Type: "tcp"}}},
+	}}
+	router := SetupRouter(&Server{client: mock})
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/widget?start=8080&end=8089", nil))
+	var resp WidgetResponse
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	want := WidgetResponse{Ports: 2, Containers: 3, Conflicts: 1, Free: 8, Range: "8080-8089"}
+	if resp != want {
+		t.Errorf("Expected %+v, got %+v", want, resp)
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/widget?start=0", nil))
+	if rr.Code != 400 {
+		t.Errorf("Expected 400 for an invalid range, got %d", rr.Code)
+	}
+}
+
+func TestWidgetCORS(t *testing.T) {
+	router := SetupRouter(&Server{client: &MockDockerClient{}, widgetOrigins: []string{"http://homepage.lan"}})
+
+	req := httptest.NewRequest("OPTIONS", "/api/widget", nil)
+	req.Header.Set("Origin", "http://homepage.lan")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != 204 || rr.Header().Get("Access-Control-Allow-Origin") != "http://homepage.lan" {
+		t.Errorf("Expected preflight to allow the origin, got %d %v", rr.Code, rr.Header())
+	}
+
+	req = httptest.NewRequest("GET", "/api/widget", nil)
+	req.Header.Set("Origin", "http://evil.example")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("Expected no CORS header for an unlisted origin, got %q", rr.Header().Get("Access-Control-Allow-Origin"))
+	}
+
+	router = SetupRouter(&Server{client: &MockDockerClient{}})
+	req = httptest.NewRequest("GET", "/api/widget", nil)
+	req.Header.Set("Origin", "http://anywhere")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Header().Get("Access-Control-Allow-Origin") != "*" {
+		t.Errorf("Expected any origin by default, got %q", rr.Header().Get("Access-Control-Allow-Origin"))
+	}
+}
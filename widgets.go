@@ -0,0 +1,216 @@
+package main
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// widgetPageTemplate is a bare page sized for an iframe tile: no script, a
+// transparent background and the dashboard's light or dark scheme, refreshed
+// by the browser itself
+var widgetPageTemplate = template.Must(template.New("widget").Parse(`<!DOCTYPE html>
+<html lang="{{.Lang}}">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+{{if .Refresh}}<meta http-equiv="refresh" content="{{.Refresh}}">
+{{end}}<title>{{.Title}}</title>
+<style>
+  :root { color-scheme: light dark; --fg: #1f2328; --muted: #656d76; --ok: #1a7f37; --warn: #9a6700; --bad: #cf222e; }
+  @media (prefers-color-scheme: dark) { :root { --fg: #e6edf3; --muted: #8d96a0; --ok: #3fb950; --warn: #d29922; --bad: #f85149; } }
+  body.light { color-scheme: light; --fg: #1f2328; --muted: #656d76; --ok: #1a7f37; --warn: #9a6700; --bad: #cf222e; }
+  body.dark { color-scheme: dark; --fg: #e6edf3; --muted: #8d96a0; --ok: #3fb950; --warn: #d29922; --bad: #f85149; }
+  html, body { margin: 0; background: transparent; }
+  body { font: 14px/1.3 system-ui, -apple-system, "Segoe UI", sans-serif; color: var(--fg); padding: 6px 8px; }
+  .tiles { display: flex; flex-wrap: wrap; gap: 4px 18px; }
+  .tile b { display: block; font-size: 1.6em; font-variant-numeric: tabular-nums; }
+  .tile span, .note { color: var(--muted); font-size: .85em; }
+  .note { margin-top: 4px; }
+  .ok { color: var(--ok); }
+  .warn { color: var(--warn); }
+  .bad { color: var(--bad); }
+</style>
+</head>
+<body class="{{.Theme}}">
+{{if .Error}}<div class="bad">{{.Error}}</div>
+{{else}}<div class="tiles">{{range .Tiles}}<div class="tile"><b{{with .Class}} class="{{.}}"{{end}}>{{.Value}}</b><span>{{.Label}}</span></div>{{end}}</div>
+{{end}}{{with .Note}}<div class="note">{{.}}</div>
+{{end}}</body>
+</html>
+`))
+
+// widgetPage is what a widget shows: tiles, or an error in their place
+type widgetPage struct {
+	Lang    string
+	Title   string
+	Theme   string
+	Refresh int
+	Tiles   []widgetTile
+	Error   string
+	Note    string
+}
+
+type widgetTile struct {
+	Value string
+	Label string
+	// Class is ok, warn or bad to color the value
+	Class string
+}
+
+// defaultWidgetRefresh is how often a widget reloads, in seconds, unless
+// ?refresh= says otherwise
+const defaultWidgetRefresh = 30
+
+// widgetPageFromRequest reads ?refresh=, in seconds with 0 turning it off
+// and 5 the shortest, and ?theme= (light, dark or auto to follow the
+// browser). It returns a message for invalid parameters.
+func widgetPageFromRequest(w http.ResponseWriter, r *http.Request) (widgetPage, string) {
+	page := widgetPage{Lang: w.Header().Get("Content-Language"), Title: "quaycheck", Refresh: defaultWidgetRefresh}
+	if v := r.URL.Query().Get("refresh"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || (n > 0 && n < 5) {
+			return page, "Invalid refresh parameter"
+		}
+		page.Refresh = n
+	}
+	switch theme := r.URL.Query().Get("theme"); theme {
+	case "", "auto":
+	case "light", "dark":
+		page.Theme = theme
+	default:
+		return page, "Invalid theme parameter"
+	}
+	return page, ""
+}
+
+// widgetFrameAncestors lets the dashboards QUAYCHECK_WIDGET_ORIGINS lists
+// embed widgets, or any page when it is unset, as with /api/widget
+func (s *Server) widgetFrameAncestors() string {
+	if len(s.widgetOrigins) == 0 || slices.Contains(s.widgetOrigins, "*") {
+		return "*"
+	}
+	return "'self' " + strings.Join(s.widgetOrigins, " ")
+}
+
+// renderWidget writes page with status. Widgets keep refreshing on errors,
+// so a tile that lost Docker comes back on its own.
+func (s *Server) renderWidget(w http.ResponseWriter, status int, page widgetPage) {
+	h := w.Header()
+	h.Set("Content-Type", "text/html; charset=utf-8")
+	h.Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; base-uri 'none'; form-action 'none'; frame-ancestors "+s.widgetFrameAncestors())
+	h.Set("X-Content-Type-Options", "nosniff")
+	// Keeps a ?token= out of the Referer of anything the page loads
+	h.Set("Referrer-Policy", "no-referrer")
+	if status != http.StatusOK {
+		h.Set("Cache-Control", "no-store")
+	}
+	w.WriteHeader(status)
+	if err := widgetPageTemplate.Execute(w, page); err != nil {
+		log.Printf("Rendering widget failed: %v", err)
+	}
+}
+
+// widgetToken lets a widget URL carry its token as ?token=, since an iframe
+// can't send an Authorization header. Only widget routes accept it.
+func widgetToken(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if token := r.URL.Query().Get("token"); token != "" && r.Header.Get("Authorization") == "" {
+			r = r.Clone(r.Context())
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		next(w, r)
+	}
+}
+
+// handlePortWidget shows whether one port is free and, if not, what holds it
+func (s *Server) handlePortWidget(w http.ResponseWriter, r *http.Request) {
+	page, errMsg := widgetPageFromRequest(w, r)
+	port, err := strconv.Atoi(r.PathValue("port"))
+	if errMsg == "" && (err != nil || port < 1 || port > maxPort) {
+		errMsg = "Invalid port parameter"
+	}
+	usage, err := s.usageFromRequest(r)
+	if errMsg == "" && err != nil {
+		errMsg = err.Error()
+	}
+	if errMsg != "" {
+		page.Refresh, page.Error = 0, localize(w, errMsg)
+		s.renderWidget(w, http.StatusBadRequest, page)
+		return
+	}
+	page.Title = localize(w, "Port %d", port)
+
+	snap, err := s.loadSnapshot(r.Context())
+	if err != nil {
+		status, _, msg := classifyDockerError(err)
+		page.Error = localize(w, msg)
+		s.renderWidget(w, status, page)
+		return
+	}
+
+	tile := widgetTile{Value: strconv.Itoa(port), Label: localize(w, "free"), Class: "ok"}
+	if usage.index(snap).Used(port) {
+		tile.Label, tile.Class = localize(w, "in use"), "bad"
+		if holder, ok := usage.holder(snap, port); ok {
+			tile.Label = localize(w, "in use by %s", containerName(holder))
+			if hint := availabilityHint(holder); hint != "" {
+				tile.Class, page.Note = "warn", localize(w, hint)
+			}
+		}
+	}
+	page.Tiles = []widgetTile{tile}
+	if snap.Stale {
+		page.Note = localize(w, "Data may be out of date")
+	}
+
+	s.writeSnapshotHeaders(w, snap)
+	s.renderWidget(w, http.StatusOK, page)
+}
+
+// handleSummaryWidget shows the /api/widget summary as tiles
+func (s *Server) handleSummaryWidget(w http.ResponseWriter, r *http.Request) {
+	page, errMsg := widgetPageFromRequest(w, r)
+	pr, rangeMsg := s.rangeFromRequest(r)
+	if errMsg == "" {
+		errMsg = rangeMsg
+	}
+	usage, err := s.usageFromRequest(r)
+	if errMsg == "" && err != nil {
+		errMsg = err.Error()
+	}
+	if errMsg != "" {
+		page.Refresh, page.Error = 0, localize(w, errMsg)
+		s.renderWidget(w, http.StatusBadRequest, page)
+		return
+	}
+
+	snap, err := s.loadSnapshot(r.Context())
+	if err != nil {
+		status, _, msg := classifyDockerError(err)
+		page.Error = localize(w, msg)
+		s.renderWidget(w, status, page)
+		return
+	}
+
+	summary := widgetSummary(snap, usage, pr)
+	conflicts := widgetTile{Value: strconv.Itoa(summary.Conflicts), Label: localize(w, "conflicts")}
+	if summary.Conflicts > 0 {
+		conflicts.Class = "bad"
+	}
+	page.Tiles = []widgetTile{
+		{Value: strconv.Itoa(summary.Ports), Label: localize(w, "ports in use")},
+		{Value: strconv.Itoa(summary.Containers), Label: localize(w, "containers")},
+		conflicts,
+		{Value: strconv.Itoa(summary.Free), Label: localize(w, "free in %s", summary.Range)},
+	}
+	if snap.Stale {
+		page.Note = localize(w, "Data may be out of date")
+	}
+
+	s.writeSnapshotHeaders(w, snap)
+	s.renderWidget(w, http.StatusOK, page)
+}
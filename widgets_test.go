@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+)
+
+func TestPortWidget(t *testing.T) {
+	mock := &MockDockerClient{Containers: []types.Container{
+		{ID: "a", Names: []string{"/web"}, State: "running", Ports: []types.Port{{PublicPort: 8080, Type: "tcp"}}},
+		{ID: "b", Names: []string{"/flaky"}, State: "restarting", Ports: []types.Port{{PublicPort: 8081, Type: "tcp"}}},
+	}}
+	router := SetupRouter(&Server{client: mock})
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/widget/port/8080", nil))
+	body := rr.Body.String()
+	if rr.Code != 200 || !strings.Contains(body, "in use by web") || !strings.Contains(body, `class="bad"`) {
+		t.Errorf("Expected port 8080 shown in use by web, got %d %s", rr.Code, body)
+	}
+	if !strings.Contains(body, `<meta http-equiv="refresh" content="30">`) {
+		t.Errorf("Expected a 30s refresh by default, got %s", body)
+	}
+	if csp := rr.Header().Get("Content-Security-Policy"); !strings.Contains(csp, "frame-ancestors *") || !strings.Contains(csp, "default-src 'none'") {
+		t.Errorf("Expected a CSP allowing any dashboard to frame the widget, got %q", csp)
+	}
+	if rr.Header().Get("X-Frame-Options") != "" {
+		t.Error("Expected widgets to be frameable")
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/widget/port/8081?refresh=0&theme=dark", nil))
+	body = rr.Body.String()
+	if !strings.Contains(body, `class="warn"`) || !strings.Contains(body, "container is restarting") {
+		t.Errorf("Expected a restarting holder flagged as may free soon, got %s", body)
+	}
+	if strings.Contains(body, "http-equiv") || !strings.Contains(body, `<body class="dark">`) {
+		t.Errorf("Expected no refresh and the dark theme, got %s", body)
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/widget/port/9000?lang=fr", nil))
+	if body = rr.Body.String(); !strings.Contains(body, "libre") || !strings.Contains(body, `<html lang="fr">`) {
+		t.Errorf("Expected a French free port, got %s", body)
+	}
+
+	for _, path := range []string{"/widget/port/0", "/widget/port/http", "/widget/port/80?refresh=2", "/widget/port/80?theme=pink"} {
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+		if rr.Code != 400 || !strings.Contains(rr.Header().Get("Content-Type"), "text/html") {
+			t.Errorf("%s: expected an HTML 400, got %d %s", path, rr.Code, rr.Header().Get("Content-Type"))
+		}
+	}
+}
+
+func TestSummaryWidget(t *testing.T) {
+	mock := &MockDockerClient{Containers: []types.Container{
+		{ID: "a", Names: []string{"/web"}, State: "running", Ports: []types.Port{{PublicPort: 8080, Type: "tcp"}}},
+		{ID: "b", Names: []string{"/proxy"}, State: "running", Ports: []types.Port{{PublicPort: 8080, Type: "tcp"}}},
+	}}
+	router := SetupRouter(&Server{client: mock, widgetOrigins: []string{"https://grafana.lan"}})
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/widget/summary?start=8080&end=8089&refresh=60", nil))
+	body := rr.Body.String()
+	for _, want := range []string{
+		"<b>1</b><span>ports in use</span>",
+		"<b>2</b><span>containers</span>",
+		`<b class="bad">1</b><span>conflicts</span>`,
+		"<b>9</b><span>free in 8080-8089</span>",
+		`content="60"`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected %q in the summary, got %s", want, body)
+		}
+	}
+	if csp := rr.Header().Get("Content-Security-Policy"); !strings.HasSuffix(csp, "frame-ancestors 'self' https://grafana.lan") {
+		t.Errorf("Expected framing limited to QUAYCHECK_WIDGET_ORIGINS, got %q", csp)
+	}
+
+	mock.Err = errors.New("dial unix /var/run/docker.sock: connect: connection refused")
+	router = SetupRouter(&Server{client: mock})
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/widget/summary", nil))
+	body = rr.Body.String()
+	if rr.Code != 503 || !strings.Contains(body, "Cannot connect to Docker") || !strings.Contains(body, "http-equiv") {
+		t.Errorf("Expected the Docker error in a widget that keeps refreshing, got %d %s", rr.Code, body)
+	}
+}
+
+func TestWidgetToken(t *testing.T) {
+	server := &Server{client: &MockDockerClient{}, tokens: []APIToken{{Name: "dash", Secret: "s3cret", Scopes: []Scope{ScopePortsRead}}}}
+	router := SetupRouter(server)
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/widget/summary", nil))
+	if rr.Code != 401 {
+		t.Errorf("Expected 401 without a token, got %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/widget/summary?token=s3cret", nil))
+	if rr.Code != 200 {
+		t.Errorf("Expected ?token= to authorize a widget, got %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/widget?token=s3cret", nil))
+	if rr.Code != 401 {
+		t.Errorf("Expected ?token= to be refused outside widgets, got %d", rr.Code)
+	}
+}